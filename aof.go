@@ -0,0 +1,847 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"maps"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// The append-only file logs every command that has changed the keyspace in RESP, and the keyspace is
+// rebuilt on start by replaying it through the command handlers. Commands are collected while they run
+// and written when the command running them ends, so commands of EXEC and of a script are written at once
+// wrapped in MULTI and EXEC and are replayed atomically. Scripts are logged as commands they have run.
+// A write starts with #TS:<unix ms> annotation when time has changed since the previous one, and replayed
+// commands see that time as now, so commands depending on time like XADD * or THROTTLE do the same on replay
+const (
+	fsyncAlways = iota
+	fsyncEverysec
+	fsyncNo
+)
+
+var fsyncPolicyNames = []string{"always", "everysec", "no"}
+
+// AOF is split into files listed in a manifest: a base file recreating the keyspace as it was when the AOF
+// was rewritten, and incremental files with commands run since then, the last one is written. A rewrite
+// switches writes to a new incremental file and writes a new base from the keyspace in the background,
+// then the manifest is replaced with one listing just the new base and the new incremental file. Until
+// then the old manifest, which lists the new incremental file too, holds all data
+const (
+	aofBaseType = 'b'
+	aofIncrType = 'i'
+
+	// failed automatic rewrite is tried again after this time
+	aofRewriteRetryDelay = time.Minute
+)
+
+type aofFile struct {
+	name string
+	seq  int64
+	typ  byte
+}
+
+type aofManifest struct {
+	base  *aofFile
+	incrs []*aofFile
+}
+
+var aof struct {
+	// file and err are guarded by mu too, as the fsync goroutine uses them. Everything is changed with kvs.mu held
+	mu   sync.Mutex
+	file *os.File
+	// error of the last write or fsync, write commands are refused until a write succeeds
+	err error
+	// fsync is due for everysec policy
+	syncDue bool
+	// size of the written file
+	size int64
+
+	// commands logged by the running command, and logged commands not written yet
+	pending [][]byte
+	buf     []byte
+	lastTS  int64
+
+	manifest aofManifest
+	// the written incremental file. It's not in the manifest while manifestPending is set, which happens
+	// when append only mode is turned on at runtime and the first rewrite isn't done yet
+	current         *aofFile
+	manifestPending bool
+	// size of all files, and their size after the last rewrite or load. Automatic rewrite starts when
+	// the AOF has grown by auto-aof-rewrite-percentage since then
+	totalSize       int64
+	rewriteBaseSize int64
+	rewriting       bool
+	// BGREWRITEAOF waits for BGSAVE, only one background snapshot runs at a time
+	rewriteScheduled bool
+	lastRewriteFail  time.Time
+	// changed when the AOF is closed, so a rewrite started before is abandoned
+	epoch int
+}
+
+// replayTime is unix ms of the command being replayed while the AOF is loaded, 0 otherwise
+var replayTime atomic.Int64
+
+// commandTime is unix ms taken when the running command has started, 0 between commands. The command sees
+// the same time all along and is logged with it, so it does the same on replay
+var commandTime atomic.Int64
+
+// clockNow is the time commands see
+func clockNow() time.Time {
+	if ms := replayTime.Load(); ms != 0 {
+		return time.UnixMilli(ms)
+	}
+	if ms := commandTime.Load(); ms != 0 {
+		return time.UnixMilli(ms)
+	}
+
+	return time.Now()
+}
+
+func aofDir() string {
+	return filepath.Join(config.dir, config.appendDirname)
+}
+
+func aofFilePath(name string) string {
+	return filepath.Join(aofDir(), name)
+}
+
+func aofManifestPath() string {
+	return aofFilePath(config.appendFilename + ".manifest")
+}
+
+func newAOFFile(seq int64, typ byte) *aofFile {
+	kind := "incr"
+	if typ == aofBaseType {
+		kind = "base"
+	}
+
+	return &aofFile{name: fmt.Sprintf("%s.%d.%s.aof", config.appendFilename, seq, kind), seq: seq, typ: typ}
+}
+
+func parseFsyncPolicy(s string) (int, bool) {
+	for policy, name := range fsyncPolicyNames {
+		if strings.EqualFold(s, name) {
+			return policy, true
+		}
+	}
+
+	return 0, false
+}
+
+// files returns base and incremental files in the order they are replayed
+func (m aofManifest) files() []*aofFile {
+	if m.base == nil {
+		return m.incrs
+	}
+
+	return append([]*aofFile{m.base}, m.incrs...)
+}
+
+// manifest has a line "file <name> seq <seq> type <b|i>" per file
+func (m aofManifest) encode() []byte {
+	var b []byte
+	for _, f := range m.files() {
+		b = fmt.Appendf(b, "file %s seq %d type %c\n", f.name, f.seq, f.typ)
+	}
+
+	return b
+}
+
+func readAOFManifest() (aofManifest, error) {
+	var m aofManifest
+
+	data, err := os.ReadFile(aofManifestPath())
+	if err != nil {
+		return m, err
+	}
+
+	for i, line := range strings.Split(string(data), "\n") {
+		if strings.TrimSpace(line) == "" {
+			continue
+		}
+
+		fields := strings.Fields(line)
+		if len(fields) != 6 || fields[0] != "file" || fields[2] != "seq" || fields[4] != "type" || len(fields[5]) != 1 ||
+			strings.ContainsAny(fields[1], `/\`) {
+			return m, fmt.Errorf("bad line %d of the AOF manifest", i+1)
+		}
+
+		seq, err := strconv.ParseInt(fields[3], 10, 64)
+		f := &aofFile{name: fields[1], seq: seq, typ: fields[5][0]}
+		switch {
+		case err != nil || seq <= 0:
+			return m, fmt.Errorf("bad line %d of the AOF manifest", i+1)
+		case f.typ == aofBaseType && m.base == nil:
+			m.base = f
+		case f.typ == aofIncrType && (len(m.incrs) == 0 || m.incrs[len(m.incrs)-1].seq < seq):
+			m.incrs = append(m.incrs, f)
+		default:
+			return m, fmt.Errorf("bad line %d of the AOF manifest", i+1)
+		}
+	}
+
+	return m, nil
+}
+
+// writeFileSync creates file with data written by write and syncs it to disk
+func writeFileSync(path string, write func(w *bufio.Writer) error) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+
+	w := bufio.NewWriter(f)
+	if err := write(w); err != nil {
+		f.Close()
+		return err
+	}
+	if err := w.Flush(); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return err
+	}
+
+	return f.Close()
+}
+
+// syncDir makes renames and removals of files in dir durable
+func syncDir(dir string) error {
+	d, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	defer d.Close()
+
+	return d.Sync()
+}
+
+// writeAOFManifest replaces the manifest atomically
+func writeAOFManifest(m aofManifest) error {
+	path := aofManifestPath()
+	tmp := path + ".tmp"
+	err := writeFileSync(tmp, func(w *bufio.Writer) error {
+		_, err := w.Write(m.encode())
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		return err
+	}
+
+	return syncDir(aofDir())
+}
+
+// propagate logs command that has changed the keyspace. Must be called with kvs.mu held
+func propagate(name string, args []*KvsValue) {
+	if aof.file == nil || kvs.loading {
+		return
+	}
+
+	cmd := aggregateResponse(ArrSymbol, len(args)+1, nil)
+	cmd = append(cmd, bulkStrResponse([]byte(name))...)
+	for _, arg := range args {
+		cmd = append(cmd, kvsValueToResponse(arg)...)
+	}
+
+	aof.pending = append(aof.pending, cmd)
+}
+
+// flushAOF writes commands logged by the command that has just ended. With appendfsync always the file
+// is synced before the command replies, and the error is returned to fail the command if what it has
+// logged can't be written. Must be called with kvs.mu held
+func flushAOF() error {
+	if aof.file == nil {
+		aof.pending = nil
+		return nil
+	}
+
+	logged := len(aof.pending) > 0
+	if logged {
+		if now := nowMs(); now != aof.lastTS {
+			aof.buf = append(aof.buf, "#TS:"+strconv.FormatInt(now, 10)+CRLF...)
+			aof.lastTS = now
+		}
+
+		multi := len(aof.pending) > 1
+		if multi {
+			aof.buf = append(aof.buf, bulkStrArrayResponse([]byte(MultiCmd))...)
+		}
+		for _, cmd := range aof.pending {
+			aof.buf = append(aof.buf, cmd...)
+		}
+		if multi {
+			aof.buf = append(aof.buf, bulkStrArrayResponse([]byte(ExecCmd))...)
+		}
+		aof.pending = aof.pending[:0]
+	}
+
+	if len(aof.buf) == 0 {
+		return nil
+	}
+
+	aof.mu.Lock()
+	defer aof.mu.Unlock()
+
+	n, err := aof.file.Write(aof.buf)
+	if err == nil && config.appendFsync == fsyncAlways {
+		err = aof.file.Sync()
+	}
+	if err != nil {
+		// partially written command would break the file, so it's cut off and written again later
+		if n > 0 {
+			if terr := aof.file.Truncate(aof.size); terr != nil {
+				n = 0
+				log.Println("Error truncating the AOF after a failed write: ", terr)
+			}
+		}
+		if aof.err == nil {
+			log.Println("Error writing the AOF: ", err)
+		}
+		aof.err = err
+		if logged && config.appendFsync == fsyncAlways {
+			return aofMisconfErr(err.Error())
+		}
+		return nil
+	}
+
+	aof.size += int64(n)
+	aof.totalSize += int64(n)
+	aof.buf = aof.buf[:0]
+	aof.err = nil
+	aof.syncDue = config.appendFsync == fsyncEverysec
+
+	return nil
+}
+
+// aofWriteErr is returned for write commands while the AOF can't be written
+func aofWriteErr() error {
+	aof.mu.Lock()
+	defer aof.mu.Unlock()
+
+	if aof.err == nil {
+		return nil
+	}
+
+	return aofMisconfErr(aof.err.Error())
+}
+
+// openAOF makes f the written file. Must be called with kvs.mu held
+func openAOF(f *aofFile) error {
+	file, err := os.OpenFile(aofFilePath(f.name), os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0o644)
+	if err != nil {
+		return err
+	}
+
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return err
+	}
+
+	aof.mu.Lock()
+	prev := aof.file
+	aof.file, aof.size, aof.err, aof.syncDue = file, info.Size(), nil, false
+	aof.mu.Unlock()
+	aof.current, aof.buf, aof.pending, aof.lastTS = f, nil, nil, 0
+
+	if prev != nil {
+		if err := prev.Sync(); err != nil {
+			log.Println("Error syncing the AOF: ", err)
+		}
+		prev.Close()
+	}
+
+	return nil
+}
+
+// closeAOF writes what's left and closes the AOF. Must be called with kvs.mu held
+func closeAOF() {
+	if aof.file == nil {
+		return
+	}
+	flushAOF()
+
+	aof.mu.Lock()
+	if err := aof.file.Sync(); err != nil {
+		log.Println("Error syncing the AOF: ", err)
+	}
+	aof.file.Close()
+	aof.file, aof.err, aof.buf, aof.syncDue = nil, nil, nil, false
+	aof.mu.Unlock()
+
+	if aof.manifestPending {
+		os.Remove(aofFilePath(aof.current.name))
+	}
+	aof.current, aof.manifestPending, aof.rewriteScheduled = nil, false, false
+	aof.epoch++
+}
+
+// aofCron syncs the AOF every second when appendfsync is everysec and starts automatic rewrites. It syncs
+// without the lock, so writers don't wait for the disk
+func aofCron() {
+	for range time.Tick(time.Second) {
+		aof.mu.Lock()
+		f, due := aof.file, aof.syncDue
+		aof.syncDue = false
+		aof.mu.Unlock()
+
+		if due {
+			if err := f.Sync(); err != nil && !errors.Is(err, os.ErrClosed) {
+				log.Println("Error syncing the AOF: ", err)
+				aof.mu.Lock()
+				if aof.file == f {
+					aof.err = err
+				}
+				aof.mu.Unlock()
+			}
+		}
+
+		kvs.mu.Lock()
+		rewriteAOFIfNeeded()
+		kvs.mu.Unlock()
+	}
+}
+
+// rewriteAOFIfNeeded starts scheduled rewrite, rewrite when the AOF has grown enough or when the first rewrite
+// after turning append only mode on has failed. Must be called with kvs.mu held
+func rewriteAOFIfNeeded() {
+	if aof.rewriting || kvs.bgSnapshot != nil {
+		return
+	}
+
+	switch {
+	case aof.rewriteScheduled:
+	case aof.file == nil || time.Since(aof.lastRewriteFail) < aofRewriteRetryDelay:
+		return
+	case aof.manifestPending:
+	default:
+		if config.autoAOFRewritePercentage == 0 || aof.totalSize < config.autoAOFRewriteMinSize {
+			return
+		}
+		base := max(aof.rewriteBaseSize, 1)
+		growth := (aof.totalSize - base) * 100 / base
+		if growth < config.autoAOFRewritePercentage {
+			return
+		}
+		log.Printf("Starting automatic rewriting of AOF on %d%% growth", growth)
+	}
+
+	if err := startAOFRewrite(); err != nil {
+		aof.lastRewriteFail = time.Now()
+		log.Println("Error starting AOF rewrite: ", err)
+	}
+}
+
+// loadAOF replays the AOF if append only mode is on and opens it for appending. The last file may end in
+// the middle of a command or of a transaction, then it's cut to the last complete one if aof-load-truncated
+// is yes. AOF of older versions, a single file in dir, becomes the base file
+func loadAOF() error {
+	if !config.appendOnly {
+		return nil
+	}
+
+	if err := lockKvs(); err != nil {
+		return err
+	}
+	defer kvs.mu.Unlock()
+
+	m, err := readAOFManifest()
+	if errors.Is(err, os.ErrNotExist) {
+		m, err = createAOFManifest()
+	}
+	if err != nil {
+		return err
+	}
+
+	kvs.mu.Unlock()
+	size, err := replayAOFFiles(m.files())
+	kvs.mu.Lock()
+	if err != nil {
+		return err
+	}
+
+	aof.manifest, aof.manifestPending = m, false
+	aof.totalSize, aof.rewriteBaseSize = size, size
+
+	if len(m.incrs) > 0 {
+		return openAOF(m.incrs[len(m.incrs)-1])
+	}
+
+	f := newAOFFile(1, aofIncrType)
+	if err := openAOF(f); err != nil {
+		return err
+	}
+	m.incrs = []*aofFile{f}
+	aof.manifest = m
+
+	return writeAOFManifest(m)
+}
+
+// createAOFManifest creates AOF directory and the manifest, listing AOF of older versions as the base
+func createAOFManifest() (aofManifest, error) {
+	var m aofManifest
+	if err := os.MkdirAll(aofDir(), 0o755); err != nil {
+		return m, err
+	}
+
+	old := filepath.Join(config.dir, config.appendFilename)
+	if _, err := os.Stat(old); err != nil {
+		return m, nil
+	}
+
+	// the old file is removed only once the manifest lists its link, so a crash leaves one of them
+	m.base = newAOFFile(1, aofBaseType)
+	path := aofFilePath(m.base.name)
+	if err := os.Remove(path); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return m, err
+	}
+	if err := os.Link(old, path); err != nil {
+		return m, err
+	}
+	if err := writeAOFManifest(m); err != nil {
+		return m, err
+	}
+	log.Printf("AOF %s is moved to %s", old, path)
+
+	return m, os.Remove(old)
+}
+
+// replayAOFFiles replays files and returns their total size
+func replayAOFFiles(files []*aofFile) (int64, error) {
+	var size int64
+
+	for i, af := range files {
+		path := aofFilePath(af.name)
+		f, err := os.Open(path)
+		if err != nil {
+			return 0, err
+		}
+		good, truncated, err := replayAOF(f)
+		f.Close()
+		if err != nil {
+			return 0, fmt.Errorf("%s: %w", af.name, err)
+		}
+
+		if truncated {
+			if i < len(files)-1 || !config.aofLoadTruncated {
+				return 0, fmt.Errorf("unexpected end of %s at offset %d, only the last file can be cut to the last "+
+					"complete command with aof-load-truncated", af.name, good)
+			}
+			log.Printf("AOF %s is truncated, cutting it to the last complete command at offset %d", af.name, good)
+			if err := os.Truncate(path, good); err != nil {
+				return 0, err
+			}
+		}
+
+		info, err := os.Stat(path)
+		if err != nil {
+			return 0, err
+		}
+		size += info.Size()
+	}
+
+	return size, nil
+}
+
+// enableAOF turns append only mode on at runtime. Written file isn't listed in the manifest until the first
+// rewrite is done, as it has only commands run since then. Must be called with kvs.mu held
+func enableAOF() error {
+	m, err := readAOFManifest()
+	if errors.Is(err, os.ErrNotExist) {
+		err = os.MkdirAll(aofDir(), 0o755)
+	}
+	if err != nil {
+		return err
+	}
+
+	aof.manifest, aof.manifestPending = m, true
+	if aof.rewriting || kvs.bgSnapshot != nil {
+		// rewrite started with append only mode off misses commands run before the file is opened
+		aof.epoch++
+		aof.rewriteScheduled = true
+		if err := switchAOFIncr(); err != nil {
+			aof.manifestPending, aof.rewriteScheduled = false, false
+			return err
+		}
+		return nil
+	}
+
+	if err := startAOFRewrite(); err != nil {
+		aof.manifestPending = false
+		return err
+	}
+
+	return nil
+}
+
+// startAOFRewrite switches writes to a new incremental file and writes new base from the keyspace in
+// the background. With append only mode off only the base is written. Must be called with kvs.mu held
+func startAOFRewrite() error {
+	if aof.rewriting {
+		return ErrAOFRewriteInProgress
+	}
+	if kvs.bgSnapshot != nil {
+		return ErrBgsaveInProgress
+	}
+
+	if aof.file == nil && !aof.manifestPending {
+		m, err := readAOFManifest()
+		if errors.Is(err, os.ErrNotExist) {
+			err = os.MkdirAll(aofDir(), 0o755)
+		}
+		if err != nil {
+			return err
+		}
+		aof.manifest = m
+	} else if err := switchAOFIncr(); err != nil {
+		return err
+	}
+
+	// keys go to the base as they are now, with time of the start
+	head := []byte("#TS:" + strconv.FormatInt(nowMs(), 10) + CRLF)
+	cur := beginSnapshotCursor(appendRestoreCommand)
+
+	// indexes are created once the keys are there, so documents are indexed once
+	var tail []byte
+	for _, name := range slices.Sorted(maps.Keys(kvs.searchIndexes)) {
+		tail = append(tail, bulkStrArrayResponse(kvs.searchIndexes[name].createArgs()...)...)
+	}
+
+	aof.rewriting, aof.rewriteScheduled = true, false
+	tmp := aofFilePath(fmt.Sprintf("temp-rewriteaof-%d.aof", os.Getpid()))
+	go rewriteAOF(tmp, cur, head, tail, aof.epoch)
+
+	return nil
+}
+
+// switchAOFIncr starts a new incremental file and lists it in the manifest. Must be called with kvs.mu held
+func switchAOFIncr() error {
+	// commands failed to be written are in the snapshot already
+	aof.buf = nil
+
+	var seq int64
+	if n := len(aof.manifest.incrs); n > 0 {
+		seq = aof.manifest.incrs[n-1].seq
+	}
+	if aof.current != nil {
+		seq = max(seq, aof.current.seq)
+	}
+	f := newAOFFile(seq+1, aofIncrType)
+
+	if aof.manifestPending {
+		prev := aof.current
+		if err := openAOF(f); err != nil {
+			return err
+		}
+		if prev != nil {
+			os.Remove(aofFilePath(prev.name))
+		}
+		return nil
+	}
+
+	m := aofManifest{base: aof.manifest.base, incrs: append(slices.Clone(aof.manifest.incrs), f)}
+	if err := writeFileSync(aofFilePath(f.name), func(*bufio.Writer) error { return nil }); err != nil {
+		return err
+	}
+	if err := writeAOFManifest(m); err != nil {
+		os.Remove(aofFilePath(f.name))
+		return err
+	}
+	aof.manifest = m
+
+	return openAOF(f)
+}
+
+// appendRestoreCommand appends RESTORE command recreating key in the base file
+func appendRestoreCommand(b []byte, key string, val *KvsValue) ([]byte, error) {
+	payload, err := dumpValue(val)
+	if err != nil {
+		return b, fmt.Errorf("key %q can't be serialized", key)
+	}
+
+	return append(b, bulkStrArrayResponse([]byte("RESTORE"), []byte(key), []byte(strconv.FormatInt(val.expireAt, 10)),
+		payload, []byte("ABSTTL"))...), nil
+}
+
+// rewriteAOF writes keyspace snapshot between head and tail to a new base file and replaces the manifest with
+// one listing the base and the incremental file started with the rewrite
+func rewriteAOF(tmp string, cur *snapshotCursor, head, tail []byte, epoch int) {
+	err := writeFileSync(tmp, func(w *bufio.Writer) error {
+		if _, err := w.Write(head); err != nil {
+			return err
+		}
+		if err := cur.writeTo(w); err != nil {
+			return err
+		}
+		_, err := w.Write(tail)
+		return err
+	})
+
+	kvs.mu.Lock()
+	defer kvs.mu.Unlock()
+
+	cur.end()
+	aof.rewriting = false
+	if err == nil && epoch != aof.epoch {
+		err = errors.New("append only mode was turned off")
+	}
+	if err == nil {
+		err = installAOFBase(tmp)
+	}
+	if err != nil {
+		os.Remove(tmp)
+		aof.lastRewriteFail = time.Now()
+		log.Println("Background AOF rewrite failed: ", err)
+		return
+	}
+
+	aof.lastRewriteFail = time.Time{}
+	log.Println("Background AOF rewrite finished successfully")
+}
+
+// installAOFBase makes tmp the base file. Files of the old manifest are removed once the new one is written.
+// Must be called with kvs.mu held
+func installAOFBase(tmp string) error {
+	var seq int64
+	if aof.manifest.base != nil {
+		seq = aof.manifest.base.seq
+	}
+	base := newAOFFile(seq+1, aofBaseType)
+
+	info, err := os.Stat(tmp)
+	if err != nil {
+		return err
+	}
+	if err := os.Rename(tmp, aofFilePath(base.name)); err != nil {
+		return err
+	}
+
+	m := aofManifest{base: base}
+	if aof.current != nil {
+		m.incrs = []*aofFile{aof.current}
+	}
+	if err := writeAOFManifest(m); err != nil {
+		os.Remove(aofFilePath(base.name))
+		return err
+	}
+
+	for _, f := range aof.manifest.files() {
+		if f != aof.current {
+			os.Remove(aofFilePath(f.name))
+		}
+	}
+
+	aof.manifest, aof.manifestPending = m, false
+	aof.totalSize = info.Size() + aof.size
+	aof.rewriteBaseSize = aof.totalSize
+
+	return nil
+}
+
+// BGREWRITEAOF
+func bgrewriteaofHandler(args []*KvsValue) ([]byte, error) {
+	if !aof.rewriting && kvs.bgSnapshot != nil {
+		aof.rewriteScheduled = true
+		return simpleStrResponse("Background append only file rewriting scheduled"), nil
+	}
+
+	if err := startAOFRewrite(); err != nil {
+		if err == ErrAOFRewriteInProgress {
+			return nil, err
+		}
+		return nil, aofRewriteErr(err.Error())
+	}
+
+	return simpleStrResponse("Background append only file rewriting started"), nil
+}
+
+// countingReader counts bytes read from r and remembers whether r is read to the end
+type countingReader struct {
+	r   io.Reader
+	n   int64
+	eof bool
+}
+
+func (cr *countingReader) Read(p []byte) (int, error) {
+	n, err := cr.r.Read(p)
+	cr.n += int64(n)
+	if err == io.EOF {
+		cr.eof = true
+	}
+
+	return n, err
+}
+
+// replayAOF runs commands from r and returns offset after the last complete command or transaction.
+// truncated is true if there is an incomplete one after it
+func replayAOF(r io.Reader) (good int64, truncated bool, err error) {
+	cr := &countingReader{r: r}
+	br := bufio.NewReader(cr)
+	rr := &respReader{reader: br}
+
+	var tx txState
+	kvs.loading = true
+	defer func() {
+		kvs.loading = false
+		replayTime.Store(0)
+	}()
+
+	for {
+		b, err := br.Peek(1)
+		if err == io.EOF {
+			// transaction without EXEC is never applied
+			return good, tx.active, nil
+		}
+		if err != nil {
+			return good, false, err
+		}
+
+		if b[0] == '#' {
+			line, err := br.ReadString('\n')
+			if err != nil {
+				return good, true, nil
+			}
+			if ts, ok := strings.CutPrefix(strings.TrimRight(line, CRLF), "#TS:"); ok {
+				ms, err := strconv.ParseInt(ts, 10, 64)
+				if err != nil {
+					return good, false, fmt.Errorf("bad annotation in the AOF at offset %d", good)
+				}
+				replayTime.Store(ms)
+			}
+		} else {
+			cmd, args, err := rr.readCommand()
+			if err != nil {
+				if cr.eof && br.Buffered() == 0 {
+					return good, true, nil
+				}
+				return good, false, fmt.Errorf("bad format of the AOF at offset %d", good)
+			}
+
+			if name := strings.ToUpper(cmd); name != MultiCmd && name != ExecCmd {
+				if _, ok := lookupCommand(name); !ok {
+					return good, false, fmt.Errorf("unknown command %s in the AOF at offset %d", cmd, good)
+				}
+			}
+			dispatchCommand(&tx, cmd, args)
+		}
+
+		if !tx.active {
+			good = cr.n - int64(br.Buffered())
+		}
+	}
+}
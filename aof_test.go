@@ -0,0 +1,489 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+// testAOF turns append only mode on with AOF in a temporary directory
+func testAOF(t *testing.T) string {
+	t.Helper()
+
+	dir, prev := t.TempDir(), config
+	config.dir, config.appendOnly = dir, true
+	t.Cleanup(func() {
+		restartAOF(t, false)
+		config = prev
+	})
+
+	initStorage()
+	if err := loadAOF(); err != nil {
+		t.Fatal(err)
+	}
+
+	return aofFilePath(aof.current.name)
+}
+
+// restartAOF closes the AOF and, if load is set, loads it into empty keyspace as on start
+func restartAOF(t *testing.T, load bool) error {
+	t.Helper()
+
+	kvs.mu.Lock()
+	closeAOF()
+	kvs.mu.Unlock()
+
+	if !load {
+		return nil
+	}
+	initStorage()
+
+	return loadAOF()
+}
+
+func TestAOFReplay(t *testing.T) {
+	path := testAOF(t)
+	var tx txState
+
+	cmd, args, err := NewRespReader(strings.NewReader("*3\r\n$3\r\nSET\r\n$1\r\nn\r\n:42\r\n")).readCommand()
+	if err != nil {
+		t.Fatal(err)
+	}
+	dispatchCommand(&tx, cmd, args)
+
+	for _, step := range [][]string{
+		{"SET", "k", "v"},
+		{"GET", "k"},
+		{"MULTI"},
+		{"SET", "a", "1"},
+		{"DELETE", "k"},
+		{"EXEC"},
+		{"XADD", "s", "*", "f", "v"},
+		{"EVAL", "redis.call('SET', 'b', 'x'); return redis.call('SET', 'c', 'y')", "0"},
+		{"SET", "missing", "1", "XX"},
+	} {
+		dispatchTest(t, &tx, step[0], step[1:]...)
+	}
+	entries := dispatchTest(t, &tx, "XRANGE", "s", "-", "+")
+
+	if err := restartAOF(t, true); err != nil {
+		t.Fatal(err)
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, cmd := range []string{"GET", "EVAL", "missing"} {
+		if strings.Contains(string(data), cmd) {
+			t.Errorf("%s is logged:\n%s", cmd, data)
+		}
+	}
+
+	for _, step := range []struct {
+		cmd   []string
+		reply string
+	}{
+		{[]string{"GET", "n"}, ":42\r\n"},
+		{[]string{"GET", "k"}, NullResponse},
+		{[]string{"GET", "a"}, "$1\r\n1\r\n"},
+		{[]string{"GET", "b"}, "$1\r\nx\r\n"},
+		{[]string{"GET", "c"}, "$1\r\ny\r\n"},
+		// XADD * generated the same ID as it's replayed with the time it was run
+		{[]string{"XRANGE", "s", "-", "+"}, entries},
+	} {
+		if got := dispatchTest(t, &tx, step.cmd[0], step.cmd[1:]...); got != step.reply {
+			t.Errorf("%v: got %q, expected: %q", step.cmd, got, step.reply)
+		}
+	}
+}
+
+// With appendfsync always the command fails when it can't be written, and writes fail until the AOF
+// can be written again
+func TestAOFFsyncAlwaysError(t *testing.T) {
+	path := testAOF(t)
+	config.appendFsync = fsyncAlways
+	var tx txState
+
+	dispatchTest(t, &tx, "SET", "a", "1")
+
+	readOnly, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer readOnly.Close()
+	kvs.mu.Lock()
+	file := aof.file
+	aof.file = readOnly
+	kvs.mu.Unlock()
+
+	for _, key := range []string{"b", "c"} {
+		if got := dispatchTest(t, &tx, "SET", key, "2"); !strings.HasPrefix(got, "-MISCONF") {
+			t.Errorf("SET %s with failing AOF: %q", key, got)
+		}
+	}
+	if got := dispatchTest(t, &tx, "GET", "a"); got != "$1\r\n1\r\n" {
+		t.Errorf("GET with failing AOF: %q", got)
+	}
+
+	kvs.mu.Lock()
+	aof.file = file
+	if err := flushAOF(); err != nil {
+		t.Error(err)
+	}
+	kvs.mu.Unlock()
+	if got := dispatchTest(t, &tx, "SET", "d", "3"); got != OkResponse {
+		t.Errorf("SET after the AOF is written again: %q", got)
+	}
+
+	if err := restartAOF(t, true); err != nil {
+		t.Fatal(err)
+	}
+	for _, key := range []string{"a", "d"} {
+		if got := dispatchTest(t, &tx, "GET", key); got == NullResponse {
+			t.Errorf("%s isn't replayed", key)
+		}
+	}
+}
+
+// Empty strings written by scripts are logged and replayed
+func TestAOFEmptyString(t *testing.T) {
+	testAOF(t)
+	var tx txState
+
+	dispatchTest(t, &tx, "EVAL", "return redis.call('SET', KEYS[1], '')", "1", "e")
+	if err := restartAOF(t, true); err != nil {
+		t.Fatal(err)
+	}
+	if got := dispatchTest(t, &tx, "GET", "e"); got != "$0\r\n\r\n" {
+		t.Errorf("GET e after replay: %q", got)
+	}
+}
+
+// Blocked read woken up by a write of another client isn't logged, as it hasn't changed anything itself
+func TestAOFBlockedRead(t *testing.T) {
+	path := testAOF(t)
+	var tx txState
+
+	done := make(chan string)
+	go func() {
+		var tx txState
+		done <- dispatchTest(t, &tx, "XREAD", "BLOCK", "0", "STREAMS", "s", "$")
+	}()
+	for blocked := false; !blocked; time.Sleep(time.Millisecond) {
+		kvs.mu.Lock()
+		blocked = len(kvs.keyWaiters["s"]) > 0
+		kvs.mu.Unlock()
+	}
+
+	dispatchTest(t, &tx, "XADD", "s", "1-1", "f", "v")
+	if got := <-done; got != "*1\r\n*2\r\n$1\r\ns\r\n*1\r\n*2\r\n$3\r\n1-1\r\n*2\r\n$1\r\nf\r\n$1\r\nv\r\n" {
+		t.Fatalf("XREAD: %q", got)
+	}
+	dispatchTest(t, &tx, "SET", "k", "v")
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(data), "XADD") || !strings.Contains(string(data), "SET") {
+		t.Fatalf("writes aren't logged: %q", data)
+	}
+	if strings.Contains(string(data), "XREAD") {
+		t.Errorf("blocked XREAD is logged: %q", data)
+	}
+}
+
+func TestAOFTruncated(t *testing.T) {
+	path := testAOF(t)
+	var tx txState
+
+	dispatchTest(t, &tx, "SET", "a", "1")
+	if err := restartAOF(t, false); err != nil {
+		t.Fatal(err)
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tail := range []string{
+		"*3\r\n$3\r\nSET\r\n$1\r\nb\r\n$2\r\n1",
+		"*1\r\n$5\r\nMULTI\r\n*3\r\n$3\r\nSET\r\n$1\r\nb\r\n$1\r\n2\r\n",
+		"#TS:17",
+	} {
+		if err := os.WriteFile(path, append(data, tail...), 0o644); err != nil {
+			t.Fatal(err)
+		}
+
+		config.aofLoadTruncated = false
+		if err := restartAOF(t, true); err == nil {
+			t.Errorf("%q: truncated AOF is loaded", tail)
+		}
+
+		config.aofLoadTruncated = true
+		if err := restartAOF(t, true); err != nil {
+			t.Fatalf("%q: %v", tail, err)
+		}
+		if got := dispatchTest(t, &tx, "GET", "b"); got != NullResponse {
+			t.Errorf("%q: incomplete command is applied: %q", tail, got)
+		}
+
+		if info, err := os.Stat(path); err != nil || info.Size() != int64(len(data)) {
+			t.Errorf("%q: AOF isn't cut to the last complete command", tail)
+		}
+	}
+
+	// cut AOF can be appended to
+	dispatchTest(t, &tx, "SET", "b", "2")
+	if err := restartAOF(t, true); err != nil {
+		t.Fatal(err)
+	}
+	if got := dispatchTest(t, &tx, "GET", "b"); got != "$1\r\n2\r\n" {
+		t.Errorf("GET b: got %q", got)
+	}
+
+	if err := os.WriteFile(path, append(data, "garbage\r\n"...), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := restartAOF(t, true); err == nil {
+		t.Error("AOF with bad format is loaded")
+	}
+}
+
+// waitAOFRewrite waits for the background rewrite to finish
+func waitAOFRewrite(t *testing.T) {
+	t.Helper()
+
+	for deadline := time.Now().Add(5 * time.Second); time.Now().Before(deadline); time.Sleep(time.Millisecond) {
+		kvs.mu.Lock()
+		rewriting := aof.rewriting
+		kvs.mu.Unlock()
+		if !rewriting {
+			return
+		}
+	}
+	t.Fatal("AOF rewrite hasn't finished")
+}
+
+func aofDirFiles(t *testing.T) []string {
+	t.Helper()
+
+	entries, err := os.ReadDir(aofDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var names []string
+	for _, e := range entries {
+		names = append(names, e.Name())
+	}
+
+	return names
+}
+
+func TestAOFRewrite(t *testing.T) {
+	testAOF(t)
+	var tx txState
+
+	for i := range 10 {
+		dispatchTest(t, &tx, "SET", "n", strconv.Itoa(i))
+		dispatchTest(t, &tx, "XADD", "s", "*", "i", strconv.Itoa(i))
+	}
+	// SET has no TTL options, the key is only written by the rewrite
+	expireAt := nowMs() + 100000
+	kvs.mu.Lock()
+	setExpire("tmp", &KvsValue{dtype: BulkStrSymbol, value: []byte("v")}, expireAt)
+	kvs.mu.Unlock()
+	dispatchTest(t, &tx, "HSET", "doc:1", "title", "hello world")
+	dispatchTest(t, &tx, "FT.CREATE", "idx", "PREFIX", "1", "doc:", "SCHEMA", "title", "TEXT", "SORTABLE")
+
+	if got := dispatchTest(t, &tx, "BGREWRITEAOF"); got != "+Background append only file rewriting started\r\n" {
+		t.Fatalf("BGREWRITEAOF: %q", got)
+	}
+	dispatchTest(t, &tx, "SET", "n", "10")
+	waitAOFRewrite(t)
+
+	expected := []string{
+		"appendonly.aof.1.base.aof",
+		"appendonly.aof.2.incr.aof",
+		"appendonly.aof.manifest",
+	}
+	if files := aofDirFiles(t); !slices.Equal(files, expected) {
+		t.Errorf("files after rewrite: %v, expected: %v", files, expected)
+	}
+	data, err := os.ReadFile(aofManifestPath())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(data) != "file appendonly.aof.1.base.aof seq 1 type b\nfile appendonly.aof.2.incr.aof seq 2 type i\n" {
+		t.Errorf("manifest: %q", data)
+	}
+
+	// the base has values as they were on the rewrite and the incremental file has commands run since then
+	dispatchTest(t, &tx, "XADD", "s", "*", "i", "10")
+	entries := dispatchTest(t, &tx, "XRANGE", "s", "-", "+")
+	if err := restartAOF(t, true); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, step := range []struct {
+		cmd   []string
+		reply string
+	}{
+		{[]string{"GET", "n"}, "$2\r\n10\r\n"},
+		{[]string{"XRANGE", "s", "-", "+"}, entries},
+		{[]string{"HGET", "doc:1", "title"}, "$11\r\nhello world\r\n"},
+		{[]string{"FT.SEARCH", "idx", "hello", "NOCONTENT"}, "*2\r\n:1\r\n$5\r\ndoc:1\r\n"},
+	} {
+		if got := dispatchTest(t, &tx, step.cmd[0], step.cmd[1:]...); got != step.reply {
+			t.Errorf("%v: got %q, expected: %q", step.cmd, got, step.reply)
+		}
+	}
+	kvs.mu.Lock()
+	val, ok := kvs.storage.Get("tmp")
+	kvs.mu.Unlock()
+	if !ok || val.expireAt != expireAt {
+		t.Errorf("expiry of tmp isn't kept")
+	}
+}
+
+// Until the rewrite replaces the manifest, the old one lists files with all data
+func TestAOFCrashDuringRewrite(t *testing.T) {
+	testAOF(t)
+	var tx txState
+
+	dispatchTest(t, &tx, "SET", "a", "1")
+
+	// the rewrite can't finish while the lock is held, so the directory is copied as it's left by a crash
+	kvs.mu.Lock()
+	if err := startAOFRewrite(); err != nil {
+		kvs.mu.Unlock()
+		t.Fatal(err)
+	}
+	if err := startAOFRewrite(); err != ErrAOFRewriteInProgress {
+		t.Errorf("second rewrite: %v", err)
+	}
+	kvs.mu.Unlock()
+
+	dispatchTest(t, &tx, "SET", "b", "2")
+
+	crashed := t.TempDir()
+	kvs.mu.Lock()
+	flushAOF()
+	for _, name := range aofDirFiles(t) {
+		data, err := os.ReadFile(aofFilePath(name))
+		if err != nil {
+			kvs.mu.Unlock()
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(filepath.Join(crashed, name), data, 0o644); err != nil {
+			kvs.mu.Unlock()
+			t.Fatal(err)
+		}
+	}
+	kvs.mu.Unlock()
+	waitAOFRewrite(t)
+
+	if err := restartAOF(t, false); err != nil {
+		t.Fatal(err)
+	}
+	config.dir, config.appendDirname = filepath.Dir(crashed), filepath.Base(crashed)
+	if err := restartAOF(t, true); err != nil {
+		t.Fatal(err)
+	}
+
+	for key, reply := range map[string]string{"a": "$1\r\n1\r\n", "b": "$1\r\n2\r\n"} {
+		if got := dispatchTest(t, &tx, "GET", key); got != reply {
+			t.Errorf("GET %s: got %q, expected: %q", key, got, reply)
+		}
+	}
+}
+
+func TestAOFOldFormat(t *testing.T) {
+	dir, prev := t.TempDir(), config
+	config.dir, config.appendOnly = dir, true
+	t.Cleanup(func() {
+		restartAOF(t, false)
+		config = prev
+	})
+
+	old := filepath.Join(dir, config.appendFilename)
+	if err := os.WriteFile(old, []byte("*3\r\n$3\r\nSET\r\n$1\r\nk\r\n$1\r\nv\r\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := restartAOF(t, true); err != nil {
+		t.Fatal(err)
+	}
+
+	var tx txState
+	if got := dispatchTest(t, &tx, "GET", "k"); got != "$1\r\nv\r\n" {
+		t.Errorf("GET k: %q", got)
+	}
+	if _, err := os.Stat(old); err == nil {
+		t.Error("AOF of the old format isn't removed")
+	}
+	expected := []string{"appendonly.aof.1.base.aof", "appendonly.aof.1.incr.aof", "appendonly.aof.manifest"}
+	if files := aofDirFiles(t); !slices.Equal(files, expected) {
+		t.Errorf("files: %v, expected: %v", files, expected)
+	}
+}
+
+func TestAOFEnableAtRuntime(t *testing.T) {
+	dir, prev := t.TempDir(), config
+	config.dir = dir
+	t.Cleanup(func() {
+		restartAOF(t, false)
+		config = prev
+	})
+	initStorage()
+	var tx txState
+
+	dispatchTest(t, &tx, "SET", "a", "1")
+	if got := dispatchTest(t, &tx, "CONFIG", "SET", "appendonly", "yes"); got != OkResponse {
+		t.Fatalf("CONFIG SET appendonly yes: %q", got)
+	}
+	dispatchTest(t, &tx, "SET", "b", "2")
+	waitAOFRewrite(t)
+
+	config.appendOnly = true
+	if err := restartAOF(t, true); err != nil {
+		t.Fatal(err)
+	}
+	for key, reply := range map[string]string{"a": "$1\r\n1\r\n", "b": "$1\r\n2\r\n"} {
+		if got := dispatchTest(t, &tx, "GET", key); got != reply {
+			t.Errorf("GET %s: got %q, expected: %q", key, got, reply)
+		}
+	}
+}
+
+func TestAOFAutoRewrite(t *testing.T) {
+	testAOF(t)
+	var tx txState
+
+	config.autoAOFRewriteMinSize = 1 << 10
+	for range 100 {
+		dispatchTest(t, &tx, "SET", "k", "value")
+	}
+
+	kvs.mu.Lock()
+	rewriteAOFIfNeeded()
+	started := aof.rewriting
+	kvs.mu.Unlock()
+	if !started {
+		t.Fatal("rewrite of grown AOF isn't started")
+	}
+	waitAOFRewrite(t)
+
+	kvs.mu.Lock()
+	rewriteAOFIfNeeded()
+	started = aof.rewriting
+	kvs.mu.Unlock()
+	if started {
+		t.Error("rewrite is started again right after a rewrite")
+	}
+}
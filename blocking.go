@@ -0,0 +1,82 @@
+package main
+
+import "time"
+
+// waitForKeys blocks the caller until one of keys is signaled with signalKeyReady or timeout passes.
+// Zero timeout means waiting forever. Must be called with kvs.mu held: the lock is released while waiting
+// and held again on return. Returns false on timeout or when the client is killed. Inside EXEC it times
+// out at once, as Redis does, so other clients never see a transaction half done. It doesn't wait while
+// persisted data is loaded either
+func waitForKeys(keys []string, timeout time.Duration) bool {
+	if kvs.inExec || kvs.loading {
+		return false
+	}
+
+	ready := make(chan struct{}, 1)
+	for _, key := range keys {
+		kvs.keyWaiters[key] = append(kvs.keyWaiters[key], ready)
+	}
+
+	var timer <-chan time.Time
+	if timeout > 0 {
+		t := time.NewTimer(timeout)
+		defer t.Stop()
+		timer = t.C
+	}
+
+	// waiting ends when the client is killed too, commands run by tests have no client
+	var killed <-chan struct{}
+	if kvs.client != nil {
+		killed = kvs.client.done
+	}
+
+	// other commands run while the lock is released and reset the command, the client and the values
+	// taken in the batch, changes made so far are handed to the engine before they take them
+	flushTouched()
+	cmd, c, touched, dirty := kvs.cmd, kvs.client, kvs.touched, kvs.dirty
+	kvs.touched = nil
+	kvs.mu.Unlock()
+
+	signaled := true
+	select {
+	case <-ready:
+	case <-timer:
+		signaled = false
+	case <-killed:
+		signaled = false
+	}
+
+	kvs.mu.Lock()
+	// the command goes on at the time it has woken up
+	kvs.cmd, kvs.client, kvs.touched = cmd, c, touched
+	kvs.dirtyWhileBlocked += kvs.dirty - dirty
+	commandTime.Store(time.Now().UnixMilli())
+
+	for _, key := range keys {
+		waiters := kvs.keyWaiters[key]
+		for i, ch := range waiters {
+			if ch == ready {
+				waiters = append(waiters[:i], waiters[i+1:]...)
+				break
+			}
+		}
+
+		if len(waiters) == 0 {
+			delete(kvs.keyWaiters, key)
+		} else {
+			kvs.keyWaiters[key] = waiters
+		}
+	}
+
+	return signaled
+}
+
+// signalKeyReady wakes up every client blocked on key. Must be called with kvs.mu held
+func signalKeyReady(key string) {
+	for _, ch := range kvs.keyWaiters[key] {
+		select {
+		case ch <- struct{}{}:
+		default:
+		}
+	}
+}
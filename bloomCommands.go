@@ -0,0 +1,157 @@
+package main
+
+import (
+	"strings"
+)
+
+// lookupBloom returns Bloom filter stored at key or nil if key doesn't exist
+func lookupBloom(key string) (*bloomFilter, error) {
+	val, ok := lookupKey(key)
+	if !ok {
+		return nil, nil
+	}
+
+	if val.dtype != BloomDtype {
+		return nil, ErrWrongType
+	}
+
+	return val.object.(*bloomFilter), nil
+}
+
+// lookupBloomCreate returns filter stored at key creating one with default parameters if needed
+func lookupBloomCreate(key string) (*bloomFilter, error) {
+	bf, err := lookupBloom(key)
+	if err != nil || bf != nil {
+		return bf, err
+	}
+
+	bf = newBloomFilter(bloomDefaultCapacity, bloomDefaultErrorRate, bloomDefaultExpansion)
+	storeKey(key, &KvsValue{dtype: BloomDtype, object: bf})
+
+	return bf, nil
+}
+
+// BF.RESERVE key error_rate capacity [EXPANSION expansion] [NONSCALING]
+func bfReserveHandler(args []*KvsValue) ([]byte, error) {
+	key := argToString(args[0])
+
+	errorRate, err := argToFloat64(args[1])
+	if err != nil || errorRate <= 0 || errorRate >= 1 {
+		return nil, ErrBloomErrorRate
+	}
+
+	capacity, err := argToInt64(args[2])
+	if err != nil || capacity <= 0 {
+		return nil, ErrBloomCapacity
+	}
+
+	expansion := int64(bloomDefaultExpansion)
+	nonScaling := false
+
+	for i := 3; i < len(args); i++ {
+		switch strings.ToUpper(argToString(args[i])) {
+		case "EXPANSION":
+			if i+1 >= len(args) {
+				return nil, ErrSyntax
+			}
+			i++
+			if expansion, err = argToInt64(args[i]); err != nil || expansion < 1 {
+				return nil, ErrBloomExpansion
+			}
+		case "NONSCALING":
+			nonScaling = true
+		default:
+			return nil, ErrSyntax
+		}
+	}
+
+	if nonScaling {
+		expansion = 0
+	}
+
+	if _, ok := kvs.storage.Get(key); ok {
+		return nil, ErrBloomItemExists
+	}
+
+	storeKey(key, &KvsValue{dtype: BloomDtype, object: newBloomFilter(uint64(capacity), errorRate, uint64(expansion))})
+	signalModifiedKey(key, notifyModule, "bf.reserve")
+
+	return []byte(OkResponse), nil
+}
+
+// BF.ADD key item
+func bfAddHandler(args []*KvsValue) ([]byte, error) {
+	key := argToString(args[0])
+
+	bf, err := lookupBloomCreate(key)
+	if err != nil {
+		return nil, err
+	}
+
+	added, err := bf.add([]byte(argToString(args[1])))
+	signalModifiedKey(key, notifyModule, "bf.add")
+	if err != nil {
+		return nil, err
+	}
+
+	return boolIntResponse(added), nil
+}
+
+// BF.MADD key item [item ...]
+func bfMaddHandler(args []*KvsValue) ([]byte, error) {
+	key := argToString(args[0])
+
+	bf, err := lookupBloomCreate(key)
+	if err != nil {
+		return nil, err
+	}
+	defer signalModifiedKey(key, notifyModule, "bf.madd")
+
+	res := make([][]byte, len(args)-1)
+	for i, arg := range args[1:] {
+		added, err := bf.add([]byte(argToString(arg)))
+		if err != nil {
+			// items added before the filter got full stay there, the rest get an error
+			res[i] = []byte(err.Error())
+			continue
+		}
+		res[i] = boolIntResponse(added)
+	}
+
+	return arrayResponse(res...), nil
+}
+
+// BF.EXISTS key item
+func bfExistsHandler(args []*KvsValue) ([]byte, error) {
+	bf, err := lookupBloom(argToString(args[0]))
+	if err != nil || bf == nil {
+		return intResponse(0), err
+	}
+
+	return boolIntResponse(bf.exists([]byte(argToString(args[1])))), nil
+}
+
+// BF.MEXISTS key item [item ...]
+func bfMexistsHandler(args []*KvsValue) ([]byte, error) {
+	bf, err := lookupBloom(argToString(args[0]))
+	if err != nil {
+		return nil, err
+	}
+
+	res := make([][]byte, len(args)-1)
+	for i, arg := range args[1:] {
+		res[i] = boolIntResponse(bf != nil && bf.exists([]byte(argToString(arg))))
+	}
+
+	return arrayResponse(res...), nil
+}
+
+// BF.CARD key
+func bfCardHandler(args []*KvsValue) ([]byte, error) {
+	bf, err := lookupBloom(argToString(args[0]))
+	if err != nil || bf == nil {
+		return intResponse(0), err
+	}
+
+	return intResponse(int64(bf.count())), nil
+}
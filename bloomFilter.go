@@ -0,0 +1,165 @@
+package main
+
+import (
+	"math"
+)
+
+// Scalable Bloom filter is a chain of plain Bloom filters. When the last one reaches its capacity a new one
+// is added, expansion times bigger and with a tighter error rate, so the overall error rate stays bounded
+const (
+	bloomDefaultErrorRate = 0.01
+	bloomDefaultCapacity  = 100
+	bloomDefaultExpansion = 2
+	bloomTighteningRatio  = 0.5
+
+	// seeds of the two hashes combined into k hash functions
+	bloomHashSeed1 = 0xc6a4a7935bd1e995
+	bloomHashSeed2 = 0x9747b28c
+)
+
+type bloomLayer struct {
+	bits     []uint64
+	size     uint64 // number of bits
+	hashes   uint64
+	capacity uint64
+	count    uint64
+}
+
+type bloomFilter struct {
+	layers    []*bloomLayer
+	errorRate float64
+	// zero expansion means the filter doesn't scale and refuses new items when full
+	expansion uint64
+}
+
+func newBloomLayer(capacity uint64, errorRate float64) *bloomLayer {
+	bitsPerItem := -math.Log(errorRate) / (math.Ln2 * math.Ln2)
+	size := max(uint64(math.Ceil(float64(capacity)*bitsPerItem)), 64)
+
+	return &bloomLayer{
+		bits:     make([]uint64, (size+63)/64),
+		size:     size,
+		hashes:   max(uint64(math.Ceil(math.Ln2*bitsPerItem)), 1),
+		capacity: capacity,
+	}
+}
+
+func newBloomFilter(capacity uint64, errorRate float64, expansion uint64) *bloomFilter {
+	return &bloomFilter{
+		layers:    []*bloomLayer{newBloomLayer(capacity, errorRate)},
+		errorRate: errorRate,
+		expansion: expansion,
+	}
+}
+
+// bloomHash returns two independent hashes, i-th hash function is h1 + i*h2 (Kirsch-Mitzenmacher)
+func bloomHash(item []byte) (uint64, uint64) {
+	return murmurHash64A(item, bloomHashSeed1), murmurHash64A(item, bloomHashSeed2)
+}
+
+func (l *bloomLayer) has(h1, h2 uint64) bool {
+	for i := range l.hashes {
+		bit := (h1 + i*h2) % l.size
+		if l.bits[bit/64]&(1<<(bit%64)) == 0 {
+			return false
+		}
+	}
+
+	return true
+}
+
+func (l *bloomLayer) add(h1, h2 uint64) {
+	for i := range l.hashes {
+		bit := (h1 + i*h2) % l.size
+		l.bits[bit/64] |= 1 << (bit % 64)
+	}
+
+	l.count++
+}
+
+func (bf *bloomFilter) exists(item []byte) bool {
+	h1, h2 := bloomHash(item)
+
+	for _, l := range bf.layers {
+		if l.has(h1, h2) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// add inserts item and returns false if it (probably) was already there
+func (bf *bloomFilter) add(item []byte) (bool, error) {
+	h1, h2 := bloomHash(item)
+
+	for _, l := range bf.layers {
+		if l.has(h1, h2) {
+			return false, nil
+		}
+	}
+
+	last := bf.layers[len(bf.layers)-1]
+	if last.count >= last.capacity {
+		if bf.expansion == 0 {
+			return false, ErrBloomFull
+		}
+
+		errorRate := bf.errorRate * math.Pow(bloomTighteningRatio, float64(len(bf.layers)))
+		last = newBloomLayer(last.capacity*bf.expansion, errorRate)
+		bf.layers = append(bf.layers, last)
+	}
+
+	last.add(h1, h2)
+
+	return true, nil
+}
+
+func (bf *bloomFilter) count() uint64 {
+	var res uint64
+	for _, l := range bf.layers {
+		res += l.count
+	}
+
+	return res
+}
+
+func (bf *bloomFilter) dump(b []byte) []byte {
+	b = appendF64(b, bf.errorRate)
+	b = appendU64(b, bf.expansion)
+
+	b = appendU32(b, len(bf.layers))
+	for _, l := range bf.layers {
+		b = appendU64(b, l.size)
+		b = appendU64(b, l.hashes)
+		b = appendU64(b, l.capacity)
+		b = appendU64(b, l.count)
+		for _, word := range l.bits {
+			b = appendU64(b, word)
+		}
+	}
+
+	return b
+}
+
+func restoreBloomFilter(r *dumpReader) *bloomFilter {
+	bf := &bloomFilter{errorRate: r.f64(), expansion: r.u64()}
+
+	bf.layers = make([]*bloomLayer, r.count(40))
+	r.check(len(bf.layers) > 0)
+	for i := range bf.layers {
+		l := &bloomLayer{size: r.u64(), hashes: r.u64(), capacity: r.u64(), count: r.u64()}
+		r.check(l.size > 0 && (l.size+63)/64 <= uint64(len(r.data))/8)
+		if r.failed {
+			return bf
+		}
+
+		l.bits = make([]uint64, (l.size+63)/64)
+		for j := range l.bits {
+			l.bits[j] = r.u64()
+		}
+		bf.layers[i] = l
+	}
+
+	return bf
+}
@@ -0,0 +1,70 @@
+package main
+
+import (
+	"errors"
+	"strconv"
+	"testing"
+)
+
+func TestBloomFilterNoFalseNegatives(t *testing.T) {
+	bf := newBloomFilter(100, 0.01, 2)
+
+	for i := range 1000 {
+		if _, err := bf.add([]byte(strconv.Itoa(i))); err != nil {
+			t.Fatalf("add(%d) returned error: %v", i, err)
+		}
+	}
+
+	for i := range 1000 {
+		if !bf.exists([]byte(strconv.Itoa(i))) {
+			t.Errorf("exists(%d) = false after add", i)
+		}
+	}
+
+	if len(bf.layers) < 2 {
+		t.Errorf("filter has %d layers after exceeding capacity, expected it to scale", len(bf.layers))
+	}
+}
+
+func TestBloomFilterErrorRate(t *testing.T) {
+	bf := newBloomFilter(10000, 0.01, 2)
+	for i := range 10000 {
+		bf.add([]byte("in" + strconv.Itoa(i)))
+	}
+
+	falsePositives := 0
+	for i := range 10000 {
+		if bf.exists([]byte("out" + strconv.Itoa(i))) {
+			falsePositives++
+		}
+	}
+
+	if rate := float64(falsePositives) / 10000; rate > 0.02 {
+		t.Errorf("false positive rate = %v, expected about 0.01", rate)
+	}
+}
+
+func TestBloomFilterAddExisting(t *testing.T) {
+	bf := newBloomFilter(10, 0.01, 2)
+
+	if added, _ := bf.add([]byte("a")); !added {
+		t.Errorf("first add() = false, expected: true")
+	}
+
+	if added, _ := bf.add([]byte("a")); added {
+		t.Errorf("second add() = true, expected: false")
+	}
+
+	if bf.count() != 1 {
+		t.Errorf("count() = %v, expected: 1", bf.count())
+	}
+}
+
+func TestBloomFilterNonScaling(t *testing.T) {
+	bf := newBloomFilter(1, 0.01, 0)
+	bf.add([]byte("a"))
+
+	if _, err := bf.add([]byte("b")); !errors.Is(err, ErrBloomFull) {
+		t.Errorf("add() to full filter error = %v, expected: %v", err, ErrBloomFull)
+	}
+}
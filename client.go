@@ -0,0 +1,447 @@
+package main
+
+import (
+	"log"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// pubsubOutputLimit is how many bytes may wait for a subscribed client to read them. Publishers never
+// block on slow clients, a client falling that far behind is disconnected instead
+const pubsubOutputLimit = 32 << 20
+
+var lastClientID atomic.Int64
+
+// Every connection gets a client. Replies and pub/sub messages are queued and written by a separate
+// goroutine, so publishing to a client doesn't wait for it to read
+type client struct {
+	id        int64
+	addr      string
+	laddr     string
+	createdAt time.Time
+	conn      net.Conn
+	resp      int // protocol version chosen with HELLO, changed under pubsub.mu
+	tx        txState
+
+	// state shown by CLIENT LIST, guarded by infoMu since it's read by other connections
+	infoMu     sync.Mutex
+	name       string
+	lastCmd    string
+	lastActive time.Time
+	qbuf       int // bytes received and not parsed yet
+	multi      int // commands queued in MULTI, -1 outside of it
+	watching   int
+	noEvict    bool
+
+	// CLIENT REPLY mode and CLIENT KILL of the connection itself, used by the connection goroutine only
+	replyMode       int
+	closeAfterReply bool
+
+	// channels, patterns and shard channels client is subscribed to, changed under pubsub.mu
+	subs [subKinds]map[string]struct{}
+
+	tracking trackingState
+
+	outMu   sync.Mutex
+	out     [][]byte
+	outSize int // bytes queued and not written to conn yet
+	closed  bool
+	wake    chan struct{}
+	// closed with the client, so its commands blocked on keys or a pause return at once
+	done chan struct{}
+}
+
+func newClient(conn net.Conn) *client {
+	now := time.Now()
+	c := &client{
+		id:         lastClientID.Add(1),
+		addr:       conn.RemoteAddr().String(),
+		laddr:      conn.LocalAddr().String(),
+		createdAt:  now,
+		conn:       conn,
+		resp:       2,
+		lastActive: now,
+		multi:      -1,
+		wake:       make(chan struct{}, 1),
+		done:       make(chan struct{}),
+	}
+	c.tx.client = c
+	for kind := range c.subs {
+		c.subs[kind] = make(map[string]struct{})
+	}
+
+	clients.mu.Lock()
+	clients.byID[c.id] = c
+	clients.mu.Unlock()
+
+	go c.writeLoop()
+
+	return c
+}
+
+// clients maps ids to connected clients
+var clients = struct {
+	mu   sync.Mutex
+	byID map[int64]*client
+}{byID: make(map[int64]*client)}
+
+// unregisterClient is called when connection is closed
+func unregisterClient(c *client) {
+	kvs.mu.Lock()
+	disableTracking(c)
+	kvs.mu.Unlock()
+
+	removeMonitor(c)
+
+	clients.mu.Lock()
+	delete(clients.byID, c.id)
+	clients.mu.Unlock()
+}
+
+func clientByID(id int64) *client {
+	clients.mu.Lock()
+	defer clients.mu.Unlock()
+
+	return clients.byID[id]
+}
+
+func allClients() []*client {
+	clients.mu.Lock()
+	defer clients.mu.Unlock()
+
+	res := make([]*client, 0, len(clients.byID))
+	for _, c := range clients.byID {
+		res = append(res, c)
+	}
+
+	return res
+}
+
+// CLIENT REPLY modes. SKIP drops the reply of CLIENT REPLY itself and of the next command
+const (
+	replyOn = iota
+	replyOff
+	replySkip
+	replySkipNext
+)
+
+// beginCommand records command received by the connection for CLIENT LIST
+func (c *client) beginCommand(cmd string, qbuf int) {
+	c.infoMu.Lock()
+	defer c.infoMu.Unlock()
+
+	c.lastCmd, c.lastActive, c.qbuf = strings.ToLower(cmd), time.Now(), qbuf
+}
+
+// endCommand records transaction state changed by the command for CLIENT LIST
+func (c *client) endCommand() {
+	multi := -1
+	if c.tx.active {
+		multi = len(c.tx.queue)
+	}
+
+	c.infoMu.Lock()
+	defer c.infoMu.Unlock()
+
+	c.multi, c.watching = multi, len(c.tx.watched)
+}
+
+// replyAllowed tells whether the reply of the command just run is sent, according to CLIENT REPLY mode
+func (c *client) replyAllowed() bool {
+	switch c.replyMode {
+	case replyOff:
+		return false
+	case replySkip:
+		c.replyMode = replySkipNext
+		return false
+	case replySkipNext:
+		c.replyMode = replyOn
+		return false
+	}
+
+	return true
+}
+
+// write queues reply for the client
+func (c *client) write(b []byte) {
+	c.enqueue(b, false)
+}
+
+// push queues pub/sub message. If client has too much unread output already it's disconnected
+func (c *client) push(b []byte) {
+	c.enqueue(b, true)
+}
+
+func (c *client) enqueue(b []byte, limited bool) {
+	if len(b) == 0 {
+		return
+	}
+
+	c.outMu.Lock()
+	defer c.outMu.Unlock()
+
+	if c.closed {
+		return
+	}
+
+	if limited && c.outSize+len(b) > pubsubOutputLimit {
+		log.Println("Client", c.id, "disconnected: pubsub output buffer limit reached")
+		c.closeLocked()
+		c.out = nil
+		c.conn.Close()
+		return
+	}
+
+	c.out = append(c.out, b)
+	c.outSize += len(b)
+
+	select {
+	case c.wake <- struct{}{}:
+	default:
+	}
+}
+
+func (c *client) writeLoop() {
+	defer c.conn.Close()
+
+	for range c.wake {
+		c.outMu.Lock()
+		bufs := c.out
+		c.out = nil
+		c.outMu.Unlock()
+
+		size := 0
+		for _, b := range bufs {
+			size += len(b)
+		}
+
+		_, err := (*net.Buffers)(&bufs).WriteTo(c.conn)
+
+		c.outMu.Lock()
+		c.outSize -= size
+		c.outMu.Unlock()
+
+		if err != nil {
+			c.close()
+			return
+		}
+	}
+}
+
+// close stops accepting output. Output queued before is still written, then connection is closed
+func (c *client) close() {
+	c.outMu.Lock()
+	defer c.outMu.Unlock()
+
+	c.closeLocked()
+}
+
+func (c *client) closeLocked() {
+	if !c.closed {
+		c.closed = true
+		close(c.wake)
+		close(c.done)
+	}
+}
+
+func (c *client) isClosed() bool {
+	c.outMu.Lock()
+	defer c.outMu.Unlock()
+
+	return c.closed
+}
+
+// subscriptionCount is what (un)subscribe replies report for kind. Shard channels are counted separately
+func (c *client) subscriptionCount(kind int) int {
+	if kind == subShard {
+		return len(c.subs[subShard])
+	}
+
+	return len(c.subs[subChannel]) + len(c.subs[subPattern])
+}
+
+// inPubsubMode tells whether client can run only subscription commands. RESP3 clients get messages as
+// push frames, so they can run any command while subscribed
+func (c *client) inPubsubMode() bool {
+	return c.resp == 2 && c.subscriptionCount(subChannel)+c.subscriptionCount(subShard) > 0
+}
+
+var pubsubModeCommands = map[string]bool{
+	SubscribeCmd: true, UnsubscribeCmd: true,
+	PsubscribeCmd: true, PunsubscribeCmd: true,
+	SsubscribeCmd: true, SunsubscribeCmd: true,
+	PingCmd: true,
+}
+
+// dispatch runs command of the client. Replies of subscription commands are written by the command itself,
+// so they can't be reordered with messages published meanwhile
+func (c *client) dispatch(cmd string, args []*KvsValue) ([]byte, error) {
+	name := strings.ToUpper(cmd)
+	feedMonitors(c.addr, cmd, args)
+
+	if c.inPubsubMode() && !pubsubModeCommands[name] {
+		return nil, pubsubModeErr(cmd)
+	}
+
+	// client killed while paused gets no reply
+	if name != ClientCmd && !waitUnpaused(c.done, c.mayWrite(name)) {
+		return nil, nil
+	}
+
+	switch name {
+	case HelloCmd:
+		if c.tx.active {
+			c.tx.aborted = true
+			return nil, ErrNotAllowedInMulti
+		}
+
+		return c.hello(args)
+	case ClientCmd:
+		if c.tx.active {
+			c.tx.aborted = true
+			return nil, ErrNotAllowedInMulti
+		}
+
+		return c.clientCommand(args)
+	case MonitorCmd:
+		if c.tx.active {
+			c.tx.aborted = true
+			return nil, ErrNotAllowedInMulti
+		}
+		if len(args) != 0 {
+			return nil, wrongArgsCountErr(MonitorCmd)
+		}
+
+		addMonitor(c)
+
+		return []byte(OkResponse), nil
+	case PingCmd:
+		if c.inPubsubMode() {
+			return c.pubsubPing(args)
+		}
+	case "SCRIPT", "FUNCTION":
+		// the running script holds kvs.mu, so SCRIPT KILL and FUNCTION KILL can't wait for it
+		if !c.tx.active && len(args) == 1 && strings.ToUpper(argToString(args[0])) == "KILL" {
+			if err := killScript(); err != nil {
+				return nil, err
+			}
+			return []byte(OkResponse), nil
+		}
+	case SubscribeCmd, PsubscribeCmd, SsubscribeCmd, UnsubscribeCmd, PunsubscribeCmd, SunsubscribeCmd:
+		if c.tx.active {
+			c.tx.aborted = true
+			return nil, ErrNotAllowedInMulti
+		}
+
+		kind := subKindOf[name]
+		subscribe := name == SubscribeCmd || name == PsubscribeCmd || name == SsubscribeCmd
+		if subscribe && len(args) == 0 {
+			return nil, wrongArgsCountErr(name)
+		}
+
+		names := make([]string, len(args))
+		for i, arg := range args {
+			names[i] = argToString(arg)
+		}
+
+		if subscribe {
+			pubsub.subscribe(c, kind, names)
+		} else {
+			pubsub.unsubscribe(c, kind, names)
+		}
+
+		return nil, nil
+	}
+
+	return dispatchCommand(&c.tx, cmd, args)
+}
+
+// mayWrite tells whether command name of the client may modify the keyspace once it's run. Commands
+// queued in MULTI run at EXEC
+func (c *client) mayWrite(name string) bool {
+	if name == ExecCmd {
+		for _, q := range c.tx.queue {
+			if q.cmd.write {
+				return true
+			}
+		}
+		return false
+	}
+	if c.tx.active {
+		return false
+	}
+
+	cmd, ok := lookupCommand(name)
+	return ok && cmd.write
+}
+
+// PING [message] of subscribed RESP2 client replies with array, as it can't be confused with a message then
+func (c *client) pubsubPing(args []*KvsValue) ([]byte, error) {
+	if len(args) > 1 {
+		return nil, wrongArgsCountErr(PingCmd)
+	}
+
+	msg := []byte{}
+	if len(args) == 1 {
+		msg = []byte(argToString(args[0]))
+	}
+
+	return arrayResponse(bulkStrResponse([]byte("pong")), bulkStrResponse(msg)), nil
+}
+
+// HELLO [protover [AUTH username password] [SETNAME clientname]]. KVS has no users, so AUTH is accepted
+// with any credentials
+func (c *client) hello(args []*KvsValue) ([]byte, error) {
+	resp, name := c.resp, c.clientName()
+
+	if len(args) > 0 {
+		ver, err := strconv.Atoi(argToString(args[0]))
+		if err != nil {
+			return nil, ErrProtocolVersion
+		}
+		if ver != 2 && ver != 3 {
+			return nil, ErrNoProto
+		}
+		resp = ver
+
+		for i := 1; i < len(args); i++ {
+			switch strings.ToUpper(argToString(args[i])) {
+			case "AUTH":
+				if i+2 >= len(args) {
+					return nil, ErrSyntax
+				}
+				i += 2
+			case "SETNAME":
+				if i+1 >= len(args) {
+					return nil, ErrSyntax
+				}
+				name = argToString(args[i+1])
+				if !validClientName(name) {
+					return nil, ErrClientName
+				}
+				i++
+			default:
+				return nil, ErrSyntax
+			}
+		}
+	}
+
+	pubsub.mu.Lock()
+	c.resp = resp
+	pubsub.mu.Unlock()
+	c.setName(name)
+
+	return mapResponse(resp,
+		bulkStrResponse([]byte("server")), bulkStrResponse([]byte("kvs")),
+		bulkStrResponse([]byte("version")), bulkStrResponse([]byte(KvsVersion)),
+		bulkStrResponse([]byte("proto")), intResponse(int64(resp)),
+		bulkStrResponse([]byte("id")), intResponse(c.id),
+		bulkStrResponse([]byte("mode")), bulkStrResponse([]byte("standalone")),
+		bulkStrResponse([]byte("role")), bulkStrResponse([]byte("master")),
+		bulkStrResponse([]byte("modules")), []byte(EmptyArrayResponse),
+	), nil
+}
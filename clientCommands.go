@@ -0,0 +1,601 @@
+package main
+
+import (
+	"cmp"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// KVS has no ACL, every connection is the default user
+const defaultUser = "default"
+
+// CLIENT subcommand [args ...] is run outside of the command table, since it changes state of the connection
+func (c *client) clientCommand(args []*KvsValue) ([]byte, error) {
+	if len(args) == 0 {
+		return nil, wrongArgsCountErr(ClientCmd)
+	}
+
+	sub := strings.ToUpper(argToString(args[0]))
+	args = args[1:]
+
+	switch sub {
+	case "ID":
+		if len(args) != 0 {
+			return nil, wrongArgsCountErr("CLIENT|ID")
+		}
+
+		return intResponse(c.id), nil
+	case "LIST":
+		return clientList(args)
+	case "INFO":
+		if len(args) != 0 {
+			return nil, wrongArgsCountErr("CLIENT|INFO")
+		}
+
+		if err := lockKvs(); err != nil {
+			return nil, err
+		}
+		defer kvs.mu.Unlock()
+
+		return bulkStrResponse([]byte(c.infoLine(time.Now()))), nil
+	case "SETNAME":
+		if len(args) != 1 {
+			return nil, wrongArgsCountErr("CLIENT|SETNAME")
+		}
+
+		name := argToString(args[0])
+		if !validClientName(name) {
+			return nil, ErrClientName
+		}
+		c.setName(name)
+
+		return []byte(OkResponse), nil
+	case "GETNAME":
+		if len(args) != 0 {
+			return nil, wrongArgsCountErr("CLIENT|GETNAME")
+		}
+
+		name := c.clientName()
+		if name == "" {
+			return []byte(NullResponse), nil
+		}
+
+		return bulkStrResponse([]byte(name)), nil
+	case "KILL":
+		return c.clientKill(args)
+	case "PAUSE":
+		if len(args) != 1 && len(args) != 2 {
+			return nil, wrongArgsCountErr("CLIENT|PAUSE")
+		}
+
+		timeout, err := strconv.ParseInt(argToString(args[0]), 10, 64)
+		if err != nil {
+			return nil, ErrClientPauseTimeout
+		}
+		if timeout < 0 {
+			return nil, ErrTimeoutNegative
+		}
+
+		all := true
+		if len(args) == 2 {
+			switch strings.ToUpper(argToString(args[1])) {
+			case "WRITE":
+				all = false
+			case "ALL":
+			default:
+				return nil, ErrSyntax
+			}
+		}
+		pauseClients(nowMs()+timeout, all)
+
+		return []byte(OkResponse), nil
+	case "UNPAUSE":
+		if len(args) != 0 {
+			return nil, wrongArgsCountErr("CLIENT|UNPAUSE")
+		}
+		unpauseClients()
+
+		return []byte(OkResponse), nil
+	case "NO-EVICT":
+		if len(args) != 1 {
+			return nil, wrongArgsCountErr("CLIENT|NO-EVICT")
+		}
+
+		var on bool
+		switch strings.ToUpper(argToString(args[0])) {
+		case "ON":
+			on = true
+		case "OFF":
+		default:
+			return nil, ErrSyntax
+		}
+
+		c.infoMu.Lock()
+		c.noEvict = on
+		c.infoMu.Unlock()
+
+		return []byte(OkResponse), nil
+	case "REPLY":
+		if len(args) != 1 {
+			return nil, wrongArgsCountErr("CLIENT|REPLY")
+		}
+
+		switch strings.ToUpper(argToString(args[0])) {
+		case "ON":
+			c.replyMode = replyOn
+		case "OFF":
+			c.replyMode = replyOff
+		case "SKIP":
+			if c.replyMode != replyOff {
+				c.replyMode = replySkip
+			}
+		default:
+			return nil, ErrSyntax
+		}
+
+		return []byte(OkResponse), nil
+	case "TRACKING":
+		return c.clientTracking(args)
+	case "CACHING":
+		if len(args) != 1 {
+			return nil, wrongArgsCountErr("CLIENT|CACHING")
+		}
+
+		yes := false
+		switch strings.ToUpper(argToString(args[0])) {
+		case "YES":
+			yes = true
+		case "NO":
+		default:
+			return nil, ErrSyntax
+		}
+
+		if err := lockKvs(); err != nil {
+			return nil, err
+		}
+		defer kvs.mu.Unlock()
+
+		t := &c.tracking
+		switch {
+		case !t.on || !t.optin && !t.optout:
+			return nil, ErrTrackingCachingMode
+		case yes && !t.optin:
+			return nil, ErrTrackingCachingYes
+		case !yes && !t.optout:
+			return nil, ErrTrackingCachingNo
+		}
+		t.caching, t.cachingSet = yes, true
+
+		return []byte(OkResponse), nil
+	case "GETREDIR":
+		if len(args) != 0 {
+			return nil, wrongArgsCountErr("CLIENT|GETREDIR")
+		}
+
+		if err := lockKvs(); err != nil {
+			return nil, err
+		}
+		defer kvs.mu.Unlock()
+
+		if !c.tracking.on {
+			return intResponse(-1), nil
+		}
+
+		return intResponse(c.tracking.redirect), nil
+	case "TRACKINGINFO":
+		if len(args) != 0 {
+			return nil, wrongArgsCountErr("CLIENT|TRACKINGINFO")
+		}
+
+		return c.trackingInfo()
+	}
+
+	return nil, ErrUnknownSubcommand
+}
+
+// CLIENT TRACKING ON|OFF [REDIRECT client-id] [PREFIX prefix [PREFIX prefix ...]] [BCAST] [OPTIN] [OPTOUT] [NOLOOP]
+func (c *client) clientTracking(args []*KvsValue) ([]byte, error) {
+	if len(args) == 0 {
+		return nil, wrongArgsCountErr("CLIENT|TRACKING")
+	}
+
+	var on bool
+	switch strings.ToUpper(argToString(args[0])) {
+	case "ON":
+		on = true
+	case "OFF":
+	default:
+		return nil, ErrSyntax
+	}
+
+	var opts trackingState
+	for i := 1; i < len(args); i++ {
+		switch strings.ToUpper(argToString(args[i])) {
+		case "REDIRECT":
+			if i+1 == len(args) {
+				return nil, ErrSyntax
+			}
+			i++
+			id, err := strconv.ParseInt(argToString(args[i]), 10, 64)
+			if err != nil {
+				return nil, ErrInvalidClientID
+			}
+			opts.redirect = id
+		case "PREFIX":
+			if i+1 == len(args) {
+				return nil, ErrSyntax
+			}
+			i++
+			opts.prefixes = append(opts.prefixes, argToString(args[i]))
+		case "BCAST":
+			opts.bcast = true
+		case "OPTIN":
+			opts.optin = true
+		case "OPTOUT":
+			opts.optout = true
+		case "NOLOOP":
+			opts.noloop = true
+		default:
+			return nil, ErrSyntax
+		}
+	}
+
+	if err := lockKvs(); err != nil {
+		return nil, err
+	}
+	defer kvs.mu.Unlock()
+
+	if !on {
+		disableTracking(c)
+		return []byte(OkResponse), nil
+	}
+
+	switch {
+	case len(opts.prefixes) > 0 && !opts.bcast:
+		return nil, ErrTrackingPrefixBcast
+	case opts.optin && opts.optout:
+		return nil, ErrTrackingOptInOut
+	case opts.bcast && (opts.optin || opts.optout):
+		return nil, ErrTrackingOptBcast
+	case opts.redirect != 0 && opts.redirect != c.id && clientByID(opts.redirect) == nil:
+		return nil, ErrTrackingNoRedirect
+	}
+
+	if err := enableTracking(c, opts); err != nil {
+		return nil, err
+	}
+
+	return []byte(OkResponse), nil
+}
+
+// CLIENT TRACKINGINFO
+func (c *client) trackingInfo() ([]byte, error) {
+	if err := lockKvs(); err != nil {
+		return nil, err
+	}
+	defer kvs.mu.Unlock()
+
+	t := &c.tracking
+	var flags [][]byte
+	redirect := int64(-1)
+
+	if !t.on {
+		flags = append(flags, []byte("off"))
+	} else {
+		redirect = t.redirect
+		flags = append(flags, []byte("on"))
+		for _, f := range []struct {
+			set  bool
+			name string
+		}{
+			{t.bcast, "bcast"},
+			{t.optin, "optin"},
+			{t.optout, "optout"},
+			{t.cachingSet && t.caching, "caching-yes"},
+			{t.cachingSet && !t.caching, "caching-no"},
+			{t.noloop, "noloop"},
+			{t.redirect != 0 && clientByID(t.redirect) == nil, "broken_redirect"},
+		} {
+			if f.set {
+				flags = append(flags, []byte(f.name))
+			}
+		}
+	}
+
+	prefixes := make([][]byte, len(t.prefixes))
+	for i, p := range t.prefixes {
+		prefixes[i] = []byte(p)
+	}
+
+	return mapResponse(c.resp,
+		bulkStrResponse([]byte("flags")), bulkStrArrayResponse(flags...),
+		bulkStrResponse([]byte("redirect")), intResponse(redirect),
+		bulkStrResponse([]byte("prefixes")), bulkStrArrayResponse(prefixes...),
+	), nil
+}
+
+func (c *client) clientName() string {
+	c.infoMu.Lock()
+	defer c.infoMu.Unlock()
+
+	return c.name
+}
+
+func (c *client) setName(name string) {
+	c.infoMu.Lock()
+	defer c.infoMu.Unlock()
+
+	c.name = name
+}
+
+// validClientName allows printable ASCII without spaces, empty name removes the name
+func validClientName(name string) bool {
+	for i := range len(name) {
+		if name[i] < '!' || name[i] > '~' {
+			return false
+		}
+	}
+
+	return true
+}
+
+// clientType is normal or pubsub, there are no masters and replicas. Must be called with pubsub.mu held
+func (c *client) clientType() string {
+	for _, subs := range c.subs {
+		if len(subs) > 0 {
+			return "pubsub"
+		}
+	}
+
+	return "normal"
+}
+
+// infoLine describes client for CLIENT LIST and CLIENT INFO. Must be called with kvs.mu held
+func (c *client) infoLine(now time.Time) string {
+	pubsub.mu.Lock()
+	sub, psub, ssub := len(c.subs[subChannel]), len(c.subs[subPattern]), len(c.subs[subShard])
+	resp := c.resp
+	pubsub.mu.Unlock()
+
+	monitors.mu.Lock()
+	_, monitor := monitors.clients[c]
+	monitors.mu.Unlock()
+
+	c.outMu.Lock()
+	omem := c.outSize
+	c.outMu.Unlock()
+
+	c.infoMu.Lock()
+	defer c.infoMu.Unlock()
+
+	var flags []byte
+	for _, f := range []struct {
+		set  bool
+		flag byte
+	}{
+		{monitor, 'O'},
+		{sub+psub+ssub > 0, 'P'},
+		{c.multi >= 0, 'x'},
+		{c.noEvict, 'e'},
+		{c.tracking.on, 't'},
+		{c.tracking.bcast, 'B'},
+		{c.tracking.redirect != 0 && clientByID(c.tracking.redirect) == nil, 'R'},
+	} {
+		if f.set {
+			flags = append(flags, f.flag)
+		}
+	}
+	if len(flags) == 0 {
+		flags = []byte{'N'}
+	}
+
+	redirect := int64(-1)
+	if c.tracking.on {
+		redirect = c.tracking.redirect
+	}
+
+	var sb strings.Builder
+	for _, field := range []struct {
+		name  string
+		value string
+	}{
+		{"id", strconv.FormatInt(c.id, 10)},
+		{"addr", c.addr},
+		{"laddr", c.laddr},
+		{"name", c.name},
+		{"age", strconv.FormatInt(int64(now.Sub(c.createdAt)/time.Second), 10)},
+		{"idle", strconv.FormatInt(int64(now.Sub(c.lastActive)/time.Second), 10)},
+		{"flags", string(flags)},
+		{"db", "0"},
+		{"sub", strconv.Itoa(sub)},
+		{"psub", strconv.Itoa(psub)},
+		{"ssub", strconv.Itoa(ssub)},
+		{"multi", strconv.Itoa(c.multi)},
+		{"watch", strconv.Itoa(c.watching)},
+		{"qbuf", strconv.Itoa(c.qbuf)},
+		{"omem", strconv.Itoa(omem)},
+		{"cmd", c.lastCmd},
+		{"user", defaultUser},
+		{"redir", strconv.FormatInt(redirect, 10)},
+		{"resp", strconv.Itoa(resp)},
+	} {
+		if sb.Len() > 0 {
+			sb.WriteByte(' ')
+		}
+		sb.WriteString(field.name + "=" + field.value)
+	}
+
+	return sb.String()
+}
+
+// CLIENT LIST [TYPE normal|master|replica|pubsub] [ID client-id [client-id ...]]
+func clientList(args []*KvsValue) ([]byte, error) {
+	var typ string
+	var ids map[int64]bool
+
+	for i := 0; i < len(args); i++ {
+		switch strings.ToUpper(argToString(args[i])) {
+		case "TYPE":
+			if i+1 == len(args) {
+				return nil, ErrSyntax
+			}
+			i++
+			typ = strings.ToLower(argToString(args[i]))
+			if !validClientType(typ) {
+				return nil, clientTypeErr(typ)
+			}
+		case "ID":
+			if i+1 == len(args) {
+				return nil, ErrSyntax
+			}
+			ids = make(map[int64]bool)
+			for i++; i < len(args); i++ {
+				id, err := strconv.ParseInt(argToString(args[i]), 10, 64)
+				if err != nil || id <= 0 {
+					return nil, ErrInvalidClientID
+				}
+				ids[id] = true
+			}
+		default:
+			return nil, ErrSyntax
+		}
+	}
+
+	if err := lockKvs(); err != nil {
+		return nil, err
+	}
+	defer kvs.mu.Unlock()
+
+	list := allClients()
+	slices.SortFunc(list, func(a, b *client) int { return cmp.Compare(a.id, b.id) })
+
+	now := time.Now()
+	var sb strings.Builder
+	for _, c := range list {
+		if ids != nil && !ids[c.id] || typ != "" && !c.hasType(typ) {
+			continue
+		}
+		sb.WriteString(c.infoLine(now))
+		sb.WriteByte('\n')
+	}
+
+	return bulkStrResponse([]byte(sb.String())), nil
+}
+
+func validClientType(typ string) bool {
+	switch typ {
+	case "normal", "master", "replica", "slave", "pubsub":
+		return true
+	}
+
+	return false
+}
+
+func (c *client) hasType(typ string) bool {
+	pubsub.mu.Lock()
+	defer pubsub.mu.Unlock()
+
+	return c.clientType() == typ
+}
+
+// CLIENT KILL addr:port | CLIENT KILL [ID client-id] [ADDR addr:port] [LADDR addr:port] [USER username]
+// [TYPE normal|master|replica|pubsub] [SKIPME yes|no] [MAXAGE seconds]
+func (c *client) clientKill(args []*KvsValue) ([]byte, error) {
+	if len(args) == 0 {
+		return nil, wrongArgsCountErr("CLIENT|KILL")
+	}
+
+	if len(args) == 1 {
+		addr := argToString(args[0])
+		for _, other := range allClients() {
+			if other.addr == addr {
+				c.kill(other)
+				return []byte(OkResponse), nil
+			}
+		}
+
+		return nil, ErrNoSuchClient
+	}
+
+	if len(args)%2 != 0 {
+		return nil, ErrSyntax
+	}
+
+	var id, maxAge int64
+	var addr, laddr, typ string
+	skipMe := true
+
+	for i := 0; i < len(args); i += 2 {
+		val := argToString(args[i+1])
+
+		switch strings.ToUpper(argToString(args[i])) {
+		case "ID":
+			n, err := strconv.ParseInt(val, 10, 64)
+			if err != nil || n <= 0 {
+				return nil, ErrClientIDNotPositive
+			}
+			id = n
+		case "ADDR":
+			addr = val
+		case "LADDR":
+			laddr = val
+		case "USER":
+			if val != defaultUser {
+				return nil, noSuchUserErr(val)
+			}
+		case "TYPE":
+			typ = strings.ToLower(val)
+			if !validClientType(typ) {
+				return nil, clientTypeErr(val)
+			}
+		case "SKIPME":
+			switch strings.ToLower(val) {
+			case "yes":
+				skipMe = true
+			case "no":
+				skipMe = false
+			default:
+				return nil, ErrSyntax
+			}
+		case "MAXAGE":
+			n, err := strconv.ParseInt(val, 10, 64)
+			if err != nil {
+				return nil, ErrNotInteger
+			}
+			maxAge = n
+		default:
+			return nil, ErrSyntax
+		}
+	}
+
+	now := time.Now()
+	killed := 0
+	for _, other := range allClients() {
+		switch {
+		case id != 0 && other.id != id,
+			addr != "" && other.addr != addr,
+			laddr != "" && other.laddr != laddr,
+			typ != "" && !other.hasType(typ),
+			maxAge > 0 && now.Sub(other.createdAt) < time.Duration(maxAge)*time.Second,
+			skipMe && other == c:
+			continue
+		}
+
+		c.kill(other)
+		killed++
+	}
+
+	return intResponse(int64(killed)), nil
+}
+
+// kill closes connection of other client, or of c itself once the reply is sent
+func (c *client) kill(other *client) {
+	if other == c {
+		c.closeAfterReply = true
+		return
+	}
+
+	other.close()
+}
@@ -0,0 +1,211 @@
+package main
+
+import (
+	"bufio"
+	"net"
+	"regexp"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+// connect runs a connection like the server does and returns its client side
+func connect(t *testing.T) (net.Conn, *bufio.Reader) {
+	t.Helper()
+
+	server, conn := net.Pipe()
+	t.Cleanup(func() { conn.Close() })
+	go handleConnection(server)
+
+	return conn, bufio.NewReader(conn)
+}
+
+func send(t *testing.T, conn net.Conn, args ...string) {
+	t.Helper()
+
+	req := aggregateResponse(ArrSymbol, len(args), nil)
+	for _, arg := range args {
+		req = append(req, bulkStrResponse([]byte(arg))...)
+	}
+	if _, err := conn.Write(req); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestClientNameAndReply(t *testing.T) {
+	initStorage()
+	conn, r := connect(t)
+
+	for _, step := range []struct {
+		cmd   []string
+		reply string
+	}{
+		{[]string{"CLIENT", "GETNAME"}, NullResponse},
+		{[]string{"CLIENT", "SETNAME", "bad name"}, ErrClientName.Error()},
+		{[]string{"CLIENT", "SETNAME", "app"}, OkResponse},
+		{[]string{"CLIENT", "GETNAME"}, "$3\r\napp\r\n"},
+		{[]string{"CLIENT", "REPLY", "SKIP"}, ""},
+		{[]string{"SET", "k", "1"}, ""},
+		{[]string{"GET", "k"}, "$1\r\n1\r\n"},
+		{[]string{"CLIENT", "REPLY", "OFF"}, ""},
+		{[]string{"SET", "k", "2"}, ""},
+		{[]string{"GET", "nope"}, ""},
+		{[]string{"CLIENT", "REPLY", "ON"}, OkResponse},
+		{[]string{"GET", "k"}, "$1\r\n2\r\n"},
+	} {
+		send(t, conn, step.cmd...)
+		if step.reply != "" {
+			readReply(t, r, step.reply)
+		}
+	}
+}
+
+func TestClientListAndKill(t *testing.T) {
+	initStorage()
+	conn, r := connect(t)
+	other, otherR := connect(t)
+
+	send(t, other, "CLIENT", "SETNAME", "worker")
+	readReply(t, otherR, OkResponse)
+	send(t, other, "MULTI")
+	readReply(t, otherR, OkResponse)
+
+	send(t, conn, "CLIENT", "LIST", "TYPE", "normal")
+	list, err := readBulk(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !regexp.MustCompile(`(?m)^id=\d+ addr=pipe laddr=pipe name=worker age=\d+ idle=\d+ flags=x db=0 sub=0 psub=0 ssub=0 ` +
+		`multi=0 watch=0 qbuf=0 omem=\d+ cmd=multi user=default redir=-1 resp=2$`).MatchString(list) {
+		t.Errorf("CLIENT LIST doesn't show the other client: %q", list)
+	}
+
+	send(t, conn, "CLIENT", "INFO")
+	info, err := readBulk(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(info, "flags=N") || !strings.Contains(info, "cmd=client") {
+		t.Errorf("CLIENT INFO: %q", info)
+	}
+
+	send(t, conn, "CLIENT", "LIST", "TYPE", "pubsub")
+	readReply(t, r, "$0\r\n\r\n")
+	send(t, conn, "CLIENT", "KILL", "USER", "nobody")
+	readReply(t, r, noSuchUserErr("nobody").Error())
+
+	// addr of pipes is the same, so it kills everything except the connection itself
+	send(t, conn, "CLIENT", "KILL", "ADDR", "pipe", "TYPE", "normal")
+	readReply(t, r, ":1\r\n")
+
+	other.SetReadDeadline(time.Now().Add(time.Second))
+	if _, err := otherR.ReadByte(); err == nil {
+		t.Error("killed connection is still open")
+	}
+
+	send(t, conn, "CLIENT", "KILL", "ID", "0")
+	readReply(t, r, ErrClientIDNotPositive.Error())
+	send(t, conn, "CLIENT", "KILL", "SKIPME", "no")
+	readReply(t, r, ":1\r\n")
+	conn.SetReadDeadline(time.Now().Add(time.Second))
+	if _, err := r.ReadByte(); err == nil {
+		t.Error("connection killed itself is still open")
+	}
+}
+
+func TestClientPause(t *testing.T) {
+	initStorage()
+	t.Cleanup(unpauseClients)
+
+	conn, r := connect(t)
+	writer, writerR := connect(t)
+
+	send(t, conn, "CLIENT", "PAUSE", "10000", "WRITE")
+	readReply(t, r, OkResponse)
+
+	// reads go on while writes wait
+	send(t, writer, "SET", "k", "v")
+	send(t, conn, "GET", "k")
+	readReply(t, r, NullResponse)
+
+	send(t, conn, "CLIENT", "UNPAUSE")
+	readReply(t, r, OkResponse)
+	readReply(t, writerR, OkResponse)
+
+	// pause ends by itself
+	send(t, conn, "CLIENT", "PAUSE", "50")
+	readReply(t, r, OkResponse)
+	start := time.Now()
+	send(t, writer, "GET", "k")
+	readReply(t, writerR, "$1\r\nv\r\n")
+	if time.Since(start) < 40*time.Millisecond {
+		t.Error("GET wasn't paused")
+	}
+}
+
+// Killed client blocked on keys or by a pause stops waiting at once
+func TestClientKillBlocked(t *testing.T) {
+	initStorage()
+	t.Cleanup(unpauseClients)
+
+	conn, r := connect(t)
+	var ids []int64
+	for _, cmd := range [][]string{
+		{"XREAD", "BLOCK", "0", "STREAMS", "s", "$"},
+		{"SET", "k", "v"},
+	} {
+		blocked, blockedR := connect(t)
+		send(t, blocked, "CLIENT", "ID")
+		line, err := blockedR.ReadString('\n')
+		if err != nil {
+			t.Fatal(err)
+		}
+		id, _ := strconv.ParseInt(strings.TrimSpace(line[1:]), 10, 64)
+		ids = append(ids, id)
+
+		if cmd[0] == "SET" {
+			send(t, conn, "CLIENT", "PAUSE", "100000", "WRITE")
+			readReply(t, r, OkResponse)
+		}
+		send(t, blocked, cmd...)
+	}
+
+	for deadline := time.Now().Add(5 * time.Second); ; time.Sleep(time.Millisecond) {
+		kvs.mu.Lock()
+		waiting := len(kvs.keyWaiters["s"])
+		kvs.mu.Unlock()
+		if waiting > 0 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("XREAD hasn't blocked")
+		}
+	}
+
+	for _, id := range ids {
+		send(t, conn, "CLIENT", "KILL", "ID", strconv.FormatInt(id, 10))
+		readReply(t, r, ":1\r\n")
+	}
+
+	for deadline := time.Now().Add(5 * time.Second); ; time.Sleep(time.Millisecond) {
+		kvs.mu.Lock()
+		waiting := len(kvs.keyWaiters["s"])
+		kvs.mu.Unlock()
+		if waiting == 0 && clientByID(ids[0]) == nil && clientByID(ids[1]) == nil {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("killed clients are still blocked")
+		}
+	}
+}
+
+func readBulk(r *bufio.Reader) (string, error) {
+	if b, err := r.ReadByte(); err != nil || b != BulkStrSymbol {
+		return "", ErrInvalidRESP
+	}
+	s, err := (&respReader{reader: r}).readBulkString()
+
+	return string(s), err
+}
@@ -0,0 +1,174 @@
+package main
+
+import (
+	"strings"
+)
+
+// lookupCMS returns Count-Min sketch stored at key. Unlike filters sketches are never created implicitly
+func lookupCMS(key string) (*countMinSketch, error) {
+	val, ok := lookupKey(key)
+	if !ok {
+		return nil, ErrCMSKeyNotExist
+	}
+
+	if val.dtype != CMSDtype {
+		return nil, ErrWrongType
+	}
+
+	return val.object.(*countMinSketch), nil
+}
+
+func cmsCreate(key string, width, depth uint64, event string) ([]byte, error) {
+	if _, ok := kvs.storage.Get(key); ok {
+		return nil, ErrCMSKeyExists
+	}
+
+	storeKey(key, &KvsValue{dtype: CMSDtype, object: newCountMinSketch(width, depth)})
+	signalModifiedKey(key, notifyModule, event)
+
+	return []byte(OkResponse), nil
+}
+
+// CMS.INITBYDIM key width depth
+func cmsInitbydimHandler(args []*KvsValue) ([]byte, error) {
+	width, err := argToInt64(args[1])
+	if err != nil || width < 1 {
+		return nil, ErrCMSInvalidWidth
+	}
+
+	depth, err := argToInt64(args[2])
+	if err != nil || depth < 1 {
+		return nil, ErrCMSInvalidDepth
+	}
+
+	return cmsCreate(argToString(args[0]), uint64(width), uint64(depth), "cms.initbydim")
+}
+
+// CMS.INITBYPROB key error probability
+func cmsInitbyprobHandler(args []*KvsValue) ([]byte, error) {
+	errorRate, err := argToFloat64(args[1])
+	if err != nil || errorRate <= 0 || errorRate >= 1 {
+		return nil, ErrCMSInvalidError
+	}
+
+	probability, err := argToFloat64(args[2])
+	if err != nil || probability <= 0 || probability >= 1 {
+		return nil, ErrCMSInvalidProb
+	}
+
+	width, depth := cmsDimsByProb(errorRate, probability)
+
+	return cmsCreate(argToString(args[0]), width, depth, "cms.initbyprob")
+}
+
+// CMS.INCRBY key item increment [item increment ...]
+func cmsIncrbyHandler(args []*KvsValue) ([]byte, error) {
+	if len(args)%2 != 1 {
+		return nil, wrongArgsCountErr("CMS.INCRBY")
+	}
+
+	key := argToString(args[0])
+
+	s, err := lookupCMS(key)
+	if err != nil {
+		return nil, err
+	}
+
+	// increments are validated before touching the sketch, so the command is applied entirely or not at all
+	incrs := make([]uint64, 0, len(args)/2)
+	for i := 2; i < len(args); i += 2 {
+		incr, err := argToInt64(args[i])
+		if err != nil || incr < 0 {
+			return nil, ErrCMSInvalidIncr
+		}
+		incrs = append(incrs, uint64(incr))
+	}
+
+	res := make([][]byte, len(incrs))
+	for i, incr := range incrs {
+		res[i] = intResponse(int64(s.incrBy([]byte(argToString(args[1+2*i])), incr)))
+	}
+	signalModifiedKey(key, notifyModule, "cms.incrby")
+
+	return arrayResponse(res...), nil
+}
+
+// CMS.QUERY key item [item ...]
+func cmsQueryHandler(args []*KvsValue) ([]byte, error) {
+	s, err := lookupCMS(argToString(args[0]))
+	if err != nil {
+		return nil, err
+	}
+
+	res := make([][]byte, len(args)-1)
+	for i, arg := range args[1:] {
+		res[i] = intResponse(int64(s.query([]byte(argToString(arg)))))
+	}
+
+	return arrayResponse(res...), nil
+}
+
+// CMS.MERGE destination numKeys source [source ...] [WEIGHTS weight [weight ...]]
+func cmsMergeHandler(args []*KvsValue) ([]byte, error) {
+	destKey := argToString(args[0])
+
+	dest, err := lookupCMS(destKey)
+	if err != nil {
+		return nil, err
+	}
+
+	numKeys, err := argToInt64(args[1])
+	if err != nil || numKeys < 1 || numKeys > int64(len(args)-2) {
+		return nil, ErrCMSInvalidNumKeys
+	}
+
+	sources := make([]*countMinSketch, numKeys)
+	for i := range sources {
+		if sources[i], err = lookupCMS(argToString(args[2+i])); err != nil {
+			return nil, err
+		}
+
+		if sources[i].width != dest.width || sources[i].depth != dest.depth {
+			return nil, ErrCMSDimsMismatch
+		}
+	}
+
+	weights := make([]uint64, numKeys)
+	for i := range weights {
+		weights[i] = 1
+	}
+
+	rest := args[2+numKeys:]
+	if len(rest) > 0 {
+		if strings.ToUpper(argToString(rest[0])) != "WEIGHTS" || int64(len(rest)-1) != numKeys {
+			return nil, ErrSyntax
+		}
+
+		for i, arg := range rest[1:] {
+			w, err := argToInt64(arg)
+			if err != nil || w < 0 {
+				return nil, ErrNotInteger
+			}
+			weights[i] = uint64(w)
+		}
+	}
+
+	dest.merge(sources, weights)
+	signalModifiedKey(destKey, notifyModule, "cms.merge")
+
+	return []byte(OkResponse), nil
+}
+
+// CMS.INFO key
+func cmsInfoHandler(args []*KvsValue) ([]byte, error) {
+	s, err := lookupCMS(argToString(args[0]))
+	if err != nil {
+		return nil, err
+	}
+
+	return arrayResponse(
+		bulkStrResponse([]byte("width")), intResponse(int64(s.width)),
+		bulkStrResponse([]byte("depth")), intResponse(int64(s.depth)),
+		bulkStrResponse([]byte("count")), intResponse(int64(s.count)),
+	), nil
+}
@@ -0,0 +1,237 @@
+package main
+
+import (
+	"strings"
+	"time"
+)
+
+// handler is called with kvs.mu held and returns already encoded RESP reply
+type cmdHandler func(args []*KvsValue) ([]byte, error)
+
+type command struct {
+	name string
+	// arity counts command name too. Negative arity means "at least -arity elements"
+	arity   int
+	handler cmdHandler
+	// command may modify the keyspace
+	write bool
+	// command can't be called from scripts and functions
+	noScript bool
+}
+
+var commandTable = map[string]*command{}
+
+func init() {
+	for _, cmd := range []*command{
+		{name: "COMMAND", arity: -1, handler: commandHandler},
+		{name: "SET", arity: 3, handler: setHandler, write: true},
+		{name: "GET", arity: 2, handler: getHandler},
+		{name: "DELETE", arity: 2, handler: deleteHandler, write: true},
+		{name: "XADD", arity: -5, handler: xaddHandler, write: true},
+		{name: "XRANGE", arity: -4, handler: xrangeHandler},
+		{name: "XREVRANGE", arity: -4, handler: xrevrangeHandler},
+		{name: "XLEN", arity: 2, handler: xlenHandler},
+		{name: "XDEL", arity: -3, handler: xdelHandler, write: true},
+		{name: "XTRIM", arity: -4, handler: xtrimHandler, write: true},
+		{name: "XREAD", arity: -4, handler: xreadHandler},
+		{name: "XGROUP", arity: -2, handler: xgroupHandler, write: true},
+		{name: "XREADGROUP", arity: -7, handler: xreadgroupHandler, write: true},
+		{name: "XACK", arity: -4, handler: xackHandler, write: true},
+		{name: "XPENDING", arity: -3, handler: xpendingHandler},
+		{name: "XCLAIM", arity: -6, handler: xclaimHandler, write: true},
+		{name: "XAUTOCLAIM", arity: -6, handler: xautoclaimHandler, write: true},
+		{name: "XINFO", arity: -2, handler: xinfoHandler},
+		{name: "PFADD", arity: -2, handler: pfaddHandler, write: true},
+		{name: "PFCOUNT", arity: -2, handler: pfcountHandler},
+		{name: "PFMERGE", arity: -2, handler: pfmergeHandler, write: true},
+		{name: "GEOADD", arity: -5, handler: geoaddHandler, write: true},
+		{name: "GEODIST", arity: -4, handler: geodistHandler},
+		{name: "GEOPOS", arity: -2, handler: geoposHandler},
+		{name: "GEOHASH", arity: -2, handler: geohashHandler},
+		{name: "GEOSEARCH", arity: -7, handler: geosearchHandler},
+		{name: "GEOSEARCHSTORE", arity: -8, handler: geosearchstoreHandler, write: true},
+		{name: "JSON.SET", arity: -4, handler: jsonSetHandler, write: true},
+		{name: "JSON.GET", arity: -2, handler: jsonGetHandler},
+		{name: "JSON.DEL", arity: -2, handler: jsonDelHandler, write: true},
+		{name: "JSON.FORGET", arity: -2, handler: jsonDelHandler, write: true},
+		{name: "JSON.TYPE", arity: -2, handler: jsonTypeHandler},
+		{name: "JSON.NUMINCRBY", arity: 4, handler: jsonNumincrbyHandler, write: true},
+		{name: "JSON.STRAPPEND", arity: -3, handler: jsonStrappendHandler, write: true},
+		{name: "JSON.ARRAPPEND", arity: -4, handler: jsonArrappendHandler, write: true},
+		{name: "JSON.ARRINSERT", arity: -5, handler: jsonArrinsertHandler, write: true},
+		{name: "JSON.ARRPOP", arity: -2, handler: jsonArrpopHandler, write: true},
+		{name: "JSON.ARRTRIM", arity: 5, handler: jsonArrtrimHandler, write: true},
+		{name: "JSON.OBJKEYS", arity: -2, handler: jsonObjkeysHandler},
+		{name: "JSON.MGET", arity: -3, handler: jsonMgetHandler},
+		{name: "BF.RESERVE", arity: -4, handler: bfReserveHandler, write: true},
+		{name: "BF.ADD", arity: 3, handler: bfAddHandler, write: true},
+		{name: "BF.MADD", arity: -3, handler: bfMaddHandler, write: true},
+		{name: "BF.EXISTS", arity: 3, handler: bfExistsHandler},
+		{name: "BF.MEXISTS", arity: -3, handler: bfMexistsHandler},
+		{name: "BF.CARD", arity: 2, handler: bfCardHandler},
+		{name: "CF.RESERVE", arity: -3, handler: cfReserveHandler, write: true},
+		{name: "CF.ADD", arity: 3, handler: cfAddHandler, write: true},
+		{name: "CF.ADDNX", arity: 3, handler: cfAddnxHandler, write: true},
+		{name: "CF.EXISTS", arity: 3, handler: cfExistsHandler},
+		{name: "CF.MEXISTS", arity: -3, handler: cfMexistsHandler},
+		{name: "CF.DEL", arity: 3, handler: cfDelHandler, write: true},
+		{name: "CF.COUNT", arity: 3, handler: cfCountHandler},
+		{name: "CMS.INITBYDIM", arity: 4, handler: cmsInitbydimHandler, write: true},
+		{name: "CMS.INITBYPROB", arity: 4, handler: cmsInitbyprobHandler, write: true},
+		{name: "CMS.INCRBY", arity: -4, handler: cmsIncrbyHandler, write: true},
+		{name: "CMS.QUERY", arity: -3, handler: cmsQueryHandler},
+		{name: "CMS.MERGE", arity: -4, handler: cmsMergeHandler, write: true},
+		{name: "CMS.INFO", arity: 2, handler: cmsInfoHandler},
+		{name: "TOPK.RESERVE", arity: -3, handler: topkReserveHandler, write: true},
+		{name: "TOPK.ADD", arity: -3, handler: topkAddHandler, write: true},
+		{name: "TOPK.QUERY", arity: -3, handler: topkQueryHandler},
+		{name: "TOPK.LIST", arity: -2, handler: topkListHandler},
+		{name: "TS.CREATE", arity: -2, handler: tsCreateHandler, write: true},
+		{name: "TS.ADD", arity: -4, handler: tsAddHandler, write: true},
+		{name: "TS.MADD", arity: -4, handler: tsMaddHandler, write: true},
+		{name: "TS.GET", arity: 2, handler: tsGetHandler},
+		{name: "TS.RANGE", arity: -4, handler: tsRangeHandler},
+		{name: "TS.REVRANGE", arity: -4, handler: tsRevrangeHandler},
+		{name: "TS.MRANGE", arity: -5, handler: tsMrangeHandler},
+		{name: "TS.MREVRANGE", arity: -5, handler: tsMrevrangeHandler},
+		{name: "TS.CREATERULE", arity: 6, handler: tsCreateruleHandler, write: true},
+		{name: "TS.DELETERULE", arity: 3, handler: tsDeleteruleHandler, write: true},
+		{name: "VADD", arity: -5, handler: vaddHandler, write: true},
+		{name: "VSIM", arity: -4, handler: vsimHandler},
+		{name: "VREM", arity: 3, handler: vremHandler, write: true},
+		{name: "VCARD", arity: 2, handler: vcardHandler},
+		{name: "VDIM", arity: 2, handler: vdimHandler},
+		{name: "VINFO", arity: 2, handler: vinfoHandler},
+		{name: "HSET", arity: -4, handler: hsetHandler, write: true},
+		{name: "HGET", arity: 3, handler: hgetHandler},
+		{name: "HMGET", arity: -3, handler: hmgetHandler},
+		{name: "HDEL", arity: -3, handler: hdelHandler, write: true},
+		{name: "HGETALL", arity: 2, handler: hgetallHandler},
+		{name: "HLEN", arity: 2, handler: hlenHandler},
+		{name: "HEXISTS", arity: 3, handler: hexistsHandler},
+		{name: "FT.CREATE", arity: -4, handler: ftCreateHandler, write: true},
+		{name: "FT.DROPINDEX", arity: -2, handler: ftDropindexHandler, write: true},
+		{name: "FT._LIST", arity: 1, handler: ftListHandler},
+		{name: "FT.INFO", arity: 2, handler: ftInfoHandler},
+		{name: "FT.SEARCH", arity: -3, handler: ftSearchHandler},
+		{name: "FT.AGGREGATE", arity: -3, handler: ftAggregateHandler},
+		{name: "THROTTLE", arity: -5, handler: throttleHandler, write: true},
+		{name: "GRAPH.QUERY", arity: 3, handler: graphQueryHandler, write: true},
+		{name: "GRAPH.EXPLAIN", arity: 3, handler: graphExplainHandler},
+		{name: "GRAPH.DELETE", arity: 2, handler: graphDeleteHandler, write: true},
+		{name: "DBSIZE", arity: 1, handler: dbsizeHandler},
+		{name: "FLUSHALL", arity: -1, handler: flushallHandler, write: true},
+		{name: "FLUSHDB", arity: -1, handler: flushallHandler, write: true},
+		{name: "PING", arity: -1, handler: pingHandler},
+		{name: "PUBLISH", arity: 3, handler: publishHandler},
+		{name: "SPUBLISH", arity: 3, handler: spublishHandler},
+		{name: "PUBSUB", arity: -2, handler: pubsubHandler},
+		{name: "CONFIG", arity: -2, handler: configHandler},
+		{name: "SAVE", arity: 1, handler: saveHandler, noScript: true},
+		{name: "BGSAVE", arity: -1, handler: bgsaveHandler, noScript: true},
+		{name: "LASTSAVE", arity: 1, handler: lastsaveHandler},
+		{name: "BGREWRITEAOF", arity: 1, handler: bgrewriteaofHandler, noScript: true},
+		{name: "RENAME", arity: 3, handler: renameHandler, write: true},
+		{name: "RENAMENX", arity: 3, handler: renamenxHandler, write: true},
+		{name: "DUMP", arity: 2, handler: dumpHandler},
+		{name: "RESTORE", arity: -4, handler: restoreHandler, write: true},
+		{name: "COPY", arity: -3, handler: copyHandler, write: true},
+		{name: "MEMORY", arity: -2, handler: memoryHandler},
+		{name: "EVAL", arity: -3, handler: evalHandler, write: true},
+		{name: "EVALSHA", arity: -3, handler: evalshaHandler, write: true},
+		{name: "EVAL_RO", arity: -3, handler: evalRoHandler},
+		{name: "EVALSHA_RO", arity: -3, handler: evalshaRoHandler},
+		{name: "SCRIPT", arity: -2, handler: scriptHandler},
+		{name: "FCALL", arity: -3, handler: fcallHandler, write: true},
+		{name: "FCALL_RO", arity: -3, handler: fcallRoHandler},
+		{name: "FUNCTION", arity: -2, handler: functionHandler},
+	} {
+		commandTable[cmd.name] = cmd
+	}
+}
+
+func lookupCommand(name string) (*command, bool) {
+	cmd, ok := commandTable[strings.ToUpper(name)]
+	return cmd, ok
+}
+
+func checkArity(cmd *command, args []*KvsValue) error {
+	elemCount := len(args) + 1
+
+	if (cmd.arity > 0 && elemCount != cmd.arity) || (cmd.arity < 0 && elemCount < -cmd.arity) {
+		return wrongArgsCountErr(cmd.name)
+	}
+
+	return nil
+}
+
+func execCommand(c *client, cmd *command, args []*KvsValue) (res []byte, err error) {
+	if err := checkArity(cmd, args); err != nil {
+		return nil, err
+	}
+
+	if err := lockKvs(); err != nil {
+		return nil, err
+	}
+	defer kvs.mu.Unlock()
+
+	commandTime.Store(time.Now().UnixMilli())
+	expireDueKeys(nowMs())
+
+	kvs.cmd, kvs.client = cmd, c
+	defer func() {
+		if aofErr := flushAOF(); aofErr != nil {
+			res, err = nil, aofErr
+		}
+		commandTime.Store(0)
+		kvs.cmd, kvs.client = nil, nil
+		if c != nil {
+			c.tracking.cachingSet = false
+		}
+	}()
+
+	return call(cmd, args)
+}
+
+// call runs handler of cmd and logs the write command to the AOF if it has changed anything itself, changes
+// made by others while it was blocked don't count. Scripts are logged as commands they run. Must be called
+// with kvs.mu held. Nothing is logged when the storage engine fails the batch
+func call(cmd *command, args []*KvsValue) ([]byte, error) {
+	if cmd.write {
+		if err := aofWriteErr(); err != nil {
+			return nil, err
+		}
+	}
+
+	dirty, others := kvs.dirty, kvs.dirtyWhileBlocked
+	var res []byte
+	var err error
+	engineErr := batch(func() { res, err = cmd.handler(args) })
+	if engineErr != nil && err == nil {
+		res, err = nil, storageEngineErr(engineErr.Error())
+	}
+	changed := kvs.dirty - dirty - (kvs.dirtyWhileBlocked - others)
+	if cmd.write && changed > 0 && engineErr == nil && !scriptForbidden[cmd.name] {
+		propagate(cmd.name, args)
+	}
+
+	return res, err
+}
+
+// COMMAND exists just for correct connection through redis-cli
+func commandHandler(args []*KvsValue) ([]byte, error) {
+	return []byte(OkResponse), nil
+}
+
+// PING [message]
+func pingHandler(args []*KvsValue) ([]byte, error) {
+	if len(args) > 1 {
+		return nil, wrongArgsCountErr("PING")
+	}
+
+	if len(args) == 1 {
+		return bulkStrResponse([]byte(argToString(args[0]))), nil
+	}
+
+	return []byte(PongResponse), nil
+}
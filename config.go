@@ -0,0 +1,334 @@
+package main
+
+import (
+	"math"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// Runtime settings changed with CONFIG SET. They are read and changed with kvs.mu held
+var config = struct {
+	notifyKeyspaceEvents int
+	busyReplyThreshold   int64 // ms
+	functionFuelLimit    int64
+	// directory of persisted files
+	dir              string
+	saveRules        []saveRule
+	dbFilename       string
+	appendOnly       bool
+	appendFsync      int
+	appendFilename   string
+	appendDirname    string
+	aofLoadTruncated bool
+	// automatic AOF rewrite starts when AOF has grown by the percentage since the last rewrite and is
+	// at least the min size in bytes, percentage 0 turns it off
+	autoAOFRewritePercentage int64
+	autoAOFRewriteMinSize    int64
+	// name of the storage engine, it's chosen on start
+	storageEngine string
+}{
+	busyReplyThreshold: scriptDefaultBusyThreshold,
+	functionFuelLimit:  functionDefaultFuelLimit,
+	dir:                ".",
+	saveRules:          defaultSaveRules,
+	dbFilename:         "dump.kdb",
+	appendFsync:        fsyncEverysec,
+	appendFilename:     "appendonly.aof",
+	appendDirname:      "appendonlydir",
+	aofLoadTruncated:   true,
+
+	autoAOFRewritePercentage: 100,
+	autoAOFRewriteMinSize:    64 << 20,
+	storageEngine:            defaultStorageEngine,
+}
+
+type configParam struct {
+	name string
+	get  func() string
+	set  func(val string) error
+}
+
+var configParams = map[string]*configParam{}
+
+func init() {
+	for _, p := range []*configParam{
+		{
+			name: "notify-keyspace-events",
+			get:  func() string { return notifyFlagsString(config.notifyKeyspaceEvents) },
+			set: func(val string) error {
+				flags, ok := parseNotifyFlags(val)
+				if !ok {
+					return configInvalidArgErr("notify-keyspace-events", val)
+				}
+				config.notifyKeyspaceEvents = flags
+				return nil
+			},
+		},
+		{
+			name: "busy-reply-threshold",
+			get:  func() string { return strconv.FormatInt(config.busyReplyThreshold, 10) },
+			set: func(val string) error {
+				ms, err := strconv.ParseInt(val, 10, 64)
+				if err != nil || ms < 0 {
+					return configInvalidArgErr("busy-reply-threshold", val)
+				}
+				config.busyReplyThreshold = ms
+				return nil
+			},
+		},
+		{
+			name: "function-fuel-limit",
+			get:  func() string { return strconv.FormatInt(config.functionFuelLimit, 10) },
+			set: func(val string) error {
+				fuel, err := strconv.ParseInt(val, 10, 64)
+				if err != nil || fuel <= 0 {
+					return configInvalidArgErr("function-fuel-limit", val)
+				}
+				config.functionFuelLimit = fuel
+				return nil
+			},
+		},
+		{
+			name: "dir",
+			get:  func() string { return config.dir },
+			set: func(val string) error {
+				if info, err := os.Stat(val); err != nil || !info.IsDir() {
+					return configInvalidArgErr("dir", val)
+				}
+				config.dir = val
+				return nil
+			},
+		},
+		{
+			name: "save",
+			get:  func() string { return formatSaveRules(config.saveRules) },
+			set: func(val string) error {
+				rules, ok := parseSaveRules(val)
+				if !ok {
+					return configInvalidArgErr("save", val)
+				}
+				config.saveRules = rules
+				return nil
+			},
+		},
+		{
+			name: "dbfilename",
+			get:  func() string { return config.dbFilename },
+			set: func(val string) error {
+				if val == "" || strings.ContainsAny(val, `/\`) {
+					return configInvalidArgErr("dbfilename", val)
+				}
+				config.dbFilename = val
+				return nil
+			},
+		},
+		{
+			name: "appendonly",
+			get:  func() string { return yesNo(config.appendOnly) },
+			set: func(val string) error {
+				on, ok := parseYesNo(val)
+				switch {
+				case !ok:
+					return configInvalidArgErr("appendonly", val)
+				case on && !config.appendOnly:
+					if err := enableAOF(); err != nil {
+						return aofRewriteErr(err.Error())
+					}
+				case !on:
+					closeAOF()
+				}
+				config.appendOnly = on
+				return nil
+			},
+		},
+		{
+			name: "appendfsync",
+			get:  func() string { return fsyncPolicyNames[config.appendFsync] },
+			set: func(val string) error {
+				policy, ok := parseFsyncPolicy(val)
+				if !ok {
+					return configInvalidArgErr("appendfsync", val)
+				}
+				config.appendFsync = policy
+				return nil
+			},
+		},
+		{
+			name: "appendfilename",
+			get:  func() string { return config.appendFilename },
+			set: func(val string) error {
+				if val != config.appendFilename {
+					return configImmutableErr("appendfilename")
+				}
+				return nil
+			},
+		},
+		{
+			name: "appenddirname",
+			get:  func() string { return config.appendDirname },
+			set: func(val string) error {
+				if val != config.appendDirname {
+					return configImmutableErr("appenddirname")
+				}
+				return nil
+			},
+		},
+		{
+			name: "storage-engine",
+			get:  func() string { return config.storageEngine },
+			set: func(val string) error {
+				if val != config.storageEngine {
+					return configImmutableErr("storage-engine")
+				}
+				return nil
+			},
+		},
+		{
+			name: "auto-aof-rewrite-percentage",
+			get:  func() string { return strconv.FormatInt(config.autoAOFRewritePercentage, 10) },
+			set: func(val string) error {
+				pct, err := strconv.ParseInt(val, 10, 64)
+				if err != nil || pct < 0 {
+					return configInvalidArgErr("auto-aof-rewrite-percentage", val)
+				}
+				config.autoAOFRewritePercentage = pct
+				return nil
+			},
+		},
+		{
+			name: "auto-aof-rewrite-min-size",
+			get:  func() string { return strconv.FormatInt(config.autoAOFRewriteMinSize, 10) },
+			set: func(val string) error {
+				size, ok := parseMemory(val)
+				if !ok {
+					return configInvalidArgErr("auto-aof-rewrite-min-size", val)
+				}
+				config.autoAOFRewriteMinSize = size
+				return nil
+			},
+		},
+		{
+			name: "aof-load-truncated",
+			get:  func() string { return yesNo(config.aofLoadTruncated) },
+			set: func(val string) error {
+				on, ok := parseYesNo(val)
+				if !ok {
+					return configInvalidArgErr("aof-load-truncated", val)
+				}
+				config.aofLoadTruncated = on
+				return nil
+			},
+		},
+	} {
+		configParams[p.name] = p
+	}
+
+	// old name of busy-reply-threshold
+	configParams["lua-time-limit"] = configParams["busy-reply-threshold"]
+}
+
+func yesNo(b bool) string {
+	if b {
+		return "yes"
+	}
+
+	return "no"
+}
+
+func parseYesNo(s string) (bool, bool) {
+	switch strings.ToLower(s) {
+	case "yes":
+		return true, true
+	case "no":
+		return false, true
+	}
+
+	return false, false
+}
+
+// parseMemory parses size in bytes with optional unit: k, kb, m, mb, g or gb. Units without b are powers
+// of 1000, with b powers of 1024
+func parseMemory(s string) (int64, bool) {
+	s = strings.ToLower(s)
+	mul := int64(1)
+	for _, unit := range []struct {
+		suffix string
+		mul    int64
+	}{
+		{"kb", 1 << 10}, {"mb", 1 << 20}, {"gb", 1 << 30}, {"k", 1e3}, {"m", 1e6}, {"g", 1e9}, {"b", 1},
+	} {
+		if strings.HasSuffix(s, unit.suffix) {
+			s, mul = strings.TrimSuffix(s, unit.suffix), unit.mul
+			break
+		}
+	}
+
+	n, err := strconv.ParseInt(s, 10, 64)
+	if err != nil || n < 0 || n > math.MaxInt64/mul {
+		return 0, false
+	}
+
+	return n * mul, true
+}
+
+// CONFIG GET pattern [pattern ...] | SET parameter value [parameter value ...]
+func configHandler(args []*KvsValue) ([]byte, error) {
+	sub := strings.ToUpper(argToString(args[0]))
+
+	switch sub {
+	case "GET":
+		if len(args) < 2 {
+			return nil, wrongArgsCountErr("CONFIG|GET")
+		}
+
+		var names []string
+		for _, arg := range args[1:] {
+			pattern := strings.ToLower(argToString(arg))
+			for name := range configParams {
+				if globMatch(pattern, name) && !slices.Contains(names, name) {
+					names = append(names, name)
+				}
+			}
+		}
+		slices.Sort(names)
+
+		res := make([][]byte, 0, 2*len(names))
+		for _, name := range names {
+			res = append(res, []byte(name), []byte(configParams[name].get()))
+		}
+
+		return bulkStrArrayResponse(res...), nil
+	case "SET":
+		if len(args) < 3 || len(args)%2 == 0 {
+			return nil, wrongArgsCountErr("CONFIG|SET")
+		}
+
+		// all values are checked before anything is changed
+		params := make([]*configParam, 0, len(args)/2)
+		for i := 1; i < len(args); i += 2 {
+			name := strings.ToLower(argToString(args[i]))
+			p, ok := configParams[name]
+			if !ok {
+				return nil, configUnknownParamErr(name)
+			}
+			params = append(params, p)
+		}
+
+		prev := make([]string, len(params))
+		for i, p := range params {
+			prev[i] = p.get()
+			if err := p.set(argToString(args[2*i+2])); err != nil {
+				for j := i - 1; j >= 0; j-- {
+					params[j].set(prev[j])
+				}
+				return nil, err
+			}
+		}
+
+		return []byte(OkResponse), nil
+	}
+
+	return nil, ErrUnknownSubcommand
+}
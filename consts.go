@@ -1,3 +1,6 @@
 package main
 
 const CRLF = "\r\n"
+
+// KvsVersion is reported by HELLO
+const KvsVersion = "1.0.0"
@@ -0,0 +1,99 @@
+package main
+
+import (
+	"math"
+)
+
+// Count-Min sketch is depth rows of width counters. Every row uses its own hash of an item, count of an item
+// is the minimum of its counters, so it can only be overestimated because of collisions
+type countMinSketch struct {
+	width    uint64
+	depth    uint64
+	counters []uint64
+	count    uint64
+}
+
+func newCountMinSketch(width, depth uint64) *countMinSketch {
+	return &countMinSketch{width: width, depth: depth, counters: make([]uint64, width*depth)}
+}
+
+// cmsDimsByProb returns dimensions with which an estimate exceeds true count by more than
+// errorRate of total count only with given probability
+func cmsDimsByProb(errorRate, probability float64) (width, depth uint64) {
+	width = uint64(math.Ceil(2 / errorRate))
+	depth = uint64(math.Ceil(math.Log10(probability) / math.Log10(0.5)))
+
+	return width, max(depth, 1)
+}
+
+func (s *countMinSketch) index(item []byte, row uint64) uint64 {
+	return row*s.width + murmurHash64A(item, row)%s.width
+}
+
+// incrBy adds incr to counters of item and returns new count of item
+func (s *countMinSketch) incrBy(item []byte, incr uint64) uint64 {
+	res := uint64(math.MaxUint64)
+
+	for row := range s.depth {
+		i := s.index(item, row)
+		s.counters[i] += incr
+		res = min(res, s.counters[i])
+	}
+
+	s.count += incr
+
+	return res
+}
+
+func (s *countMinSketch) query(item []byte) uint64 {
+	res := uint64(math.MaxUint64)
+
+	for row := range s.depth {
+		res = min(res, s.counters[s.index(item, row)])
+	}
+
+	return res
+}
+
+// merge replaces sketch content with weighted sum of sources. All sketches must have the same dimensions
+func (s *countMinSketch) merge(sources []*countMinSketch, weights []uint64) {
+	counters := make([]uint64, len(s.counters))
+	var count uint64
+
+	for i, src := range sources {
+		for j, c := range src.counters {
+			counters[j] += c * weights[i]
+		}
+		count += src.count * weights[i]
+	}
+
+	s.counters = counters
+	s.count = count
+}
+
+func (s *countMinSketch) dump(b []byte) []byte {
+	b = appendU64(b, s.width)
+	b = appendU64(b, s.depth)
+	b = appendU64(b, s.count)
+	for _, c := range s.counters {
+		b = appendU64(b, c)
+	}
+
+	return b
+}
+
+func restoreCountMinSketch(r *dumpReader) *countMinSketch {
+	width, depth, count := r.u64(), r.u64(), r.u64()
+	r.check(width > 0 && depth > 0 && width*depth/depth == width && width*depth == uint64(len(r.data))/8)
+	if r.failed {
+		return nil
+	}
+
+	s := newCountMinSketch(width, depth)
+	s.count = count
+	for i := range s.counters {
+		s.counters[i] = r.u64()
+	}
+
+	return s
+}
@@ -0,0 +1,46 @@
+package main
+
+import (
+	"strconv"
+	"testing"
+)
+
+func TestCountMinSketchNeverUnderestimates(t *testing.T) {
+	s := newCountMinSketch(50, 4)
+
+	for i := range 200 {
+		s.incrBy([]byte(strconv.Itoa(i)), uint64(i%7+1))
+	}
+
+	for i := range 200 {
+		if res := s.query([]byte(strconv.Itoa(i))); res < uint64(i%7+1) {
+			t.Errorf("query(%d) = %v, expected at least %v", i, res, i%7+1)
+		}
+	}
+}
+
+func TestCountMinSketchMerge(t *testing.T) {
+	a := newCountMinSketch(100, 5)
+	b := newCountMinSketch(100, 5)
+	a.incrBy([]byte("x"), 3)
+	b.incrBy([]byte("x"), 4)
+
+	dest := newCountMinSketch(100, 5)
+	dest.merge([]*countMinSketch{a, b}, []uint64{1, 2})
+
+	if res := dest.query([]byte("x")); res != 11 {
+		t.Errorf("query() after merge = %v, expected: 11", res)
+	}
+
+	if dest.count != 11 {
+		t.Errorf("count after merge = %v, expected: 11", dest.count)
+	}
+}
+
+func TestCMSDimsByProb(t *testing.T) {
+	width, depth := cmsDimsByProb(0.001, 0.01)
+
+	if width != 2000 || depth != 7 {
+		t.Errorf("cmsDimsByProb(0.001, 0.01) = %v, %v, expected: 2000, 7", width, depth)
+	}
+}
@@ -0,0 +1,181 @@
+package main
+
+import (
+	"strings"
+)
+
+// lookupCuckoo returns Cuckoo filter stored at key or nil if key doesn't exist
+func lookupCuckoo(key string) (*cuckooFilter, error) {
+	val, ok := lookupKey(key)
+	if !ok {
+		return nil, nil
+	}
+
+	if val.dtype != CuckooDtype {
+		return nil, ErrWrongType
+	}
+
+	return val.object.(*cuckooFilter), nil
+}
+
+// lookupCuckooCreate returns filter stored at key creating one with default parameters if needed
+func lookupCuckooCreate(key string) (*cuckooFilter, error) {
+	cf, err := lookupCuckoo(key)
+	if err != nil || cf != nil {
+		return cf, err
+	}
+
+	cf = newCuckooFilter(cuckooDefaultCapacity, cuckooDefaultBucketSize, cuckooDefaultMaxIter, cuckooDefaultExpansion)
+	storeKey(key, &KvsValue{dtype: CuckooDtype, object: cf})
+
+	return cf, nil
+}
+
+// CF.RESERVE key capacity [BUCKETSIZE bucketsize] [MAXITERATIONS maxiterations] [EXPANSION expansion]
+func cfReserveHandler(args []*KvsValue) ([]byte, error) {
+	key := argToString(args[0])
+
+	capacity, err := argToInt64(args[1])
+	if err != nil || capacity <= 0 {
+		return nil, ErrCuckooCapacity
+	}
+
+	bucketSize := int64(cuckooDefaultBucketSize)
+	maxIter := int64(cuckooDefaultMaxIter)
+	expansion := int64(cuckooDefaultExpansion)
+
+	for i := 2; i < len(args); i += 2 {
+		if i+1 >= len(args) {
+			return nil, ErrSyntax
+		}
+
+		n, err := argToInt64(args[i+1])
+		if err != nil {
+			return nil, err
+		}
+
+		switch strings.ToUpper(argToString(args[i])) {
+		case "BUCKETSIZE":
+			if n < 1 || n > cuckooMaxBucketSize {
+				return nil, ErrCuckooBucketSize
+			}
+			bucketSize = n
+		case "MAXITERATIONS":
+			if n < 1 || n > 65535 {
+				return nil, ErrCuckooMaxIter
+			}
+			maxIter = n
+		case "EXPANSION":
+			if n < 0 || n > 32768 {
+				return nil, ErrCuckooExpansion
+			}
+			expansion = n
+		default:
+			return nil, ErrSyntax
+		}
+	}
+
+	if _, ok := kvs.storage.Get(key); ok {
+		return nil, ErrBloomItemExists
+	}
+
+	cf := newCuckooFilter(uint64(capacity), uint64(bucketSize), int(maxIter), uint64(expansion))
+	storeKey(key, &KvsValue{dtype: CuckooDtype, object: cf})
+	signalModifiedKey(key, notifyModule, "cf.reserve")
+
+	return []byte(OkResponse), nil
+}
+
+// CF.ADD key item
+func cfAddHandler(args []*KvsValue) ([]byte, error) {
+	key := argToString(args[0])
+
+	cf, err := lookupCuckooCreate(key)
+	if err != nil {
+		return nil, err
+	}
+	defer signalModifiedKey(key, notifyModule, "cf.add")
+
+	if err := cf.add([]byte(argToString(args[1]))); err != nil {
+		return nil, err
+	}
+
+	return intResponse(1), nil
+}
+
+// CF.ADDNX key item
+func cfAddnxHandler(args []*KvsValue) ([]byte, error) {
+	key := argToString(args[0])
+
+	cf, err := lookupCuckooCreate(key)
+	if err != nil {
+		return nil, err
+	}
+	defer signalModifiedKey(key, notifyModule, "cf.addnx")
+
+	item := []byte(argToString(args[1]))
+	if cf.exists(item) {
+		return intResponse(0), nil
+	}
+
+	if err := cf.add(item); err != nil {
+		return nil, err
+	}
+
+	return intResponse(1), nil
+}
+
+// CF.EXISTS key item
+func cfExistsHandler(args []*KvsValue) ([]byte, error) {
+	cf, err := lookupCuckoo(argToString(args[0]))
+	if err != nil || cf == nil {
+		return intResponse(0), err
+	}
+
+	return boolIntResponse(cf.exists([]byte(argToString(args[1])))), nil
+}
+
+// CF.MEXISTS key item [item ...]
+func cfMexistsHandler(args []*KvsValue) ([]byte, error) {
+	cf, err := lookupCuckoo(argToString(args[0]))
+	if err != nil {
+		return nil, err
+	}
+
+	res := make([][]byte, len(args)-1)
+	for i, arg := range args[1:] {
+		res[i] = boolIntResponse(cf != nil && cf.exists([]byte(argToString(arg))))
+	}
+
+	return arrayResponse(res...), nil
+}
+
+// CF.DEL key item
+func cfDelHandler(args []*KvsValue) ([]byte, error) {
+	key := argToString(args[0])
+
+	cf, err := lookupCuckoo(key)
+	if err != nil {
+		return nil, err
+	}
+	if cf == nil {
+		return nil, ErrCuckooNotFound
+	}
+
+	deleted := cf.delete([]byte(argToString(args[1])))
+	if deleted {
+		signalModifiedKey(key, notifyModule, "cf.del")
+	}
+
+	return boolIntResponse(deleted), nil
+}
+
+// CF.COUNT key item
+func cfCountHandler(args []*KvsValue) ([]byte, error) {
+	cf, err := lookupCuckoo(argToString(args[0]))
+	if err != nil || cf == nil {
+		return intResponse(0), err
+	}
+
+	return intResponse(int64(cf.count([]byte(argToString(args[1]))))), nil
+}
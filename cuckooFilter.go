@@ -0,0 +1,233 @@
+package main
+
+import (
+	"math/bits"
+	"math/rand/v2"
+)
+
+// Cuckoo filter keeps 8 bit fingerprints of items in buckets. Every item has two candidate buckets and
+// the second one can be computed from the first one and the fingerprint alone, which makes it possible
+// to move fingerprints around on collisions and to delete items. When an item can't be placed a new,
+// bigger sub-filter is added
+const (
+	cuckooDefaultCapacity   = 1024
+	cuckooDefaultBucketSize = 2
+	cuckooDefaultMaxIter    = 20
+	cuckooDefaultExpansion  = 1
+	cuckooMaxBucketSize     = 255
+	cuckooMaxSubFilters     = 32
+	cuckooEmptySlot         = 0
+
+	cuckooHashSeed = 0x5bd1e995
+)
+
+type cuckooSubFilter struct {
+	numBuckets uint64 // always a power of two, so alternative bucket index stays inside the filter
+	slots      []uint8
+}
+
+type cuckooFilter struct {
+	filters    []*cuckooSubFilter
+	bucketSize uint64
+	maxIter    int
+	expansion  uint64
+}
+
+func newCuckooSubFilter(capacity, bucketSize uint64) *cuckooSubFilter {
+	numBuckets := max(uint64(1)<<bits.Len64((capacity+bucketSize-1)/bucketSize-1), 1)
+
+	return &cuckooSubFilter{numBuckets: numBuckets, slots: make([]uint8, numBuckets*bucketSize)}
+}
+
+func newCuckooFilter(capacity, bucketSize uint64, maxIter int, expansion uint64) *cuckooFilter {
+	return &cuckooFilter{
+		filters:    []*cuckooSubFilter{newCuckooSubFilter(capacity, bucketSize)},
+		bucketSize: bucketSize,
+		maxIter:    maxIter,
+		expansion:  expansion,
+	}
+}
+
+// cuckooHash returns fingerprint of item and hash used to find its first bucket
+func cuckooHash(item []byte) (uint8, uint64) {
+	h := murmurHash64A(item, cuckooHashSeed)
+
+	fp := uint8(h >> 56)
+	if fp == cuckooEmptySlot {
+		fp = 1
+	}
+
+	return fp, h
+}
+
+func cuckooAltIndex(index uint64, fp uint8) uint64 {
+	return index ^ (uint64(fp) * cuckooHashSeed)
+}
+
+func (f *cuckooSubFilter) bucket(index, bucketSize uint64) []uint8 {
+	i := index & (f.numBuckets - 1)
+	return f.slots[i*bucketSize : (i+1)*bucketSize]
+}
+
+func bucketInsert(bucket []uint8, fp uint8) bool {
+	for i, slot := range bucket {
+		if slot == cuckooEmptySlot {
+			bucket[i] = fp
+			return true
+		}
+	}
+
+	return false
+}
+
+func bucketCount(bucket []uint8, fp uint8) uint64 {
+	var res uint64
+	for _, slot := range bucket {
+		if slot == fp {
+			res++
+		}
+	}
+
+	return res
+}
+
+func bucketDelete(bucket []uint8, fp uint8) bool {
+	for i, slot := range bucket {
+		if slot == fp {
+			bucket[i] = cuckooEmptySlot
+			return true
+		}
+	}
+
+	return false
+}
+
+type cuckooKick struct {
+	bucket []uint8
+	slot   int
+	fp     uint8
+}
+
+// insert places fingerprint into one of its buckets, kicking other fingerprints to their alternative
+// buckets if needed. If there is still no room after maxIter kicks all moves are rolled back
+func (f *cuckooSubFilter) insert(fp uint8, h, bucketSize uint64, maxIter int) bool {
+	i1 := h
+	i2 := cuckooAltIndex(i1, fp)
+
+	if bucketInsert(f.bucket(i1, bucketSize), fp) || bucketInsert(f.bucket(i2, bucketSize), fp) {
+		return true
+	}
+
+	kicks := make([]cuckooKick, 0, maxIter)
+	index := i1
+	if rand.IntN(2) == 0 {
+		index = i2
+	}
+
+	for range maxIter {
+		bucket := f.bucket(index, bucketSize)
+		slot := rand.IntN(len(bucket))
+
+		kicks = append(kicks, cuckooKick{bucket: bucket, slot: slot, fp: bucket[slot]})
+		fp, bucket[slot] = bucket[slot], fp
+
+		index = cuckooAltIndex(index, fp)
+		if bucketInsert(f.bucket(index, bucketSize), fp) {
+			return true
+		}
+	}
+
+	for i := len(kicks) - 1; i >= 0; i-- {
+		kicks[i].bucket[kicks[i].slot] = kicks[i].fp
+	}
+
+	return false
+}
+
+func (cf *cuckooFilter) count(item []byte) uint64 {
+	fp, h := cuckooHash(item)
+
+	var res uint64
+	for _, f := range cf.filters {
+		i1 := h
+		i2 := cuckooAltIndex(i1, fp)
+
+		res += bucketCount(f.bucket(i1, cf.bucketSize), fp)
+		if i1&(f.numBuckets-1) != i2&(f.numBuckets-1) {
+			res += bucketCount(f.bucket(i2, cf.bucketSize), fp)
+		}
+	}
+
+	return res
+}
+
+func (cf *cuckooFilter) exists(item []byte) bool {
+	return cf.count(item) > 0
+}
+
+// add inserts item even if it already exists, so it can be deleted the same number of times
+func (cf *cuckooFilter) add(item []byte) error {
+	fp, h := cuckooHash(item)
+
+	// newest sub-filter has the most free room
+	for i := len(cf.filters) - 1; i >= 0; i-- {
+		if cf.filters[i].insert(fp, h, cf.bucketSize, cf.maxIter) {
+			return nil
+		}
+	}
+
+	if cf.expansion == 0 || len(cf.filters) >= cuckooMaxSubFilters {
+		return ErrCuckooFull
+	}
+
+	last := cf.filters[len(cf.filters)-1]
+	f := newCuckooSubFilter(last.numBuckets*cf.bucketSize*cf.expansion, cf.bucketSize)
+	cf.filters = append(cf.filters, f)
+
+	if !f.insert(fp, h, cf.bucketSize, cf.maxIter) {
+		return ErrCuckooFull
+	}
+
+	return nil
+}
+
+func (cf *cuckooFilter) delete(item []byte) bool {
+	fp, h := cuckooHash(item)
+
+	for i := len(cf.filters) - 1; i >= 0; i-- {
+		f := cf.filters[i]
+		if bucketDelete(f.bucket(h, cf.bucketSize), fp) || bucketDelete(f.bucket(cuckooAltIndex(h, fp), cf.bucketSize), fp) {
+			return true
+		}
+	}
+
+	return false
+}
+
+func (cf *cuckooFilter) dump(b []byte) []byte {
+	b = appendU64(b, cf.bucketSize)
+	b = appendU32(b, cf.maxIter)
+	b = appendU64(b, cf.expansion)
+
+	b = appendU32(b, len(cf.filters))
+	for _, f := range cf.filters {
+		b = appendU64(b, f.numBuckets)
+		b = appendStr(b, f.slots)
+	}
+
+	return b
+}
+
+func restoreCuckooFilter(r *dumpReader) *cuckooFilter {
+	cf := &cuckooFilter{bucketSize: r.u64(), maxIter: int(r.u32()), expansion: r.u64()}
+
+	cf.filters = make([]*cuckooSubFilter, r.count(12))
+	r.check(len(cf.filters) > 0)
+	for i := range cf.filters {
+		f := &cuckooSubFilter{numBuckets: r.u64(), slots: r.bytes()}
+		r.check(bits.OnesCount64(f.numBuckets) == 1 && f.numBuckets*cf.bucketSize == uint64(len(f.slots)))
+		cf.filters[i] = f
+	}
+
+	return cf
+}
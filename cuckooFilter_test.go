@@ -0,0 +1,81 @@
+package main
+
+import (
+	"errors"
+	"strconv"
+	"testing"
+)
+
+func TestCuckooFilterAddDelete(t *testing.T) {
+	cf := newCuckooFilter(1000, 2, 20, 1)
+
+	for i := range 500 {
+		if err := cf.add([]byte(strconv.Itoa(i))); err != nil {
+			t.Fatalf("add(%d) returned error: %v", i, err)
+		}
+	}
+
+	for i := range 500 {
+		if !cf.exists([]byte(strconv.Itoa(i))) {
+			t.Errorf("exists(%d) = false after add", i)
+		}
+	}
+
+	for i := range 250 {
+		if !cf.delete([]byte(strconv.Itoa(i))) {
+			t.Errorf("delete(%d) = false", i)
+		}
+	}
+
+	for i := 250; i < 500; i++ {
+		if !cf.exists([]byte(strconv.Itoa(i))) {
+			t.Errorf("exists(%d) = false after deleting other items", i)
+		}
+	}
+}
+
+func TestCuckooFilterCount(t *testing.T) {
+	cf := newCuckooFilter(100, 4, 20, 1)
+
+	for range 3 {
+		cf.add([]byte("a"))
+	}
+	cf.delete([]byte("a"))
+
+	if res := cf.count([]byte("a")); res != 2 {
+		t.Errorf("count() = %v, expected: 2", res)
+	}
+}
+
+func TestCuckooFilterExpansion(t *testing.T) {
+	cf := newCuckooFilter(8, 2, 10, 2)
+
+	for i := range 100 {
+		if err := cf.add([]byte(strconv.Itoa(i))); err != nil {
+			t.Fatalf("add(%d) returned error: %v", i, err)
+		}
+	}
+
+	if len(cf.filters) < 2 {
+		t.Errorf("filter has %d sub-filters, expected it to expand", len(cf.filters))
+	}
+
+	for i := range 100 {
+		if !cf.exists([]byte(strconv.Itoa(i))) {
+			t.Errorf("exists(%d) = false after add", i)
+		}
+	}
+}
+
+func TestCuckooFilterFull(t *testing.T) {
+	cf := newCuckooFilter(2, 1, 5, 0)
+
+	var err error
+	for i := 0; i < 100 && err == nil; i++ {
+		err = cf.add([]byte(strconv.Itoa(i)))
+	}
+
+	if !errors.Is(err, ErrCuckooFull) {
+		t.Errorf("add() to full filter error = %v, expected: %v", err, ErrCuckooFull)
+	}
+}
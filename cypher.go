@@ -0,0 +1,1055 @@
+package main
+
+import (
+	"strconv"
+	"strings"
+)
+
+// Subset of Cypher accepted by GRAPH.QUERY:
+//
+//	MATCH (a:Person {name: 'Alice'})-[:KNOWS*1..3]->(b) WHERE b.age > 30
+//	OPTIONAL MATCH (b)-[r:WORKS_AT]->(c)
+//	CREATE (a)-[:KNOWS {since: 2020}]->(:Person {name: 'Bob'})
+//	SET a.age = 31, a:Admin, a += {city: 'Paris'}
+//	[DETACH] DELETE r, b
+//	WITH a, count(b) AS friends WHERE friends > 1
+//	RETURN [DISTINCT] a.name AS name, friends ORDER BY name DESC SKIP 1 LIMIT 10
+//	CREATE INDEX FOR (p:Person) ON (p.name), CREATE INDEX ON :Person(name)
+//
+// Expressions have OR, XOR, AND, NOT, comparisons, IN, STARTS WITH, ENDS WITH, CONTAINS, IS [NOT] NULL,
+// arithmetic, property access, list and map literals, functions and aggregations
+type cyQuery struct {
+	clauses []any // *cyMatch, *cyCreate, *cySet, *cyDelete, *cyProjection, *cyCreateIndex
+}
+
+type cyNodePat struct {
+	name   string
+	labels []string
+	props  *cyMap
+}
+
+type cyRelPat struct {
+	name   string
+	types  []string
+	dir    int // 1 is ->, -1 is <-, 0 is undirected
+	varLen bool
+	minHop int
+	maxHop int // -1 means unbounded
+	props  *cyMap
+}
+
+type cyPattern struct {
+	nodes []*cyNodePat
+	rels  []*cyRelPat // rels[i] connects nodes[i] and nodes[i+1]
+}
+
+type cyMatch struct {
+	optional bool
+	patterns []*cyPattern
+	where    cyExpr
+}
+
+type cyCreate struct {
+	patterns []*cyPattern
+}
+
+type cySetItem struct {
+	name   string
+	prop   string // set when item is name.prop = value
+	value  cyExpr // property value or map merged by +=
+	labels []string
+}
+
+type cySet struct {
+	items []cySetItem
+}
+
+type cyDelete struct {
+	exprs  []cyExpr
+	detach bool
+}
+
+type cyReturnItem struct {
+	expr  cyExpr
+	alias string
+}
+
+type cyOrderItem struct {
+	expr cyExpr
+	desc bool
+}
+
+// cyProjection is RETURN or WITH
+type cyProjection struct {
+	final    bool // RETURN
+	distinct bool
+	star     bool
+	items    []cyReturnItem
+	orderBy  []cyOrderItem
+	skip     cyExpr
+	limit    cyExpr
+	where    cyExpr // WITH ... WHERE
+}
+
+type cyCreateIndex struct {
+	label, prop string
+}
+
+const (
+	cyTokEOF = iota
+	cyTokIdent
+	cyTokInt
+	cyTokFloat
+	cyTokString
+	cyTokPunct
+)
+
+type cyToken struct {
+	kind   int
+	text   string
+	pos    int
+	end    int
+	quoted bool // `backticked` identifier, never a keyword
+}
+
+var cyPuncts = []string{"->", "<-", "<>", "<=", ">=", "..", "+=", "(", ")", "[", "]", "{", "}", ",", ":", ".", "|", "*", "+", "-", "/", "%", "=", "<", ">"}
+
+func lexCypher(query string) ([]cyToken, error) {
+	var toks []cyToken
+
+	for i := 0; i < len(query); {
+		c := query[i]
+		start := i
+
+		switch {
+		case c == ' ' || c == '\t' || c == '\n' || c == '\r':
+			i++
+			continue
+		case c >= '0' && c <= '9':
+			for i < len(query) && query[i] >= '0' && query[i] <= '9' {
+				i++
+			}
+			kind := cyTokInt
+			if i+1 < len(query) && query[i] == '.' && query[i+1] >= '0' && query[i+1] <= '9' {
+				kind = cyTokFloat
+				for i++; i < len(query) && query[i] >= '0' && query[i] <= '9'; i++ {
+				}
+			}
+			if i < len(query) && (query[i] == 'e' || query[i] == 'E') {
+				kind = cyTokFloat
+				i++
+				if i < len(query) && (query[i] == '+' || query[i] == '-') {
+					i++
+				}
+				for i < len(query) && query[i] >= '0' && query[i] <= '9' {
+					i++
+				}
+			}
+			toks = append(toks, cyToken{kind: kind, text: query[start:i], pos: start, end: i})
+		case c == '_' || c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z':
+			for i < len(query) && (query[i] == '_' || query[i] >= 'a' && query[i] <= 'z' || query[i] >= 'A' && query[i] <= 'Z' || query[i] >= '0' && query[i] <= '9') {
+				i++
+			}
+			toks = append(toks, cyToken{kind: cyTokIdent, text: query[start:i], pos: start, end: i})
+		case c == '`':
+			end := strings.IndexByte(query[i+1:], '`')
+			if end < 0 {
+				return nil, cypherSyntaxErr(start, query[start:])
+			}
+			i += end + 2
+			toks = append(toks, cyToken{kind: cyTokIdent, text: query[start+1 : i-1], pos: start, end: i, quoted: true})
+		case c == '\'' || c == '"':
+			var sb strings.Builder
+			for i++; ; i++ {
+				if i >= len(query) {
+					return nil, cypherSyntaxErr(start, query[start:])
+				}
+				if query[i] == c {
+					i++
+					break
+				}
+				if query[i] == '\\' && i+1 < len(query) {
+					i++
+					switch query[i] {
+					case 'n':
+						sb.WriteByte('\n')
+					case 't':
+						sb.WriteByte('\t')
+					default:
+						sb.WriteByte(query[i])
+					}
+					continue
+				}
+				sb.WriteByte(query[i])
+			}
+			toks = append(toks, cyToken{kind: cyTokString, text: sb.String(), pos: start, end: i})
+		default:
+			matched := false
+			for _, p := range cyPuncts {
+				if strings.HasPrefix(query[i:], p) {
+					i += len(p)
+					toks = append(toks, cyToken{kind: cyTokPunct, text: p, pos: start, end: i})
+					matched = true
+					break
+				}
+			}
+			if !matched {
+				return nil, cypherSyntaxErr(start, query[start:start+1])
+			}
+		}
+	}
+
+	return append(toks, cyToken{kind: cyTokEOF, pos: len(query), end: len(query)}), nil
+}
+
+type cyParser struct {
+	query string
+	toks  []cyToken
+	pos   int
+	anon  int
+}
+
+func parseCypher(query string) (*cyQuery, error) {
+	toks, err := lexCypher(query)
+	if err != nil {
+		return nil, err
+	}
+
+	p := &cyParser{query: query, toks: toks}
+	q := &cyQuery{}
+
+	for p.peek().kind != cyTokEOF {
+		clause, err := p.parseClause()
+		if err != nil {
+			return nil, err
+		}
+		q.clauses = append(q.clauses, clause)
+
+		if proj, ok := clause.(*cyProjection); ok && proj.final && p.peek().kind != cyTokEOF {
+			return nil, p.syntaxErr()
+		}
+	}
+
+	if len(q.clauses) == 0 {
+		return nil, ErrGraphEmptyQuery
+	}
+
+	switch c := q.clauses[len(q.clauses)-1].(type) {
+	case *cyMatch:
+		return nil, ErrGraphQueryConclusion
+	case *cyProjection:
+		if !c.final {
+			return nil, ErrGraphQueryConclusion
+		}
+	}
+
+	return q, nil
+}
+
+func (p *cyParser) peek() cyToken {
+	return p.toks[p.pos]
+}
+
+func (p *cyParser) next() cyToken {
+	t := p.toks[p.pos]
+	if t.kind != cyTokEOF {
+		p.pos++
+	}
+
+	return t
+}
+
+func (p *cyParser) syntaxErr() error {
+	t := p.peek()
+	if t.kind == cyTokEOF {
+		return cypherSyntaxErr(t.pos, "end of input")
+	}
+
+	return cypherSyntaxErr(t.pos, p.query[t.pos:t.end])
+}
+
+// isKeyword reports whether next tokens are the given keywords
+func (p *cyParser) isKeyword(kws ...string) bool {
+	for i, kw := range kws {
+		if p.pos+i >= len(p.toks) {
+			return false
+		}
+		t := p.toks[p.pos+i]
+		if t.kind != cyTokIdent || t.quoted || !strings.EqualFold(t.text, kw) {
+			return false
+		}
+	}
+
+	return true
+}
+
+func (p *cyParser) acceptKeyword(kws ...string) bool {
+	if !p.isKeyword(kws...) {
+		return false
+	}
+	p.pos += len(kws)
+
+	return true
+}
+
+func (p *cyParser) expectKeyword(kws ...string) error {
+	if !p.acceptKeyword(kws...) {
+		return p.syntaxErr()
+	}
+
+	return nil
+}
+
+func (p *cyParser) isPunct(s string) bool {
+	t := p.peek()
+	return t.kind == cyTokPunct && t.text == s
+}
+
+func (p *cyParser) accept(s string) bool {
+	if !p.isPunct(s) {
+		return false
+	}
+	p.pos++
+
+	return true
+}
+
+func (p *cyParser) expect(s string) error {
+	if !p.accept(s) {
+		return p.syntaxErr()
+	}
+
+	return nil
+}
+
+var cyReserved = map[string]bool{
+	"MATCH": true, "OPTIONAL": true, "CREATE": true, "SET": true, "DELETE": true, "DETACH": true, "WITH": true,
+	"RETURN": true, "WHERE": true, "ORDER": true, "BY": true, "SKIP": true, "LIMIT": true, "AND": true, "OR": true,
+	"XOR": true, "NOT": true, "IN": true, "IS": true, "NULL": true, "TRUE": true, "FALSE": true, "AS": true,
+	"DISTINCT": true, "ASC": true, "DESC": true, "ASCENDING": true, "DESCENDING": true, "STARTS": true,
+	"ENDS": true, "CONTAINS": true, "INDEX": true, "ON": true, "FOR": true,
+}
+
+func (p *cyParser) expectIdent() (string, error) {
+	t := p.peek()
+	if t.kind != cyTokIdent || (!t.quoted && cyReserved[strings.ToUpper(t.text)]) {
+		return "", p.syntaxErr()
+	}
+	p.pos++
+
+	return t.text, nil
+}
+
+// expectName accepts any identifier including keywords, as labels, types and property keys may be keywords
+func (p *cyParser) expectName() (string, error) {
+	t := p.peek()
+	if t.kind != cyTokIdent {
+		return "", p.syntaxErr()
+	}
+	p.pos++
+
+	return t.text, nil
+}
+
+func (p *cyParser) parseClause() (any, error) {
+	switch {
+	case p.acceptKeyword("OPTIONAL", "MATCH"):
+		return p.parseMatch(true)
+	case p.acceptKeyword("MATCH"):
+		return p.parseMatch(false)
+	case p.acceptKeyword("CREATE", "INDEX"):
+		return p.parseCreateIndex()
+	case p.acceptKeyword("CREATE"):
+		patterns, err := p.parsePatterns()
+		if err != nil {
+			return nil, err
+		}
+		for _, pat := range patterns {
+			for _, r := range pat.rels {
+				if r.dir == 0 || r.varLen || len(r.types) != 1 {
+					return nil, ErrGraphCreateRel
+				}
+			}
+		}
+		return &cyCreate{patterns: patterns}, nil
+	case p.acceptKeyword("SET"):
+		return p.parseSet()
+	case p.acceptKeyword("DETACH", "DELETE"):
+		return p.parseDelete(true)
+	case p.acceptKeyword("DELETE"):
+		return p.parseDelete(false)
+	case p.acceptKeyword("WITH"):
+		return p.parseProjection(false)
+	case p.acceptKeyword("RETURN"):
+		return p.parseProjection(true)
+	}
+
+	return nil, p.syntaxErr()
+}
+
+func (p *cyParser) parseMatch(optional bool) (*cyMatch, error) {
+	patterns, err := p.parsePatterns()
+	if err != nil {
+		return nil, err
+	}
+
+	m := &cyMatch{optional: optional, patterns: patterns}
+	if p.acceptKeyword("WHERE") {
+		if m.where, err = p.parseExpr(); err != nil {
+			return nil, err
+		}
+	}
+
+	return m, nil
+}
+
+// parseCreateIndex parses "FOR (n:Label) ON (n.prop)" or "ON :Label(prop)"
+func (p *cyParser) parseCreateIndex() (*cyCreateIndex, error) {
+	var label, prop string
+	var err error
+
+	if p.acceptKeyword("ON") {
+		if err = p.expect(":"); err != nil {
+			return nil, err
+		}
+		if label, err = p.expectName(); err != nil {
+			return nil, err
+		}
+		if err = p.expect("("); err != nil {
+			return nil, err
+		}
+		if prop, err = p.expectName(); err != nil {
+			return nil, err
+		}
+		return &cyCreateIndex{label: label, prop: prop}, p.expect(")")
+	}
+
+	if err = p.expectKeyword("FOR"); err != nil {
+		return nil, err
+	}
+
+	node, err := p.parseNodePat()
+	if err != nil {
+		return nil, err
+	}
+	if len(node.labels) != 1 {
+		return nil, p.syntaxErr()
+	}
+
+	if err = p.expectKeyword("ON"); err != nil {
+		return nil, err
+	}
+	if err = p.expect("("); err != nil {
+		return nil, err
+	}
+
+	name, err := p.expectIdent()
+	if err != nil {
+		return nil, err
+	}
+	if name != node.name {
+		return nil, cypherUndefinedVarErr(name)
+	}
+	if err = p.expect("."); err != nil {
+		return nil, err
+	}
+	if prop, err = p.expectName(); err != nil {
+		return nil, err
+	}
+
+	return &cyCreateIndex{label: node.labels[0], prop: prop}, p.expect(")")
+}
+
+func (p *cyParser) parsePatterns() ([]*cyPattern, error) {
+	var patterns []*cyPattern
+
+	for {
+		pat, err := p.parsePattern()
+		if err != nil {
+			return nil, err
+		}
+		patterns = append(patterns, pat)
+
+		if !p.accept(",") {
+			return patterns, nil
+		}
+	}
+}
+
+func (p *cyParser) parsePattern() (*cyPattern, error) {
+	node, err := p.parseNodePat()
+	if err != nil {
+		return nil, err
+	}
+
+	pat := &cyPattern{nodes: []*cyNodePat{node}}
+	for p.isPunct("-") || p.isPunct("<-") {
+		rel, err := p.parseRelPat()
+		if err != nil {
+			return nil, err
+		}
+
+		node, err := p.parseNodePat()
+		if err != nil {
+			return nil, err
+		}
+
+		pat.rels = append(pat.rels, rel)
+		pat.nodes = append(pat.nodes, node)
+	}
+
+	return pat, nil
+}
+
+// anonName names pattern elements without a variable, so they can be bound in rows like named ones
+func (p *cyParser) anonName() string {
+	p.anon++
+	return "@anon_" + strconv.Itoa(p.anon-1)
+}
+
+func (p *cyParser) parseNodePat() (*cyNodePat, error) {
+	if err := p.expect("("); err != nil {
+		return nil, err
+	}
+
+	node := &cyNodePat{}
+	if p.peek().kind == cyTokIdent {
+		name, err := p.expectIdent()
+		if err != nil {
+			return nil, err
+		}
+		node.name = name
+	} else {
+		node.name = p.anonName()
+	}
+
+	for p.accept(":") {
+		label, err := p.expectName()
+		if err != nil {
+			return nil, err
+		}
+		node.labels = append(node.labels, label)
+	}
+
+	if p.isPunct("{") {
+		props, err := p.parseMap()
+		if err != nil {
+			return nil, err
+		}
+		node.props = props
+	}
+
+	return node, p.expect(")")
+}
+
+func (p *cyParser) parseRelPat() (*cyRelPat, error) {
+	rel := &cyRelPat{minHop: 1, maxHop: 1}
+
+	in := p.accept("<-")
+	if !in {
+		if err := p.expect("-"); err != nil {
+			return nil, err
+		}
+	}
+
+	if p.accept("[") {
+		if err := p.parseRelDetails(rel); err != nil {
+			return nil, err
+		}
+	}
+	if rel.name == "" {
+		rel.name = p.anonName()
+	}
+
+	out := p.accept("->")
+	if !out {
+		if err := p.expect("-"); err != nil {
+			return nil, err
+		}
+	}
+
+	switch {
+	case in && out:
+		return nil, p.syntaxErr()
+	case in:
+		rel.dir = -1
+	case out:
+		rel.dir = 1
+	}
+
+	return rel, nil
+}
+
+func (p *cyParser) parseRelDetails(rel *cyRelPat) error {
+	if p.peek().kind == cyTokIdent {
+		name, err := p.expectIdent()
+		if err != nil {
+			return err
+		}
+		rel.name = name
+	}
+
+	if p.accept(":") {
+		for {
+			typ, err := p.expectName()
+			if err != nil {
+				return err
+			}
+			rel.types = append(rel.types, typ)
+
+			if !p.accept("|") {
+				break
+			}
+			p.accept(":")
+		}
+	}
+
+	if p.accept("*") {
+		rel.varLen = true
+		rel.maxHop = -1
+
+		if p.peek().kind == cyTokInt {
+			n, _ := strconv.Atoi(p.next().text)
+			rel.minHop, rel.maxHop = n, n
+		}
+		if p.accept("..") {
+			rel.maxHop = -1
+			if p.peek().kind == cyTokInt {
+				rel.maxHop, _ = strconv.Atoi(p.next().text)
+			}
+		}
+		if rel.maxHop >= 0 && rel.maxHop < rel.minHop {
+			return p.syntaxErr()
+		}
+	}
+
+	if p.isPunct("{") {
+		props, err := p.parseMap()
+		if err != nil {
+			return err
+		}
+		rel.props = props
+	}
+
+	return p.expect("]")
+}
+
+func (p *cyParser) parseSet() (*cySet, error) {
+	set := &cySet{}
+
+	for {
+		name, err := p.expectIdent()
+		if err != nil {
+			return nil, err
+		}
+		item := cySetItem{name: name}
+
+		switch {
+		case p.isPunct(":"):
+			for p.accept(":") {
+				label, err := p.expectName()
+				if err != nil {
+					return nil, err
+				}
+				item.labels = append(item.labels, label)
+			}
+		case p.accept("."):
+			if item.prop, err = p.expectName(); err != nil {
+				return nil, err
+			}
+			if err = p.expect("="); err != nil {
+				return nil, err
+			}
+			if item.value, err = p.parseExpr(); err != nil {
+				return nil, err
+			}
+		case p.accept("+="):
+			if item.value, err = p.parseExpr(); err != nil {
+				return nil, err
+			}
+		default:
+			return nil, p.syntaxErr()
+		}
+
+		set.items = append(set.items, item)
+		if !p.accept(",") {
+			return set, nil
+		}
+	}
+}
+
+func (p *cyParser) parseDelete(detach bool) (*cyDelete, error) {
+	del := &cyDelete{detach: detach}
+
+	for {
+		e, err := p.parseExpr()
+		if err != nil {
+			return nil, err
+		}
+		del.exprs = append(del.exprs, e)
+
+		if !p.accept(",") {
+			return del, nil
+		}
+	}
+}
+
+func (p *cyParser) parseProjection(final bool) (*cyProjection, error) {
+	proj := &cyProjection{final: final, distinct: p.acceptKeyword("DISTINCT")}
+
+	if p.accept("*") {
+		proj.star = true
+	} else {
+		for {
+			start := p.peek().pos
+			e, err := p.parseExpr()
+			if err != nil {
+				return nil, err
+			}
+
+			item := cyReturnItem{expr: e, alias: p.query[start:p.toks[p.pos-1].end]}
+			if p.acceptKeyword("AS") {
+				if item.alias, err = p.expectIdent(); err != nil {
+					return nil, err
+				}
+			}
+			proj.items = append(proj.items, item)
+
+			if !p.accept(",") {
+				break
+			}
+		}
+	}
+
+	var err error
+	if p.acceptKeyword("ORDER", "BY") {
+		for {
+			var item cyOrderItem
+			if item.expr, err = p.parseExpr(); err != nil {
+				return nil, err
+			}
+			switch {
+			case p.acceptKeyword("DESC"), p.acceptKeyword("DESCENDING"):
+				item.desc = true
+			case p.acceptKeyword("ASC"), p.acceptKeyword("ASCENDING"):
+			}
+			proj.orderBy = append(proj.orderBy, item)
+
+			if !p.accept(",") {
+				break
+			}
+		}
+	}
+
+	if p.acceptKeyword("SKIP") {
+		if proj.skip, err = p.parseExpr(); err != nil {
+			return nil, err
+		}
+	}
+	if p.acceptKeyword("LIMIT") {
+		if proj.limit, err = p.parseExpr(); err != nil {
+			return nil, err
+		}
+	}
+	if !final && p.acceptKeyword("WHERE") {
+		if proj.where, err = p.parseExpr(); err != nil {
+			return nil, err
+		}
+	}
+
+	return proj, nil
+}
+
+// Expressions, from the lowest precedence
+
+func (p *cyParser) parseExpr() (cyExpr, error) {
+	return p.parseBinary(0)
+}
+
+var cyBinaryLevels = [][]string{{"OR"}, {"XOR"}, {"AND"}}
+
+func (p *cyParser) parseBinary(level int) (cyExpr, error) {
+	if level == len(cyBinaryLevels) {
+		return p.parseNot()
+	}
+
+	left, err := p.parseBinary(level + 1)
+	if err != nil {
+		return nil, err
+	}
+
+	for {
+		op := ""
+		for _, kw := range cyBinaryLevels[level] {
+			if p.acceptKeyword(kw) {
+				op = kw
+			}
+		}
+		if op == "" {
+			return left, nil
+		}
+
+		right, err := p.parseBinary(level + 1)
+		if err != nil {
+			return nil, err
+		}
+		left = &cyBinary{op: op, l: left, r: right}
+	}
+}
+
+func (p *cyParser) parseNot() (cyExpr, error) {
+	if p.acceptKeyword("NOT") {
+		x, err := p.parseNot()
+		if err != nil {
+			return nil, err
+		}
+		return &cyUnary{op: "NOT", x: x}, nil
+	}
+
+	return p.parseComparison()
+}
+
+func (p *cyParser) parseComparison() (cyExpr, error) {
+	left, err := p.parseAdditive()
+	if err != nil {
+		return nil, err
+	}
+
+	for {
+		var op string
+		switch {
+		case p.isPunct("=") || p.isPunct("<>") || p.isPunct("<") || p.isPunct("<=") || p.isPunct(">") || p.isPunct(">="):
+			op = p.next().text
+		case p.acceptKeyword("IN"):
+			op = "IN"
+		case p.acceptKeyword("STARTS", "WITH"):
+			op = "STARTS WITH"
+		case p.acceptKeyword("ENDS", "WITH"):
+			op = "ENDS WITH"
+		case p.acceptKeyword("CONTAINS"):
+			op = "CONTAINS"
+		case p.acceptKeyword("IS", "NOT", "NULL"):
+			left = &cyIsNull{x: left, not: true}
+			continue
+		case p.acceptKeyword("IS", "NULL"):
+			left = &cyIsNull{x: left}
+			continue
+		default:
+			return left, nil
+		}
+
+		right, err := p.parseAdditive()
+		if err != nil {
+			return nil, err
+		}
+		left = &cyBinary{op: op, l: left, r: right}
+	}
+}
+
+func (p *cyParser) parseAdditive() (cyExpr, error) {
+	left, err := p.parseMultiplicative()
+	if err != nil {
+		return nil, err
+	}
+
+	for p.isPunct("+") || p.isPunct("-") {
+		op := p.next().text
+		right, err := p.parseMultiplicative()
+		if err != nil {
+			return nil, err
+		}
+		left = &cyBinary{op: op, l: left, r: right}
+	}
+
+	return left, nil
+}
+
+func (p *cyParser) parseMultiplicative() (cyExpr, error) {
+	left, err := p.parseUnary()
+	if err != nil {
+		return nil, err
+	}
+
+	for p.isPunct("*") || p.isPunct("/") || p.isPunct("%") {
+		op := p.next().text
+		right, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		left = &cyBinary{op: op, l: left, r: right}
+	}
+
+	return left, nil
+}
+
+func (p *cyParser) parseUnary() (cyExpr, error) {
+	if p.accept("-") {
+		x, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		return &cyUnary{op: "-", x: x}, nil
+	}
+	p.accept("+")
+
+	x, err := p.parsePrimary()
+	if err != nil {
+		return nil, err
+	}
+
+	for p.accept(".") {
+		key, err := p.expectName()
+		if err != nil {
+			return nil, err
+		}
+		x = &cyProp{x: x, key: key}
+	}
+
+	return x, nil
+}
+
+func (p *cyParser) parsePrimary() (cyExpr, error) {
+	t := p.peek()
+
+	switch t.kind {
+	case cyTokInt:
+		p.next()
+		n, err := strconv.ParseInt(t.text, 10, 64)
+		if err != nil {
+			return nil, cypherSyntaxErr(t.pos, t.text)
+		}
+		return &cyLiteral{v: n}, nil
+	case cyTokFloat:
+		p.next()
+		f, err := strconv.ParseFloat(t.text, 64)
+		if err != nil {
+			return nil, cypherSyntaxErr(t.pos, t.text)
+		}
+		return &cyLiteral{v: f}, nil
+	case cyTokString:
+		p.next()
+		return &cyLiteral{v: t.text}, nil
+	case cyTokPunct:
+		switch t.text {
+		case "(":
+			p.next()
+			x, err := p.parseExpr()
+			if err != nil {
+				return nil, err
+			}
+			return x, p.expect(")")
+		case "[":
+			return p.parseList()
+		case "{":
+			return p.parseMap()
+		}
+	case cyTokIdent:
+		switch {
+		case p.acceptKeyword("TRUE"):
+			return &cyLiteral{v: true}, nil
+		case p.acceptKeyword("FALSE"):
+			return &cyLiteral{v: false}, nil
+		case p.acceptKeyword("NULL"):
+			return &cyLiteral{}, nil
+		}
+
+		if next := p.toks[p.pos+1]; next.kind == cyTokPunct && next.text == "(" && !t.quoted {
+			return p.parseCall()
+		}
+
+		name, err := p.expectIdent()
+		if err != nil {
+			return nil, err
+		}
+		return &cyVar{name: name}, nil
+	}
+
+	return nil, p.syntaxErr()
+}
+
+func (p *cyParser) parseCall() (cyExpr, error) {
+	t := p.next()
+	p.next()
+
+	call := &cyCall{name: strings.ToLower(t.text)}
+	if _, ok := cyAggregates[call.name]; !ok {
+		if _, ok := cyFunctions[call.name]; !ok {
+			return nil, cypherUnknownFuncErr(t.text)
+		}
+	}
+
+	if call.name == "count" && p.accept("*") {
+		call.star = true
+		return call, p.expect(")")
+	}
+
+	call.distinct = p.acceptKeyword("DISTINCT")
+	if p.accept(")") {
+		return call, nil
+	}
+
+	for {
+		arg, err := p.parseExpr()
+		if err != nil {
+			return nil, err
+		}
+		call.args = append(call.args, arg)
+
+		if !p.accept(",") {
+			return call, p.expect(")")
+		}
+	}
+}
+
+func (p *cyParser) parseList() (cyExpr, error) {
+	p.next()
+
+	list := &cyList{}
+	if p.accept("]") {
+		return list, nil
+	}
+
+	for {
+		e, err := p.parseExpr()
+		if err != nil {
+			return nil, err
+		}
+		list.elems = append(list.elems, e)
+
+		if !p.accept(",") {
+			return list, p.expect("]")
+		}
+	}
+}
+
+func (p *cyParser) parseMap() (*cyMap, error) {
+	if err := p.expect("{"); err != nil {
+		return nil, err
+	}
+
+	m := &cyMap{}
+	if p.accept("}") {
+		return m, nil
+	}
+
+	for {
+		key, err := p.expectName()
+		if err != nil {
+			return nil, err
+		}
+		if err = p.expect(":"); err != nil {
+			return nil, err
+		}
+
+		val, err := p.parseExpr()
+		if err != nil {
+			return nil, err
+		}
+		m.keys = append(m.keys, key)
+		m.vals = append(m.vals, val)
+
+		if !p.accept(",") {
+			return m, p.expect("}")
+		}
+	}
+}
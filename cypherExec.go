@@ -0,0 +1,1104 @@
+package main
+
+import (
+	"fmt"
+	"maps"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// Query is executed clause by clause over a list of rows binding variables to values. MATCH replaces every
+// row by its extensions with the pattern matches, updating clauses change the graph once per row, and
+// WITH and RETURN project rows to new variables
+type cyStats struct {
+	nodesCreated, nodesDeleted int
+	relsCreated, relsDeleted   int
+	propsSet, labelsAdded      int
+	indicesCreated             int
+}
+
+type cyResult struct {
+	columns []string
+	rows    [][]any
+	stats   cyStats
+}
+
+type cyExec struct {
+	g     *graph
+	stats cyStats
+}
+
+func runCypher(g *graph, q *cyQuery) (*cyResult, error) {
+	ex := &cyExec{g: g}
+	rows := []cyRow{{}}
+	res := &cyResult{}
+
+	for _, clause := range q.clauses {
+		var err error
+
+		switch c := clause.(type) {
+		case *cyMatch:
+			rows, err = ex.match(rows, c)
+		case *cyCreate:
+			rows, err = ex.create(rows, c)
+		case *cySet:
+			err = ex.set(rows, c)
+		case *cyDelete:
+			err = ex.delete(rows, c)
+		case *cyCreateIndex:
+			if g.createIndex(c.label, c.prop) {
+				ex.stats.indicesCreated++
+			}
+		case *cyProjection:
+			var columns []string
+			columns, rows, err = ex.project(rows, c)
+			if c.final {
+				res.columns = columns
+				for _, row := range rows {
+					vals := make([]any, len(columns))
+					for i, col := range columns {
+						vals[i] = row[col]
+					}
+					res.rows = append(res.rows, vals)
+				}
+			}
+		}
+
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	res.stats = ex.stats
+
+	return res, nil
+}
+
+// isWrite reports whether query may change the graph
+func (q *cyQuery) isWrite() bool {
+	for _, clause := range q.clauses {
+		switch clause.(type) {
+		case *cyCreate, *cySet, *cyDelete, *cyCreateIndex:
+			return true
+		}
+	}
+
+	return false
+}
+
+func cyTruthy(e cyExpr, row cyRow) (bool, error) {
+	if e == nil {
+		return true, nil
+	}
+
+	v, err := e.eval(row)
+	if err != nil {
+		return false, err
+	}
+
+	switch v := v.(type) {
+	case nil:
+		return false, nil
+	case bool:
+		return v, nil
+	}
+
+	return false, cypherTypeErr("Boolean", v)
+}
+
+// Matching
+
+func (ex *cyExec) match(rows []cyRow, m *cyMatch) ([]cyRow, error) {
+	var res []cyRow
+
+	for _, row := range rows {
+		matches := []cyRow{row}
+
+		for _, pat := range m.patterns {
+			var next []cyRow
+			for _, r := range matches {
+				err := ex.matchPattern(orientPattern(ex.g, pat, r), r, func(found cyRow) {
+					next = append(next, found)
+				})
+				if err != nil {
+					return nil, err
+				}
+			}
+			matches = next
+		}
+
+		matched := false
+		for _, r := range matches {
+			ok, err := cyTruthy(m.where, r)
+			if err != nil {
+				return nil, err
+			}
+			if ok {
+				res = append(res, r)
+				matched = true
+			}
+		}
+
+		if !matched && m.optional {
+			r := maps.Clone(row)
+			for _, pat := range m.patterns {
+				for _, n := range pat.nodes {
+					if _, ok := r[n.name]; !ok {
+						r[n.name] = nil
+					}
+				}
+				for _, rel := range pat.rels {
+					if _, ok := r[rel.name]; !ok {
+						r[rel.name] = nil
+					}
+				}
+			}
+			res = append(res, r)
+		}
+	}
+
+	return res, nil
+}
+
+// orientPattern reverses pattern when matching is cheaper from its last node: when the last node is
+// already bound or has a label and the first one has neither
+func orientPattern(g *graph, pat *cyPattern, row cyRow) *cyPattern {
+	if len(pat.rels) == 0 {
+		return pat
+	}
+
+	first, last := pat.nodes[0], pat.nodes[len(pat.nodes)-1]
+	_, firstBound := row[first.name]
+	_, lastBound := row[last.name]
+
+	if firstBound || (!lastBound && (len(first.labels) > 0 || len(last.labels) == 0)) {
+		return pat
+	}
+
+	rev := &cyPattern{nodes: slices.Clone(pat.nodes)}
+	slices.Reverse(rev.nodes)
+	for i := len(pat.rels) - 1; i >= 0; i-- {
+		r := *pat.rels[i]
+		r.dir = -r.dir
+		rev.rels = append(rev.rels, &r)
+	}
+
+	return rev
+}
+
+// scanKind describes how candidates of the first pattern node are found
+func scanKind(g *graph, np *cyNodePat, bound bool) (kind, label, prop string, val cyExpr) {
+	switch {
+	case bound:
+		return "Argument", "", "", nil
+	case len(np.labels) == 0:
+		return "All Node Scan", "", "", nil
+	}
+
+	if np.props != nil {
+		for _, l := range np.labels {
+			for i, k := range np.props.keys {
+				if _, ok := g.indexes[graphIndexKey{l, k}]; ok {
+					return "Node By Index Scan", l, k, np.props.vals[i]
+				}
+			}
+		}
+	}
+
+	return "Node By Label Scan", np.labels[0], "", nil
+}
+
+func (ex *cyExec) scanNodes(np *cyNodePat, row cyRow) ([]*graphNode, error) {
+	if v, ok := row[np.name]; ok {
+		switch v := v.(type) {
+		case nil:
+			return nil, nil
+		case *graphNode:
+			return []*graphNode{v}, nil
+		}
+		return nil, cypherTypeErr("Node", v)
+	}
+
+	kind, label, prop, valExpr := scanKind(ex.g, np, false)
+	switch kind {
+	case "Node By Index Scan":
+		val, err := valExpr.eval(row)
+		if err != nil {
+			return nil, err
+		}
+		nodes, _ := ex.g.lookupIndex(label, prop, val)
+		return nodes, nil
+	case "Node By Label Scan":
+		return sortedNodes(ex.g.labels[label]), nil
+	}
+
+	return sortedNodes(ex.g.nodes), nil
+}
+
+func cyPropsMatch(props []graphProp, want *cyMap, row cyRow) (bool, error) {
+	if want == nil {
+		return true, nil
+	}
+
+	for i, k := range want.keys {
+		wv, err := want.vals[i].eval(row)
+		if err != nil {
+			return false, err
+		}
+		v, _ := getProp(props, k)
+		if cyEquals(v, wv) != true {
+			return false, nil
+		}
+	}
+
+	return true, nil
+}
+
+func (ex *cyExec) nodeMatches(np *cyNodePat, n *graphNode, row cyRow) (bool, error) {
+	if v, ok := row[np.name]; ok && v != n {
+		return false, nil
+	}
+
+	for _, l := range np.labels {
+		if !slices.Contains(n.labels, l) {
+			return false, nil
+		}
+	}
+
+	return cyPropsMatch(n.props, np.props, row)
+}
+
+func (ex *cyExec) edgeMatches(rp *cyRelPat, e *graphEdge, row cyRow) (bool, error) {
+	if len(rp.types) > 0 && !slices.Contains(rp.types, e.typ) {
+		return false, nil
+	}
+
+	return cyPropsMatch(e.props, rp.props, row)
+}
+
+// cyHop is an edge leading from a node to its neighbour
+type cyHop struct {
+	e    *graphEdge
+	next *graphNode
+}
+
+func cyHops(n *graphNode, dir int) []cyHop {
+	var hops []cyHop
+
+	if dir >= 0 {
+		for _, e := range n.out {
+			hops = append(hops, cyHop{e, e.dst})
+		}
+	}
+	if dir <= 0 {
+		for _, e := range n.in {
+			// undirected self loop is already added as outgoing
+			if dir == 0 && e.src == e.dst {
+				continue
+			}
+			hops = append(hops, cyHop{e, e.src})
+		}
+	}
+
+	return hops
+}
+
+func (ex *cyExec) matchPattern(pat *cyPattern, row cyRow, emit func(cyRow)) error {
+	start := pat.nodes[0]
+
+	candidates, err := ex.scanNodes(start, row)
+	if err != nil {
+		return err
+	}
+
+	for _, n := range candidates {
+		ok, err := ex.nodeMatches(start, n, row)
+		if err != nil {
+			return err
+		}
+		if !ok {
+			continue
+		}
+
+		r := maps.Clone(row)
+		r[start.name] = n
+		if err = ex.extend(pat, 0, n, r, map[*graphEdge]bool{}, emit); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// extend matches pattern relationships from i on, starting at node cur. Edges are never repeated in
+// one pattern match
+func (ex *cyExec) extend(pat *cyPattern, i int, cur *graphNode, row cyRow, used map[*graphEdge]bool, emit func(cyRow)) error {
+	if i == len(pat.rels) {
+		emit(row)
+		return nil
+	}
+
+	rp, np := pat.rels[i], pat.nodes[i+1]
+
+	bind := func(relVal any, next *graphNode, path []*graphEdge) error {
+		if v, ok := row[rp.name]; ok && cyEquals(v, relVal) != true {
+			return nil
+		}
+
+		ok, err := ex.nodeMatches(np, next, row)
+		if err != nil || !ok {
+			return err
+		}
+
+		r := maps.Clone(row)
+		r[rp.name] = relVal
+		r[np.name] = next
+		for _, e := range path {
+			used[e] = true
+		}
+		err = ex.extend(pat, i+1, next, r, used, emit)
+		for _, e := range path {
+			delete(used, e)
+		}
+
+		return err
+	}
+
+	if !rp.varLen {
+		for _, h := range cyHops(cur, rp.dir) {
+			if used[h.e] {
+				continue
+			}
+			ok, err := ex.edgeMatches(rp, h.e, row)
+			if err != nil {
+				return err
+			}
+			if ok {
+				if err = bind(h.e, h.next, []*graphEdge{h.e}); err != nil {
+					return err
+				}
+			}
+		}
+		return nil
+	}
+
+	var path []*graphEdge
+	var walk func(n *graphNode) error
+	walk = func(n *graphNode) error {
+		if len(path) >= rp.minHop {
+			edges := make([]any, len(path))
+			for j, e := range path {
+				edges[j] = e
+			}
+			if err := bind(edges, n, path); err != nil {
+				return err
+			}
+		}
+
+		if rp.maxHop >= 0 && len(path) == rp.maxHop {
+			return nil
+		}
+
+		for _, h := range cyHops(n, rp.dir) {
+			if used[h.e] || slices.Contains(path, h.e) {
+				continue
+			}
+			ok, err := ex.edgeMatches(rp, h.e, row)
+			if err != nil {
+				return err
+			}
+			if !ok {
+				continue
+			}
+
+			path = append(path, h.e)
+			err = walk(h.next)
+			path = path[:len(path)-1]
+			if err != nil {
+				return err
+			}
+		}
+
+		return nil
+	}
+
+	return walk(cur)
+}
+
+// Updates
+
+// cyPropValue checks value can be stored as a property
+func cyPropValue(v any) (any, error) {
+	switch v := v.(type) {
+	case nil, int64, float64, string, bool:
+		return v, nil
+	case []any:
+		for _, el := range v {
+			switch el.(type) {
+			case nil, int64, float64, string, bool:
+			default:
+				return nil, ErrGraphPropertyType
+			}
+		}
+		return v, nil
+	}
+
+	return nil, ErrGraphPropertyType
+}
+
+func (ex *cyExec) evalProps(m *cyMap, row cyRow) ([]graphProp, error) {
+	if m == nil {
+		return nil, nil
+	}
+
+	var props []graphProp
+	for i, k := range m.keys {
+		v, err := m.vals[i].eval(row)
+		if err != nil {
+			return nil, err
+		}
+		if v, err = cyPropValue(v); err != nil {
+			return nil, err
+		}
+		props = setProp(props, k, v)
+	}
+
+	return props, nil
+}
+
+func (ex *cyExec) create(rows []cyRow, c *cyCreate) ([]cyRow, error) {
+	res := make([]cyRow, 0, len(rows))
+
+	for _, row := range rows {
+		r := maps.Clone(row)
+
+		for _, pat := range c.patterns {
+			nodes := make([]*graphNode, len(pat.nodes))
+
+			for i, np := range pat.nodes {
+				if v, ok := r[np.name]; ok {
+					n, ok := v.(*graphNode)
+					if !ok {
+						return nil, cypherTypeErr("Node", v)
+					}
+					if len(pat.rels) == 0 || len(np.labels) > 0 || np.props != nil {
+						return nil, cypherVarRedeclaredErr(np.name)
+					}
+					nodes[i] = n
+					continue
+				}
+
+				props, err := ex.evalProps(np.props, r)
+				if err != nil {
+					return nil, err
+				}
+				nodes[i] = ex.g.addNode(np.labels, props)
+				r[np.name] = nodes[i]
+
+				ex.stats.nodesCreated++
+				ex.stats.labelsAdded += len(nodes[i].labels)
+				ex.stats.propsSet += len(props)
+			}
+
+			for i, rp := range pat.rels {
+				if _, ok := r[rp.name]; ok {
+					return nil, cypherVarRedeclaredErr(rp.name)
+				}
+
+				props, err := ex.evalProps(rp.props, r)
+				if err != nil {
+					return nil, err
+				}
+
+				src, dst := nodes[i], nodes[i+1]
+				if rp.dir < 0 {
+					src, dst = dst, src
+				}
+				r[rp.name] = ex.g.addEdge(rp.types[0], src, dst, props)
+
+				ex.stats.relsCreated++
+				ex.stats.propsSet += len(props)
+			}
+		}
+
+		res = append(res, r)
+	}
+
+	return res, nil
+}
+
+func (ex *cyExec) setProp(target any, key string, val any) error {
+	val, err := cyPropValue(val)
+	if err != nil {
+		return err
+	}
+
+	switch t := target.(type) {
+	case *graphNode:
+		if _, ok := ex.g.nodes[t.id]; ok {
+			ex.g.setNodeProp(t, key, val)
+		}
+	case *graphEdge:
+		t.props = setProp(t.props, key, val)
+	default:
+		return cypherTypeErr("Node or Edge", target)
+	}
+
+	ex.stats.propsSet++
+
+	return nil
+}
+
+func (ex *cyExec) set(rows []cyRow, s *cySet) error {
+	for _, row := range rows {
+		for _, item := range s.items {
+			target, err := (&cyVar{name: item.name}).eval(row)
+			if err != nil {
+				return err
+			}
+			if target == nil {
+				continue
+			}
+
+			switch {
+			case item.labels != nil:
+				n, ok := target.(*graphNode)
+				if !ok {
+					return cypherTypeErr("Node", target)
+				}
+				for _, l := range item.labels {
+					if ex.g.addLabel(n, l) {
+						ex.stats.labelsAdded++
+					}
+				}
+			case item.prop != "":
+				val, err := item.value.eval(row)
+				if err != nil {
+					return err
+				}
+				if err = ex.setProp(target, item.prop, val); err != nil {
+					return err
+				}
+			default:
+				val, err := item.value.eval(row)
+				if err != nil {
+					return err
+				}
+				m, ok := val.(map[string]any)
+				if !ok {
+					return cypherTypeErr("Map", val)
+				}
+				for _, k := range slices.Sorted(maps.Keys(m)) {
+					if err = ex.setProp(target, k, m[k]); err != nil {
+						return err
+					}
+				}
+			}
+		}
+	}
+
+	return nil
+}
+
+// delete removes matched nodes together with their relationships, DETACH is accepted for compatibility
+func (ex *cyExec) delete(rows []cyRow, d *cyDelete) error {
+	var nodes []*graphNode
+	var edges []*graphEdge
+
+	var collect func(v any) error
+	collect = func(v any) error {
+		switch v := v.(type) {
+		case nil:
+		case *graphNode:
+			nodes = append(nodes, v)
+		case *graphEdge:
+			edges = append(edges, v)
+		case []any:
+			for _, el := range v {
+				if err := collect(el); err != nil {
+					return err
+				}
+			}
+		default:
+			return cypherTypeErr("Node or Edge", v)
+		}
+		return nil
+	}
+
+	for _, row := range rows {
+		for _, e := range d.exprs {
+			v, err := e.eval(row)
+			if err != nil {
+				return err
+			}
+			if err = collect(v); err != nil {
+				return err
+			}
+		}
+	}
+
+	for _, e := range edges {
+		if ex.g.deleteEdge(e) {
+			ex.stats.relsDeleted++
+		}
+	}
+	for _, n := range nodes {
+		if removed := ex.g.deleteNode(n); removed >= 0 {
+			ex.stats.nodesDeleted++
+			ex.stats.relsDeleted += removed
+		}
+	}
+
+	return nil
+}
+
+// Projection
+
+func cyIsAggregate(e cyExpr) bool {
+	call, ok := e.(*cyCall)
+	return ok && cyAggregates[call.name]
+}
+
+// cyValueKey identifies value for grouping and DISTINCT
+func cyValueKey(v any) string {
+	switch v := v.(type) {
+	case *graphNode:
+		return "N" + strconv.FormatInt(v.id, 10)
+	case *graphEdge:
+		return "E" + strconv.FormatInt(v.id, 10)
+	case []any:
+		parts := make([]string, len(v))
+		for i, el := range v {
+			parts[i] = cyValueKey(el)
+		}
+		return "[" + strings.Join(parts, ",") + "]"
+	case map[string]any:
+		var sb strings.Builder
+		sb.WriteByte('{')
+		for _, k := range slices.Sorted(maps.Keys(v)) {
+			sb.WriteString(strconv.Quote(k) + ":" + cyValueKey(v[k]) + ",")
+		}
+		sb.WriteByte('}')
+		return sb.String()
+	}
+
+	if f, ok := cyToFloat(v); ok {
+		return "n" + strconv.FormatFloat(f, 'g', -1, 64)
+	}
+
+	return fmt.Sprintf("%T:%v", v, v)
+}
+
+func (ex *cyExec) project(rows []cyRow, p *cyProjection) ([]string, []cyRow, error) {
+	items := p.items
+	if p.star {
+		var names []string
+		for _, row := range rows[:min(len(rows), 1)] {
+			for name := range row {
+				if !strings.HasPrefix(name, "@") {
+					names = append(names, name)
+				}
+			}
+		}
+		slices.Sort(names)
+		for _, name := range names {
+			items = append(items, cyReturnItem{expr: &cyVar{name: name}, alias: name})
+		}
+	}
+
+	columns := make([]string, len(items))
+	aggregate := false
+	for i, item := range items {
+		columns[i] = item.alias
+		aggregate = aggregate || cyIsAggregate(item.expr)
+	}
+
+	// scopes are rows that ORDER BY sees, they keep variables of the input unless rows are merged
+	var out, scopes []cyRow
+	var err error
+	if aggregate {
+		out, err = cyAggregate(rows, items)
+		scopes = out
+	} else {
+		for _, row := range rows {
+			r := make(cyRow, len(items))
+			for _, item := range items {
+				if r[item.alias], err = item.expr.eval(row); err != nil {
+					return nil, nil, err
+				}
+			}
+			out = append(out, r)
+
+			scope := maps.Clone(row)
+			maps.Copy(scope, r)
+			scopes = append(scopes, scope)
+		}
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if p.distinct {
+		seen := make(map[string]bool)
+		var dOut, dScopes []cyRow
+		for _, r := range out {
+			parts := make([]string, len(columns))
+			for j, col := range columns {
+				parts[j] = cyValueKey(r[col])
+			}
+			key := strings.Join(parts, "|")
+			if !seen[key] {
+				seen[key] = true
+				dOut = append(dOut, r)
+				dScopes = append(dScopes, r)
+			}
+		}
+		out, scopes = dOut, dScopes
+	}
+
+	if len(p.orderBy) > 0 {
+		keys := make([][]any, len(out))
+		for i, scope := range scopes {
+			keys[i] = make([]any, len(p.orderBy))
+			for j, o := range p.orderBy {
+				if keys[i][j], err = o.expr.eval(scope); err != nil {
+					return nil, nil, err
+				}
+			}
+		}
+
+		order := make([]int, len(out))
+		for i := range order {
+			order[i] = i
+		}
+		slices.SortStableFunc(order, func(a, b int) int {
+			for j, o := range p.orderBy {
+				c := cyOrderCompare(keys[a][j], keys[b][j])
+				if o.desc {
+					c = -c
+				}
+				if c != 0 {
+					return c
+				}
+			}
+			return 0
+		})
+
+		sorted := make([]cyRow, len(out))
+		for i, idx := range order {
+			sorted[i] = out[idx]
+		}
+		out = sorted
+	}
+
+	skip, err := cyCount(p.skip, 0)
+	if err != nil {
+		return nil, nil, err
+	}
+	limit, err := cyCount(p.limit, int64(len(out)))
+	if err != nil {
+		return nil, nil, err
+	}
+	skip = min(skip, int64(len(out)))
+	out = out[skip:min(int64(len(out)), skip+limit)]
+
+	if p.where != nil {
+		var filtered []cyRow
+		for _, r := range out {
+			ok, err := cyTruthy(p.where, r)
+			if err != nil {
+				return nil, nil, err
+			}
+			if ok {
+				filtered = append(filtered, r)
+			}
+		}
+		out = filtered
+	}
+
+	return columns, out, nil
+}
+
+// cyCount evaluates SKIP or LIMIT argument
+func cyCount(e cyExpr, def int64) (int64, error) {
+	if e == nil {
+		return def, nil
+	}
+
+	v, err := e.eval(cyRow{})
+	if err != nil {
+		return 0, err
+	}
+
+	n, ok := v.(int64)
+	if !ok || n < 0 {
+		return 0, ErrGraphInvalidCount
+	}
+
+	return n, nil
+}
+
+// cyAggregate groups rows by values of non aggregating items and computes aggregations in every group
+func cyAggregate(rows []cyRow, items []cyReturnItem) ([]cyRow, error) {
+	type group struct {
+		keys cyRow
+		rows []cyRow
+	}
+
+	var groups []*group
+	byKey := make(map[string]*group)
+	hasKeys := false
+
+	for _, row := range rows {
+		keys := make(cyRow)
+		var parts []string
+		for _, item := range items {
+			if cyIsAggregate(item.expr) {
+				continue
+			}
+			hasKeys = true
+			v, err := item.expr.eval(row)
+			if err != nil {
+				return nil, err
+			}
+			keys[item.alias] = v
+			parts = append(parts, cyValueKey(v))
+		}
+
+		key := strings.Join(parts, "|")
+		g, ok := byKey[key]
+		if !ok {
+			g = &group{keys: keys}
+			byKey[key] = g
+			groups = append(groups, g)
+		}
+		g.rows = append(g.rows, row)
+	}
+
+	// aggregation without grouping keys returns one row even for no input
+	if len(groups) == 0 && !hasKeys {
+		groups = append(groups, &group{keys: make(cyRow)})
+	}
+
+	out := make([]cyRow, len(groups))
+	for i, g := range groups {
+		r := g.keys
+		for _, item := range items {
+			if !cyIsAggregate(item.expr) {
+				continue
+			}
+			v, err := cyAggregateCall(item.expr.(*cyCall), g.rows)
+			if err != nil {
+				return nil, err
+			}
+			r[item.alias] = v
+		}
+		out[i] = r
+	}
+
+	return out, nil
+}
+
+func cyAggregateCall(call *cyCall, rows []cyRow) (any, error) {
+	if call.star {
+		return int64(len(rows)), nil
+	}
+	if len(call.args) != 1 {
+		return nil, cypherArgsCountErr(call.name)
+	}
+
+	var vals []any
+	seen := make(map[string]bool)
+	for _, row := range rows {
+		v, err := call.args[0].eval(row)
+		if err != nil {
+			return nil, err
+		}
+		if v == nil {
+			continue
+		}
+		if call.distinct {
+			key := cyValueKey(v)
+			if seen[key] {
+				continue
+			}
+			seen[key] = true
+		}
+		vals = append(vals, v)
+	}
+
+	switch call.name {
+	case "count":
+		return int64(len(vals)), nil
+	case "collect":
+		if vals == nil {
+			return []any{}, nil
+		}
+		return vals, nil
+	case "min", "max":
+		if len(vals) == 0 {
+			return nil, nil
+		}
+		if call.name == "min" {
+			return slices.MinFunc(vals, cyOrderCompare), nil
+		}
+		return slices.MaxFunc(vals, cyOrderCompare), nil
+	}
+
+	// sum and avg
+	var isum int64
+	var fsum float64
+	isFloat := false
+	for _, v := range vals {
+		switch v := v.(type) {
+		case int64:
+			isum += v
+			fsum += float64(v)
+		case float64:
+			isFloat = true
+			fsum += v
+		default:
+			return nil, cypherTypeErr("Integer or Float", v)
+		}
+	}
+
+	if call.name == "avg" {
+		if len(vals) == 0 {
+			return nil, nil
+		}
+		return fsum / float64(len(vals)), nil
+	}
+	if isFloat {
+		return fsum, nil
+	}
+
+	return isum, nil
+}
+
+// Plans
+
+func (np *cyNodePat) String() string {
+	var sb strings.Builder
+	sb.WriteByte('(')
+	if !strings.HasPrefix(np.name, "@") {
+		sb.WriteString(np.name)
+	}
+	for _, l := range np.labels {
+		sb.WriteString(":" + l)
+	}
+	sb.WriteByte(')')
+
+	return sb.String()
+}
+
+func (rp *cyRelPat) String() string {
+	var sb strings.Builder
+	if rp.dir < 0 {
+		sb.WriteByte('<')
+	}
+	sb.WriteString("-[")
+	if !strings.HasPrefix(rp.name, "@") {
+		sb.WriteString(rp.name)
+	}
+	if len(rp.types) > 0 {
+		sb.WriteString(":" + strings.Join(rp.types, "|"))
+	}
+	if rp.varLen {
+		sb.WriteString("*" + strconv.Itoa(rp.minHop) + "..")
+		if rp.maxHop >= 0 {
+			sb.WriteString(strconv.Itoa(rp.maxHop))
+		}
+	}
+	sb.WriteString("]-")
+	if rp.dir > 0 {
+		sb.WriteByte('>')
+	}
+
+	return sb.String()
+}
+
+// explainCypher returns operations executing query, the last executed one first. Every operation
+// consumes rows of the operation printed below it
+func explainCypher(g *graph, q *cyQuery) []string {
+	var ops []string
+	bound := make(cyRow)
+
+	for _, clause := range q.clauses {
+		switch c := clause.(type) {
+		case *cyMatch:
+			for _, pat := range c.patterns {
+				pat = orientPattern(g, pat, bound)
+				start := pat.nodes[0]
+				if kind, _, _, _ := scanKind(g, start, bound[start.name] != nil); kind != "Argument" {
+					ops = append(ops, kind+" | "+start.String())
+				}
+				bound[start.name] = true
+
+				for i, rp := range pat.rels {
+					op := "Conditional Traverse | "
+					if rp.varLen {
+						op = "Conditional Variable Length Traverse | "
+					}
+					ops = append(ops, op+pat.nodes[i].String()+rp.String()+pat.nodes[i+1].String())
+					bound[rp.name] = true
+					bound[pat.nodes[i+1].name] = true
+				}
+			}
+			if c.where != nil {
+				ops = append(ops, "Filter")
+			}
+			if c.optional {
+				ops = append(ops, "Optional")
+			}
+		case *cyCreate:
+			ops = append(ops, "Create")
+			for _, pat := range c.patterns {
+				for _, np := range pat.nodes {
+					bound[np.name] = true
+				}
+				for _, rp := range pat.rels {
+					bound[rp.name] = true
+				}
+			}
+		case *cySet:
+			ops = append(ops, "Update")
+		case *cyDelete:
+			ops = append(ops, "Delete")
+		case *cyCreateIndex:
+			ops = append(ops, "Create Index | :"+c.label+"("+c.prop+")")
+		case *cyProjection:
+			if slices.ContainsFunc(c.items, func(item cyReturnItem) bool { return cyIsAggregate(item.expr) }) {
+				ops = append(ops, "Aggregate")
+			} else {
+				ops = append(ops, "Project")
+			}
+
+			for _, step := range []struct {
+				on bool
+				op string
+			}{
+				{c.distinct, "Distinct"}, {c.orderBy != nil, "Sort"}, {c.skip != nil, "Skip"},
+				{c.limit != nil, "Limit"}, {c.where != nil, "Filter"}, {c.final, "Results"},
+			} {
+				if step.on {
+					ops = append(ops, step.op)
+				}
+			}
+
+			if !c.star {
+				bound = make(cyRow)
+				for _, item := range c.items {
+					bound[item.alias] = true
+				}
+			}
+		}
+	}
+
+	plan := make([]string, len(ops))
+	for i, op := range slices.Backward(ops) {
+		plan[len(ops)-1-i] = strings.Repeat("    ", len(ops)-1-i) + op
+	}
+
+	return plan
+}
@@ -0,0 +1,834 @@
+package main
+
+import (
+	"cmp"
+	"fmt"
+	"maps"
+	"math"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// Values of expressions are nil, int64, float64, string, bool, []any, map[string]any, *graphNode and
+// *graphEdge. Variable length relationships are bound to []any of *graphEdge. Null propagates through
+// operators, and WHERE treats it as false
+type cyRow map[string]any
+
+type cyExpr interface {
+	eval(row cyRow) (any, error)
+}
+
+type cyLiteral struct{ v any }
+type cyVar struct{ name string }
+
+type cyProp struct {
+	x   cyExpr
+	key string
+}
+
+type cyList struct{ elems []cyExpr }
+
+type cyMap struct {
+	keys []string
+	vals []cyExpr
+}
+
+type cyUnary struct {
+	op string
+	x  cyExpr
+}
+
+type cyBinary struct {
+	op   string
+	l, r cyExpr
+}
+
+type cyIsNull struct {
+	x   cyExpr
+	not bool
+}
+
+type cyCall struct {
+	name     string
+	args     []cyExpr
+	star     bool // count(*)
+	distinct bool
+}
+
+func (e *cyLiteral) eval(cyRow) (any, error) {
+	return e.v, nil
+}
+
+func (e *cyVar) eval(row cyRow) (any, error) {
+	v, ok := row[e.name]
+	if !ok {
+		return nil, cypherUndefinedVarErr(e.name)
+	}
+
+	return v, nil
+}
+
+func (e *cyProp) eval(row cyRow) (any, error) {
+	x, err := e.x.eval(row)
+	if err != nil {
+		return nil, err
+	}
+
+	switch x := x.(type) {
+	case nil:
+		return nil, nil
+	case *graphNode:
+		v, _ := getProp(x.props, e.key)
+		return v, nil
+	case *graphEdge:
+		v, _ := getProp(x.props, e.key)
+		return v, nil
+	case map[string]any:
+		return x[e.key], nil
+	}
+
+	return nil, cypherTypeErr("Map, Node or Edge", x)
+}
+
+func (e *cyList) eval(row cyRow) (any, error) {
+	list := make([]any, len(e.elems))
+	for i, el := range e.elems {
+		v, err := el.eval(row)
+		if err != nil {
+			return nil, err
+		}
+		list[i] = v
+	}
+
+	return list, nil
+}
+
+func (e *cyMap) eval(row cyRow) (any, error) {
+	m := make(map[string]any, len(e.keys))
+	for i, k := range e.keys {
+		v, err := e.vals[i].eval(row)
+		if err != nil {
+			return nil, err
+		}
+		m[k] = v
+	}
+
+	return m, nil
+}
+
+func (e *cyUnary) eval(row cyRow) (any, error) {
+	x, err := e.x.eval(row)
+	if err != nil || x == nil {
+		return nil, err
+	}
+
+	if e.op == "NOT" {
+		b, ok := x.(bool)
+		if !ok {
+			return nil, cypherTypeErr("Boolean", x)
+		}
+		return !b, nil
+	}
+
+	switch x := x.(type) {
+	case int64:
+		return -x, nil
+	case float64:
+		return -x, nil
+	}
+
+	return nil, cypherTypeErr("Integer or Float", x)
+}
+
+func (e *cyIsNull) eval(row cyRow) (any, error) {
+	x, err := e.x.eval(row)
+	if err != nil {
+		return nil, err
+	}
+
+	return (x == nil) != e.not, nil
+}
+
+func (e *cyBinary) eval(row cyRow) (any, error) {
+	switch e.op {
+	case "AND", "OR", "XOR":
+		return e.evalLogical(row)
+	}
+
+	l, err := e.l.eval(row)
+	if err != nil {
+		return nil, err
+	}
+	r, err := e.r.eval(row)
+	if err != nil {
+		return nil, err
+	}
+
+	switch e.op {
+	case "=":
+		return cyEquals(l, r), nil
+	case "<>":
+		if eq := cyEquals(l, r); eq != nil {
+			return !eq.(bool), nil
+		}
+		return nil, nil
+	case "<", "<=", ">", ">=":
+		c, ok := cyCompare(l, r)
+		if !ok {
+			return nil, nil
+		}
+		switch e.op {
+		case "<":
+			return c < 0, nil
+		case "<=":
+			return c <= 0, nil
+		case ">":
+			return c > 0, nil
+		}
+		return c >= 0, nil
+	case "IN":
+		return cyIn(l, r)
+	case "STARTS WITH", "ENDS WITH", "CONTAINS":
+		if l == nil || r == nil {
+			return nil, nil
+		}
+		ls, ok := l.(string)
+		if !ok {
+			return nil, cypherTypeErr("String", l)
+		}
+		rs, ok := r.(string)
+		if !ok {
+			return nil, cypherTypeErr("String", r)
+		}
+		switch e.op {
+		case "STARTS WITH":
+			return strings.HasPrefix(ls, rs), nil
+		case "ENDS WITH":
+			return strings.HasSuffix(ls, rs), nil
+		}
+		return strings.Contains(ls, rs), nil
+	}
+
+	return cyArithmetic(e.op, l, r)
+}
+
+// evalLogical uses three-valued logic, where null means unknown
+func (e *cyBinary) evalLogical(row cyRow) (any, error) {
+	var vals [2]any
+	for i, x := range []cyExpr{e.l, e.r} {
+		v, err := x.eval(row)
+		if err != nil {
+			return nil, err
+		}
+		if _, ok := v.(bool); v != nil && !ok {
+			return nil, cypherTypeErr("Boolean", v)
+		}
+		vals[i] = v
+	}
+
+	l, r := vals[0], vals[1]
+	switch e.op {
+	case "AND":
+		if l == false || r == false {
+			return false, nil
+		}
+		if l == nil || r == nil {
+			return nil, nil
+		}
+		return true, nil
+	case "OR":
+		if l == true || r == true {
+			return true, nil
+		}
+		if l == nil || r == nil {
+			return nil, nil
+		}
+		return false, nil
+	}
+
+	if l == nil || r == nil {
+		return nil, nil
+	}
+
+	return l != r, nil
+}
+
+func cyIn(x, list any) (any, error) {
+	if list == nil {
+		return nil, nil
+	}
+
+	elems, ok := list.([]any)
+	if !ok {
+		return nil, cypherTypeErr("List", list)
+	}
+
+	var res any = false
+	for _, el := range elems {
+		switch cyEquals(x, el) {
+		case true:
+			return true, nil
+		case nil:
+			res = nil
+		}
+	}
+
+	return res, nil
+}
+
+func cyToFloat(v any) (float64, bool) {
+	switch v := v.(type) {
+	case int64:
+		return float64(v), true
+	case float64:
+		return v, true
+	}
+
+	return 0, false
+}
+
+// cyEquals returns true, false or nil when equality is unknown because of nulls
+func cyEquals(a, b any) any {
+	if a == nil || b == nil {
+		return nil
+	}
+
+	if fa, ok := cyToFloat(a); ok {
+		fb, ok := cyToFloat(b)
+		return ok && fa == fb
+	}
+
+	switch a := a.(type) {
+	case []any:
+		b, ok := b.([]any)
+		if !ok || len(a) != len(b) {
+			return false
+		}
+		var res any = true
+		for i := range a {
+			switch cyEquals(a[i], b[i]) {
+			case false:
+				return false
+			case nil:
+				res = nil
+			}
+		}
+		return res
+	case map[string]any:
+		b, ok := b.(map[string]any)
+		if !ok || len(a) != len(b) {
+			return false
+		}
+		for k, v := range a {
+			if cyEquals(v, b[k]) != true {
+				return false
+			}
+		}
+		return true
+	}
+
+	return a == b
+}
+
+// cyCompare compares numbers, strings and booleans, ok is false for other or different types
+func cyCompare(a, b any) (int, bool) {
+	if fa, ok := cyToFloat(a); ok {
+		fb, ok := cyToFloat(b)
+		if !ok {
+			return 0, false
+		}
+		return cmp.Compare(fa, fb), true
+	}
+
+	switch a := a.(type) {
+	case string:
+		if b, ok := b.(string); ok {
+			return strings.Compare(a, b), true
+		}
+	case bool:
+		if b, ok := b.(bool); ok {
+			switch {
+			case a == b:
+				return 0, true
+			case b:
+				return -1, true
+			}
+			return 1, true
+		}
+	}
+
+	return 0, false
+}
+
+// cyOrderRank orders values of different types in ORDER BY, nulls go last
+func cyOrderRank(v any) int {
+	switch v.(type) {
+	case map[string]any:
+		return 0
+	case *graphNode:
+		return 1
+	case *graphEdge:
+		return 2
+	case []any:
+		return 3
+	case string:
+		return 4
+	case bool:
+		return 5
+	case int64, float64:
+		return 6
+	case nil:
+		return 8
+	}
+
+	return 7
+}
+
+// cyOrderCompare is a total order of values used by ORDER BY, min and max
+func cyOrderCompare(a, b any) int {
+	if ra, rb := cyOrderRank(a), cyOrderRank(b); ra != rb {
+		return cmp.Compare(ra, rb)
+	}
+
+	if c, ok := cyCompare(a, b); ok {
+		return c
+	}
+
+	switch a := a.(type) {
+	case *graphNode:
+		return cmp.Compare(a.id, b.(*graphNode).id)
+	case *graphEdge:
+		return cmp.Compare(a.id, b.(*graphEdge).id)
+	case []any:
+		return slices.CompareFunc(a, b.([]any), cyOrderCompare)
+	}
+
+	return 0
+}
+
+func cyArithmetic(op string, l, r any) (any, error) {
+	if l == nil || r == nil {
+		return nil, nil
+	}
+
+	if op == "+" {
+		if la, ok := l.([]any); ok {
+			if ra, ok := r.([]any); ok {
+				return slices.Concat(la, ra), nil
+			}
+			return append(slices.Clone(la), r), nil
+		}
+		if ra, ok := r.([]any); ok {
+			return append([]any{l}, ra...), nil
+		}
+
+		_, ls := l.(string)
+		_, rs := r.(string)
+		if ls || rs {
+			return cyToString(l) + cyToString(r), nil
+		}
+	}
+
+	li, lInt := l.(int64)
+	ri, rInt := r.(int64)
+	if lInt && rInt {
+		switch op {
+		case "+":
+			return li + ri, nil
+		case "-":
+			return li - ri, nil
+		case "*":
+			return li * ri, nil
+		}
+		if ri == 0 {
+			return nil, ErrGraphDivisionByZero
+		}
+		if op == "/" {
+			return li / ri, nil
+		}
+		return li % ri, nil
+	}
+
+	lf, ok := cyToFloat(l)
+	if !ok {
+		return nil, cypherTypeErr("Integer or Float", l)
+	}
+	rf, ok := cyToFloat(r)
+	if !ok {
+		return nil, cypherTypeErr("Integer or Float", r)
+	}
+
+	switch op {
+	case "+":
+		return lf + rf, nil
+	case "-":
+		return lf - rf, nil
+	case "*":
+		return lf * rf, nil
+	case "/":
+		return lf / rf, nil
+	}
+
+	return math.Mod(lf, rf), nil
+}
+
+func cyToString(v any) string {
+	switch v := v.(type) {
+	case nil:
+		return "NULL"
+	case string:
+		return v
+	case int64:
+		return strconv.FormatInt(v, 10)
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64)
+	case bool:
+		return strconv.FormatBool(v)
+	case []any:
+		elems := make([]string, len(v))
+		for i, el := range v {
+			elems[i] = cyToString(el)
+		}
+		return "[" + strings.Join(elems, ", ") + "]"
+	}
+
+	return fmt.Sprint(v)
+}
+
+func cyTypeName(v any) string {
+	switch v.(type) {
+	case nil:
+		return "Null"
+	case int64:
+		return "Integer"
+	case float64:
+		return "Float"
+	case string:
+		return "String"
+	case bool:
+		return "Boolean"
+	case []any:
+		return "List"
+	case map[string]any:
+		return "Map"
+	case *graphNode:
+		return "Node"
+	case *graphEdge:
+		return "Edge"
+	}
+
+	return "Unknown"
+}
+
+// Functions
+
+type cyFunc struct {
+	minArgs, maxArgs int
+	fn               func(args []any) (any, error)
+}
+
+var cyAggregates = map[string]bool{"count": true, "sum": true, "avg": true, "min": true, "max": true, "collect": true}
+
+var cyFunctions map[string]cyFunc
+
+func init() {
+	cyFunctions = map[string]cyFunc{
+		"id":         {1, 1, cyFnID},
+		"labels":     {1, 1, cyFnLabels},
+		"type":       {1, 1, cyFnType},
+		"keys":       {1, 1, cyFnKeys},
+		"properties": {1, 1, cyFnProperties},
+		"startnode":  {1, 1, func(args []any) (any, error) { return cyEdgeEnd(args[0], true) }},
+		"endnode":    {1, 1, func(args []any) (any, error) { return cyEdgeEnd(args[0], false) }},
+		"size":       {1, 1, cyFnSize},
+		"length":     {1, 1, cyFnSize},
+		"head":       {1, 1, func(args []any) (any, error) { return cyListEnd(args[0], true) }},
+		"last":       {1, 1, func(args []any) (any, error) { return cyListEnd(args[0], false) }},
+		"range":      {2, 3, cyFnRange},
+		"coalesce":   {1, -1, cyFnCoalesce},
+		"exists":     {1, 1, func(args []any) (any, error) { return args[0] != nil, nil }},
+		"toupper":    {1, 1, cyStringFn(strings.ToUpper)},
+		"tolower":    {1, 1, cyStringFn(strings.ToLower)},
+		"trim":       {1, 1, cyStringFn(strings.TrimSpace)},
+		"tostring":   {1, 1, cyFnToString},
+		"tointeger":  {1, 1, cyFnToInteger},
+		"tofloat":    {1, 1, cyFnToFloat},
+		"abs":        {1, 1, cyFnAbs},
+	}
+}
+
+func (e *cyCall) eval(row cyRow) (any, error) {
+	if cyAggregates[e.name] {
+		return nil, cypherAggregateErr(e.name)
+	}
+
+	f := cyFunctions[e.name]
+	if len(e.args) < f.minArgs || (f.maxArgs >= 0 && len(e.args) > f.maxArgs) {
+		return nil, cypherArgsCountErr(e.name)
+	}
+
+	args := make([]any, len(e.args))
+	for i, a := range e.args {
+		v, err := a.eval(row)
+		if err != nil {
+			return nil, err
+		}
+		args[i] = v
+	}
+
+	return f.fn(args)
+}
+
+func cyFnID(args []any) (any, error) {
+	switch v := args[0].(type) {
+	case nil:
+		return nil, nil
+	case *graphNode:
+		return v.id, nil
+	case *graphEdge:
+		return v.id, nil
+	}
+
+	return nil, cypherTypeErr("Node or Edge", args[0])
+}
+
+func cyFnLabels(args []any) (any, error) {
+	switch v := args[0].(type) {
+	case nil:
+		return nil, nil
+	case *graphNode:
+		labels := make([]any, len(v.labels))
+		for i, l := range v.labels {
+			labels[i] = l
+		}
+		return labels, nil
+	}
+
+	return nil, cypherTypeErr("Node", args[0])
+}
+
+func cyFnType(args []any) (any, error) {
+	switch v := args[0].(type) {
+	case nil:
+		return nil, nil
+	case *graphEdge:
+		return v.typ, nil
+	}
+
+	return nil, cypherTypeErr("Edge", args[0])
+}
+
+func cyPropsOf(v any) ([]graphProp, error) {
+	switch v := v.(type) {
+	case *graphNode:
+		return v.props, nil
+	case *graphEdge:
+		return v.props, nil
+	}
+
+	return nil, cypherTypeErr("Map, Node or Edge", v)
+}
+
+func cyFnKeys(args []any) (any, error) {
+	switch v := args[0].(type) {
+	case nil:
+		return nil, nil
+	case map[string]any:
+		keys := make([]any, 0, len(v))
+		for _, k := range slices.Sorted(maps.Keys(v)) {
+			keys = append(keys, k)
+		}
+		return keys, nil
+	}
+
+	props, err := cyPropsOf(args[0])
+	if err != nil {
+		return nil, err
+	}
+
+	keys := make([]any, len(props))
+	for i, p := range props {
+		keys[i] = p.key
+	}
+
+	return keys, nil
+}
+
+func cyFnProperties(args []any) (any, error) {
+	switch v := args[0].(type) {
+	case nil:
+		return nil, nil
+	case map[string]any:
+		return v, nil
+	}
+
+	props, err := cyPropsOf(args[0])
+	if err != nil {
+		return nil, err
+	}
+
+	m := make(map[string]any, len(props))
+	for _, p := range props {
+		m[p.key] = p.val
+	}
+
+	return m, nil
+}
+
+func cyEdgeEnd(v any, start bool) (any, error) {
+	switch v := v.(type) {
+	case nil:
+		return nil, nil
+	case *graphEdge:
+		if start {
+			return v.src, nil
+		}
+		return v.dst, nil
+	}
+
+	return nil, cypherTypeErr("Edge", v)
+}
+
+func cyFnSize(args []any) (any, error) {
+	switch v := args[0].(type) {
+	case nil:
+		return nil, nil
+	case string:
+		return int64(len([]rune(v))), nil
+	case []any:
+		return int64(len(v)), nil
+	}
+
+	return nil, cypherTypeErr("List or String", args[0])
+}
+
+func cyListEnd(v any, head bool) (any, error) {
+	switch v := v.(type) {
+	case nil:
+		return nil, nil
+	case []any:
+		if len(v) == 0 {
+			return nil, nil
+		}
+		if head {
+			return v[0], nil
+		}
+		return v[len(v)-1], nil
+	}
+
+	return nil, cypherTypeErr("List", v)
+}
+
+func cyFnRange(args []any) (any, error) {
+	bounds := []int64{0, 0, 1}
+	for i, a := range args {
+		n, ok := a.(int64)
+		if !ok {
+			return nil, cypherTypeErr("Integer", a)
+		}
+		bounds[i] = n
+	}
+
+	from, to, step := bounds[0], bounds[1], bounds[2]
+	if step == 0 {
+		return nil, ErrGraphRangeStep
+	}
+
+	var list []any
+	for n := from; (step > 0 && n <= to) || (step < 0 && n >= to); n += step {
+		list = append(list, n)
+	}
+
+	if list == nil {
+		return []any{}, nil
+	}
+
+	return list, nil
+}
+
+func cyFnCoalesce(args []any) (any, error) {
+	for _, a := range args {
+		if a != nil {
+			return a, nil
+		}
+	}
+
+	return nil, nil
+}
+
+func cyStringFn(fn func(string) string) func(args []any) (any, error) {
+	return func(args []any) (any, error) {
+		switch v := args[0].(type) {
+		case nil:
+			return nil, nil
+		case string:
+			return fn(v), nil
+		}
+
+		return nil, cypherTypeErr("String", args[0])
+	}
+}
+
+func cyFnToString(args []any) (any, error) {
+	switch args[0].(type) {
+	case nil:
+		return nil, nil
+	case *graphNode, *graphEdge, map[string]any:
+		return nil, cypherTypeErr("Integer, Float, String, Boolean or List", args[0])
+	}
+
+	return cyToString(args[0]), nil
+}
+
+func cyFnToInteger(args []any) (any, error) {
+	switch v := args[0].(type) {
+	case int64:
+		return v, nil
+	case float64:
+		return int64(v), nil
+	case string:
+		if n, err := strconv.ParseInt(strings.TrimSpace(v), 10, 64); err == nil {
+			return n, nil
+		}
+		if f, err := strconv.ParseFloat(strings.TrimSpace(v), 64); err == nil {
+			return int64(f), nil
+		}
+		return nil, nil
+	case nil:
+		return nil, nil
+	}
+
+	return nil, cypherTypeErr("Integer, Float or String", args[0])
+}
+
+func cyFnToFloat(args []any) (any, error) {
+	switch v := args[0].(type) {
+	case int64:
+		return float64(v), nil
+	case float64:
+		return v, nil
+	case string:
+		if f, err := strconv.ParseFloat(strings.TrimSpace(v), 64); err == nil {
+			return f, nil
+		}
+		return nil, nil
+	case nil:
+		return nil, nil
+	}
+
+	return nil, cypherTypeErr("Integer, Float or String", args[0])
+}
+
+func cyFnAbs(args []any) (any, error) {
+	switch v := args[0].(type) {
+	case nil:
+		return nil, nil
+	case int64:
+		return max(v, -v), nil
+	case float64:
+		return math.Abs(v), nil
+	}
+
+	return nil, cypherTypeErr("Integer or Float", args[0])
+}
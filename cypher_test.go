@@ -0,0 +1,155 @@
+package main
+
+import (
+	"slices"
+	"testing"
+)
+
+func runTestQuery(t *testing.T, g *graph, query string) *cyResult {
+	t.Helper()
+
+	q, err := parseCypher(query)
+	if err != nil {
+		t.Fatalf("parseCypher(%q) returned error: %v", query, err)
+	}
+
+	res, err := runCypher(g, q)
+	if err != nil {
+		t.Fatalf("runCypher(%q) returned error: %v", query, err)
+	}
+
+	return res
+}
+
+func testGraph(t *testing.T) *graph {
+	t.Helper()
+
+	g := newGraph()
+	runTestQuery(t, g, `CREATE (a:Person {name: 'Alice', age: 30})-[:KNOWS {since: 2010}]->(b:Person {name: 'Bob', age: 35}),
+		(b)-[:KNOWS]->(c:Person {name: 'Carol', age: 40}), (c)-[:KNOWS]->(d:Person {name: 'Dave', age: 25}),
+		(b)-[:WORKS_AT]->(:Company {name: 'Acme'}), (d)-[:WORKS_AT]->(:Company {name: 'Initech'})`)
+
+	return g
+}
+
+func TestCypherQueries(t *testing.T) {
+	g := testGraph(t)
+
+	for _, tc := range []struct {
+		query    string
+		expected [][]any
+	}{
+		{"MATCH (p:Person) WHERE p.age > 30 RETURN p.name ORDER BY p.name", [][]any{{"Bob"}, {"Carol"}}},
+		{"MATCH (:Person {name: 'Alice'})-[:KNOWS*2..3]->(f) RETURN f.name", [][]any{{"Carol"}, {"Dave"}}},
+		{"MATCH (:Person {name: 'Alice'})-[:KNOWS*]->(f) RETURN count(f)", [][]any{{int64(3)}}},
+		{"MATCH (p:Person {name: 'Dave'})<-[:KNOWS*0..]-(f) RETURN f.name ORDER BY f.age DESC LIMIT 2", [][]any{{"Carol"}, {"Bob"}}},
+		{"MATCH (a)-[:KNOWS]-(b {name: 'Bob'}) RETURN a.name ORDER BY a.name", [][]any{{"Alice"}, {"Carol"}}},
+		{"MATCH (p)-[:WORKS_AT]->(c:Company) RETURN c.name, p.name ORDER BY c.name SKIP 1", [][]any{{"Initech", "Dave"}}},
+		{"MATCH (p:Person) RETURN p.age > 30 AS old, count(*) AS n ORDER BY old", [][]any{{false, int64(2)}, {true, int64(2)}}},
+		{"MATCH (p:Person) WITH p ORDER BY p.age LIMIT 2 RETURN collect(p.name)", [][]any{{[]any{"Dave", "Alice"}}}},
+		{"MATCH (p:Person) OPTIONAL MATCH (p)-[:WORKS_AT]->(c) RETURN p.name, c.name ORDER BY p.name", [][]any{
+			{"Alice", nil}, {"Bob", "Acme"}, {"Carol", nil}, {"Dave", "Initech"},
+		}},
+		{"MATCH (p:Person) WHERE p.name STARTS WITH 'C' OR p.age IS NULL RETURN p.name", [][]any{{"Carol"}}},
+		{"MATCH (n:Nobody) RETURN count(n), sum(n.age), min(n.age)", [][]any{{int64(0), int64(0), nil}}},
+		{"RETURN 7 / 2, 7 % 3, 1.5 * 2, 'a' + 1, size([1, 2, 3]), coalesce(null, 'x')", [][]any{
+			{int64(3), int64(1), 3.0, "a1", int64(3), "x"},
+		}},
+	} {
+		if res := runTestQuery(t, g, tc.query); !slices.EqualFunc(res.rows, tc.expected, func(a, b []any) bool {
+			return cyValueKey(a) == cyValueKey(b)
+		}) {
+			t.Errorf("%s returned %v, expected %v", tc.query, res.rows, tc.expected)
+		}
+	}
+}
+
+func TestCypherUpdates(t *testing.T) {
+	g := testGraph(t)
+
+	res := runTestQuery(t, g, "MATCH (p:Person {name: 'Bob'}) SET p.age = 36, p:Manager, p += {city: 'Paris'}")
+	if res.stats.propsSet != 2 || res.stats.labelsAdded != 1 {
+		t.Errorf("SET stats are %+v", res.stats)
+	}
+
+	res = runTestQuery(t, g, "MATCH (m:Manager) RETURN m.name, m.age, m.city")
+	if len(res.rows) != 1 || !slices.Equal(res.rows[0], []any{"Bob", int64(36), "Paris"}) {
+		t.Errorf("updated node is %v", res.rows)
+	}
+
+	res = runTestQuery(t, g, "MATCH (p:Person {name: 'Bob'}) DELETE p")
+	if res.stats.nodesDeleted != 1 || res.stats.relsDeleted != 3 {
+		t.Errorf("DELETE stats are %+v", res.stats)
+	}
+
+	res = runTestQuery(t, g, "MATCH (:Person {name: 'Alice'})-[:KNOWS*]->(f) RETURN f")
+	if len(res.rows) != 0 {
+		t.Errorf("deleted node is still reachable: %v", res.rows)
+	}
+
+	res = runTestQuery(t, g, "MATCH (a:Person {name: 'Alice'}), (d:Person {name: 'Dave'}) CREATE (a)-[r:KNOWS]->(d) RETURN type(r)")
+	if res.stats.relsCreated != 1 || res.stats.nodesCreated != 0 {
+		t.Errorf("CREATE between matched nodes stats are %+v", res.stats)
+	}
+}
+
+func TestCypherIndexScan(t *testing.T) {
+	g := testGraph(t)
+	runTestQuery(t, g, "CREATE INDEX ON :Person(name)")
+
+	q, err := parseCypher("MATCH (a:Person {name: 'Carol'})-[:KNOWS]->(b) RETURN b.name")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	plan := explainCypher(g, q)
+	expected := []string{
+		"Results",
+		"    Project",
+		"        Conditional Traverse | (a:Person)-[:KNOWS]->(b)",
+		"            Node By Index Scan | (a:Person)",
+	}
+	if !slices.Equal(plan, expected) {
+		t.Errorf("plan is %q, expected %q", plan, expected)
+	}
+
+	res, err := runCypher(g, q)
+	if err != nil || len(res.rows) != 1 || res.rows[0][0] != "Dave" {
+		t.Errorf("index scan returned %v, %v", res, err)
+	}
+}
+
+func TestCypherErrors(t *testing.T) {
+	for _, query := range []string{
+		"",
+		"MATCH (n)",
+		"MATCH (n RETURN n",
+		"MATCH (n) RETURN n LIMIT",
+		"CREATE (a)-[:R]-(b)",
+		"CREATE (a)-[:R|S]->(b)",
+		"MATCH (a)<-[:R]->(b) RETURN a",
+		"RETURN unknown(1)",
+		"RETURN 'unterminated",
+	} {
+		if _, err := parseCypher(query); err == nil {
+			t.Errorf("parseCypher(%q) must fail", query)
+		}
+	}
+
+	g := testGraph(t)
+	for _, query := range []string{
+		"RETURN x",
+		"RETURN 1 / 0",
+		"MATCH (p:Person) WHERE p.name RETURN p",
+		"MATCH (p:Person) RETURN count(p) + 1",
+		"MATCH (a), (b) CREATE (a)-[:R {x: b}]->(b)",
+	} {
+		q, err := parseCypher(query)
+		if err != nil {
+			t.Fatalf("parseCypher(%q) returned error: %v", query, err)
+		}
+		if _, err = runCypher(g, q); err == nil {
+			t.Errorf("runCypher(%q) must fail", query)
+		}
+	}
+}
@@ -0,0 +1,296 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"hash/crc64"
+	"math"
+)
+
+// Serialized value is its dtype followed by data of the type. Numbers are little endian, strings and
+// lists are prefixed with u32 length:
+//   - bulk string: the bytes
+//   - integer: i64
+//   - boolean: a single byte
+//   - module value: length and name of the type, u32 encoding version and data saved by the type
+//   - other types: see dump methods of the types
+//
+// DUMP payload is serialized value followed by u16 version of the format and CRC64 of everything before it
+const dumpVersion = 1
+
+var crc64Table = crc64.MakeTable(crc64.ECMA)
+
+// appendValue appends serialized val to b
+func appendValue(b []byte, val *KvsValue) ([]byte, error) {
+	b = append(b, val.dtype)
+
+	switch val.dtype {
+	case BulkStrSymbol, BoolSymbol, IntSymbol:
+		return append(b, val.value...), nil
+	case StreamDtype:
+		return val.object.(*stream).dump(b), nil
+	case ZSetDtype:
+		return val.object.(*sortedSet).dump(b), nil
+	case JSONDtype:
+		return appendStr(b, val.object.(*jsonNode).String()), nil
+	case BloomDtype:
+		return val.object.(*bloomFilter).dump(b), nil
+	case CuckooDtype:
+		return val.object.(*cuckooFilter).dump(b), nil
+	case CMSDtype:
+		return val.object.(*countMinSketch).dump(b), nil
+	case TopKDtype:
+		return val.object.(*topK).dump(b), nil
+	case TimeSeriesDtype:
+		return val.object.(*timeSeries).dump(b), nil
+	case VectorSetDtype:
+		return val.object.(*vectorSet).dump(b), nil
+	case HashDtype:
+		return dumpHash(b, val.object.(map[string]string)), nil
+	case GraphDtype:
+		return val.object.(*graph).dump(b), nil
+	case ModuleDtype:
+		mv := val.object.(*moduleValue)
+		b = appendStr(b, mv.typ.Name)
+		b = binary.LittleEndian.AppendUint32(b, mv.typ.Version)
+		return append(b, mv.typ.Save(mv.v)...), nil
+	}
+
+	return nil, ErrDumpUnsupported
+}
+
+// decodeValue decodes value serialized with appendValue
+func decodeValue(data []byte) (*KvsValue, error) {
+	if len(data) == 0 {
+		return nil, ErrDumpBadFormat
+	}
+	dtype, data := data[0], data[1:]
+	r := &dumpReader{data: data}
+	val := &KvsValue{dtype: dtype}
+
+	switch dtype {
+	case BulkStrSymbol:
+		val.value = bytes.Clone(data)
+		return val, nil
+	case BoolSymbol:
+		if len(data) != 1 {
+			return nil, ErrDumpBadFormat
+		}
+		val.value = bytes.Clone(data)
+		return val, nil
+	case IntSymbol:
+		if len(data) != 8 {
+			return nil, ErrDumpBadFormat
+		}
+		val.value = bytes.Clone(data)
+		return val, nil
+	case StreamDtype:
+		val.object = restoreStream(r)
+	case ZSetDtype:
+		val.object = restoreSortedSet(r)
+	case JSONDtype:
+		doc, err := parseJSON(r.str())
+		if err != nil {
+			return nil, ErrDumpBadFormat
+		}
+		val.object = doc
+	case BloomDtype:
+		val.object = restoreBloomFilter(r)
+	case CuckooDtype:
+		val.object = restoreCuckooFilter(r)
+	case CMSDtype:
+		val.object = restoreCountMinSketch(r)
+	case TopKDtype:
+		val.object = restoreTopK(r)
+	case TimeSeriesDtype:
+		val.object = restoreTimeSeries(r)
+	case VectorSetDtype:
+		val.object = restoreVectorSet(r)
+	case HashDtype:
+		val.object = restoreHash(r)
+	case GraphDtype:
+		val.object = restoreGraph(r)
+	case ModuleDtype:
+		t, ok := moduleTypes[r.str()]
+		version := r.u32()
+		if !ok || r.failed {
+			return nil, ErrDumpBadFormat
+		}
+
+		v, err := t.Load(r.data, version)
+		if err != nil {
+			return nil, ErrDumpBadFormat
+		}
+		val.object = &moduleValue{typ: t, v: v}
+		return val, nil
+	default:
+		return nil, ErrDumpBadFormat
+	}
+
+	if r.failed || len(r.data) != 0 {
+		return nil, ErrDumpBadFormat
+	}
+
+	return val, nil
+}
+
+func appendU32(b []byte, n int) []byte {
+	return binary.LittleEndian.AppendUint32(b, uint32(n))
+}
+
+func appendU64(b []byte, n uint64) []byte {
+	return binary.LittleEndian.AppendUint64(b, n)
+}
+
+func appendF64(b []byte, f float64) []byte {
+	return binary.LittleEndian.AppendUint64(b, math.Float64bits(f))
+}
+
+func appendStr[S string | []byte](b []byte, s S) []byte {
+	b = appendU32(b, len(s))
+	return append(b, s...)
+}
+
+// dumpReader reads serialized data. Reading past the end marks the reader failed and returns zero
+// values, so decoders check it once when they are done
+type dumpReader struct {
+	data   []byte
+	failed bool
+}
+
+func (r *dumpReader) next(n uint64) []byte {
+	if r.failed || n > uint64(len(r.data)) {
+		r.failed = true
+		return nil
+	}
+
+	res := r.data[:n:n]
+	r.data = r.data[n:]
+
+	return res
+}
+
+func (r *dumpReader) u8() byte {
+	if b := r.next(1); b != nil {
+		return b[0]
+	}
+
+	return 0
+}
+
+func (r *dumpReader) u32() uint32 {
+	if b := r.next(4); b != nil {
+		return binary.LittleEndian.Uint32(b)
+	}
+
+	return 0
+}
+
+func (r *dumpReader) u64() uint64 {
+	if b := r.next(8); b != nil {
+		return binary.LittleEndian.Uint64(b)
+	}
+
+	return 0
+}
+
+func (r *dumpReader) i64() int64 {
+	return int64(r.u64())
+}
+
+func (r *dumpReader) f64() float64 {
+	return math.Float64frombits(r.u64())
+}
+
+func (r *dumpReader) bytes() []byte {
+	return bytes.Clone(r.next(uint64(r.u32())))
+}
+
+func (r *dumpReader) str() string {
+	return string(r.next(uint64(r.u32())))
+}
+
+// count reads number of elements taking at least minSize bytes each, so a broken count can't make
+// decoder allocate more than the data can hold
+func (r *dumpReader) count(minSize int) int {
+	n := uint64(r.u32())
+	if n*uint64(minSize) > uint64(len(r.data)) {
+		r.failed = true
+		return 0
+	}
+
+	return int(n)
+}
+
+// check marks the reader failed unless decoded data is consistent
+func (r *dumpReader) check(ok bool) {
+	if !ok {
+		r.failed = true
+	}
+}
+
+// dumpValue builds DUMP payload of val
+func dumpValue(val *KvsValue) ([]byte, error) {
+	b, err := appendValue(nil, val)
+	if err != nil {
+		return nil, err
+	}
+	b = binary.LittleEndian.AppendUint16(b, dumpVersion)
+
+	return binary.LittleEndian.AppendUint64(b, crc64.Checksum(b, crc64Table)), nil
+}
+
+// restoreValue decodes DUMP payload
+func restoreValue(payload []byte) (*KvsValue, error) {
+	if len(payload) < 2+8 {
+		return nil, ErrDumpBadPayload
+	}
+
+	body, sum := payload[:len(payload)-8], binary.LittleEndian.Uint64(payload[len(payload)-8:])
+	if crc64.Checksum(body, crc64Table) != sum || binary.LittleEndian.Uint16(body[len(body)-2:]) != dumpVersion {
+		return nil, ErrDumpBadPayload
+	}
+
+	return decodeValue(body[:len(body)-2])
+}
+
+// copyValue returns independent copy of val without time to live
+func copyValue(val *KvsValue) (*KvsValue, error) {
+	switch {
+	case val.isScalar():
+		return &KvsValue{dtype: val.dtype, value: bytes.Clone(val.value)}, nil
+	case val.dtype == ModuleDtype:
+		if mv := val.object.(*moduleValue); mv.typ.Copy != nil {
+			return &KvsValue{dtype: val.dtype, object: &moduleValue{typ: mv.typ, v: mv.typ.Copy(mv.v)}}, nil
+		}
+	}
+
+	b, err := appendValue(nil, val)
+	if err != nil {
+		return nil, err
+	}
+
+	return decodeValue(b)
+}
+
+// memoryUsage estimates number of bytes used by key and val
+func memoryUsage(key string, val *KvsValue) (int, error) {
+	// map entry, KvsValue and key
+	size := 64 + len(key)
+
+	switch {
+	case val.isScalar():
+		return size + len(val.value), nil
+	case val.dtype == ModuleDtype:
+		if mv := val.object.(*moduleValue); mv.typ.MemUsage != nil {
+			return size + mv.typ.MemUsage(mv.v), nil
+		}
+	}
+
+	b, err := appendValue(nil, val)
+	if err != nil {
+		return 0, err
+	}
+
+	return size + len(b), nil
+}
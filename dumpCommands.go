@@ -0,0 +1,165 @@
+package main
+
+import "strings"
+
+// DUMP key
+func dumpHandler(args []*KvsValue) ([]byte, error) {
+	val, ok := lookupKey(argToString(args[0]))
+	if !ok {
+		return []byte(NullResponse), nil
+	}
+
+	payload, err := dumpValue(val)
+	if err != nil {
+		return nil, err
+	}
+
+	return bulkStrResponse(payload), nil
+}
+
+// RESTORE key ttl payload [REPLACE] [ABSTTL]
+func restoreHandler(args []*KvsValue) ([]byte, error) {
+	key := argToString(args[0])
+
+	ttl, err := argToInt64(args[1])
+	if err != nil {
+		return nil, err
+	}
+	if ttl < 0 {
+		return nil, ErrInvalidTTL
+	}
+
+	replace, absTTL := false, false
+	for _, arg := range args[3:] {
+		switch strings.ToUpper(argToString(arg)) {
+		case "REPLACE":
+			replace = true
+		case "ABSTTL":
+			absTTL = true
+		default:
+			return nil, ErrSyntax
+		}
+	}
+
+	if _, exists := kvs.storage.Get(key); exists && !replace {
+		return nil, ErrBusyKey
+	}
+
+	val, err := restoreValue(args[2].value)
+	if err != nil {
+		return nil, err
+	}
+
+	at := ttl
+	if ttl > 0 && !absTTL {
+		at += nowMs()
+	}
+
+	switch {
+	case ttl == 0:
+		storeKey(key, val)
+	case at <= nowMs():
+		// already expired, so it only replaces the key
+		if removeKey(key) {
+			signalModifiedKey(key, notifyGeneric, "del")
+		}
+		return []byte(OkResponse), nil
+	default:
+		setExpire(key, val, at)
+	}
+	signalModifiedKey(key, notifyGeneric, "restore")
+
+	return []byte(OkResponse), nil
+}
+
+// COPY source destination [REPLACE]
+func copyHandler(args []*KvsValue) ([]byte, error) {
+	src, dst := argToString(args[0]), argToString(args[1])
+
+	replace := false
+	for _, arg := range args[2:] {
+		if strings.ToUpper(argToString(arg)) != "REPLACE" {
+			return nil, ErrSyntax
+		}
+		replace = true
+	}
+
+	val, ok := lookupKey(src)
+	if !ok {
+		return boolIntResponse(false), nil
+	}
+	if _, exists := kvs.storage.Get(dst); exists && (!replace || src == dst) {
+		return boolIntResponse(false), nil
+	}
+
+	cp, err := copyValue(val)
+	if err != nil {
+		return nil, err
+	}
+
+	if val.expireAt > 0 {
+		setExpire(dst, cp, val.expireAt)
+	} else {
+		storeKey(dst, cp)
+	}
+	signalModifiedKey(dst, notifyGeneric, "copy_to")
+
+	return boolIntResponse(true), nil
+}
+
+// MEMORY USAGE key [SAMPLES count] | STATS
+func memoryHandler(args []*KvsValue) ([]byte, error) {
+	switch strings.ToUpper(argToString(args[0])) {
+	case "USAGE":
+		return memoryUsageHandler(args)
+	case "STATS":
+		if len(args) != 1 {
+			return nil, wrongArgsCountErr("MEMORY|STATS")
+		}
+		return memoryStatsHandler()
+	default:
+		return nil, ErrUnknownSubcommand
+	}
+}
+
+func memoryUsageHandler(args []*KvsValue) ([]byte, error) {
+	if len(args) != 2 && len(args) != 4 {
+		return nil, wrongArgsCountErr("MEMORY|USAGE")
+	}
+	if len(args) == 4 {
+		if strings.ToUpper(argToString(args[2])) != "SAMPLES" {
+			return nil, ErrSyntax
+		}
+		if _, err := argToInt64(args[3]); err != nil {
+			return nil, err
+		}
+	}
+
+	key := argToString(args[1])
+	val, ok := lookupKey(key)
+	if !ok {
+		return []byte(NullResponse), nil
+	}
+
+	size, err := memoryUsage(key, val)
+	if err != nil {
+		return nil, err
+	}
+
+	return intResponse(int64(size)), nil
+}
+
+// memoryStatsHandler reports the storage engine and its counters prefixed with "storage."
+func memoryStatsHandler() ([]byte, error) {
+	resp := 2
+	if kvs.client != nil {
+		resp = kvs.client.resp
+	}
+
+	pairs := [][]byte{bulkStrResponse([]byte("storage.engine")), bulkStrResponse([]byte(config.storageEngine))}
+	for _, s := range kvs.storage.Stats() {
+		pairs = append(pairs, bulkStrResponse([]byte("storage."+s.name)), intResponse(s.value))
+	}
+
+	return mapResponse(resp, pairs...), nil
+}
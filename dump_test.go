@@ -0,0 +1,111 @@
+package main
+
+import (
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func TestDumpAllTypes(t *testing.T) {
+	initStorage()
+	var tx txState
+
+	for _, tc := range []struct {
+		setup [][]string
+		// commands run on the key and its restored copy, the key is the first argument
+		check [][]string
+	}{
+		{
+			setup: [][]string{
+				{"XADD", "key", "1-1", "a", "1"},
+				{"XADD", "key", "2-1", "b", "2", "c", "3"},
+				{"XADD", "key", "3-1", "a", "4"},
+				{"XGROUP", "CREATE", "key", "g", "0"},
+				{"XREADGROUP", "GROUP", "g", "alice", "COUNT", "2", "STREAMS", "key", ">"},
+				{"XDEL", "key", "2-1"},
+			},
+			check: [][]string{{"XRANGE", "-", "+"}, {"XPENDING", "g", "-", "+", "10"}, {"XLEN"}},
+		},
+		{
+			setup: [][]string{{"GEOADD", "key", "13.361389", "38.115556", "Palermo", "15.087269", "37.502669", "Catania"}},
+			check: [][]string{{"GEOPOS", "Palermo", "Catania"}, {"GEOSEARCH", "FROMLONLAT", "15", "37", "BYRADIUS", "200", "km", "ASC"}},
+		},
+		{
+			setup: [][]string{{"JSON.SET", "key", "$", `{"a":[1,2.5,"x",true,null],"b":{"z":1,"c":{}}}`}},
+			check: [][]string{{"JSON.GET"}},
+		},
+		{
+			setup: [][]string{{"BF.RESERVE", "key", "0.01", "4"}, {"BF.MADD", "key", "a", "b", "c", "d", "e", "f", "g"}},
+			check: [][]string{{"BF.MEXISTS", "a", "g", "x", "y"}, {"BF.CARD"}},
+		},
+		{
+			setup: [][]string{{"CF.RESERVE", "key", "64"}, {"CF.ADD", "key", "a"}, {"CF.ADD", "key", "a"}},
+			check: [][]string{{"CF.MEXISTS", "a", "b"}, {"CF.COUNT", "a"}},
+		},
+		{
+			setup: [][]string{{"CMS.INITBYDIM", "key", "100", "5"}, {"CMS.INCRBY", "key", "a", "3", "b", "1"}},
+			check: [][]string{{"CMS.QUERY", "a", "b", "c"}, {"CMS.INFO"}},
+		},
+		{
+			setup: [][]string{{"TOPK.RESERVE", "key", "2"}, {"TOPK.ADD", "key", "a", "b", "a", "c", "a"}},
+			check: [][]string{{"TOPK.LIST", "WITHCOUNT"}},
+		},
+		{
+			setup: [][]string{
+				{"TS.CREATE", "key", "LABELS", "sensor", "1"},
+				{"TS.CREATE", "avg"},
+				{"TS.CREATERULE", "key", "avg", "AGGREGATION", "avg", "10"},
+				{"TS.ADD", "key", "1", "1.5"},
+				{"TS.ADD", "key", "5", "2"},
+				{"TS.ADD", "key", "3", "-1"},
+			},
+			check: [][]string{{"TS.RANGE", "-", "+"}, {"TS.GET"}},
+		},
+		{
+			setup: [][]string{
+				{"VADD", "key", "VALUES", "2", "1", "0", "a", "Q8"},
+				{"VADD", "key", "VALUES", "2", "0", "1", "b"},
+				{"VADD", "key", "VALUES", "2", "1", "1", "c"},
+				{"VREM", "key", "b"},
+			},
+			check: [][]string{{"VSIM", "VALUES", "2", "1", "0.5", "WITHSCORES"}, {"VCARD"}},
+		},
+		{
+			setup: [][]string{{"HSET", "key", "f", "v", "g", "w"}},
+			check: [][]string{{"HGETALL"}},
+		},
+		{
+			setup: [][]string{{"GRAPH.QUERY", "key", "CREATE (:P {name: 'a', n: [1, 2.5, true]})-[:K {w: 1}]->(:P:Q {name: 'b'})"}},
+			check: [][]string{{"GRAPH.QUERY", "MATCH (a)-[r:K]->(b:Q) RETURN a.name, a.n, r.w, b.name"}},
+		},
+	} {
+		initStorage()
+		for _, cmd := range tc.setup {
+			if reply := dispatchTest(t, &tx, cmd[0], cmd[1:]...); strings.HasPrefix(reply, "-") {
+				t.Fatalf("%v: %q", cmd, reply)
+			}
+		}
+
+		dump := dispatchTest(t, &tx, "DUMP", "key")
+		if !strings.HasPrefix(dump, "$") {
+			t.Fatalf("DUMP %s: %q", tc.setup[0][0], dump)
+		}
+		n, _ := strconv.Atoi(dump[1:strings.Index(dump, CRLF)])
+		payload := dump[len(dump)-2-n : len(dump)-2]
+
+		if reply := dispatchTest(t, &tx, "RESTORE", "copy", "0", payload); reply != OkResponse {
+			t.Fatalf("RESTORE %s: %q", tc.setup[0][0], reply)
+		}
+
+		for _, cmd := range tc.check {
+			expected := dispatchTest(t, &tx, cmd[0], append([]string{"key"}, cmd[1:]...)...)
+			got := dispatchTest(t, &tx, cmd[0], append([]string{"copy"}, cmd[1:]...)...)
+			// graph replies end with time of the query
+			expected, _, _ = strings.Cut(expected, "Query internal execution time")
+			got, _, _ = strings.Cut(got, "Query internal execution time")
+			if got != expected {
+				t.Errorf("%v of restored value: got %q, expected: %q", cmd, got, expected)
+			}
+		}
+	}
+}
@@ -2,6 +2,7 @@ package main
 
 import (
 	"encoding/binary"
+	"math"
 	"strconv"
 	"unicode"
 )
@@ -56,7 +57,46 @@ func decodeBool(boolBytesVal []byte) string {
 }
 
 func decodeInt(intBytesVal []byte) string {
-	intVal := int(binary.NativeEndian.Uint64(intBytesVal))
+	intVal := int(binary.LittleEndian.Uint64(intBytesVal))
 
 	return strconv.Itoa(intVal)
 }
+
+// command args may come either as bulk strings (that's what redis-cli sends) or as typed RESP values,
+// so handlers that need plain text or numbers from args should go through these helpers
+func argToString(arg *KvsValue) string {
+	switch arg.dtype {
+	case IntSymbol:
+		return decodeInt(arg.value)
+	case BoolSymbol:
+		return decodeBool(arg.value)
+	default:
+		return string(arg.value)
+	}
+}
+
+func argToInt64(arg *KvsValue) (int64, error) {
+	if arg.dtype == IntSymbol {
+		return int64(binary.LittleEndian.Uint64(arg.value)), nil
+	}
+
+	res, err := strconv.ParseInt(argToString(arg), 10, 64)
+	if err != nil {
+		return 0, ErrNotInteger
+	}
+
+	return res, nil
+}
+
+func argToFloat64(arg *KvsValue) (float64, error) {
+	if arg.dtype == IntSymbol {
+		return float64(int64(binary.LittleEndian.Uint64(arg.value))), nil
+	}
+
+	res, err := strconv.ParseFloat(argToString(arg), 64)
+	if err != nil || math.IsNaN(res) {
+		return 0, ErrNotFloat
+	}
+
+	return res, nil
+}
@@ -0,0 +1,107 @@
+package main
+
+import (
+	"iter"
+	"maps"
+	"slices"
+)
+
+// Storage engine keeps the keyspace behind kvs.storage, commands only go through lookupKey, storeKey,
+// removeKey and friends. Engines are registered by name and chosen on start with -storage-engine. All
+// methods are called with kvs.mu held.
+//
+// Get may return the stored value or a copy of it. Commands change values in place, and every value taken
+// by a write command is set back with Set before its batch ends, so an engine keeping copies sees all changes
+type storageEngine interface {
+	Get(key string) (*KvsValue, bool)
+	Set(key string, val *KvsValue)
+	// Delete removes key and tells whether it existed
+	Delete(key string) bool
+	Len() int
+	// All iterates over keys in any order. Keys may be changed between steps of the iteration, as background
+	// snapshots release kvs.mu between them: a key deleted before it's reached mustn't be produced, a key
+	// created meanwhile may be produced or not, and no key is produced twice
+	All() iter.Seq2[string, *KvsValue]
+	// Batch runs fn, which is a command or a part of a loaded file, and applies changes made by it atomically.
+	// Batches of commands run by EXEC or a script are nested in the batch of EXEC or the script. Error of
+	// the engine is returned, fn itself can't fail
+	Batch(fn func()) error
+	// Stats returns counters shown by MEMORY STATS
+	Stats() []storageStat
+}
+
+type storageStat struct {
+	name  string
+	value int64
+}
+
+// storageEngines creates engines by name
+var storageEngines = map[string]func() storageEngine{
+	"map": newMapEngine,
+}
+
+const defaultStorageEngine = "map"
+
+func storageEngineNames() []string {
+	return slices.Sorted(maps.Keys(storageEngines))
+}
+
+// mapEngine is the default engine, a Go map
+type mapEngine struct {
+	m                         map[string]*KvsValue
+	gets, sets, dels, batches int64
+}
+
+func newMapEngine() storageEngine {
+	return &mapEngine{m: make(map[string]*KvsValue)}
+}
+
+func (e *mapEngine) Get(key string) (*KvsValue, bool) {
+	e.gets++
+	val, ok := e.m[key]
+
+	return val, ok
+}
+
+func (e *mapEngine) Set(key string, val *KvsValue) {
+	e.sets++
+	e.m[key] = val
+}
+
+func (e *mapEngine) Delete(key string) bool {
+	if _, ok := e.m[key]; !ok {
+		return false
+	}
+
+	e.dels++
+	delete(e.m, key)
+
+	return true
+}
+
+func (e *mapEngine) Len() int {
+	return len(e.m)
+}
+
+// All relies on the Go map iteration, which allows changes of the map during it
+func (e *mapEngine) All() iter.Seq2[string, *KvsValue] {
+	return maps.All(e.m)
+}
+
+// Batch just runs fn, changes of the map are atomic under kvs.mu
+func (e *mapEngine) Batch(fn func()) error {
+	e.batches++
+	fn()
+
+	return nil
+}
+
+func (e *mapEngine) Stats() []storageStat {
+	return []storageStat{
+		{"keys", int64(len(e.m))},
+		{"gets", e.gets},
+		{"sets", e.sets},
+		{"deletes", e.dels},
+		{"batches", e.batches},
+	}
+}
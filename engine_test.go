@@ -0,0 +1,216 @@
+package main
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+// countingEngine is an instrumented engine over the map engine, it can fail batches
+type countingEngine struct {
+	storageEngine
+	batches, depth, maxDepth int
+	fail                     error
+}
+
+func (e *countingEngine) Batch(fn func()) error {
+	e.batches++
+	e.depth++
+	e.maxDepth = max(e.maxDepth, e.depth)
+	defer func() { e.depth-- }()
+
+	if err := e.storageEngine.Batch(fn); err != nil {
+		return err
+	}
+
+	return e.fail
+}
+
+// copyingEngine keeps its own copies of values and hands out copies, as engines that persist data do
+type copyingEngine struct {
+	storageEngine
+}
+
+func copyOf(val *KvsValue) *KvsValue {
+	c, err := copyValue(val)
+	if err != nil {
+		panic(err)
+	}
+	c.expireAt, c.savedGen = val.expireAt, val.savedGen
+
+	return c
+}
+
+func (e copyingEngine) Get(key string) (*KvsValue, bool) {
+	val, ok := e.storageEngine.Get(key)
+	if !ok {
+		return nil, false
+	}
+
+	return copyOf(val), true
+}
+
+func (e copyingEngine) Set(key string, val *KvsValue) {
+	e.storageEngine.Set(key, copyOf(val))
+}
+
+// testEngine makes kvs run on engine registered as "counting"
+func testEngine(t *testing.T) *countingEngine {
+	t.Helper()
+
+	engine := &countingEngine{storageEngine: newMapEngine()}
+	prev := config
+	storageEngines["counting"] = func() storageEngine { return engine }
+	config.storageEngine = "counting"
+	t.Cleanup(func() {
+		delete(storageEngines, "counting")
+		config = prev
+		initStorage()
+	})
+	initStorage()
+
+	return engine
+}
+
+func TestStorageEngineSelection(t *testing.T) {
+	engine := testEngine(t)
+	var tx txState
+
+	for _, step := range []struct {
+		cmd   []string
+		reply string
+	}{
+		{[]string{"SET", "a", "1"}, OkResponse},
+		{[]string{"SET", "b", "2"}, OkResponse},
+		{[]string{"HSET", "h", "f", "v"}, ":1\r\n"},
+		{[]string{"DELETE", "a"}, OkResponse},
+		{[]string{"DBSIZE"}, ":2\r\n"},
+		{[]string{"CONFIG", "GET", "storage-engine"}, "*2\r\n$14\r\nstorage-engine\r\n$8\r\ncounting\r\n"},
+		{[]string{"CONFIG", "SET", "storage-engine", "map"}, configImmutableErr("storage-engine").Error()},
+	} {
+		if got := dispatchTest(t, &tx, step.cmd[0], step.cmd[1:]...); got != step.reply {
+			t.Errorf("%v: got %q, expected: %q", step.cmd, got, step.reply)
+		}
+	}
+
+	kvs.mu.Lock()
+	if _, ok := kvs.storage.(*countingEngine); !ok {
+		t.Errorf("keyspace is kept by %T", kvs.storage)
+	}
+	kvs.mu.Unlock()
+	if engine.batches != 7 {
+		t.Errorf("%d batches for 7 commands", engine.batches)
+	}
+
+	// commands of EXEC are nested in its batch
+	engine.maxDepth = 0
+	dispatchTest(t, &tx, "MULTI")
+	dispatchTest(t, &tx, "SET", "c", "3")
+	dispatchTest(t, &tx, "SET", "d", "4")
+	if got := dispatchTest(t, &tx, "EXEC"); got != "*2\r\n"+OkResponse+OkResponse {
+		t.Fatalf("EXEC: %q", got)
+	}
+	if engine.maxDepth != 2 {
+		t.Errorf("batches of EXEC are nested %d deep", engine.maxDepth)
+	}
+
+	// stats of the map engine go through the wrapper
+	got := dispatchTest(t, &tx, "MEMORY", "STATS")
+	expected := "*12\r\n$14\r\nstorage.engine\r\n$8\r\ncounting\r\n$12\r\nstorage.keys\r\n:4\r\n"
+	if len(got) < len(expected) || got[:len(expected)] != expected {
+		t.Errorf("MEMORY STATS: %q", got)
+	}
+}
+
+func TestStorageEngineError(t *testing.T) {
+	engine := testEngine(t)
+	var tx txState
+
+	dispatchTest(t, &tx, "SET", "s", "v")
+
+	engine.fail = errors.New("disk is full")
+	if got, expected := dispatchTest(t, &tx, "SET", "a", "1"), storageEngineErr("disk is full").Error(); got != expected {
+		t.Errorf("SET with failing engine: got %q, expected: %q", got, expected)
+	}
+	// error of the command itself wins
+	if got := dispatchTest(t, &tx, "HSET", "s", "f", "v"); got != ErrWrongType.Error() {
+		t.Errorf("HSET of a string with failing engine: %q", got)
+	}
+
+	engine.fail = nil
+	if got := dispatchTest(t, &tx, "GET", "s"); got != "$1\r\nv\r\n" {
+		t.Errorf("GET s: %q", got)
+	}
+}
+
+// Changes made by commands in place reach engine that keeps copies, failed writes aren't logged
+func TestStorageEngineCopies(t *testing.T) {
+	testAOF(t)
+	var engine *countingEngine
+	storageEngines["copying"] = func() storageEngine {
+		engine = &countingEngine{storageEngine: copyingEngine{newMapEngine()}}
+		return engine
+	}
+	prev := config.storageEngine
+	config.storageEngine = "copying"
+	t.Cleanup(func() {
+		delete(storageEngines, "copying")
+		config.storageEngine = prev
+	})
+	initStorage()
+	var tx txState
+
+	for _, step := range []struct {
+		cmd   []string
+		reply string
+	}{
+		{[]string{"HSET", "h", "a", "1"}, ":1\r\n"},
+		{[]string{"HSET", "h", "b", "2"}, ":1\r\n"},
+		{[]string{"HLEN", "h"}, ":2\r\n"},
+		{[]string{"XADD", "s", "1-1", "f", "v"}, "$3\r\n1-1\r\n"},
+		{[]string{"XADD", "s", "2-1", "f", "v"}, "$3\r\n2-1\r\n"},
+		{[]string{"XGROUP", "CREATE", "s", "g", "0"}, OkResponse},
+		{[]string{"XREADGROUP", "GROUP", "g", "c", "COUNT", "1", "STREAMS", "s", ">"}, "*1\r\n*2\r\n$1\r\ns\r\n*1\r\n*2\r\n$3\r\n1-1\r\n*2\r\n$1\r\nf\r\n$1\r\nv\r\n"},
+		{[]string{"XLEN", "s"}, ":2\r\n"},
+		{[]string{"XPENDING", "s", "g"}, "*4\r\n:1\r\n$3\r\n1-1\r\n$3\r\n1-1\r\n*1\r\n*2\r\n$1\r\nc\r\n$1\r\n1\r\n"},
+		{[]string{"RENAME", "h", "h2"}, OkResponse},
+		{[]string{"HGET", "h2", "b"}, "$1\r\n2\r\n"},
+		{[]string{"EVAL", "redis.call('HSET', 'h2', 'c', '3'); return redis.call('HLEN', 'h2')", "0"}, ":3\r\n"},
+		{[]string{"HGET", "h2", "c"}, "$1\r\n3\r\n"},
+	} {
+		if got := dispatchTest(t, &tx, step.cmd[0], step.cmd[1:]...); got != step.reply {
+			t.Errorf("%v: got %q, expected: %q", step.cmd, got, step.reply)
+		}
+	}
+
+	// blocked command takes the stream again after it wakes up
+	dispatchTest(t, &tx, "XREADGROUP", "GROUP", "g", "c", "STREAMS", "s", ">")
+	done := make(chan string)
+	go func() {
+		var tx txState
+		done <- dispatchTest(t, &tx, "XREADGROUP", "GROUP", "g", "c", "BLOCK", "0", "STREAMS", "s", ">")
+	}()
+	for blocked := false; !blocked; time.Sleep(time.Millisecond) {
+		kvs.mu.Lock()
+		blocked = len(kvs.keyWaiters["s"]) > 0
+		kvs.mu.Unlock()
+	}
+	dispatchTest(t, &tx, "XADD", "s", "3-1", "f", "v")
+	<-done
+	if got := dispatchTest(t, &tx, "XPENDING", "s", "g"); got != "*4\r\n:3\r\n$3\r\n1-1\r\n$3\r\n3-1\r\n*1\r\n*2\r\n$1\r\nc\r\n$1\r\n3\r\n" {
+		t.Errorf("XPENDING after blocked XREADGROUP: %q", got)
+	}
+
+	engine.fail = errors.New("disk is full")
+	dispatchTest(t, &tx, "SET", "lost", "1")
+	engine.fail = nil
+	if err := restartAOF(t, true); err != nil {
+		t.Fatal(err)
+	}
+	if got := dispatchTest(t, &tx, "GET", "lost"); got != NullResponse {
+		t.Errorf("failed SET is replayed: %q", got)
+	}
+	if got := dispatchTest(t, &tx, "HLEN", "h2"); got != ":3\r\n" {
+		t.Errorf("HLEN h2 after replay: %q", got)
+	}
+}
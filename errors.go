@@ -1,6 +1,10 @@
 package main
 
-import "errors"
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
 
 const ErrorSymbol = '-'
 
@@ -11,14 +15,348 @@ var (
 	ErrIncorrectDataLen     = errors.New(string(ErrorSymbol) + "ERR data length must be non-negative non-zero integer" + CRLF)
 	ErrBulkStrLenMismatch   = errors.New(string(ErrorSymbol) + "ERR bulk string length is not correct" + CRLF)
 	ErrInvalidRESP          = errors.New(string(ErrorSymbol) + "ERR invalid RESP" + CRLF)
+	ErrMultibulkLen         = errors.New(string(ErrorSymbol) + "ERR Protocol error: invalid multibulk length" + CRLF)
+	ErrBulkLen              = errors.New(string(ErrorSymbol) + "ERR Protocol error: invalid bulk length" + CRLF)
 	ErrInvalidSetCmd        = errors.New(string(ErrorSymbol) + "ERR SET command must contain key and value" + CRLF)
 	ErrCmdNotSupported      = errors.New(string(ErrorSymbol) + "ERR unsupported command. Supported commands: SET, GET, PING" + CRLF)
 	ErrDtypeNotSupported    = errors.New(string(ErrorSymbol) + "ERR unsupported data type. Supported types: integer, boolean, bulk string" + CRLF)
 	ErrInvalidIntVal        = errors.New(string(ErrorSymbol) + "ERR Invalid integer value" + CRLF)
 	ErrInvalidBoolVal       = errors.New(string(ErrorSymbol) + "ERR Invalid boolean value" + CRLF)
-	ErrWrongSetArgsCount    = errors.New(string(ErrorSymbol) + "ERR SET command requires 2 args: key and value" + CRLF)
-	ErrWrongGetArgsCount    = errors.New(string(ErrorSymbol) + "ERR GET command requires 1 arg: key" + CRLF)
-	ErrWrongDelArgsCount    = errors.New(string(ErrorSymbol) + "ERR DELETE command requires 1 arg: key" + CRLF)
 	ErrWrongKeyDtype        = errors.New(string(ErrorSymbol) + "ERR key datatype must be bulk string" + CRLF)
 	ErrKeyNotExist          = errors.New(string(ErrorSymbol) + "ERR key does not exist" + CRLF)
+	ErrWrongType            = errors.New(string(ErrorSymbol) + "WRONGTYPE Operation against a key holding the wrong kind of value" + CRLF)
+	ErrSyntax               = errors.New(string(ErrorSymbol) + "ERR syntax error" + CRLF)
+	ErrNotInteger           = errors.New(string(ErrorSymbol) + "ERR value is not an integer or out of range" + CRLF)
+	ErrNotFloat             = errors.New(string(ErrorSymbol) + "ERR value is not a valid float" + CRLF)
+	ErrTimeoutNegative      = errors.New(string(ErrorSymbol) + "ERR timeout is negative" + CRLF)
+	ErrUnknownSubcommand    = errors.New(string(ErrorSymbol) + "ERR unknown subcommand" + CRLF)
+
+	// streams
+	ErrInvalidStreamID      = errors.New(string(ErrorSymbol) + "ERR Invalid stream ID specified as stream command argument" + CRLF)
+	ErrStreamIDTooSmall     = errors.New(string(ErrorSymbol) + "ERR The ID specified in XADD is equal or smaller than the target stream top item" + CRLF)
+	ErrStreamIDZero         = errors.New(string(ErrorSymbol) + "ERR The ID specified in XADD must be greater than 0-0" + CRLF)
+	ErrStreamLimitNoApprox  = errors.New(string(ErrorSymbol) + "ERR syntax error, LIMIT cannot be used without the special ~ option" + CRLF)
+	ErrStreamUnbalancedRead = errors.New(string(ErrorSymbol) + "ERR Unbalanced XREAD list of streams: for each stream key an ID or '$' must be specified" + CRLF)
+	ErrStreamGroupDollar    = errors.New(string(ErrorSymbol) + "ERR The $ ID is meaningless in the context of XREADGROUP" + CRLF)
+	ErrStreamNoGroup        = errors.New(string(ErrorSymbol) + "NOGROUP No such key or consumer group" + CRLF)
+	ErrStreamBusyGroup      = errors.New(string(ErrorSymbol) + "BUSYGROUP Consumer Group name already exists" + CRLF)
+	ErrStreamKeyRequired    = errors.New(string(ErrorSymbol) + "ERR The XGROUP subcommand requires the key to exist. Note that for CREATE you may want to use the MKSTREAM option to create an empty stream automatically" + CRLF)
+	ErrStreamNoSuchKey      = errors.New(string(ErrorSymbol) + "ERR no such key" + CRLF)
+
+	// hyperloglog
+	ErrHLLWrongType = errors.New(string(ErrorSymbol) + "WRONGTYPE Key is not a valid HyperLogLog string value." + CRLF)
+	ErrHLLCorrupted = errors.New(string(ErrorSymbol) + "INVALIDOBJ Corrupted HLL object detected" + CRLF)
+
+	// geo
+	ErrGeoUnsupportedUnit  = errors.New(string(ErrorSymbol) + "ERR unsupported unit provided. please use M, KM, FT, MI" + CRLF)
+	ErrGeoNoShape          = errors.New(string(ErrorSymbol) + "ERR exactly one of BYRADIUS and BYBOX arguments must be provided for GEOSEARCH command" + CRLF)
+	ErrGeoNoCenter         = errors.New(string(ErrorSymbol) + "ERR exactly one of FROMMEMBER or FROMLONLAT can be specified for GEOSEARCH" + CRLF)
+	ErrGeoMemberNotFound   = errors.New(string(ErrorSymbol) + "ERR could not decode requested zset member" + CRLF)
+	ErrGeoCountNotPositive = errors.New(string(ErrorSymbol) + "ERR COUNT must be > 0" + CRLF)
+	ErrGeoAnyWithoutCount  = errors.New(string(ErrorSymbol) + "ERR the ANY argument requires COUNT argument" + CRLF)
+	ErrGeoNegativeRadius   = errors.New(string(ErrorSymbol) + "ERR radius cannot be negative" + CRLF)
+	ErrGeoNegativeSize     = errors.New(string(ErrorSymbol) + "ERR height or width cannot be negative" + CRLF)
+	ErrGeoNxXx             = errors.New(string(ErrorSymbol) + "ERR XX and NX options at the same time are not compatible" + CRLF)
+	ErrGeoStoreWith        = errors.New(string(ErrorSymbol) + "ERR WITHCOORD, WITHDIST and WITHHASH options are not allowed in GEOSEARCHSTORE" + CRLF)
+
+	// bloom and cuckoo filters, count-min sketch, top-k
+	ErrBloomItemExists   = errors.New(string(ErrorSymbol) + "ERR item exists" + CRLF)
+	ErrBloomErrorRate    = errors.New(string(ErrorSymbol) + "ERR (0 < error rate range < 1)" + CRLF)
+	ErrBloomCapacity     = errors.New(string(ErrorSymbol) + "ERR (capacity should be larger than 0)" + CRLF)
+	ErrBloomExpansion    = errors.New(string(ErrorSymbol) + "ERR (expansion should be greater or equal to 1)" + CRLF)
+	ErrBloomFull         = errors.New(string(ErrorSymbol) + "ERR non scaling filter is full" + CRLF)
+	ErrCuckooCapacity    = errors.New(string(ErrorSymbol) + "ERR (capacity should be larger than 0)" + CRLF)
+	ErrCuckooBucketSize  = errors.New(string(ErrorSymbol) + "ERR (bucket size should be between 1 and 255)" + CRLF)
+	ErrCuckooMaxIter     = errors.New(string(ErrorSymbol) + "ERR (max iterations should be between 1 and 65535)" + CRLF)
+	ErrCuckooExpansion   = errors.New(string(ErrorSymbol) + "ERR (expansion should be between 0 and 32768)" + CRLF)
+	ErrCuckooFull        = errors.New(string(ErrorSymbol) + "ERR Filter is full" + CRLF)
+	ErrCuckooNotFound    = errors.New(string(ErrorSymbol) + "ERR not found" + CRLF)
+	ErrCMSKeyExists      = errors.New(string(ErrorSymbol) + "CMS: key already exists" + CRLF)
+	ErrCMSKeyNotExist    = errors.New(string(ErrorSymbol) + "CMS: key does not exist" + CRLF)
+	ErrCMSInvalidWidth   = errors.New(string(ErrorSymbol) + "CMS: invalid width" + CRLF)
+	ErrCMSInvalidDepth   = errors.New(string(ErrorSymbol) + "CMS: invalid depth" + CRLF)
+	ErrCMSInvalidError   = errors.New(string(ErrorSymbol) + "CMS: invalid overestimation value" + CRLF)
+	ErrCMSInvalidProb    = errors.New(string(ErrorSymbol) + "CMS: invalid prob value" + CRLF)
+	ErrCMSInvalidIncr    = errors.New(string(ErrorSymbol) + "CMS: Cannot parse number" + CRLF)
+	ErrCMSInvalidNumKeys = errors.New(string(ErrorSymbol) + "CMS: invalid numkeys" + CRLF)
+	ErrCMSDimsMismatch   = errors.New(string(ErrorSymbol) + "CMS: width/depth is not equal" + CRLF)
+	ErrTopKKeyExists     = errors.New(string(ErrorSymbol) + "TopK: key already exists" + CRLF)
+	ErrTopKKeyNotExist   = errors.New(string(ErrorSymbol) + "TopK: key does not exist" + CRLF)
+	ErrTopKInvalidK      = errors.New(string(ErrorSymbol) + "TopK: invalid k" + CRLF)
+	ErrTopKInvalidWidth  = errors.New(string(ErrorSymbol) + "TopK: invalid width" + CRLF)
+	ErrTopKInvalidDepth  = errors.New(string(ErrorSymbol) + "TopK: invalid depth" + CRLF)
+	ErrTopKInvalidDecay  = errors.New(string(ErrorSymbol) + "TopK: invalid decay value. must be '<= 1' & '> 0'" + CRLF)
+
+	// time series
+	ErrTSKeyExists          = errors.New(string(ErrorSymbol) + "ERR TSDB: key already exists" + CRLF)
+	ErrTSKeyNotExist        = errors.New(string(ErrorSymbol) + "ERR TSDB: the key does not exist" + CRLF)
+	ErrTSInvalidTimestamp   = errors.New(string(ErrorSymbol) + "ERR TSDB: invalid timestamp" + CRLF)
+	ErrTSInvalidValue       = errors.New(string(ErrorSymbol) + "ERR TSDB: invalid value" + CRLF)
+	ErrTSInvalidRetention   = errors.New(string(ErrorSymbol) + "ERR TSDB: Couldn't parse RETENTION" + CRLF)
+	ErrTSInvalidPolicy      = errors.New(string(ErrorSymbol) + "ERR TSDB: Unknown DUPLICATE_POLICY" + CRLF)
+	ErrTSInvalidLabels      = errors.New(string(ErrorSymbol) + "ERR TSDB: Couldn't parse LABELS" + CRLF)
+	ErrTSDuplicateBlocked   = errors.New(string(ErrorSymbol) + "ERR TSDB: Error at upsert, update is not supported when DUPLICATE_POLICY is set to BLOCK mode" + CRLF)
+	ErrTSTooOld             = errors.New(string(ErrorSymbol) + "ERR TSDB: Timestamp is older than retention" + CRLF)
+	ErrTSInvalidAggregation = errors.New(string(ErrorSymbol) + "ERR TSDB: Unknown aggregation type" + CRLF)
+	ErrTSInvalidBucket      = errors.New(string(ErrorSymbol) + "ERR TSDB: bucketDuration must be greater than zero" + CRLF)
+	ErrTSSameKey            = errors.New(string(ErrorSymbol) + "ERR TSDB: the source key and destination key should be different" + CRLF)
+	ErrTSDestHasSrc         = errors.New(string(ErrorSymbol) + "ERR TSDB: the destination key already has a src rule" + CRLF)
+	ErrTSDestHasRules       = errors.New(string(ErrorSymbol) + "ERR TSDB: the destination key already has a dst rule" + CRLF)
+	ErrTSRuleNotExist       = errors.New(string(ErrorSymbol) + "ERR TSDB: compaction rule does not exist" + CRLF)
+	ErrTSInvalidFilter      = errors.New(string(ErrorSymbol) + "ERR TSDB: failed parsing labels" + CRLF)
+	ErrTSNoMatcher          = errors.New(string(ErrorSymbol) + "ERR TSDB: please provide at least one matcher" + CRLF)
+
+	// json
+	ErrJSONInvalid       = errors.New(string(ErrorSymbol) + "ERR invalid JSON" + CRLF)
+	ErrJSONTooDeep       = errors.New(string(ErrorSymbol) + "ERR JSON document exceeds maximum nesting depth" + CRLF)
+	ErrJSONNewAtRoot     = errors.New(string(ErrorSymbol) + "ERR new objects must be created at the root" + CRLF)
+	ErrJSONPathNotExist  = errors.New(string(ErrorSymbol) + "ERR Path does not exist" + CRLF)
+	ErrJSONNotNumber     = errors.New(string(ErrorSymbol) + "ERR value is not a number" + CRLF)
+	ErrJSONNotString     = errors.New(string(ErrorSymbol) + "ERR value is not a string" + CRLF)
+	ErrJSONNotArray      = errors.New(string(ErrorSymbol) + "ERR value is not an array" + CRLF)
+	ErrJSONNotObject     = errors.New(string(ErrorSymbol) + "ERR value is not an object" + CRLF)
+	ErrJSONIndexOutRange = errors.New(string(ErrorSymbol) + "ERR index out of bounds" + CRLF)
+	ErrJSONNumOverflow   = errors.New(string(ErrorSymbol) + "ERR result is not a finite number" + CRLF)
+
+	// vector sets
+	ErrVSetInvalidVector   = errors.New(string(ErrorSymbol) + "ERR invalid vector specification" + CRLF)
+	ErrVSetInvalidMetric   = errors.New(string(ErrorSymbol) + "ERR invalid METRIC, expected COSINE or L2" + CRLF)
+	ErrVSetInvalidEF       = errors.New(string(ErrorSymbol) + "ERR invalid EF" + CRLF)
+	ErrVSetInvalidM        = errors.New(string(ErrorSymbol) + "ERR invalid M" + CRLF)
+	ErrVSetInvalidCount    = errors.New(string(ErrorSymbol) + "ERR invalid COUNT" + CRLF)
+	ErrVSetInvalidAttrs    = errors.New(string(ErrorSymbol) + "ERR invalid JSON in SETATTR" + CRLF)
+	ErrVSetInvalidFilter   = errors.New(string(ErrorSymbol) + "ERR syntax error in FILTER expression" + CRLF)
+	ErrVSetQuantMismatch   = errors.New(string(ErrorSymbol) + "ERR asked quantization mismatch with existing vector set" + CRLF)
+	ErrVSetMetricMismatch  = errors.New(string(ErrorSymbol) + "ERR asked metric mismatch with existing vector set" + CRLF)
+	ErrVSetElementNotFound = errors.New(string(ErrorSymbol) + "ERR element not found in set" + CRLF)
+
+	// search
+	ErrFTIndexExists      = errors.New(string(ErrorSymbol) + "ERR Index already exists" + CRLF)
+	ErrFTUnknownIndex     = errors.New(string(ErrorSymbol) + "ERR Unknown index name" + CRLF)
+	ErrFTNoFields         = errors.New(string(ErrorSymbol) + "ERR Fields arguments are missing" + CRLF)
+	ErrFTInvalidFieldType = errors.New(string(ErrorSymbol) + "ERR Invalid field type, expected TEXT, TAG, NUMERIC or GEO" + CRLF)
+	ErrFTDuplicateField   = errors.New(string(ErrorSymbol) + "ERR Duplicate field in schema" + CRLF)
+	ErrFTUnknownReducer   = errors.New(string(ErrorSymbol) + "ERR Unknown reducer" + CRLF)
+	ErrFTPropertyPrefix   = errors.New(string(ErrorSymbol) + "ERR Missing prefix: property names require '@'" + CRLF)
+
+	// throttle
+	ErrThrottleInvalidArgs  = errors.New(string(ErrorSymbol) + "ERR invalid throttle parameters, max_burst must be >= 0, count_per_period and period >= 1, quantity >= 0" + CRLF)
+	ErrThrottleInvalidState = errors.New(string(ErrorSymbol) + "ERR value stored at key is not a valid throttle state" + CRLF)
+
+	// transactions
+	ErrNestedMulti         = errors.New(string(ErrorSymbol) + "ERR MULTI calls can not be nested" + CRLF)
+	ErrExecWithoutMulti    = errors.New(string(ErrorSymbol) + "ERR EXEC without MULTI" + CRLF)
+	ErrDiscardWithoutMulti = errors.New(string(ErrorSymbol) + "ERR DISCARD without MULTI" + CRLF)
+	ErrExecAbort           = errors.New(string(ErrorSymbol) + "EXECABORT Transaction discarded because of previous errors." + CRLF)
+	ErrWatchInMulti        = errors.New(string(ErrorSymbol) + "ERR WATCH inside MULTI is not allowed" + CRLF)
+
+	// pubsub
+	ErrNotAllowedInMulti = errors.New(string(ErrorSymbol) + "ERR Command not allowed inside a transaction" + CRLF)
+	ErrProtocolVersion   = errors.New(string(ErrorSymbol) + "ERR Protocol version is not an integer or out of range" + CRLF)
+	ErrNoProto           = errors.New(string(ErrorSymbol) + "NOPROTO unsupported protocol version" + CRLF)
+
+	// scripting
+	ErrBusy                 = errors.New(string(ErrorSymbol) + "BUSY KVS is busy running a script. You can only call SCRIPT KILL or FUNCTION KILL." + CRLF)
+	ErrNotBusy              = errors.New(string(ErrorSymbol) + "NOTBUSY No scripts in execution right now." + CRLF)
+	ErrUnkillable           = errors.New(string(ErrorSymbol) + "UNKILLABLE Sorry the script already executed write commands against the dataset. You can wait the script termination." + CRLF)
+	ErrScriptKilled         = errors.New(string(ErrorSymbol) + "ERR Script killed by user with SCRIPT KILL..." + CRLF)
+	ErrNoScript             = errors.New(string(ErrorSymbol) + "NOSCRIPT No matching script. Please use EVAL." + CRLF)
+	ErrScriptNegativeKeys   = errors.New(string(ErrorSymbol) + "ERR Number of keys can't be negative" + CRLF)
+	ErrScriptTooManyKeys    = errors.New(string(ErrorSymbol) + "ERR Number of keys can't be greater than number of args" + CRLF)
+	ErrScriptNoArgs         = errors.New(string(ErrorSymbol) + "ERR Please specify at least one argument for this redis lib call" + CRLF)
+	ErrScriptUnknownCmd     = errors.New(string(ErrorSymbol) + "ERR Unknown Redis command called from script" + CRLF)
+	ErrScriptForbiddenCmd   = errors.New(string(ErrorSymbol) + "ERR This Redis command is not allowed from script" + CRLF)
+	ErrScriptReadOnly       = errors.New(string(ErrorSymbol) + "ERR Write commands are not allowed from read-only scripts." + CRLF)
+	ErrScriptArgType        = errors.New(string(ErrorSymbol) + "ERR Lua redis lib command arguments must be strings, integers or booleans" + CRLF)
+	ErrScriptNestingTooDeep = errors.New(string(ErrorSymbol) + "ERR reached lua stack limit" + CRLF)
+
+	// client side caching
+	ErrTrackingSwitchMode  = errors.New(string(ErrorSymbol) + "ERR You can't switch BCAST mode on/off before disabling tracking for this client, and then re-enabling it with a different mode." + CRLF)
+	ErrTrackingPrefixBcast = errors.New(string(ErrorSymbol) + "ERR PREFIX option requires BCAST mode to be enabled" + CRLF)
+	ErrTrackingOptInOut    = errors.New(string(ErrorSymbol) + "ERR You can't use both OPTIN and OPTOUT" + CRLF)
+	ErrTrackingOptBcast    = errors.New(string(ErrorSymbol) + "ERR OPTIN and OPTOUT are not compatible with BCAST" + CRLF)
+	ErrTrackingNoRedirect  = errors.New(string(ErrorSymbol) + "ERR The client ID you want redirect to does not exist" + CRLF)
+	ErrTrackingCachingMode = errors.New(string(ErrorSymbol) + "ERR CLIENT CACHING can be called only when the client is in tracking mode with OPTIN or OPTOUT mode enabled" + CRLF)
+	ErrTrackingCachingYes  = errors.New(string(ErrorSymbol) + "ERR CLIENT CACHING YES is only valid when tracking is enabled in OPTIN mode." + CRLF)
+	ErrTrackingCachingNo   = errors.New(string(ErrorSymbol) + "ERR CLIENT CACHING NO is only valid when tracking is enabled in OPTOUT mode." + CRLF)
+	ErrInvalidClientID     = errors.New(string(ErrorSymbol) + "ERR Invalid client ID" + CRLF)
+
+	// clients
+	ErrClientName          = errors.New(string(ErrorSymbol) + "ERR Client names cannot contain spaces, newlines or special characters." + CRLF)
+	ErrNoSuchClient        = errors.New(string(ErrorSymbol) + "ERR No such client" + CRLF)
+	ErrClientIDNotPositive = errors.New(string(ErrorSymbol) + "ERR client-id should be greater than 0" + CRLF)
+	ErrClientPauseTimeout  = errors.New(string(ErrorSymbol) + "ERR timeout is not an integer or out of range" + CRLF)
+
+	// functions
+	ErrFunctionNotFound   = errors.New(string(ErrorSymbol) + "ERR Function not found" + CRLF)
+	ErrLibraryNotFound    = errors.New(string(ErrorSymbol) + "ERR Library not found" + CRLF)
+	ErrLibraryNoName      = errors.New(string(ErrorSymbol) + "ERR Library name is missing, it must be set with kvs_library custom section" + CRLF)
+	ErrLibraryBadName     = errors.New(string(ErrorSymbol) + "ERR Library names can only contain letters, numbers, or underscores(_) and must be at least one character long" + CRLF)
+	ErrLibraryNoFunctions = errors.New(string(ErrorSymbol) + "ERR No functions registered" + CRLF)
+	ErrLibraryABI         = errors.New(string(ErrorSymbol) + "ERR Library must export memory and kvs_alloc(i32) -> i32" + CRLF)
+	ErrFunctionWriteFlag  = errors.New(string(ErrorSymbol) + "ERR Can not execute a script with write flag using *_ro command." + CRLF)
+	ErrFunctionKilled     = errors.New(string(ErrorSymbol) + "ERR Script killed by user with FUNCTION KILL..." + CRLF)
+	ErrFunctionOutOfFuel  = errors.New(string(ErrorSymbol) + "ERR Function ran out of fuel" + CRLF)
+	ErrFunctionBadReply   = errors.New(string(ErrorSymbol) + "ERR Function returned invalid RESP reply" + CRLF)
+	ErrFunctionBadPayload = errors.New(string(ErrorSymbol) + "ERR payload version or checksum are wrong" + CRLF)
+
+	// DUMP, RESTORE and modules
+	ErrDumpBadPayload  = errors.New(string(ErrorSymbol) + "ERR DUMP payload version or checksum are wrong" + CRLF)
+	ErrDumpBadFormat   = errors.New(string(ErrorSymbol) + "ERR Bad data format" + CRLF)
+	ErrDumpUnsupported = errors.New(string(ErrorSymbol) + "ERR values of this type can't be serialized" + CRLF)
+	ErrBusyKey         = errors.New(string(ErrorSymbol) + "BUSYKEY Target key name already exists." + CRLF)
+	ErrInvalidTTL      = errors.New(string(ErrorSymbol) + "ERR Invalid TTL value, must be >= 0" + CRLF)
+
+	// persistence
+	ErrBgsaveInProgress     = errors.New(string(ErrorSymbol) + "ERR Background save already in progress" + CRLF)
+	ErrBgsaveAOFRewrite     = errors.New(string(ErrorSymbol) + "ERR Background AOF rewrite in progress: can't BGSAVE right now. Use BGSAVE SCHEDULE in order to schedule a BGSAVE whenever possible" + CRLF)
+	ErrAOFRewriteInProgress = errors.New(string(ErrorSymbol) + "ERR Background append only file rewriting already in progress" + CRLF)
+
+	// graph
+	ErrGraphEmptyQuery      = errors.New(string(ErrorSymbol) + "ERR Error: empty query" + CRLF)
+	ErrGraphQueryConclusion = errors.New(string(ErrorSymbol) + "ERR Query cannot conclude with MATCH or WITH (must be RETURN or an update clause)" + CRLF)
+	ErrGraphCreateRel       = errors.New(string(ErrorSymbol) + "ERR Exactly one relationship type and a direction must be specified for CREATE" + CRLF)
+	ErrGraphPropertyType    = errors.New(string(ErrorSymbol) + "ERR Property values can only be of primitive types or arrays of primitive types" + CRLF)
+	ErrGraphDivisionByZero  = errors.New(string(ErrorSymbol) + "ERR Division by zero" + CRLF)
+	ErrGraphRangeStep       = errors.New(string(ErrorSymbol) + "ERR step argument to range() can't be 0" + CRLF)
+	ErrGraphInvalidCount    = errors.New(string(ErrorSymbol) + "ERR SKIP and LIMIT must be non-negative integers" + CRLF)
+	ErrGraphEmptyKey        = errors.New(string(ErrorSymbol) + "ERR Invalid graph operation on empty key" + CRLF)
 )
+
+func wrongArgsCountErr(cmdName string) error {
+	return errors.New(string(ErrorSymbol) + "ERR wrong number of arguments for '" + strings.ToLower(cmdName) + "' command" + CRLF)
+}
+
+func geoInvalidCoordsErr(lon, lat float64) error {
+	return fmt.Errorf("%cERR invalid longitude,latitude pair %f,%f%s", ErrorSymbol, lon, lat, CRLF)
+}
+
+func jsonPathErr(path string) error {
+	return errors.New(string(ErrorSymbol) + "ERR invalid JSONPath '" + path + "'" + CRLF)
+}
+
+func vsetDimMismatchErr(got, dim int) error {
+	return fmt.Errorf("%cERR Vector dimension mismatch - got %d but set has %d%s", ErrorSymbol, got, dim, CRLF)
+}
+
+func ftSyntaxErr(offset int, near string) error {
+	return fmt.Errorf("%cERR Syntax error at offset %d near '%s'%s", ErrorSymbol, offset, near, CRLF)
+}
+
+func ftUnknownFieldErr(name string) error {
+	return errors.New(string(ErrorSymbol) + "ERR Unknown field '" + name + "'" + CRLF)
+}
+
+func cypherSyntaxErr(offset int, near string) error {
+	return fmt.Errorf("%cERR Invalid input at offset %d near '%s'%s", ErrorSymbol, offset, near, CRLF)
+}
+
+func cypherUndefinedVarErr(name string) error {
+	return errors.New(string(ErrorSymbol) + "ERR " + name + " not defined" + CRLF)
+}
+
+func cypherVarRedeclaredErr(name string) error {
+	return errors.New(string(ErrorSymbol) + "ERR The bound variable '" + name + "' can't be redeclared in a CREATE clause" + CRLF)
+}
+
+func cypherUnknownFuncErr(name string) error {
+	return errors.New(string(ErrorSymbol) + "ERR Unknown function '" + name + "'" + CRLF)
+}
+
+func cypherArgsCountErr(name string) error {
+	return errors.New(string(ErrorSymbol) + "ERR Received wrong number of arguments to function '" + name + "'" + CRLF)
+}
+
+func cypherAggregateErr(name string) error {
+	return errors.New(string(ErrorSymbol) + "ERR Invalid use of aggregating function '" + name + "'" + CRLF)
+}
+
+func cypherTypeErr(expected string, got any) error {
+	return errors.New(string(ErrorSymbol) + "ERR Type mismatch: expected " + expected + " but was " + cyTypeName(got) + CRLF)
+}
+
+func pubsubModeErr(cmd string) error {
+	return errors.New(string(ErrorSymbol) + "ERR Can't execute '" + strings.ToLower(cmd) +
+		"': only (P|S)SUBSCRIBE / (P|S)UNSUBSCRIBE / PING are allowed in this context" + CRLF)
+}
+
+func configUnknownParamErr(name string) error {
+	return errors.New(string(ErrorSymbol) + "ERR Unknown option or number of arguments for CONFIG SET - '" + name + "'" + CRLF)
+}
+
+func configInvalidArgErr(name, val string) error {
+	return errors.New(string(ErrorSymbol) + "ERR Invalid argument '" + val + "' for CONFIG SET '" + name + "'" + CRLF)
+}
+
+func scriptCompileErr(msg string) error {
+	return errors.New(string(ErrorSymbol) + "ERR Error compiling script (new function): " + strings.ReplaceAll(msg, "\n", " ") + CRLF)
+}
+
+func scriptRunErr(sha, msg string) error {
+	return errors.New(string(ErrorSymbol) + "ERR Error running script (call to f_" + sha + "): " + strings.ReplaceAll(msg, "\n", " ") + CRLF)
+}
+
+func libraryExistsErr(name string) error {
+	return errors.New(string(ErrorSymbol) + "ERR Library '" + name + "' already exists" + CRLF)
+}
+
+func functionExistsErr(name string) error {
+	return errors.New(string(ErrorSymbol) + "ERR Function " + name + " already exists" + CRLF)
+}
+
+func functionNameErr(name string) error {
+	return errors.New(string(ErrorSymbol) + "ERR Function names can only contain letters, numbers, or underscores(_) and must be at least one character long: '" + name + "'" + CRLF)
+}
+
+func functionSignatureErr(export string) error {
+	return errors.New(string(ErrorSymbol) + "ERR Function " + export + " must have signature (i32, i32) -> i64" + CRLF)
+}
+
+func libraryCompileErr(msg string) error {
+	return errors.New(string(ErrorSymbol) + "ERR Error compiling library: " + strings.ReplaceAll(msg, "\n", " ") + CRLF)
+}
+
+func functionRunErr(name, msg string) error {
+	return errors.New(string(ErrorSymbol) + "ERR Error running function " + name + ": " + strings.ReplaceAll(msg, "\n", " ") + CRLF)
+}
+
+func librarySaveErr(msg string) error {
+	return errors.New(string(ErrorSymbol) + "ERR Error saving function libraries: " + msg + CRLF)
+}
+
+func trackingPrefixOverlapErr(p, q string) error {
+	return errors.New(string(ErrorSymbol) + "ERR Prefix '" + p + "' overlaps with an existing prefix '" + q +
+		"'. Prefixes for a single client must not overlap." + CRLF)
+}
+
+func moduleErr(msg string) error {
+	return errors.New(string(ErrorSymbol) + strings.NewReplacer("\r", " ", "\n", " ").Replace(msg) + CRLF)
+}
+
+func clientTypeErr(t string) error {
+	return errors.New(string(ErrorSymbol) + "ERR Unknown client type '" + t + "'" + CRLF)
+}
+
+func noSuchUserErr(user string) error {
+	return errors.New(string(ErrorSymbol) + "ERR No such user '" + user + "'" + CRLF)
+}
+
+func configImmutableErr(name string) error {
+	return errors.New(string(ErrorSymbol) + "ERR CONFIG SET failed (possibly related to argument '" + name + "') - can't set immutable config" + CRLF)
+}
+
+func aofMisconfErr(msg string) error {
+	return errors.New(string(ErrorSymbol) + "MISCONF Errors writing to the AOF file: " + strings.ReplaceAll(msg, "\n", " ") + CRLF)
+}
+
+func aofRewriteErr(msg string) error {
+	return errors.New(string(ErrorSymbol) + "ERR Background append only file rewriting failed: " + strings.ReplaceAll(msg, "\n", " ") + CRLF)
+}
+
+func storageEngineErr(msg string) error {
+	return errors.New(string(ErrorSymbol) + "ERR Storage engine error: " + strings.ReplaceAll(msg, "\n", " ") + CRLF)
+}
+
+func saveErr(msg string) error {
+	return errors.New(string(ErrorSymbol) + "ERR Error saving the snapshot: " + strings.ReplaceAll(msg, "\n", " ") + CRLF)
+}
@@ -0,0 +1,73 @@
+package main
+
+import (
+	"container/heap"
+	"log"
+	"time"
+)
+
+// Keys with a time to live keep their deadline in KvsValue.expireAt and in a min-heap of deadlines.
+// Keys that are due are deleted before every command is executed, so expired values are never seen,
+// and by expireCron, so they don't stay in memory and expired events are sent without any traffic.
+// A heap entry is stale when the key was overwritten or got another deadline since it was pushed,
+// such entries are just dropped
+type expireEntry struct {
+	at  int64 // unix ms
+	key string
+}
+
+type expireHeap []expireEntry
+
+func (h expireHeap) Len() int           { return len(h) }
+func (h expireHeap) Less(i, j int) bool { return h[i].at < h[j].at }
+func (h expireHeap) Swap(i, j int)      { h[i], h[j] = h[j], h[i] }
+func (h *expireHeap) Push(x any)        { *h = append(*h, x.(expireEntry)) }
+
+func (h *expireHeap) Pop() any {
+	old := *h
+	last := old[len(old)-1]
+	*h = old[:len(old)-1]
+
+	return last
+}
+
+// setExpire stores val at key with deadline at. Must be called with kvs.mu held
+func setExpire(key string, val *KvsValue, at int64) {
+	if cur, ok := storedValue(key); ok && cur == val {
+		preserveValue(key, val)
+	}
+	val.expireAt = at
+	storeKey(key, val)
+	heap.Push(&kvs.expires, expireEntry{at: at, key: key})
+}
+
+// expireDueKeys deletes keys with deadlines before now. Must be called with kvs.mu held
+func expireDueKeys(now int64) {
+	for len(kvs.expires) > 0 && kvs.expires[0].at <= now {
+		e := heap.Pop(&kvs.expires).(expireEntry)
+
+		if val, ok := storedValue(e.key); ok && val.expireAt == e.at {
+			removeKey(e.key)
+			signalModifiedKey(e.key, notifyExpired, "expired")
+		}
+	}
+}
+
+// keys that are due are deleted by expireCron this often
+const activeExpireInterval = 100 * time.Millisecond
+
+// expireCron deletes keys that are due when no commands come
+func expireCron() {
+	for range time.Tick(activeExpireInterval) {
+		kvs.mu.Lock()
+		activeExpire()
+		kvs.mu.Unlock()
+	}
+}
+
+// activeExpire deletes keys that are due in a batch of the storage engine. Must be called with kvs.mu held
+func activeExpire() {
+	if err := batch(func() { expireDueKeys(nowMs()) }); err != nil {
+		log.Println("Error expiring keys: ", err)
+	}
+}
@@ -0,0 +1,72 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestExpireDueKeys(t *testing.T) {
+	initStorage()
+
+	setExpire("a", &KvsValue{dtype: BulkStrSymbol, value: []byte("1")}, 100)
+	setExpire("b", &KvsValue{dtype: BulkStrSymbol, value: []byte("2")}, 200)
+	setExpire("c", &KvsValue{dtype: BulkStrSymbol, value: []byte("3")}, 100)
+
+	// overwritten key loses its deadline, key with a new deadline keeps only the new one
+	kvs.storage.Set("c", &KvsValue{dtype: BulkStrSymbol, value: []byte("4")})
+	b, _ := kvs.storage.Get("b")
+	setExpire("b", b, 300)
+
+	expireDueKeys(99)
+	if kvs.storage.Len() != 3 {
+		t.Fatalf("keys expired before their deadline")
+	}
+
+	expireDueKeys(250)
+	if _, ok := kvs.storage.Get("a"); ok {
+		t.Errorf("key a is not expired")
+	}
+	if _, ok := kvs.storage.Get("b"); !ok {
+		t.Errorf("key b expired by its old deadline")
+	}
+	if _, ok := kvs.storage.Get("c"); !ok {
+		t.Errorf("overwritten key c expired")
+	}
+
+	expireDueKeys(300)
+	if _, ok := kvs.storage.Get("b"); ok {
+		t.Errorf("key b is not expired")
+	}
+	if len(kvs.expires) != 0 {
+		t.Errorf("%d deadlines left, expected: 0", len(kvs.expires))
+	}
+}
+
+// Due keys are deleted and their expired events are sent without any commands
+func TestActiveExpire(t *testing.T) {
+	initStorage()
+	defer func() { config.notifyKeyspaceEvents = 0 }()
+
+	c, r := pipeClient(t)
+	pubsub.subscribe(c, subChannel, []string{"__keyevent@0__:expired"})
+	defer pubsub.unsubscribeAll(c)
+	readReply(t, r, "*3\r\n$9\r\nsubscribe\r\n$22\r\n__keyevent@0__:expired\r\n:1\r\n")
+	config.notifyKeyspaceEvents, _ = parseNotifyFlags("Ex")
+
+	kvs.mu.Lock()
+	setExpire("a", &KvsValue{dtype: BulkStrSymbol, value: []byte("1")}, nowMs()+10)
+	setExpire("b", &KvsValue{dtype: BulkStrSymbol, value: []byte("2")}, nowMs()+100000)
+	kvs.mu.Unlock()
+	time.Sleep(20 * time.Millisecond)
+
+	kvs.mu.Lock()
+	activeExpire()
+	_, aLeft := kvs.storage.Get("a")
+	_, bLeft := kvs.storage.Get("b")
+	kvs.mu.Unlock()
+	if aLeft || !bLeft {
+		t.Errorf("after active expire a is kept: %v, b is kept: %v", aLeft, bLeft)
+	}
+	readReply(t, r, string(pushResponse(2, bulkStrResponse([]byte("message")),
+		bulkStrResponse([]byte("__keyevent@0__:expired")), bulkStrResponse([]byte("a")))))
+}
@@ -0,0 +1,573 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"hash/crc64"
+	"maps"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/tetratelabs/wazero"
+	"github.com/tetratelabs/wazero/api"
+	"github.com/tetratelabs/wazero/imports/wasi_snapshot_preview1"
+)
+
+// Function libraries are WASM modules run by wazero, so they may be written in any language compiled
+// to WASM, WASI is available. The ABI is:
+//   - kvs_library custom section holds library name
+//   - functions are exported as kvs_fn_<name>, or kvs_ro_<name> for functions that don't write, and take
+//     (ptr, len i32) -> i64. Their input is u32 number of keys followed by a list of keys and args, and
+//     they return ptr << 32 | len of a RESP reply in their memory
+//   - memory and kvs_alloc(size i32) -> i32, host writes function input into memory allocated with it
+//   - kvs.call(ptr, len i32) -> i32 import runs a command given as a list and returns length of its RESP
+//     reply, errors included, and kvs.reply(ptr i32) copies the reply to memory
+//
+// A list is u32 count followed by u32 length and bytes of every string, all numbers are little endian.
+// Every call gets a new instance, which runs exported _initialize first if there is one. Functions run
+// under kvs.mu like scripts and share BUSY and kill handling with them
+const (
+	functionLibrarySection = "kvs_library"
+	functionExportPrefix   = "kvs_fn_"
+	functionExportPrefixRO = "kvs_ro_"
+
+	functionMemoryLimitPages  = 1024 // 64MB
+	functionDefaultFuelLimit  = 1_000_000_000
+	functionLibrariesFileName = "functions.kvs"
+	functionPayloadVersion    = 1
+)
+
+var functionPayloadMagic = []byte("KVSFUNC")
+
+type functionLibrary struct {
+	name      string
+	code      []byte
+	module    wazero.CompiledModule
+	functions []*libraryFunction
+}
+
+type libraryFunction struct {
+	name     string
+	export   string
+	readOnly bool
+	lib      *functionLibrary
+}
+
+// function libraries are guarded by kvs.mu
+var functions struct {
+	runtime   wazero.Runtime
+	libraries map[string]*functionLibrary
+	byName    map[string]*libraryFunction
+	// reply of the last kvs.call, until it's copied by kvs.reply
+	reply []byte
+}
+
+func wasmRuntime() wazero.Runtime {
+	if functions.runtime != nil {
+		return functions.runtime
+	}
+
+	ctx := context.Background()
+	cfg := wazero.NewRuntimeConfig().
+		WithMemoryLimitPages(functionMemoryLimitPages).
+		WithCloseOnContextDone(true)
+	r := wazero.NewRuntimeWithConfig(ctx, cfg)
+
+	wasi_snapshot_preview1.MustInstantiate(ctx, r)
+	_, err := r.NewHostModuleBuilder("kvs").
+		NewFunctionBuilder().WithFunc(wasmCall).Export("call").
+		NewFunctionBuilder().WithFunc(wasmReply).Export("reply").
+		Instantiate(ctx)
+	if err != nil {
+		panic(err)
+	}
+
+	functions.runtime = r
+
+	return r
+}
+
+// wasmCall is kvs.call import
+func wasmCall(ctx context.Context, m api.Module, ptr, size uint32) uint32 {
+	b, ok := m.Memory().Read(ptr, size)
+	if !ok {
+		panic(errWasmOutOfBounds)
+	}
+
+	args, err := decodeWasmList(b)
+	if err == nil && len(args) == 0 {
+		err = ErrScriptNoArgs
+	}
+
+	var res []byte
+	if err == nil {
+		res, err = scriptCall(args)
+	}
+	if err != nil {
+		res = []byte(err.Error())
+	}
+	functions.reply = res
+
+	return uint32(len(res))
+}
+
+// wasmReply is kvs.reply import
+func wasmReply(ctx context.Context, m api.Module, ptr uint32) {
+	if !m.Memory().Write(ptr, functions.reply) {
+		panic(errWasmOutOfBounds)
+	}
+	functions.reply = nil
+}
+
+var errWasmOutOfBounds = errors.New("memory access out of bounds")
+
+func isValidFunctionName(name string) bool {
+	if name == "" {
+		return false
+	}
+
+	for _, c := range []byte(name) {
+		if !(c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || c == '_') {
+			return false
+		}
+	}
+
+	return true
+}
+
+// compileLibrary checks module against the ABI, meters and compiles it
+func compileLibrary(code []byte) (*functionLibrary, error) {
+	sections, err := parseWasmSections(code)
+	if err != nil {
+		return nil, libraryCompileErr(err.Error())
+	}
+
+	name, ok := wasmCustomSection(sections, functionLibrarySection)
+	if !ok {
+		return nil, ErrLibraryNoName
+	}
+	if !isValidFunctionName(string(name)) {
+		return nil, ErrLibraryBadName
+	}
+
+	metered, err := meterWasm(code)
+	if err != nil {
+		return nil, libraryCompileErr(err.Error())
+	}
+
+	// wazero caches compiled code by module contents, so modules of libraries with the same code are shared
+	var module wazero.CompiledModule
+	for _, lib := range functions.libraries {
+		if bytes.Equal(lib.code, code) {
+			module = lib.module
+		}
+	}
+	if module == nil {
+		if module, err = wasmRuntime().CompileModule(context.Background(), metered); err != nil {
+			return nil, libraryCompileErr(err.Error())
+		}
+	}
+
+	lib := &functionLibrary{name: string(name), code: code, module: module}
+	fail := func(err error) (*functionLibrary, error) {
+		closeLibraries([]*functionLibrary{lib})
+		return nil, err
+	}
+
+	exports := module.ExportedFunctions()
+	alloc, ok := exports["kvs_alloc"]
+	if _, hasMemory := module.ExportedMemories()["memory"]; !ok || !hasMemory ||
+		!slices.Equal(alloc.ParamTypes(), []api.ValueType{api.ValueTypeI32}) ||
+		!slices.Equal(alloc.ResultTypes(), []api.ValueType{api.ValueTypeI32}) {
+		return fail(ErrLibraryABI)
+	}
+
+	for _, export := range slices.Sorted(maps.Keys(exports)) {
+		name, readOnly := "", false
+		switch {
+		case strings.HasPrefix(export, functionExportPrefix):
+			name = export[len(functionExportPrefix):]
+		case strings.HasPrefix(export, functionExportPrefixRO):
+			name, readOnly = export[len(functionExportPrefixRO):], true
+		default:
+			continue
+		}
+
+		if !isValidFunctionName(name) {
+			return fail(functionNameErr(name))
+		}
+		def := exports[export]
+		if !slices.Equal(def.ParamTypes(), []api.ValueType{api.ValueTypeI32, api.ValueTypeI32}) ||
+			!slices.Equal(def.ResultTypes(), []api.ValueType{api.ValueTypeI64}) {
+			return fail(functionSignatureErr(export))
+		}
+		if slices.ContainsFunc(lib.functions, func(f *libraryFunction) bool { return f.name == name }) {
+			return fail(functionExistsErr(name))
+		}
+
+		lib.functions = append(lib.functions, &libraryFunction{name: name, export: export, readOnly: readOnly, lib: lib})
+	}
+
+	if len(lib.functions) == 0 {
+		return fail(ErrLibraryNoFunctions)
+	}
+	slices.SortFunc(lib.functions, func(a, b *libraryFunction) int { return strings.Compare(a.name, b.name) })
+
+	return lib, nil
+}
+
+// setLibraries persists libs and makes them current, libraries that are no longer used are closed.
+// Nothing changes if libraries can't be saved. Must be called with kvs.mu held
+func setLibraries(libs map[string]*functionLibrary) error {
+	byName := make(map[string]*libraryFunction)
+	for _, name := range slices.Sorted(maps.Keys(libs)) {
+		lib := libs[name]
+		for _, f := range lib.functions {
+			if other, ok := byName[f.name]; ok && other.lib != lib {
+				return functionExistsErr(f.name)
+			}
+			byName[f.name] = f
+		}
+	}
+
+	if err := saveLibraries(libs); err != nil {
+		return librarySaveErr(err.Error())
+	}
+
+	prev := functions.libraries
+	functions.libraries, functions.byName = libs, byName
+	for name, lib := range prev {
+		if libs[name] != lib {
+			closeLibraries([]*functionLibrary{lib})
+		}
+	}
+
+	return nil
+}
+
+// loadLibrary adds library from code, replacing library with the same name if replace is set
+func loadLibrary(code []byte, replace bool) (string, error) {
+	lib, err := compileLibrary(code)
+	if err != nil {
+		return "", err
+	}
+
+	if _, ok := functions.libraries[lib.name]; ok && !replace {
+		closeLibraries([]*functionLibrary{lib})
+		return "", libraryExistsErr(lib.name)
+	}
+
+	libs := cloneLibraries()
+	libs[lib.name] = lib
+	if err := setLibraries(libs); err != nil {
+		closeLibraries([]*functionLibrary{lib})
+		return "", err
+	}
+
+	return lib.name, nil
+}
+
+func cloneLibraries() map[string]*functionLibrary {
+	libs := make(map[string]*functionLibrary, len(functions.libraries)+1)
+	for name, lib := range functions.libraries {
+		libs[name] = lib
+	}
+
+	return libs
+}
+
+// dumpLibraries encodes libraries as magic, version, u32 count, name and code of every library
+// and CRC64 of everything before it, all little endian. It's the payload of FUNCTION DUMP and the
+// format of the libraries file
+func dumpLibraries(libs map[string]*functionLibrary) []byte {
+	b := bytes.Clone(functionPayloadMagic)
+	b = binary.LittleEndian.AppendUint16(b, functionPayloadVersion)
+	b = binary.LittleEndian.AppendUint32(b, uint32(len(libs)))
+
+	for _, name := range slices.Sorted(maps.Keys(libs)) {
+		b = binary.LittleEndian.AppendUint32(b, uint32(len(name)))
+		b = append(b, name...)
+		b = binary.LittleEndian.AppendUint32(b, uint32(len(libs[name].code)))
+		b = append(b, libs[name].code...)
+	}
+
+	return binary.LittleEndian.AppendUint64(b, crc64.Checksum(b, crc64.MakeTable(crc64.ECMA)))
+}
+
+// restoreLibraries decodes and compiles libraries dumped with dumpLibraries
+func restoreLibraries(payload []byte) ([]*functionLibrary, error) {
+	header := len(functionPayloadMagic) + 2 + 4
+	if len(payload) < header+8 || !bytes.HasPrefix(payload, functionPayloadMagic) {
+		return nil, ErrFunctionBadPayload
+	}
+
+	body, sum := payload[:len(payload)-8], binary.LittleEndian.Uint64(payload[len(payload)-8:])
+	if crc64.Checksum(body, crc64.MakeTable(crc64.ECMA)) != sum ||
+		binary.LittleEndian.Uint16(body[len(functionPayloadMagic):]) != functionPayloadVersion {
+		return nil, ErrFunctionBadPayload
+	}
+
+	n := binary.LittleEndian.Uint32(body[header-4:])
+	body = body[header:]
+
+	next := func() ([]byte, bool) {
+		if len(body) < 4 {
+			return nil, false
+		}
+		size := binary.LittleEndian.Uint32(body)
+		if uint64(size) > uint64(len(body)-4) {
+			return nil, false
+		}
+		res := body[4 : 4+size]
+		body = body[4+size:]
+		return res, true
+	}
+
+	var libs []*functionLibrary
+	for range n {
+		name, ok := next()
+		code, ok2 := next()
+		if !ok || !ok2 {
+			closeLibraries(libs)
+			return nil, ErrFunctionBadPayload
+		}
+
+		lib, err := compileLibrary(bytes.Clone(code))
+		if err != nil {
+			closeLibraries(libs)
+			return nil, err
+		}
+		libs = append(libs, lib)
+		if lib.name != string(name) {
+			closeLibraries(libs)
+			return nil, ErrFunctionBadPayload
+		}
+	}
+	if len(body) != 0 {
+		closeLibraries(libs)
+		return nil, ErrFunctionBadPayload
+	}
+
+	return libs, nil
+}
+
+// closeLibraries releases compiled modules of libs that aren't shared with current libraries
+func closeLibraries(libs []*functionLibrary) {
+	for _, lib := range libs {
+		shared := false
+		for _, cur := range functions.libraries {
+			shared = shared || cur.module == lib.module
+		}
+		if !shared {
+			lib.module.Close(context.Background())
+		}
+	}
+}
+
+func librariesPath() string {
+	return filepath.Join(config.dir, functionLibrariesFileName)
+}
+
+// saveLibraries writes libraries file, it's replaced atomically
+func saveLibraries(libs map[string]*functionLibrary) error {
+	path := librariesPath()
+	if len(libs) == 0 {
+		if err := os.Remove(path); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return err
+		}
+		return nil
+	}
+
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, dumpLibraries(libs), 0o644); err != nil {
+		return err
+	}
+
+	return os.Rename(tmp, path)
+}
+
+// loadLibrariesFile loads libraries saved in dir on start
+func loadLibrariesFile() error {
+	payload, err := os.ReadFile(librariesPath())
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	libs, err := restoreLibraries(payload)
+	if err != nil {
+		return errors.New(strings.TrimSuffix(strings.TrimPrefix(err.Error(), string(ErrorSymbol)), CRLF))
+	}
+
+	functions.libraries = make(map[string]*functionLibrary)
+	functions.byName = make(map[string]*libraryFunction)
+	for _, lib := range libs {
+		functions.libraries[lib.name] = lib
+		for _, f := range lib.functions {
+			functions.byName[f.name] = f
+		}
+	}
+
+	return nil
+}
+
+// runFunction calls f with keys and args in a new instance of its library. Must be called with kvs.mu held
+func runFunction(f *libraryFunction, keys, args []*KvsValue) ([]byte, error) {
+	run, ctx, finish := startRun(f.readOnly)
+	defer finish()
+
+	mod, err := wasmRuntime().InstantiateModule(ctx, f.lib.module, wazero.NewModuleConfig().WithName("").WithStartFunctions())
+	if err != nil {
+		return nil, functionRunErr(f.name, err.Error())
+	}
+	defer mod.Close(context.Background())
+
+	fuel := mod.ExportedGlobal(wasmFuelExport).(api.MutableGlobal)
+	fuel.Set(uint64(config.functionFuelLimit))
+
+	res, err := callFunction(ctx, mod, f, keys, args)
+	if err != nil {
+		switch {
+		case run.wasKilled():
+			return nil, ErrFunctionKilled
+		case int64(fuel.Get()) < 0:
+			return nil, ErrFunctionOutOfFuel
+		}
+		return nil, functionRunErr(f.name, err.Error())
+	}
+
+	if n, err := respReplyLen(res, 64); err != nil || n != len(res) {
+		return nil, ErrFunctionBadReply
+	}
+	if res[0] == ErrorSymbol {
+		return nil, errors.New(string(res))
+	}
+
+	return res, nil
+}
+
+func callFunction(ctx context.Context, mod api.Module, f *libraryFunction, keys, args []*KvsValue) ([]byte, error) {
+	if init := mod.ExportedFunction("_initialize"); init != nil {
+		if _, err := init.Call(ctx); err != nil {
+			return nil, err
+		}
+	}
+
+	input := binary.LittleEndian.AppendUint32(nil, uint32(len(keys)))
+	input = appendWasmList(input, append(slices.Clip(keys), args...))
+
+	ret, err := mod.ExportedFunction("kvs_alloc").Call(ctx, uint64(len(input)))
+	if err != nil {
+		return nil, err
+	}
+	ptr := uint32(ret[0])
+	if !mod.Memory().Write(ptr, input) {
+		return nil, errWasmOutOfBounds
+	}
+
+	ret, err = mod.ExportedFunction(f.export).Call(ctx, uint64(ptr), uint64(len(input)))
+	if err != nil {
+		return nil, err
+	}
+
+	res, ok := mod.Memory().Read(uint32(ret[0]>>32), uint32(ret[0]))
+	if !ok {
+		return nil, errWasmOutOfBounds
+	}
+
+	return bytes.Clone(res), nil
+}
+
+func appendWasmList(b []byte, items []*KvsValue) []byte {
+	b = binary.LittleEndian.AppendUint32(b, uint32(len(items)))
+	for _, item := range items {
+		s := argToString(item)
+		b = binary.LittleEndian.AppendUint32(b, uint32(len(s)))
+		b = append(b, s...)
+	}
+
+	return b
+}
+
+func decodeWasmList(b []byte) ([]*KvsValue, error) {
+	if len(b) < 4 {
+		return nil, ErrInvalidRESP
+	}
+
+	n := binary.LittleEndian.Uint32(b)
+	b = b[4:]
+	if uint64(n) > uint64(len(b)/4) {
+		return nil, ErrInvalidRESP
+	}
+
+	res := make([]*KvsValue, 0, n)
+	for range n {
+		if len(b) < 4 {
+			return nil, ErrInvalidRESP
+		}
+		size := binary.LittleEndian.Uint32(b)
+		if uint64(size) > uint64(len(b)-4) {
+			return nil, ErrInvalidRESP
+		}
+		res = append(res, &KvsValue{dtype: BulkStrSymbol, value: bytes.Clone(b[4 : 4+size])})
+		b = b[4+size:]
+	}
+
+	return res, nil
+}
+
+// respReplyLen returns length of the first RESP value in b, aggregates may be nested up to depth levels
+func respReplyLen(b []byte, depth int) (int, error) {
+	end := bytes.Index(b, []byte(CRLF))
+	if len(b) == 0 || end < 0 {
+		return 0, ErrInvalidRESP
+	}
+
+	line := string(b[1:end])
+	n := end + 2
+
+	switch b[0] {
+	case SimpleStrSymbol, ErrorSymbol, IntSymbol, BoolSymbol, '_':
+		return n, nil
+	case BulkStrSymbol:
+		size, err := strconv.Atoi(line)
+		if err != nil || size < -1 {
+			return 0, ErrInvalidRESP
+		}
+		if size == -1 {
+			return n, nil
+		}
+		if len(b) < n+size+2 || string(b[n+size:n+size+2]) != CRLF {
+			return 0, ErrInvalidRESP
+		}
+		return n + size + 2, nil
+	case ArrSymbol, MapSymbol:
+		count, err := strconv.Atoi(line)
+		if err != nil || count < -1 {
+			return 0, ErrInvalidRESP
+		}
+		if b[0] == MapSymbol {
+			count *= 2
+		}
+		if count > 0 && depth == 0 {
+			return 0, ErrInvalidRESP
+		}
+		for range count {
+			m, err := respReplyLen(b[n:], depth-1)
+			if err != nil {
+				return 0, err
+			}
+			n += m
+		}
+		return n, nil
+	}
+
+	return 0, ErrInvalidRESP
+}
@@ -0,0 +1,203 @@
+package main
+
+import (
+	"maps"
+	"slices"
+	"strings"
+)
+
+// FCALL function numkeys [key [key ...]] [arg [arg ...]]
+func fcallHandler(args []*KvsValue) ([]byte, error) {
+	return fcall(args, false)
+}
+
+// FCALL_RO function numkeys [key [key ...]] [arg [arg ...]]
+func fcallRoHandler(args []*KvsValue) ([]byte, error) {
+	return fcall(args, true)
+}
+
+func fcall(args []*KvsValue, readOnly bool) ([]byte, error) {
+	numKeys, err := argToInt64(args[1])
+	if err != nil {
+		return nil, err
+	}
+	if numKeys < 0 {
+		return nil, ErrScriptNegativeKeys
+	}
+	if numKeys > int64(len(args)-2) {
+		return nil, ErrScriptTooManyKeys
+	}
+
+	f, ok := functions.byName[argToString(args[0])]
+	if !ok {
+		return nil, ErrFunctionNotFound
+	}
+	if readOnly && !f.readOnly {
+		return nil, ErrFunctionWriteFlag
+	}
+
+	return runFunction(f, args[2:2+numKeys], args[2+numKeys:])
+}
+
+// FUNCTION LOAD [REPLACE] code | DELETE library | LIST [WITHCODE] [LIBRARYNAME pattern] | DUMP |
+// RESTORE payload [FLUSH | APPEND | REPLACE] | FLUSH [ASYNC | SYNC] | KILL
+func functionHandler(args []*KvsValue) ([]byte, error) {
+	sub := strings.ToUpper(argToString(args[0]))
+
+	switch sub {
+	case "LOAD":
+		replace := len(args) == 3 && strings.ToUpper(argToString(args[1])) == "REPLACE"
+		if len(args) != 2 && !replace {
+			if len(args) == 3 {
+				return nil, ErrSyntax
+			}
+			return nil, wrongArgsCountErr("FUNCTION|LOAD")
+		}
+
+		name, err := loadLibrary(args[len(args)-1].value, replace)
+		if err != nil {
+			return nil, err
+		}
+
+		return bulkStrResponse([]byte(name)), nil
+	case "DELETE":
+		if len(args) != 2 {
+			return nil, wrongArgsCountErr("FUNCTION|DELETE")
+		}
+
+		name := argToString(args[1])
+		if _, ok := functions.libraries[name]; !ok {
+			return nil, ErrLibraryNotFound
+		}
+
+		libs := cloneLibraries()
+		delete(libs, name)
+		if err := setLibraries(libs); err != nil {
+			return nil, err
+		}
+
+		return []byte(OkResponse), nil
+	case "LIST":
+		return functionList(args[1:])
+	case "DUMP":
+		if len(args) != 1 {
+			return nil, wrongArgsCountErr("FUNCTION|DUMP")
+		}
+
+		return bulkStrResponse(dumpLibraries(functions.libraries)), nil
+	case "RESTORE":
+		if len(args) != 2 && len(args) != 3 {
+			return nil, wrongArgsCountErr("FUNCTION|RESTORE")
+		}
+
+		policy := "APPEND"
+		if len(args) == 3 {
+			policy = strings.ToUpper(argToString(args[2]))
+			if policy != "FLUSH" && policy != "APPEND" && policy != "REPLACE" {
+				return nil, ErrSyntax
+			}
+		}
+
+		restored, err := restoreLibraries(args[1].value)
+		if err != nil {
+			return nil, err
+		}
+
+		libs := make(map[string]*functionLibrary)
+		if policy != "FLUSH" {
+			libs = cloneLibraries()
+		}
+		for _, lib := range restored {
+			if _, ok := libs[lib.name]; ok && policy == "APPEND" {
+				closeLibraries(restored)
+				return nil, libraryExistsErr(lib.name)
+			}
+			libs[lib.name] = lib
+		}
+
+		if err := setLibraries(libs); err != nil {
+			closeLibraries(restored)
+			return nil, err
+		}
+
+		return []byte(OkResponse), nil
+	case "FLUSH":
+		if len(args) > 2 {
+			return nil, wrongArgsCountErr("FUNCTION|FLUSH")
+		}
+		if len(args) == 2 {
+			if mode := strings.ToUpper(argToString(args[1])); mode != "ASYNC" && mode != "SYNC" {
+				return nil, ErrSyntax
+			}
+		}
+
+		if err := setLibraries(make(map[string]*functionLibrary)); err != nil {
+			return nil, err
+		}
+
+		return []byte(OkResponse), nil
+	case "KILL":
+		if len(args) != 1 {
+			return nil, wrongArgsCountErr("FUNCTION|KILL")
+		}
+
+		if err := killScript(); err != nil {
+			return nil, err
+		}
+
+		return []byte(OkResponse), nil
+	}
+
+	return nil, ErrUnknownSubcommand
+}
+
+// functionList replies with library_name, engine, functions and optionally library_code of every library
+func functionList(args []*KvsValue) ([]byte, error) {
+	withCode, pattern := false, ""
+	for i := 0; i < len(args); i++ {
+		switch strings.ToUpper(argToString(args[i])) {
+		case "WITHCODE":
+			withCode = true
+		case "LIBRARYNAME":
+			if i+1 == len(args) {
+				return nil, ErrSyntax
+			}
+			i++
+			pattern = argToString(args[i])
+		default:
+			return nil, ErrSyntax
+		}
+	}
+
+	var res [][]byte
+	for _, name := range slices.Sorted(maps.Keys(functions.libraries)) {
+		if pattern != "" && !globMatch(pattern, name) {
+			continue
+		}
+		lib := functions.libraries[name]
+
+		funcs := make([][]byte, len(lib.functions))
+		for i, f := range lib.functions {
+			var flags [][]byte
+			if f.readOnly {
+				flags = append(flags, []byte("no-writes"))
+			}
+			funcs[i] = arrayResponse(
+				bulkStrResponse([]byte("name")), bulkStrResponse([]byte(f.name)),
+				bulkStrResponse([]byte("flags")), bulkStrArrayResponse(flags...),
+			)
+		}
+
+		info := [][]byte{
+			bulkStrResponse([]byte("library_name")), bulkStrResponse([]byte(name)),
+			bulkStrResponse([]byte("engine")), bulkStrResponse([]byte("WASM")),
+			bulkStrResponse([]byte("functions")), arrayResponse(funcs...),
+		}
+		if withCode {
+			info = append(info, bulkStrResponse([]byte("library_code")), bulkStrResponse(lib.code))
+		}
+		res = append(res, arrayResponse(info...))
+	}
+
+	return arrayResponse(res...), nil
+}
@@ -0,0 +1,196 @@
+package main
+
+import (
+	"math"
+	"os"
+	"strings"
+	"testing"
+)
+
+// testWasmLibrary builds library with functions:
+//   - run and peek (read-only) that run their keys and args as a command and return its reply
+//   - spin that loops forever
+func testWasmLibrary(name string) []byte {
+	return testWasmLibrarySpin(name, 0x03, 0x40, 0x0c, 0x00, 0x0b, 0x00, 0x0b) // loop br 0 end unreachable
+}
+
+// testWasmLibrarySpin builds the test library with spin running body
+func testWasmLibrarySpin(name string, spin ...byte) []byte {
+	section := func(id byte, entries ...[]byte) []byte {
+		body := appendULEB(nil, uint64(len(entries)))
+		for _, e := range entries {
+			body = append(body, e...)
+		}
+		return append(appendULEB([]byte{id}, uint64(len(body))), body...)
+	}
+	code := func(body ...byte) []byte {
+		return append(appendULEB(nil, uint64(len(body))), body...)
+	}
+	export := func(name string, kind, idx byte) []byte {
+		return append(appendWasmName(nil, name), kind, idx)
+	}
+
+	forward := code(
+		0x01, 0x02, 0x7f, // two i32 locals: reply length and pointer
+		0x20, 0x00, 0x41, 0x04, 0x6a, // ptr + 4 skips number of keys
+		0x20, 0x01, 0x41, 0x04, 0x6b, // len - 4
+		0x10, 0x00, 0x21, 0x02, // call
+		0x20, 0x02, 0x10, 0x02, 0x21, 0x03, // kvs_alloc
+		0x20, 0x03, 0x10, 0x01, // reply
+		0x20, 0x03, 0xad, 0x42, 0x20, 0x86, 0x20, 0x02, 0xad, 0x84, // ptr << 32 | len
+		0x0b,
+	)
+
+	module := append([]byte{}, wasmHeader...)
+	module = append(module, section(1,
+		[]byte{0x60, 0x02, 0x7f, 0x7f, 0x01, 0x7f}, // call
+		[]byte{0x60, 0x01, 0x7f, 0x00},             // reply
+		[]byte{0x60, 0x01, 0x7f, 0x01, 0x7f},       // kvs_alloc
+		[]byte{0x60, 0x02, 0x7f, 0x7f, 0x01, 0x7e}, // functions
+	)...)
+	module = append(module, section(2,
+		append(appendWasmName(appendWasmName(nil, "kvs"), "call"), 0x00, 0x00),
+		append(appendWasmName(appendWasmName(nil, "kvs"), "reply"), 0x00, 0x01),
+	)...)
+	module = append(module, section(3, []byte{0x02}, []byte{0x03}, []byte{0x03}, []byte{0x03})...)
+	module = append(module, section(5, []byte{0x00, 0x01})...)
+	module = append(module, section(6, []byte{0x7f, 0x01, 0x41, 0x80, 0x08, 0x0b})...) // heap = 1024
+	module = append(module, section(7,
+		export("memory", 0x02, 0),
+		export("kvs_alloc", 0x00, 2),
+		export("kvs_fn_run", 0x00, 3),
+		export("kvs_ro_peek", 0x00, 4),
+		export("kvs_fn_spin", 0x00, 5),
+	)...)
+	module = append(module, section(10,
+		code(0x00, 0x23, 0x00, 0x23, 0x00, 0x20, 0x00, 0x6a, 0x24, 0x00, 0x0b), // bump allocator
+		forward,
+		forward,
+		code(append([]byte{0x00}, spin...)...),
+	)...)
+
+	custom := appendWasmName(nil, functionLibrarySection)
+	custom = append(custom, name...)
+
+	return append(append(module, 0x00), append(appendULEB(nil, uint64(len(custom))), custom...)...)
+}
+
+func resetFunctions(t *testing.T) {
+	t.Helper()
+
+	config.dir = t.TempDir()
+	if err := setLibraries(make(map[string]*functionLibrary)); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		setLibraries(make(map[string]*functionLibrary))
+		config.dir = "."
+	})
+}
+
+func TestFunctionCall(t *testing.T) {
+	initStorage()
+	resetFunctions(t)
+	config.functionFuelLimit = 10000
+	defer func() { config.functionFuelLimit = functionDefaultFuelLimit }()
+
+	var tx txState
+	lib := string(testWasmLibrary("testlib"))
+
+	for _, step := range []struct {
+		cmd   []string
+		reply string
+	}{
+		{[]string{"FCALL", "run", "0"}, ErrFunctionNotFound.Error()},
+		{[]string{"FUNCTION", "LOAD", lib}, "$7\r\ntestlib\r\n"},
+		{[]string{"FUNCTION", "LOAD", lib}, libraryExistsErr("testlib").Error()},
+		{[]string{"FUNCTION", "LOAD", "REPLACE", lib}, "$7\r\ntestlib\r\n"},
+		{[]string{"FUNCTION", "LOAD", string(testWasmLibrary("other"))}, functionExistsErr("peek").Error()},
+		{[]string{"FUNCTION", "LOAD", "not wasm"}, libraryCompileErr(errWasmMalformed.Error()).Error()},
+		{[]string{"FCALL", "run", "0", "SET", "k", "v"}, "+OK\r\n"},
+		{[]string{"FCALL", "run", "1", "GET", "k"}, "$1\r\nv\r\n"},
+		{[]string{"FCALL", "run", "0", "HGET", "k", "f"}, ErrWrongType.Error()},
+		{[]string{"FCALL", "run", "0", "EVAL", "return 1", "0"}, ErrScriptForbiddenCmd.Error()},
+		{[]string{"FCALL_RO", "run", "0", "GET", "k"}, ErrFunctionWriteFlag.Error()},
+		{[]string{"FCALL_RO", "peek", "0", "GET", "k"}, "$1\r\nv\r\n"},
+		{[]string{"FCALL", "peek", "0", "SET", "k", "w"}, ErrScriptReadOnly.Error()},
+		{[]string{"FCALL", "spin", "0"}, ErrFunctionOutOfFuel.Error()},
+		{[]string{"FCALL", "run", "2", "k"}, ErrScriptTooManyKeys.Error()},
+		{[]string{"FUNCTION", "LIST", "LIBRARYNAME", "nope*"}, "*0\r\n"},
+		{[]string{"FUNCTION", "LIST", "LIBRARYNAME", "test*"}, "*1\r\n*6\r\n" +
+			"$12\r\nlibrary_name\r\n$7\r\ntestlib\r\n$6\r\nengine\r\n$4\r\nWASM\r\n$9\r\nfunctions\r\n*3\r\n" +
+			"*4\r\n$4\r\nname\r\n$4\r\npeek\r\n$5\r\nflags\r\n*1\r\n$9\r\nno-writes\r\n" +
+			"*4\r\n$4\r\nname\r\n$3\r\nrun\r\n$5\r\nflags\r\n*0\r\n" +
+			"*4\r\n$4\r\nname\r\n$4\r\nspin\r\n$5\r\nflags\r\n*0\r\n"},
+		{[]string{"FUNCTION", "DELETE", "testlib"}, "+OK\r\n"},
+		{[]string{"FUNCTION", "DELETE", "testlib"}, ErrLibraryNotFound.Error()},
+		{[]string{"FCALL", "run", "0", "GET", "k"}, ErrFunctionNotFound.Error()},
+	} {
+		if got := dispatchTest(t, &tx, step.cmd[0], step.cmd[1:]...); got != step.reply {
+			t.Errorf("%v: got %q, expected: %q", step.cmd[:min(len(step.cmd), 2)], got, step.reply)
+		}
+	}
+}
+
+// Library can't reach the fuel global added by metering and refill its fuel
+func TestFunctionFuelGlobal(t *testing.T) {
+	initStorage()
+	resetFunctions(t)
+	var tx txState
+
+	refill := append(append([]byte{0x03, 0x40, 0x42}, appendSLEB(nil, math.MaxInt64)...), 0x24, 0x01, 0x0c, 0x00, 0x0b, 0x00, 0x0b)
+	for name, spin := range map[string][]byte{
+		"global.set": refill,
+		"global.get": {0x23, 0x01, 0x1a, 0x00, 0x0b},
+	} {
+		lib := string(testWasmLibrarySpin("testlib", spin...))
+		if got, expected := dispatchTest(t, &tx, "FUNCTION", "LOAD", lib), libraryCompileErr(errWasmGlobal.Error()).Error(); got != expected {
+			t.Errorf("%s of the fuel global: got %q, expected: %q", name, got, expected)
+		}
+	}
+}
+
+func TestFunctionDumpRestore(t *testing.T) {
+	initStorage()
+	resetFunctions(t)
+
+	var tx txState
+	dispatchTest(t, &tx, "FUNCTION", "LOAD", string(testWasmLibrary("testlib")))
+
+	dump := dispatchTest(t, &tx, "FUNCTION", "DUMP")
+	payload := dump[strings.Index(dump, "\r\n")+2 : len(dump)-2]
+
+	corrupted := []byte(payload)
+	corrupted[len(corrupted)/2] ^= 0xff
+
+	for _, step := range []struct {
+		cmd   []string
+		reply string
+	}{
+		{[]string{"FUNCTION", "RESTORE", payload}, libraryExistsErr("testlib").Error()},
+		{[]string{"FUNCTION", "RESTORE", string(corrupted), "REPLACE"}, ErrFunctionBadPayload.Error()},
+		{[]string{"FUNCTION", "FLUSH"}, "+OK\r\n"},
+		{[]string{"FCALL", "run", "0", "PING"}, ErrFunctionNotFound.Error()},
+		{[]string{"FUNCTION", "RESTORE", payload}, "+OK\r\n"},
+		{[]string{"FUNCTION", "RESTORE", payload, "REPLACE"}, "+OK\r\n"},
+		{[]string{"FCALL", "run", "0", "PING"}, "+PONG\r\n"},
+	} {
+		if got := dispatchTest(t, &tx, step.cmd[0], step.cmd[1:]...); got != step.reply {
+			t.Errorf("%v: got %q, expected: %q", step.cmd[:2], got, step.reply)
+		}
+	}
+
+	// libraries are persisted on every change and loaded on start
+	saved, err := os.ReadFile(librariesPath())
+	if err != nil || string(saved) != payload {
+		t.Fatalf("saved libraries differ from dump: %v", err)
+	}
+
+	functions.libraries, functions.byName = nil, nil
+	if err := loadLibrariesFile(); err != nil {
+		t.Fatalf("loadLibrariesFile: %v", err)
+	}
+	if got := dispatchTest(t, &tx, "FCALL", "run", "0", "PING"); got != "+PONG\r\n" {
+		t.Errorf("FCALL after reload: got %q", got)
+	}
+}
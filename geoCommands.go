@@ -0,0 +1,538 @@
+package main
+
+import (
+	"math"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// lookupZSet returns sorted set stored at key. If key doesn't exist and create is true, new set is stored,
+// otherwise nil is returned
+func lookupZSet(key string, create bool) (*sortedSet, error) {
+	val, ok := lookupKey(key)
+	if !ok {
+		if !create {
+			return nil, nil
+		}
+
+		z := newSortedSet()
+		storeKey(key, &KvsValue{dtype: ZSetDtype, object: z})
+
+		return z, nil
+	}
+
+	if val.dtype != ZSetDtype {
+		return nil, ErrWrongType
+	}
+
+	return val.object.(*sortedSet), nil
+}
+
+func parseGeoUnit(arg *KvsValue) (float64, error) {
+	factor, ok := geoUnits[strings.ToLower(argToString(arg))]
+	if !ok {
+		return 0, ErrGeoUnsupportedUnit
+	}
+
+	return factor, nil
+}
+
+func parseGeoCoords(lonArg, latArg *KvsValue) (lon, lat float64, err error) {
+	lon, err = argToFloat64(lonArg)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	lat, err = argToFloat64(latArg)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	if !geoValidCoords(lon, lat) {
+		return 0, 0, geoInvalidCoordsErr(lon, lat)
+	}
+
+	return lon, lat, nil
+}
+
+func geoFloatResponse(v float64) []byte {
+	return bulkStrResponse([]byte(strconv.FormatFloat(v, 'g', 17, 64)))
+}
+
+func geoDistResponse(dist float64) []byte {
+	return bulkStrResponse([]byte(strconv.FormatFloat(dist, 'f', 4, 64)))
+}
+
+// GEOADD key [NX | XX] [CH] longitude latitude member [longitude latitude member ...]
+func geoaddHandler(args []*KvsValue) ([]byte, error) {
+	nx, xx, ch := false, false, false
+
+	i := 1
+	for ; i < len(args); i++ {
+		switch strings.ToUpper(argToString(args[i])) {
+		case "NX":
+			nx = true
+			continue
+		case "XX":
+			xx = true
+			continue
+		case "CH":
+			ch = true
+			continue
+		}
+		break
+	}
+
+	if nx && xx {
+		return nil, ErrGeoNxXx
+	}
+
+	triples := args[i:]
+	if len(triples) == 0 || len(triples)%3 != 0 {
+		return nil, ErrSyntax
+	}
+
+	scores := make([]float64, 0, len(triples)/3)
+	for j := 0; j < len(triples); j += 3 {
+		lon, lat, err := parseGeoCoords(triples[j], triples[j+1])
+		if err != nil {
+			return nil, err
+		}
+		scores = append(scores, float64(geoEncode(lon, lat, geoStepMax)))
+	}
+
+	key := argToString(args[0])
+	z, err := lookupZSet(key, false)
+	if err != nil {
+		return nil, err
+	}
+
+	if z == nil {
+		if xx {
+			return intResponse(0), nil
+		}
+		z, _ = lookupZSet(key, true)
+	}
+
+	var added, changed int64
+	for j, score := range scores {
+		member := argToString(triples[j*3+2])
+
+		old, exists := z.score(member)
+		if (exists && nx) || (!exists && xx) {
+			continue
+		}
+
+		z.add(member, score)
+		if !exists {
+			added++
+		} else if old != score {
+			changed++
+		}
+	}
+
+	if z.len() == 0 {
+		removeKey(key)
+	}
+
+	if added+changed > 0 {
+		signalModifiedKey(key, notifyZset, "zadd")
+	}
+
+	if ch {
+		return intResponse(added + changed), nil
+	}
+
+	return intResponse(added), nil
+}
+
+// GEODIST key member1 member2 [M | KM | FT | MI]
+func geodistHandler(args []*KvsValue) ([]byte, error) {
+	if len(args) > 4 {
+		return nil, ErrSyntax
+	}
+
+	unit := 1.0
+	if len(args) == 4 {
+		var err error
+		unit, err = parseGeoUnit(args[3])
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	z, err := lookupZSet(argToString(args[0]), false)
+	if err != nil || z == nil {
+		return []byte(NullResponse), err
+	}
+
+	score1, ok1 := z.score(argToString(args[1]))
+	score2, ok2 := z.score(argToString(args[2]))
+	if !ok1 || !ok2 {
+		return []byte(NullResponse), nil
+	}
+
+	lon1, lat1 := geoDecode(uint64(score1))
+	lon2, lat2 := geoDecode(uint64(score2))
+
+	return geoDistResponse(geoDistance(lon1, lat1, lon2, lat2) / unit), nil
+}
+
+// GEOPOS key [member [member ...]]
+func geoposHandler(args []*KvsValue) ([]byte, error) {
+	z, err := lookupZSet(argToString(args[0]), false)
+	if err != nil {
+		return nil, err
+	}
+
+	elems := make([][]byte, 0, len(args)-1)
+	for _, arg := range args[1:] {
+		var score float64
+		ok := false
+		if z != nil {
+			score, ok = z.score(argToString(arg))
+		}
+
+		if !ok {
+			elems = append(elems, []byte(NullResponse))
+			continue
+		}
+
+		lon, lat := geoDecode(uint64(score))
+		elems = append(elems, arrayResponse(geoFloatResponse(lon), geoFloatResponse(lat)))
+	}
+
+	return arrayResponse(elems...), nil
+}
+
+// GEOHASH key [member [member ...]]
+func geohashHandler(args []*KvsValue) ([]byte, error) {
+	z, err := lookupZSet(argToString(args[0]), false)
+	if err != nil {
+		return nil, err
+	}
+
+	elems := make([][]byte, 0, len(args)-1)
+	for _, arg := range args[1:] {
+		var score float64
+		ok := false
+		if z != nil {
+			score, ok = z.score(argToString(arg))
+		}
+
+		if !ok {
+			elems = append(elems, []byte(NullResponse))
+			continue
+		}
+
+		elems = append(elems, bulkStrResponse([]byte(geoHashString(uint64(score)))))
+	}
+
+	return arrayResponse(elems...), nil
+}
+
+const (
+	geoSortNone = iota
+	geoSortAsc
+	geoSortDesc
+)
+
+type geoSearchArgs struct {
+	fromMember string
+	hasMember  bool
+	hasLonLat  bool
+	lon, lat   float64
+
+	byRadius bool
+	byBox    bool
+	radius   float64 // meters
+	width    float64 // meters
+	height   float64 // meters
+	unit     float64
+
+	sort      int
+	count     int64
+	any       bool
+	withCoord bool
+	withDist  bool
+	withHash  bool
+	storeDist bool
+}
+
+func parseGeoSearchArgs(args []*KvsValue, isStore bool) (opts geoSearchArgs, err error) {
+	for i := 0; i < len(args); i++ {
+		opt := strings.ToUpper(argToString(args[i]))
+		left := len(args) - i - 1
+
+		switch {
+		case opt == "FROMMEMBER" && left >= 1 && !opts.hasMember:
+			opts.fromMember = argToString(args[i+1])
+			opts.hasMember = true
+			i++
+		case opt == "FROMLONLAT" && left >= 2 && !opts.hasLonLat:
+			opts.lon, opts.lat, err = parseGeoCoords(args[i+1], args[i+2])
+			if err != nil {
+				return opts, err
+			}
+			opts.hasLonLat = true
+			i += 2
+		case opt == "BYRADIUS" && left >= 2 && !opts.byRadius:
+			opts.radius, err = argToFloat64(args[i+1])
+			if err != nil {
+				return opts, err
+			}
+			if opts.radius < 0 {
+				return opts, ErrGeoNegativeRadius
+			}
+			if opts.unit, err = parseGeoUnit(args[i+2]); err != nil {
+				return opts, err
+			}
+			opts.radius *= opts.unit
+			opts.byRadius = true
+			i += 2
+		case opt == "BYBOX" && left >= 3 && !opts.byBox:
+			opts.width, err = argToFloat64(args[i+1])
+			if err != nil {
+				return opts, err
+			}
+			opts.height, err = argToFloat64(args[i+2])
+			if err != nil {
+				return opts, err
+			}
+			if opts.width < 0 || opts.height < 0 {
+				return opts, ErrGeoNegativeSize
+			}
+			if opts.unit, err = parseGeoUnit(args[i+3]); err != nil {
+				return opts, err
+			}
+			opts.width *= opts.unit
+			opts.height *= opts.unit
+			opts.byBox = true
+			i += 3
+		case opt == "ASC":
+			opts.sort = geoSortAsc
+		case opt == "DESC":
+			opts.sort = geoSortDesc
+		case opt == "COUNT" && left >= 1:
+			opts.count, err = argToInt64(args[i+1])
+			if err != nil {
+				return opts, err
+			}
+			if opts.count <= 0 {
+				return opts, ErrGeoCountNotPositive
+			}
+			i++
+		case opt == "ANY":
+			opts.any = true
+		case opt == "WITHCOORD" && !isStore:
+			opts.withCoord = true
+		case opt == "WITHDIST" && !isStore:
+			opts.withDist = true
+		case opt == "WITHHASH" && !isStore:
+			opts.withHash = true
+		case opt == "STOREDIST" && isStore:
+			opts.storeDist = true
+		case isStore && (opt == "WITHCOORD" || opt == "WITHDIST" || opt == "WITHHASH"):
+			return opts, ErrGeoStoreWith
+		default:
+			return opts, ErrSyntax
+		}
+	}
+
+	if opts.hasMember == opts.hasLonLat {
+		return opts, ErrGeoNoCenter
+	}
+
+	if opts.byRadius == opts.byBox {
+		return opts, ErrGeoNoShape
+	}
+
+	if opts.any && opts.count == 0 {
+		return opts, ErrGeoAnyWithoutCount
+	}
+
+	// with COUNT only the closest points make sense, so results are sorted unless any points will do
+	if opts.count > 0 && !opts.any && opts.sort == geoSortNone {
+		opts.sort = geoSortAsc
+	}
+
+	return opts, nil
+}
+
+type geoPoint struct {
+	member string
+	score  float64
+	dist   float64 // meters
+	lon    float64
+	lat    float64
+}
+
+// distance from center to point if point is inside the searched shape
+func (opts *geoSearchArgs) distanceIfInside(lon, lat float64) (float64, bool) {
+	if opts.byRadius {
+		dist := geoDistance(opts.lon, opts.lat, lon, lat)
+		return dist, dist <= opts.radius
+	}
+
+	// for box latitude and longitude distances are checked separately
+	if geoDistance(opts.lon, opts.lat, opts.lon, lat) > opts.height/2 {
+		return 0, false
+	}
+
+	if geoDistance(opts.lon, lat, lon, lat) > opts.width/2 {
+		return 0, false
+	}
+
+	return geoDistance(opts.lon, opts.lat, lon, lat), true
+}
+
+func geoSearch(z *sortedSet, opts *geoSearchArgs) []geoPoint {
+	radius := opts.radius
+	if opts.byBox {
+		radius = math.Sqrt((opts.width/2)*(opts.width/2) + (opts.height/2)*(opts.height/2))
+	}
+
+	cells, step := geoSearchCells(opts.lon, opts.lat, radius)
+	shift := 2 * (geoStepMax - step)
+
+	var res []geoPoint
+	limitReached := func() bool {
+		return opts.any && int64(len(res)) >= opts.count
+	}
+
+	for _, cell := range cells {
+		r := zrangeSpec{min: float64(cell << shift), max: float64((cell + 1) << shift), maxEx: true}
+
+		z.rangeByScore(r, func(member string, score float64) bool {
+			lon, lat := geoDecode(uint64(score))
+
+			if dist, ok := opts.distanceIfInside(lon, lat); ok {
+				res = append(res, geoPoint{member: member, score: score, dist: dist, lon: lon, lat: lat})
+			}
+
+			return !limitReached()
+		})
+
+		if limitReached() {
+			break
+		}
+	}
+
+	switch opts.sort {
+	case geoSortAsc:
+		slices.SortFunc(res, func(a, b geoPoint) int { return cmpFloat(a.dist, b.dist) })
+	case geoSortDesc:
+		slices.SortFunc(res, func(a, b geoPoint) int { return cmpFloat(b.dist, a.dist) })
+	}
+
+	if opts.count > 0 && int64(len(res)) > opts.count {
+		res = res[:opts.count]
+	}
+
+	return res
+}
+
+func cmpFloat(a, b float64) int {
+	switch {
+	case a < b:
+		return -1
+	case a > b:
+		return 1
+	}
+
+	return 0
+}
+
+// resolves search center and runs the search. Returns nil points if source key doesn't exist
+func runGeoSearch(srcKey string, opts *geoSearchArgs) ([]geoPoint, error) {
+	z, err := lookupZSet(srcKey, false)
+	if err != nil || z == nil {
+		return nil, err
+	}
+
+	if opts.hasMember {
+		score, ok := z.score(opts.fromMember)
+		if !ok {
+			return nil, ErrGeoMemberNotFound
+		}
+		opts.lon, opts.lat = geoDecode(uint64(score))
+	}
+
+	return geoSearch(z, opts), nil
+}
+
+// GEOSEARCH key <FROMMEMBER member | FROMLONLAT longitude latitude> <BYRADIUS radius <M | KM | FT | MI>
+// | BYBOX width height <M | KM | FT | MI>> [ASC | DESC] [COUNT count [ANY]] [WITHCOORD] [WITHDIST] [WITHHASH]
+func geosearchHandler(args []*KvsValue) ([]byte, error) {
+	opts, err := parseGeoSearchArgs(args[1:], false)
+	if err != nil {
+		return nil, err
+	}
+
+	points, err := runGeoSearch(argToString(args[0]), &opts)
+	if err != nil {
+		return nil, err
+	}
+
+	elems := make([][]byte, len(points))
+	for i, p := range points {
+		member := bulkStrResponse([]byte(p.member))
+
+		if !opts.withDist && !opts.withHash && !opts.withCoord {
+			elems[i] = member
+			continue
+		}
+
+		item := [][]byte{member}
+		if opts.withDist {
+			item = append(item, geoDistResponse(p.dist/opts.unit))
+		}
+		if opts.withHash {
+			item = append(item, intResponse(int64(p.score)))
+		}
+		if opts.withCoord {
+			item = append(item, arrayResponse(geoFloatResponse(p.lon), geoFloatResponse(p.lat)))
+		}
+
+		elems[i] = arrayResponse(item...)
+	}
+
+	return arrayResponse(elems...), nil
+}
+
+// GEOSEARCHSTORE destination source <FROMMEMBER member | FROMLONLAT longitude latitude> <BYRADIUS radius
+// <M | KM | FT | MI> | BYBOX width height <M | KM | FT | MI>> [ASC | DESC] [COUNT count [ANY]] [STOREDIST]
+func geosearchstoreHandler(args []*KvsValue) ([]byte, error) {
+	opts, err := parseGeoSearchArgs(args[2:], true)
+	if err != nil {
+		return nil, err
+	}
+
+	points, err := runGeoSearch(argToString(args[1]), &opts)
+	if err != nil {
+		return nil, err
+	}
+
+	destKey := argToString(args[0])
+	if len(points) == 0 {
+		if removeKey(destKey) {
+			signalModifiedKey(destKey, notifyGeneric, "del")
+		}
+		return intResponse(0), nil
+	}
+
+	z := newSortedSet()
+	for _, p := range points {
+		score := p.score
+		if opts.storeDist {
+			score = p.dist / opts.unit
+		}
+		z.add(p.member, score)
+	}
+
+	storeKey(destKey, &KvsValue{dtype: ZSetDtype, object: z})
+	signalModifiedKey(destKey, notifyZset, "geosearchstore")
+
+	return intResponse(int64(z.len())), nil
+}
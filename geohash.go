@@ -0,0 +1,222 @@
+package main
+
+import (
+	"math"
+)
+
+// Coordinates are encoded as 52 bit interleaved geohash (26 bits per coordinate, longitude bit first)
+// within Web Mercator bounds, so the hash fits into float64 score of a sorted set without loss.
+// Nearby points have nearby scores, which makes area search a handful of score range queries
+const (
+	geoStepMax   = 26
+	geoLatMin    = -85.05112878
+	geoLatMax    = 85.05112878
+	geoLonMin    = -180.0
+	geoLonMax    = 180.0
+	geoLatMinStd = -90.0
+	geoLatMaxStd = 90.0
+
+	earthRadiusM = 6372797.560856
+	mercatorMax  = 20037726.37
+
+	geoAlphabet = "0123456789bcdefghjkmnpqrstuvwxyz"
+)
+
+var geoUnits = map[string]float64{
+	"m":  1,
+	"km": 1000,
+	"mi": 1609.34,
+	"ft": 0.3048,
+}
+
+// spreads lower 32 bits of x so there is a zero bit between every two bits
+func geoSpread(x uint64) uint64 {
+	x &= 0xffffffff
+	x = (x | x<<16) & 0x0000ffff0000ffff
+	x = (x | x<<8) & 0x00ff00ff00ff00ff
+	x = (x | x<<4) & 0x0f0f0f0f0f0f0f0f
+	x = (x | x<<2) & 0x3333333333333333
+	x = (x | x<<1) & 0x5555555555555555
+
+	return x
+}
+
+func geoSquash(x uint64) uint64 {
+	x &= 0x5555555555555555
+	x = (x | x>>1) & 0x3333333333333333
+	x = (x | x>>2) & 0x0f0f0f0f0f0f0f0f
+	x = (x | x>>4) & 0x00ff00ff00ff00ff
+	x = (x | x>>8) & 0x0000ffff0000ffff
+	x = (x | x>>16) & 0x00000000ffffffff
+
+	return x
+}
+
+func geoInterleave(latBits, lonBits uint64) uint64 {
+	return geoSpread(latBits) | geoSpread(lonBits)<<1
+}
+
+func geoDeinterleave(hash uint64) (latBits, lonBits uint64) {
+	return geoSquash(hash), geoSquash(hash >> 1)
+}
+
+func geoValidCoords(lon, lat float64) bool {
+	return lon >= geoLonMin && lon <= geoLonMax && lat >= geoLatMin && lat <= geoLatMax
+}
+
+func geoEncodeRange(lon, lat float64, latMin, latMax float64, step uint) uint64 {
+	latOffset := (lat - latMin) / (latMax - latMin)
+	lonOffset := (lon - geoLonMin) / (geoLonMax - geoLonMin)
+
+	cells := uint64(1) << step
+
+	// points right on the upper bound belong to the last cell
+	latCell := min(uint64(latOffset*float64(cells)), cells-1)
+	lonCell := min(uint64(lonOffset*float64(cells)), cells-1)
+
+	return geoInterleave(latCell, lonCell)
+}
+
+// geoEncode returns hash of coordinates with given precision (2*step bits)
+func geoEncode(lon, lat float64, step uint) uint64 {
+	return geoEncodeRange(lon, lat, geoLatMin, geoLatMax, step)
+}
+
+type geoArea struct {
+	lonMin, lonMax float64
+	latMin, latMax float64
+}
+
+func geoDecodeArea(hash uint64, step uint) geoArea {
+	latBits, lonBits := geoDeinterleave(hash)
+
+	latScale := geoLatMax - geoLatMin
+	lonScale := geoLonMax - geoLonMin
+	cells := float64(uint64(1) << step)
+
+	return geoArea{
+		latMin: geoLatMin + float64(latBits)/cells*latScale,
+		latMax: geoLatMin + float64(latBits+1)/cells*latScale,
+		lonMin: geoLonMin + float64(lonBits)/cells*lonScale,
+		lonMax: geoLonMin + float64(lonBits+1)/cells*lonScale,
+	}
+}
+
+// geoDecode returns center of the cell described by 52 bit hash
+func geoDecode(hash uint64) (lon, lat float64) {
+	area := geoDecodeArea(hash, geoStepMax)
+
+	lon = min(max((area.lonMin+area.lonMax)/2, geoLonMin), geoLonMax)
+	lat = min(max((area.latMin+area.latMax)/2, geoLatMin), geoLatMax)
+
+	return lon, lat
+}
+
+// geoHashString returns standard 11 characters geohash. Standard geohash uses [-90, 90] latitude range,
+// so coordinates are re-encoded with it
+func geoHashString(hash uint64) string {
+	lon, lat := geoDecode(hash)
+	std := geoEncodeRange(lon, lat, geoLatMinStd, geoLatMaxStd, geoStepMax)
+
+	res := make([]byte, 11)
+	for i := range res {
+		idx := uint64(0)
+		// only 52 bits are available, so the last character always stands for zero bits
+		if i < 10 {
+			idx = (std >> (52 - (i+1)*5)) & 0x1f
+		}
+		res[i] = geoAlphabet[idx]
+	}
+
+	return string(res)
+}
+
+func degRad(deg float64) float64 {
+	return deg * math.Pi / 180
+}
+
+// geoDistance returns haversine distance in meters
+func geoDistance(lon1, lat1, lon2, lat2 float64) float64 {
+	lat1r, lat2r := degRad(lat1), degRad(lat2)
+	u := math.Sin((lat2r - lat1r) / 2)
+	v := math.Sin(degRad(lon2-lon1) / 2)
+
+	a := u*u + math.Cos(lat1r)*math.Cos(lat2r)*v*v
+
+	return 2 * earthRadiusM * math.Asin(math.Sqrt(a))
+}
+
+// geoEstimateStep returns the largest precision at which 3x3 cells around the center still cover radius
+func geoEstimateStep(radius, lat float64) uint {
+	if radius == 0 {
+		return geoStepMax
+	}
+
+	step := 1
+	for radius < mercatorMax {
+		radius *= 2
+		step++
+	}
+	step -= 2
+
+	// cells get narrower towards the poles
+	if lat > 66 || lat < -66 {
+		step--
+		if lat > 80 || lat < -80 {
+			step--
+		}
+	}
+
+	return uint(min(max(step, 1), geoStepMax))
+}
+
+// geoNeighbors returns center cell at step and its 8 neighbours (less near the poles)
+func geoNeighbors(hash uint64, step uint) []uint64 {
+	latBits, lonBits := geoDeinterleave(hash)
+	cells := int64(1) << step
+
+	res := make([]uint64, 0, 9)
+	seen := make(map[uint64]bool, 9)
+
+	for dLat := int64(-1); dLat <= 1; dLat++ {
+		lat := int64(latBits) + dLat
+		if lat < 0 || lat >= cells {
+			continue
+		}
+
+		for dLon := int64(-1); dLon <= 1; dLon++ {
+			// longitude wraps around antimeridian
+			lon := (int64(lonBits) + dLon + cells) % cells
+
+			cell := geoInterleave(uint64(lat), uint64(lon))
+			if !seen[cell] {
+				seen[cell] = true
+				res = append(res, cell)
+			}
+		}
+	}
+
+	return res
+}
+
+// geoSearchCells returns hash cells at the right precision that cover the area around the point
+func geoSearchCells(lon, lat, radius float64) ([]uint64, uint) {
+	step := geoEstimateStep(radius, lat)
+
+	// shrink precision until cells are at least radius wide, otherwise the 3x3 block can miss some points
+	for step > 1 {
+		area := geoDecodeArea(geoEncode(lon, lat, step), step)
+		cellHeight := geoDistance(lon, area.latMin, lon, area.latMax)
+		// neighbour rows are the narrowest ones on the side closer to a pole
+		rowHeight := area.latMax - area.latMin
+		worstLat := min(max(math.Abs(area.latMin-rowHeight), math.Abs(area.latMax+rowHeight)), geoLatMax)
+		cellWidth := geoDistance(area.lonMin, worstLat, area.lonMax, worstLat)
+
+		if cellHeight >= radius && cellWidth >= radius {
+			break
+		}
+		step--
+	}
+
+	return geoNeighbors(geoEncode(lon, lat, step), step), step
+}
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"math"
+	"testing"
+)
+
+const (
+	palermoLon = 13.361389
+	palermoLat = 38.115556
+	cataniaLon = 15.087269
+	cataniaLat = 37.502669
+)
+
+func TestGeoEncodeKnownScore(t *testing.T) {
+	expected := uint64(3479099956230698)
+
+	res := geoEncode(palermoLon, palermoLat, geoStepMax)
+
+	if res != expected {
+		t.Errorf("geoEncode(Palermo) = %v, expected: %v", res, expected)
+	}
+}
+
+func TestGeoDecodeRoundTrip(t *testing.T) {
+	lon, lat := geoDecode(geoEncode(palermoLon, palermoLat, geoStepMax))
+
+	if math.Abs(lon-palermoLon) > 1e-5 || math.Abs(lat-palermoLat) > 1e-5 {
+		t.Errorf("geoDecode(geoEncode(Palermo)) = %v,%v, expected: %v,%v", lon, lat, palermoLon, palermoLat)
+	}
+}
+
+func TestGeoHashString(t *testing.T) {
+	cases := []struct {
+		lon, lat float64
+		expected string
+	}{
+		{palermoLon, palermoLat, "sqc8b49rny0"},
+		{cataniaLon, cataniaLat, "sqdtr74hyu0"},
+	}
+
+	for _, c := range cases {
+		res := geoHashString(geoEncode(c.lon, c.lat, geoStepMax))
+		if res != c.expected {
+			t.Errorf("geoHashString(%v,%v) = %v, expected: %v", c.lon, c.lat, res, c.expected)
+		}
+	}
+}
+
+func TestGeoDistance(t *testing.T) {
+	expected := 166274.1516
+
+	res := geoDistance(palermoLon, palermoLat, cataniaLon, cataniaLat)
+
+	if math.Abs(res-expected) > 1 {
+		t.Errorf("geoDistance(Palermo, Catania) = %v, expected: %v", res, expected)
+	}
+}
+
+func TestGeoEncodeUpperBound(t *testing.T) {
+	res := geoEncode(geoLonMax, geoLatMax, geoStepMax)
+
+	if res >= 1<<52 {
+		t.Errorf("geoEncode(<upper bounds>) = %v, expected hash below 2^52", res)
+	}
+}
+
+func TestGeoNeighborsWrapLongitude(t *testing.T) {
+	hash := geoEncode(179.99, 0, 4)
+
+	res := geoNeighbors(hash, 4)
+
+	if len(res) != 9 {
+		t.Errorf("geoNeighbors(<cell at antimeridian>) returned %v cells, expected: %v", len(res), 9)
+	}
+}
+
+func TestGeoSearchCellsCoverRadius(t *testing.T) {
+	z := newSortedSet()
+	z.add("Palermo", float64(geoEncode(palermoLon, palermoLat, geoStepMax)))
+	z.add("Catania", float64(geoEncode(cataniaLon, cataniaLat, geoStepMax)))
+
+	opts := &geoSearchArgs{lon: 15, lat: 37, byRadius: true, radius: 200000, unit: 1, sort: geoSortAsc}
+
+	res := geoSearch(z, opts)
+
+	if len(res) != 2 || res[0].member != "Catania" || res[1].member != "Palermo" {
+		t.Errorf("geoSearch(15,37 radius 200km) = %v, expected Catania and Palermo", res)
+	}
+}
@@ -1,3 +1,8 @@
 module github.com/dmitrenko-v/kvs
 
 go 1.25.4
+
+require (
+	github.com/tetratelabs/wazero v1.9.0
+	github.com/yuin/gopher-lua v1.1.2
+)
@@ -0,0 +1,416 @@
+package main
+
+import (
+	"cmp"
+	"maps"
+	"slices"
+	"strconv"
+)
+
+// Graph keeps nodes and relationships (edges) with labels or type and property maps. Every node knows
+// its outgoing and incoming edges, so traversals don't scan the whole graph. Nodes are indexed by label,
+// and CREATE INDEX adds exact match indexes of (label, property) pairs
+type graphProp struct {
+	key string
+	val any // int64, float64, string, bool or []any
+}
+
+type graphNode struct {
+	id     int64
+	labels []string
+	props  []graphProp
+	out    []*graphEdge
+	in     []*graphEdge
+}
+
+type graphEdge struct {
+	id       int64
+	typ      string
+	src, dst *graphNode
+	props    []graphProp
+}
+
+type graphIndexKey struct {
+	label, prop string
+}
+
+type graph struct {
+	nodes      map[int64]*graphNode
+	edges      map[int64]*graphEdge
+	nextNodeID int64
+	nextEdgeID int64
+
+	labels  map[string]map[int64]*graphNode
+	indexes map[graphIndexKey]map[string]map[int64]*graphNode // property value key -> nodes
+}
+
+func newGraph() *graph {
+	return &graph{
+		nodes:   make(map[int64]*graphNode),
+		edges:   make(map[int64]*graphEdge),
+		labels:  make(map[string]map[int64]*graphNode),
+		indexes: make(map[graphIndexKey]map[string]map[int64]*graphNode),
+	}
+}
+
+func getProp(props []graphProp, key string) (any, bool) {
+	for _, p := range props {
+		if p.key == key {
+			return p.val, true
+		}
+	}
+
+	return nil, false
+}
+
+// setProp sets property, nil value removes it
+func setProp(props []graphProp, key string, val any) []graphProp {
+	for i, p := range props {
+		if p.key == key {
+			if val == nil {
+				return slices.Delete(props, i, i+1)
+			}
+			props[i].val = val
+			return props
+		}
+	}
+
+	if val == nil {
+		return props
+	}
+
+	return append(props, graphProp{key: key, val: val})
+}
+
+// graphValueKey returns key of property value in indexes. Integers and floats with equal values get
+// the same key, since they are equal in queries
+func graphValueKey(v any) (string, bool) {
+	switch v := v.(type) {
+	case int64:
+		return "n" + strconv.FormatFloat(float64(v), 'g', -1, 64), true
+	case float64:
+		return "n" + strconv.FormatFloat(v, 'g', -1, 64), true
+	case string:
+		return "s" + v, true
+	case bool:
+		return "b" + strconv.FormatBool(v), true
+	}
+
+	return "", false
+}
+
+// sortedNodes returns nodes ordered by id, so query results don't depend on map order
+func sortedNodes(nodes map[int64]*graphNode) []*graphNode {
+	return slices.SortedFunc(maps.Values(nodes), func(a, b *graphNode) int { return cmp.Compare(a.id, b.id) })
+}
+
+func (g *graph) addNode(labels []string, props []graphProp) *graphNode {
+	n := &graphNode{id: g.nextNodeID, props: props}
+	g.nextNodeID++
+	g.nodes[n.id] = n
+
+	for _, l := range labels {
+		g.addLabel(n, l)
+	}
+
+	return n
+}
+
+func (g *graph) addEdge(typ string, src, dst *graphNode, props []graphProp) *graphEdge {
+	e := &graphEdge{id: g.nextEdgeID, typ: typ, src: src, dst: dst, props: props}
+	g.nextEdgeID++
+	g.edges[e.id] = e
+
+	src.out = append(src.out, e)
+	dst.in = append(dst.in, e)
+
+	return e
+}
+
+// addLabel returns false if node already has label
+func (g *graph) addLabel(n *graphNode, label string) bool {
+	if slices.Contains(n.labels, label) {
+		return false
+	}
+
+	n.labels = append(n.labels, label)
+	if g.labels[label] == nil {
+		g.labels[label] = make(map[int64]*graphNode)
+	}
+	g.labels[label][n.id] = n
+
+	for _, p := range n.props {
+		g.indexNode(graphIndexKey{label, p.key}, n, p.val, true)
+	}
+
+	return true
+}
+
+func (g *graph) indexNode(key graphIndexKey, n *graphNode, val any, add bool) {
+	idx, ok := g.indexes[key]
+	if !ok {
+		return
+	}
+
+	vk, ok := graphValueKey(val)
+	if !ok {
+		return
+	}
+
+	if add {
+		if idx[vk] == nil {
+			idx[vk] = make(map[int64]*graphNode)
+		}
+		idx[vk][n.id] = n
+		return
+	}
+
+	delete(idx[vk], n.id)
+	if len(idx[vk]) == 0 {
+		delete(idx, vk)
+	}
+}
+
+func (g *graph) setNodeProp(n *graphNode, key string, val any) {
+	if old, ok := getProp(n.props, key); ok {
+		for _, l := range n.labels {
+			g.indexNode(graphIndexKey{l, key}, n, old, false)
+		}
+	}
+
+	n.props = setProp(n.props, key, val)
+
+	if val != nil {
+		for _, l := range n.labels {
+			g.indexNode(graphIndexKey{l, key}, n, val, true)
+		}
+	}
+}
+
+func (g *graph) deleteEdge(e *graphEdge) bool {
+	if _, ok := g.edges[e.id]; !ok {
+		return false
+	}
+
+	delete(g.edges, e.id)
+	e.src.out = slices.DeleteFunc(e.src.out, func(x *graphEdge) bool { return x == e })
+	e.dst.in = slices.DeleteFunc(e.dst.in, func(x *graphEdge) bool { return x == e })
+
+	return true
+}
+
+// deleteNode removes node with all its edges and returns number of removed edges, or -1 if node
+// was already removed
+func (g *graph) deleteNode(n *graphNode) int {
+	if _, ok := g.nodes[n.id]; !ok {
+		return -1
+	}
+
+	removed := 0
+	for _, e := range slices.Concat(n.out, n.in) {
+		if g.deleteEdge(e) {
+			removed++
+		}
+	}
+
+	for _, l := range n.labels {
+		delete(g.labels[l], n.id)
+		if len(g.labels[l]) == 0 {
+			delete(g.labels, l)
+		}
+
+		for _, p := range n.props {
+			g.indexNode(graphIndexKey{l, p.key}, n, p.val, false)
+		}
+	}
+
+	delete(g.nodes, n.id)
+
+	return removed
+}
+
+// createIndex indexes existing nodes with label by prop and returns false if the index already exists
+func (g *graph) createIndex(label, prop string) bool {
+	key := graphIndexKey{label, prop}
+	if _, ok := g.indexes[key]; ok {
+		return false
+	}
+
+	g.indexes[key] = make(map[string]map[int64]*graphNode)
+	for _, n := range g.labels[label] {
+		if v, ok := getProp(n.props, prop); ok {
+			g.indexNode(key, n, v, true)
+		}
+	}
+
+	return true
+}
+
+// lookupIndex returns nodes with label having prop equal to val, ok is false if there is no such index
+func (g *graph) lookupIndex(label, prop string, val any) ([]*graphNode, bool) {
+	idx, ok := g.indexes[graphIndexKey{label, prop}]
+	if !ok {
+		return nil, false
+	}
+
+	vk, ok := graphValueKey(val)
+	if !ok {
+		return nil, true
+	}
+
+	return sortedNodes(idx[vk]), true
+}
+
+// property values are serialized with a tag byte
+const (
+	graphTagInt = iota
+	graphTagFloat
+	graphTagString
+	graphTagBool
+	graphTagArray
+)
+
+func appendGraphValue(b []byte, v any) []byte {
+	switch v := v.(type) {
+	case int64:
+		return appendU64(append(b, graphTagInt), uint64(v))
+	case float64:
+		return appendF64(append(b, graphTagFloat), v)
+	case string:
+		return appendStr(append(b, graphTagString), v)
+	case bool:
+		if v {
+			return append(b, graphTagBool, 1)
+		}
+		return append(b, graphTagBool, 0)
+	case []any:
+		b = appendU32(append(b, graphTagArray), len(v))
+		for _, el := range v {
+			b = appendGraphValue(b, el)
+		}
+	}
+
+	return b
+}
+
+func (r *dumpReader) graphValue() any {
+	switch r.u8() {
+	case graphTagInt:
+		return r.i64()
+	case graphTagFloat:
+		return r.f64()
+	case graphTagString:
+		return r.str()
+	case graphTagBool:
+		return r.u8() != 0
+	case graphTagArray:
+		arr := make([]any, r.count(2))
+		for i := range arr {
+			arr[i] = r.graphValue()
+		}
+		return arr
+	}
+
+	r.failed = true
+	return nil
+}
+
+func appendGraphProps(b []byte, props []graphProp) []byte {
+	b = appendU32(b, len(props))
+	for _, p := range props {
+		b = appendStr(b, p.key)
+		b = appendGraphValue(b, p.val)
+	}
+
+	return b
+}
+
+func (r *dumpReader) graphProps() []graphProp {
+	props := make([]graphProp, r.count(6))
+	for i := range props {
+		props[i] = graphProp{key: r.str(), val: r.graphValue()}
+	}
+
+	return props
+}
+
+// dump serializes nodes and edges ordered by id, and indexed (label, property) pairs
+func (g *graph) dump(b []byte) []byte {
+	b = appendU64(b, uint64(g.nextNodeID))
+	b = appendU64(b, uint64(g.nextEdgeID))
+
+	b = appendU32(b, len(g.nodes))
+	for _, n := range sortedNodes(g.nodes) {
+		b = appendU64(b, uint64(n.id))
+		b = appendU32(b, len(n.labels))
+		for _, l := range n.labels {
+			b = appendStr(b, l)
+		}
+		b = appendGraphProps(b, n.props)
+	}
+
+	b = appendU32(b, len(g.edges))
+	for _, id := range slices.Sorted(maps.Keys(g.edges)) {
+		e := g.edges[id]
+		b = appendU64(b, uint64(e.id))
+		b = appendStr(b, e.typ)
+		b = appendU64(b, uint64(e.src.id))
+		b = appendU64(b, uint64(e.dst.id))
+		b = appendGraphProps(b, e.props)
+	}
+
+	keys := slices.SortedFunc(maps.Keys(g.indexes), func(a, b graphIndexKey) int {
+		return cmp.Or(cmp.Compare(a.label, b.label), cmp.Compare(a.prop, b.prop))
+	})
+	b = appendU32(b, len(keys))
+	for _, key := range keys {
+		b = appendStr(b, key.label)
+		b = appendStr(b, key.prop)
+	}
+
+	return b
+}
+
+func restoreGraph(r *dumpReader) *graph {
+	g := newGraph()
+	g.nextNodeID, g.nextEdgeID = r.i64(), r.i64()
+
+	for range r.count(16) {
+		n := &graphNode{id: r.i64()}
+		labels := make([]string, r.count(4))
+		for i := range labels {
+			labels[i] = r.str()
+		}
+		n.props = r.graphProps()
+
+		_, dup := g.nodes[n.id]
+		r.check(!dup && n.id < g.nextNodeID)
+		g.nodes[n.id] = n
+		for _, l := range labels {
+			g.addLabel(n, l)
+		}
+	}
+
+	for range r.count(32) {
+		e := &graphEdge{id: r.i64(), typ: r.str()}
+		src, srcOK := g.nodes[r.i64()]
+		dst, dstOK := g.nodes[r.i64()]
+		e.props = r.graphProps()
+
+		_, dup := g.edges[e.id]
+		r.check(srcOK && dstOK && !dup && e.id < g.nextEdgeID)
+		if r.failed {
+			return g
+		}
+		e.src, e.dst = src, dst
+		g.edges[e.id] = e
+		src.out = append(src.out, e)
+		dst.in = append(dst.in, e)
+	}
+
+	for range r.count(8) {
+		g.createIndex(r.str(), r.str())
+	}
+
+	return g
+}
@@ -0,0 +1,204 @@
+package main
+
+import (
+	"fmt"
+	"maps"
+	"slices"
+	"strconv"
+	"time"
+)
+
+// lookupGraph returns graph stored at key. If key doesn't exist and create is true, new graph is stored,
+// otherwise nil is returned
+func lookupGraph(key string, create bool) (*graph, error) {
+	val, ok := lookupKey(key)
+	if !ok {
+		if !create {
+			return nil, nil
+		}
+
+		g := newGraph()
+		storeKey(key, &KvsValue{dtype: GraphDtype, object: g})
+
+		return g, nil
+	}
+
+	if val.dtype != GraphDtype {
+		return nil, ErrWrongType
+	}
+
+	return val.object.(*graph), nil
+}
+
+func graphPropsResponse(props []graphProp) []byte {
+	pairs := make([][]byte, len(props))
+	for i, p := range props {
+		pairs[i] = arrayResponse(bulkStrResponse([]byte(p.key)), graphValueResponse(p.val))
+	}
+
+	return arrayResponse(pairs...)
+}
+
+// graphValueResponse encodes value of query result. Nodes and edges are arrays of [name, value] pairs
+func graphValueResponse(v any) []byte {
+	switch v := v.(type) {
+	case nil:
+		return []byte(NullResponse)
+	case int64:
+		return intResponse(v)
+	case float64:
+		return bulkStrResponse([]byte(strconv.FormatFloat(v, 'f', -1, 64)))
+	case string:
+		return bulkStrResponse([]byte(v))
+	case bool:
+		return bulkStrResponse([]byte(strconv.FormatBool(v)))
+	case []any:
+		elems := make([][]byte, len(v))
+		for i, el := range v {
+			elems[i] = graphValueResponse(el)
+		}
+		return arrayResponse(elems...)
+	case map[string]any:
+		var elems [][]byte
+		for _, k := range slices.Sorted(maps.Keys(v)) {
+			elems = append(elems, bulkStrResponse([]byte(k)), graphValueResponse(v[k]))
+		}
+		return arrayResponse(elems...)
+	case *graphNode:
+		labels := make([][]byte, len(v.labels))
+		for i, l := range v.labels {
+			labels[i] = []byte(l)
+		}
+		return arrayResponse(
+			arrayResponse(bulkStrResponse([]byte("id")), intResponse(v.id)),
+			arrayResponse(bulkStrResponse([]byte("labels")), bulkStrArrayResponse(labels...)),
+			arrayResponse(bulkStrResponse([]byte("properties")), graphPropsResponse(v.props)),
+		)
+	case *graphEdge:
+		return arrayResponse(
+			arrayResponse(bulkStrResponse([]byte("id")), intResponse(v.id)),
+			arrayResponse(bulkStrResponse([]byte("type")), bulkStrResponse([]byte(v.typ))),
+			arrayResponse(bulkStrResponse([]byte("src_node")), intResponse(v.src.id)),
+			arrayResponse(bulkStrResponse([]byte("dest_node")), intResponse(v.dst.id)),
+			arrayResponse(bulkStrResponse([]byte("properties")), graphPropsResponse(v.props)),
+		)
+	}
+
+	return []byte(NullResponse)
+}
+
+func graphStatsResponse(s cyStats, elapsed time.Duration) []byte {
+	var stats [][]byte
+	for _, c := range []struct {
+		name string
+		n    int
+	}{
+		{"Labels added", s.labelsAdded},
+		{"Nodes created", s.nodesCreated},
+		{"Nodes deleted", s.nodesDeleted},
+		{"Properties set", s.propsSet},
+		{"Relationships created", s.relsCreated},
+		{"Relationships deleted", s.relsDeleted},
+		{"Indices created", s.indicesCreated},
+	} {
+		if c.n > 0 {
+			stats = append(stats, []byte(c.name+": "+strconv.Itoa(c.n)))
+		}
+	}
+
+	stats = append(stats, fmt.Appendf(nil, "Query internal execution time: %.6f milliseconds", float64(elapsed.Nanoseconds())/1e6))
+
+	return bulkStrArrayResponse(stats...)
+}
+
+// GRAPH.QUERY key query
+func graphQueryHandler(args []*KvsValue) ([]byte, error) {
+	start := time.Now()
+	key := argToString(args[0])
+
+	q, err := parseCypher(argToString(args[1]))
+	if err != nil {
+		return nil, err
+	}
+
+	g, err := lookupGraph(key, q.isWrite())
+	if err != nil {
+		return nil, err
+	}
+	if g == nil {
+		g = newGraph()
+	}
+
+	res, err := runCypher(g, q)
+	if q.isWrite() {
+		signalModifiedKey(key, notifyModule, "graph.query")
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	stats := graphStatsResponse(res.stats, time.Since(start))
+
+	last, ok := q.clauses[len(q.clauses)-1].(*cyProjection)
+	if !ok || !last.final {
+		return arrayResponse(stats), nil
+	}
+
+	header := make([][]byte, len(res.columns))
+	for i, col := range res.columns {
+		header[i] = []byte(col)
+	}
+
+	rows := make([][]byte, len(res.rows))
+	for i, row := range res.rows {
+		vals := make([][]byte, len(row))
+		for j, v := range row {
+			vals[j] = graphValueResponse(v)
+		}
+		rows[i] = arrayResponse(vals...)
+	}
+
+	return arrayResponse(bulkStrArrayResponse(header...), arrayResponse(rows...), stats), nil
+}
+
+// GRAPH.EXPLAIN key query
+func graphExplainHandler(args []*KvsValue) ([]byte, error) {
+	q, err := parseCypher(argToString(args[1]))
+	if err != nil {
+		return nil, err
+	}
+
+	g, err := lookupGraph(argToString(args[0]), false)
+	if err != nil {
+		return nil, err
+	}
+	if g == nil {
+		g = newGraph()
+	}
+
+	plan := explainCypher(g, q)
+	ops := make([][]byte, len(plan))
+	for i, op := range plan {
+		ops[i] = []byte(op)
+	}
+
+	return bulkStrArrayResponse(ops...), nil
+}
+
+// GRAPH.DELETE key
+func graphDeleteHandler(args []*KvsValue) ([]byte, error) {
+	key := argToString(args[0])
+
+	g, err := lookupGraph(key, false)
+	if err != nil {
+		return nil, err
+	}
+	if g == nil {
+		return nil, ErrGraphEmptyKey
+	}
+
+	removeKey(key)
+	signalModifiedKey(key, notifyGeneric, "del")
+
+	return []byte(OkResponse), nil
+}
@@ -0,0 +1,74 @@
+package main
+
+import "testing"
+
+func TestGraphIndexes(t *testing.T) {
+	g := newGraph()
+	alice := g.addNode([]string{"Person"}, []graphProp{{"name", "Alice"}, {"age", int64(30)}})
+	bob := g.addNode([]string{"Person"}, []graphProp{{"name", "Bob"}})
+	g.addNode([]string{"City"}, []graphProp{{"name", "Alice"}})
+
+	if !g.createIndex("Person", "name") || g.createIndex("Person", "name") {
+		t.Fatal("createIndex must succeed only once")
+	}
+
+	lookup := func(label, prop string, val any) []*graphNode {
+		t.Helper()
+		nodes, ok := g.lookupIndex(label, prop, val)
+		if !ok {
+			t.Fatalf("no index on %s(%s)", label, prop)
+		}
+		return nodes
+	}
+
+	if nodes := lookup("Person", "name", "Alice"); len(nodes) != 1 || nodes[0] != alice {
+		t.Errorf("lookup of Alice returned %v", nodes)
+	}
+
+	g.setNodeProp(bob, "name", "Robert")
+	if nodes := lookup("Person", "name", "Bob"); len(nodes) != 0 {
+		t.Errorf("old value is still indexed: %v", nodes)
+	}
+	if nodes := lookup("Person", "name", "Robert"); len(nodes) != 1 || nodes[0] != bob {
+		t.Errorf("new value is not indexed: %v", nodes)
+	}
+
+	g.createIndex("Person", "age")
+	if nodes := lookup("Person", "age", 30.0); len(nodes) != 1 || nodes[0] != alice {
+		t.Errorf("float lookup of integer property returned %v", nodes)
+	}
+
+	city := g.nodes[2]
+	g.addLabel(city, "Person")
+	if nodes := lookup("Person", "name", "Alice"); len(nodes) != 2 {
+		t.Errorf("node with added label is not indexed: %v", nodes)
+	}
+
+	g.deleteNode(alice)
+	if nodes := lookup("Person", "name", "Alice"); len(nodes) != 1 || nodes[0] != city {
+		t.Errorf("deleted node is still indexed: %v", nodes)
+	}
+	if _, ok := g.labels["Person"][alice.id]; ok {
+		t.Error("deleted node is still in label index")
+	}
+}
+
+func TestGraphDeleteNode(t *testing.T) {
+	g := newGraph()
+	a := g.addNode(nil, nil)
+	b := g.addNode(nil, nil)
+	c := g.addNode(nil, nil)
+	g.addEdge("R", a, b, nil)
+	g.addEdge("R", b, c, nil)
+	g.addEdge("R", b, b, nil)
+
+	if removed := g.deleteNode(b); removed != 3 {
+		t.Errorf("deleteNode removed %d edges, expected 3", removed)
+	}
+	if removed := g.deleteNode(b); removed != -1 {
+		t.Errorf("second deleteNode returned %d, expected -1", removed)
+	}
+	if len(g.edges) != 0 || len(a.out) != 0 || len(c.in) != 0 {
+		t.Errorf("edges of deleted node are left: %d edges, %d out of a, %d in of c", len(g.edges), len(a.out), len(c.in))
+	}
+}
@@ -0,0 +1,175 @@
+package main
+
+import (
+	"maps"
+	"slices"
+)
+
+// lookupHash returns fields of hash stored at key. If key doesn't exist and create is true, new hash is stored,
+// otherwise nil is returned
+func lookupHash(key string, create bool) (map[string]string, error) {
+	val, ok := lookupKey(key)
+	if !ok {
+		if !create {
+			return nil, nil
+		}
+
+		h := make(map[string]string)
+		storeKey(key, &KvsValue{dtype: HashDtype, object: h})
+
+		return h, nil
+	}
+
+	if val.dtype != HashDtype {
+		return nil, ErrWrongType
+	}
+
+	return val.object.(map[string]string), nil
+}
+
+// HSET key field value [field value ...]
+func hsetHandler(args []*KvsValue) ([]byte, error) {
+	if len(args)%2 == 0 {
+		return nil, wrongArgsCountErr("HSET")
+	}
+
+	key := argToString(args[0])
+
+	h, err := lookupHash(key, true)
+	if err != nil {
+		return nil, err
+	}
+
+	var added int64
+	for i := 1; i < len(args); i += 2 {
+		field := argToString(args[i])
+		if _, ok := h[field]; !ok {
+			added++
+		}
+		h[field] = argToString(args[i+1])
+	}
+
+	signalModifiedKey(key, notifyHash, "hset")
+
+	return intResponse(added), nil
+}
+
+// HGET key field
+func hgetHandler(args []*KvsValue) ([]byte, error) {
+	h, err := lookupHash(argToString(args[0]), false)
+	if err != nil {
+		return nil, err
+	}
+
+	v, ok := h[argToString(args[1])]
+	if !ok {
+		return []byte(NullResponse), nil
+	}
+
+	return bulkStrResponse([]byte(v)), nil
+}
+
+// HMGET key field [field ...]
+func hmgetHandler(args []*KvsValue) ([]byte, error) {
+	h, err := lookupHash(argToString(args[0]), false)
+	if err != nil {
+		return nil, err
+	}
+
+	res := make([][]byte, len(args)-1)
+	for i, arg := range args[1:] {
+		if v, ok := h[argToString(arg)]; ok {
+			res[i] = []byte(v)
+		}
+	}
+
+	return bulkStrArrayResponse(res...), nil
+}
+
+// HDEL key field [field ...]
+func hdelHandler(args []*KvsValue) ([]byte, error) {
+	key := argToString(args[0])
+
+	h, err := lookupHash(key, false)
+	if err != nil || h == nil {
+		return intResponse(0), err
+	}
+
+	var removed int64
+	for _, arg := range args[1:] {
+		field := argToString(arg)
+		if _, ok := h[field]; ok {
+			delete(h, field)
+			removed++
+		}
+	}
+
+	if removed > 0 {
+		signalModifiedKey(key, notifyHash, "hdel")
+	}
+	if len(h) == 0 {
+		removeKey(key)
+		signalModifiedKey(key, notifyGeneric, "del")
+	}
+
+	return intResponse(removed), nil
+}
+
+// HGETALL key
+func hgetallHandler(args []*KvsValue) ([]byte, error) {
+	h, err := lookupHash(argToString(args[0]), false)
+	if err != nil {
+		return nil, err
+	}
+
+	res := make([][]byte, 0, len(h)*2)
+	for _, field := range slices.Sorted(maps.Keys(h)) {
+		res = append(res, []byte(field), []byte(h[field]))
+	}
+
+	return bulkStrArrayResponse(res...), nil
+}
+
+// HLEN key
+func hlenHandler(args []*KvsValue) ([]byte, error) {
+	h, err := lookupHash(argToString(args[0]), false)
+	if err != nil {
+		return nil, err
+	}
+
+	return intResponse(int64(len(h))), nil
+}
+
+// HEXISTS key field
+func hexistsHandler(args []*KvsValue) ([]byte, error) {
+	h, err := lookupHash(argToString(args[0]), false)
+	if err != nil {
+		return nil, err
+	}
+
+	_, ok := h[argToString(args[1])]
+
+	return boolIntResponse(ok), nil
+}
+
+func dumpHash(b []byte, h map[string]string) []byte {
+	b = appendU32(b, len(h))
+	for field, value := range h {
+		b = appendStr(b, field)
+		b = appendStr(b, value)
+	}
+
+	return b
+}
+
+func restoreHash(r *dumpReader) map[string]string {
+	n := r.count(8)
+	h := make(map[string]string, n)
+	for range n {
+		field := r.str()
+		h[field] = r.str()
+	}
+	r.check(len(h) == n)
+
+	return h
+}
@@ -0,0 +1,549 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"math"
+	"math/bits"
+)
+
+// HyperLogLog values are stored as plain bulk strings using the exact byte layout of Redis,
+// so they can be moved between systems as is:
+//
+//	+------+---+-----+----------+
+//	| HYLL | E | N/U | Cardin.  |
+//	+------+---+-----+----------+
+//
+// 4 bytes magic, 1 byte encoding (dense or sparse), 3 unused bytes and 8 bytes of cached cardinality
+// in little endian. Most significant bit of the last cardinality byte marks the cache as stale.
+// Dense encoding holds 16384 registers of 6 bits each. Sparse encoding is a sequence of opcodes
+// describing runs of registers: ZERO (00xxxxxx) and XZERO (01xxxxxx yyyyyyyy) for runs of zero
+// registers and VAL (1vvvvvxx) for up to 4 registers with the same value up to 32.
+const (
+	hllP         = 14
+	hllQ         = 64 - hllP
+	hllRegisters = 1 << hllP
+	hllPMask     = hllRegisters - 1
+	hllBits      = 6
+	hllRegMax    = (1 << hllBits) - 1
+	hllHdrSize   = 16
+	hllDenseSize = hllHdrSize + (hllRegisters*hllBits+7)/8
+	hllDense     = 0
+	hllSparse    = 1
+	hllAlphaInf  = 0.721347520444481703680
+
+	hllSparseMaxBytes  = 3000
+	hllSparseValMaxVal = 32
+	hllSparseValMaxLen = 4
+	hllSparseZeroMax   = 64
+	hllSparseXZeroMax  = 16384
+
+	hllHashSeed = 0xadc83b19
+)
+
+var hllMagic = []byte("HYLL")
+
+// MurmurHash2, 64 bit version by Austin Appleby, the one Redis uses for HyperLogLog
+func murmurHash64A(key []byte, seed uint64) uint64 {
+	const m = 0xc6a4a7935bd1e995
+	const r = 47
+
+	h := seed ^ (uint64(len(key)) * m)
+
+	for len(key) >= 8 {
+		k := binary.LittleEndian.Uint64(key)
+		k *= m
+		k ^= k >> r
+		k *= m
+
+		h ^= k
+		h *= m
+
+		key = key[8:]
+	}
+
+	switch len(key) {
+	case 7:
+		h ^= uint64(key[6]) << 48
+		fallthrough
+	case 6:
+		h ^= uint64(key[5]) << 40
+		fallthrough
+	case 5:
+		h ^= uint64(key[4]) << 32
+		fallthrough
+	case 4:
+		h ^= uint64(key[3]) << 24
+		fallthrough
+	case 3:
+		h ^= uint64(key[2]) << 16
+		fallthrough
+	case 2:
+		h ^= uint64(key[1]) << 8
+		fallthrough
+	case 1:
+		h ^= uint64(key[0])
+		h *= m
+	}
+
+	h ^= h >> r
+	h *= m
+	h ^= h >> r
+
+	return h
+}
+
+// hllPatLen returns register index for element and length of "000..1" pattern in the rest of its hash
+func hllPatLen(elem []byte) (index int, count uint8) {
+	hash := murmurHash64A(elem, hllHashSeed)
+	index = int(hash & hllPMask)
+	hash >>= hllP
+	hash |= 1 << hllQ // guarantees the loop to end when all the bits are zero
+
+	return index, uint8(bits.TrailingZeros64(hash) + 1)
+}
+
+func newHLL() []byte {
+	// new HLL starts as sparse with all registers set to zero, that's exactly one XZERO opcode
+	hll := make([]byte, hllHdrSize, hllHdrSize+2)
+	copy(hll, hllMagic)
+	hll[4] = hllSparse
+
+	return appendSparseXZero(hll, hllRegisters)
+}
+
+func isHLL(val []byte) bool {
+	if len(val) < hllHdrSize || !bytes.Equal(val[:4], hllMagic) {
+		return false
+	}
+
+	switch val[4] {
+	case hllDense:
+		return len(val) == hllDenseSize
+	case hllSparse:
+		return true
+	}
+
+	return false
+}
+
+func hllInvalidateCache(hll []byte) {
+	hll[15] |= 1 << 7
+}
+
+func hllCachedCard(hll []byte) (uint64, bool) {
+	if hll[15]&(1<<7) != 0 {
+		return 0, false
+	}
+
+	return binary.LittleEndian.Uint64(hll[8:16]), true
+}
+
+func hllSetCachedCard(hll []byte, card uint64) {
+	binary.LittleEndian.PutUint64(hll[8:16], card)
+}
+
+// ================================ dense ========================================
+
+func hllDenseGet(regs []byte, i int) uint8 {
+	pos := i * hllBits
+	b := pos / 8
+	fb := uint(pos & 7)
+
+	v := uint16(regs[b])
+	if b+1 < len(regs) {
+		v |= uint16(regs[b+1]) << 8
+	}
+
+	return uint8(v>>fb) & hllRegMax
+}
+
+func hllDenseSet(regs []byte, i int, val uint8) {
+	pos := i * hllBits
+	b := pos / 8
+	fb := uint(pos & 7)
+
+	v := uint16(regs[b])
+	if b+1 < len(regs) {
+		v |= uint16(regs[b+1]) << 8
+	}
+
+	v &^= hllRegMax << fb
+	v |= uint16(val) << fb
+
+	regs[b] = byte(v)
+	if b+1 < len(regs) {
+		regs[b+1] = byte(v >> 8)
+	}
+}
+
+// ================================ sparse ========================================
+
+func appendSparseZero(buf []byte, runLen int) []byte {
+	return append(buf, byte(runLen-1))
+}
+
+func appendSparseXZero(buf []byte, runLen int) []byte {
+	runLen--
+	return append(buf, 0x40|byte(runLen>>8), byte(runLen))
+}
+
+func appendSparseVal(buf []byte, val uint8, runLen int) []byte {
+	return append(buf, 0x80|(val-1)<<2|byte(runLen-1))
+}
+
+// appendSparseZeros encodes run of zero registers of any length
+func appendSparseZeros(buf []byte, runLen int) []byte {
+	for runLen > 0 {
+		n := min(runLen, hllSparseXZeroMax)
+		if n <= hllSparseZeroMax {
+			buf = appendSparseZero(buf, n)
+		} else {
+			buf = appendSparseXZero(buf, n)
+		}
+		runLen -= n
+	}
+
+	return buf
+}
+
+type hllSparseOp struct {
+	val    uint8 // 0 for ZERO and XZERO
+	runLen int
+	size   int // bytes taken by opcode
+}
+
+func hllDecodeSparseOp(data []byte, pos int) (op hllSparseOp, ok bool) {
+	b := data[pos]
+
+	switch {
+	case b&0xc0 == 0x00:
+		return hllSparseOp{runLen: int(b&0x3f) + 1, size: 1}, true
+	case b&0xc0 == 0x40:
+		if pos+1 >= len(data) {
+			return op, false
+		}
+		return hllSparseOp{runLen: (int(b&0x3f)<<8 | int(data[pos+1])) + 1, size: 2}, true
+	}
+
+	return hllSparseOp{val: (b>>2)&0x1f + 1, runLen: int(b&0x3) + 1, size: 1}, true
+}
+
+// hllSparseWalk calls fn for every opcode with index of its first register. Returns false if encoding is corrupted
+func hllSparseWalk(hll []byte, fn func(pos, first int, op hllSparseOp) bool) bool {
+	data := hll[hllHdrSize:]
+	idx := 0
+
+	for pos := 0; pos < len(data); {
+		op, ok := hllDecodeSparseOp(data, pos)
+		if !ok || idx+op.runLen > hllRegisters {
+			return false
+		}
+
+		if !fn(pos, idx, op) {
+			return true
+		}
+
+		idx += op.runLen
+		pos += op.size
+	}
+
+	return idx == hllRegisters
+}
+
+// hllSparseSet sets register index to count if it's greater than current value.
+// Returns updated HLL (it may grow or get promoted to dense encoding), whether it was changed and whether it's valid
+func hllSparseSet(hll []byte, index int, count uint8) (res []byte, changed bool, ok bool) {
+	if count > hllSparseValMaxVal {
+		return hllPromoteAndSet(hll, index, count)
+	}
+
+	var opPos, opFirst int
+	var op hllSparseOp
+
+	valid := hllSparseWalk(hll, func(pos, first int, cur hllSparseOp) bool {
+		if index >= first+cur.runLen {
+			return true
+		}
+
+		opPos, opFirst, op = pos, first, cur
+		return false
+	})
+	if !valid || op.runLen == 0 {
+		return hll, false, false
+	}
+
+	if op.val >= count {
+		return hll, false, true
+	}
+
+	// replace found opcode with up to 3 opcodes: the part before index, index itself and the part after
+	var repl []byte
+	appendRun := func(runLen int) {
+		if runLen == 0 {
+			return
+		}
+		if op.val == 0 {
+			repl = appendSparseZeros(repl, runLen)
+		} else {
+			repl = appendSparseVal(repl, op.val, runLen)
+		}
+	}
+
+	appendRun(index - opFirst)
+	repl = appendSparseVal(repl, count, 1)
+	appendRun(opFirst + op.runLen - index - 1)
+
+	start := hllHdrSize + opPos
+	res = make([]byte, 0, len(hll)+len(repl)-op.size)
+	res = append(res, hll[:start]...)
+	res = append(res, repl...)
+	res = append(res, hll[start+op.size:]...)
+
+	res = hllSparseMergeVals(res)
+	if len(res) > hllSparseMaxBytes {
+		res, _ = hllSparseToDense(res)
+	}
+
+	hllInvalidateCache(res)
+
+	return res, true, true
+}
+
+// joins adjacent VAL opcodes with the same value while run length allows it
+func hllSparseMergeVals(hll []byte) []byte {
+	out := append([]byte(nil), hll[:hllHdrSize]...)
+
+	var pendingVal uint8
+	pendingLen := 0
+
+	flush := func() {
+		if pendingLen > 0 {
+			out = appendSparseVal(out, pendingVal, pendingLen)
+			pendingLen = 0
+		}
+	}
+
+	hllSparseWalk(hll, func(pos, _ int, op hllSparseOp) bool {
+		if op.val == 0 {
+			flush()
+			out = append(out, hll[hllHdrSize+pos:hllHdrSize+pos+op.size]...)
+			return true
+		}
+
+		if pendingLen > 0 && pendingVal == op.val && pendingLen+op.runLen <= hllSparseValMaxLen {
+			pendingLen += op.runLen
+			return true
+		}
+
+		flush()
+		pendingVal, pendingLen = op.val, op.runLen
+		return true
+	})
+	flush()
+
+	return out
+}
+
+func hllSparseToDense(hll []byte) ([]byte, bool) {
+	dense := make([]byte, hllDenseSize)
+	copy(dense, hll[:hllHdrSize])
+	dense[4] = hllDense
+	regs := dense[hllHdrSize:]
+
+	valid := hllSparseWalk(hll, func(_, first int, op hllSparseOp) bool {
+		if op.val != 0 {
+			for i := first; i < first+op.runLen; i++ {
+				hllDenseSet(regs, i, op.val)
+			}
+		}
+		return true
+	})
+
+	return dense, valid
+}
+
+func hllPromoteAndSet(hll []byte, index int, count uint8) ([]byte, bool, bool) {
+	dense, ok := hllSparseToDense(hll)
+	if !ok {
+		return hll, false, false
+	}
+
+	changed := hllSet(dense, index, count)
+
+	return dense, changed, true
+}
+
+// ================================ common ========================================
+
+// hllSet works only for dense HLL, sparse one must go through hllSparseSet since it can be reallocated
+func hllSet(hll []byte, index int, count uint8) bool {
+	regs := hll[hllHdrSize:]
+	if hllDenseGet(regs, index) >= count {
+		return false
+	}
+
+	hllDenseSet(regs, index, count)
+	hllInvalidateCache(hll)
+
+	return true
+}
+
+// hllAdd adds element to HLL and returns the (possibly reallocated) HLL and whether any register changed
+func hllAdd(hll []byte, elem []byte) (res []byte, changed bool, ok bool) {
+	index, count := hllPatLen(elem)
+
+	if hll[4] == hllDense {
+		return hll, hllSet(hll, index, count), true
+	}
+
+	return hllSparseSet(hll, index, count)
+}
+
+// hllMaxRegisters merges registers of hll into regs taking maximum of each pair
+func hllMaxRegisters(regs *[hllRegisters]uint8, hll []byte) bool {
+	if hll[4] == hllDense {
+		dense := hll[hllHdrSize:]
+		for i := range regs {
+			regs[i] = max(regs[i], hllDenseGet(dense, i))
+		}
+		return true
+	}
+
+	return hllSparseWalk(hll, func(_, first int, op hllSparseOp) bool {
+		if op.val != 0 {
+			for i := first; i < first+op.runLen; i++ {
+				regs[i] = max(regs[i], op.val)
+			}
+		}
+		return true
+	})
+}
+
+func hllTau(x float64) float64 {
+	if x == 0 || x == 1 {
+		return 0
+	}
+
+	y := 1.0
+	z := 1 - x
+
+	for {
+		x = math.Sqrt(x)
+		zPrime := z
+		y *= 0.5
+		z -= math.Pow(1-x, 2) * y
+
+		if zPrime == z {
+			return z / 3
+		}
+	}
+}
+
+func hllSigma(x float64) float64 {
+	if x == 1 {
+		return math.Inf(1)
+	}
+
+	y := 1.0
+	z := x
+
+	for {
+		x *= x
+		zPrime := z
+		z += x * y
+		y += y
+
+		if zPrime == z {
+			return z
+		}
+	}
+}
+
+// hllEstimate implements the improved estimator from "New cardinality estimation algorithms
+// for HyperLogLog sketches" by Otmar Ertl, same as Redis does
+func hllEstimate(regs *[hllRegisters]uint8) uint64 {
+	var histo [64]int
+	for _, r := range regs {
+		histo[r]++
+	}
+
+	m := float64(hllRegisters)
+	z := m * hllTau((m-float64(histo[hllQ+1]))/m)
+
+	for j := hllQ; j >= 1; j-- {
+		z += float64(histo[j])
+		z *= 0.5
+	}
+
+	z += m * hllSigma(float64(histo[0])/m)
+
+	return uint64(math.Round(hllAlphaInf * m * m / z))
+}
+
+// hllCount returns cardinality of a single HLL, using and refreshing its cached value
+func hllCount(hll []byte) (uint64, bool) {
+	if card, ok := hllCachedCard(hll); ok {
+		return card, true
+	}
+
+	var regs [hllRegisters]uint8
+	if !hllMaxRegisters(&regs, hll) {
+		return 0, false
+	}
+
+	card := hllEstimate(&regs)
+	hllSetCachedCard(hll, card)
+
+	return card, true
+}
+
+// hllFromRegisters encodes registers as sparse HLL if possible, dense otherwise
+func hllFromRegisters(regs *[hllRegisters]uint8, dense bool) []byte {
+	if !dense {
+		hll := make([]byte, hllHdrSize)
+		copy(hll, hllMagic)
+		hll[4] = hllSparse
+
+		for i := 0; i < hllRegisters && !dense; {
+			val := regs[i]
+			runLen := 1
+			maxRun := hllSparseXZeroMax
+			if val != 0 {
+				maxRun = hllSparseValMaxLen
+			}
+			for i+runLen < hllRegisters && regs[i+runLen] == val && runLen < maxRun {
+				runLen++
+			}
+
+			switch {
+			case val == 0:
+				hll = appendSparseZeros(hll, runLen)
+			case val > hllSparseValMaxVal:
+				dense = true
+			default:
+				hll = appendSparseVal(hll, val, runLen)
+			}
+
+			i += runLen
+		}
+
+		if !dense && len(hll) <= hllSparseMaxBytes {
+			hllInvalidateCache(hll)
+			return hll
+		}
+	}
+
+	hll := make([]byte, hllDenseSize)
+	copy(hll, hllMagic)
+	hll[4] = hllDense
+
+	for i, r := range regs {
+		if r != 0 {
+			hllDenseSet(hll[hllHdrSize:], i, r)
+		}
+	}
+	hllInvalidateCache(hll)
+
+	return hll
+}
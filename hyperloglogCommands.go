@@ -0,0 +1,134 @@
+package main
+
+// lookupHLL returns HLL stored at key or nil if key doesn't exist
+func lookupHLL(key string) ([]byte, error) {
+	val, ok := lookupKey(key)
+	if !ok {
+		return nil, nil
+	}
+
+	if val.dtype != BulkStrSymbol || !isHLL(val.value) {
+		return nil, ErrHLLWrongType
+	}
+
+	return val.value, nil
+}
+
+// PFADD key [element [element ...]]
+func pfaddHandler(args []*KvsValue) ([]byte, error) {
+	key := argToString(args[0])
+
+	val, exists := lookupKey(key)
+	if exists && (val.dtype != BulkStrSymbol || !isHLL(val.value)) {
+		return nil, ErrHLLWrongType
+	}
+
+	var hll []byte
+	updated := false
+	if exists {
+		hll = val.value
+	} else {
+		hll = newHLL()
+		updated = true
+	}
+
+	for _, arg := range args[1:] {
+		var changed, ok bool
+
+		hll, changed, ok = hllAdd(hll, []byte(argToString(arg)))
+		if !ok {
+			return nil, ErrHLLCorrupted
+		}
+
+		updated = updated || changed
+	}
+
+	// existing value is changed in place, so it keeps its time to live
+	if exists {
+		val.value = hll
+	} else {
+		storeKey(key, &KvsValue{dtype: BulkStrSymbol, value: hll})
+	}
+
+	if updated {
+		signalModifiedKey(key, notifyString, "pfadd")
+		return intResponse(1), nil
+	}
+
+	return intResponse(0), nil
+}
+
+// PFCOUNT key [key ...]. Cardinality of a single key is cached in its value in place, that isn't counted as
+// a change, as the HLL itself stays the same. The value is serialized for a running snapshot before and handed
+// to the storage engine after
+func pfcountHandler(args []*KvsValue) ([]byte, error) {
+	if len(args) == 1 {
+		key := argToString(args[0])
+		val, ok := lookupKey(key)
+		if !ok {
+			return intResponse(0), nil
+		}
+		if val.dtype != BulkStrSymbol || !isHLL(val.value) {
+			return nil, ErrHLLWrongType
+		}
+
+		if _, cached := hllCachedCard(val.value); !cached {
+			preserveValue(key, val)
+			touchValue(key, val)
+		}
+
+		card, ok := hllCount(val.value)
+		if !ok {
+			return nil, ErrHLLCorrupted
+		}
+
+		return intResponse(int64(card)), nil
+	}
+
+	// for several keys registers are merged on the fly without touching stored values
+	var regs [hllRegisters]uint8
+	for _, arg := range args {
+		hll, err := lookupHLL(argToString(arg))
+		if err != nil {
+			return nil, err
+		}
+
+		if hll != nil && !hllMaxRegisters(&regs, hll) {
+			return nil, ErrHLLCorrupted
+		}
+	}
+
+	return intResponse(int64(hllEstimate(&regs))), nil
+}
+
+// PFMERGE destkey [sourcekey [sourcekey ...]]
+func pfmergeHandler(args []*KvsValue) ([]byte, error) {
+	var regs [hllRegisters]uint8
+	dense := false
+
+	// destination key takes part in merge too
+	for _, arg := range args {
+		hll, err := lookupHLL(argToString(arg))
+		if err != nil {
+			return nil, err
+		}
+
+		if hll == nil {
+			continue
+		}
+
+		if hll[4] == hllDense {
+			dense = true
+		}
+
+		if !hllMaxRegisters(&regs, hll) {
+			return nil, ErrHLLCorrupted
+		}
+	}
+
+	destKey := argToString(args[0])
+	storeKey(destKey, &KvsValue{dtype: BulkStrSymbol, value: hllFromRegisters(&regs, dense)})
+	signalModifiedKey(destKey, notifyString, "pfadd")
+
+	return []byte(OkResponse), nil
+}
@@ -0,0 +1,167 @@
+package main
+
+import (
+	"math"
+	"strconv"
+	"testing"
+)
+
+func initMockHLL(n int, prefix string) []byte {
+	hll := newHLL()
+	for i := range n {
+		hll, _, _ = hllAdd(hll, []byte(prefix+strconv.Itoa(i)))
+	}
+
+	return hll
+}
+
+func TestNewHLLIsEmptySparse(t *testing.T) {
+	hll := newHLL()
+
+	card, ok := hllCount(hll)
+
+	if !isHLL(hll) || hll[4] != hllSparse || card != 0 || !ok {
+		t.Errorf("newHLL() = %v with cardinality %v, expected empty sparse HLL", hll, card)
+	}
+}
+
+func TestHLLAddReportsChange(t *testing.T) {
+	hll := newHLL()
+
+	hll, changed, _ := hllAdd(hll, []byte("foo"))
+	if !changed {
+		t.Errorf("hllAdd(foo) on empty HLL didn't report change")
+	}
+
+	_, changed, _ = hllAdd(hll, []byte("foo"))
+	if changed {
+		t.Errorf("hllAdd(foo) second time reported change")
+	}
+}
+
+func TestHLLSmallCardinalityExact(t *testing.T) {
+	hll := initMockHLL(7, "el")
+
+	card, _ := hllCount(hll)
+
+	if card != 7 {
+		t.Errorf("hllCount(<7 elements>) = %v, expected: %v", card, 7)
+	}
+}
+
+func TestHLLPromotesToDense(t *testing.T) {
+	hll := initMockHLL(5000, "el")
+
+	if hll[4] != hllDense || len(hll) != hllDenseSize {
+		t.Errorf("HLL with 5000 elements has encoding %v and size %v, expected dense of size %v", hll[4], len(hll), hllDenseSize)
+	}
+}
+
+func TestHLLStandardError(t *testing.T) {
+	for _, n := range []int{1000, 20000, 200000} {
+		hll := initMockHLL(n, "item:")
+
+		card, ok := hllCount(hll)
+		relErr := math.Abs(float64(card)-float64(n)) / float64(n)
+
+		// 0.81% is standard error, so staying within 3 of them is expected
+		if !ok || relErr > 0.0243 {
+			t.Errorf("hllCount(<%v elements>) = %v, relative error %.4f is too big", n, card, relErr)
+		}
+	}
+}
+
+func TestHLLSparseAndDenseAgree(t *testing.T) {
+	sparse := initMockHLL(300, "el")
+
+	var regs [hllRegisters]uint8
+	hllMaxRegisters(&regs, sparse)
+	dense := hllFromRegisters(&regs, true)
+
+	sparseCard, _ := hllCount(sparse)
+	denseCard, _ := hllCount(dense)
+
+	if sparse[4] != hllSparse || sparseCard != denseCard {
+		t.Errorf("sparse cardinality %v differs from dense one %v", sparseCard, denseCard)
+	}
+}
+
+func TestHLLMergeRegisters(t *testing.T) {
+	a := initMockHLL(10000, "a")
+	b := initMockHLL(10000, "b")
+
+	var regs [hllRegisters]uint8
+	hllMaxRegisters(&regs, a)
+	hllMaxRegisters(&regs, b)
+
+	card := hllEstimate(&regs)
+	relErr := math.Abs(float64(card)-20000) / 20000
+
+	if relErr > 0.0243 {
+		t.Errorf("estimate of merged HLLs = %v, expected around %v", card, 20000)
+	}
+}
+
+func TestHLLCachedCardinality(t *testing.T) {
+	hll := initMockHLL(100, "el")
+
+	if _, ok := hllCachedCard(hll); ok {
+		t.Errorf("cardinality cache is valid right after adding elements")
+	}
+
+	card, _ := hllCount(hll)
+	cached, ok := hllCachedCard(hll)
+
+	if !ok || cached != card {
+		t.Errorf("cached cardinality = %v, valid: %v, expected: %v", cached, ok, card)
+	}
+}
+
+// PFADD changes the value in place and keeps its time to live, PFCOUNT caching cardinality in the value
+// serializes it for a running snapshot first and doesn't count as a change
+func TestHLLCommandsChangeValueInPlace(t *testing.T) {
+	initStorage()
+	var tx txState
+
+	dispatchTest(t, &tx, "PFADD", "h", "a", "b")
+	expireAt := nowMs() + 100000
+	kvs.mu.Lock()
+	val, _ := kvs.storage.Get("h")
+	setExpire("h", val, expireAt)
+	kvs.mu.Unlock()
+
+	if got := dispatchTest(t, &tx, "PFADD", "h", "c"); got != ":1\r\n" {
+		t.Fatalf("PFADD: %q", got)
+	}
+	kvs.mu.Lock()
+	val, _ = kvs.storage.Get("h")
+	kvs.mu.Unlock()
+	if val.expireAt != expireAt {
+		t.Error("PFADD has dropped time to live")
+	}
+
+	kvs.mu.Lock()
+	cur := beginSnapshotCursor(appendSnapshotKey)
+	kvs.mu.Unlock()
+	defer func() {
+		kvs.mu.Lock()
+		cur.end()
+		kvs.mu.Unlock()
+	}()
+
+	kvs.mu.Lock()
+	dirty := kvs.dirty
+	kvs.mu.Unlock()
+	if got := dispatchTest(t, &tx, "PFCOUNT", "h"); got != ":3\r\n" {
+		t.Fatalf("PFCOUNT: %q", got)
+	}
+	kvs.mu.Lock()
+	preserved, changed := len(cur.out) > 0, kvs.dirty != dirty
+	kvs.mu.Unlock()
+	if !preserved {
+		t.Error("PFCOUNT has changed the value before the snapshot got it")
+	}
+	if changed {
+		t.Error("PFCOUNT is counted as a change")
+	}
+}
@@ -0,0 +1,554 @@
+package main
+
+import (
+	"math"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// JSON documents are kept as a tree of jsonNode, so commands can change a part of document in place
+// without parsing and serializing the whole thing. Objects keep insertion order of their keys
+const (
+	jsonNull = iota
+	jsonBool
+	jsonInt
+	jsonFloat
+	jsonString
+	jsonArray
+	jsonObject
+
+	jsonMaxDepth = 128
+)
+
+type jsonNode struct {
+	kind int
+	b    bool
+	i    int64
+	f    float64
+	str  string
+	arr  []*jsonNode
+	obj  *jsonObj
+}
+
+type jsonObj struct {
+	keys []string
+	vals map[string]*jsonNode
+}
+
+func newJSONObj() *jsonObj {
+	return &jsonObj{vals: make(map[string]*jsonNode)}
+}
+
+func (o *jsonObj) get(key string) (*jsonNode, bool) {
+	v, ok := o.vals[key]
+	return v, ok
+}
+
+func (o *jsonObj) set(key string, val *jsonNode) {
+	if _, ok := o.vals[key]; !ok {
+		o.keys = append(o.keys, key)
+	}
+	o.vals[key] = val
+}
+
+func (o *jsonObj) remove(key string) bool {
+	if _, ok := o.vals[key]; !ok {
+		return false
+	}
+
+	delete(o.vals, key)
+	for i, k := range o.keys {
+		if k == key {
+			o.keys = append(o.keys[:i], o.keys[i+1:]...)
+			break
+		}
+	}
+
+	return true
+}
+
+func (n *jsonNode) typeName() string {
+	switch n.kind {
+	case jsonBool:
+		return "boolean"
+	case jsonInt:
+		return "integer"
+	case jsonFloat:
+		return "number"
+	case jsonString:
+		return "string"
+	case jsonArray:
+		return "array"
+	case jsonObject:
+		return "object"
+	}
+
+	return "null"
+}
+
+func (n *jsonNode) isNumber() bool {
+	return n.kind == jsonInt || n.kind == jsonFloat
+}
+
+func (n *jsonNode) number() float64 {
+	if n.kind == jsonInt {
+		return float64(n.i)
+	}
+
+	return n.f
+}
+
+func (n *jsonNode) clone() *jsonNode {
+	res := *n
+
+	switch n.kind {
+	case jsonArray:
+		res.arr = make([]*jsonNode, len(n.arr))
+		for i, el := range n.arr {
+			res.arr[i] = el.clone()
+		}
+	case jsonObject:
+		res.obj = newJSONObj()
+		for _, k := range n.obj.keys {
+			res.obj.set(k, n.obj.vals[k].clone())
+		}
+	}
+
+	return &res
+}
+
+// ================================ parsing ========================================
+
+type jsonParser struct {
+	data  string
+	pos   int
+	depth int
+}
+
+func parseJSON(data string) (*jsonNode, error) {
+	p := &jsonParser{data: data}
+
+	node, err := p.parseValue()
+	if err != nil {
+		return nil, err
+	}
+
+	p.skipSpaces()
+	if p.pos != len(p.data) {
+		return nil, ErrJSONInvalid
+	}
+
+	return node, nil
+}
+
+func (p *jsonParser) skipSpaces() {
+	for p.pos < len(p.data) {
+		switch p.data[p.pos] {
+		case ' ', '\t', '\n', '\r':
+			p.pos++
+		default:
+			return
+		}
+	}
+}
+
+func (p *jsonParser) consume(literal string) bool {
+	if strings.HasPrefix(p.data[p.pos:], literal) {
+		p.pos += len(literal)
+		return true
+	}
+
+	return false
+}
+
+func (p *jsonParser) parseValue() (*jsonNode, error) {
+	p.skipSpaces()
+	if p.pos >= len(p.data) {
+		return nil, ErrJSONInvalid
+	}
+
+	switch c := p.data[p.pos]; {
+	case c == '{':
+		return p.parseObject()
+	case c == '[':
+		return p.parseArray()
+	case c == '"':
+		s, err := p.parseString()
+		if err != nil {
+			return nil, err
+		}
+		return &jsonNode{kind: jsonString, str: s}, nil
+	case c == '-' || (c >= '0' && c <= '9'):
+		return p.parseNumber()
+	case p.consume("true"):
+		return &jsonNode{kind: jsonBool, b: true}, nil
+	case p.consume("false"):
+		return &jsonNode{kind: jsonBool}, nil
+	case p.consume("null"):
+		return &jsonNode{kind: jsonNull}, nil
+	}
+
+	return nil, ErrJSONInvalid
+}
+
+func (p *jsonParser) enter() error {
+	p.depth++
+	if p.depth > jsonMaxDepth {
+		return ErrJSONTooDeep
+	}
+
+	p.pos++
+	return nil
+}
+
+func (p *jsonParser) parseObject() (*jsonNode, error) {
+	if err := p.enter(); err != nil {
+		return nil, err
+	}
+	defer func() { p.depth-- }()
+
+	node := &jsonNode{kind: jsonObject, obj: newJSONObj()}
+
+	p.skipSpaces()
+	if p.consume("}") {
+		return node, nil
+	}
+
+	for {
+		p.skipSpaces()
+		if p.pos >= len(p.data) || p.data[p.pos] != '"' {
+			return nil, ErrJSONInvalid
+		}
+
+		key, err := p.parseString()
+		if err != nil {
+			return nil, err
+		}
+
+		p.skipSpaces()
+		if !p.consume(":") {
+			return nil, ErrJSONInvalid
+		}
+
+		val, err := p.parseValue()
+		if err != nil {
+			return nil, err
+		}
+		node.obj.set(key, val)
+
+		p.skipSpaces()
+		if p.consume("}") {
+			return node, nil
+		}
+		if !p.consume(",") {
+			return nil, ErrJSONInvalid
+		}
+	}
+}
+
+func (p *jsonParser) parseArray() (*jsonNode, error) {
+	if err := p.enter(); err != nil {
+		return nil, err
+	}
+	defer func() { p.depth-- }()
+
+	node := &jsonNode{kind: jsonArray, arr: []*jsonNode{}}
+
+	p.skipSpaces()
+	if p.consume("]") {
+		return node, nil
+	}
+
+	for {
+		val, err := p.parseValue()
+		if err != nil {
+			return nil, err
+		}
+		node.arr = append(node.arr, val)
+
+		p.skipSpaces()
+		if p.consume("]") {
+			return node, nil
+		}
+		if !p.consume(",") {
+			return nil, ErrJSONInvalid
+		}
+	}
+}
+
+func (p *jsonParser) parseNumber() (*jsonNode, error) {
+	start := p.pos
+	isFloat := false
+
+	if p.data[p.pos] == '-' {
+		p.pos++
+	}
+
+	for p.pos < len(p.data) {
+		c := p.data[p.pos]
+		if c == '.' || c == 'e' || c == 'E' || c == '+' || (c == '-' && p.pos > start) {
+			isFloat = true
+		} else if c < '0' || c > '9' {
+			break
+		}
+		p.pos++
+	}
+
+	text := p.data[start:p.pos]
+
+	if !isFloat {
+		if i, err := strconv.ParseInt(text, 10, 64); err == nil {
+			return &jsonNode{kind: jsonInt, i: i}, nil
+		}
+	}
+
+	f, err := strconv.ParseFloat(text, 64)
+	if err != nil || math.IsInf(f, 0) {
+		return nil, ErrJSONInvalid
+	}
+
+	return &jsonNode{kind: jsonFloat, f: f}, nil
+}
+
+func (p *jsonParser) parseString() (string, error) {
+	p.pos++ // opening quote
+	var sb strings.Builder
+
+	for p.pos < len(p.data) {
+		c := p.data[p.pos]
+
+		switch {
+		case c == '"':
+			p.pos++
+			return sb.String(), nil
+		case c < 0x20:
+			return "", ErrJSONInvalid
+		case c != '\\':
+			sb.WriteByte(c)
+			p.pos++
+			continue
+		}
+
+		p.pos++
+		if p.pos >= len(p.data) {
+			return "", ErrJSONInvalid
+		}
+
+		esc := p.data[p.pos]
+		p.pos++
+
+		switch esc {
+		case '"', '\\', '/':
+			sb.WriteByte(esc)
+		case 'b':
+			sb.WriteByte('\b')
+		case 'f':
+			sb.WriteByte('\f')
+		case 'n':
+			sb.WriteByte('\n')
+		case 'r':
+			sb.WriteByte('\r')
+		case 't':
+			sb.WriteByte('\t')
+		case 'u':
+			r, ok := p.parseUnicodeEscape()
+			if !ok {
+				return "", ErrJSONInvalid
+			}
+			sb.WriteRune(r)
+		default:
+			return "", ErrJSONInvalid
+		}
+	}
+
+	return "", ErrJSONInvalid
+}
+
+func (p *jsonParser) parseHex4() (rune, bool) {
+	if p.pos+4 > len(p.data) {
+		return 0, false
+	}
+
+	v, err := strconv.ParseUint(p.data[p.pos:p.pos+4], 16, 32)
+	if err != nil {
+		return 0, false
+	}
+	p.pos += 4
+
+	return rune(v), true
+}
+
+func (p *jsonParser) parseUnicodeEscape() (rune, bool) {
+	r, ok := p.parseHex4()
+	if !ok {
+		return 0, false
+	}
+
+	// characters outside of BMP come as surrogate pairs
+	if r >= 0xd800 && r < 0xdc00 && p.consume("\\u") {
+		low, ok := p.parseHex4()
+		if !ok || low < 0xdc00 || low > 0xdfff {
+			return 0, false
+		}
+		return (r-0xd800)<<10 + (low - 0xdc00) + 0x10000, true
+	}
+
+	return r, true
+}
+
+// ================================ serializing ========================================
+
+type jsonFormat struct {
+	indent  string
+	newline string
+	space   string
+}
+
+func (n *jsonNode) String() string {
+	var sb strings.Builder
+	n.write(&sb, jsonFormat{}, 0)
+
+	return sb.String()
+}
+
+func (n *jsonNode) format(f jsonFormat) string {
+	var sb strings.Builder
+	n.write(&sb, f, 0)
+
+	return sb.String()
+}
+
+func formatJSONFloat(f float64) string {
+	s := strconv.FormatFloat(f, 'g', -1, 64)
+	if !strings.ContainsAny(s, ".eE") {
+		s += ".0"
+	}
+
+	return s
+}
+
+func writeJSONString(sb *strings.Builder, s string) {
+	sb.WriteByte('"')
+
+	for i := 0; i < len(s); {
+		r, size := utf8.DecodeRuneInString(s[i:])
+
+		switch {
+		case r == '"':
+			sb.WriteString(`\"`)
+		case r == '\\':
+			sb.WriteString(`\\`)
+		case r == '\n':
+			sb.WriteString(`\n`)
+		case r == '\r':
+			sb.WriteString(`\r`)
+		case r == '\t':
+			sb.WriteString(`\t`)
+		case r < 0x20 || (r == utf8.RuneError && size == 1):
+			sb.WriteString(`\u00`)
+			sb.WriteByte("0123456789abcdef"[s[i]>>4])
+			sb.WriteByte("0123456789abcdef"[s[i]&0xf])
+		default:
+			sb.WriteString(s[i : i+size])
+		}
+
+		i += size
+	}
+
+	sb.WriteByte('"')
+}
+
+func writeJSONIndent(sb *strings.Builder, f jsonFormat, depth int) {
+	sb.WriteString(f.newline)
+	for range depth {
+		sb.WriteString(f.indent)
+	}
+}
+
+func (n *jsonNode) write(sb *strings.Builder, f jsonFormat, depth int) {
+	switch n.kind {
+	case jsonNull:
+		sb.WriteString("null")
+	case jsonBool:
+		sb.WriteString(strconv.FormatBool(n.b))
+	case jsonInt:
+		sb.WriteString(strconv.FormatInt(n.i, 10))
+	case jsonFloat:
+		sb.WriteString(formatJSONFloat(n.f))
+	case jsonString:
+		writeJSONString(sb, n.str)
+	case jsonArray:
+		sb.WriteByte('[')
+		for i, el := range n.arr {
+			if i > 0 {
+				sb.WriteByte(',')
+			}
+			writeJSONIndent(sb, f, depth+1)
+			el.write(sb, f, depth+1)
+		}
+		if len(n.arr) > 0 {
+			writeJSONIndent(sb, f, depth)
+		}
+		sb.WriteByte(']')
+	case jsonObject:
+		sb.WriteByte('{')
+		for i, k := range n.obj.keys {
+			if i > 0 {
+				sb.WriteByte(',')
+			}
+			writeJSONIndent(sb, f, depth+1)
+			writeJSONString(sb, k)
+			sb.WriteByte(':')
+			sb.WriteString(f.space)
+			n.obj.vals[k].write(sb, f, depth+1)
+		}
+		if len(n.obj.keys) > 0 {
+			writeJSONIndent(sb, f, depth)
+		}
+		sb.WriteByte('}')
+	}
+}
+
+// jsonEqual compares values the way filter expressions need it: numbers are compared by value
+func jsonEqual(a, b *jsonNode) bool {
+	if a.isNumber() && b.isNumber() {
+		return a.number() == b.number()
+	}
+
+	if a.kind != b.kind {
+		return false
+	}
+
+	switch a.kind {
+	case jsonNull:
+		return true
+	case jsonBool:
+		return a.b == b.b
+	case jsonString:
+		return a.str == b.str
+	case jsonArray:
+		if len(a.arr) != len(b.arr) {
+			return false
+		}
+		for i := range a.arr {
+			if !jsonEqual(a.arr[i], b.arr[i]) {
+				return false
+			}
+		}
+		return true
+	case jsonObject:
+		if len(a.obj.keys) != len(b.obj.keys) {
+			return false
+		}
+		for _, k := range a.obj.keys {
+			bv, ok := b.obj.get(k)
+			if !ok || !jsonEqual(a.obj.vals[k], bv) {
+				return false
+			}
+		}
+		return true
+	}
+
+	return false
+}
@@ -0,0 +1,674 @@
+package main
+
+import (
+	"errors"
+	"math"
+	"slices"
+	"strings"
+)
+
+// lookupJSON returns root of JSON document stored at key or nil if key doesn't exist
+func lookupJSON(key string) (*jsonNode, error) {
+	val, ok := lookupKey(key)
+	if !ok {
+		return nil, nil
+	}
+
+	if val.dtype != JSONDtype {
+		return nil, ErrWrongType
+	}
+
+	return val.object.(*jsonNode), nil
+}
+
+func argToJSONPath(arg *KvsValue) (*jsonPath, error) {
+	return parseJSONPath(argToString(arg))
+}
+
+func argToJSON(arg *KvsValue) (*jsonNode, error) {
+	return parseJSON(argToString(arg))
+}
+
+// isJSONTypeErr reports whether err means that matched node has a wrong type for the command.
+// Such nodes get null in JSONPath replies instead of failing the whole command
+func isJSONTypeErr(err error) bool {
+	return errors.Is(err, ErrJSONNotNumber) || errors.Is(err, ErrJSONNotString) ||
+		errors.Is(err, ErrJSONNotArray) || errors.Is(err, ErrJSONNotObject)
+}
+
+// jsonPathReply calls fn for nodes matched by path. JSONPath gets an array with reply for every match,
+// legacy path works with the first match only and gets its reply as is
+func jsonPathReply(path *jsonPath, doc *jsonNode, fn func(m jsonMatch) ([]byte, error)) ([]byte, error) {
+	matches := path.eval(doc)
+
+	if path.legacy {
+		if len(matches) == 0 {
+			return nil, ErrJSONPathNotExist
+		}
+
+		return fn(matches[0])
+	}
+
+	elems := make([][]byte, len(matches))
+	for i, m := range matches {
+		res, err := fn(m)
+		if err != nil {
+			if !isJSONTypeErr(err) {
+				return nil, err
+			}
+			res = []byte(NullResponse)
+		}
+		elems[i] = res
+	}
+
+	return arrayResponse(elems...), nil
+}
+
+// jsonMatchesNode builds array node from matches, so they can be serialized as one JSON text
+func jsonMatchesNode(matches []jsonMatch) *jsonNode {
+	res := &jsonNode{kind: jsonArray, arr: make([]*jsonNode, len(matches))}
+	for i, m := range matches {
+		res.arr[i] = m.node
+	}
+
+	return res
+}
+
+// JSON.SET key path value [NX | XX]
+func jsonSetHandler(args []*KvsValue) ([]byte, error) {
+	key := argToString(args[0])
+
+	path, err := argToJSONPath(args[1])
+	if err != nil {
+		return nil, err
+	}
+
+	val, err := argToJSON(args[2])
+	if err != nil {
+		return nil, err
+	}
+
+	var nx, xx bool
+	for _, arg := range args[3:] {
+		switch strings.ToUpper(argToString(arg)) {
+		case "NX":
+			nx = true
+		case "XX":
+			xx = true
+		default:
+			return nil, ErrSyntax
+		}
+	}
+
+	if nx && xx {
+		return nil, ErrSyntax
+	}
+
+	doc, err := lookupJSON(key)
+	if err != nil {
+		return nil, err
+	}
+
+	if doc == nil {
+		if len(path.segments) > 0 {
+			return nil, ErrJSONNewAtRoot
+		}
+		if xx {
+			return []byte(NullResponse), nil
+		}
+
+		storeKey(key, &KvsValue{dtype: JSONDtype, object: val})
+		signalModifiedKey(key, notifyModule, "json.set")
+
+		return []byte(OkResponse), nil
+	}
+
+	if matches := path.eval(doc); len(matches) > 0 {
+		if nx {
+			return []byte(NullResponse), nil
+		}
+
+		for _, m := range matches {
+			*m.node = *val.clone()
+		}
+		signalModifiedKey(key, notifyModule, "json.set")
+
+		return []byte(OkResponse), nil
+	}
+
+	if xx {
+		return []byte(NullResponse), nil
+	}
+
+	// path doesn't match anything, but it still can name a new key of existing objects
+	created := false
+	if parentPath, name, ok := path.lastKey(); ok {
+		for _, m := range parentPath.eval(doc) {
+			if m.node.kind == jsonObject {
+				m.node.obj.set(name, val.clone())
+				created = true
+			}
+		}
+	}
+
+	if !created {
+		if path.legacy {
+			return nil, ErrJSONPathNotExist
+		}
+		return []byte(NullResponse), nil
+	}
+	signalModifiedKey(key, notifyModule, "json.set")
+
+	return []byte(OkResponse), nil
+}
+
+// JSON.GET key [INDENT indent] [NEWLINE newline] [SPACE space] [path [path ...]]
+func jsonGetHandler(args []*KvsValue) ([]byte, error) {
+	var f jsonFormat
+
+	i := 1
+	for ; i+1 < len(args); i += 2 {
+		switch strings.ToUpper(argToString(args[i])) {
+		case "INDENT":
+			f.indent = argToString(args[i+1])
+			continue
+		case "NEWLINE":
+			f.newline = argToString(args[i+1])
+			continue
+		case "SPACE":
+			f.space = argToString(args[i+1])
+			continue
+		}
+		break
+	}
+
+	paths := make([]*jsonPath, 0, len(args)-i)
+	legacy := true
+	for _, arg := range args[i:] {
+		path, err := argToJSONPath(arg)
+		if err != nil {
+			return nil, err
+		}
+		paths = append(paths, path)
+		legacy = legacy && path.legacy
+	}
+
+	doc, err := lookupJSON(argToString(args[0]))
+	if err != nil || doc == nil {
+		return []byte(NullResponse), err
+	}
+
+	if len(paths) == 0 {
+		return bulkStrResponse([]byte(doc.format(f))), nil
+	}
+
+	// with several paths reply is an object keyed by path. When any of them is JSONPath all of them
+	// reply with arrays of matches
+	res := &jsonNode{kind: jsonObject, obj: newJSONObj()}
+	for _, path := range paths {
+		matches := path.eval(doc)
+
+		if !legacy {
+			res.obj.set(path.raw, jsonMatchesNode(matches))
+			continue
+		}
+
+		if len(matches) == 0 {
+			return nil, ErrJSONPathNotExist
+		}
+		res.obj.set(path.raw, matches[0].node)
+	}
+
+	if len(paths) == 1 {
+		res = res.obj.vals[paths[0].raw]
+	}
+
+	return bulkStrResponse([]byte(res.format(f))), nil
+}
+
+// JSON.DEL key [path]
+func jsonDelHandler(args []*KvsValue) ([]byte, error) {
+	if len(args) > 2 {
+		return nil, wrongArgsCountErr("JSON.DEL")
+	}
+
+	key := argToString(args[0])
+
+	doc, err := lookupJSON(key)
+	if err != nil || doc == nil {
+		return intResponse(0), err
+	}
+
+	path := &jsonPath{}
+	if len(args) > 1 {
+		if path, err = argToJSONPath(args[1]); err != nil {
+			return nil, err
+		}
+	}
+
+	if len(path.segments) == 0 {
+		removeKey(key)
+		signalModifiedKey(key, notifyModule, "json.del")
+		return intResponse(1), nil
+	}
+
+	matches := path.eval(doc)
+
+	// array elements are removed by identity, so several matches in one array don't shift each other
+	removed := make(map[*jsonNode]bool, len(matches))
+	var parents []*jsonNode
+	for _, m := range matches {
+		if removed[m.node] {
+			continue
+		}
+		removed[m.node] = true
+
+		switch m.parent.kind {
+		case jsonObject:
+			m.parent.obj.remove(m.key)
+		case jsonArray:
+			if !slices.Contains(parents, m.parent) {
+				parents = append(parents, m.parent)
+			}
+		}
+	}
+
+	for _, parent := range parents {
+		parent.arr = slices.DeleteFunc(parent.arr, func(n *jsonNode) bool { return removed[n] })
+	}
+
+	if len(removed) > 0 {
+		signalModifiedKey(key, notifyModule, "json.del")
+	}
+
+	return intResponse(int64(len(removed))), nil
+}
+
+// JSON.TYPE key [path]
+func jsonTypeHandler(args []*KvsValue) ([]byte, error) {
+	if len(args) > 2 {
+		return nil, wrongArgsCountErr("JSON.TYPE")
+	}
+
+	doc, err := lookupJSON(argToString(args[0]))
+	if err != nil || doc == nil {
+		return []byte(NullResponse), err
+	}
+
+	path := &jsonPath{legacy: true}
+	if len(args) > 1 {
+		if path, err = argToJSONPath(args[1]); err != nil {
+			return nil, err
+		}
+	}
+
+	return jsonPathReply(path, doc, func(m jsonMatch) ([]byte, error) {
+		return simpleStrResponse(m.node.typeName()), nil
+	})
+}
+
+// jsonIncr adds delta to number node in place. Sum of two integers stays integer while it fits int64
+func jsonIncr(n, delta *jsonNode) error {
+	if !n.isNumber() {
+		return ErrJSONNotNumber
+	}
+
+	if n.kind == jsonInt && delta.kind == jsonInt {
+		sum := n.i + delta.i
+		if (sum > n.i) == (delta.i > 0) {
+			n.i = sum
+			return nil
+		}
+	}
+
+	sum := n.number() + delta.number()
+	if math.IsInf(sum, 0) || math.IsNaN(sum) {
+		return ErrJSONNumOverflow
+	}
+
+	n.kind, n.f = jsonFloat, sum
+
+	return nil
+}
+
+// JSON.NUMINCRBY key path value
+func jsonNumincrbyHandler(args []*KvsValue) ([]byte, error) {
+	path, err := argToJSONPath(args[1])
+	if err != nil {
+		return nil, err
+	}
+
+	delta, err := argToJSON(args[2])
+	if err != nil || !delta.isNumber() {
+		return nil, ErrJSONNotNumber
+	}
+
+	doc, err := lookupJSON(argToString(args[0]))
+	if err != nil {
+		return nil, err
+	}
+	if doc == nil {
+		return nil, ErrStreamNoSuchKey
+	}
+	defer signalModifiedKey(argToString(args[0]), notifyModule, "json.numincrby")
+
+	matches := path.eval(doc)
+
+	if path.legacy {
+		if len(matches) == 0 {
+			return nil, ErrJSONPathNotExist
+		}
+		if err := jsonIncr(matches[0].node, delta); err != nil {
+			return nil, err
+		}
+		return bulkStrResponse([]byte(matches[0].node.String())), nil
+	}
+
+	// like JSON.GET, reply is a JSON array with new values and nulls for matches that are not numbers
+	res := &jsonNode{kind: jsonArray, arr: make([]*jsonNode, len(matches))}
+	for i, m := range matches {
+		err := jsonIncr(m.node, delta)
+		switch {
+		case err == nil:
+			res.arr[i] = m.node
+		case isJSONTypeErr(err):
+			res.arr[i] = &jsonNode{kind: jsonNull}
+		default:
+			return nil, err
+		}
+	}
+
+	return bulkStrResponse([]byte(res.String())), nil
+}
+
+// JSON.STRAPPEND key [path] value
+func jsonStrappendHandler(args []*KvsValue) ([]byte, error) {
+	if len(args) > 3 {
+		return nil, wrongArgsCountErr("JSON.STRAPPEND")
+	}
+
+	path := &jsonPath{legacy: true}
+	var err error
+	if len(args) == 3 {
+		if path, err = argToJSONPath(args[1]); err != nil {
+			return nil, err
+		}
+	}
+
+	val, err := argToJSON(args[len(args)-1])
+	if err != nil {
+		return nil, err
+	}
+	if val.kind != jsonString {
+		return nil, ErrJSONNotString
+	}
+
+	doc, err := lookupJSON(argToString(args[0]))
+	if err != nil {
+		return nil, err
+	}
+	if doc == nil {
+		return nil, ErrStreamNoSuchKey
+	}
+	defer signalModifiedKey(argToString(args[0]), notifyModule, "json.strappend")
+
+	return jsonPathReply(path, doc, func(m jsonMatch) ([]byte, error) {
+		if m.node.kind != jsonString {
+			return nil, ErrJSONNotString
+		}
+
+		m.node.str += val.str
+
+		return intResponse(int64(len(m.node.str))), nil
+	})
+}
+
+// lookupJSONForPath is a common start of array commands: parses path and finds document that must exist
+func lookupJSONForPath(keyArg, pathArg *KvsValue) (*jsonNode, *jsonPath, error) {
+	path, err := argToJSONPath(pathArg)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	doc, err := lookupJSON(argToString(keyArg))
+	if err != nil {
+		return nil, nil, err
+	}
+	if doc == nil {
+		return nil, nil, ErrStreamNoSuchKey
+	}
+
+	return doc, path, nil
+}
+
+func argsToJSON(args []*KvsValue) ([]*jsonNode, error) {
+	res := make([]*jsonNode, len(args))
+	for i, arg := range args {
+		val, err := argToJSON(arg)
+		if err != nil {
+			return nil, err
+		}
+		res[i] = val
+	}
+
+	return res, nil
+}
+
+// JSON.ARRAPPEND key path value [value ...]
+func jsonArrappendHandler(args []*KvsValue) ([]byte, error) {
+	vals, err := argsToJSON(args[2:])
+	if err != nil {
+		return nil, err
+	}
+
+	doc, path, err := lookupJSONForPath(args[0], args[1])
+	if err != nil {
+		return nil, err
+	}
+	defer signalModifiedKey(argToString(args[0]), notifyModule, "json.arrappend")
+
+	return jsonPathReply(path, doc, func(m jsonMatch) ([]byte, error) {
+		if m.node.kind != jsonArray {
+			return nil, ErrJSONNotArray
+		}
+
+		for _, val := range vals {
+			m.node.arr = append(m.node.arr, val.clone())
+		}
+
+		return intResponse(int64(len(m.node.arr))), nil
+	})
+}
+
+// JSON.ARRINSERT key path index value [value ...]
+func jsonArrinsertHandler(args []*KvsValue) ([]byte, error) {
+	index, err := argToInt64(args[2])
+	if err != nil {
+		return nil, err
+	}
+
+	vals, err := argsToJSON(args[3:])
+	if err != nil {
+		return nil, err
+	}
+
+	doc, path, err := lookupJSONForPath(args[0], args[1])
+	if err != nil {
+		return nil, err
+	}
+	defer signalModifiedKey(argToString(args[0]), notifyModule, "json.arrinsert")
+
+	return jsonPathReply(path, doc, func(m jsonMatch) ([]byte, error) {
+		if m.node.kind != jsonArray {
+			return nil, ErrJSONNotArray
+		}
+
+		length := int64(len(m.node.arr))
+		i := index
+		if i < 0 {
+			i += length
+		}
+		if i < 0 || i > length {
+			return nil, ErrJSONIndexOutRange
+		}
+
+		inserted := make([]*jsonNode, len(vals))
+		for j, val := range vals {
+			inserted[j] = val.clone()
+		}
+		m.node.arr = slices.Insert(m.node.arr, int(i), inserted...)
+
+		return intResponse(int64(len(m.node.arr))), nil
+	})
+}
+
+// JSON.ARRPOP key [path [index]]
+func jsonArrpopHandler(args []*KvsValue) ([]byte, error) {
+	if len(args) > 3 {
+		return nil, wrongArgsCountErr("JSON.ARRPOP")
+	}
+
+	index := int64(-1)
+	if len(args) == 3 {
+		var err error
+		if index, err = argToInt64(args[2]); err != nil {
+			return nil, err
+		}
+	}
+
+	pathArg := &KvsValue{dtype: BulkStrSymbol, value: []byte(".")}
+	if len(args) > 1 {
+		pathArg = args[1]
+	}
+
+	doc, path, err := lookupJSONForPath(args[0], pathArg)
+	if err != nil {
+		return nil, err
+	}
+	defer signalModifiedKey(argToString(args[0]), notifyModule, "json.arrpop")
+
+	return jsonPathReply(path, doc, func(m jsonMatch) ([]byte, error) {
+		if m.node.kind != jsonArray {
+			return nil, ErrJSONNotArray
+		}
+
+		length := int64(len(m.node.arr))
+		if length == 0 {
+			return []byte(NullResponse), nil
+		}
+
+		// out of range index pops the first or the last element
+		i := index
+		if i < 0 {
+			i += length
+		}
+		i = min(max(i, 0), length-1)
+
+		popped := m.node.arr[i]
+		m.node.arr = slices.Delete(m.node.arr, int(i), int(i)+1)
+
+		return bulkStrResponse([]byte(popped.String())), nil
+	})
+}
+
+// JSON.ARRTRIM key path start stop
+func jsonArrtrimHandler(args []*KvsValue) ([]byte, error) {
+	start, err := argToInt64(args[2])
+	if err != nil {
+		return nil, err
+	}
+
+	stop, err := argToInt64(args[3])
+	if err != nil {
+		return nil, err
+	}
+
+	doc, path, err := lookupJSONForPath(args[0], args[1])
+	if err != nil {
+		return nil, err
+	}
+	defer signalModifiedKey(argToString(args[0]), notifyModule, "json.arrtrim")
+
+	return jsonPathReply(path, doc, func(m jsonMatch) ([]byte, error) {
+		if m.node.kind != jsonArray {
+			return nil, ErrJSONNotArray
+		}
+
+		length := int64(len(m.node.arr))
+		from, to := start, stop
+		if from < 0 {
+			from = max(from+length, 0)
+		}
+		if to < 0 {
+			to += length
+		}
+		to = min(to, length-1)
+
+		if from > to || from >= length {
+			m.node.arr = m.node.arr[:0]
+		} else {
+			m.node.arr = slices.Clone(m.node.arr[from : to+1])
+		}
+
+		return intResponse(int64(len(m.node.arr))), nil
+	})
+}
+
+// JSON.OBJKEYS key [path]
+func jsonObjkeysHandler(args []*KvsValue) ([]byte, error) {
+	if len(args) > 2 {
+		return nil, wrongArgsCountErr("JSON.OBJKEYS")
+	}
+
+	doc, err := lookupJSON(argToString(args[0]))
+	if err != nil || doc == nil {
+		return []byte(NullResponse), err
+	}
+
+	path := &jsonPath{legacy: true}
+	if len(args) > 1 {
+		if path, err = argToJSONPath(args[1]); err != nil {
+			return nil, err
+		}
+	}
+
+	return jsonPathReply(path, doc, func(m jsonMatch) ([]byte, error) {
+		if m.node.kind != jsonObject {
+			return nil, ErrJSONNotObject
+		}
+
+		keys := make([][]byte, len(m.node.obj.keys))
+		for i, k := range m.node.obj.keys {
+			keys[i] = []byte(k)
+		}
+
+		return bulkStrArrayResponse(keys...), nil
+	})
+}
+
+// JSON.MGET key [key ...] path
+func jsonMgetHandler(args []*KvsValue) ([]byte, error) {
+	path, err := argToJSONPath(args[len(args)-1])
+	if err != nil {
+		return nil, err
+	}
+
+	res := make([][]byte, len(args)-1)
+	for i, arg := range args[:len(args)-1] {
+		// missing keys and keys of other types are reported as null instead of failing the whole command
+		doc, err := lookupJSON(argToString(arg))
+		if err != nil || doc == nil {
+			continue
+		}
+
+		matches := path.eval(doc)
+		switch {
+		case !path.legacy:
+			res[i] = []byte(jsonMatchesNode(matches).String())
+		case len(matches) > 0:
+			res[i] = []byte(matches[0].node.String())
+		}
+	}
+
+	return bulkStrArrayResponse(res...), nil
+}
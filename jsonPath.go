@@ -0,0 +1,712 @@
+package main
+
+import (
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// JSONPath support covers: $ root, .key and ['key'] children, [n] indices (negative count from the end),
+// [a,b] unions, [start:end:step] slices, * wildcards, .. recursive descent and [?(...)] filters with
+// comparison (==, !=, <, <=, >, >=, =~), &&, || and ! operators.
+// Paths not starting with $ are legacy ones: they are converted to JSONPath but commands reply with
+// a single value instead of an array of all matches
+const (
+	jsonSelName = iota
+	jsonSelIndex
+	jsonSelWildcard
+	jsonSelSlice
+	jsonSelFilter
+)
+
+type jsonSelector struct {
+	kind    int
+	names   []string
+	indices []int
+
+	start, end, step int
+	hasStart, hasEnd bool
+	filter           jsonFilterExpr
+}
+
+type jsonPathSegment struct {
+	recursive bool
+	sel       jsonSelector
+}
+
+type jsonPath struct {
+	raw      string
+	legacy   bool
+	segments []jsonPathSegment
+}
+
+// jsonMatch is a node found by path together with its place in the parent, so it can be replaced or removed
+type jsonMatch struct {
+	node   *jsonNode
+	parent *jsonNode
+	key    string
+	index  int
+}
+
+func parseJSONPath(raw string) (*jsonPath, error) {
+	path := &jsonPath{raw: raw}
+	expr := raw
+
+	if !strings.HasPrefix(raw, "$") {
+		path.legacy = true
+		switch {
+		case raw == ".":
+			expr = "$"
+		case strings.HasPrefix(raw, ".") || strings.HasPrefix(raw, "["):
+			expr = "$" + raw
+		default:
+			expr = "$." + raw
+		}
+	}
+
+	p := &jsonPathParser{data: expr, pos: 1}
+
+	for p.pos < len(p.data) {
+		seg, err := p.parseSegment()
+		if err != nil {
+			return nil, err
+		}
+		path.segments = append(path.segments, seg)
+	}
+
+	return path, nil
+}
+
+type jsonPathParser struct {
+	data string
+	pos  int
+}
+
+func isJSONPathNameChar(c byte) bool {
+	return c != '.' && c != '[' && c != ']' && c != ' ' && c != '(' && c != ')' &&
+		c != '=' && c != '!' && c != '<' && c != '>' && c != '&' && c != '|' && c != ','
+}
+
+func (p *jsonPathParser) parseSegment() (jsonPathSegment, error) {
+	var seg jsonPathSegment
+
+	switch {
+	case strings.HasPrefix(p.data[p.pos:], ".."):
+		seg.recursive = true
+		p.pos += 2
+		if p.pos < len(p.data) && p.data[p.pos] == '[' {
+			sel, err := p.parseBracket()
+			seg.sel = sel
+			return seg, err
+		}
+	case p.data[p.pos] == '.':
+		p.pos++
+	case p.data[p.pos] == '[':
+		sel, err := p.parseBracket()
+		seg.sel = sel
+		return seg, err
+	default:
+		return seg, jsonPathErr(p.data)
+	}
+
+	if p.pos < len(p.data) && p.data[p.pos] == '*' {
+		p.pos++
+		seg.sel.kind = jsonSelWildcard
+		return seg, nil
+	}
+
+	start := p.pos
+	for p.pos < len(p.data) && isJSONPathNameChar(p.data[p.pos]) {
+		p.pos++
+	}
+
+	if start == p.pos {
+		return seg, jsonPathErr(p.data)
+	}
+
+	seg.sel = jsonSelector{kind: jsonSelName, names: []string{p.data[start:p.pos]}}
+
+	return seg, nil
+}
+
+func (p *jsonPathParser) skipSpaces() {
+	for p.pos < len(p.data) && p.data[p.pos] == ' ' {
+		p.pos++
+	}
+}
+
+// parseQuoted reads string in single or double quotes starting at current position
+func (p *jsonPathParser) parseQuoted() (string, bool) {
+	quote := p.data[p.pos]
+	p.pos++
+
+	var sb strings.Builder
+	for p.pos < len(p.data) {
+		c := p.data[p.pos]
+		p.pos++
+
+		switch {
+		case c == quote:
+			return sb.String(), true
+		case c == '\\' && p.pos < len(p.data):
+			sb.WriteByte(p.data[p.pos])
+			p.pos++
+		default:
+			sb.WriteByte(c)
+		}
+	}
+
+	return "", false
+}
+
+func (p *jsonPathParser) parseBracket() (jsonSelector, error) {
+	var sel jsonSelector
+	p.pos++ // [
+	p.skipSpaces()
+
+	if p.pos >= len(p.data) {
+		return sel, jsonPathErr(p.data)
+	}
+
+	switch c := p.data[p.pos]; {
+	case c == '*':
+		p.pos++
+		sel.kind = jsonSelWildcard
+	case c == '?':
+		p.pos++
+		expr, err := p.parseFilter()
+		if err != nil {
+			return sel, err
+		}
+		sel.kind = jsonSelFilter
+		sel.filter = expr
+	case c == '\'' || c == '"':
+		sel.kind = jsonSelName
+		for {
+			name, ok := p.parseQuoted()
+			if !ok {
+				return sel, jsonPathErr(p.data)
+			}
+			sel.names = append(sel.names, name)
+
+			p.skipSpaces()
+			if p.pos >= len(p.data) || p.data[p.pos] != ',' {
+				break
+			}
+			p.pos++
+			p.skipSpaces()
+			if p.pos >= len(p.data) || (p.data[p.pos] != '\'' && p.data[p.pos] != '"') {
+				return sel, jsonPathErr(p.data)
+			}
+		}
+	default:
+		end := strings.IndexByte(p.data[p.pos:], ']')
+		if end < 0 {
+			return sel, jsonPathErr(p.data)
+		}
+
+		inner := strings.TrimSpace(p.data[p.pos : p.pos+end])
+		p.pos += end
+
+		var err error
+		if strings.Contains(inner, ":") {
+			err = parseJSONSlice(inner, &sel)
+		} else {
+			err = parseJSONIndices(inner, &sel)
+		}
+		if err != nil {
+			return sel, jsonPathErr(p.data)
+		}
+	}
+
+	p.skipSpaces()
+	if p.pos >= len(p.data) || p.data[p.pos] != ']' {
+		return sel, jsonPathErr(p.data)
+	}
+	p.pos++
+
+	return sel, nil
+}
+
+func parseJSONIndices(inner string, sel *jsonSelector) error {
+	sel.kind = jsonSelIndex
+
+	for _, part := range strings.Split(inner, ",") {
+		i, err := strconv.Atoi(strings.TrimSpace(part))
+		if err != nil {
+			return err
+		}
+		sel.indices = append(sel.indices, i)
+	}
+
+	return nil
+}
+
+func parseJSONSlice(inner string, sel *jsonSelector) error {
+	sel.kind = jsonSelSlice
+	sel.step = 1
+
+	parts := strings.Split(inner, ":")
+	if len(parts) > 3 {
+		return ErrSyntax
+	}
+
+	for i, part := range parts {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+
+		v, err := strconv.Atoi(part)
+		if err != nil {
+			return err
+		}
+
+		switch i {
+		case 0:
+			sel.start, sel.hasStart = v, true
+		case 1:
+			sel.end, sel.hasEnd = v, true
+		case 2:
+			if v == 0 {
+				return ErrSyntax
+			}
+			sel.step = v
+		}
+	}
+
+	return nil
+}
+
+// ================================ evaluation ========================================
+
+func (path *jsonPath) eval(root *jsonNode) []jsonMatch {
+	matches := []jsonMatch{{node: root}}
+
+	for _, seg := range path.segments {
+		var next []jsonMatch
+
+		for _, m := range matches {
+			if seg.recursive {
+				for _, d := range jsonDescendants(m) {
+					next = append(next, seg.sel.apply(d.node, root)...)
+				}
+				continue
+			}
+
+			next = append(next, seg.sel.apply(m.node, root)...)
+		}
+
+		matches = next
+	}
+
+	return matches
+}
+
+// jsonDescendants returns match itself and all nodes below it in document order
+func jsonDescendants(m jsonMatch) []jsonMatch {
+	res := []jsonMatch{m}
+
+	switch m.node.kind {
+	case jsonArray:
+		for i, el := range m.node.arr {
+			res = append(res, jsonDescendants(jsonMatch{node: el, parent: m.node, index: i})...)
+		}
+	case jsonObject:
+		for _, k := range m.node.obj.keys {
+			res = append(res, jsonDescendants(jsonMatch{node: m.node.obj.vals[k], parent: m.node, key: k})...)
+		}
+	}
+
+	return res
+}
+
+func jsonChildren(n *jsonNode) []jsonMatch {
+	var res []jsonMatch
+
+	switch n.kind {
+	case jsonArray:
+		for i, el := range n.arr {
+			res = append(res, jsonMatch{node: el, parent: n, index: i})
+		}
+	case jsonObject:
+		for _, k := range n.obj.keys {
+			res = append(res, jsonMatch{node: n.obj.vals[k], parent: n, key: k})
+		}
+	}
+
+	return res
+}
+
+func (sel *jsonSelector) apply(n *jsonNode, root *jsonNode) []jsonMatch {
+	var res []jsonMatch
+
+	switch sel.kind {
+	case jsonSelName:
+		if n.kind != jsonObject {
+			return nil
+		}
+		for _, name := range sel.names {
+			if v, ok := n.obj.get(name); ok {
+				res = append(res, jsonMatch{node: v, parent: n, key: name})
+			}
+		}
+	case jsonSelWildcard:
+		res = jsonChildren(n)
+	case jsonSelIndex:
+		if n.kind != jsonArray {
+			return nil
+		}
+		for _, i := range sel.indices {
+			if i < 0 {
+				i += len(n.arr)
+			}
+			if i >= 0 && i < len(n.arr) {
+				res = append(res, jsonMatch{node: n.arr[i], parent: n, index: i})
+			}
+		}
+	case jsonSelSlice:
+		if n.kind != jsonArray {
+			return nil
+		}
+		for _, i := range sel.sliceIndices(len(n.arr)) {
+			res = append(res, jsonMatch{node: n.arr[i], parent: n, index: i})
+		}
+	case jsonSelFilter:
+		for _, child := range jsonChildren(n) {
+			if sel.filter.eval(child.node, root) {
+				res = append(res, child)
+			}
+		}
+	}
+
+	return res
+}
+
+func normalizeSliceBound(i, length int) int {
+	if i < 0 {
+		i += length
+	}
+
+	return min(max(i, 0), length)
+}
+
+func (sel *jsonSelector) sliceIndices(length int) []int {
+	var res []int
+
+	if sel.step > 0 {
+		start, end := 0, length
+		if sel.hasStart {
+			start = normalizeSliceBound(sel.start, length)
+		}
+		if sel.hasEnd {
+			end = normalizeSliceBound(sel.end, length)
+		}
+
+		for i := start; i < end; i += sel.step {
+			res = append(res, i)
+		}
+
+		return res
+	}
+
+	start, end := length-1, -1
+	if sel.hasStart {
+		start = min(normalizeSliceBound(sel.start, length), length-1)
+	}
+	if sel.hasEnd {
+		end = normalizeSliceBound(sel.end, length)
+	}
+
+	for i := start; i > end; i += sel.step {
+		res = append(res, i)
+	}
+
+	return res
+}
+
+// lastKey splits path like $.a.b into $.a and "b", so commands can create missing object keys
+func (path *jsonPath) lastKey() (*jsonPath, string, bool) {
+	if len(path.segments) == 0 {
+		return nil, "", false
+	}
+
+	last := path.segments[len(path.segments)-1]
+	if last.recursive || last.sel.kind != jsonSelName || len(last.sel.names) != 1 {
+		return nil, "", false
+	}
+
+	parent := &jsonPath{raw: path.raw, legacy: path.legacy, segments: path.segments[:len(path.segments)-1]}
+
+	return parent, last.sel.names[0], true
+}
+
+// ================================ filters ========================================
+
+type jsonFilterExpr interface {
+	eval(cur, root *jsonNode) bool
+}
+
+type jsonFilterOr struct{ left, right jsonFilterExpr }
+type jsonFilterAnd struct{ left, right jsonFilterExpr }
+type jsonFilterNot struct{ expr jsonFilterExpr }
+
+type jsonFilterOperand struct {
+	path    *jsonPath
+	fromCur bool
+	literal *jsonNode
+	regex   *regexp.Regexp
+}
+
+type jsonFilterCmp struct {
+	left  jsonFilterOperand
+	op    string
+	right jsonFilterOperand
+}
+
+func (e jsonFilterOr) eval(cur, root *jsonNode) bool {
+	return e.left.eval(cur, root) || e.right.eval(cur, root)
+}
+
+func (e jsonFilterAnd) eval(cur, root *jsonNode) bool {
+	return e.left.eval(cur, root) && e.right.eval(cur, root)
+}
+
+func (e jsonFilterNot) eval(cur, root *jsonNode) bool {
+	return !e.expr.eval(cur, root)
+}
+
+func (o jsonFilterOperand) value(cur, root *jsonNode) *jsonNode {
+	if o.literal != nil {
+		return o.literal
+	}
+
+	start := root
+	if o.fromCur {
+		start = cur
+	}
+
+	matches := o.path.eval(start)
+	if len(matches) == 0 {
+		return nil
+	}
+
+	return matches[0].node
+}
+
+func (e jsonFilterCmp) eval(cur, root *jsonNode) bool {
+	left := e.left.value(cur, root)
+
+	if e.op == "" {
+		return left != nil && !(left.kind == jsonBool && !left.b)
+	}
+
+	right := e.right.value(cur, root)
+	if left == nil || right == nil {
+		return e.op == "!=" && (left != nil || right != nil)
+	}
+
+	switch e.op {
+	case "==":
+		return jsonEqual(left, right)
+	case "!=":
+		return !jsonEqual(left, right)
+	case "=~":
+		if left.kind != jsonString {
+			return false
+		}
+		re := e.right.regex
+		if re == nil {
+			if right.kind != jsonString {
+				return false
+			}
+			var err error
+			if re, err = regexp.Compile(right.str); err != nil {
+				return false
+			}
+		}
+		return re.MatchString(left.str)
+	}
+
+	var c int
+	switch {
+	case left.isNumber() && right.isNumber():
+		c = cmpFloat(left.number(), right.number())
+	case left.kind == jsonString && right.kind == jsonString:
+		c = strings.Compare(left.str, right.str)
+	default:
+		return false
+	}
+
+	switch e.op {
+	case "<":
+		return c < 0
+	case "<=":
+		return c <= 0
+	case ">":
+		return c > 0
+	case ">=":
+		return c >= 0
+	}
+
+	return false
+}
+
+// parseFilter parses "(expr)" right after "?" of filter selector
+func (p *jsonPathParser) parseFilter() (jsonFilterExpr, error) {
+	p.skipSpaces()
+	if p.pos >= len(p.data) || p.data[p.pos] != '(' {
+		return nil, jsonPathErr(p.data)
+	}
+	p.pos++
+
+	expr, err := p.parseOr()
+	if err != nil {
+		return nil, err
+	}
+
+	p.skipSpaces()
+	if p.pos >= len(p.data) || p.data[p.pos] != ')' {
+		return nil, jsonPathErr(p.data)
+	}
+	p.pos++
+
+	return expr, nil
+}
+
+func (p *jsonPathParser) consume(s string) bool {
+	p.skipSpaces()
+	if strings.HasPrefix(p.data[p.pos:], s) {
+		p.pos += len(s)
+		return true
+	}
+
+	return false
+}
+
+func (p *jsonPathParser) parseOr() (jsonFilterExpr, error) {
+	left, err := p.parseAnd()
+	if err != nil {
+		return nil, err
+	}
+
+	for p.consume("||") {
+		right, err := p.parseAnd()
+		if err != nil {
+			return nil, err
+		}
+		left = jsonFilterOr{left, right}
+	}
+
+	return left, nil
+}
+
+func (p *jsonPathParser) parseAnd() (jsonFilterExpr, error) {
+	left, err := p.parseUnary()
+	if err != nil {
+		return nil, err
+	}
+
+	for p.consume("&&") {
+		right, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		left = jsonFilterAnd{left, right}
+	}
+
+	return left, nil
+}
+
+func (p *jsonPathParser) parseUnary() (jsonFilterExpr, error) {
+	if p.consume("!") && !strings.HasPrefix(p.data[p.pos:], "=") {
+		expr, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		return jsonFilterNot{expr}, nil
+	}
+
+	if p.consume("(") {
+		expr, err := p.parseOr()
+		if err != nil {
+			return nil, err
+		}
+		if !p.consume(")") {
+			return nil, jsonPathErr(p.data)
+		}
+		return expr, nil
+	}
+
+	left, err := p.parseOperand()
+	if err != nil {
+		return nil, err
+	}
+
+	for _, op := range []string{"==", "!=", "<=", ">=", "=~", "<", ">"} {
+		if !p.consume(op) {
+			continue
+		}
+
+		right, err := p.parseOperand()
+		if err != nil {
+			return nil, err
+		}
+
+		if op == "=~" && right.literal != nil && right.literal.kind == jsonString {
+			right.regex, err = regexp.Compile(right.literal.str)
+			if err != nil {
+				return nil, jsonPathErr(p.data)
+			}
+		}
+
+		return jsonFilterCmp{left: left, op: op, right: right}, nil
+	}
+
+	return jsonFilterCmp{left: left}, nil
+}
+
+func (p *jsonPathParser) parseOperand() (jsonFilterOperand, error) {
+	var o jsonFilterOperand
+
+	p.skipSpaces()
+	if p.pos >= len(p.data) {
+		return o, jsonPathErr(p.data)
+	}
+
+	switch c := p.data[p.pos]; {
+	case c == '@' || c == '$':
+		o.fromCur = c == '@'
+		p.pos++
+
+		sub := &jsonPathParser{data: p.data, pos: p.pos}
+		o.path = &jsonPath{}
+		for sub.pos < len(sub.data) && (sub.data[sub.pos] == '.' || sub.data[sub.pos] == '[') {
+			seg, err := sub.parseSegment()
+			if err != nil {
+				return o, err
+			}
+			o.path.segments = append(o.path.segments, seg)
+		}
+		p.pos = sub.pos
+	case c == '\'' || c == '"':
+		s, ok := p.parseQuoted()
+		if !ok {
+			return o, jsonPathErr(p.data)
+		}
+		o.literal = &jsonNode{kind: jsonString, str: s}
+	default:
+		start := p.pos
+		for p.pos < len(p.data) && strings.IndexByte(" )&|=!<>", p.data[p.pos]) < 0 {
+			p.pos++
+		}
+
+		lit, err := parseJSON(p.data[start:p.pos])
+		if err != nil || lit.kind == jsonArray || lit.kind == jsonObject {
+			return o, jsonPathErr(p.data)
+		}
+		o.literal = lit
+	}
+
+	return o, nil
+}
@@ -0,0 +1,96 @@
+package main
+
+import (
+	"testing"
+)
+
+const jsonPathTestDoc = `{"store":{"book":[{"title":"A","price":8},{"title":"B","price":12,"isbn":"x"},{"title":"C","price":5}],` +
+	`"bicycle":{"color":"red","price":20}},"arr":[0,1,2,3,4,5]}`
+
+func evalJSONPath(t *testing.T, path string) string {
+	t.Helper()
+
+	doc, err := parseJSON(jsonPathTestDoc)
+	if err != nil {
+		t.Fatalf("parseJSON returned error: %v", err)
+	}
+
+	p, err := parseJSONPath(path)
+	if err != nil {
+		t.Fatalf("parseJSONPath(%q) returned error: %v", path, err)
+	}
+
+	return jsonMatchesNode(p.eval(doc)).String()
+}
+
+func TestJSONPathEval(t *testing.T) {
+	cases := []struct {
+		path     string
+		expected string
+	}{
+		{"$", `[` + jsonPathTestDoc + `]`},
+		{"$.store.bicycle.color", `["red"]`},
+		{"$['store']['bicycle']['color','price']", `["red",20]`},
+		{"$..price", `[8,12,5,20]`},
+		{"$.store.book[*].title", `["A","B","C"]`},
+		{"$.store.book[-1].title", `["C"]`},
+		{"$.store.book[0,2].title", `["A","C"]`},
+		{"$.arr[1:3]", `[1,2]`},
+		{"$.arr[:-4]", `[0,1]`},
+		{"$.arr[::2]", `[0,2,4]`},
+		{"$.arr[::-3]", `[5,2]`},
+		{"$.store.book[?(@.price < 10)].title", `["A","C"]`},
+		{"$.store.book[?(@.isbn)].title", `["B"]`},
+		{"$.store.book[?(!@.isbn && @.price > 6)].title", `["A"]`},
+		{"$.store.book[?(@.title == 'A' || @.price >= 12)].title", `["A","B"]`},
+		{"$.store.book[?(@.title =~ '^[BC]$')].title", `["B","C"]`},
+		{"$.store.book[?(@.price < $.store.bicycle.price && (@.price > 6))].title", `["A","B"]`},
+		{"$.missing", `[]`},
+		{"store.bicycle.price", `[20]`},
+		{".arr[0]", `[0]`},
+	}
+
+	for _, c := range cases {
+		if res := evalJSONPath(t, c.path); res != c.expected {
+			t.Errorf("eval(%q) = %v, expected: %v", c.path, res, c.expected)
+		}
+	}
+}
+
+func TestJSONPathLegacy(t *testing.T) {
+	cases := map[string]bool{"$.a": false, "$": false, ".": true, "a.b": true, "[0]": true}
+
+	for path, legacy := range cases {
+		p, err := parseJSONPath(path)
+		if err != nil {
+			t.Errorf("parseJSONPath(%q) returned error: %v", path, err)
+			continue
+		}
+
+		if p.legacy != legacy {
+			t.Errorf("parseJSONPath(%q).legacy = %v, expected: %v", path, p.legacy, legacy)
+		}
+	}
+}
+
+func TestJSONPathInvalid(t *testing.T) {
+	for _, path := range []string{"$.", "$[", "$[1", "$['a'", "$[a]", "$[::0]", "$[?(@.a <)]", "$x"} {
+		if _, err := parseJSONPath(path); err == nil {
+			t.Errorf("parseJSONPath(%q) returned no error", path)
+		}
+	}
+}
+
+func TestJSONPathLastKey(t *testing.T) {
+	p, _ := parseJSONPath("$.a['b'].c")
+
+	parent, key, ok := p.lastKey()
+	if !ok || key != "c" || len(parent.segments) != 2 {
+		t.Errorf("lastKey() = %v, %q, %v, expected parent with 2 segments and key \"c\"", parent, key, ok)
+	}
+
+	p, _ = parseJSONPath("$.a[0]")
+	if _, _, ok := p.lastKey(); ok {
+		t.Errorf("lastKey() of path ending with index returned ok")
+	}
+}
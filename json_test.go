@@ -0,0 +1,77 @@
+package main
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestParseJSONRoundTrip(t *testing.T) {
+	cases := []struct {
+		input    string
+		expected string
+	}{
+		{`{"b":1,"a":[true,false,null]}`, `{"b":1,"a":[true,false,null]}`},
+		{` [ 1 , 2.5 , -3e2 ] `, `[1,2.5,-300.0]`},
+		{`"é😀\n"`, `"é😀\n"`},
+		{`{}`, `{}`},
+		{`-0.5`, `-0.5`},
+	}
+
+	for _, c := range cases {
+		node, err := parseJSON(c.input)
+		if err != nil {
+			t.Errorf("parseJSON(%q) returned error: %v", c.input, err)
+			continue
+		}
+
+		if res := node.String(); res != c.expected {
+			t.Errorf("parseJSON(%q).String() = %v, expected: %v", c.input, res, c.expected)
+		}
+	}
+}
+
+func TestParseJSONInvalid(t *testing.T) {
+	for _, input := range []string{``, `{`, `[1,]`, `{"a" 1}`, `tru`, `"abc`, `1 2`, `{'a':1}`} {
+		if _, err := parseJSON(input); !errors.Is(err, ErrJSONInvalid) {
+			t.Errorf("parseJSON(%q) error = %v, expected: %v", input, err, ErrJSONInvalid)
+		}
+	}
+}
+
+func TestParseJSONTooDeep(t *testing.T) {
+	input := ""
+	for range jsonMaxDepth + 1 {
+		input += "["
+	}
+
+	if _, err := parseJSON(input); !errors.Is(err, ErrJSONTooDeep) {
+		t.Errorf("parseJSON of too deep document error = %v, expected: %v", err, ErrJSONTooDeep)
+	}
+}
+
+func TestJSONFormat(t *testing.T) {
+	node, _ := parseJSON(`{"a":[1],"b":{}}`)
+	expected := "{\n  \"a\": [\n    1\n  ],\n  \"b\": {}\n}"
+
+	res := node.format(jsonFormat{indent: "  ", newline: "\n", space: " "})
+
+	if res != expected {
+		t.Errorf("format() = %q, expected: %q", res, expected)
+	}
+}
+
+func TestJSONObjKeepsOrder(t *testing.T) {
+	obj := newJSONObj()
+	obj.set("z", &jsonNode{kind: jsonInt, i: 1})
+	obj.set("a", &jsonNode{kind: jsonInt, i: 2})
+	obj.set("z", &jsonNode{kind: jsonInt, i: 3})
+	obj.remove("a")
+	obj.set("m", &jsonNode{kind: jsonNull})
+
+	res := (&jsonNode{kind: jsonObject, obj: obj}).String()
+	expected := `{"z":3,"m":null}`
+
+	if res != expected {
+		t.Errorf("object = %v, expected: %v", res, expected)
+	}
+}
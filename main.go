@@ -11,25 +11,98 @@ import (
 )
 
 const (
-	SetCmd     = "SET"
-	GetCmd     = "GET"
-	DeleteCmd  = "DELETE"
-	CommandCmd = "COMMAND" // Command exists just for correct connection through redis-cli
+	MultiCmd   = "MULTI"
+	ExecCmd    = "EXEC"
+	DiscardCmd = "DISCARD"
+	WatchCmd   = "WATCH"
+	UnwatchCmd = "UNWATCH"
+
+	HelloCmd        = "HELLO"
+	ClientCmd       = "CLIENT"
+	MonitorCmd      = "MONITOR"
+	PingCmd         = "PING"
+	SubscribeCmd    = "SUBSCRIBE"
+	UnsubscribeCmd  = "UNSUBSCRIBE"
+	PsubscribeCmd   = "PSUBSCRIBE"
+	PunsubscribeCmd = "PUNSUBSCRIBE"
+	SsubscribeCmd   = "SSUBSCRIBE"
+	SunsubscribeCmd = "SUNSUBSCRIBE"
 )
 
 func main() {
 	var port int
+	var notifyEvents, saveRules, appendFsync, aofMinSize string
 	flag.IntVar(&port, "port", 8080, "Port to run application on")
+	flag.StringVar(&notifyEvents, "notify-keyspace-events", "", "Classes of keyspace events published through pub/sub")
+	flag.StringVar(&config.dir, "dir", ".", "Directory of persisted files")
+	flag.StringVar(&saveRules, "save", formatSaveRules(defaultSaveRules), "Pairs of seconds and changes, snapshot is saved when there were that many changes in that time, empty to turn off")
+	flag.StringVar(&config.dbFilename, "dbfilename", "dump.kdb", "Name of the snapshot file")
+	flag.BoolVar(&config.appendOnly, "appendonly", false, "Log every write command to the append-only file and replay it on start")
+	flag.StringVar(&appendFsync, "appendfsync", "everysec", "When the append-only file is synced to disk: always, everysec or no")
+	flag.StringVar(&config.appendFilename, "appendfilename", "appendonly.aof", "Name of the append-only file")
+	flag.StringVar(&config.appendDirname, "appenddirname", "appendonlydir", "Directory in dir with the append-only files and their manifest")
+	flag.Int64Var(&config.autoAOFRewritePercentage, "auto-aof-rewrite-percentage", 100, "Rewrite the append-only file when it has grown by this percentage since the last rewrite, 0 to turn off")
+	flag.StringVar(&aofMinSize, "auto-aof-rewrite-min-size", "64mb", "Minimum size of the append-only file for automatic rewrite")
+	flag.StringVar(&config.storageEngine, "storage-engine", defaultStorageEngine, "Engine keeping the keyspace: "+strings.Join(storageEngineNames(), ", "))
+	flag.BoolVar(&config.aofLoadTruncated, "aof-load-truncated", true, "Load append-only file ending in the middle of a command, cutting the incomplete command off")
 	flag.Parse()
 
+	flags, ok := parseNotifyFlags(notifyEvents)
+	if !ok {
+		log.Fatal("Invalid notify-keyspace-events: ", notifyEvents)
+	}
+	config.notifyKeyspaceEvents = flags
+
+	rules, ok := parseSaveRules(saveRules)
+	if !ok {
+		log.Fatal("Invalid save: ", saveRules)
+	}
+	config.saveRules = rules
+
+	policy, ok := parseFsyncPolicy(appendFsync)
+	if !ok {
+		log.Fatal("Invalid appendfsync: ", appendFsync)
+	}
+	config.appendFsync = policy
+
+	minSize, ok := parseMemory(aofMinSize)
+	if !ok {
+		log.Fatal("Invalid auto-aof-rewrite-min-size: ", aofMinSize)
+	}
+	config.autoAOFRewriteMinSize = minSize
+
+	if _, ok := storageEngines[config.storageEngine]; !ok {
+		log.Fatal("Unknown storage-engine: ", config.storageEngine, ", available: ", strings.Join(storageEngineNames(), ", "))
+	}
+
+	initStorage()
+
+	if err := loadModules(); err != nil {
+		log.Fatal("Error loading modules: ", err)
+	}
+
+	if err := loadLibrariesFile(); err != nil {
+		log.Fatal("Error loading function libraries: ", err)
+	}
+
+	// AOF has the latest data, so the snapshot is loaded only without it
+	if config.appendOnly {
+		if err := loadAOF(); err != nil {
+			log.Fatal("Error loading the append-only file: ", err)
+		}
+	} else if err := loadSnapshot(); err != nil {
+		log.Fatal("Error loading the snapshot: ", err)
+	}
+	go expireCron()
+	go aofCron()
+	go saveCron()
+
 	ln, err := net.Listen("tcp", fmt.Sprintf(":%v", port))
 	if err != nil {
 		log.Fatal("Error setting up tcp listener: ", err)
 	}
 	log.Println("Application started at port:", port)
 
-	initStorage()
-
 	for {
 		conn, err := ln.Accept()
 		if err != nil {
@@ -40,61 +113,82 @@ func main() {
 	}
 }
 
-func handleConnection(c net.Conn) {
-	defer c.Close()
+func handleConnection(conn net.Conn) {
+	c := newClient(conn)
+	defer c.close()
 	defer func() {
 		if r := recover(); r != nil {
 			log.Println("Panic occured: ", r)
-			c.Write([]byte(ErrServerSide.Error()))
+			c.write([]byte(ErrServerSide.Error()))
 		}
 	}()
+	defer unregisterClient(c)
+	defer pubsub.unsubscribeAll(c)
+	defer c.tx.close()
 
-	respReader := NewRespReader(c)
+	respReader := NewRespReader(conn)
 
 	for {
 		cmd, args, err := respReader.readCommand()
 		if err != nil {
 			if err == io.EOF {
 				log.Println("Client disconnected")
-				c.Close()
 				return
 			}
 
-			c.Write([]byte(err.Error()))
+			// connection was closed by us, e.g. because of output buffer limit
+			if c.isClosed() {
+				return
+			}
+
+			c.write([]byte(err.Error()))
 			continue
 		}
 
-		switch strings.ToUpper(cmd) {
-		case CommandCmd:
-			c.Write([]byte(OkResponse))
-		case SetCmd:
-			err = setHandler(args)
-			if err == nil {
-				c.Write([]byte(OkResponse))
-			}
-		case GetCmd:
-			res, err := getHandler(args)
-			if res == nil && err == nil {
-				c.Write([]byte(NullResponse))
-			} else if res != nil && err == nil {
-				response := kvsValueToResponse(res)
-				c.Write(response)
-			}
-		case DeleteCmd:
-			err = deleteHandler(args)
-			if err == nil {
-				c.Write([]byte(OkResponse))
-			}
-		default:
-			c.Write([]byte(ErrCmdNotSupported.Error()))
+		c.beginCommand(cmd, respReader.buffered())
+		res, err := c.dispatch(cmd, args)
+		c.endCommand()
+		if err != nil {
+			res = []byte(err.Error())
 		}
 
-		if err != nil {
-			c.Write([]byte(err.Error()))
+		if c.replyAllowed() {
+			c.write(res)
+		}
+		if c.closeAfterReply {
+			return
 		}
 	}
 }
 
+// dispatchCommand runs command of a connection with transaction state tx. Inside MULTI every command
+// except the transaction ones is queued
+func dispatchCommand(tx *txState, cmd string, args []*KvsValue) ([]byte, error) {
+	switch strings.ToUpper(cmd) {
+	case MultiCmd:
+		return tx.multi(args)
+	case ExecCmd:
+		return tx.exec(args)
+	case DiscardCmd:
+		return tx.discard(args)
+	case WatchCmd:
+		return tx.watch(args)
+	case UnwatchCmd:
+		return tx.unwatch(args)
+	}
+
+	if tx.active {
+		return tx.enqueue(cmd, args)
+	}
+
+	cmdDef, ok := lookupCommand(cmd)
+	if !ok {
+		return nil, ErrCmdNotSupported
+	}
+
+	return execCommand(tx.client, cmdDef, args)
+}
+
 func kvsValueToResponse(kvsValue *KvsValue) []byte {
 	var sb strings.Builder
 	var dataValue string
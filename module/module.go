@@ -0,0 +1,137 @@
+// Package module is the API for extending KVS with commands and value types without changing the server.
+//
+// A module is a Go package that registers its commands and types from init:
+//
+//	func init() {
+//		module.RegisterType(counterType)
+//		module.RegisterCommand(module.Command{Name: "COUNTER.INCR", Arity: 2, Flags: module.Write, Handler: incr})
+//	}
+//
+// and is linked into the server with a blank import in modules.go. Commands and types are picked up on start,
+// a name clashing with a built-in command or with another module stops the server.
+//
+// Handlers run atomically like built-in commands, with the keyspace locked, so they must not block.
+// Keyspace and ReplyWriter passed to a handler are valid until it returns
+package module
+
+import (
+	"errors"
+	"sync"
+)
+
+// Flags describe what a command does
+type Flags int
+
+const (
+	// Write is set for commands that may modify the keyspace. Commands without it are allowed in read-only
+	// scripts and keys they read are tracked for client side caching
+	Write Flags = 1 << iota
+	// NoScript commands can't be called from scripts and functions
+	NoScript
+)
+
+// Handler runs a command with its arguments, command name excluded. It writes a single reply to w, or
+// returns an error that is sent to the client instead of anything written. The error message should
+// start with an error code like "ERR". A handler that writes nothing replies with OK
+type Handler func(ks Keyspace, w ReplyWriter, args [][]byte) error
+
+type Command struct {
+	// Name is case insensitive, it's usually prefixed with the module name like "COUNTER.INCR"
+	Name string
+	// Arity counts command name too. Negative arity means "at least -Arity arguments"
+	Arity   int
+	Flags   Flags
+	Handler Handler
+}
+
+// Type is a custom value type. Values of a type are whatever Load returns and handlers store with
+// Keyspace.SetValue
+type Type struct {
+	// Name identifies the type in DUMP payloads and snapshots, so it must not change
+	Name string
+	// Version of the encoding produced by Save, it's passed to Load to read older encodings
+	Version uint32
+	// Save encodes value for DUMP and snapshots. Required
+	Save func(v any) []byte
+	// Load decodes value saved by Save with encoding version. Required
+	Load func(data []byte, version uint32) (any, error)
+	// MemUsage returns approximate number of bytes used by value. Optional, length of the
+	// encoding is used without it
+	MemUsage func(v any) int
+	// Copy returns independent copy of value for COPY. Optional, the value is saved and loaded without it
+	Copy func(v any) any
+	// Free is called when value is deleted, overwritten or expired. Optional
+	Free func(v any)
+}
+
+// ErrWrongType is returned by Keyspace when key holds a value of another type. Handlers may return it as is
+var ErrWrongType = errors.New("WRONGTYPE Operation against a key holding the wrong kind of value")
+
+// Keyspace is the handle to stored values given to handlers. Changes made through it bump key versions,
+// publish keyspace events and invalidate client caches the same way built-in commands do
+type Keyspace interface {
+	// Get returns string value of key, ok is false if key doesn't exist
+	Get(key string) (val []byte, ok bool, err error)
+	// Set stores string value at key, replacing any value and its time to live
+	Set(key string, val []byte)
+	// Value returns value of type t stored at key, ok is false if key doesn't exist
+	Value(key string, t *Type) (v any, ok bool, err error)
+	// SetValue stores value of type t at key, replacing any value and its time to live
+	SetValue(key string, t *Type, v any)
+	// Delete deletes key and tells whether it existed
+	Delete(key string) bool
+	// Modified must be called after value returned by Value is changed in place. event is published
+	// as keyspace event of module class
+	Modified(key string, event string)
+}
+
+// ReplyWriter builds the reply of a command. Array and Map are followed by replies of their elements,
+// Map takes keys and values one after another and is sent as a flat array to RESP2 clients
+type ReplyWriter interface {
+	SimpleString(s string)
+	Error(msg string)
+	Int(n int64)
+	Bulk(b []byte)
+	Float(f float64)
+	Null()
+	Array(n int)
+	Map(n int)
+}
+
+var registry struct {
+	mu       sync.Mutex
+	commands []Command
+	types    []*Type
+}
+
+// RegisterCommand adds command to the server, it must be called from init
+func RegisterCommand(cmd Command) {
+	registry.mu.Lock()
+	defer registry.mu.Unlock()
+
+	registry.commands = append(registry.commands, cmd)
+}
+
+// RegisterType adds value type to the server, it must be called from init
+func RegisterType(t *Type) {
+	registry.mu.Lock()
+	defer registry.mu.Unlock()
+
+	registry.types = append(registry.types, t)
+}
+
+// Commands returns registered commands, it's used by the server
+func Commands() []Command {
+	registry.mu.Lock()
+	defer registry.mu.Unlock()
+
+	return append([]Command(nil), registry.commands...)
+}
+
+// Types returns registered types, it's used by the server
+func Types() []*Type {
+	registry.mu.Lock()
+	defer registry.mu.Unlock()
+
+	return append([]*Type(nil), registry.types...)
+}
@@ -0,0 +1,189 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/dmitrenko-v/kvs/module"
+	// Modules are linked into the server by importing them here, like
+	// _ "example.com/kvs-counter"
+)
+
+// Values of module types are stored with ModuleDtype, object holds the type and the value
+type moduleValue struct {
+	typ *module.Type
+	v   any
+}
+
+// moduleTypes are registered types by name, filled on start
+var moduleTypes = map[string]*module.Type{}
+
+// loadModules adds commands and types registered by linked modules
+func loadModules() error {
+	for _, t := range module.Types() {
+		if t.Name == "" || t.Save == nil || t.Load == nil {
+			return fmt.Errorf("module type %q must have name, Save and Load", t.Name)
+		}
+		if _, ok := moduleTypes[t.Name]; ok {
+			return fmt.Errorf("module type %q is registered twice", t.Name)
+		}
+		moduleTypes[t.Name] = t
+	}
+
+	for _, mc := range module.Commands() {
+		name := strings.ToUpper(mc.Name)
+		if name == "" || mc.Arity == 0 || mc.Handler == nil {
+			return fmt.Errorf("module command %q must have name, arity and handler", mc.Name)
+		}
+		if _, ok := commandTable[name]; ok {
+			return fmt.Errorf("module command %q clashes with an existing command", mc.Name)
+		}
+
+		commandTable[name] = &command{
+			name:     name,
+			arity:    mc.Arity,
+			handler:  moduleHandler(mc.Handler),
+			write:    mc.Flags&module.Write != 0,
+			noScript: mc.Flags&module.NoScript != 0,
+		}
+	}
+
+	return nil
+}
+
+func moduleHandler(h module.Handler) cmdHandler {
+	return func(args []*KvsValue) ([]byte, error) {
+		margs := make([][]byte, len(args))
+		for i, arg := range args {
+			margs[i] = []byte(argToString(arg))
+		}
+
+		w := &moduleReply{resp: 2}
+		if kvs.client != nil {
+			w.resp = kvs.client.resp
+		}
+
+		if err := h(moduleKeyspace{}, w, margs); err != nil {
+			if errors.Is(err, module.ErrWrongType) {
+				return nil, ErrWrongType
+			}
+			return nil, moduleErr(err.Error())
+		}
+
+		if w.buf == nil {
+			return []byte(OkResponse), nil
+		}
+
+		return w.buf, nil
+	}
+}
+
+// freeModuleValue lets the type of val release it. Must be called with kvs.mu held once val is dropped
+// from the keyspace
+func freeModuleValue(val *KvsValue) {
+	if mv, ok := val.object.(*moduleValue); ok && mv.typ.Free != nil {
+		mv.typ.Free(mv.v)
+	}
+}
+
+// moduleKeyspace is module.Keyspace over kvs, it's used with kvs.mu held
+type moduleKeyspace struct{}
+
+func (moduleKeyspace) Get(key string) ([]byte, bool, error) {
+	val, ok := lookupKey(key)
+	if !ok {
+		return nil, false, nil
+	}
+	if !val.isScalar() {
+		return nil, true, module.ErrWrongType
+	}
+
+	return []byte(argToString(val)), true, nil
+}
+
+func (moduleKeyspace) Set(key string, val []byte) {
+	storeKey(key, &KvsValue{dtype: BulkStrSymbol, value: bytes.Clone(val)})
+	signalModifiedKey(key, notifyString, "set")
+}
+
+func (moduleKeyspace) Value(key string, t *module.Type) (any, bool, error) {
+	val, ok := lookupKey(key)
+	if !ok {
+		return nil, false, nil
+	}
+
+	mv, isModule := val.object.(*moduleValue)
+	if !isModule || mv.typ != t {
+		return nil, true, module.ErrWrongType
+	}
+
+	return mv.v, true, nil
+}
+
+func (moduleKeyspace) SetValue(key string, t *module.Type, v any) {
+	if moduleTypes[t.Name] != t {
+		panic("module type " + t.Name + " is not registered")
+	}
+
+	storeKey(key, &KvsValue{dtype: ModuleDtype, object: &moduleValue{typ: t, v: v}})
+	signalModifiedKey(key, notifyModule, "set")
+}
+
+func (moduleKeyspace) Delete(key string) bool {
+	if !removeKey(key) {
+		return false
+	}
+	signalModifiedKey(key, notifyGeneric, "del")
+
+	return true
+}
+
+func (moduleKeyspace) Modified(key string, event string) {
+	signalModifiedKey(key, notifyModule, event)
+}
+
+// moduleReply is module.ReplyWriter building RESP reply for protocol version resp
+type moduleReply struct {
+	resp int
+	buf  []byte
+}
+
+func (w *moduleReply) SimpleString(s string) {
+	w.buf = append(w.buf, simpleStrResponse(s)...)
+}
+
+func (w *moduleReply) Error(msg string) {
+	w.buf = append(w.buf, moduleErr(msg).Error()...)
+}
+
+func (w *moduleReply) Int(n int64) {
+	w.buf = append(w.buf, intResponse(n)...)
+}
+
+func (w *moduleReply) Bulk(b []byte) {
+	w.buf = append(w.buf, bulkStrResponse(b)...)
+}
+
+func (w *moduleReply) Float(f float64) {
+	w.buf = append(w.buf, bulkStrResponse([]byte(strconv.FormatFloat(f, 'g', -1, 64)))...)
+}
+
+func (w *moduleReply) Null() {
+	w.buf = append(w.buf, NullResponse...)
+}
+
+func (w *moduleReply) Array(n int) {
+	w.buf = append(w.buf, aggregateResponse(ArrSymbol, n, nil)...)
+}
+
+func (w *moduleReply) Map(n int) {
+	if w.resp == 3 {
+		w.buf = append(w.buf, aggregateResponse(MapSymbol, n, nil)...)
+		return
+	}
+
+	w.buf = append(w.buf, aggregateResponse(ArrSymbol, 2*n, nil)...)
+}
@@ -0,0 +1,186 @@
+package main
+
+import (
+	"encoding/binary"
+	"errors"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/dmitrenko-v/kvs/module"
+)
+
+// counterType stores *int64 values, freed counters are counted to check that Free is called
+var (
+	freedCounters int
+	counterType   = &module.Type{
+		Name:    "counter",
+		Version: 1,
+		Save:    func(v any) []byte { return binary.LittleEndian.AppendUint64(nil, uint64(*v.(*int64))) },
+		Load: func(data []byte, version uint32) (any, error) {
+			if version != 1 || len(data) != 8 {
+				return nil, errors.New("bad counter")
+			}
+			n := int64(binary.LittleEndian.Uint64(data))
+			return &n, nil
+		},
+		Copy: func(v any) any { n := *v.(*int64); return &n },
+		Free: func(v any) { freedCounters++ },
+	}
+)
+
+func counterIncr(ks module.Keyspace, w module.ReplyWriter, args [][]byte) error {
+	by := int64(1)
+	if len(args) == 2 {
+		n, err := strconv.ParseInt(string(args[1]), 10, 64)
+		if err != nil {
+			return errors.New("ERR increment is not an integer")
+		}
+		by = n
+	}
+
+	key := string(args[0])
+	v, ok, err := ks.Value(key, counterType)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		n := by
+		ks.SetValue(key, counterType, &n)
+		w.Int(n)
+		return nil
+	}
+
+	*v.(*int64) += by
+	ks.Modified(key, "counter.incr")
+	w.Int(*v.(*int64))
+
+	return nil
+}
+
+func counterInfo(ks module.Keyspace, w module.ReplyWriter, args [][]byte) error {
+	v, ok, err := ks.Value(string(args[0]), counterType)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		w.Null()
+		return nil
+	}
+
+	w.Map(2)
+	w.Bulk([]byte("value"))
+	w.Int(*v.(*int64))
+	w.Bulk([]byte("even"))
+	w.Array(1)
+	w.SimpleString(strconv.FormatBool(*v.(*int64)%2 == 0))
+
+	return nil
+}
+
+var loadTestModule = sync.OnceValue(func() error {
+	module.RegisterType(counterType)
+	module.RegisterCommand(module.Command{Name: "counter.incr", Arity: -2, Flags: module.Write, Handler: counterIncr})
+	module.RegisterCommand(module.Command{Name: "COUNTER.INFO", Arity: 2, Handler: counterInfo})
+	module.RegisterCommand(module.Command{Name: "COUNTER.RESET", Arity: 2, Flags: module.Write | module.NoScript,
+		Handler: func(ks module.Keyspace, w module.ReplyWriter, args [][]byte) error {
+			ks.Delete(string(args[0]))
+			return nil
+		}})
+
+	return loadModules()
+})
+
+func TestModuleCommands(t *testing.T) {
+	if err := loadTestModule(); err != nil {
+		t.Fatal(err)
+	}
+	initStorage()
+	freedCounters = 0
+
+	var tx txState
+	for _, step := range []struct {
+		cmd   []string
+		reply string
+	}{
+		{[]string{"COUNTER.INCR", "c"}, ":1\r\n"},
+		{[]string{"COUNTER.INCR", "c", "41"}, ":42\r\n"},
+		{[]string{"COUNTER.INCR", "c", "x"}, "-ERR increment is not an integer\r\n"},
+		{[]string{"COUNTER.INCR"}, wrongArgsCountErr("COUNTER.INCR").Error()},
+		{[]string{"COUNTER.INFO", "c"}, "*4\r\n$5\r\nvalue\r\n:42\r\n$4\r\neven\r\n*1\r\n+true\r\n"},
+		{[]string{"COUNTER.INFO", "nope"}, NullResponse},
+		{[]string{"GET", "c"}, ErrWrongType.Error()},
+		{[]string{"SET", "s", "v"}, OkResponse},
+		{[]string{"COUNTER.INCR", "s"}, ErrWrongType.Error()},
+		{[]string{"EVAL", "return redis.call('COUNTER.INCR', KEYS[1])", "1", "c"}, ":43\r\n"},
+		{[]string{"EVAL_RO", "return redis.call('COUNTER.INCR', KEYS[1])", "1", "c"}, ErrScriptReadOnly.Error()},
+		{[]string{"EVAL", "return redis.call('COUNTER.RESET', KEYS[1])", "1", "c"}, ErrScriptForbiddenCmd.Error()},
+		{[]string{"COUNTER.RESET", "c"}, OkResponse},
+		{[]string{"COUNTER.INFO", "c"}, NullResponse},
+	} {
+		if got := dispatchTest(t, &tx, step.cmd[0], step.cmd[1:]...); got != step.reply {
+			t.Errorf("%v: got %q, expected: %q", step.cmd, got, step.reply)
+		}
+	}
+
+	if freedCounters != 1 {
+		t.Errorf("%d counters are freed, expected: 1", freedCounters)
+	}
+}
+
+func TestDumpRestore(t *testing.T) {
+	if err := loadTestModule(); err != nil {
+		t.Fatal(err)
+	}
+	initStorage()
+	freedCounters = 0
+
+	var tx txState
+	dispatchTest(t, &tx, "COUNTER.INCR", "c", "7")
+	dispatchTest(t, &tx, "SET", "s", "hello")
+
+	payload := func(key string) string {
+		dump := dispatchTest(t, &tx, "DUMP", key)
+		return dump[strings.Index(dump, "\r\n")+2 : len(dump)-2]
+	}
+	counter, str := payload("c"), payload("s")
+
+	corrupted := []byte(counter)
+	corrupted[1] ^= 0xff
+
+	for _, step := range []struct {
+		cmd   []string
+		reply string
+	}{
+		{[]string{"DUMP", "nope"}, NullResponse},
+		{[]string{"RESTORE", "c", "0", counter}, ErrBusyKey.Error()},
+		{[]string{"RESTORE", "c2", "0", string(corrupted)}, ErrDumpBadPayload.Error()},
+		{[]string{"RESTORE", "c2", "-1", counter}, ErrInvalidTTL.Error()},
+		{[]string{"RESTORE", "c2", "0", counter}, OkResponse},
+		{[]string{"COUNTER.INCR", "c2"}, ":8\r\n"},
+		{[]string{"RESTORE", "c", "0", str, "REPLACE"}, OkResponse},
+		{[]string{"GET", "c"}, "$5\r\nhello\r\n"},
+		{[]string{"RESTORE", "c2", "1", str, "REPLACE", "ABSTTL"}, OkResponse},
+		{[]string{"GET", "c2"}, NullResponse},
+		{[]string{"COUNTER.INCR", "n", "3"}, ":3\r\n"},
+		{[]string{"COPY", "n", "n2"}, ":1\r\n"},
+		{[]string{"COPY", "n", "n2"}, ":0\r\n"},
+		{[]string{"COUNTER.INCR", "n2"}, ":4\r\n"},
+		{[]string{"COUNTER.INFO", "n"}, "*4\r\n$5\r\nvalue\r\n:3\r\n$4\r\neven\r\n*1\r\n+false\r\n"},
+		{[]string{"COPY", "s", "n2", "REPLACE"}, ":1\r\n"},
+		{[]string{"GET", "n2"}, "$5\r\nhello\r\n"},
+		{[]string{"MEMORY", "USAGE", "s"}, ":70\r\n"},
+		{[]string{"MEMORY", "USAGE", "nope"}, NullResponse},
+	} {
+		got := dispatchTest(t, &tx, step.cmd[0], step.cmd[1:]...)
+		if step.reply != "" && got != step.reply {
+			t.Errorf("%v: got %q, expected: %q", step.cmd[:2], got, step.reply)
+		}
+	}
+
+	// c, c2 and n2 held counters that were replaced
+	if freedCounters != 3 {
+		t.Errorf("%d counters are freed, expected: 3", freedCounters)
+	}
+}
@@ -0,0 +1,122 @@
+package main
+
+import (
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// MONITOR turns the connection into a monitor that gets every command received by the server as
+// +<unix time with microseconds> [<db> <client address>] "<arg>" ... line. Commands run by scripts and
+// functions have lua as address. Lines are queued like pub/sub messages, so a slow monitor never blocks
+// commands and is disconnected once it falls pubsubOutputLimit behind
+const monitorScriptSource = "lua"
+
+var monitors = struct {
+	mu      sync.Mutex
+	clients map[*client]struct{}
+	// number of monitors, checked without the lock so commands don't pay for formatting when there are none
+	n atomic.Int32
+}{clients: make(map[*client]struct{})}
+
+func addMonitor(c *client) {
+	monitors.mu.Lock()
+	defer monitors.mu.Unlock()
+
+	if _, ok := monitors.clients[c]; !ok {
+		monitors.clients[c] = struct{}{}
+		monitors.n.Add(1)
+	}
+}
+
+func removeMonitor(c *client) {
+	monitors.mu.Lock()
+	defer monitors.mu.Unlock()
+
+	if _, ok := monitors.clients[c]; ok {
+		delete(monitors.clients, c)
+		monitors.n.Add(-1)
+	}
+}
+
+// feedMonitors sends command received from source to every monitor
+func feedMonitors(source string, cmd string, args []*KvsValue) {
+	if monitors.n.Load() == 0 {
+		return
+	}
+
+	line := monitorLine(time.Now(), source, cmd, args)
+
+	monitors.mu.Lock()
+	defer monitors.mu.Unlock()
+
+	for m := range monitors.clients {
+		m.push(line)
+	}
+}
+
+func monitorLine(now time.Time, source string, cmd string, args []*KvsValue) []byte {
+	b := []byte{SimpleStrSymbol}
+	b = strconv.AppendInt(b, now.Unix(), 10)
+	b = append(b, '.')
+	b = append(b, strconv.Itoa(1000000 + now.Nanosecond()/1000)[1:]...)
+	b = append(b, " [0 "...)
+	b = append(b, source...)
+	b = append(b, ']')
+
+	b = append(b, ' ')
+	b = appendMonitorQuoted(b, cmd)
+
+	// passwords are never shown: all args of AUTH and username with password after HELLO's AUTH
+	redacted := 0
+	name := strings.ToUpper(cmd)
+	for i, arg := range args {
+		s := argToString(arg)
+		switch {
+		case name == "AUTH":
+			s = "(redacted)"
+		case redacted > 0:
+			s = "(redacted)"
+			redacted--
+		case name == HelloCmd && i > 0 && strings.ToUpper(s) == "AUTH":
+			redacted = 2
+		}
+
+		b = append(b, ' ')
+		b = appendMonitorQuoted(b, s)
+	}
+
+	return append(b, CRLF...)
+}
+
+// appendMonitorQuoted appends s in double quotes with special and non-printable characters escaped
+func appendMonitorQuoted(b []byte, s string) []byte {
+	b = append(b, '"')
+
+	for i := 0; i < len(s); i++ {
+		switch ch := s[i]; ch {
+		case '\\', '"':
+			b = append(b, '\\', ch)
+		case '\n':
+			b = append(b, `\n`...)
+		case '\r':
+			b = append(b, `\r`...)
+		case '\t':
+			b = append(b, `\t`...)
+		case '\a':
+			b = append(b, `\a`...)
+		case '\b':
+			b = append(b, `\b`...)
+		default:
+			if ch < 0x20 || ch >= 0x7f {
+				b = append(b, '\\', 'x', "0123456789abcdef"[ch>>4], "0123456789abcdef"[ch&0xf])
+				continue
+			}
+			b = append(b, ch)
+		}
+	}
+
+	return append(b, '"')
+}
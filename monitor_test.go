@@ -0,0 +1,61 @@
+package main
+
+import (
+	"regexp"
+	"testing"
+	"time"
+)
+
+func TestMonitorLine(t *testing.T) {
+	now := time.Unix(1339518083, 107412000)
+
+	for _, tc := range []struct {
+		cmd      string
+		args     []string
+		expected string
+	}{
+		{"set", []string{"k", "a \"b\"\n\x01"}, `+1339518083.107412 [0 10.0.0.1:5000] "set" "k" "a \"b\"\n\x01"` + CRLF},
+		{"AUTH", []string{"user", "secret"}, `+1339518083.107412 [0 10.0.0.1:5000] "AUTH" "(redacted)" "(redacted)"` + CRLF},
+		{"hello", []string{"3", "auth", "user", "secret", "SETNAME", "app"},
+			`+1339518083.107412 [0 10.0.0.1:5000] "hello" "3" "auth" "(redacted)" "(redacted)" "SETNAME" "app"` + CRLF},
+	} {
+		if got := string(monitorLine(now, "10.0.0.1:5000", tc.cmd, bulkArgs(tc.args...))); got != tc.expected {
+			t.Errorf("%s: got %q, expected: %q", tc.cmd, got, tc.expected)
+		}
+	}
+}
+
+func TestMonitor(t *testing.T) {
+	initStorage()
+
+	m, r := pipeClient(t)
+	t.Cleanup(func() { unregisterClient(m) })
+	if res, err := m.dispatch("MONITOR", nil); err != nil || string(res) != OkResponse {
+		t.Fatalf("MONITOR: %q, %v", res, err)
+	}
+
+	c, _ := pipeClient(t)
+	t.Cleanup(func() { unregisterClient(c) })
+	c.dispatch("SET", bulkArgs("k", "v"))
+	c.dispatch("EVAL", bulkArgs("return redis.call('GET', KEYS[1])", "1", "k"))
+
+	for _, expected := range []string{
+		`^\+\d+\.\d{6} \[0 pipe\] "SET" "k" "v"\r\n$`,
+		`^\+\d+\.\d{6} \[0 pipe\] "EVAL" "return redis.call\('GET', KEYS\[1\]\)" "1" "k"\r\n$`,
+		`^\+\d+\.\d{6} \[0 lua\] "GET" "k"\r\n$`,
+	} {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !regexp.MustCompile(expected).MatchString(line) {
+			t.Errorf("got %q, expected to match: %q", line, expected)
+		}
+	}
+
+	// closed monitors don't get anything
+	unregisterClient(m)
+	if n := monitors.n.Load(); n != 0 {
+		t.Errorf("%d monitors left", n)
+	}
+}
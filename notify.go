@@ -0,0 +1,104 @@
+package main
+
+import "strings"
+
+// Keyspace notifications are published through pub/sub when enabled with notify-keyspace-events.
+// Every event belongs to a class, and is published to __keyspace@0__:<key> with event name as message
+// if K is set and to __keyevent@0__:<event> with key as message if E is set. KVS has a single database,
+// so db is always 0
+const (
+	notifyKeyspace = 1 << iota // K
+	notifyKeyevent             // E
+	notifyGeneric              // g: del, expire, rename_from, rename_to
+	notifyString               // $
+	notifyList                 // l
+	notifySet                  // s
+	notifyHash                 // h
+	notifyZset                 // z
+	notifyExpired              // x
+	notifyEvicted              // e
+	notifyStream               // t
+	notifyKeyMiss              // m
+	notifyModule               // d: JSON, probabilistic, time series, vector set, graph and throttle commands
+	notifyNew                  // n
+
+	// A is alias for all classes except m and n
+	notifyAll = notifyGeneric | notifyString | notifyList | notifySet | notifyHash | notifyZset |
+		notifyExpired | notifyEvicted | notifyStream | notifyModule
+)
+
+var notifyFlagLetters = []struct {
+	letter byte
+	flag   int
+}{
+	{'g', notifyGeneric},
+	{'$', notifyString},
+	{'l', notifyList},
+	{'s', notifySet},
+	{'h', notifyHash},
+	{'z', notifyZset},
+	{'x', notifyExpired},
+	{'e', notifyEvicted},
+	{'t', notifyStream},
+	{'m', notifyKeyMiss},
+	{'d', notifyModule},
+	{'n', notifyNew},
+	{'K', notifyKeyspace},
+	{'E', notifyKeyevent},
+}
+
+func parseNotifyFlags(s string) (int, bool) {
+	flags := 0
+
+outer:
+	for i := range len(s) {
+		if s[i] == 'A' {
+			flags |= notifyAll
+			continue
+		}
+
+		for _, l := range notifyFlagLetters {
+			if s[i] == l.letter {
+				flags |= l.flag
+				continue outer
+			}
+		}
+
+		return 0, false
+	}
+
+	return flags, true
+}
+
+// notifyFlagsString is the inverse of parseNotifyFlags, A is used when all classes it covers are set
+func notifyFlagsString(flags int) string {
+	var sb strings.Builder
+
+	if flags&notifyAll == notifyAll {
+		sb.WriteByte('A')
+		flags &^= notifyAll
+	}
+
+	for _, l := range notifyFlagLetters {
+		if flags&l.flag != 0 {
+			sb.WriteByte(l.letter)
+		}
+	}
+
+	return sb.String()
+}
+
+// notifyKeyspaceEvent publishes event of class about key if the class is enabled. Must be called with kvs.mu held
+func notifyKeyspaceEvent(class int, event, key string) {
+	flags := config.notifyKeyspaceEvents
+	if flags&class == 0 {
+		return
+	}
+
+	if flags&notifyKeyspace != 0 {
+		pubsub.publish("__keyspace@0__:"+key, []byte(event))
+	}
+	if flags&notifyKeyevent != 0 {
+		pubsub.publish("__keyevent@0__:"+event, []byte(key))
+	}
+}
@@ -0,0 +1,63 @@
+package main
+
+import "testing"
+
+func TestNotifyFlags(t *testing.T) {
+	for _, tc := range []struct {
+		in, out string
+		ok      bool
+	}{
+		{"", "", true},
+		{"KEA", "AKE", true},
+		{"Eg$", "g$E", true},
+		{"KAmn", "AmnK", true},
+		{"Kgx$lshzetd", "AK", true},
+		{"KEq", "", false},
+	} {
+		flags, ok := parseNotifyFlags(tc.in)
+		if ok != tc.ok {
+			t.Errorf("parseNotifyFlags(%q) ok = %v, expected: %v", tc.in, ok, tc.ok)
+			continue
+		}
+		if got := notifyFlagsString(flags); ok && got != tc.out {
+			t.Errorf("notifyFlagsString(parseNotifyFlags(%q)) = %q, expected: %q", tc.in, got, tc.out)
+		}
+	}
+}
+
+func TestKeyspaceEvents(t *testing.T) {
+	initStorage()
+	defer func() { config.notifyKeyspaceEvents = 0 }()
+
+	c, r := pipeClient(t)
+	pubsub.subscribe(c, subPattern, []string{"__keyevent@0__:*"})
+	defer pubsub.unsubscribeAll(c)
+	readReply(t, r, "*3\r\n$10\r\npsubscribe\r\n$16\r\n__keyevent@0__:*\r\n:1\r\n")
+
+	config.notifyKeyspaceEvents, _ = parseNotifyFlags("Eghm")
+
+	var tx txState
+	for _, cmd := range [][]string{
+		{"SET", "k", "v"}, // string events are disabled
+		{"HSET", "h", "f", "v"},
+		{"RENAME", "h", "h2"},
+		{"HDEL", "h2", "f"},
+		{"HGET", "missing", "f"},
+	} {
+		dispatchTest(t, &tx, cmd[0], cmd[1:]...)
+	}
+
+	for _, ev := range [][2]string{
+		{"hset", "h"},
+		{"rename_from", "h"},
+		{"rename_to", "h2"},
+		{"hdel", "h2"},
+		{"del", "h2"},
+		{"keymiss", "missing"},
+	} {
+		channel := "__keyevent@0__:" + ev[0]
+		readReply(t, r, string(pushResponse(2,
+			bulkStrResponse([]byte("pmessage")), bulkStrResponse([]byte("__keyevent@0__:*")),
+			bulkStrResponse([]byte(channel)), bulkStrResponse([]byte(ev[1])))))
+	}
+}
@@ -0,0 +1,76 @@
+package main
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// CLIENT PAUSE holds commands of all clients until the pause ends. In WRITE mode only commands that may
+// write wait, EXEC counts as such if anything it runs may write. CLIENT commands never wait, so a pause
+// can always be ended with CLIENT UNPAUSE
+var pause struct {
+	mu sync.Mutex
+	// unix ms when the pause ends, 0 if clients aren't paused. Checked without the lock before every command
+	until atomic.Int64
+	all   bool
+	// closed and replaced when pause is changed
+	changed chan struct{}
+}
+
+func init() {
+	pause.changed = make(chan struct{})
+}
+
+// pauseClients pauses clients until unix ms. A pause in progress is only extended and made stricter
+func pauseClients(until int64, all bool) {
+	pause.mu.Lock()
+	defer pause.mu.Unlock()
+
+	if pause.until.Load() > nowMs() {
+		until = max(until, pause.until.Load())
+		all = all || pause.all
+	}
+
+	pause.until.Store(until)
+	pause.all = all
+	close(pause.changed)
+	pause.changed = make(chan struct{})
+}
+
+func unpauseClients() {
+	pause.mu.Lock()
+	defer pause.mu.Unlock()
+
+	pause.until.Store(0)
+	pause.all = false
+	close(pause.changed)
+	pause.changed = make(chan struct{})
+}
+
+// waitUnpaused returns once command that may write or not isn't paused, or false once done is closed
+func waitUnpaused(done <-chan struct{}, write bool) bool {
+	for {
+		if until := pause.until.Load(); until == 0 || until <= nowMs() {
+			return true
+		}
+
+		pause.mu.Lock()
+		until, paused, changed := pause.until.Load(), pause.all || write, pause.changed
+		pause.mu.Unlock()
+
+		if !paused {
+			return true
+		}
+
+		timer := time.NewTimer(time.Duration(until-nowMs()) * time.Millisecond)
+		select {
+		case <-changed:
+		case <-timer.C:
+		case <-done:
+			timer.Stop()
+			return false
+		}
+		timer.Stop()
+	}
+}
@@ -0,0 +1,202 @@
+package main
+
+import (
+	"maps"
+	"slices"
+	"sync"
+)
+
+// subscription kinds
+const (
+	subChannel = iota
+	subPattern
+	subShard
+	subKinds
+)
+
+var subKindOf = map[string]int{
+	SubscribeCmd: subChannel, UnsubscribeCmd: subChannel,
+	PsubscribeCmd: subPattern, PunsubscribeCmd: subPattern,
+	SsubscribeCmd: subShard, SunsubscribeCmd: subShard,
+}
+
+// names of subscribe and unsubscribe confirmations for every kind
+var subReplyNames = [subKinds][2]string{
+	{"subscribe", "unsubscribe"},
+	{"psubscribe", "punsubscribe"},
+	{"ssubscribe", "sunsubscribe"},
+}
+
+// pubsubRegistry maps channels, patterns and shard channels to subscribed clients. Lock order is kvs.mu
+// before mu, so commands may publish while holding kvs.mu. There's a single node, so shard channels
+// behave like regular ones, just in a separate namespace
+type pubsubRegistry struct {
+	mu   sync.Mutex
+	subs [subKinds]map[string]map[*client]struct{}
+}
+
+var pubsub = newPubsubRegistry()
+
+func newPubsubRegistry() *pubsubRegistry {
+	p := &pubsubRegistry{}
+	for kind := range p.subs {
+		p.subs[kind] = make(map[string]map[*client]struct{})
+	}
+
+	return p
+}
+
+func (p *pubsubRegistry) subscribe(c *client, kind int, names []string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	for _, name := range names {
+		if _, ok := c.subs[kind][name]; !ok {
+			c.subs[kind][name] = struct{}{}
+			if p.subs[kind][name] == nil {
+				p.subs[kind][name] = make(map[*client]struct{})
+			}
+			p.subs[kind][name][c] = struct{}{}
+		}
+
+		c.write(subscriptionResponse(c, kind, 0, []byte(name)))
+	}
+}
+
+// unsubscribe removes client from names, or from everything of kind if names are empty
+func (p *pubsubRegistry) unsubscribe(c *client, kind int, names []string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if len(names) == 0 {
+		if len(c.subs[kind]) == 0 {
+			c.write(subscriptionResponse(c, kind, 1, nil))
+			return
+		}
+		names = slices.Sorted(maps.Keys(c.subs[kind]))
+	}
+
+	for _, name := range names {
+		p.remove(c, kind, name)
+		c.write(subscriptionResponse(c, kind, 1, []byte(name)))
+	}
+}
+
+// unsubscribeAll is called when connection is closed
+func (p *pubsubRegistry) unsubscribeAll(c *client) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	for kind := range c.subs {
+		for name := range c.subs[kind] {
+			p.remove(c, kind, name)
+		}
+	}
+}
+
+func (p *pubsubRegistry) remove(c *client, kind int, name string) {
+	delete(c.subs[kind], name)
+
+	clients := p.subs[kind][name]
+	delete(clients, c)
+	if len(clients) == 0 {
+		delete(p.subs[kind], name)
+	}
+}
+
+// subscriptionResponse confirms (un)subscription, which is 0 for subscribe and 1 for unsubscribe.
+// nil name is reported when client unsubscribes from everything without being subscribed
+func subscriptionResponse(c *client, kind, which int, name []byte) []byte {
+	nameResp := []byte(NullResponse)
+	if name != nil {
+		nameResp = bulkStrResponse(name)
+	}
+
+	return pushResponse(c.resp,
+		bulkStrResponse([]byte(subReplyNames[kind][which])),
+		nameResp,
+		intResponse(int64(c.subscriptionCount(kind))),
+	)
+}
+
+// publish sends message to subscribers of channel and of patterns matching it. Returns number of
+// receivers, a client subscribed to both channel and pattern is counted twice the way Redis does
+func (p *pubsubRegistry) publish(channel string, message []byte) int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	n := 0
+	var msg [2][]byte // encoded for RESP2 and RESP3 clients
+
+	for c := range p.subs[subChannel][channel] {
+		if msg[c.resp-2] == nil {
+			msg[c.resp-2] = pushResponse(c.resp, bulkStrResponse([]byte("message")), bulkStrResponse([]byte(channel)), bulkStrResponse(message))
+		}
+		c.push(msg[c.resp-2])
+		n++
+	}
+
+	for pattern, clients := range p.subs[subPattern] {
+		if !globMatch(pattern, channel) {
+			continue
+		}
+
+		var pmsg [2][]byte
+		for c := range clients {
+			if pmsg[c.resp-2] == nil {
+				pmsg[c.resp-2] = pushResponse(c.resp,
+					bulkStrResponse([]byte("pmessage")), bulkStrResponse([]byte(pattern)), bulkStrResponse([]byte(channel)), bulkStrResponse(message))
+			}
+			c.push(pmsg[c.resp-2])
+			n++
+		}
+	}
+
+	return n
+}
+
+// publishShard sends message to subscribers of shard channel
+func (p *pubsubRegistry) publishShard(channel string, message []byte) int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	var msg [2][]byte
+	for c := range p.subs[subShard][channel] {
+		if msg[c.resp-2] == nil {
+			msg[c.resp-2] = pushResponse(c.resp, bulkStrResponse([]byte("smessage")), bulkStrResponse([]byte(channel)), bulkStrResponse(message))
+		}
+		c.push(msg[c.resp-2])
+	}
+
+	return len(p.subs[subShard][channel])
+}
+
+// channels returns active channels of kind matching pattern, all of them if pattern is empty
+func (p *pubsubRegistry) channels(kind int, pattern string) []string {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	var res []string
+	for name := range p.subs[kind] {
+		if pattern == "" || globMatch(pattern, name) {
+			res = append(res, name)
+		}
+	}
+	slices.Sort(res)
+
+	return res
+}
+
+func (p *pubsubRegistry) numsub(kind int, name string) int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	return len(p.subs[kind][name])
+}
+
+func (p *pubsubRegistry) numpat() int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	return len(p.subs[subPattern])
+}
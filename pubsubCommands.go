@@ -0,0 +1,64 @@
+package main
+
+import "strings"
+
+// PUBLISH channel message
+func publishHandler(args []*KvsValue) ([]byte, error) {
+	return intResponse(int64(pubsub.publish(argToString(args[0]), []byte(argToString(args[1]))))), nil
+}
+
+// SPUBLISH shardchannel message
+func spublishHandler(args []*KvsValue) ([]byte, error) {
+	return intResponse(int64(pubsub.publishShard(argToString(args[0]), []byte(argToString(args[1]))))), nil
+}
+
+// PUBSUB CHANNELS [pattern] | NUMSUB [channel ...] | NUMPAT | SHARDCHANNELS [pattern] | SHARDNUMSUB [channel ...]
+func pubsubHandler(args []*KvsValue) ([]byte, error) {
+	sub := strings.ToUpper(argToString(args[0]))
+
+	switch sub {
+	case "CHANNELS", "SHARDCHANNELS":
+		if len(args) > 2 {
+			return nil, wrongArgsCountErr("PUBSUB|" + sub)
+		}
+
+		kind := subChannel
+		if sub == "SHARDCHANNELS" {
+			kind = subShard
+		}
+
+		pattern := ""
+		if len(args) == 2 {
+			pattern = argToString(args[1])
+		}
+
+		channels := pubsub.channels(kind, pattern)
+		res := make([][]byte, len(channels))
+		for i, ch := range channels {
+			res[i] = []byte(ch)
+		}
+
+		return bulkStrArrayResponse(res...), nil
+	case "NUMSUB", "SHARDNUMSUB":
+		kind := subChannel
+		if sub == "SHARDNUMSUB" {
+			kind = subShard
+		}
+
+		res := make([][]byte, 0, 2*(len(args)-1))
+		for _, arg := range args[1:] {
+			ch := argToString(arg)
+			res = append(res, bulkStrResponse([]byte(ch)), intResponse(int64(pubsub.numsub(kind, ch))))
+		}
+
+		return arrayResponse(res...), nil
+	case "NUMPAT":
+		if len(args) != 1 {
+			return nil, wrongArgsCountErr("PUBSUB|NUMPAT")
+		}
+
+		return intResponse(int64(pubsub.numpat())), nil
+	}
+
+	return nil, ErrUnknownSubcommand
+}
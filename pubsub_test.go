@@ -0,0 +1,97 @@
+package main
+
+import (
+	"bufio"
+	"io"
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+// pipeClient returns client writing to a pipe and reader of the other end
+func pipeClient(t *testing.T) (*client, *bufio.Reader) {
+	t.Helper()
+
+	server, conn := net.Pipe()
+	t.Cleanup(func() { conn.Close() })
+
+	return newClient(server), bufio.NewReader(conn)
+}
+
+func readReply(t *testing.T, r *bufio.Reader, expected string) {
+	t.Helper()
+
+	buf := make([]byte, len(expected))
+	if _, err := io.ReadFull(r, buf); err != nil {
+		t.Fatalf("reading %q: %v", expected, err)
+	}
+	if string(buf) != expected {
+		t.Fatalf("got %q, expected: %q", buf, expected)
+	}
+}
+
+func TestPubsubPublish(t *testing.T) {
+	p := newPubsubRegistry()
+	c1, r1 := pipeClient(t)
+	c2, r2 := pipeClient(t)
+	c2.resp = 3
+
+	p.subscribe(c1, subChannel, []string{"news"})
+	readReply(t, r1, "*3\r\n$9\r\nsubscribe\r\n$4\r\nnews\r\n:1\r\n")
+	p.subscribe(c1, subPattern, []string{"n*"})
+	readReply(t, r1, "*3\r\n$10\r\npsubscribe\r\n$2\r\nn*\r\n:2\r\n")
+	p.subscribe(c2, subShard, []string{"news"})
+	readReply(t, r2, ">3\r\n$10\r\nssubscribe\r\n$4\r\nnews\r\n:1\r\n")
+
+	if n := p.publish("news", []byte("hi")); n != 2 {
+		t.Errorf("publish reached %d receivers, expected: 2", n)
+	}
+	readReply(t, r1, "*3\r\n$7\r\nmessage\r\n$4\r\nnews\r\n$2\r\nhi\r\n")
+	readReply(t, r1, "*4\r\n$8\r\npmessage\r\n$2\r\nn*\r\n$4\r\nnews\r\n$2\r\nhi\r\n")
+
+	if n := p.publishShard("news", []byte("yo")); n != 1 {
+		t.Errorf("shard publish reached %d receivers, expected: 1", n)
+	}
+	readReply(t, r2, ">3\r\n$8\r\nsmessage\r\n$4\r\nnews\r\n$2\r\nyo\r\n")
+
+	p.unsubscribe(c1, subChannel, nil)
+	readReply(t, r1, "*3\r\n$11\r\nunsubscribe\r\n$4\r\nnews\r\n:1\r\n")
+	if n := p.publish("nope", nil); n != 1 {
+		t.Errorf("publish after unsubscribe reached %d receivers, expected: 1 (pattern)", n)
+	}
+
+	p.unsubscribeAll(c1)
+	p.unsubscribeAll(c2)
+	for kind := range p.subs {
+		if len(p.subs[kind]) != 0 {
+			t.Errorf("subscriptions of kind %d left after unsubscribeAll: %v", kind, p.subs[kind])
+		}
+	}
+}
+
+func TestPubsubSlowSubscriber(t *testing.T) {
+	p := newPubsubRegistry()
+	slow, _ := pipeClient(t) // nobody reads, so writes to pipe block
+
+	p.subscribe(slow, subChannel, []string{"ch"})
+
+	msg := []byte(strings.Repeat("x", 1<<20))
+	done := make(chan struct{})
+	go func() {
+		for range 2 * pubsubOutputLimit / len(msg) {
+			p.publish("ch", msg)
+		}
+		close(done)
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("publisher blocked by slow subscriber")
+	}
+
+	if !slow.isClosed() {
+		t.Error("slow subscriber wasn't disconnected")
+	}
+}
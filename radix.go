@@ -0,0 +1,350 @@
+package main
+
+import "bytes"
+
+// radixTree is an ordered map with byte string keys where nodes with a single child are merged
+// into one edge. Streams use it to index their blocks by the first entry ID and to keep pending
+// entries sorted, so it also supports seeking to the closest key on either side.
+type radixTree[V any] struct {
+	root *radixNode[V]
+	size int
+}
+
+type radixNode[V any] struct {
+	prefix   []byte // edge label leading into this node
+	children []*radixNode[V]
+	isKey    bool
+	value    V
+}
+
+func newRadixTree[V any]() *radixTree[V] {
+	return &radixTree[V]{root: &radixNode[V]{}}
+}
+
+func (t *radixTree[V]) len() int {
+	return t.size
+}
+
+func commonPrefixLen(a, b []byte) int {
+	n := min(len(a), len(b))
+	for i := range n {
+		if a[i] != b[i] {
+			return i
+		}
+	}
+
+	return n
+}
+
+// returns index of child whose edge starts with b, or index where such child should be inserted
+func (n *radixNode[V]) childIx(b byte) (int, bool) {
+	lo, hi := 0, len(n.children)
+	for lo < hi {
+		mid := (lo + hi) / 2
+		if n.children[mid].prefix[0] < b {
+			lo = mid + 1
+		} else {
+			hi = mid
+		}
+	}
+
+	return lo, lo < len(n.children) && n.children[lo].prefix[0] == b
+}
+
+// insert returns true if key was not present before
+func (t *radixTree[V]) insert(key []byte, value V) bool {
+	n := t.root
+	rem := key
+
+	for len(rem) > 0 {
+		ix, found := n.childIx(rem[0])
+		if !found {
+			child := &radixNode[V]{prefix: bytes.Clone(rem), isKey: true, value: value}
+			n.children = append(n.children, nil)
+			copy(n.children[ix+1:], n.children[ix:])
+			n.children[ix] = child
+			t.size++
+			return true
+		}
+
+		child := n.children[ix]
+		l := commonPrefixLen(child.prefix, rem)
+
+		if l < len(child.prefix) {
+			// split the edge: child becomes a grandchild under a new node holding common part
+			split := &radixNode[V]{prefix: child.prefix[:l:l], children: []*radixNode[V]{child}}
+			child.prefix = child.prefix[l:]
+			n.children[ix] = split
+			child = split
+		}
+
+		n = child
+		rem = rem[l:]
+	}
+
+	isNew := !n.isKey
+	n.isKey = true
+	n.value = value
+	if isNew {
+		t.size++
+	}
+
+	return isNew
+}
+
+func (t *radixTree[V]) find(key []byte) (value V, ok bool) {
+	n := t.root
+	rem := key
+
+	for len(rem) > 0 {
+		ix, found := n.childIx(rem[0])
+		if !found {
+			return value, false
+		}
+
+		child := n.children[ix]
+		if !bytes.HasPrefix(rem, child.prefix) {
+			return value, false
+		}
+
+		n = child
+		rem = rem[len(child.prefix):]
+	}
+
+	if !n.isKey {
+		return value, false
+	}
+
+	return n.value, true
+}
+
+// remove returns true if key was present
+func (t *radixTree[V]) remove(key []byte) bool {
+	var zero V
+	path := []*radixNode[V]{t.root}
+	n := t.root
+	rem := key
+
+	for len(rem) > 0 {
+		ix, found := n.childIx(rem[0])
+		if !found {
+			return false
+		}
+
+		child := n.children[ix]
+		if !bytes.HasPrefix(rem, child.prefix) {
+			return false
+		}
+
+		n = child
+		rem = rem[len(child.prefix):]
+		path = append(path, n)
+	}
+
+	if !n.isKey {
+		return false
+	}
+
+	n.isKey = false
+	n.value = zero
+	t.size--
+
+	// walk back up removing empty nodes and merging nodes left with a single child
+	for i := len(path) - 1; i > 0; i-- {
+		node := path[i]
+		parent := path[i-1]
+
+		if node.isKey {
+			break
+		}
+
+		if len(node.children) == 0 {
+			ix, _ := parent.childIx(node.prefix[0])
+			parent.children = append(parent.children[:ix], parent.children[ix+1:]...)
+			continue
+		}
+
+		if len(node.children) == 1 {
+			only := node.children[0]
+			merged := make([]byte, 0, len(node.prefix)+len(only.prefix))
+			merged = append(merged, node.prefix...)
+			merged = append(merged, only.prefix...)
+			only.prefix = merged
+
+			ix, _ := parent.childIx(node.prefix[0])
+			parent.children[ix] = only
+		}
+
+		break
+	}
+
+	return true
+}
+
+func radixMin[V any](n *radixNode[V], path []byte) ([]byte, *radixNode[V]) {
+	for !n.isKey {
+		if len(n.children) == 0 {
+			return nil, nil
+		}
+		n = n.children[0]
+		path = append(path, n.prefix...)
+	}
+
+	return path, n
+}
+
+func radixMax[V any](n *radixNode[V], path []byte) ([]byte, *radixNode[V]) {
+	for len(n.children) > 0 {
+		n = n.children[len(n.children)-1]
+		path = append(path, n.prefix...)
+	}
+
+	if !n.isKey {
+		return nil, nil
+	}
+
+	return path, n
+}
+
+// smallest key >= key (or > key when inclusive is false)
+func radixCeiling[V any](n *radixNode[V], rem []byte, path []byte, inclusive bool) ([]byte, *radixNode[V]) {
+	if len(rem) == 0 {
+		if n.isKey && inclusive {
+			return path, n
+		}
+
+		for _, c := range n.children {
+			if k, found := radixMin(c, append(path, c.prefix...)); found != nil {
+				return k, found
+			}
+		}
+
+		return nil, nil
+	}
+
+	for i, c := range n.children {
+		l := commonPrefixLen(c.prefix, rem)
+
+		var k []byte
+		var found *radixNode[V]
+
+		switch {
+		case l == len(c.prefix):
+			k, found = radixCeiling(c, rem[l:], append(path, c.prefix...), inclusive)
+		case l == len(rem) || c.prefix[l] > rem[l]:
+			k, found = radixMin(c, append(path, c.prefix...))
+		default:
+			continue
+		}
+
+		if found != nil {
+			return k, found
+		}
+
+		// everything in the following children is greater than key
+		for _, next := range n.children[i+1:] {
+			if k, found := radixMin(next, append(path, next.prefix...)); found != nil {
+				return k, found
+			}
+		}
+
+		return nil, nil
+	}
+
+	return nil, nil
+}
+
+// greatest key <= key (or < key when inclusive is false)
+func radixFloor[V any](n *radixNode[V], rem []byte, path []byte, inclusive bool) ([]byte, *radixNode[V]) {
+	if len(rem) == 0 {
+		if n.isKey && inclusive {
+			return path, n
+		}
+
+		return nil, nil
+	}
+
+	for i := len(n.children) - 1; i >= 0; i-- {
+		c := n.children[i]
+		l := commonPrefixLen(c.prefix, rem)
+
+		var k []byte
+		var found *radixNode[V]
+
+		switch {
+		case l == len(c.prefix):
+			k, found = radixFloor(c, rem[l:], append(path, c.prefix...), inclusive)
+		case l < len(rem) && c.prefix[l] < rem[l]:
+			k, found = radixMax(c, append(path, c.prefix...))
+		default:
+			continue
+		}
+
+		if found != nil {
+			return k, found
+		}
+
+		for j := i - 1; j >= 0; j-- {
+			prev := n.children[j]
+			if k, found := radixMax(prev, append(path, prev.prefix...)); found != nil {
+				return k, found
+			}
+		}
+
+		break
+	}
+
+	if n.isKey {
+		return path, n
+	}
+
+	return nil, nil
+}
+
+// seek helpers return found key (a fresh slice), its value and whether anything was found
+
+func (t *radixTree[V]) first() ([]byte, V, bool) {
+	return radixResult(radixMin(t.root, nil))
+}
+
+func (t *radixTree[V]) last() ([]byte, V, bool) {
+	return radixResult(radixMax(t.root, nil))
+}
+
+func (t *radixTree[V]) ceiling(key []byte, inclusive bool) ([]byte, V, bool) {
+	return radixResult(radixCeiling(t.root, key, nil, inclusive))
+}
+
+func (t *radixTree[V]) floor(key []byte, inclusive bool) ([]byte, V, bool) {
+	return radixResult(radixFloor(t.root, key, nil, inclusive))
+}
+
+func radixResult[V any](key []byte, n *radixNode[V]) ([]byte, V, bool) {
+	if n == nil {
+		var zero V
+		return nil, zero, false
+	}
+
+	return bytes.Clone(key), n.value, true
+}
+
+// walk calls fn for every key in ascending (or descending if reverse) order until fn returns false
+func (t *radixTree[V]) walk(reverse bool, fn func(key []byte, value V) bool) {
+	var key []byte
+	var value V
+	var ok bool
+
+	if reverse {
+		key, value, ok = t.last()
+	} else {
+		key, value, ok = t.first()
+	}
+
+	for ok && fn(key, value) {
+		if reverse {
+			key, value, ok = t.floor(key, false)
+		} else {
+			key, value, ok = t.ceiling(key, false)
+		}
+	}
+}
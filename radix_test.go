@@ -0,0 +1,148 @@
+package main
+
+import (
+	"slices"
+	"testing"
+)
+
+func initMockRadixTree(keys ...string) *radixTree[int] {
+	t := newRadixTree[int]()
+	for i, k := range keys {
+		t.insert([]byte(k), i)
+	}
+
+	return t
+}
+
+func radixKeys(t *radixTree[int], reverse bool) []string {
+	var res []string
+	t.walk(reverse, func(key []byte, _ int) bool {
+		res = append(res, string(key))
+		return true
+	})
+
+	return res
+}
+
+// ================================ insert/find ========================================
+func TestRadixInsertFind(t *testing.T) {
+	tree := initMockRadixTree("romane", "romanus", "romulus", "rubens", "ruber", "rom")
+
+	for i, k := range []string{"romane", "romanus", "romulus", "rubens", "ruber", "rom"} {
+		res, ok := tree.find([]byte(k))
+		if !ok || res != i {
+			t.Errorf("find(%v) = %v, expected: %v, found: %v", k, res, i, ok)
+		}
+	}
+
+	if _, ok := tree.find([]byte("roma")); ok {
+		t.Errorf("find(roma) found key that was never inserted")
+	}
+
+	if tree.len() != 6 {
+		t.Errorf("len() = %v, expected: %v", tree.len(), 6)
+	}
+}
+
+func TestRadixInsertExisting(t *testing.T) {
+	tree := initMockRadixTree("abc")
+
+	isNew := tree.insert([]byte("abc"), 42)
+	res, _ := tree.find([]byte("abc"))
+
+	if isNew || res != 42 || tree.len() != 1 {
+		t.Errorf("insert(abc) over existing key = %v, value %v, len %v, expected: false, 42, 1", isNew, res, tree.len())
+	}
+}
+
+// ================================ walk ========================================
+func TestRadixWalkOrder(t *testing.T) {
+	tree := initMockRadixTree("b", "abc", "a", "ab", "c", "abd")
+
+	expected := []string{"a", "ab", "abc", "abd", "b", "c"}
+
+	res := radixKeys(tree, false)
+	if !slices.Equal(res, expected) {
+		t.Errorf("walk() = %v, expected: %v", res, expected)
+	}
+
+	slices.Reverse(expected)
+
+	res = radixKeys(tree, true)
+	if !slices.Equal(res, expected) {
+		t.Errorf("walk(reverse) = %v, expected: %v", res, expected)
+	}
+}
+
+// ================================ remove ========================================
+func TestRadixRemove(t *testing.T) {
+	tree := initMockRadixTree("a", "ab", "abc", "abd", "b")
+
+	if !tree.remove([]byte("ab")) || !tree.remove([]byte("abc")) {
+		t.Errorf("remove() of existing keys returned false")
+	}
+
+	if tree.remove([]byte("abc")) || tree.remove([]byte("x")) {
+		t.Errorf("remove() of missing keys returned true")
+	}
+
+	expected := []string{"a", "abd", "b"}
+
+	res := radixKeys(tree, false)
+	if !slices.Equal(res, expected) || tree.len() != 3 {
+		t.Errorf("keys after remove() = %v, expected: %v", res, expected)
+	}
+}
+
+// ================================ ceiling/floor ========================================
+func TestRadixCeiling(t *testing.T) {
+	tree := initMockRadixTree("b", "bd", "d", "f")
+
+	cases := []struct {
+		key       string
+		inclusive bool
+		expected  string
+		found     bool
+	}{
+		{"a", true, "b", true},
+		{"b", true, "b", true},
+		{"b", false, "bd", true},
+		{"bc", true, "bd", true},
+		{"be", true, "d", true},
+		{"e", true, "f", true},
+		{"f", false, "", false},
+	}
+
+	for _, c := range cases {
+		res, _, ok := tree.ceiling([]byte(c.key), c.inclusive)
+		if string(res) != c.expected || ok != c.found {
+			t.Errorf("ceiling(%v, %v) = %s, expected: %v", c.key, c.inclusive, res, c.expected)
+		}
+	}
+}
+
+func TestRadixFloor(t *testing.T) {
+	tree := initMockRadixTree("b", "bd", "d", "f")
+
+	cases := []struct {
+		key       string
+		inclusive bool
+		expected  string
+		found     bool
+	}{
+		{"a", true, "", false},
+		{"b", true, "b", true},
+		{"b", false, "", false},
+		{"bc", true, "b", true},
+		{"be", true, "bd", true},
+		{"d", false, "bd", true},
+		{"z", true, "f", true},
+	}
+
+	for _, c := range cases {
+		res, _, ok := tree.floor([]byte(c.key), c.inclusive)
+		if string(res) != c.expected || ok != c.found {
+			t.Errorf("floor(%v, %v) = %s, expected: %v", c.key, c.inclusive, res, c.expected)
+		}
+	}
+}
@@ -14,6 +14,14 @@ const (
 	BulkStrSymbol   = '$'
 	IntSymbol       = ':'
 	BoolSymbol      = '#'
+	MapSymbol       = '%'
+	PushSymbol      = '>'
+)
+
+// limits of lengths sent by clients, as in Redis, so a header alone can't make the server allocate too much
+const (
+	respMaxMultibulkLen = 1024 * 1024
+	respMaxBulkLen      = 512 << 20
 )
 
 type respReader struct {
@@ -24,6 +32,11 @@ func NewRespReader(r io.Reader) *respReader {
 	return &respReader{reader: bufio.NewReader(r)}
 }
 
+// buffered is number of bytes received and not read yet
+func (r *respReader) buffered() int {
+	return r.reader.Buffered()
+}
+
 // EVERY RESP command MUST start like "*<no. of lines after first one>\r\n$" and then input may vary
 // so this function basically checks this beggining and then reads args
 func (r *respReader) readCommand() (command string, args []*KvsValue, err error) {
@@ -32,7 +45,8 @@ func (r *respReader) readCommand() (command string, args []*KvsValue, err error)
 		return "", nil, err
 	}
 
-	if err := readArray(firstLine); err != nil {
+	elemCount, err := readArray(firstLine)
+	if err != nil {
 		return "", nil, err
 	}
 
@@ -46,7 +60,7 @@ func (r *respReader) readCommand() (command string, args []*KvsValue, err error)
 		return "", nil, err
 	}
 
-	args, err = r.readArgs()
+	args, err = r.readArgs(elemCount - 1)
 	if err != nil {
 		return "", nil, err
 	}
@@ -54,26 +68,12 @@ func (r *respReader) readCommand() (command string, args []*KvsValue, err error)
 	return string(cmd), args, nil
 }
 
-func (r *respReader) readArgs() (args []*KvsValue, err error) {
-	_, err = r.reader.Peek(1) // checking for empty args
-	if err != nil {
-		return nil, nil
-	}
-
-	maxArgsCount := 2
-	args = make([]*KvsValue, 0, maxArgsCount)
-	curArgIx := 0
-
-	for {
-		if curArgIx == maxArgsCount {
-			break
-		}
-
+// argsCount is taken from the command array header, so we never try to read past the current command
+// and block on the next one. args grow as they arrive, as the count comes from the client
+func (r *respReader) readArgs(argsCount int) (args []*KvsValue, err error) {
+	for range argsCount {
 		dtype, err := r.reader.ReadByte()
 		if err != nil {
-			if err == io.EOF {
-				break
-			}
 			return nil, ErrInvalidRESP
 		}
 
@@ -95,8 +95,6 @@ func (r *respReader) readArgs() (args []*KvsValue, err error) {
 
 		arg := &KvsValue{dtype: dtype, value: val}
 		args = append(args, arg)
-
-		curArgIx++
 	}
 
 	return args, nil
@@ -121,24 +119,29 @@ func (r *respReader) readRespLine() (line []byte, err error) {
 	return respLine[:crIndex], nil
 }
 
-// For this minimum version of kvs we dont really need arrays except just read command header so it differs from other read<type> funcs
-func readArray(respFirstLine []byte) error {
-	if respFirstLine[0] != ArrSymbol {
-		return ErrCmdNotArray
+// For this minimum version of kvs we dont really need arrays except just read command header so it differs from other read<type> funcs.
+// Returns number of elements in command array (command name included)
+func readArray(respFirstLine []byte) (elemCount int, err error) {
+	if len(respFirstLine) == 0 || respFirstLine[0] != ArrSymbol {
+		return 0, ErrCmdNotArray
 	}
 
 	dataLength, err := bytesToInt(respFirstLine[1:])
 	if err != nil {
-		return err
+		return 0, err
 	}
 
 	if dataLength <= 0 {
-		return ErrIncorrectDataLen
+		return 0, ErrIncorrectDataLen
+	}
+	if dataLength > respMaxMultibulkLen {
+		return 0, ErrMultibulkLen
 	}
 
-	return nil
+	return dataLength, nil
 }
 
+// readBulkString reads bulk string after its '$'. Empty string is valid, null bulk string is read as nil
 func (r *respReader) readBulkString() (val []byte, err error) {
 	dataLengthBytes, err := r.reader.ReadBytes('\r')
 	if err != nil {
@@ -150,14 +153,20 @@ func (r *respReader) readBulkString() (val []byte, err error) {
 		return nil, err
 	}
 
-	if dataLength <= 0 {
+	if dataLength < -1 {
 		return nil, ErrIncorrectDataLen
 	}
+	if dataLength > respMaxBulkLen {
+		return nil, ErrBulkLen
+	}
 
 	lastByte, err := r.reader.ReadByte()
 	if err != nil || lastByte != '\n' {
 		return nil, ErrInvalidRESP
 	}
+	if dataLength == -1 {
+		return nil, nil
+	}
 
 	res := make([]byte, dataLength)
 
@@ -229,7 +238,7 @@ func (r *respReader) readInt() (val []byte, err error) {
 	}
 
 	res := make([]byte, 8)
-	binary.NativeEndian.PutUint64(res, uint64(intVal))
+	binary.LittleEndian.PutUint64(res, uint64(intVal))
 
 	return res, nil
 }
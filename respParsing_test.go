@@ -53,17 +53,17 @@ func TestReadRespLineWithoutCR(t *testing.T) {
 func TestReadArrayCorrectArray(t *testing.T) {
 	array := []byte("*2")
 
-	err := readArray(array)
+	res, err := readArray(array)
 
-	if err != nil {
-		t.Errorf("readArray([]byte('*2') errors with %v, expected: %v", err, nil)
+	if res != 2 || err != nil {
+		t.Errorf("readArray([]byte('*2') = %v, expected: %v, err: %v", res, 2, err)
 	}
 }
 
 func TestReadArrayNoArraySymbol(t *testing.T) {
 	array := []byte("2")
 
-	err := readArray(array)
+	_, err := readArray(array)
 
 	if err != ErrCmdNotArray {
 		t.Errorf("readArray([]byte('2') errors with %v, expected: %v", err, ErrCmdNotArray)
@@ -73,7 +73,7 @@ func TestReadArrayNoArraySymbol(t *testing.T) {
 func TestReadArrayNegativeCount(t *testing.T) {
 	array := []byte("*-2")
 
-	err := readArray(array)
+	_, err := readArray(array)
 
 	if err != ErrIncorrectDataLen {
 		t.Errorf("readArray([]byte('*-2') errors with %v, expected: %v", err, ErrIncorrectDataLen)
@@ -83,13 +83,34 @@ func TestReadArrayNegativeCount(t *testing.T) {
 func TestReadArrayCountNotNumeric(t *testing.T) {
 	array := []byte("*asdf")
 
-	err := readArray(array)
+	_, err := readArray(array)
 
 	if err != ErrInvalidIntVal {
 		t.Errorf("readArray([]byte('*asdf') errors with %v, expected: %v", err, ErrInvalidIntVal)
 	}
 }
 
+func TestReadArrayTooLong(t *testing.T) {
+	array := []byte("*4000000000")
+
+	_, err := readArray(array)
+
+	if err != ErrMultibulkLen {
+		t.Errorf("readArray([]byte('*4000000000') errors with %v, expected: %v", err, ErrMultibulkLen)
+	}
+}
+
+// huge count in the header is replied with an error instead of being allocated
+func TestHugeMultibulkLenReply(t *testing.T) {
+	initStorage()
+	conn, r := connect(t)
+
+	if _, err := conn.Write([]byte("*4000000000\r\n$3\r\nSET\r\n")); err != nil {
+		t.Fatal(err)
+	}
+	readReply(t, r, ErrMultibulkLen.Error())
+}
+
 // ================================ readBulkString ========================================
 func TestReadBulkStringCorrect(t *testing.T) {
 	r := initMockReader("2\r\nHI\r\n")
@@ -103,6 +124,36 @@ func TestReadBulkStringCorrect(t *testing.T) {
 	}
 }
 
+func TestReadBulkStringTooLong(t *testing.T) {
+	r := initMockReader("4000000000\r\nHI\r\n")
+
+	res, err := r.readBulkString()
+
+	if res != nil || err != ErrBulkLen {
+		t.Errorf("readBulkString(4000000000\\r\\nHI\\r\\n) = %s, expected: %v, err: %v", res, ErrBulkLen, err)
+	}
+}
+
+func TestReadBulkStringEmpty(t *testing.T) {
+	r := initMockReader("0\r\n\r\n")
+
+	res, err := r.readBulkString()
+
+	if res == nil || len(res) != 0 || err != nil {
+		t.Errorf("readBulkString(0\\r\\n\\r\\n) = %q, expected empty string, err: %v", res, err)
+	}
+}
+
+func TestReadBulkStringNull(t *testing.T) {
+	r := initMockReader("-1\r\n")
+
+	res, err := r.readBulkString()
+
+	if res != nil || err != nil {
+		t.Errorf("readBulkString(-1\\r\\n) = %q, expected: nil, err: %v", res, err)
+	}
+}
+
 func TestReadBulkStringWithoutCR(t *testing.T) {
 	r := initMockReader("2\n")
 
@@ -275,7 +326,7 @@ func TestReadBoolWithoutNewLine(t *testing.T) {
 func TestReadArgsCorrectArgs(t *testing.T) {
 	r := initMockReader("$2\r\nHI\r\n$2\r\nYO\r\n")
 
-	res, err := r.readArgs()
+	res, err := r.readArgs(2)
 	expectedArg1 := KvsValue{dtype: BulkStrSymbol, value: []byte("HI")}
 	expectedArg2 := KvsValue{dtype: BulkStrSymbol, value: []byte("YO")}
 
@@ -299,7 +350,7 @@ func TestReadArgsCorrectArgs(t *testing.T) {
 func TestReadArgsIncorrectDtype(t *testing.T) {
 	r := initMockReader("&23\r\n")
 
-	res, err := r.readArgs()
+	res, err := r.readArgs(1)
 
 	if res != nil || err != ErrDtypeNotSupported {
 		t.Errorf("readArgs(\\r\\n) = %v, expected: %v, err: %v", res, ErrDtypeNotSupported, err)
@@ -309,7 +360,7 @@ func TestReadArgsIncorrectDtype(t *testing.T) {
 func TestReadArgsEmpty(t *testing.T) {
 	r := initMockReader("")
 
-	res, err := r.readArgs()
+	res, err := r.readArgs(0)
 
 	if res != nil || err != nil {
 		t.Errorf("readArgs('') = %v, expected: %v, err: %v", res, nil, err)
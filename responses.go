@@ -1,7 +1,97 @@
 package main
 
+import (
+	"strconv"
+)
+
 const (
-	OkResponse   = string(SimpleStrSymbol) + "OK" + CRLF
-	PongResponse = string(SimpleStrSymbol) + "PONG" + CRLF
-	NullResponse = "_" + CRLF
+	OkResponse         = string(SimpleStrSymbol) + "OK" + CRLF
+	PongResponse       = string(SimpleStrSymbol) + "PONG" + CRLF
+	NullResponse       = "_" + CRLF
+	EmptyArrayResponse = string(ArrSymbol) + "0" + CRLF
 )
+
+// helpers below build RESP replies for commands that answer with something more than OK or a single stored value
+
+func intResponse(n int64) []byte {
+	return []byte(string(IntSymbol) + strconv.FormatInt(n, 10) + CRLF)
+}
+
+// boolIntResponse encodes flag as integer 1 or 0, the way Redis commands report yes/no answers
+func boolIntResponse(b bool) []byte {
+	if b {
+		return intResponse(1)
+	}
+
+	return intResponse(0)
+}
+
+func simpleStrResponse(s string) []byte {
+	return []byte(string(SimpleStrSymbol) + s + CRLF)
+}
+
+func bulkStrResponse(s []byte) []byte {
+	res := make([]byte, 0, len(s)+16)
+	res = append(res, BulkStrSymbol)
+	res = strconv.AppendInt(res, int64(len(s)), 10)
+	res = append(res, CRLF...)
+	res = append(res, s...)
+	res = append(res, CRLF...)
+
+	return res
+}
+
+// elems must be already encoded RESP values
+func arrayResponse(elems ...[]byte) []byte {
+	return aggregateResponse(ArrSymbol, len(elems), elems)
+}
+
+// pushResponse encodes out-of-band message like pub/sub one. RESP3 clients get a push frame,
+// RESP2 clients can't tell it from a regular array
+func pushResponse(resp int, elems ...[]byte) []byte {
+	if resp == 3 {
+		return aggregateResponse(PushSymbol, len(elems), elems)
+	}
+
+	return arrayResponse(elems...)
+}
+
+// mapResponse takes already encoded keys and values one after another. RESP2 clients get a flat array
+func mapResponse(resp int, pairs ...[]byte) []byte {
+	if resp == 3 {
+		return aggregateResponse(MapSymbol, len(pairs)/2, pairs)
+	}
+
+	return arrayResponse(pairs...)
+}
+
+func aggregateResponse(symbol byte, n int, elems [][]byte) []byte {
+	size := 16
+	for _, e := range elems {
+		size += len(e)
+	}
+
+	res := make([]byte, 0, size)
+	res = append(res, symbol)
+	res = strconv.AppendInt(res, int64(n), 10)
+	res = append(res, CRLF...)
+
+	for _, e := range elems {
+		res = append(res, e...)
+	}
+
+	return res
+}
+
+func bulkStrArrayResponse(strs ...[]byte) []byte {
+	elems := make([][]byte, len(strs))
+	for i, s := range strs {
+		if s == nil {
+			elems[i] = []byte(NullResponse)
+			continue
+		}
+		elems[i] = bulkStrResponse(s)
+	}
+
+	return arrayResponse(elems...)
+}
@@ -0,0 +1,550 @@
+package main
+
+import (
+	"context"
+	"crypto/sha1"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"log"
+	"math"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	lua "github.com/yuin/gopher-lua"
+	"github.com/yuin/gopher-lua/parse"
+)
+
+// Scripts are run by a single Lua state holding kvs.mu, so a script is atomic the same way EXEC is.
+// redis.call runs handlers from the command table directly. A script running longer than
+// busy-reply-threshold makes commands waiting for the lock fail with BUSY, and SCRIPT KILL stops
+// it unless it has written something already
+const scriptDefaultBusyThreshold = 5000 // ms
+
+// scriptRun is state of the running script
+type scriptRun struct {
+	readOnly bool
+	wrote    bool
+	cancel   context.CancelFunc
+	killed   bool
+}
+
+// scripting state is guarded by kvs.mu, except runState that is used by clients waiting for the lock
+var scripting struct {
+	state   *lua.LState
+	scripts map[string]*lua.FunctionProto // by sha1
+	run     *scriptRun
+}
+
+var runState struct {
+	mu   sync.Mutex
+	run  *scriptRun
+	busy bool
+	// closed and replaced every time run or busy changes
+	changed chan struct{}
+}
+
+func init() {
+	runState.changed = make(chan struct{})
+}
+
+// setRunState must be called with runState.mu held
+func setRunState(run *scriptRun, busy bool) {
+	runState.run, runState.busy = run, busy
+	close(runState.changed)
+	runState.changed = make(chan struct{})
+}
+
+// lockKvs takes kvs.mu or returns ErrBusy if it's held by a script running for too long
+func lockKvs() error {
+	if kvs.mu.TryLock() {
+		return nil
+	}
+
+	locked := make(chan struct{})
+	abandoned := make(chan struct{})
+	go func() {
+		kvs.mu.Lock()
+		select {
+		case locked <- struct{}{}:
+		case <-abandoned:
+			kvs.mu.Unlock()
+		}
+	}()
+
+	for {
+		runState.mu.Lock()
+		busy, changed := runState.busy, runState.changed
+		runState.mu.Unlock()
+
+		if busy {
+			close(abandoned)
+			return ErrBusy
+		}
+
+		select {
+		case <-locked:
+			return nil
+		case <-changed:
+		}
+	}
+}
+
+// killScript stops the running script. It's called without kvs.mu, since the script holds it
+func killScript() error {
+	runState.mu.Lock()
+	defer runState.mu.Unlock()
+
+	run := runState.run
+	switch {
+	case run == nil:
+		return ErrNotBusy
+	case run.wrote:
+		return ErrUnkillable
+	}
+
+	run.killed = true
+	run.cancel()
+
+	return nil
+}
+
+func scriptSHA(body string) string {
+	sum := sha1.Sum([]byte(body))
+	return hex.EncodeToString(sum[:])
+}
+
+func compileScript(body string) (*lua.FunctionProto, error) {
+	chunk, err := parse.Parse(strings.NewReader(body), "user_script")
+	if err != nil {
+		return nil, scriptCompileErr(err.Error())
+	}
+
+	proto, err := lua.Compile(chunk, "user_script")
+	if err != nil {
+		return nil, scriptCompileErr(err.Error())
+	}
+
+	return proto, nil
+}
+
+// loadScript compiles and caches script, returns its sha1. Must be called with kvs.mu held
+func loadScript(body string) (string, error) {
+	sha := scriptSHA(body)
+	if _, ok := scripting.scripts[sha]; ok {
+		return sha, nil
+	}
+
+	proto, err := compileScript(body)
+	if err != nil {
+		return "", err
+	}
+
+	if scripting.scripts == nil {
+		scripting.scripts = make(map[string]*lua.FunctionProto)
+	}
+	scripting.scripts[sha] = proto
+
+	return sha, nil
+}
+
+func luaState() *lua.LState {
+	if scripting.state != nil {
+		return scripting.state
+	}
+
+	L := lua.NewState(lua.Options{SkipOpenLibs: true})
+	for _, lib := range []struct {
+		name string
+		open lua.LGFunction
+	}{
+		{lua.BaseLibName, lua.OpenBase},
+		{lua.TabLibName, lua.OpenTable},
+		{lua.StringLibName, lua.OpenString},
+		{lua.MathLibName, lua.OpenMath},
+	} {
+		L.Push(L.NewFunction(lib.open))
+		L.Push(lua.LString(lib.name))
+		L.Call(1, 0)
+	}
+
+	// scripts must not touch the file system, nor get around read-only tables
+	for _, name := range []string{"dofile", "loadfile", "require", "module", "rawset", "getfenv", "setfenv"} {
+		L.SetGlobal(name, lua.LNil)
+	}
+
+	redis := L.NewTable()
+	L.SetFuncs(redis, map[string]lua.LGFunction{
+		"call":  func(L *lua.LState) int { return luaRedisCall(L, false) },
+		"pcall": func(L *lua.LState) int { return luaRedisCall(L, true) },
+		"error_reply": func(L *lua.LState) int {
+			L.Push(luaStatusTable(L, "err", L.CheckString(1)))
+			return 1
+		},
+		"status_reply": func(L *lua.LState) int {
+			L.Push(luaStatusTable(L, "ok", L.CheckString(1)))
+			return 1
+		},
+		"sha1hex": func(L *lua.LState) int {
+			L.Push(lua.LString(scriptSHA(L.CheckString(1))))
+			return 1
+		},
+		"log": func(L *lua.LState) int {
+			L.CheckInt(1)
+			log.Println("Script:", L.CheckString(2))
+			return 0
+		},
+	})
+	for i, level := range []string{"LOG_DEBUG", "LOG_VERBOSE", "LOG_NOTICE", "LOG_WARNING"} {
+		redis.RawSetString(level, lua.LNumber(i))
+	}
+	L.SetGlobal("redis", redis)
+
+	// scripts share the state, so they see globals and libraries through read-only tables and can't change
+	// them for the next ones. KEYS and ARGV are set in the real globals
+	for _, name := range []string{lua.TabLibName, lua.StringLibName, lua.MathLibName, "redis"} {
+		L.SetGlobal(name, luaReadOnlyTable(L, L.GetGlobal(name).(*lua.LTable)))
+	}
+	if mt, ok := L.GetMetatable(lua.LString("")).(*lua.LTable); ok {
+		mt.RawSetString("__metatable", lua.LFalse)
+	}
+	env := luaReadOnlyTable(L, L.G.Global)
+	L.SetGlobal("_G", env)
+	L.Env = env
+
+	scripting.state = L
+
+	return L
+}
+
+// luaReadOnlyTable returns empty table reading fields of t and raising an error on writes
+func luaReadOnlyTable(L *lua.LState, t *lua.LTable) *lua.LTable {
+	mt := L.NewTable()
+	mt.RawSetString("__index", t)
+	mt.RawSetString("__newindex", L.NewFunction(func(L *lua.LState) int {
+		L.RaiseError("Attempt to modify a readonly table")
+		return 0
+	}))
+	mt.RawSetString("__metatable", lua.LFalse)
+
+	proxy := L.NewTable()
+	L.SetMetatable(proxy, mt)
+
+	return proxy
+}
+
+// closeLuaState drops the state with everything scripts have left in it. Must be called with kvs.mu held
+func closeLuaState() {
+	if scripting.state != nil {
+		scripting.state.Close()
+		scripting.state = nil
+	}
+}
+
+func luaStatusTable(L *lua.LState, field, msg string) *lua.LTable {
+	t := L.NewTable()
+	t.RawSetString(field, lua.LString(msg))
+
+	return t
+}
+
+// startRun registers a new script or function run and arms the busy timer. The returned context is
+// cancelled by SCRIPT KILL, and finish must be called when the run ends. Must be called with kvs.mu held
+func startRun(readOnly bool) (*scriptRun, context.Context, func()) {
+	ctx, cancel := context.WithCancel(context.Background())
+
+	run := &scriptRun{readOnly: readOnly, cancel: cancel}
+	scripting.run = run
+
+	runState.mu.Lock()
+	setRunState(run, false)
+	runState.mu.Unlock()
+
+	threshold := time.Duration(config.busyReplyThreshold) * time.Millisecond
+	timer := time.AfterFunc(threshold, func() {
+		runState.mu.Lock()
+		defer runState.mu.Unlock()
+
+		if runState.run == run {
+			log.Println("Slow script detected: still in execution after", threshold)
+			setRunState(run, true)
+		}
+	})
+
+	// a script can't block, the same way commands inside EXEC don't
+	inExec := kvs.inExec
+	kvs.inExec = true
+
+	return run, ctx, func() {
+		kvs.inExec = inExec
+		timer.Stop()
+		cancel()
+		scripting.run = nil
+
+		runState.mu.Lock()
+		setRunState(nil, false)
+		runState.mu.Unlock()
+	}
+}
+
+func (run *scriptRun) wasKilled() bool {
+	runState.mu.Lock()
+	defer runState.mu.Unlock()
+
+	return run.killed
+}
+
+// runScript runs cached script with KEYS and ARGV. Must be called with kvs.mu held
+func runScript(proto *lua.FunctionProto, sha string, keys, argv []*KvsValue, readOnly bool) ([]byte, error) {
+	L := luaState()
+
+	run, ctx, finish := startRun(readOnly)
+	defer finish()
+
+	L.SetGlobal("KEYS", luaArgsTable(L, keys))
+	L.SetGlobal("ARGV", luaArgsTable(L, argv))
+
+	L.SetContext(ctx)
+	defer L.RemoveContext()
+
+	L.Push(L.NewFunctionFromProto(proto))
+	err := L.PCall(0, 1, nil)
+	if err != nil {
+		if run.wasKilled() {
+			return nil, ErrScriptKilled
+		}
+
+		var apiErr *lua.ApiError
+		if errors.As(err, &apiErr) {
+			// errors of redis.call are raised as error tables and returned as they are
+			if t, ok := apiErr.Object.(*lua.LTable); ok {
+				if msg, ok := t.RawGetString("err").(lua.LString); ok {
+					return nil, errors.New(string(ErrorSymbol) + string(msg) + CRLF)
+				}
+			}
+			return nil, scriptRunErr(sha, apiErr.Object.String())
+		}
+
+		return nil, scriptRunErr(sha, err.Error())
+	}
+
+	ret := L.Get(-1)
+	L.Pop(1)
+
+	return luaToResponse(ret, 0)
+}
+
+func luaArgsTable(L *lua.LState, args []*KvsValue) *lua.LTable {
+	t := L.CreateTable(len(args), 0)
+	for _, arg := range args {
+		t.Append(lua.LString(argToString(arg)))
+	}
+
+	return t
+}
+
+// commands scripts and functions can't run
+var scriptForbidden = map[string]bool{
+	"EVAL": true, "EVALSHA": true, "EVAL_RO": true, "EVALSHA_RO": true, "SCRIPT": true,
+	"FCALL": true, "FCALL_RO": true, "FUNCTION": true,
+}
+
+// scriptCall runs command from a script or a function. Must be called with kvs.mu held while a run is active
+func scriptCall(args []*KvsValue) ([]byte, error) {
+	cmd, ok := lookupCommand(argToString(args[0]))
+	if !ok {
+		return nil, ErrScriptUnknownCmd
+	}
+	if scriptForbidden[cmd.name] || cmd.noScript {
+		return nil, ErrScriptForbiddenCmd
+	}
+	if err := checkArity(cmd, args[1:]); err != nil {
+		return nil, err
+	}
+	feedMonitors(monitorScriptSource, argToString(args[0]), args[1:])
+
+	run := scripting.run
+	if cmd.write {
+		if run.readOnly {
+			return nil, ErrScriptReadOnly
+		}
+
+		runState.mu.Lock()
+		run.wrote = true
+		runState.mu.Unlock()
+	}
+
+	prev := kvs.cmd
+	kvs.cmd = cmd
+	defer func() { kvs.cmd = prev }()
+
+	return call(cmd, args[1:])
+}
+
+// luaRedisCall is redis.call and redis.pcall. call raises errors, pcall returns them as {err = msg} tables
+func luaRedisCall(L *lua.LState, protected bool) int {
+	fail := func(err error) int {
+		msg := strings.TrimSuffix(strings.TrimPrefix(err.Error(), string(ErrorSymbol)), CRLF)
+		t := luaStatusTable(L, "err", msg)
+		if !protected {
+			L.Error(t, 0)
+		}
+		L.Push(t)
+		return 1
+	}
+
+	if L.GetTop() == 0 {
+		return fail(ErrScriptNoArgs)
+	}
+
+	args := make([]*KvsValue, L.GetTop())
+	for i := range args {
+		arg, err := luaToArg(L.Get(i + 1))
+		if err != nil {
+			return fail(err)
+		}
+		args[i] = arg
+	}
+
+	res, err := scriptCall(args)
+	if err != nil {
+		return fail(err)
+	}
+
+	val, _, err := respToLua(L, res)
+	if err != nil {
+		return fail(err)
+	}
+	L.Push(val)
+
+	return 1
+}
+
+// luaToArg converts Lua value to command argument: integers and booleans keep their types,
+// other numbers are passed as strings
+func luaToArg(v lua.LValue) (*KvsValue, error) {
+	switch v := v.(type) {
+	case lua.LString:
+		return &KvsValue{dtype: BulkStrSymbol, value: []byte(v)}, nil
+	case lua.LNumber:
+		f := float64(v)
+		if f == math.Trunc(f) && math.Abs(f) < 1<<63 {
+			b := make([]byte, 8)
+			binary.LittleEndian.PutUint64(b, uint64(int64(f)))
+			return &KvsValue{dtype: IntSymbol, value: b}, nil
+		}
+		return &KvsValue{dtype: BulkStrSymbol, value: []byte(strconv.FormatFloat(f, 'g', 17, 64))}, nil
+	case lua.LBool:
+		if v {
+			return &KvsValue{dtype: BoolSymbol, value: []byte{0x01}}, nil
+		}
+		return &KvsValue{dtype: BoolSymbol, value: []byte{0x00}}, nil
+	}
+
+	return nil, ErrScriptArgType
+}
+
+// respToLua converts RESP reply of a command to Lua value and returns the rest of reply. Nulls become false,
+// status replies become {ok = status} tables
+func respToLua(L *lua.LState, b []byte) (lua.LValue, []byte, error) {
+	end := strings.Index(string(b), CRLF)
+	if len(b) == 0 || end < 0 {
+		return nil, nil, ErrInvalidRESP
+	}
+
+	line, rest := string(b[1:end]), b[end+2:]
+
+	switch b[0] {
+	case SimpleStrSymbol:
+		return luaStatusTable(L, "ok", line), rest, nil
+	case IntSymbol:
+		n, err := strconv.ParseInt(line, 10, 64)
+		return lua.LNumber(n), rest, err
+	case BoolSymbol:
+		v, err := strconv.ParseBool(line)
+		return lua.LBool(v), rest, err
+	case '_':
+		return lua.LFalse, rest, nil
+	case BulkStrSymbol:
+		n, err := strconv.Atoi(line)
+		if err != nil {
+			return nil, nil, ErrInvalidRESP
+		}
+		if n < 0 {
+			return lua.LFalse, rest, nil
+		}
+		if len(rest) < n+2 {
+			return nil, nil, ErrInvalidRESP
+		}
+		return lua.LString(rest[:n]), rest[n+2:], nil
+	case ArrSymbol, MapSymbol:
+		n, err := strconv.Atoi(line)
+		if err != nil {
+			return nil, nil, ErrInvalidRESP
+		}
+		if n < 0 {
+			return lua.LFalse, rest, nil
+		}
+		if b[0] == MapSymbol {
+			n *= 2
+		}
+
+		t := L.CreateTable(n, 0)
+		for range n {
+			var el lua.LValue
+			if el, rest, err = respToLua(L, rest); err != nil {
+				return nil, nil, err
+			}
+			t.Append(el)
+		}
+		return t, rest, nil
+	}
+
+	return nil, nil, ErrInvalidRESP
+}
+
+// luaToResponse converts value returned by script to RESP reply. Numbers are truncated to integers,
+// false is a null reply and arrays end at the first nil the way Redis does it
+func luaToResponse(v lua.LValue, depth int) ([]byte, error) {
+	if depth > 64 {
+		return nil, ErrScriptNestingTooDeep
+	}
+
+	switch v := v.(type) {
+	case lua.LString:
+		return bulkStrResponse([]byte(v)), nil
+	case lua.LNumber:
+		return intResponse(int64(v)), nil
+	case lua.LBool:
+		if !v {
+			return []byte(NullResponse), nil
+		}
+		return []byte(string(BoolSymbol) + strconv.FormatBool(bool(v)) + CRLF), nil
+	case *lua.LTable:
+		if msg, ok := v.RawGetString("err").(lua.LString); ok {
+			return nil, errors.New(string(ErrorSymbol) + string(msg) + CRLF)
+		}
+		if msg, ok := v.RawGetString("ok").(lua.LString); ok {
+			return simpleStrResponse(string(msg)), nil
+		}
+
+		var elems [][]byte
+		for i := 1; ; i++ {
+			el := v.RawGetInt(i)
+			if el == lua.LNil {
+				break
+			}
+
+			res, err := luaToResponse(el, depth+1)
+			if err != nil {
+				res = []byte(err.Error())
+			}
+			elems = append(elems, res)
+		}
+		return arrayResponse(elems...), nil
+	}
+
+	return []byte(NullResponse), nil
+}
@@ -0,0 +1,111 @@
+package main
+
+import (
+	"strings"
+
+	lua "github.com/yuin/gopher-lua"
+)
+
+// EVAL script numkeys [key [key ...]] [arg [arg ...]]
+func evalHandler(args []*KvsValue) ([]byte, error) {
+	return eval(args, false, false)
+}
+
+// EVALSHA sha1 numkeys [key [key ...]] [arg [arg ...]]
+func evalshaHandler(args []*KvsValue) ([]byte, error) {
+	return eval(args, true, false)
+}
+
+// EVAL_RO script numkeys [key [key ...]] [arg [arg ...]]
+func evalRoHandler(args []*KvsValue) ([]byte, error) {
+	return eval(args, false, true)
+}
+
+// EVALSHA_RO sha1 numkeys [key [key ...]] [arg [arg ...]]
+func evalshaRoHandler(args []*KvsValue) ([]byte, error) {
+	return eval(args, true, true)
+}
+
+func eval(args []*KvsValue, bySHA, readOnly bool) ([]byte, error) {
+	numKeys, err := argToInt64(args[1])
+	if err != nil {
+		return nil, err
+	}
+	if numKeys < 0 {
+		return nil, ErrScriptNegativeKeys
+	}
+	if numKeys > int64(len(args)-2) {
+		return nil, ErrScriptTooManyKeys
+	}
+
+	var sha string
+	if bySHA {
+		sha = strings.ToLower(argToString(args[0]))
+	} else if sha, err = loadScript(argToString(args[0])); err != nil {
+		return nil, err
+	}
+
+	proto, ok := scripting.scripts[sha]
+	if !ok {
+		return nil, ErrNoScript
+	}
+
+	return runScript(proto, sha, args[2:2+numKeys], args[2+numKeys:], readOnly)
+}
+
+// SCRIPT LOAD script | EXISTS sha1 [sha1 ...] | FLUSH [ASYNC | SYNC] | KILL
+func scriptHandler(args []*KvsValue) ([]byte, error) {
+	sub := strings.ToUpper(argToString(args[0]))
+
+	switch sub {
+	case "LOAD":
+		if len(args) != 2 {
+			return nil, wrongArgsCountErr("SCRIPT|LOAD")
+		}
+
+		sha, err := loadScript(argToString(args[1]))
+		if err != nil {
+			return nil, err
+		}
+
+		return bulkStrResponse([]byte(sha)), nil
+	case "EXISTS":
+		if len(args) < 2 {
+			return nil, wrongArgsCountErr("SCRIPT|EXISTS")
+		}
+
+		res := make([][]byte, len(args)-1)
+		for i, arg := range args[1:] {
+			_, ok := scripting.scripts[strings.ToLower(argToString(arg))]
+			res[i] = boolIntResponse(ok)
+		}
+
+		return arrayResponse(res...), nil
+	case "FLUSH":
+		if len(args) > 2 {
+			return nil, wrongArgsCountErr("SCRIPT|FLUSH")
+		}
+		if len(args) == 2 {
+			if mode := strings.ToUpper(argToString(args[1])); mode != "ASYNC" && mode != "SYNC" {
+				return nil, ErrSyntax
+			}
+		}
+
+		scripting.scripts = make(map[string]*lua.FunctionProto)
+		closeLuaState()
+
+		return []byte(OkResponse), nil
+	case "KILL":
+		if len(args) != 1 {
+			return nil, wrongArgsCountErr("SCRIPT|KILL")
+		}
+
+		if err := killScript(); err != nil {
+			return nil, err
+		}
+
+		return []byte(OkResponse), nil
+	}
+
+	return nil, ErrUnknownSubcommand
+}
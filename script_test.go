@@ -0,0 +1,122 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestScriptEval(t *testing.T) {
+	initStorage()
+	var tx txState
+
+	for _, step := range []struct {
+		cmd   []string
+		reply string
+	}{
+		{[]string{"EVAL", "return {1, 2.9, 'x', true, false, nil, 3}", "0"}, "*5\r\n:1\r\n:2\r\n$1\r\nx\r\n#true\r\n_\r\n"},
+		{[]string{"EVAL", "return redis.call('SET', KEYS[1], ARGV[1])", "1", "k", "v"}, "+OK\r\n"},
+		{[]string{"EVAL", "redis.call('SET', 'n', 41); return redis.call('GET', 'n') + 1", "0"}, ":42\r\n"},
+		{[]string{"GET", "n"}, ":41\r\n"},
+		{[]string{"EVAL", "redis.call('SET', 'b', true); return type(redis.call('GET', 'b'))", "0"}, "$7\r\nboolean\r\n"},
+		{[]string{"GET", "b"}, "#true\r\n"},
+		{[]string{"EVAL", "return redis.call('GET', 'missing')", "0"}, NullResponse},
+		{[]string{"EVAL", "return redis.call('HGET', 'k', 'f')", "0"}, ErrWrongType.Error()},
+		{[]string{"EVAL", "return redis.pcall('HGET', 'k', 'f').err", "0"}, "$65\r\nWRONGTYPE Operation against a key holding the wrong kind of value\r\n"},
+		{[]string{"EVAL", "return redis.status_reply('FINE')", "0"}, "+FINE\r\n"},
+		{[]string{"EVAL", "return redis.call('EVAL', 'return 1', '0')", "0"}, ErrScriptForbiddenCmd.Error()},
+		{[]string{"EVAL", "return 1", "-1"}, ErrScriptNegativeKeys.Error()},
+		{[]string{"EVAL", "return 1", "2", "k"}, ErrScriptTooManyKeys.Error()},
+		{[]string{"EVAL_RO", "return redis.call('GET', KEYS[1])", "1", "k"}, "$1\r\nv\r\n"},
+		{[]string{"EVAL_RO", "return redis.call('SET', 'k', 'w')", "0"}, ErrScriptReadOnly.Error()},
+		{[]string{"SCRIPT", "LOAD", "return ARGV[1]"}, "$40\r\n098e0f0d1448c0a81dafe820f66d460eb09263da\r\n"},
+		{[]string{"EVALSHA", "098e0f0d1448c0a81dafe820f66d460eb09263da", "0", "hi"}, "$2\r\nhi\r\n"},
+		{[]string{"SCRIPT", "EXISTS", "098e0f0d1448c0a81dafe820f66d460eb09263da", "abc"}, "*2\r\n:1\r\n:0\r\n"},
+		{[]string{"SCRIPT", "FLUSH"}, "+OK\r\n"},
+		{[]string{"EVALSHA", "098e0f0d1448c0a81dafe820f66d460eb09263da", "0"}, ErrNoScript.Error()},
+	} {
+		if got := dispatchTest(t, &tx, step.cmd[0], step.cmd[1:]...); got != step.reply {
+			t.Errorf("%v: got %q, expected: %q", step.cmd, got, step.reply)
+		}
+	}
+}
+
+// Scripts can't change globals or libraries for the next ones, and SCRIPT FLUSH starts them afresh
+func TestScriptGlobals(t *testing.T) {
+	initStorage()
+	var tx txState
+
+	for _, script := range []string{
+		"redis = nil; return 1",
+		"x = 5",
+		"function f() end",
+		"redis.call = nil",
+		"string.len = nil",
+		"_G.x = 5",
+		"getmetatable(_G).__index.x = 5",
+		"getmetatable('').__index.len = nil",
+		"rawset(_G, 'x', 5)",
+		"getfenv(print).x = 5",
+	} {
+		if got := dispatchTest(t, &tx, "EVAL", script, "0"); !strings.HasPrefix(got, "-ERR Error running script") {
+			t.Errorf("%q: %q", script, got)
+		}
+	}
+	for _, step := range []struct{ script, reply string }{
+		{"return redis.call('SET', 'k', 'v')", OkResponse},
+		{"return type(x)", "$3\r\nnil\r\n"},
+		{"return string.len('abc')", ":3\r\n"},
+		{"local s = 'abc'; return s:upper()", "$3\r\nABC\r\n"},
+	} {
+		if got := dispatchTest(t, &tx, "EVAL", step.script, "0"); got != step.reply {
+			t.Errorf("%q: got %q, expected: %q", step.script, got, step.reply)
+		}
+	}
+
+	kvs.mu.Lock()
+	state := scripting.state
+	kvs.mu.Unlock()
+	dispatchTest(t, &tx, "SCRIPT", "FLUSH")
+	dispatchTest(t, &tx, "EVAL", "return 1", "0")
+	kvs.mu.Lock()
+	flushed := scripting.state != state
+	kvs.mu.Unlock()
+	if !flushed {
+		t.Error("SCRIPT FLUSH has kept the Lua state")
+	}
+}
+
+func TestScriptBusy(t *testing.T) {
+	initStorage()
+	config.busyReplyThreshold = 50
+	defer func() { config.busyReplyThreshold = scriptDefaultBusyThreshold }()
+
+	var tx txState
+	if got := dispatchTest(t, &tx, "SCRIPT", "KILL"); got != ErrNotBusy.Error() {
+		t.Fatalf("SCRIPT KILL without script: got %q", got)
+	}
+
+	done := make(chan string)
+	go func() {
+		var tx txState
+		done <- dispatchTest(t, &tx, "EVAL", "while true do end", "0")
+	}()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for dispatchTest(t, &tx, "GET", "k") != ErrBusy.Error() {
+		if time.Now().After(deadline) {
+			t.Fatal("no BUSY reply while script is running")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	if err := killScript(); err != nil {
+		t.Fatalf("killScript: %v", err)
+	}
+	if got := <-done; got != ErrScriptKilled.Error() {
+		t.Errorf("killed script: got %q, expected: %q", got, ErrScriptKilled.Error())
+	}
+	if got := dispatchTest(t, &tx, "GET", "k"); got != NullResponse {
+		t.Errorf("GET after kill: got %q", got)
+	}
+}
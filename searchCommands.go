@@ -0,0 +1,814 @@
+package main
+
+import (
+	"cmp"
+	"maps"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+func lookupSearchIndex(name string) (*searchIndex, error) {
+	idx, ok := kvs.searchIndexes[name]
+	if !ok {
+		return nil, ErrFTUnknownIndex
+	}
+
+	return idx, nil
+}
+
+// ftCountedArgs returns arguments of "count arg ..." list starting at args[i] and index right after it
+func ftCountedArgs(args []*KvsValue, i int) ([]*KvsValue, int, error) {
+	if i >= len(args) {
+		return nil, 0, ErrSyntax
+	}
+
+	n, err := argToInt64(args[i])
+	if err != nil || n < 0 || int64(len(args)-i-1) < n {
+		return nil, 0, ErrSyntax
+	}
+
+	return args[i+1 : i+1+int(n)], i + 1 + int(n), nil
+}
+
+// ftProperty strips @ from property name used by FT.AGGREGATE
+func ftProperty(arg *KvsValue) (string, error) {
+	name, ok := strings.CutPrefix(argToString(arg), "@")
+	if !ok || name == "" {
+		return "", ErrFTPropertyPrefix
+	}
+
+	return name, nil
+}
+
+// FT.CREATE index [ON HASH | JSON] [PREFIX count prefix ...] [STOPWORDS count word ...] SCHEMA
+// identifier [AS attribute] TEXT [WEIGHT weight] [NOSTEM] | TAG [SEPARATOR sep] [CASESENSITIVE] | NUMERIC | GEO
+// [SORTABLE] ...
+func ftCreateHandler(args []*KvsValue) ([]byte, error) {
+	name := argToString(args[0])
+	if _, ok := kvs.searchIndexes[name]; ok {
+		return nil, ErrFTIndexExists
+	}
+
+	on := ftOnHash
+	var prefixes []string
+	stopwords := ftDefaultStopwords
+
+	i := 1
+	for ; i < len(args) && strings.ToUpper(argToString(args[i])) != "SCHEMA"; i++ {
+		switch strings.ToUpper(argToString(args[i])) {
+		case "ON":
+			if i+1 >= len(args) {
+				return nil, ErrSyntax
+			}
+			i++
+			on = strings.ToUpper(argToString(args[i]))
+			if on != ftOnHash && on != ftOnJSON {
+				return nil, ErrSyntax
+			}
+		case "PREFIX", "STOPWORDS":
+			list, next, err := ftCountedArgs(args, i+1)
+			if err != nil {
+				return nil, err
+			}
+
+			words := make([]string, len(list))
+			for j, arg := range list {
+				words[j] = argToString(arg)
+			}
+
+			if strings.ToUpper(argToString(args[i])) == "PREFIX" {
+				prefixes = words
+			} else {
+				stopwords = words
+			}
+			i = next - 1
+		default:
+			return nil, ErrSyntax
+		}
+	}
+
+	fields, err := parseFTSchema(args[min(i+1, len(args)):], on)
+	if err != nil {
+		return nil, err
+	}
+
+	idx := newSearchIndex(name, on, prefixes, stopwords, fields)
+	idx.scan()
+	kvs.searchIndexes[name] = idx
+	markDirty()
+
+	return []byte(OkResponse), nil
+}
+
+func parseFTSchema(args []*KvsValue, on string) ([]*ftField, error) {
+	if len(args) == 0 {
+		return nil, ErrFTNoFields
+	}
+
+	var fields []*ftField
+
+	for i := 0; i < len(args); {
+		f := &ftField{path: argToString(args[i]), weight: 1, separator: ","}
+		f.name = f.path
+		i++
+
+		if on == ftOnJSON {
+			jp, err := parseJSONPath(f.path)
+			if err != nil {
+				return nil, err
+			}
+			f.jsonPath = jp
+		}
+
+		if i+1 < len(args) && strings.ToUpper(argToString(args[i])) == "AS" {
+			f.name = argToString(args[i+1])
+			i += 2
+		}
+
+		if i >= len(args) {
+			return nil, ErrFTInvalidFieldType
+		}
+
+		f.typ = strings.ToUpper(argToString(args[i]))
+		if !slices.Contains([]string{ftFieldText, ftFieldTag, ftFieldNumeric, ftFieldGeo}, f.typ) {
+			return nil, ErrFTInvalidFieldType
+		}
+		i++
+
+		if slices.ContainsFunc(fields, func(other *ftField) bool { return other.name == f.name }) {
+			return nil, ErrFTDuplicateField
+		}
+
+	options:
+		for ; i < len(args); i++ {
+			opt := strings.ToUpper(argToString(args[i]))
+
+			switch {
+			case opt == "SORTABLE":
+				f.sortable = true
+			case opt == "UNF":
+			case opt == "NOSTEM" && f.typ == ftFieldText:
+				f.noStem = true
+			case opt == "CASESENSITIVE" && f.typ == ftFieldTag:
+				f.caseSensitive = true
+			case opt == "WEIGHT" && f.typ == ftFieldText && i+1 < len(args):
+				i++
+				w, err := argToFloat64(args[i])
+				if err != nil || w < 0 {
+					return nil, ErrSyntax
+				}
+				f.weight = w
+			case opt == "SEPARATOR" && f.typ == ftFieldTag && i+1 < len(args):
+				i++
+				f.separator = argToString(args[i])
+				if len(f.separator) != 1 {
+					return nil, ErrSyntax
+				}
+			default:
+				break options
+			}
+		}
+
+		fields = append(fields, f)
+	}
+
+	return fields, nil
+}
+
+// FT.DROPINDEX index [DD]
+func ftDropindexHandler(args []*KvsValue) ([]byte, error) {
+	if len(args) > 2 || (len(args) == 2 && strings.ToUpper(argToString(args[1])) != "DD") {
+		return nil, ErrSyntax
+	}
+
+	idx, err := lookupSearchIndex(argToString(args[0]))
+	if err != nil {
+		return nil, err
+	}
+
+	delete(kvs.searchIndexes, idx.name)
+	markDirty()
+
+	if len(args) == 2 {
+		for key := range idx.docs {
+			if removeKey(key) {
+				signalModifiedKey(key, notifyGeneric, "del")
+			}
+		}
+	}
+
+	return []byte(OkResponse), nil
+}
+
+// FT._LIST
+func ftListHandler(args []*KvsValue) ([]byte, error) {
+	var res [][]byte
+	for _, name := range slices.Sorted(maps.Keys(kvs.searchIndexes)) {
+		res = append(res, []byte(name))
+	}
+
+	return bulkStrArrayResponse(res...), nil
+}
+
+// FT.INFO index
+func ftInfoHandler(args []*KvsValue) ([]byte, error) {
+	idx, err := lookupSearchIndex(argToString(args[0]))
+	if err != nil {
+		return nil, err
+	}
+
+	prefixes := make([][]byte, len(idx.prefixes))
+	for i, p := range idx.prefixes {
+		prefixes[i] = []byte(p)
+	}
+
+	numTerms := 0
+	attrs := make([][]byte, len(idx.fields))
+	for i, f := range idx.fields {
+		numTerms += len(f.tags)
+		if f.terms != nil {
+			numTerms += f.terms.len()
+		}
+
+		attr := [][]byte{[]byte("identifier"), []byte(f.path), []byte("attribute"), []byte(f.name),
+			[]byte("type"), []byte(f.typ)}
+		switch f.typ {
+		case ftFieldText:
+			attr = append(attr, []byte("WEIGHT"), []byte(strconv.FormatFloat(f.weight, 'f', -1, 64)))
+			if f.noStem {
+				attr = append(attr, []byte("NOSTEM"))
+			}
+		case ftFieldTag:
+			attr = append(attr, []byte("SEPARATOR"), []byte(f.separator))
+			if f.caseSensitive {
+				attr = append(attr, []byte("CASESENSITIVE"))
+			}
+		}
+		if f.sortable {
+			attr = append(attr, []byte("SORTABLE"))
+		}
+
+		attrs[i] = bulkStrArrayResponse(attr...)
+	}
+
+	return arrayResponse(
+		bulkStrResponse([]byte("index_name")), bulkStrResponse([]byte(idx.name)),
+		bulkStrResponse([]byte("index_definition")), arrayResponse(
+			bulkStrResponse([]byte("key_type")), bulkStrResponse([]byte(idx.on)),
+			bulkStrResponse([]byte("prefixes")), bulkStrArrayResponse(prefixes...),
+		),
+		bulkStrResponse([]byte("attributes")), arrayResponse(attrs...),
+		bulkStrResponse([]byte("num_docs")), intResponse(int64(len(idx.docs))),
+		bulkStrResponse([]byte("num_terms")), intResponse(int64(numTerms)),
+	), nil
+}
+
+type ftResult struct {
+	key   string
+	score float64
+}
+
+// sortFTResults orders results by attribute with missing values last, or by score when sortBy is empty
+func sortFTResults(idx *searchIndex, results []ftResult, sortBy string, desc bool) {
+	slices.SortFunc(results, func(a, b ftResult) int {
+		if sortBy == "" {
+			return cmp.Or(cmpFloat(b.score, a.score), strings.Compare(a.key, b.key))
+		}
+
+		va, okA := idx.docs[a.key].values[sortBy]
+		vb, okB := idx.docs[b.key].values[sortBy]
+
+		var c int
+		switch {
+		case okA && okB:
+			c = ftCompareValues(va, vb)
+			if desc {
+				c = -c
+			}
+		case okA:
+			c = -1
+		case okB:
+			c = 1
+		}
+
+		return cmp.Or(c, strings.Compare(a.key, b.key))
+	})
+}
+
+// FT.SEARCH index query [NOCONTENT] [VERBATIM] [WITHSCORES] [RETURN count field ...]
+// [SORTBY attribute [ASC | DESC]] [LIMIT offset num]
+func ftSearchHandler(args []*KvsValue) ([]byte, error) {
+	idx, err := lookupSearchIndex(argToString(args[0]))
+	if err != nil {
+		return nil, err
+	}
+
+	noContent, verbatim, withScores, desc := false, false, false, false
+	var returnFields []string
+	var sortBy string
+	offset, num := int64(0), int64(10)
+
+	for i := 2; i < len(args); i++ {
+		switch strings.ToUpper(argToString(args[i])) {
+		case "NOCONTENT":
+			noContent = true
+		case "VERBATIM":
+			verbatim = true
+		case "WITHSCORES":
+			withScores = true
+		case "RETURN":
+			list, next, err := ftCountedArgs(args, i+1)
+			if err != nil {
+				return nil, err
+			}
+			returnFields = make([]string, len(list))
+			for j, arg := range list {
+				returnFields[j] = argToString(arg)
+			}
+			i = next - 1
+		case "SORTBY":
+			if i+1 >= len(args) {
+				return nil, ErrSyntax
+			}
+			i++
+			sortBy = strings.TrimPrefix(argToString(args[i]), "@")
+			if idx.field(sortBy) == nil {
+				return nil, ftUnknownFieldErr(sortBy)
+			}
+			if i+1 < len(args) {
+				switch strings.ToUpper(argToString(args[i+1])) {
+				case "ASC":
+					i++
+				case "DESC":
+					desc = true
+					i++
+				}
+			}
+		case "LIMIT":
+			if i+2 >= len(args) {
+				return nil, ErrSyntax
+			}
+			if offset, err = argToInt64(args[i+1]); err != nil || offset < 0 {
+				return nil, ErrSyntax
+			}
+			if num, err = argToInt64(args[i+2]); err != nil || num < 0 {
+				return nil, ErrSyntax
+			}
+			i += 2
+		case "DIALECT":
+			i++
+		default:
+			return nil, ErrSyntax
+		}
+	}
+
+	q, err := parseFTQuery(idx, argToString(args[1]), verbatim)
+	if err != nil {
+		return nil, err
+	}
+
+	matched := q.eval(idx)
+	results := make([]ftResult, 0, len(matched))
+	for key, score := range matched {
+		results = append(results, ftResult{key: key, score: score})
+	}
+	sortFTResults(idx, results, sortBy, desc)
+
+	page := results[min(int(offset), len(results)):]
+	page = page[:min(int(num), len(page))]
+
+	res := [][]byte{intResponse(int64(len(results)))}
+	for _, r := range page {
+		res = append(res, bulkStrResponse([]byte(r.key)))
+
+		if withScores {
+			res = append(res, bulkStrResponse([]byte(strconv.FormatFloat(r.score, 'f', -1, 64))))
+		}
+
+		if noContent {
+			continue
+		}
+
+		var pairs [][]byte
+		if returnFields == nil {
+			for _, s := range idx.documentFields(r.key) {
+				pairs = append(pairs, []byte(s))
+			}
+		}
+		for _, name := range returnFields {
+			if v, ok := idx.attributeValue(r.key, name); ok {
+				pairs = append(pairs, []byte(name), []byte(v))
+			}
+		}
+		res = append(res, bulkStrArrayResponse(pairs...))
+	}
+
+	return arrayResponse(res...), nil
+}
+
+// ================================ aggregation ========================================
+
+// ftRow is a row of FT.AGGREGATE pipeline. All attributes of the document can be used by steps, but only
+// names loaded or produced by GROUPBY are in the reply. Values are strings or []string made by TOLIST
+type ftRow struct {
+	key   string
+	names []string
+	vals  map[string]any
+}
+
+func (r *ftRow) set(name string, v any) {
+	if !slices.Contains(r.names, name) {
+		r.names = append(r.names, name)
+	}
+	r.vals[name] = v
+}
+
+func (r *ftRow) str(name string) (string, bool) {
+	s, ok := r.vals[name].(string)
+	return s, ok
+}
+
+type ftStep func(rows []*ftRow) []*ftRow
+
+type ftReducer struct {
+	fn    string
+	arg   string
+	alias string
+}
+
+var ftReducerArgs = map[string]int{
+	"COUNT": 0, "COUNT_DISTINCT": 1, "SUM": 1, "MIN": 1, "MAX": 1, "AVG": 1, "TOLIST": 1, "FIRST_VALUE": 1,
+}
+
+func formatFTNumber(v float64) string {
+	return strconv.FormatFloat(v, 'f', -1, 64)
+}
+
+// reduce returns value of reducer for group rows, nil when there is no value
+func (red *ftReducer) reduce(rows []*ftRow) any {
+	if red.fn == "COUNT" {
+		return strconv.Itoa(len(rows))
+	}
+
+	var vals []string
+	for _, r := range rows {
+		if s, ok := r.str(red.arg); ok {
+			vals = append(vals, s)
+		}
+	}
+
+	switch red.fn {
+	case "COUNT_DISTINCT":
+		return strconv.Itoa(len(slices.Compact(slices.Sorted(slices.Values(vals)))))
+	case "TOLIST":
+		var list []string
+		for _, v := range vals {
+			if !slices.Contains(list, v) {
+				list = append(list, v)
+			}
+		}
+		return list
+	case "FIRST_VALUE":
+		if len(vals) == 0 {
+			return nil
+		}
+		return vals[0]
+	}
+
+	var nums []float64
+	for _, v := range vals {
+		if n, err := strconv.ParseFloat(v, 64); err == nil {
+			nums = append(nums, n)
+		}
+	}
+
+	var sum float64
+	for _, n := range nums {
+		sum += n
+	}
+
+	switch {
+	case red.fn == "SUM":
+		return formatFTNumber(sum)
+	case len(nums) == 0:
+		return nil
+	case red.fn == "MIN":
+		return formatFTNumber(slices.Min(nums))
+	case red.fn == "MAX":
+		return formatFTNumber(slices.Max(nums))
+	}
+
+	return formatFTNumber(sum / float64(len(nums)))
+}
+
+func parseFTLoad(idx *searchIndex, args []*KvsValue, i int) (ftStep, int, error) {
+	if i+1 < len(args) && argToString(args[i+1]) == "*" {
+		return func(rows []*ftRow) []*ftRow {
+			for _, r := range rows {
+				fields := idx.documentFields(r.key)
+				for j := 0; j < len(fields); j += 2 {
+					r.set(fields[j], fields[j+1])
+				}
+			}
+			return rows
+		}, i + 2, nil
+	}
+
+	list, next, err := ftCountedArgs(args, i+1)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	// every loaded name is an attribute, hash field or JSONPath with optional AS alias
+	var names, aliases []string
+	for j := 0; j < len(list); j++ {
+		name := strings.TrimPrefix(argToString(list[j]), "@")
+		alias := name
+		if j+2 < len(list) && strings.ToUpper(argToString(list[j+1])) == "AS" {
+			alias = argToString(list[j+2])
+			j += 2
+		}
+		names = append(names, name)
+		aliases = append(aliases, alias)
+	}
+
+	return func(rows []*ftRow) []*ftRow {
+		for _, r := range rows {
+			for j, name := range names {
+				if name == "__key" {
+					r.set(aliases[j], r.key)
+				} else if v, ok := idx.attributeValue(r.key, name); ok {
+					r.set(aliases[j], v)
+				}
+			}
+		}
+		return rows
+	}, next, nil
+}
+
+func parseFTGroupBy(args []*KvsValue, i int) (ftStep, int, error) {
+	list, i, err := ftCountedArgs(args, i+1)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	props := make([]string, len(list))
+	for j, arg := range list {
+		if props[j], err = ftProperty(arg); err != nil {
+			return nil, 0, err
+		}
+	}
+
+	var reducers []*ftReducer
+	for i < len(args) && strings.ToUpper(argToString(args[i])) == "REDUCE" {
+		if i+1 >= len(args) {
+			return nil, 0, ErrSyntax
+		}
+
+		red := &ftReducer{fn: strings.ToUpper(argToString(args[i+1]))}
+		nargs, ok := ftReducerArgs[red.fn]
+		if !ok {
+			return nil, 0, ErrFTUnknownReducer
+		}
+
+		var redArgs []*KvsValue
+		if redArgs, i, err = ftCountedArgs(args, i+2); err != nil {
+			return nil, 0, err
+		}
+		if len(redArgs) != nargs {
+			return nil, 0, ErrSyntax
+		}
+		if nargs == 1 {
+			if red.arg, err = ftProperty(redArgs[0]); err != nil {
+				return nil, 0, err
+			}
+		}
+
+		red.alias = "__generated_alias" + strings.ToLower(red.fn) + red.arg
+		if i+1 < len(args) && strings.ToUpper(argToString(args[i])) == "AS" {
+			red.alias = argToString(args[i+1])
+			i += 2
+		}
+
+		reducers = append(reducers, red)
+	}
+
+	return func(rows []*ftRow) []*ftRow {
+		var groups []*ftRow
+		var members [][]*ftRow
+		groupIx := make(map[string]int)
+
+		for _, r := range rows {
+			var sb strings.Builder
+			for _, p := range props {
+				s, _ := r.str(p)
+				sb.WriteString(s)
+				sb.WriteByte(0)
+			}
+
+			gi, ok := groupIx[sb.String()]
+			if !ok {
+				g := &ftRow{vals: make(map[string]any)}
+				for _, p := range props {
+					g.names = append(g.names, p)
+					if s, ok := r.str(p); ok {
+						g.vals[p] = s
+					}
+				}
+
+				gi = len(groups)
+				groupIx[sb.String()] = gi
+				groups = append(groups, g)
+				members = append(members, nil)
+			}
+			members[gi] = append(members[gi], r)
+		}
+
+		for gi, g := range groups {
+			for _, red := range reducers {
+				g.names = append(g.names, red.alias)
+				if v := red.reduce(members[gi]); v != nil {
+					g.vals[red.alias] = v
+				}
+			}
+		}
+
+		return groups
+	}, i, nil
+}
+
+func parseFTSortBy(args []*KvsValue, i int) (ftStep, int, error) {
+	list, i, err := ftCountedArgs(args, i+1)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	type sortKey struct {
+		prop string
+		desc bool
+	}
+
+	var keys []sortKey
+	for j := 0; j < len(list); j++ {
+		prop, err := ftProperty(list[j])
+		if err != nil {
+			return nil, 0, err
+		}
+
+		k := sortKey{prop: prop}
+		if j+1 < len(list) {
+			switch strings.ToUpper(argToString(list[j+1])) {
+			case "ASC":
+				j++
+			case "DESC":
+				k.desc = true
+				j++
+			}
+		}
+		keys = append(keys, k)
+	}
+
+	maxRows := int64(-1)
+	if i+1 < len(args) && strings.ToUpper(argToString(args[i])) == "MAX" {
+		if maxRows, err = argToInt64(args[i+1]); err != nil || maxRows < 0 {
+			return nil, 0, ErrSyntax
+		}
+		i += 2
+	}
+
+	return func(rows []*ftRow) []*ftRow {
+		slices.SortStableFunc(rows, func(a, b *ftRow) int {
+			for _, k := range keys {
+				va, okA := a.str(k.prop)
+				vb, okB := b.str(k.prop)
+
+				var c int
+				switch {
+				case okA && okB:
+					c = ftCompareValues(va, vb)
+					if k.desc {
+						c = -c
+					}
+				case okA:
+					c = -1
+				case okB:
+					c = 1
+				}
+
+				if c != 0 {
+					return c
+				}
+			}
+			return 0
+		})
+
+		if maxRows >= 0 && int64(len(rows)) > maxRows {
+			rows = rows[:maxRows]
+		}
+		return rows
+	}, i, nil
+}
+
+func ftRowResponse(r *ftRow) []byte {
+	elems := make([][]byte, 0, len(r.names)*2)
+	for _, name := range r.names {
+		elems = append(elems, bulkStrResponse([]byte(name)))
+
+		switch v := r.vals[name].(type) {
+		case string:
+			elems = append(elems, bulkStrResponse([]byte(v)))
+		case []string:
+			list := make([][]byte, len(v))
+			for i, s := range v {
+				list[i] = []byte(s)
+			}
+			elems = append(elems, bulkStrArrayResponse(list...))
+		default:
+			elems = append(elems, []byte(NullResponse))
+		}
+	}
+
+	return arrayResponse(elems...)
+}
+
+// FT.AGGREGATE index query [VERBATIM] [LOAD count field ... | LOAD *] [GROUPBY nargs property ...
+// [REDUCE function nargs arg ... [AS name] ...] ...] [SORTBY nargs property [ASC | DESC] ... [MAX num]]
+// [LIMIT offset num]
+func ftAggregateHandler(args []*KvsValue) ([]byte, error) {
+	idx, err := lookupSearchIndex(argToString(args[0]))
+	if err != nil {
+		return nil, err
+	}
+
+	verbatim := false
+	var steps []ftStep
+
+	for i := 2; i < len(args); {
+		var step ftStep
+
+		switch strings.ToUpper(argToString(args[i])) {
+		case "VERBATIM":
+			verbatim = true
+			i++
+			continue
+		case "DIALECT":
+			i += 2
+			continue
+		case "LOAD":
+			step, i, err = parseFTLoad(idx, args, i)
+		case "GROUPBY":
+			step, i, err = parseFTGroupBy(args, i)
+		case "SORTBY":
+			step, i, err = parseFTSortBy(args, i)
+		case "LIMIT":
+			if i+2 >= len(args) {
+				return nil, ErrSyntax
+			}
+			offset, err1 := argToInt64(args[i+1])
+			num, err2 := argToInt64(args[i+2])
+			if err1 != nil || err2 != nil || offset < 0 || num < 0 {
+				return nil, ErrSyntax
+			}
+			step = func(rows []*ftRow) []*ftRow {
+				rows = rows[min(int(offset), len(rows)):]
+				return rows[:min(int(num), len(rows))]
+			}
+			i += 3
+		default:
+			return nil, ErrSyntax
+		}
+
+		if err != nil {
+			return nil, err
+		}
+		steps = append(steps, step)
+	}
+
+	q, err := parseFTQuery(idx, argToString(args[1]), verbatim)
+	if err != nil {
+		return nil, err
+	}
+
+	matched := q.eval(idx)
+	rows := make([]*ftRow, 0, len(matched))
+	for _, key := range slices.Sorted(maps.Keys(matched)) {
+		r := &ftRow{key: key, vals: make(map[string]any)}
+		for name, v := range idx.docs[key].values {
+			r.vals[name] = v
+		}
+		rows = append(rows, r)
+	}
+
+	for _, step := range steps {
+		rows = step(rows)
+	}
+
+	res := make([][]byte, 0, len(rows)+1)
+	res = append(res, intResponse(int64(len(rows))))
+	for _, r := range rows {
+		res = append(res, ftRowResponse(r))
+	}
+
+	return arrayResponse(res...), nil
+}
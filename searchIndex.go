@@ -0,0 +1,417 @@
+package main
+
+import (
+	"maps"
+	"slices"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// Search index covers hashes or JSON documents with keys starting with one of its prefixes and is updated
+// on every change of such key. TEXT fields are split into words, stopwords are dropped and the rest is kept
+// both stemmed and as is in a radix tree of terms with word positions for every document, so prefix and
+// phrase queries are possible. TAG fields map normalized tags to documents, NUMERIC and GEO fields are
+// sorted sets of documents scored by the value or by geohash
+const (
+	ftOnHash = "HASH"
+	ftOnJSON = "JSON"
+
+	ftFieldText    = "TEXT"
+	ftFieldTag     = "TAG"
+	ftFieldNumeric = "NUMERIC"
+	ftFieldGeo     = "GEO"
+)
+
+var ftDefaultStopwords = []string{
+	"a", "is", "the", "an", "and", "are", "as", "at", "be", "but", "by", "for", "if", "in", "into", "it", "no",
+	"not", "of", "on", "or", "such", "that", "their", "then", "there", "these", "they", "this", "to", "was",
+	"will", "with",
+}
+
+type ftField struct {
+	name     string // attribute name used in queries
+	path     string // hash field or JSONPath
+	jsonPath *jsonPath
+	typ      string
+
+	weight        float64
+	noStem        bool
+	sortable      bool
+	separator     string
+	caseSensitive bool
+
+	terms  *radixTree[map[string][]int] // text: term -> document key -> positions of the term
+	tags   map[string]map[string]bool
+	values *sortedSet // numeric values or geohashes of documents
+}
+
+// ftDoc remembers what document added to the index, so it can be removed without looking at the old value
+type ftDoc struct {
+	values map[string]string   // attribute -> value as it is returned and sorted by
+	terms  map[string][]string // attribute -> terms or tags of the document
+}
+
+type searchIndex struct {
+	name      string
+	on        string
+	prefixes  []string
+	stopwords map[string]bool
+	fields    []*ftField
+	docs      map[string]*ftDoc
+}
+
+func newSearchIndex(name, on string, prefixes, stopwords []string, fields []*ftField) *searchIndex {
+	idx := &searchIndex{
+		name:      name,
+		on:        on,
+		prefixes:  prefixes,
+		stopwords: make(map[string]bool, len(stopwords)),
+		fields:    fields,
+		docs:      make(map[string]*ftDoc),
+	}
+
+	for _, w := range stopwords {
+		idx.stopwords[strings.ToLower(w)] = true
+	}
+
+	for _, f := range fields {
+		switch f.typ {
+		case ftFieldText:
+			f.terms = newRadixTree[map[string][]int]()
+		case ftFieldTag:
+			f.tags = make(map[string]map[string]bool)
+		default:
+			f.values = newSortedSet()
+		}
+	}
+
+	return idx
+}
+
+// createArgs returns FT.CREATE command creating the same index, it's how indexes are written to the AOF
+func (idx *searchIndex) createArgs() [][]byte {
+	args := [][]byte{[]byte("FT.CREATE"), []byte(idx.name), []byte("ON"), []byte(idx.on)}
+
+	args = append(args, []byte("PREFIX"), []byte(strconv.Itoa(len(idx.prefixes))))
+	for _, p := range idx.prefixes {
+		args = append(args, []byte(p))
+	}
+
+	args = append(args, []byte("STOPWORDS"), []byte(strconv.Itoa(len(idx.stopwords))))
+	for _, w := range slices.Sorted(maps.Keys(idx.stopwords)) {
+		args = append(args, []byte(w))
+	}
+
+	args = append(args, []byte("SCHEMA"))
+	for _, f := range idx.fields {
+		args = append(args, []byte(f.path), []byte("AS"), []byte(f.name), []byte(f.typ))
+		switch f.typ {
+		case ftFieldText:
+			args = append(args, []byte("WEIGHT"), []byte(strconv.FormatFloat(f.weight, 'g', -1, 64)))
+			if f.noStem {
+				args = append(args, []byte("NOSTEM"))
+			}
+		case ftFieldTag:
+			args = append(args, []byte("SEPARATOR"), []byte(f.separator))
+			if f.caseSensitive {
+				args = append(args, []byte("CASESENSITIVE"))
+			}
+		}
+		if f.sortable {
+			args = append(args, []byte("SORTABLE"))
+		}
+	}
+
+	return args
+}
+
+func (idx *searchIndex) field(name string) *ftField {
+	for _, f := range idx.fields {
+		if f.name == name {
+			return f
+		}
+	}
+
+	return nil
+}
+
+func (idx *searchIndex) covers(key string) bool {
+	return len(idx.prefixes) == 0 || slices.ContainsFunc(idx.prefixes, func(p string) bool {
+		return strings.HasPrefix(key, p)
+	})
+}
+
+func (idx *searchIndex) dtype() byte {
+	if idx.on == ftOnJSON {
+		return JSONDtype
+	}
+
+	return HashDtype
+}
+
+// scan indexes keys that already exist
+func (idx *searchIndex) scan() {
+	for key := range kvs.storage.All() {
+		idx.reindex(key)
+	}
+}
+
+// reindex brings index up to date with the current value of key
+func (idx *searchIndex) reindex(key string) {
+	if !idx.covers(key) {
+		return
+	}
+
+	idx.removeDoc(key)
+
+	if val, ok := storedValue(key); ok && val.dtype == idx.dtype() {
+		idx.addDoc(key, val)
+	}
+}
+
+// tokenize splits text into lowercase words
+func tokenize(text string) []string {
+	return strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r) && r != '_'
+	})
+}
+
+// normalizeTerm returns form of word kept in the index for f
+func (f *ftField) normalizeTerm(word string, verbatim bool) string {
+	if verbatim || f.noStem {
+		return word
+	}
+
+	return stem(word)
+}
+
+func (f *ftField) normalizeTag(tag string) string {
+	tag = strings.TrimSpace(tag)
+	if !f.caseSensitive {
+		tag = strings.ToLower(tag)
+	}
+
+	return tag
+}
+
+// jsonScalarString returns text of JSON scalar the way it's indexed and returned
+func jsonScalarString(n *jsonNode) (string, bool) {
+	switch n.kind {
+	case jsonString:
+		return n.str, true
+	case jsonNull, jsonObject, jsonArray:
+		return "", false
+	}
+
+	return n.String(), true
+}
+
+// fieldValues returns raw values of field in the stored value. JSON arrays of scalars give several values
+func (idx *searchIndex) fieldValues(f *ftField, val *KvsValue) []string {
+	if val.dtype == HashDtype {
+		if v, ok := val.object.(map[string]string)[f.path]; ok {
+			return []string{v}
+		}
+		return nil
+	}
+
+	var res []string
+	for _, m := range f.jsonPath.eval(val.object.(*jsonNode)) {
+		nodes := []*jsonNode{m.node}
+		if m.node.kind == jsonArray {
+			nodes = m.node.arr
+		}
+
+		for _, n := range nodes {
+			if s, ok := jsonScalarString(n); ok {
+				res = append(res, s)
+			}
+		}
+	}
+
+	return res
+}
+
+func parseGeoValue(s string) (lon, lat float64, ok bool) {
+	lonStr, latStr, found := strings.Cut(s, ",")
+	if !found {
+		return 0, 0, false
+	}
+
+	lon, err1 := strconv.ParseFloat(strings.TrimSpace(lonStr), 64)
+	lat, err2 := strconv.ParseFloat(strings.TrimSpace(latStr), 64)
+
+	return lon, lat, err1 == nil && err2 == nil && geoValidCoords(lon, lat)
+}
+
+func (idx *searchIndex) addDoc(key string, val *KvsValue) {
+	doc := &ftDoc{values: make(map[string]string), terms: make(map[string][]string)}
+
+	for _, f := range idx.fields {
+		vals := idx.fieldValues(f, val)
+		if len(vals) == 0 {
+			continue
+		}
+
+		switch f.typ {
+		case ftFieldText:
+			pos := 0
+			for _, v := range vals {
+				for _, word := range tokenize(v) {
+					if idx.stopwords[word] {
+						continue
+					}
+
+					// the word itself is kept too, so verbatim and prefix queries find it
+					for _, term := range slices.Compact([]string{f.normalizeTerm(word, false), word}) {
+						f.addPosting(term, key, pos)
+						if !slices.Contains(doc.terms[f.name], term) {
+							doc.terms[f.name] = append(doc.terms[f.name], term)
+						}
+					}
+					pos++
+				}
+			}
+			doc.values[f.name] = strings.Join(vals, " ")
+		case ftFieldTag:
+			for _, v := range vals {
+				for _, tag := range strings.Split(v, f.separator) {
+					if tag = f.normalizeTag(tag); tag == "" || slices.Contains(doc.terms[f.name], tag) {
+						continue
+					}
+
+					if f.tags[tag] == nil {
+						f.tags[tag] = make(map[string]bool)
+					}
+					f.tags[tag][key] = true
+					doc.terms[f.name] = append(doc.terms[f.name], tag)
+				}
+			}
+			doc.values[f.name] = strings.Join(vals, f.separator)
+		case ftFieldNumeric:
+			n, err := strconv.ParseFloat(vals[0], 64)
+			if err != nil {
+				continue
+			}
+			f.values.add(key, n)
+			doc.values[f.name] = vals[0]
+		case ftFieldGeo:
+			lon, lat, ok := parseGeoValue(vals[0])
+			if !ok {
+				continue
+			}
+			f.values.add(key, float64(geoEncode(lon, lat, geoStepMax)))
+			doc.values[f.name] = vals[0]
+		}
+	}
+
+	idx.docs[key] = doc
+}
+
+func (f *ftField) addPosting(term, key string, pos int) {
+	postings, ok := f.terms.find([]byte(term))
+	if !ok {
+		postings = make(map[string][]int)
+		f.terms.insert([]byte(term), postings)
+	}
+
+	postings[key] = append(postings[key], pos)
+}
+
+func (idx *searchIndex) removeDoc(key string) {
+	doc, ok := idx.docs[key]
+	if !ok {
+		return
+	}
+
+	for _, f := range idx.fields {
+		switch f.typ {
+		case ftFieldText:
+			for _, term := range doc.terms[f.name] {
+				postings, _ := f.terms.find([]byte(term))
+				delete(postings, key)
+				if len(postings) == 0 {
+					f.terms.remove([]byte(term))
+				}
+			}
+		case ftFieldTag:
+			for _, tag := range doc.terms[f.name] {
+				delete(f.tags[tag], key)
+				if len(f.tags[tag]) == 0 {
+					delete(f.tags, tag)
+				}
+			}
+		default:
+			f.values.remove(key)
+		}
+	}
+
+	delete(idx.docs, key)
+}
+
+// attributeValue returns value of attribute or hash field or JSONPath of document stored at key the way
+// FT.SEARCH RETURN and FT.AGGREGATE LOAD see it
+func (idx *searchIndex) attributeValue(key, name string) (string, bool) {
+	val, ok := kvs.storage.Get(key)
+	if !ok || val.dtype != idx.dtype() {
+		return "", false
+	}
+
+	path := name
+	if f := idx.field(name); f != nil {
+		path = f.path
+	}
+
+	if val.dtype == HashDtype {
+		v, ok := val.object.(map[string]string)[path]
+		return v, ok
+	}
+
+	jp, err := parseJSONPath(path)
+	if err != nil {
+		return "", false
+	}
+
+	matches := jp.eval(val.object.(*jsonNode))
+	if len(matches) == 0 {
+		return "", false
+	}
+
+	if s, ok := jsonScalarString(matches[0].node); ok {
+		return s, true
+	}
+
+	return matches[0].node.String(), true
+}
+
+// documentFields returns all fields of document as name-value pairs. JSON document is returned whole under $
+func (idx *searchIndex) documentFields(key string) []string {
+	val, ok := kvs.storage.Get(key)
+	if !ok {
+		return nil
+	}
+
+	if val.dtype == JSONDtype {
+		return []string{"$", val.object.(*jsonNode).String()}
+	}
+
+	h := val.object.(map[string]string)
+	res := make([]string, 0, len(h)*2)
+	for _, field := range slices.Sorted(maps.Keys(h)) {
+		res = append(res, field, h[field])
+	}
+
+	return res
+}
+
+// ftCompareValues orders attribute values numerically when both are numbers and as strings otherwise
+func ftCompareValues(a, b string) int {
+	x, errA := strconv.ParseFloat(a, 64)
+	y, errB := strconv.ParseFloat(b, 64)
+	if errA == nil && errB == nil {
+		return cmpFloat(x, y)
+	}
+
+	return strings.Compare(a, b)
+}
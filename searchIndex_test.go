@@ -0,0 +1,138 @@
+package main
+
+import (
+	"maps"
+	"slices"
+	"testing"
+)
+
+func testSearchIndex(t *testing.T) *searchIndex {
+	t.Helper()
+
+	idx := newSearchIndex("idx", ftOnHash, []string{"doc:"}, ftDefaultStopwords, []*ftField{
+		{name: "title", path: "title", typ: ftFieldText, weight: 2},
+		{name: "body", path: "body", typ: ftFieldText, weight: 1},
+		{name: "tags", path: "tags", typ: ftFieldTag, separator: ","},
+		{name: "price", path: "price", typ: ftFieldNumeric},
+		{name: "loc", path: "loc", typ: ftFieldGeo},
+	})
+
+	for key, fields := range map[string]map[string]string{
+		"doc:1": {"title": "Running shoes", "body": "light shoes for the marathon", "tags": "Sport,Shoes", "price": "100", "loc": "13.4,52.5"},
+		"doc:2": {"title": "Winter boots", "body": "warm boots for running in the snow", "tags": "shoes", "price": "150"},
+		"doc:3": {"title": "Marathon guide", "body": "how to run your first marathon", "tags": "books", "price": "20.5", "loc": "2.35,48.85"},
+	} {
+		idx.addDoc(key, &KvsValue{dtype: HashDtype, object: fields})
+	}
+
+	return idx
+}
+
+func searchKeys(t *testing.T, idx *searchIndex, query string) []string {
+	t.Helper()
+
+	q, err := parseFTQuery(idx, query, false)
+	if err != nil {
+		t.Fatalf("parseFTQuery(%q) returned error: %v", query, err)
+	}
+
+	return slices.Sorted(maps.Keys(q.eval(idx)))
+}
+
+func TestSearchQueries(t *testing.T) {
+	idx := testSearchIndex(t)
+
+	for query, expected := range map[string][]string{
+		"running":                      {"doc:1", "doc:2", "doc:3"},
+		"marathon shoes":               {"doc:1"},
+		"boots | guide":                {"doc:2", "doc:3"},
+		"marathon -shoes":              {"doc:3"},
+		"@title:running":               {"doc:1"},
+		"@title:(boots | guide)":       {"doc:2", "doc:3"},
+		`"first marathon"`:             {"doc:3"},
+		`"marathon first"`:             nil,
+		"mara*":                        {"doc:1", "doc:3"},
+		"@tags:{shoes}":                {"doc:1", "doc:2"},
+		"@tags:{sport | books}":        {"doc:1", "doc:3"},
+		"@price:[20.5 100]":            {"doc:1", "doc:3"},
+		"@price:[(20.5 +inf]":          {"doc:1", "doc:2"},
+		"@price:[-inf (100]":           {"doc:3"},
+		"@loc:[13.3 52.5 20 km]":       {"doc:1"},
+		"*":                            {"doc:1", "doc:2", "doc:3"},
+		"-*":                           nil,
+		"the":                          nil,
+		"the snow":                     {"doc:2"},
+		"@tags:{shoes} @price:[0 120]": {"doc:1"},
+	} {
+		if res := searchKeys(t, idx, query); !slices.Equal(res, expected) {
+			t.Errorf("search %q = %v, expected: %v", query, res, expected)
+		}
+	}
+}
+
+func TestSearchQuerySyntaxErrors(t *testing.T) {
+	idx := testSearchIndex(t)
+
+	for _, query := range []string{"", "(running", "running)", "@title", "@tags:shoes", "@tags:{", "@price:[1]", `"open`, "a | | b"} {
+		if _, err := parseFTQuery(idx, query, false); err == nil {
+			t.Errorf("parseFTQuery(%q) didn't return error", query)
+		}
+	}
+
+	if _, err := parseFTQuery(idx, "@missing:x", false); err == nil {
+		t.Errorf("parseFTQuery() with unknown field didn't return error")
+	}
+}
+
+func TestSearchScores(t *testing.T) {
+	idx := testSearchIndex(t)
+
+	q, _ := parseFTQuery(idx, "running", false)
+	scores := q.eval(idx)
+
+	// title has weight 2, so the word in title weighs more than in body
+	if scores["doc:1"] <= scores["doc:2"] {
+		t.Errorf("score of title match %f is not above score of body match %f", scores["doc:1"], scores["doc:2"])
+	}
+}
+
+func TestSearchIndexUpdate(t *testing.T) {
+	idx := testSearchIndex(t)
+
+	idx.removeDoc("doc:1")
+	idx.addDoc("doc:1", &KvsValue{dtype: HashDtype, object: map[string]string{"title": "Tennis racket", "tags": "sport"}})
+
+	if res := searchKeys(t, idx, "@tags:{shoes}"); !slices.Equal(res, []string{"doc:2"}) {
+		t.Errorf("search after update = %v, expected: [doc:2]", res)
+	}
+	if res := searchKeys(t, idx, "@price:[-inf +inf]"); !slices.Equal(res, []string{"doc:2", "doc:3"}) {
+		t.Errorf("numeric search after update = %v, expected: [doc:2 doc:3]", res)
+	}
+	if res := searchKeys(t, idx, "@tags:{sport} racket"); !slices.Equal(res, []string{"doc:1"}) {
+		t.Errorf("search of new value = %v, expected: [doc:1]", res)
+	}
+
+	idx.removeDoc("doc:1")
+	if _, ok := idx.fields[0].terms.find([]byte("racket")); ok {
+		t.Errorf("term of removed document is left in the index")
+	}
+	if _, ok := idx.fields[2].tags["sport"]; ok {
+		t.Errorf("tag of removed document is left in the index")
+	}
+}
+
+func TestSearchIndexJSON(t *testing.T) {
+	title, _ := parseJSONPath("$.title")
+	tags, _ := parseJSONPath("$.tags")
+	idx := newSearchIndex("idx", ftOnJSON, nil, ftDefaultStopwords, []*ftField{
+		{name: "title", path: "$.title", jsonPath: title, typ: ftFieldText, weight: 1},
+		{name: "tags", path: "$.tags", jsonPath: tags, typ: ftFieldTag, separator: ","},
+	})
+
+	doc, _ := parseJSON(`{"title":"Hello world","tags":["a","B"]}`)
+	idx.addDoc("k", &KvsValue{dtype: JSONDtype, object: doc})
+
+	if res := searchKeys(t, idx, "@tags:{b} hello"); !slices.Equal(res, []string{"k"}) {
+		t.Errorf("search = %v, expected: [k]", res)
+	}
+}
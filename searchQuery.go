@@ -0,0 +1,586 @@
+package main
+
+import (
+	"math"
+	"strconv"
+	"strings"
+)
+
+// Queries of FT.SEARCH and FT.AGGREGATE:
+//
+//	hello world            documents with both words in any TEXT field
+//	hello | world          documents with any of them, intersection binds tighter than union
+//	-hello                 documents without the word
+//	hel*  "hello world"    prefix and exact phrase
+//	@title:(a | b)         words in the given TEXT field
+//	@price:[10 (20]        numeric range, ( excludes the border, -inf and +inf are allowed
+//	@tags:{red | green}    any of the tags
+//	@loc:[lon lat 5 km]    points within radius
+//	*                      all documents
+//
+// Evaluation returns matched document keys with TF-IDF scores of the matched words
+type ftQuery interface {
+	eval(idx *searchIndex) map[string]float64
+}
+
+type ftQueryAll struct{}
+
+type ftQueryTerm struct {
+	field    string // empty means all TEXT fields
+	word     string
+	prefix   bool
+	verbatim bool
+}
+
+type ftQueryPhrase struct {
+	field    string
+	words    []string
+	verbatim bool
+}
+
+type ftQueryNumeric struct {
+	field string
+	r     zrangeSpec
+}
+
+type ftQueryTag struct {
+	field string
+	tags  []string
+}
+
+type ftQueryGeo struct {
+	field    string
+	lon, lat float64
+	radius   float64 // meters
+}
+
+type ftQueryAnd []ftQuery
+type ftQueryOr []ftQuery
+type ftQueryNot struct{ q ftQuery }
+
+func (ftQueryAll) eval(idx *searchIndex) map[string]float64 {
+	res := make(map[string]float64, len(idx.docs))
+	for key := range idx.docs {
+		res[key] = 0
+	}
+
+	return res
+}
+
+// textFields returns fields searched by a word query
+func (idx *searchIndex) textFields(name string) []*ftField {
+	if name != "" {
+		return []*ftField{idx.field(name)}
+	}
+
+	var res []*ftField
+	for _, f := range idx.fields {
+		if f.typ == ftFieldText {
+			res = append(res, f)
+		}
+	}
+
+	return res
+}
+
+// addTermScores adds TF-IDF score of term postings to res
+func (idx *searchIndex) addTermScores(res map[string]float64, f *ftField, postings map[string][]int) {
+	idf := math.Log(1 + float64(len(idx.docs))/float64(len(postings)))
+
+	for key, positions := range postings {
+		res[key] += float64(len(positions)) * f.weight * idf
+	}
+}
+
+func (q ftQueryTerm) eval(idx *searchIndex) map[string]float64 {
+	res := make(map[string]float64)
+
+	for _, f := range idx.textFields(q.field) {
+		if !q.prefix {
+			if postings, ok := f.terms.find([]byte(f.normalizeTerm(q.word, q.verbatim))); ok {
+				idx.addTermScores(res, f, postings)
+			}
+			continue
+		}
+
+		term, postings, ok := f.terms.ceiling([]byte(q.word), true)
+		for ok && strings.HasPrefix(string(term), q.word) {
+			idx.addTermScores(res, f, postings)
+			term, postings, ok = f.terms.ceiling(term, false)
+		}
+	}
+
+	return res
+}
+
+func (q ftQueryPhrase) eval(idx *searchIndex) map[string]float64 {
+	res := make(map[string]float64)
+
+	for _, f := range idx.textFields(q.field) {
+		postings := make([]map[string][]int, len(q.words))
+		for i, w := range q.words {
+			postings[i], _ = f.terms.find([]byte(f.normalizeTerm(w, q.verbatim)))
+		}
+
+		for key, first := range postings[0] {
+			for _, start := range first {
+				if phraseAt(postings, key, start) {
+					for _, p := range postings {
+						idx.addTermScores(res, f, map[string][]int{key: p[key]})
+					}
+					break
+				}
+			}
+		}
+	}
+
+	return res
+}
+
+func phraseAt(postings []map[string][]int, key string, start int) bool {
+	for i, p := range postings[1:] {
+		found := false
+		for _, pos := range p[key] {
+			if pos == start+i+1 {
+				found = true
+				break
+			}
+		}
+
+		if !found {
+			return false
+		}
+	}
+
+	return true
+}
+
+func (q ftQueryNumeric) eval(idx *searchIndex) map[string]float64 {
+	res := make(map[string]float64)
+
+	idx.field(q.field).values.rangeByScore(q.r, func(key string, _ float64) bool {
+		res[key] = 0
+		return true
+	})
+
+	return res
+}
+
+func (q ftQueryTag) eval(idx *searchIndex) map[string]float64 {
+	f := idx.field(q.field)
+	res := make(map[string]float64)
+
+	for _, tag := range q.tags {
+		for key := range f.tags[f.normalizeTag(tag)] {
+			res[key] = 0
+		}
+	}
+
+	return res
+}
+
+func (q ftQueryGeo) eval(idx *searchIndex) map[string]float64 {
+	res := make(map[string]float64)
+
+	opts := geoSearchArgs{byRadius: true, lon: q.lon, lat: q.lat, radius: q.radius}
+	for _, p := range geoSearch(idx.field(q.field).values, &opts) {
+		res[p.member] = 0
+	}
+
+	return res
+}
+
+func (q ftQueryAnd) eval(idx *searchIndex) map[string]float64 {
+	res := q[0].eval(idx)
+
+	for _, child := range q[1:] {
+		if len(res) == 0 {
+			break
+		}
+
+		other := child.eval(idx)
+		for key, score := range res {
+			s, ok := other[key]
+			if !ok {
+				delete(res, key)
+				continue
+			}
+			res[key] = score + s
+		}
+	}
+
+	return res
+}
+
+func (q ftQueryOr) eval(idx *searchIndex) map[string]float64 {
+	res := make(map[string]float64)
+
+	for _, child := range q {
+		for key, score := range child.eval(idx) {
+			res[key] += score
+		}
+	}
+
+	return res
+}
+
+func (q ftQueryNot) eval(idx *searchIndex) map[string]float64 {
+	excluded := q.q.eval(idx)
+
+	res := make(map[string]float64)
+	for key := range idx.docs {
+		if _, ok := excluded[key]; !ok {
+			res[key] = 0
+		}
+	}
+
+	return res
+}
+
+// ================================ parsing ========================================
+
+type ftQueryParser struct {
+	idx      *searchIndex
+	data     string
+	pos      int
+	verbatim bool
+}
+
+const ftQuerySpecialChars = "()|@\"{}[]:-*~"
+
+// parseFTQuery parses query for idx. Words that are stopwords are ignored, query of stopwords only
+// matches nothing
+func parseFTQuery(idx *searchIndex, query string, verbatim bool) (ftQuery, error) {
+	p := &ftQueryParser{idx: idx, data: query, verbatim: verbatim}
+
+	q, err := p.parseOr("")
+	if err != nil {
+		return nil, err
+	}
+
+	p.skipSpaces()
+	if p.pos < len(p.data) {
+		return nil, p.syntaxErr()
+	}
+
+	if q == nil {
+		return ftQueryOr{}, nil
+	}
+
+	return q, nil
+}
+
+func (p *ftQueryParser) syntaxErr() error {
+	near := p.data[min(p.pos, len(p.data)):]
+	if i := strings.IndexAny(near, " \t"); i > 0 {
+		near = near[:i]
+	}
+
+	return ftSyntaxErr(p.pos, near)
+}
+
+func (p *ftQueryParser) skipSpaces() {
+	for p.pos < len(p.data) && (p.data[p.pos] == ' ' || p.data[p.pos] == '\t') {
+		p.pos++
+	}
+}
+
+func (p *ftQueryParser) peek() byte {
+	p.skipSpaces()
+	if p.pos >= len(p.data) {
+		return 0
+	}
+
+	return p.data[p.pos]
+}
+
+func (p *ftQueryParser) consume(c byte) bool {
+	if p.peek() != c || c == 0 {
+		return false
+	}
+	p.pos++
+
+	return true
+}
+
+// parseOr parses union of intersections. field limits words to one TEXT field when it's not empty
+func (p *ftQueryParser) parseOr(field string) (ftQuery, error) {
+	var children ftQueryOr
+
+	for {
+		q, err := p.parseAnd(field)
+		if err != nil {
+			return nil, err
+		}
+		if q != nil {
+			children = append(children, q)
+		}
+
+		if !p.consume('|') {
+			break
+		}
+	}
+
+	switch len(children) {
+	case 0:
+		return nil, nil
+	case 1:
+		return children[0], nil
+	}
+
+	return children, nil
+}
+
+func (p *ftQueryParser) parseAnd(field string) (ftQuery, error) {
+	var children ftQueryAnd
+	parsed := 0
+
+	for c := p.peek(); c != 0 && c != '|' && c != ')'; c = p.peek() {
+		q, err := p.parseUnary(field)
+		if err != nil {
+			return nil, err
+		}
+		parsed++
+
+		if q != nil {
+			children = append(children, q)
+		}
+	}
+
+	if parsed == 0 {
+		return nil, p.syntaxErr()
+	}
+
+	switch len(children) {
+	case 0:
+		return nil, nil
+	case 1:
+		return children[0], nil
+	}
+
+	return children, nil
+}
+
+func (p *ftQueryParser) parseUnary(field string) (ftQuery, error) {
+	if !p.consume('-') {
+		return p.parsePrimary(field)
+	}
+
+	q, err := p.parseUnary(field)
+	if err != nil || q == nil {
+		return nil, err
+	}
+
+	return ftQueryNot{q}, nil
+}
+
+func (p *ftQueryParser) parsePrimary(field string) (ftQuery, error) {
+	switch p.peek() {
+	case '(':
+		p.pos++
+		q, err := p.parseOr(field)
+		if err != nil {
+			return nil, err
+		}
+		if !p.consume(')') {
+			return nil, p.syntaxErr()
+		}
+		return q, nil
+	case '@':
+		p.pos++
+		return p.parseFieldQuery()
+	case '"':
+		return p.parsePhrase(field)
+	case '*':
+		p.pos++
+		return ftQueryAll{}, nil
+	}
+
+	return p.parseWord(field)
+}
+
+func (p *ftQueryParser) parseWord(field string) (ftQuery, error) {
+	start := p.pos
+	for p.pos < len(p.data) && p.data[p.pos] != ' ' && p.data[p.pos] != '\t' &&
+		!strings.ContainsRune(ftQuerySpecialChars, rune(p.data[p.pos])) {
+		p.pos++
+	}
+
+	if p.pos == start {
+		return nil, p.syntaxErr()
+	}
+
+	words := tokenize(p.data[start:p.pos])
+	prefix := p.pos < len(p.data) && p.data[p.pos] == '*'
+	if prefix {
+		p.pos++
+	}
+
+	var res ftQueryAnd
+	for i, w := range words {
+		isPrefix := prefix && i == len(words)-1
+		if p.idx.stopwords[w] && !isPrefix {
+			continue
+		}
+		res = append(res, ftQueryTerm{field: field, word: w, prefix: isPrefix, verbatim: p.verbatim})
+	}
+
+	switch len(res) {
+	case 0:
+		return nil, nil
+	case 1:
+		return res[0], nil
+	}
+
+	return res, nil
+}
+
+func (p *ftQueryParser) parsePhrase(field string) (ftQuery, error) {
+	p.pos++
+
+	end := strings.IndexByte(p.data[p.pos:], '"')
+	if end < 0 {
+		return nil, p.syntaxErr()
+	}
+
+	var words []string
+	for _, w := range tokenize(p.data[p.pos : p.pos+end]) {
+		if !p.idx.stopwords[w] {
+			words = append(words, w)
+		}
+	}
+	p.pos += end + 1
+
+	if len(words) == 0 {
+		return nil, nil
+	}
+
+	return ftQueryPhrase{field: field, words: words, verbatim: p.verbatim}, nil
+}
+
+func (p *ftQueryParser) parseFieldQuery() (ftQuery, error) {
+	start := p.pos
+	for p.pos < len(p.data) && p.data[p.pos] != ':' && p.data[p.pos] != ' ' {
+		p.pos++
+	}
+
+	name := p.data[start:p.pos]
+	f := p.idx.field(name)
+	if f == nil {
+		return nil, ftUnknownFieldErr(name)
+	}
+
+	if !p.consume(':') {
+		return nil, p.syntaxErr()
+	}
+
+	switch f.typ {
+	case ftFieldText:
+		return p.parseUnary(f.name)
+	case ftFieldTag:
+		return p.parseTags(f)
+	}
+
+	args, err := p.parseBracketArgs()
+	if err != nil {
+		return nil, err
+	}
+
+	if f.typ == ftFieldNumeric {
+		return p.parseNumericRange(f, args)
+	}
+
+	return p.parseGeoRadius(f, args)
+}
+
+func (p *ftQueryParser) parseTags(f *ftField) (ftQuery, error) {
+	if !p.consume('{') {
+		return nil, p.syntaxErr()
+	}
+
+	var tags []string
+	var sb strings.Builder
+
+	for p.pos < len(p.data) {
+		c := p.data[p.pos]
+		p.pos++
+
+		switch {
+		case c == '\\' && p.pos < len(p.data):
+			sb.WriteByte(p.data[p.pos])
+			p.pos++
+		case c == '|' || c == '}':
+			if tag := strings.TrimSpace(sb.String()); tag != "" {
+				tags = append(tags, tag)
+			}
+			sb.Reset()
+
+			if c == '}' {
+				if len(tags) == 0 {
+					return nil, p.syntaxErr()
+				}
+				return ftQueryTag{field: f.name, tags: tags}, nil
+			}
+		default:
+			sb.WriteByte(c)
+		}
+	}
+
+	return nil, p.syntaxErr()
+}
+
+// parseBracketArgs returns space or comma separated arguments of [...]
+func (p *ftQueryParser) parseBracketArgs() ([]string, error) {
+	if !p.consume('[') {
+		return nil, p.syntaxErr()
+	}
+
+	end := strings.IndexByte(p.data[p.pos:], ']')
+	if end < 0 {
+		return nil, p.syntaxErr()
+	}
+
+	args := strings.FieldsFunc(p.data[p.pos:p.pos+end], func(r rune) bool { return r == ' ' || r == ',' })
+	p.pos += end + 1
+
+	return args, nil
+}
+
+func (p *ftQueryParser) parseNumericRange(f *ftField, args []string) (ftQuery, error) {
+	if len(args) != 2 {
+		return nil, p.syntaxErr()
+	}
+
+	var r zrangeSpec
+	var err error
+
+	minStr, maxStr := args[0], args[1]
+	r.minEx = strings.HasPrefix(minStr, "(")
+	r.maxEx = strings.HasPrefix(maxStr, "(")
+
+	if r.min, err = strconv.ParseFloat(strings.TrimPrefix(minStr, "("), 64); err != nil {
+		return nil, p.syntaxErr()
+	}
+	if r.max, err = strconv.ParseFloat(strings.TrimPrefix(maxStr, "("), 64); err != nil {
+		return nil, p.syntaxErr()
+	}
+
+	return ftQueryNumeric{field: f.name, r: r}, nil
+}
+
+func (p *ftQueryParser) parseGeoRadius(f *ftField, args []string) (ftQuery, error) {
+	if len(args) != 4 {
+		return nil, p.syntaxErr()
+	}
+
+	lon, err1 := strconv.ParseFloat(args[0], 64)
+	lat, err2 := strconv.ParseFloat(args[1], 64)
+	radius, err3 := strconv.ParseFloat(args[2], 64)
+	unit, ok := geoUnits[strings.ToLower(args[3])]
+
+	if err1 != nil || err2 != nil || err3 != nil || !ok || radius < 0 || !geoValidCoords(lon, lat) {
+		return nil, p.syntaxErr()
+	}
+
+	return ftQueryGeo{field: f.name, lon: lon, lat: lat, radius: radius * unit}, nil
+}
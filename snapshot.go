@@ -0,0 +1,409 @@
+package main
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc64"
+	"io"
+	"log"
+	"maps"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Snapshot file is "KVSDB" followed by u16 version of the format and unix ms it was taken at, then records
+// starting with a byte of their type:
+//   - key: length-prefixed key, i64 unix ms the key expires at or 0 and length-prefixed value serialized
+//     as in DUMP
+//   - index: list of FT.CREATE arguments creating a search index, written after all keys
+//   - end: nothing, it's the last record
+//
+// The end record is followed by CRC64 of everything before it. Numbers are little endian
+const (
+	snapshotMagic   = "KVSDB"
+	snapshotVersion = 1
+
+	snapshotKeyRecord   = 'K'
+	snapshotIndexRecord = 'I'
+	snapshotEndRecord   = 'E'
+
+	// BGSAVE started by save rules is tried again after this time if it has failed
+	snapshotRetryDelay = 5 * time.Second
+)
+
+// save rule triggers BGSAVE when there were at least changes changes of the keyspace and at least
+// seconds seconds passed since the last save
+type saveRule struct {
+	seconds int64
+	changes uint64
+}
+
+var defaultSaveRules = []saveRule{{3600, 1}, {300, 100}, {60, 10000}}
+
+// state of snapshots, changed with kvs.mu held
+var snapshot = struct {
+	saving bool
+	// BGSAVE SCHEDULE waits for AOF rewrite, only one background snapshot runs at a time
+	scheduled bool
+	// unix seconds of the last successful save or of the start, reported by LASTSAVE
+	lastSave   int64
+	lastSaveOK bool
+	lastTry    time.Time
+	// kvs.dirty at the last successful save, save rules count changes since then
+	dirtyAtSave uint64
+}{lastSave: time.Now().Unix(), lastSaveOK: true}
+
+func snapshotPath() string {
+	return filepath.Join(config.dir, config.dbFilename)
+}
+
+func formatSaveRules(rules []saveRule) string {
+	parts := make([]string, 0, 2*len(rules))
+	for _, r := range rules {
+		parts = append(parts, strconv.FormatInt(r.seconds, 10), strconv.FormatUint(r.changes, 10))
+	}
+
+	return strings.Join(parts, " ")
+}
+
+// parseSaveRules parses pairs of seconds and changes, empty string turns rules off
+func parseSaveRules(s string) ([]saveRule, bool) {
+	fields := strings.Fields(s)
+	if len(fields)%2 != 0 {
+		return nil, false
+	}
+
+	rules := make([]saveRule, 0, len(fields)/2)
+	for i := 0; i < len(fields); i += 2 {
+		seconds, err := strconv.ParseInt(fields[i], 10, 64)
+		if err != nil || seconds <= 0 {
+			return nil, false
+		}
+		changes, err := strconv.ParseUint(fields[i+1], 10, 64)
+		if err != nil || changes == 0 {
+			return nil, false
+		}
+		rules = append(rules, saveRule{seconds, changes})
+	}
+
+	return rules, true
+}
+
+// appendSnapshotKey appends record of key with its value
+func appendSnapshotKey(b []byte, key string, val *KvsValue) ([]byte, error) {
+	b = append(b, snapshotKeyRecord)
+	b = appendStr(b, key)
+	b = appendU64(b, uint64(val.expireAt))
+
+	// value is written after its length once it's known
+	start := len(b)
+	b = appendU32(b, 0)
+	b, err := appendValue(b, val)
+	if err != nil {
+		return b, fmt.Errorf("key %q can't be serialized", key)
+	}
+	binary.LittleEndian.PutUint32(b[start:], uint32(len(b)-start-4))
+
+	return b, nil
+}
+
+// snapshotIndexes returns records of search indexes. Must be called with kvs.mu held
+func snapshotIndexes() []byte {
+	var b []byte
+	for _, name := range slices.Sorted(maps.Keys(kvs.searchIndexes)) {
+		args := kvs.searchIndexes[name].createArgs()[1:]
+		b = append(b, snapshotIndexRecord)
+		b = appendU32(b, len(args))
+		for _, arg := range args {
+			b = appendStr(b, arg)
+		}
+	}
+
+	return b
+}
+
+// crcWriter computes CRC64 of everything written through it
+type crcWriter struct {
+	w   io.Writer
+	crc uint64
+}
+
+func (cw *crcWriter) Write(p []byte) (int, error) {
+	cw.crc = crc64.Update(cw.crc, crc64Table, p)
+	return cw.w.Write(p)
+}
+
+// writeSnapshot replaces snapshot file with one taken at unix ms at, with records written by body. It's written
+// to a temporary file first, so a crash never leaves a partially written snapshot
+func writeSnapshot(path string, at int64, body func(w io.Writer) error) error {
+	tmp := filepath.Join(filepath.Dir(path), fmt.Sprintf("temp-%d.kdb", os.Getpid()))
+	err := writeFileSync(tmp, func(w *bufio.Writer) error {
+		cw := &crcWriter{w: w}
+
+		header := binary.LittleEndian.AppendUint16([]byte(snapshotMagic), snapshotVersion)
+		if _, err := cw.Write(appendU64(header, uint64(at))); err != nil {
+			return err
+		}
+		if err := body(cw); err != nil {
+			return err
+		}
+		if _, err := cw.Write([]byte{snapshotEndRecord}); err != nil {
+			return err
+		}
+
+		_, err := w.Write(appendU64(nil, cw.crc))
+		return err
+	})
+	if err == nil {
+		err = os.Rename(tmp, path)
+	}
+	if err != nil {
+		os.Remove(tmp)
+		return err
+	}
+
+	return syncDir(filepath.Dir(path))
+}
+
+// saveSnapshot writes the snapshot blocking everything else. Must be called with kvs.mu held
+func saveSnapshot() error {
+	if snapshot.saving {
+		return ErrBgsaveInProgress
+	}
+
+	err := writeSnapshot(snapshotPath(), nowMs(), func(w io.Writer) error {
+		var b []byte
+		for key, val := range kvs.storage.All() {
+			var err error
+			if b, err = appendSnapshotKey(b[:0], key, val); err != nil {
+				return err
+			}
+			if _, err := w.Write(b); err != nil {
+				return err
+			}
+		}
+
+		_, err := w.Write(snapshotIndexes())
+		return err
+	})
+	snapshotDone(err, kvs.dirty)
+
+	return err
+}
+
+// startBgsave writes the snapshot in the background, while writes go on. Must be called with kvs.mu held
+func startBgsave() error {
+	switch {
+	case snapshot.saving:
+		return ErrBgsaveInProgress
+	case kvs.bgSnapshot != nil:
+		return ErrBgsaveAOFRewrite
+	}
+
+	snapshot.saving, snapshot.scheduled, snapshot.lastTry = true, false, time.Now()
+	path, at, dirty := snapshotPath(), nowMs(), kvs.dirty
+	cur := beginSnapshotCursor(appendSnapshotKey)
+	indexes := snapshotIndexes()
+
+	go func() {
+		err := writeSnapshot(path, at, func(w io.Writer) error {
+			if err := cur.writeTo(w); err != nil {
+				return err
+			}
+			_, err := w.Write(indexes)
+			return err
+		})
+
+		kvs.mu.Lock()
+		defer kvs.mu.Unlock()
+		cur.end()
+		snapshot.saving = false
+		snapshotDone(err, dirty)
+	}()
+
+	return nil
+}
+
+// snapshotDone records result of a save, dirty is kvs.dirty when the keyspace was serialized. Must be called
+// with kvs.mu held
+func snapshotDone(err error, dirty uint64) {
+	if err != nil {
+		snapshot.lastSaveOK = false
+		log.Println("Error saving the snapshot: ", err)
+		return
+	}
+
+	snapshot.lastSave, snapshot.lastSaveOK, snapshot.dirtyAtSave = time.Now().Unix(), true, dirty
+	log.Println("Snapshot saved on disk")
+}
+
+// saveCron starts BGSAVE every second when one of save rules is met
+func saveCron() {
+	for range time.Tick(time.Second) {
+		kvs.mu.Lock()
+		saveIfNeeded()
+		kvs.mu.Unlock()
+	}
+}
+
+// saveIfNeeded starts scheduled BGSAVE or BGSAVE if one of save rules is met. Must be called with kvs.mu held
+func saveIfNeeded() {
+	if snapshot.saving || kvs.bgSnapshot != nil {
+		return
+	}
+	if snapshot.scheduled {
+		if err := startBgsave(); err != nil {
+			log.Println("Error starting BGSAVE: ", err)
+		}
+		return
+	}
+	if !snapshot.lastSaveOK && time.Since(snapshot.lastTry) < snapshotRetryDelay {
+		return
+	}
+
+	changes, elapsed := kvs.dirty-snapshot.dirtyAtSave, time.Now().Unix()-snapshot.lastSave
+	for _, r := range config.saveRules {
+		if changes >= r.changes && elapsed >= r.seconds {
+			log.Printf("%d changes in %d seconds. Saving...", r.changes, r.seconds)
+			if err := startBgsave(); err != nil {
+				log.Println("Error starting BGSAVE: ", err)
+			}
+			return
+		}
+	}
+}
+
+// loadSnapshot loads the snapshot file on start if there is one
+func loadSnapshot() error {
+	data, err := os.ReadFile(snapshotPath())
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	if err := lockKvs(); err != nil {
+		return err
+	}
+	defer kvs.mu.Unlock()
+
+	// the whole snapshot is one batch of the storage engine
+	err = nil
+	if engineErr := batch(func() { err = decodeSnapshot(data) }); engineErr != nil && err == nil {
+		err = engineErr
+	}
+	if err != nil {
+		return err
+	}
+	snapshot.dirtyAtSave = kvs.dirty
+
+	return nil
+}
+
+// decodeSnapshot adds keys and indexes of snapshot data to the keyspace. Must be called with kvs.mu held
+func decodeSnapshot(data []byte) error {
+	header := len(snapshotMagic) + 2 + 8
+	if len(data) < header+1+8 || string(data[:len(snapshotMagic)]) != snapshotMagic {
+		return errors.New("not a snapshot file")
+	}
+	if v := binary.LittleEndian.Uint16(data[len(snapshotMagic):]); v != snapshotVersion {
+		return fmt.Errorf("unsupported snapshot version %d", v)
+	}
+
+	body, sum := data[:len(data)-8], binary.LittleEndian.Uint64(data[len(data)-8:])
+	if crc64.Checksum(body, crc64Table) != sum {
+		return errors.New("snapshot checksum is wrong")
+	}
+
+	now := nowMs()
+	r := &dumpReader{data: body[header:]}
+	for !r.failed {
+		switch r.u8() {
+		case snapshotKeyRecord:
+			key, expireAt, payload := r.str(), r.i64(), r.next(uint64(r.u32()))
+			if r.failed {
+				break
+			}
+
+			val, err := decodeValue(payload)
+			if err != nil {
+				return fmt.Errorf("key %q: value can't be decoded", key)
+			}
+
+			switch {
+			case expireAt == 0:
+				storeKey(key, val)
+			case expireAt > now:
+				setExpire(key, val, expireAt)
+			default:
+				// expired while the server was down
+				continue
+			}
+			signalModifiedKey(key, notifyGeneric, "")
+		case snapshotIndexRecord:
+			n := r.count(4)
+			args := make([]*KvsValue, n)
+			for i := range args {
+				args[i] = &KvsValue{dtype: BulkStrSymbol, value: r.bytes()}
+			}
+			if r.failed {
+				break
+			}
+
+			if _, err := ftCreateHandler(args); err != nil {
+				return fmt.Errorf("search index can't be created: %s", strings.TrimSpace(err.Error()))
+			}
+		case snapshotEndRecord:
+			if len(r.data) != 0 {
+				return errors.New("snapshot has data after the end")
+			}
+			return nil
+		default:
+			r.failed = true
+		}
+	}
+
+	return errors.New("snapshot has bad format")
+}
+
+// SAVE
+func saveHandler(args []*KvsValue) ([]byte, error) {
+	if err := saveSnapshot(); err != nil {
+		if err == ErrBgsaveInProgress {
+			return nil, err
+		}
+		return nil, saveErr(err.Error())
+	}
+
+	return []byte(OkResponse), nil
+}
+
+// BGSAVE [SCHEDULE]
+func bgsaveHandler(args []*KvsValue) ([]byte, error) {
+	if len(args) > 1 || (len(args) == 1 && strings.ToUpper(argToString(args[0])) != "SCHEDULE") {
+		return nil, ErrSyntax
+	}
+
+	if !snapshot.saving && kvs.bgSnapshot != nil && len(args) == 1 {
+		snapshot.scheduled = true
+		return simpleStrResponse("Background saving scheduled"), nil
+	}
+
+	if err := startBgsave(); err != nil {
+		return nil, err
+	}
+
+	return simpleStrResponse("Background saving started"), nil
+}
+
+// LASTSAVE
+func lastsaveHandler(args []*KvsValue) ([]byte, error) {
+	return intResponse(snapshot.lastSave), nil
+}
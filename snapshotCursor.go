@@ -0,0 +1,160 @@
+package main
+
+import (
+	"io"
+	"iter"
+)
+
+// Go can't fork to get a copy-on-write image of the keyspace, so background snapshots serialize it in steps
+// taking kvs.mu for a bounded amount of work, and writes go on between the steps. Every snapshot gets a new
+// generation and a value is marked with it once it's serialized, or when it's created after the snapshot
+// has started and so isn't a part of the image. The image is kept as it was at the start:
+//   - value looked up by a write command is serialized at once if it isn't marked yet, as the command can
+//     change it in place
+//   - replaced, deleted or expired value isn't changed anymore, so it's only kept until a step serializes it
+//
+// Keys are visited with the iterator of the storage engine, which is only advanced with kvs.mu held. Keys deleted
+// before the iterator reaches them aren't produced, keys created meanwhile may be, then they are marked.
+// So the cost of a write is bounded by the size of the value it touches, never by the size of the dataset
+const (
+	// a step ends once it has visited this many keys or produced this many bytes
+	snapshotStepKeys  = 1024
+	snapshotStepBytes = 256 << 10
+)
+
+// snapshotRecordFunc appends key with its value to b in the format of the snapshot
+type snapshotRecordFunc func(b []byte, key string, val *KvsValue) ([]byte, error)
+
+type detachedValue struct {
+	key string
+	val *KvsValue
+}
+
+type snapshotCursor struct {
+	gen    uint64
+	record snapshotRecordFunc
+	next   func() (string, *KvsValue, bool)
+	stop   func()
+	// values taken out of the keyspace before the iterator has reached them
+	detached []detachedValue
+	// records of values serialized by writers, they go out with the next step
+	out []byte
+	err error
+}
+
+// beginSnapshotCursor starts background snapshot of the keyspace, only one can run at a time. Must be called
+// with kvs.mu held
+func beginSnapshotCursor(record snapshotRecordFunc) *snapshotCursor {
+	kvs.snapshotGen++
+	s := &snapshotCursor{gen: kvs.snapshotGen, record: record}
+	s.next, s.stop = iter.Pull2(kvs.storage.All())
+	kvs.bgSnapshot = s
+
+	return s
+}
+
+// preserveValue serializes val stored at key before a write command can change it. Must be called with
+// kvs.mu held
+func preserveValue(key string, val *KvsValue) {
+	s := kvs.bgSnapshot
+	if s == nil || val.savedGen == s.gen || s.err != nil {
+		return
+	}
+
+	val.savedGen = s.gen
+	s.out, s.err = s.record(s.out, key, val)
+}
+
+// detachValue keeps val taken out of the keyspace until it's serialized, and tells whether it's kept, then
+// it's freed once it's serialized. Must be called with kvs.mu held
+func detachValue(key string, val *KvsValue) bool {
+	s := kvs.bgSnapshot
+	if s == nil || val.savedGen == s.gen {
+		return false
+	}
+
+	val.savedGen = s.gen
+	s.detached = append(s.detached, detachedValue{key, val})
+
+	return true
+}
+
+// markNewValue excludes value stored after the snapshot has started from it. Must be called with
+// kvs.mu held
+func markNewValue(val *KvsValue) {
+	if s := kvs.bgSnapshot; s != nil {
+		val.savedGen = s.gen
+	}
+}
+
+// step serializes the next part of the keyspace and tells whether it's the last one. The snapshot ends
+// with the last step or an error. Must be called with kvs.mu held
+func (s *snapshotCursor) step() ([]byte, bool) {
+	b := s.out
+	s.out = nil
+
+	for keys := 0; keys < snapshotStepKeys && len(b) < snapshotStepBytes && s.err == nil; keys++ {
+		if n := len(s.detached); n > 0 {
+			d := s.detached[n-1]
+			s.detached = s.detached[:n-1]
+			b, s.err = s.record(b, d.key, d.val)
+			freeModuleValue(d.val)
+			continue
+		}
+
+		key, val, ok := s.next()
+		if !ok {
+			s.end()
+			return b, true
+		}
+		if val.savedGen == s.gen {
+			continue
+		}
+		val.savedGen = s.gen
+		b, s.err = s.record(b, key, val)
+	}
+
+	if s.err != nil {
+		s.end()
+		return nil, true
+	}
+
+	return b, false
+}
+
+// end stops the snapshot, so writers don't keep values for it anymore. Must be called with kvs.mu held
+func (s *snapshotCursor) end() {
+	if kvs.bgSnapshot != s {
+		return
+	}
+
+	s.stop()
+	for _, d := range s.detached {
+		freeModuleValue(d.val)
+	}
+	s.detached, s.out = nil, nil
+	kvs.bgSnapshot = nil
+}
+
+// writeTo writes the snapshot to w step by step, releasing kvs.mu while a step is written
+func (s *snapshotCursor) writeTo(w io.Writer) error {
+	for {
+		kvs.mu.Lock()
+		b, done := s.step()
+		err := s.err
+		kvs.mu.Unlock()
+		if err != nil {
+			return err
+		}
+
+		if _, err := w.Write(b); err != nil {
+			kvs.mu.Lock()
+			s.end()
+			kvs.mu.Unlock()
+			return err
+		}
+		if done {
+			return nil
+		}
+	}
+}
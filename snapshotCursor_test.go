@@ -0,0 +1,205 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"strconv"
+	"testing"
+)
+
+// keyspaceImage returns replies of commands reading every key, and the number of keys
+func keyspaceImage(t *testing.T, tx *txState, keys int) map[string]string {
+	t.Helper()
+
+	image := map[string]string{
+		"HGETALL h":  dispatchTest(t, tx, "HGETALL", "h"),
+		"XRANGE s":   dispatchTest(t, tx, "XRANGE", "s", "-", "+"),
+		"JSON.GET j": dispatchTest(t, tx, "JSON.GET", "j"),
+	}
+	for i := range keys {
+		key := "k" + strconv.Itoa(i)
+		image["GET "+key] = dispatchTest(t, tx, "GET", key)
+	}
+
+	kvs.mu.Lock()
+	image["keys"] = strconv.Itoa(kvs.storage.Len())
+	kvs.mu.Unlock()
+
+	return image
+}
+
+func fillKeyspace(t *testing.T, tx *txState, keys int) {
+	t.Helper()
+
+	for i := range keys {
+		dispatchTest(t, tx, "SET", "k"+strconv.Itoa(i), "v"+strconv.Itoa(i))
+	}
+	dispatchTest(t, tx, "HSET", "h", "f", "1")
+	dispatchTest(t, tx, "XADD", "s", "1-1", "f", "1")
+	dispatchTest(t, tx, "JSON.SET", "j", "$", `{"n":1}`)
+}
+
+// Writes between steps of the snapshot, in place, replacing, deleting and creating keys, don't get into it
+func TestSnapshotCursorPointInTime(t *testing.T) {
+	path := testSnapshot(t)
+	var tx txState
+
+	keys := 3 * snapshotStepKeys
+	fillKeyspace(t, &tx, keys)
+	expected := keyspaceImage(t, &tx, keys)
+
+	steps := 0
+	err := writeSnapshot(path, nowMs(), func(w io.Writer) error {
+		kvs.mu.Lock()
+		cur := beginSnapshotCursor(appendSnapshotKey)
+		kvs.mu.Unlock()
+
+		for done := false; !done; steps++ {
+			var b []byte
+			kvs.mu.Lock()
+			b, done = cur.step()
+			kvs.mu.Unlock()
+			if cur.err != nil {
+				return cur.err
+			}
+			if _, err := w.Write(b); err != nil {
+				return err
+			}
+
+			// every step some keys already written and some not yet visited are changed
+			for _, step := range [][]string{
+				{"SET", "k" + strconv.Itoa(steps*7), "changed"},
+				{"SET", "k" + strconv.Itoa(keys-1-steps*5), "changed"},
+				{"DELETE", "k" + strconv.Itoa(steps*11+1)},
+				{"RENAME", "k" + strconv.Itoa(steps*13+2), "renamed" + strconv.Itoa(steps)},
+				{"SET", "new" + strconv.Itoa(steps), "v"},
+				{"HSET", "h", "f", strconv.Itoa(steps + 2), "g" + strconv.Itoa(steps), "x"},
+				{"XADD", "s", "*", "f", "v"},
+				{"JSON.NUMINCRBY", "j", "$.n", "1"},
+			} {
+				if reply := dispatchTest(t, &tx, step[0], step[1:]...); reply[0] == '-' {
+					t.Fatalf("%v: %q", step, reply)
+				}
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if steps < 3 {
+		t.Fatalf("snapshot of %d keys is written in %d steps", keys, steps)
+	}
+
+	// all of it is gone from the keyspace, but not from the snapshot
+	dispatchTest(t, &tx, "FLUSHALL")
+
+	if err := reloadSnapshot(t); err != nil {
+		t.Fatal(err)
+	}
+	got := keyspaceImage(t, &tx, keys)
+	for name, reply := range expected {
+		if got[name] != reply {
+			t.Errorf("%s: got %q, expected: %q", name, got[name], reply)
+		}
+	}
+}
+
+// Values deleted while the snapshot runs are kept for it until they are written, and FLUSHALL doesn't
+// have to serialize the whole keyspace at once
+func TestSnapshotCursorFlushall(t *testing.T) {
+	testSnapshot(t)
+	var tx txState
+
+	keys := 2 * snapshotStepKeys
+	fillKeyspace(t, &tx, keys)
+	expected := keyspaceImage(t, &tx, keys)
+
+	kvs.mu.Lock()
+	cur := beginSnapshotCursor(appendSnapshotKey)
+	first, _ := cur.step()
+	kvs.mu.Unlock()
+
+	dispatchTest(t, &tx, "FLUSHALL")
+	kvs.mu.Lock()
+	if len(cur.out) != 0 {
+		t.Error("FLUSHALL has serialized values")
+	}
+	if len(cur.detached) != keys+3-snapshotStepKeys {
+		t.Errorf("%d values are kept after FLUSHALL, expected: %d", len(cur.detached), keys+3-snapshotStepKeys)
+	}
+	kvs.mu.Unlock()
+	dispatchTest(t, &tx, "SET", "k0", "after")
+
+	err := writeSnapshot(snapshotPath(), nowMs(), func(w io.Writer) error {
+		if _, err := w.Write(first); err != nil {
+			return err
+		}
+		return cur.writeTo(w)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if kvs.bgSnapshot != nil {
+		t.Error("snapshot isn't ended")
+	}
+
+	if err := reloadSnapshot(t); err != nil {
+		t.Fatal(err)
+	}
+	got := keyspaceImage(t, &tx, keys)
+	for name, reply := range expected {
+		if got[name] != reply {
+			t.Errorf("%s: got %q, expected: %q", name, got[name], reply)
+		}
+	}
+}
+
+// BGSAVE writes the keyspace as it was when it started while clients keep writing
+func TestBgsaveWithConcurrentWrites(t *testing.T) {
+	testSnapshot(t)
+	var tx txState
+
+	keys := 20 * snapshotStepKeys
+	fillKeyspace(t, &tx, keys)
+	expected := keyspaceImage(t, &tx, keys)
+
+	if got := dispatchTest(t, &tx, "BGSAVE"); got != "+Background saving started\r\n" {
+		t.Fatalf("BGSAVE: %q", got)
+	}
+	// only one background snapshot runs at a time
+	if got := dispatchTest(t, &tx, "BGREWRITEAOF"); got != "+Background append only file rewriting scheduled\r\n" {
+		t.Errorf("BGREWRITEAOF during BGSAVE: %q", got)
+	}
+	kvs.mu.Lock()
+	aof.rewriteScheduled = false
+	kvs.mu.Unlock()
+
+	writes := 0
+	for i := 0; ; i++ {
+		kvs.mu.Lock()
+		saving := snapshot.saving
+		kvs.mu.Unlock()
+		if !saving {
+			break
+		}
+
+		key := "k" + strconv.Itoa(i%keys)
+		dispatchTest(t, &tx, "SET", key, fmt.Sprintf("changed%d", i))
+		dispatchTest(t, &tx, "DELETE", "k"+strconv.Itoa((i*31)%keys))
+		dispatchTest(t, &tx, "HSET", "h", "f", strconv.Itoa(i))
+		dispatchTest(t, &tx, "SET", "new"+strconv.Itoa(i), "v")
+		writes++
+	}
+	t.Logf("%d rounds of writes ran during BGSAVE", writes)
+
+	if err := reloadSnapshot(t); err != nil {
+		t.Fatal(err)
+	}
+	got := keyspaceImage(t, &tx, keys)
+	for name, reply := range expected {
+		if got[name] != reply {
+			t.Errorf("%s: got %q, expected: %q", name, got[name], reply)
+		}
+	}
+}
@@ -0,0 +1,189 @@
+package main
+
+import (
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+// testSnapshot makes snapshots saved to a temporary directory
+func testSnapshot(t *testing.T) string {
+	t.Helper()
+
+	dir, prev, prevState := t.TempDir(), config, snapshot
+	config.dir = dir
+	t.Cleanup(func() {
+		waitBgsave(t)
+		config, snapshot = prev, prevState
+	})
+	initStorage()
+
+	return snapshotPath()
+}
+
+// waitBgsave waits for the background save to finish
+func waitBgsave(t *testing.T) {
+	t.Helper()
+
+	for deadline := time.Now().Add(5 * time.Second); time.Now().Before(deadline); time.Sleep(time.Millisecond) {
+		kvs.mu.Lock()
+		saving := snapshot.saving
+		kvs.mu.Unlock()
+		if !saving {
+			return
+		}
+	}
+	t.Fatal("BGSAVE hasn't finished")
+}
+
+func reloadSnapshot(t *testing.T) error {
+	t.Helper()
+
+	initStorage()
+	return loadSnapshot()
+}
+
+func TestSnapshotSaveAndLoad(t *testing.T) {
+	path := testSnapshot(t)
+	var tx txState
+
+	cmd, args, err := NewRespReader(strings.NewReader("*3\r\n$3\r\nSET\r\n$1\r\nn\r\n:-42\r\n")).readCommand()
+	if err != nil {
+		t.Fatal(err)
+	}
+	dispatchCommand(&tx, cmd, args)
+
+	for _, step := range [][]string{
+		{"SET", "s", "v"},
+		{"XADD", "stream", "1-1", "f", "v"},
+		{"HSET", "doc:1", "title", "hello world"},
+		{"FT.CREATE", "idx", "PREFIX", "1", "doc:", "SCHEMA", "title", "TEXT"},
+		{"JSON.SET", "j", "$", `{"a":[1,2]}`},
+	} {
+		if reply := dispatchTest(t, &tx, step[0], step[1:]...); strings.HasPrefix(reply, "-") {
+			t.Fatalf("%v: %q", step, reply)
+		}
+	}
+	expireAt := nowMs() + 100000
+	kvs.mu.Lock()
+	setExpire("tmp", &KvsValue{dtype: BulkStrSymbol, value: []byte("v")}, expireAt)
+	setExpire("expired", &KvsValue{dtype: BulkStrSymbol, value: []byte("v")}, nowMs()+50)
+	kvs.mu.Unlock()
+
+	before := dispatchTest(t, &tx, "LASTSAVE")
+	if got := dispatchTest(t, &tx, "SAVE"); got != OkResponse {
+		t.Fatalf("SAVE: %q", got)
+	}
+	time.Sleep(60 * time.Millisecond)
+
+	if err := reloadSnapshot(t); err != nil {
+		t.Fatal(err)
+	}
+	for _, step := range []struct {
+		cmd   []string
+		reply string
+	}{
+		{[]string{"GET", "n"}, ":-42\r\n"},
+		{[]string{"GET", "s"}, "$1\r\nv\r\n"},
+		{[]string{"GET", "expired"}, NullResponse},
+		{[]string{"XRANGE", "stream", "-", "+"}, "*1\r\n*2\r\n$3\r\n1-1\r\n*2\r\n$1\r\nf\r\n$1\r\nv\r\n"},
+		{[]string{"JSON.GET", "j"}, "$11\r\n{\"a\":[1,2]}\r\n"},
+		{[]string{"FT.SEARCH", "idx", "hello", "NOCONTENT"}, "*2\r\n:1\r\n$5\r\ndoc:1\r\n"},
+	} {
+		if got := dispatchTest(t, &tx, step.cmd[0], step.cmd[1:]...); got != step.reply {
+			t.Errorf("%v: got %q, expected: %q", step.cmd, got, step.reply)
+		}
+	}
+	kvs.mu.Lock()
+	val, ok := kvs.storage.Get("tmp")
+	kvs.mu.Unlock()
+	if !ok || val.expireAt != expireAt {
+		t.Error("expiry of tmp isn't kept")
+	}
+	if got := dispatchTest(t, &tx, "LASTSAVE"); got < before {
+		t.Errorf("LASTSAVE isn't updated: %q before SAVE, %q after", before, got)
+	}
+
+	dispatchTest(t, &tx, "SET", "s", "w")
+	if got := dispatchTest(t, &tx, "BGSAVE"); got != "+Background saving started\r\n" {
+		t.Fatalf("BGSAVE: %q", got)
+	}
+	// the write after BGSAVE has started isn't in the snapshot
+	dispatchTest(t, &tx, "SET", "s", "x")
+	waitBgsave(t)
+	if err := reloadSnapshot(t); err != nil {
+		t.Fatal(err)
+	}
+	if got := dispatchTest(t, &tx, "GET", "s"); got != "$1\r\nw\r\n" {
+		t.Errorf("GET s after BGSAVE: %q", got)
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for name, broken := range map[string][]byte{
+		"corrupted": append(append([]byte{}, data[:20]...), append([]byte{data[20] ^ 1}, data[21:]...)...),
+		"truncated": data[:len(data)-1],
+		"version":   append(append([]byte(snapshotMagic), 2, 0), data[len(snapshotMagic)+2:]...),
+	} {
+		if err := os.WriteFile(path, broken, 0o644); err != nil {
+			t.Fatal(err)
+		}
+		if err := reloadSnapshot(t); err == nil {
+			t.Errorf("%s snapshot is loaded", name)
+		}
+	}
+}
+
+func TestSaveRules(t *testing.T) {
+	testSnapshot(t)
+	var tx txState
+
+	for _, step := range []struct {
+		cmd   []string
+		reply string
+	}{
+		{[]string{"CONFIG", "SET", "save", "10 1 abc"}, configInvalidArgErr("save", "10 1 abc").Error()},
+		{[]string{"CONFIG", "SET", "dbfilename", "../x.kdb"}, configInvalidArgErr("dbfilename", "../x.kdb").Error()},
+		{[]string{"CONFIG", "SET", "save", "100 3 10 2"}, OkResponse},
+		{[]string{"CONFIG", "GET", "save"}, "*2\r\n$4\r\nsave\r\n$10\r\n100 3 10 2\r\n"},
+	} {
+		if got := dispatchTest(t, &tx, step.cmd[0], step.cmd[1:]...); got != step.reply {
+			t.Errorf("%v: got %q, expected: %q", step.cmd, got, step.reply)
+		}
+	}
+
+	kvs.mu.Lock()
+	snapshot.lastSave = time.Now().Unix() - 20
+	snapshot.dirtyAtSave = kvs.dirty
+	kvs.mu.Unlock()
+
+	dispatchTest(t, &tx, "SET", "a", "1")
+	kvs.mu.Lock()
+	saveIfNeeded()
+	started := snapshot.saving
+	kvs.mu.Unlock()
+	if started {
+		t.Fatal("BGSAVE is started before enough changes")
+	}
+
+	dispatchTest(t, &tx, "SET", "a", "2")
+	kvs.mu.Lock()
+	saveIfNeeded()
+	started = snapshot.saving
+	kvs.mu.Unlock()
+	if !started {
+		t.Fatal("BGSAVE isn't started when the rule is met")
+	}
+	waitBgsave(t)
+
+	kvs.mu.Lock()
+	saveIfNeeded()
+	started = snapshot.saving
+	kvs.mu.Unlock()
+	if started {
+		t.Error("BGSAVE is started again without changes")
+	}
+}
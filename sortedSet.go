@@ -0,0 +1,244 @@
+package main
+
+import (
+	"math/rand/v2"
+)
+
+// skiplist parameters are the same as in Redis
+const (
+	zslMaxLevel = 32
+	zslP        = 0.25
+)
+
+type zslNode struct {
+	member   string
+	score    float64
+	backward *zslNode
+	forward  []*zslNode // one pointer per level
+}
+
+type skiplist struct {
+	header *zslNode
+	tail   *zslNode
+	length int
+	level  int
+}
+
+// zrangeSpec describes score interval, borders are excluded when minEx/maxEx are set
+type zrangeSpec struct {
+	min, max     float64
+	minEx, maxEx bool
+}
+
+func (r zrangeSpec) gteMin(score float64) bool {
+	if r.minEx {
+		return score > r.min
+	}
+
+	return score >= r.min
+}
+
+func (r zrangeSpec) lteMax(score float64) bool {
+	if r.maxEx {
+		return score < r.max
+	}
+
+	return score <= r.max
+}
+
+func (r zrangeSpec) empty() bool {
+	return r.min > r.max || (r.min == r.max && (r.minEx || r.maxEx))
+}
+
+func newSkiplist() *skiplist {
+	return &skiplist{
+		header: &zslNode{forward: make([]*zslNode, zslMaxLevel)},
+		level:  1,
+	}
+}
+
+func zslRandomLevel() int {
+	level := 1
+	for level < zslMaxLevel && rand.Float64() < zslP {
+		level++
+	}
+
+	return level
+}
+
+// zslNodeBefore reports whether (score, member) goes before node in skiplist order
+func zslNodeBefore(n *zslNode, score float64, member string) bool {
+	return n.score < score || (n.score == score && n.member < member)
+}
+
+func (zsl *skiplist) insert(score float64, member string) *zslNode {
+	var update [zslMaxLevel]*zslNode
+
+	x := zsl.header
+	for i := zsl.level - 1; i >= 0; i-- {
+		for x.forward[i] != nil && zslNodeBefore(x.forward[i], score, member) {
+			x = x.forward[i]
+		}
+		update[i] = x
+	}
+
+	level := zslRandomLevel()
+	if level > zsl.level {
+		for i := zsl.level; i < level; i++ {
+			update[i] = zsl.header
+		}
+		zsl.level = level
+	}
+
+	x = &zslNode{member: member, score: score, forward: make([]*zslNode, level)}
+	for i := range level {
+		x.forward[i] = update[i].forward[i]
+		update[i].forward[i] = x
+	}
+
+	if update[0] != zsl.header {
+		x.backward = update[0]
+	}
+
+	if x.forward[0] != nil {
+		x.forward[0].backward = x
+	} else {
+		zsl.tail = x
+	}
+
+	zsl.length++
+
+	return x
+}
+
+func (zsl *skiplist) delete(score float64, member string) bool {
+	var update [zslMaxLevel]*zslNode
+
+	x := zsl.header
+	for i := zsl.level - 1; i >= 0; i-- {
+		for x.forward[i] != nil && zslNodeBefore(x.forward[i], score, member) {
+			x = x.forward[i]
+		}
+		update[i] = x
+	}
+
+	x = x.forward[0]
+	if x == nil || x.score != score || x.member != member {
+		return false
+	}
+
+	for i := range len(x.forward) {
+		update[i].forward[i] = x.forward[i]
+	}
+
+	if x.forward[0] != nil {
+		x.forward[0].backward = x.backward
+	} else {
+		zsl.tail = x.backward
+	}
+
+	for zsl.level > 1 && zsl.header.forward[zsl.level-1] == nil {
+		zsl.level--
+	}
+
+	zsl.length--
+
+	return true
+}
+
+// firstInRange returns the first node with score inside r or nil
+func (zsl *skiplist) firstInRange(r zrangeSpec) *zslNode {
+	if r.empty() {
+		return nil
+	}
+
+	x := zsl.header
+	for i := zsl.level - 1; i >= 0; i-- {
+		for x.forward[i] != nil && !r.gteMin(x.forward[i].score) {
+			x = x.forward[i]
+		}
+	}
+
+	x = x.forward[0]
+	if x == nil || !r.lteMax(x.score) {
+		return nil
+	}
+
+	return x
+}
+
+// sortedSet is a map from member to score plus a skiplist ordered by (score, member) for range queries
+type sortedSet struct {
+	dict map[string]float64
+	zsl  *skiplist
+}
+
+func newSortedSet() *sortedSet {
+	return &sortedSet{dict: make(map[string]float64), zsl: newSkiplist()}
+}
+
+func (z *sortedSet) len() int {
+	return len(z.dict)
+}
+
+func (z *sortedSet) score(member string) (float64, bool) {
+	score, ok := z.dict[member]
+	return score, ok
+}
+
+// add sets score of member and returns whether member is new
+func (z *sortedSet) add(member string, score float64) bool {
+	old, exists := z.dict[member]
+	if exists {
+		if old == score {
+			return false
+		}
+		z.zsl.delete(old, member)
+	}
+
+	z.zsl.insert(score, member)
+	z.dict[member] = score
+
+	return !exists
+}
+
+func (z *sortedSet) remove(member string) bool {
+	score, ok := z.dict[member]
+	if !ok {
+		return false
+	}
+
+	z.zsl.delete(score, member)
+	delete(z.dict, member)
+
+	return true
+}
+
+// rangeByScore calls fn for members with scores inside r in ascending order until fn returns false
+func (z *sortedSet) rangeByScore(r zrangeSpec, fn func(member string, score float64) bool) {
+	for x := z.zsl.firstInRange(r); x != nil && r.lteMax(x.score); x = x.forward[0] {
+		if !fn(x.member, x.score) {
+			return
+		}
+	}
+}
+
+// dump serializes members with their scores in ascending order
+func (z *sortedSet) dump(b []byte) []byte {
+	b = appendU32(b, z.len())
+	for x := z.zsl.header.forward[0]; x != nil; x = x.forward[0] {
+		b = appendStr(b, x.member)
+		b = appendF64(b, x.score)
+	}
+
+	return b
+}
+
+func restoreSortedSet(r *dumpReader) *sortedSet {
+	z := newSortedSet()
+	for range r.count(12) {
+		r.check(z.add(r.str(), r.f64()))
+	}
+
+	return z
+}
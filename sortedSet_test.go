@@ -0,0 +1,74 @@
+package main
+
+import (
+	"slices"
+	"testing"
+)
+
+func sortedSetMembers(z *sortedSet, r zrangeSpec) []string {
+	var res []string
+	z.rangeByScore(r, func(member string, _ float64) bool {
+		res = append(res, member)
+		return true
+	})
+
+	return res
+}
+
+func TestSortedSetOrder(t *testing.T) {
+	z := newSortedSet()
+	z.add("c", 3)
+	z.add("a", 1)
+	z.add("b2", 2)
+	z.add("b1", 2)
+
+	expected := []string{"a", "b1", "b2", "c"}
+
+	res := sortedSetMembers(z, zrangeSpec{min: 0, max: 10})
+	if !slices.Equal(res, expected) {
+		t.Errorf("members = %v, expected: %v", res, expected)
+	}
+}
+
+func TestSortedSetUpdateScore(t *testing.T) {
+	z := newSortedSet()
+	z.add("a", 1)
+	z.add("b", 2)
+
+	isNew := z.add("a", 5)
+	res := sortedSetMembers(z, zrangeSpec{min: 0, max: 10})
+
+	if isNew || !slices.Equal(res, []string{"b", "a"}) || z.zsl.length != 2 {
+		t.Errorf("members after score update = %v, expected: %v", res, []string{"b", "a"})
+	}
+}
+
+func TestSortedSetRangeExclusive(t *testing.T) {
+	z := newSortedSet()
+	for i, m := range []string{"a", "b", "c", "d"} {
+		z.add(m, float64(i))
+	}
+
+	res := sortedSetMembers(z, zrangeSpec{min: 1, max: 3, minEx: true, maxEx: true})
+
+	if !slices.Equal(res, []string{"c"}) {
+		t.Errorf("rangeByScore((1, 3)) = %v, expected: %v", res, []string{"c"})
+	}
+}
+
+func TestSortedSetRemove(t *testing.T) {
+	z := newSortedSet()
+	for i := range 100 {
+		z.add(string(rune('a'+i%26))+string(rune('0'+i/26)), float64(i))
+	}
+
+	for i := 0; i < 100; i += 2 {
+		z.remove(string(rune('a'+i%26)) + string(rune('0'+i/26)))
+	}
+
+	res := sortedSetMembers(z, zrangeSpec{min: 0, max: 100})
+
+	if len(res) != 50 || z.len() != 50 || z.zsl.length != 50 {
+		t.Errorf("after removing every second member %v remain, expected: %v", len(res), 50)
+	}
+}
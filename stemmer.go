@@ -0,0 +1,222 @@
+package main
+
+import "strings"
+
+// Porter stemmer reduces english words to their stems, so "connected", "connecting" and "connections"
+// are all indexed as "connect". Words with anything but ascii letters are left as is
+type porterStemmer struct {
+	b []byte
+	j int // length of the stem before the suffix matched by the last ends call
+}
+
+func stem(word string) string {
+	if len(word) <= 2 {
+		return word
+	}
+
+	for i := range len(word) {
+		if word[i] < 'a' || word[i] > 'z' {
+			return word
+		}
+	}
+
+	s := &porterStemmer{b: []byte(word)}
+	s.step1ab()
+	if len(s.b) > 2 {
+		s.step1c()
+		s.step2()
+		s.step3()
+		s.step4()
+		s.step5()
+	}
+
+	return string(s.b)
+}
+
+func (s *porterStemmer) isConsonant(i int) bool {
+	switch s.b[i] {
+	case 'a', 'e', 'i', 'o', 'u':
+		return false
+	case 'y':
+		return i == 0 || !s.isConsonant(i-1)
+	}
+
+	return true
+}
+
+// measure returns number of vowel-consonant sequences in b[:n]
+func (s *porterStemmer) measure(n int) int {
+	m, i := 0, 0
+
+	for i < n && s.isConsonant(i) {
+		i++
+	}
+
+	for i < n {
+		for i < n && !s.isConsonant(i) {
+			i++
+		}
+		if i == n {
+			break
+		}
+
+		for i < n && s.isConsonant(i) {
+			i++
+		}
+		m++
+	}
+
+	return m
+}
+
+func (s *porterStemmer) hasVowel(n int) bool {
+	for i := range n {
+		if !s.isConsonant(i) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// doubleConsonant reports whether b[i-1:i+1] is a double consonant
+func (s *porterStemmer) doubleConsonant(i int) bool {
+	return i >= 1 && s.b[i] == s.b[i-1] && s.isConsonant(i)
+}
+
+// cvc reports whether b[i-2:i+1] is consonant-vowel-consonant and the last one is not w, x or y,
+// it restores e in words like hop(e)
+func (s *porterStemmer) cvc(i int) bool {
+	if i < 2 || !s.isConsonant(i) || s.isConsonant(i-1) || !s.isConsonant(i-2) {
+		return false
+	}
+
+	c := s.b[i]
+	return c != 'w' && c != 'x' && c != 'y'
+}
+
+func (s *porterStemmer) ends(suffix string) bool {
+	if !strings.HasSuffix(string(s.b), suffix) {
+		return false
+	}
+
+	s.j = len(s.b) - len(suffix)
+
+	return true
+}
+
+func (s *porterStemmer) setTo(suffix string) {
+	s.b = append(s.b[:s.j], suffix...)
+}
+
+// replaceSuffix takes the first matching suffix and replaces it when the stem measure is above minMeasure
+func (s *porterStemmer) replaceSuffix(rules [][2]string, minMeasure int) {
+	for _, r := range rules {
+		if s.ends(r[0]) {
+			if s.measure(s.j) > minMeasure {
+				s.setTo(r[1])
+			}
+			return
+		}
+	}
+}
+
+// step1ab removes plurals and -ed or -ing
+func (s *porterStemmer) step1ab() {
+	if s.b[len(s.b)-1] == 's' {
+		switch {
+		case s.ends("sses"):
+			s.b = s.b[:len(s.b)-2]
+		case s.ends("ies"):
+			s.setTo("i")
+		case !s.ends("ss"):
+			s.b = s.b[:len(s.b)-1]
+		}
+	}
+
+	if s.ends("eed") {
+		if s.measure(s.j) > 0 {
+			s.b = s.b[:len(s.b)-1]
+		}
+		return
+	}
+
+	if !(s.ends("ed") || s.ends("ing")) || !s.hasVowel(s.j) {
+		return
+	}
+	s.b = s.b[:s.j]
+
+	last := len(s.b) - 1
+	switch {
+	case s.ends("at"), s.ends("bl"), s.ends("iz"):
+		s.b = append(s.b, 'e')
+	case s.doubleConsonant(last):
+		if c := s.b[last]; c != 'l' && c != 's' && c != 'z' {
+			s.b = s.b[:last]
+		}
+	case s.measure(len(s.b)) == 1 && s.cvc(last):
+		s.b = append(s.b, 'e')
+	}
+}
+
+// step1c turns terminal y to i when there is another vowel in the stem
+func (s *porterStemmer) step1c() {
+	if s.ends("y") && s.hasVowel(s.j) {
+		s.b[len(s.b)-1] = 'i'
+	}
+}
+
+var porterStep2 = [][2]string{
+	{"ational", "ate"}, {"tional", "tion"}, {"enci", "ence"}, {"anci", "ance"}, {"izer", "ize"}, {"bli", "ble"},
+	{"alli", "al"}, {"entli", "ent"}, {"eli", "e"}, {"ousli", "ous"}, {"ization", "ize"}, {"ation", "ate"},
+	{"ator", "ate"}, {"alism", "al"}, {"iveness", "ive"}, {"fulness", "ful"}, {"ousness", "ous"},
+	{"aliti", "al"}, {"iviti", "ive"}, {"biliti", "ble"}, {"logi", "log"},
+}
+
+var porterStep3 = [][2]string{
+	{"icate", "ic"}, {"ative", ""}, {"alize", "al"}, {"iciti", "ic"}, {"ical", "ic"}, {"ful", ""}, {"ness", ""},
+}
+
+var porterStep4 = [][2]string{
+	{"al", ""}, {"ance", ""}, {"ence", ""}, {"er", ""}, {"ic", ""}, {"able", ""}, {"ible", ""}, {"ant", ""},
+	{"ement", ""}, {"ment", ""}, {"ent", ""}, {"ou", ""}, {"ism", ""}, {"ate", ""}, {"iti", ""}, {"ous", ""},
+	{"ive", ""}, {"ize", ""},
+}
+
+// step2 maps double suffixes to single ones
+func (s *porterStemmer) step2() {
+	s.replaceSuffix(porterStep2, 0)
+}
+
+// step3 deals with -ic-, -full, -ness etc.
+func (s *porterStemmer) step3() {
+	s.replaceSuffix(porterStep3, 0)
+}
+
+// step4 removes -ant, -ence etc. from stems with measure above 1
+func (s *porterStemmer) step4() {
+	// -ion is removed only after s or t
+	if s.ends("ion") && s.j > 0 && (s.b[s.j-1] == 's' || s.b[s.j-1] == 't') {
+		if s.measure(s.j) > 1 {
+			s.setTo("")
+		}
+		return
+	}
+
+	s.replaceSuffix(porterStep4, 1)
+}
+
+// step5 removes final e and reduces -ll to -l for long stems
+func (s *porterStemmer) step5() {
+	if s.ends("e") {
+		m := s.measure(s.j)
+		if m > 1 || (m == 1 && !s.cvc(s.j-1)) {
+			s.b = s.b[:s.j]
+		}
+	}
+
+	last := len(s.b) - 1
+	if s.b[last] == 'l' && s.doubleConsonant(last) && s.measure(len(s.b)) > 1 {
+		s.b = s.b[:last]
+	}
+}
@@ -0,0 +1,38 @@
+package main
+
+import "testing"
+
+func TestStem(t *testing.T) {
+	for word, expected := range map[string]string{
+		"caresses":       "caress",
+		"ponies":         "poni",
+		"cats":           "cat",
+		"feed":           "feed",
+		"agreed":         "agre",
+		"plastered":      "plaster",
+		"motoring":       "motor",
+		"sing":           "sing",
+		"conflated":      "conflat",
+		"troubled":       "troubl",
+		"sized":          "size",
+		"hopping":        "hop",
+		"falling":        "fall",
+		"filing":         "file",
+		"happy":          "happi",
+		"relational":     "relat",
+		"connection":     "connect",
+		"connections":    "connect",
+		"connecting":     "connect",
+		"generalization": "gener",
+		"running":        "run",
+		"adjustment":     "adjust",
+		"controll":       "control",
+		"go":             "go",
+		"naïve":          "naïve",
+		"abc123":         "abc123",
+	} {
+		if res := stem(word); res != expected {
+			t.Errorf("stem(%q) = %q, expected: %q", word, res, expected)
+		}
+	}
+}
@@ -4,75 +4,330 @@ import (
 	"sync"
 )
 
+// dtypes of values that are not plain RESP scalars. Data of such values lives in KvsValue.object
+const (
+	StreamDtype     = 'x'
+	ZSetDtype       = 'z'
+	JSONDtype       = 'j'
+	BloomDtype      = 'B'
+	CuckooDtype     = 'C'
+	CMSDtype        = 'M'
+	TopKDtype       = 'K'
+	TimeSeriesDtype = 't'
+	VectorSetDtype  = 'v'
+	HashDtype       = 'h'
+	GraphDtype      = 'g'
+	ModuleDtype     = 'm'
+)
+
 type KvsValue struct {
-	dtype byte
-	value []byte
+	dtype  byte
+	value  []byte // integers are i64 little endian, booleans a single byte
+	object any
+	// unix ms after which the key is deleted, 0 if the key doesn't expire
+	expireAt int64
+	// generation of the last background snapshot the value was serialized for or created during
+	savedGen uint64
+}
+
+func (v *KvsValue) isScalar() bool {
+	return v.dtype == BulkStrSymbol || v.dtype == IntSymbol || v.dtype == BoolSymbol
 }
 
 type Kvs struct {
-	mu      sync.Mutex
-	storage map[string]*KvsValue
+	mu sync.Mutex
+	// keyspace, kept by the storage engine chosen on start
+	storage storageEngine
+	// channels of clients blocked until some key gets new data
+	keyWaiters map[string][]chan struct{}
+	// secondary indexes created with FT.CREATE by name
+	searchIndexes map[string]*searchIndex
+	// deadlines of keys with time to live
+	expires expireHeap
+	// set while EXEC runs queued commands or a script runs, blocking commands must not release the lock then
+	inExec bool
+	// version of the last modification of existing and watched keys, taken from the counter
+	versions map[string]uint64
+	version  uint64
+	// number of connections watching key
+	watchers map[string]int
+	// command being executed and client running it, client is nil for commands run by tests
+	cmd    *command
+	client *client
+	// number of changes of the keyspace, it tells whether a command has changed anything
+	dirty uint64
+	// values taken by write commands in the current batch of the storage engine, they are set back when
+	// it ends, so engines keeping copies see changes made in place. It's nil outside of batches
+	touched map[string]*KvsValue
+	// changes made by other commands while some command was blocked, they don't count toward its own
+	dirtyWhileBlocked uint64
+	// set while persisted data is loaded, blocking commands time out at once then as in EXEC
+	loading bool
+	// background snapshot being written and generation of the last one started
+	bgSnapshot  *snapshotCursor
+	snapshotGen uint64
 }
 
 var kvs Kvs
 
 func initStorage() {
-	kvs.storage = make(map[string]*KvsValue)
+	kvs.storage = storageEngines[config.storageEngine]()
+	kvs.keyWaiters = make(map[string][]chan struct{})
+	kvs.searchIndexes = make(map[string]*searchIndex)
+	kvs.expires = nil
+	kvs.versions = make(map[string]uint64)
+	kvs.watchers = make(map[string]int)
+}
+
+// storedValue returns value stored at key. Value taken by a write command is the one it changes until
+// its batch ends. Must be called with kvs.mu held
+func storedValue(key string) (*KvsValue, bool) {
+	if val, ok := kvs.touched[key]; ok {
+		return val, true
+	}
+
+	val, ok := kvs.storage.Get(key)
+	if ok && (kvs.cmd == nil || kvs.cmd.write) {
+		touchValue(key, val)
+	}
+
+	return val, ok
+}
+
+// touchValue makes val set back to the storage engine when the batch ends. Must be called with kvs.mu held
+func touchValue(key string, val *KvsValue) {
+	if kvs.touched != nil {
+		kvs.touched[key] = val
+	}
+}
+
+// batch runs fn in a batch of the storage engine, values changed in place by fn are set back before
+// the batch ends. Must be called with kvs.mu held
+func batch(fn func()) error {
+	return kvs.storage.Batch(func() {
+		outer := kvs.touched == nil
+		if outer {
+			kvs.touched = make(map[string]*KvsValue)
+		}
+
+		fn()
+		flushTouched()
+		if outer {
+			kvs.touched = nil
+		}
+	})
+}
+
+// flushTouched sets values taken by write commands back to the storage engine. Must be called with
+// kvs.mu held
+func flushTouched() {
+	for key, val := range kvs.touched {
+		kvs.storage.Set(key, val)
+	}
+	clear(kvs.touched)
 }
 
-func setHandler(args []*KvsValue) error {
-	if len(args) != 2 {
-		return ErrWrongSetArgsCount
+// lookupKey returns value stored at key. Missing keys looked up by read commands are reported with
+// keymiss event, and keys read by clients with tracking on are remembered. Value looked up by a write command
+// is serialized for a running background snapshot first. Must be called with kvs.mu held
+func lookupKey(key string) (*KvsValue, bool) {
+	val, ok := storedValue(key)
+	switch {
+	case ok && (kvs.cmd == nil || kvs.cmd.write):
+		preserveValue(key, val)
+	case !ok && kvs.cmd != nil && !kvs.cmd.write:
+		notifyKeyspaceEvent(notifyKeyMiss, "keymiss", key)
 	}
+	trackKey(key)
 
+	return val, ok
+}
+
+// storeKey stores val at key, creation of a new key is reported with new event and replaced value is freed.
+// Must be called with kvs.mu held
+func storeKey(key string, val *KvsValue) {
+	old, ok := storedValue(key)
+	switch {
+	case !ok:
+		notifyKeyspaceEvent(notifyNew, "new", key)
+	case old == val:
+		return
+	case !detachValue(key, old):
+		freeModuleValue(old)
+	}
+
+	markNewValue(val)
+	kvs.storage.Set(key, val)
+	touchValue(key, val)
+}
+
+// removeKey deletes key and frees its value, and tells whether it existed. Must be called with kvs.mu held
+func removeKey(key string) bool {
+	val, ok := storedValue(key)
+	if !ok {
+		return false
+	}
+
+	kvs.storage.Delete(key)
+	delete(kvs.touched, key)
+	if !detachValue(key, val) {
+		freeModuleValue(val)
+	}
+
+	return true
+}
+
+// signalModifiedKey must be called with kvs.mu held after value stored at key is changed, replaced
+// or deleted. It bumps key version, keeps secondary indexes up to date, publishes keyspace event of class
+// and invalidates key in client caches. Empty event is used for changes nobody is notified about individually,
+// like FLUSHALL
+func signalModifiedKey(key string, class int, event string) {
+	kvs.dirty++
+	kvs.version++
+	if _, ok := kvs.storage.Get(key); ok || kvs.watchers[key] > 0 {
+		kvs.versions[key] = kvs.version
+	} else {
+		delete(kvs.versions, key)
+	}
+
+	for _, idx := range kvs.searchIndexes {
+		idx.reindex(key)
+	}
+
+	if event != "" {
+		notifyKeyspaceEvent(class, event, key)
+		trackingInvalidateKey(key)
+	}
+}
+
+// markDirty counts change of state that isn't a change of some key's value, like a new consumer or
+// a secondary index. Must be called with kvs.mu held
+func markDirty() {
+	kvs.dirty++
+}
+
+// keyVersion returns version of the last modification of key, 0 if it was never modified or
+// is deleted and not watched. Must be called with kvs.mu held
+func keyVersion(key string) uint64 {
+	return kvs.versions[key]
+}
+
+// SET key value
+func setHandler(args []*KvsValue) ([]byte, error) {
 	key := args[0]
 	value := args[1]
 
 	if key.dtype != BulkStrSymbol {
-		return ErrWrongKeyDtype
+		return nil, ErrWrongKeyDtype
 	}
 
-	kvs.mu.Lock()
-	kvs.storage[string(key.value)] = value
-	kvs.mu.Unlock()
+	storeKey(string(key.value), value)
+	signalModifiedKey(string(key.value), notifyString, "set")
 
-	return nil
+	return []byte(OkResponse), nil
 }
 
-func getHandler(args []*KvsValue) (value *KvsValue, err error) {
-	if len(args) != 1 {
-		return nil, ErrWrongGetArgsCount
+// GET key
+func getHandler(args []*KvsValue) ([]byte, error) {
+	key := args[0]
+
+	if key.dtype != BulkStrSymbol {
+		return nil, ErrWrongKeyDtype
 	}
 
+	res, ok := lookupKey(string(key.value))
+	if !ok {
+		return []byte(NullResponse), nil
+	}
+
+	if !res.isScalar() {
+		return nil, ErrWrongType
+	}
+
+	return kvsValueToResponse(res), nil
+}
+
+// DELETE key
+func deleteHandler(args []*KvsValue) ([]byte, error) {
 	key := args[0]
 
 	if key.dtype != BulkStrSymbol {
 		return nil, ErrWrongKeyDtype
 	}
 
-	res, ok := kvs.storage[string(key.value)]
+	if removeKey(string(key.value)) {
+		signalModifiedKey(string(key.value), notifyGeneric, "del")
+	}
 
-	if !ok {
-		return nil, nil
+	return []byte(OkResponse), nil
+}
+
+// RENAME key newkey
+func renameHandler(args []*KvsValue) ([]byte, error) {
+	renamed, err := renameKey(argToString(args[0]), argToString(args[1]), false)
+	if err != nil || !renamed {
+		return nil, err
 	}
 
-	return res, nil
+	return []byte(OkResponse), nil
 }
 
-func deleteHandler(args []*KvsValue) error {
-	if len(args) != 1 {
-		return ErrWrongDelArgsCount
+// RENAMENX key newkey
+func renamenxHandler(args []*KvsValue) ([]byte, error) {
+	renamed, err := renameKey(argToString(args[0]), argToString(args[1]), true)
+	if err != nil {
+		return nil, err
 	}
 
-	key := args[0]
+	return boolIntResponse(renamed), nil
+}
 
-	if key.dtype != BulkStrSymbol {
-		return ErrWrongKeyDtype
+// renameKey moves value with its time to live from src to dst. If nx is true existing dst is kept
+// and false is returned
+func renameKey(src, dst string, nx bool) (bool, error) {
+	val, ok := lookupKey(src)
+	if !ok {
+		return false, ErrStreamNoSuchKey
+	}
+
+	if _, exists := kvs.storage.Get(dst); exists && nx {
+		return false, nil
+	}
+	if src == dst {
+		return true, nil
+	}
+
+	// the value moves, so it isn't freed
+	kvs.storage.Delete(src)
+	delete(kvs.touched, src)
+	removeKey(dst)
+	if val.expireAt > 0 {
+		setExpire(dst, val, val.expireAt)
+	} else {
+		storeKey(dst, val)
+	}
+
+	signalModifiedKey(src, notifyGeneric, "rename_from")
+	signalModifiedKey(dst, notifyGeneric, "rename_to")
+	signalKeyReady(dst)
+
+	return true, nil
+}
+
+// DBSIZE
+func dbsizeHandler(args []*KvsValue) ([]byte, error) {
+	return intResponse(int64(kvs.storage.Len())), nil
+}
+
+// FLUSHALL
+func flushallHandler(args []*KvsValue) ([]byte, error) {
+	for key := range kvs.storage.All() {
+		removeKey(key)
+		signalModifiedKey(key, 0, "")
 	}
+	trackingInvalidateAll()
 
-	kvs.mu.Lock()
-	delete(kvs.storage, string(key.value))
-	kvs.mu.Unlock()
+	kvs.expires = nil
 
-	return nil
+	return []byte(OkResponse), nil
 }
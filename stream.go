@@ -0,0 +1,775 @@
+package main
+
+import (
+	"encoding/binary"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// Stream entries are packed into blocks of up to streamBlockMaxEntries entries and blocks are indexed
+// in a radix tree by ID of their first (master) entry. Inside a block entries are stored as deltas
+// against the master entry, and entries with the same fields as master entry don't repeat field names,
+// so long streams of similar events stay compact.
+const (
+	streamBlockMaxEntries = 100
+	streamBlockMaxBytes   = 4096
+
+	streamEntryDeleted    = 0x01
+	streamEntrySameFields = 0x02
+)
+
+type streamID struct {
+	ms  uint64
+	seq uint64
+}
+
+var (
+	minStreamID = streamID{0, 0}
+	maxStreamID = streamID{math.MaxUint64, math.MaxUint64}
+)
+
+func (id streamID) String() string {
+	return strconv.FormatUint(id.ms, 10) + "-" + strconv.FormatUint(id.seq, 10)
+}
+
+func (id streamID) compare(other streamID) int {
+	switch {
+	case id.ms < other.ms:
+		return -1
+	case id.ms > other.ms:
+		return 1
+	case id.seq < other.seq:
+		return -1
+	case id.seq > other.seq:
+		return 1
+	}
+
+	return 0
+}
+
+func (id streamID) isZero() bool {
+	return id.ms == 0 && id.seq == 0
+}
+
+// returns ID right after this one and false if there is no such ID
+func (id streamID) next() (streamID, bool) {
+	switch {
+	case id.seq < math.MaxUint64:
+		return streamID{id.ms, id.seq + 1}, true
+	case id.ms < math.MaxUint64:
+		return streamID{id.ms + 1, 0}, true
+	}
+
+	return id, false
+}
+
+func (id streamID) prev() (streamID, bool) {
+	switch {
+	case id.seq > 0:
+		return streamID{id.ms, id.seq - 1}, true
+	case id.ms > 0:
+		return streamID{id.ms - 1, math.MaxUint64}, true
+	}
+
+	return id, false
+}
+
+// big endian so byte order of keys in radix tree matches order of IDs
+func (id streamID) key() []byte {
+	res := make([]byte, 16)
+	binary.BigEndian.PutUint64(res, id.ms)
+	binary.BigEndian.PutUint64(res[8:], id.seq)
+
+	return res
+}
+
+func streamIDFromKey(key []byte) streamID {
+	return streamID{ms: binary.BigEndian.Uint64(key), seq: binary.BigEndian.Uint64(key[8:])}
+}
+
+// parses "<ms>-<seq>" or "<ms>". If seq is missing, missingSeq is used for it
+func parseStreamID(s string, missingSeq uint64) (streamID, error) {
+	msPart, seqPart, hasSeq := strings.Cut(s, "-")
+
+	ms, err := strconv.ParseUint(msPart, 10, 64)
+	if err != nil {
+		return streamID{}, ErrInvalidStreamID
+	}
+
+	if !hasSeq {
+		return streamID{ms, missingSeq}, nil
+	}
+
+	seq, err := strconv.ParseUint(seqPart, 10, 64)
+	if err != nil {
+		return streamID{}, ErrInvalidStreamID
+	}
+
+	return streamID{ms, seq}, nil
+}
+
+type streamEntry struct {
+	id     streamID
+	fields [][]byte // field, value, field, value...
+}
+
+type streamBlock struct {
+	master     streamID
+	last       streamID
+	masterKeys [][]byte // field names of master entry
+	data       []byte
+	count      int // entries including deleted ones
+	deleted    int
+}
+
+func (b *streamBlock) live() int {
+	return b.count - b.deleted
+}
+
+func (b *streamBlock) full() bool {
+	return b.count >= streamBlockMaxEntries || len(b.data) >= streamBlockMaxBytes
+}
+
+func (b *streamBlock) sameFields(fields [][]byte) bool {
+	if len(fields)/2 != len(b.masterKeys) {
+		return false
+	}
+
+	for i, k := range b.masterKeys {
+		if string(fields[i*2]) != string(k) {
+			return false
+		}
+	}
+
+	return true
+}
+
+func appendBytes(buf []byte, b []byte) []byte {
+	buf = binary.AppendUvarint(buf, uint64(len(b)))
+	return append(buf, b...)
+}
+
+func (b *streamBlock) append(id streamID, fields [][]byte) {
+	var flags byte
+	same := b.sameFields(fields)
+	if same {
+		flags |= streamEntrySameFields
+	}
+
+	b.data = append(b.data, flags)
+	b.data = binary.AppendUvarint(b.data, id.ms-b.master.ms)
+	b.data = binary.AppendVarint(b.data, int64(id.seq-b.master.seq))
+
+	if !same {
+		b.data = binary.AppendUvarint(b.data, uint64(len(fields)/2))
+		for i := 0; i < len(fields); i += 2 {
+			b.data = appendBytes(b.data, fields[i])
+		}
+	}
+
+	for i := 1; i < len(fields); i += 2 {
+		b.data = appendBytes(b.data, fields[i])
+	}
+
+	b.count++
+	b.last = id
+}
+
+// blockEntry is a decoded entry together with offset of its flags byte in block data
+type blockEntry struct {
+	streamEntry
+	offset  int
+	deleted bool
+}
+
+func readBytes(data []byte, pos int) ([]byte, int) {
+	l, n := binary.Uvarint(data[pos:])
+	pos += n
+
+	return data[pos : pos+int(l) : pos+int(l)], pos + int(l)
+}
+
+func (b *streamBlock) entries() []blockEntry {
+	res := make([]blockEntry, 0, b.count)
+	pos := 0
+
+	for pos < len(b.data) {
+		e := blockEntry{offset: pos}
+		flags := b.data[pos]
+		pos++
+		e.deleted = flags&streamEntryDeleted != 0
+
+		msDelta, n := binary.Uvarint(b.data[pos:])
+		pos += n
+		seqDelta, n := binary.Varint(b.data[pos:])
+		pos += n
+		e.id = streamID{b.master.ms + msDelta, b.master.seq + uint64(seqDelta)}
+
+		keys := b.masterKeys
+		if flags&streamEntrySameFields == 0 {
+			fieldsCount, n := binary.Uvarint(b.data[pos:])
+			pos += n
+
+			keys = make([][]byte, fieldsCount)
+			for i := range keys {
+				keys[i], pos = readBytes(b.data, pos)
+			}
+		}
+
+		e.fields = make([][]byte, len(keys)*2)
+		for i := range keys {
+			e.fields[i*2] = keys[i]
+			e.fields[i*2+1], pos = readBytes(b.data, pos)
+		}
+
+		res = append(res, e)
+	}
+
+	return res
+}
+
+func (b *streamBlock) markDeleted(e blockEntry) {
+	b.data[e.offset] |= streamEntryDeleted
+	b.deleted++
+}
+
+type streamPendingEntry struct {
+	id            streamID
+	consumer      *streamConsumer
+	deliveryTime  int64 // unix ms of last delivery
+	deliveryCount int64
+}
+
+type streamConsumer struct {
+	name       string
+	seenTime   int64 // last time consumer attempted any interaction
+	activeTime int64 // last time consumer actually read or claimed something, -1 if never
+	pel        *radixTree[*streamPendingEntry]
+}
+
+type streamGroup struct {
+	name        string
+	lastID      streamID
+	entriesRead int64 // -1 when unknown
+	pel         *radixTree[*streamPendingEntry]
+	consumers   *radixTree[*streamConsumer]
+}
+
+type stream struct {
+	blocks       *radixTree[*streamBlock]
+	length       int64
+	lastID       streamID
+	maxDeletedID streamID
+	entriesAdded int64
+	groups       *radixTree[*streamGroup]
+}
+
+func newStream() *stream {
+	return &stream{
+		blocks: newRadixTree[*streamBlock](),
+		groups: newRadixTree[*streamGroup](),
+	}
+}
+
+func nowMs() int64 {
+	return clockNow().UnixMilli()
+}
+
+// nextID returns ID for XADD given "*", "<ms>-*" or explicit ID
+func (s *stream) nextID(idArg string) (streamID, error) {
+	if idArg == "*" {
+		ms := max(uint64(nowMs()), s.lastID.ms)
+		if ms == s.lastID.ms {
+			id, ok := s.lastID.next()
+			if !ok {
+				return id, ErrStreamIDTooSmall
+			}
+			return id, nil
+		}
+
+		return streamID{ms, 0}, nil
+	}
+
+	if msPart, ok := strings.CutSuffix(idArg, "-*"); ok {
+		ms, err := strconv.ParseUint(msPart, 10, 64)
+		if err != nil {
+			return streamID{}, ErrInvalidStreamID
+		}
+
+		switch {
+		case ms < s.lastID.ms:
+			return streamID{}, ErrStreamIDTooSmall
+		case ms == s.lastID.ms:
+			if s.lastID.seq == math.MaxUint64 {
+				return streamID{}, ErrStreamIDTooSmall
+			}
+			return streamID{ms, s.lastID.seq + 1}, nil
+		case ms == 0:
+			return streamID{0, 1}, nil
+		}
+
+		return streamID{ms, 0}, nil
+	}
+
+	id, err := parseStreamID(idArg, 0)
+	if err != nil {
+		return id, err
+	}
+
+	if id.isZero() {
+		return id, ErrStreamIDZero
+	}
+
+	if id.compare(s.lastID) <= 0 {
+		return id, ErrStreamIDTooSmall
+	}
+
+	return id, nil
+}
+
+func (s *stream) add(id streamID, fields [][]byte) {
+	_, b, ok := s.blocks.last()
+	if !ok || b.full() {
+		b = &streamBlock{master: id}
+		for i := 0; i < len(fields); i += 2 {
+			b.masterKeys = append(b.masterKeys, fields[i])
+		}
+		s.blocks.insert(id.key(), b)
+	}
+
+	b.append(id, fields)
+	s.length++
+	s.lastID = id
+	s.entriesAdded++
+}
+
+// iterate calls fn for every live entry in [start, end] in ascending or descending order until fn returns false
+func (s *stream) iterate(start, end streamID, reverse bool, fn func(e streamEntry) bool) {
+	if start.compare(end) > 0 {
+		return
+	}
+
+	var blockKey []byte
+	var b *streamBlock
+	var ok bool
+
+	if reverse {
+		blockKey, b, ok = s.blocks.floor(end.key(), true)
+	} else {
+		blockKey, b, ok = s.blocks.floor(start.key(), true)
+		if !ok {
+			blockKey, b, ok = s.blocks.first()
+		}
+	}
+
+	for ok {
+		entries := b.entries()
+
+		for i := range entries {
+			e := entries[i]
+			if reverse {
+				e = entries[len(entries)-1-i]
+			}
+
+			if e.deleted {
+				continue
+			}
+
+			if reverse && e.id.compare(end) > 0 || !reverse && e.id.compare(start) < 0 {
+				continue
+			}
+
+			if reverse && e.id.compare(start) < 0 || !reverse && e.id.compare(end) > 0 {
+				return
+			}
+
+			if !fn(e.streamEntry) {
+				return
+			}
+		}
+
+		if reverse {
+			blockKey, b, ok = s.blocks.floor(blockKey, false)
+		} else {
+			blockKey, b, ok = s.blocks.ceiling(blockKey, false)
+		}
+	}
+}
+
+func (s *stream) rangeEntries(start, end streamID, count int64, reverse bool) []streamEntry {
+	var res []streamEntry
+
+	s.iterate(start, end, reverse, func(e streamEntry) bool {
+		res = append(res, e)
+		return count <= 0 || int64(len(res)) < count
+	})
+
+	return res
+}
+
+func (s *stream) lookup(id streamID) (streamEntry, bool) {
+	var res streamEntry
+	found := false
+
+	s.iterate(id, id, false, func(e streamEntry) bool {
+		res = e
+		found = true
+		return false
+	})
+
+	return res, found
+}
+
+func (s *stream) firstEntry() (streamEntry, bool) {
+	res := s.rangeEntries(minStreamID, maxStreamID, 1, false)
+	if len(res) == 0 {
+		return streamEntry{}, false
+	}
+
+	return res[0], true
+}
+
+func (s *stream) lastEntry() (streamEntry, bool) {
+	res := s.rangeEntries(minStreamID, maxStreamID, 1, true)
+	if len(res) == 0 {
+		return streamEntry{}, false
+	}
+
+	return res[0], true
+}
+
+// delete marks entry as deleted. Blocks with no live entries left are dropped from the tree
+func (s *stream) delete(id streamID) bool {
+	blockKey, b, ok := s.blocks.floor(id.key(), true)
+	if !ok {
+		return false
+	}
+
+	for _, e := range b.entries() {
+		if e.id != id {
+			continue
+		}
+
+		if e.deleted {
+			return false
+		}
+
+		b.markDeleted(e)
+		s.length--
+		if id.compare(s.maxDeletedID) > 0 {
+			s.maxDeletedID = id
+		}
+
+		if b.live() == 0 {
+			s.blocks.remove(blockKey)
+		}
+
+		return true
+	}
+
+	return false
+}
+
+// trim removes entries from the head of the stream while shouldRemove returns true for them.
+// With approx trimming only whole blocks are removed. limit (if > 0) caps number of removed entries
+// and applies only to approx trimming. Returns number of removed entries
+func (s *stream) trim(shouldRemove func(b *streamBlock, e *streamEntry) bool, approx bool, limit int64) int64 {
+	var removed int64
+
+	for {
+		blockKey, b, ok := s.blocks.first()
+		if !ok {
+			break
+		}
+
+		if shouldRemove(b, nil) {
+			if approx && limit > 0 && removed+int64(b.live()) > limit {
+				break
+			}
+
+			s.blocks.remove(blockKey)
+			s.length -= int64(b.live())
+			removed += int64(b.live())
+			if b.last.compare(s.maxDeletedID) > 0 {
+				s.maxDeletedID = b.last
+			}
+			continue
+		}
+
+		if approx {
+			break
+		}
+
+		for _, e := range b.entries() {
+			if e.deleted {
+				continue
+			}
+
+			if !shouldRemove(nil, &e.streamEntry) {
+				break
+			}
+
+			b.markDeleted(e)
+			s.length--
+			removed++
+			if e.id.compare(s.maxDeletedID) > 0 {
+				s.maxDeletedID = e.id
+			}
+		}
+
+		break
+	}
+
+	return removed
+}
+
+func (s *stream) trimMaxLen(maxLen int64, approx bool, limit int64) int64 {
+	return s.trim(func(b *streamBlock, e *streamEntry) bool {
+		if b != nil {
+			return s.length-int64(b.live()) >= maxLen
+		}
+
+		return s.length > maxLen
+	}, approx, limit)
+}
+
+func (s *stream) trimMinID(minID streamID, approx bool, limit int64) int64 {
+	return s.trim(func(b *streamBlock, e *streamEntry) bool {
+		if b != nil {
+			return b.last.compare(minID) < 0
+		}
+
+		return e.id.compare(minID) < 0
+	}, approx, limit)
+}
+
+func (s *stream) group(name string) (*streamGroup, bool) {
+	return s.groups.find([]byte(name))
+}
+
+func (s *stream) createGroup(name string, lastID streamID, entriesRead int64) (*streamGroup, bool) {
+	if _, exists := s.group(name); exists {
+		return nil, false
+	}
+
+	g := &streamGroup{
+		name:        name,
+		lastID:      lastID,
+		entriesRead: entriesRead,
+		pel:         newRadixTree[*streamPendingEntry](),
+		consumers:   newRadixTree[*streamConsumer](),
+	}
+	s.groups.insert([]byte(name), g)
+
+	return g, true
+}
+
+// entriesReadFor estimates value of entries-read counter of a group positioned at id, -1 if it can't be known
+func (s *stream) entriesReadFor(id streamID) int64 {
+	if s.entriesAdded == 0 {
+		return 0
+	}
+
+	if s.length == 0 && id.compare(s.lastID) < 1 {
+		return s.entriesAdded
+	}
+
+	if id.compare(s.lastID) >= 0 {
+		return s.entriesAdded
+	}
+
+	if first, ok := s.firstEntry(); ok && id.compare(first.id) < 0 && s.maxDeletedID.isZero() {
+		return 0
+	}
+
+	return -1
+}
+
+// lag is number of entries in the stream that are yet to be delivered to the group, -1 if unknown
+func (s *stream) lag(g *streamGroup) int64 {
+	if s.entriesAdded == 0 {
+		return 0
+	}
+
+	if g.entriesRead >= 0 && (s.maxDeletedID.isZero() || s.maxDeletedID.compare(g.lastID) <= 0) {
+		return s.entriesAdded - g.entriesRead
+	}
+
+	entriesRead := s.entriesReadFor(g.lastID)
+	if entriesRead < 0 {
+		return -1
+	}
+
+	return s.entriesAdded - entriesRead
+}
+
+func (g *streamGroup) consumer(name string, create bool) (c *streamConsumer, created bool) {
+	c, ok := g.consumers.find([]byte(name))
+	if ok || !create {
+		return c, false
+	}
+
+	c = &streamConsumer{name: name, seenTime: nowMs(), activeTime: -1, pel: newRadixTree[*streamPendingEntry]()}
+	g.consumers.insert([]byte(name), c)
+
+	return c, true
+}
+
+// deleteConsumer removes consumer with its pending entries and returns how many entries were pending
+func (g *streamGroup) deleteConsumer(name string) int64 {
+	c, ok := g.consumers.find([]byte(name))
+	if !ok {
+		return 0
+	}
+
+	pending := int64(c.pel.len())
+	c.pel.walk(false, func(key []byte, _ *streamPendingEntry) bool {
+		g.pel.remove(key)
+		return true
+	})
+	g.consumers.remove([]byte(name))
+
+	return pending
+}
+
+// deliver records delivery of id to consumer c, moving the pending entry between consumers if needed
+func (g *streamGroup) deliver(c *streamConsumer, id streamID, now int64, countDelivery bool) *streamPendingEntry {
+	key := id.key()
+
+	pe, ok := g.pel.find(key)
+	if !ok {
+		pe = &streamPendingEntry{id: id}
+		g.pel.insert(key, pe)
+	} else if pe.consumer != c {
+		pe.consumer.pel.remove(key)
+	}
+
+	pe.consumer = c
+	pe.deliveryTime = now
+	if countDelivery {
+		pe.deliveryCount++
+	}
+	c.pel.insert(key, pe)
+
+	return pe
+}
+
+func (g *streamGroup) ack(id streamID) bool {
+	key := id.key()
+
+	pe, ok := g.pel.find(key)
+	if !ok {
+		return false
+	}
+
+	g.pel.remove(key)
+	pe.consumer.pel.remove(key)
+
+	return true
+}
+
+func appendStreamID(b []byte, id streamID) []byte {
+	return appendU64(appendU64(b, id.ms), id.seq)
+}
+
+func (r *dumpReader) streamID() streamID {
+	return streamID{ms: r.u64(), seq: r.u64()}
+}
+
+// dump serializes blocks as they are, then consumer groups with their consumers and pending entries
+func (s *stream) dump(b []byte) []byte {
+	b = appendU64(b, uint64(s.length))
+	b = appendStreamID(b, s.lastID)
+	b = appendStreamID(b, s.maxDeletedID)
+	b = appendU64(b, uint64(s.entriesAdded))
+
+	b = appendU32(b, s.blocks.len())
+	s.blocks.walk(false, func(_ []byte, blk *streamBlock) bool {
+		b = appendStreamID(b, blk.master)
+		b = appendStreamID(b, blk.last)
+		b = appendU32(b, len(blk.masterKeys))
+		for _, k := range blk.masterKeys {
+			b = appendStr(b, k)
+		}
+		b = appendStr(b, blk.data)
+		b = appendU32(b, blk.count)
+		b = appendU32(b, blk.deleted)
+		return true
+	})
+
+	b = appendU32(b, s.groups.len())
+	s.groups.walk(false, func(_ []byte, g *streamGroup) bool {
+		b = appendStr(b, g.name)
+		b = appendStreamID(b, g.lastID)
+		b = appendU64(b, uint64(g.entriesRead))
+
+		b = appendU32(b, g.consumers.len())
+		g.consumers.walk(false, func(_ []byte, c *streamConsumer) bool {
+			b = appendStr(b, c.name)
+			b = appendU64(b, uint64(c.seenTime))
+			b = appendU64(b, uint64(c.activeTime))
+			return true
+		})
+
+		b = appendU32(b, g.pel.len())
+		g.pel.walk(false, func(_ []byte, pe *streamPendingEntry) bool {
+			b = appendStreamID(b, pe.id)
+			b = appendStr(b, pe.consumer.name)
+			b = appendU64(b, uint64(pe.deliveryTime))
+			b = appendU64(b, uint64(pe.deliveryCount))
+			return true
+		})
+		return true
+	})
+
+	return b
+}
+
+func restoreStream(r *dumpReader) *stream {
+	s := newStream()
+	s.length = r.i64()
+	s.lastID = r.streamID()
+	s.maxDeletedID = r.streamID()
+	s.entriesAdded = r.i64()
+
+	for range r.count(40) {
+		blk := &streamBlock{master: r.streamID(), last: r.streamID()}
+		blk.masterKeys = make([][]byte, r.count(4))
+		for i := range blk.masterKeys {
+			blk.masterKeys[i] = r.bytes()
+		}
+		blk.data = r.bytes()
+		blk.count, blk.deleted = int(r.u32()), int(r.u32())
+		s.blocks.insert(blk.master.key(), blk)
+	}
+
+	for range r.count(36) {
+		g, created := s.createGroup(r.str(), r.streamID(), r.i64())
+		r.check(created)
+		if r.failed {
+			return s
+		}
+
+		for range r.count(20) {
+			c := &streamConsumer{name: r.str(), seenTime: r.i64(), activeTime: r.i64(), pel: newRadixTree[*streamPendingEntry]()}
+			g.consumers.insert([]byte(c.name), c)
+		}
+
+		for range r.count(36) {
+			pe := &streamPendingEntry{id: r.streamID()}
+			c, ok := g.consumers.find([]byte(r.str()))
+			r.check(ok)
+			if r.failed {
+				return s
+			}
+			pe.consumer, pe.deliveryTime, pe.deliveryCount = c, r.i64(), r.i64()
+
+			key := pe.id.key()
+			g.pel.insert(key, pe)
+			c.pel.insert(key, pe)
+		}
+	}
+
+	return s
+}
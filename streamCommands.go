@@ -0,0 +1,1364 @@
+package main
+
+import (
+	"math"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	streamDefaultAutoclaimCount = 100
+	streamDefaultInfoFullCount  = 10
+)
+
+// lookupStream returns stream stored at key. If key doesn't exist and create is true, new stream is stored,
+// otherwise nil is returned
+func lookupStream(key string, create bool) (*stream, error) {
+	val, ok := lookupKey(key)
+	if !ok {
+		if !create {
+			return nil, nil
+		}
+
+		s := newStream()
+		storeKey(key, &KvsValue{dtype: StreamDtype, object: s})
+
+		return s, nil
+	}
+
+	if val.dtype != StreamDtype {
+		return nil, ErrWrongType
+	}
+
+	return val.object.(*stream), nil
+}
+
+func lookupStreamGroup(key, groupName string) (*stream, *streamGroup, error) {
+	s, err := lookupStream(key, false)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if s == nil {
+		return nil, nil, ErrStreamNoGroup
+	}
+
+	g, ok := s.group(groupName)
+	if !ok {
+		return nil, nil, ErrStreamNoGroup
+	}
+
+	return s, g, nil
+}
+
+func streamIDResponse(id streamID) []byte {
+	return bulkStrResponse([]byte(id.String()))
+}
+
+func streamEntryResponse(e streamEntry) []byte {
+	return arrayResponse(streamIDResponse(e.id), bulkStrArrayResponse(e.fields...))
+}
+
+func streamEntriesResponse(entries []streamEntry) []byte {
+	elems := make([][]byte, len(entries))
+	for i, e := range entries {
+		elems[i] = streamEntryResponse(e)
+	}
+
+	return arrayResponse(elems...)
+}
+
+type streamTrimArgs struct {
+	enabled bool
+	byMinID bool
+	approx  bool
+	maxLen  int64
+	minID   streamID
+	limit   int64
+}
+
+// parseStreamTrimArgs parses "<MAXLEN | MINID> [= | ~] threshold [LIMIT count]" starting at args[i].
+// Returns index of the first arg after trimming options
+func parseStreamTrimArgs(args []*KvsValue, i int) (opts streamTrimArgs, next int, err error) {
+	strategy := strings.ToUpper(argToString(args[i]))
+	switch strategy {
+	case "MAXLEN":
+	case "MINID":
+		opts.byMinID = true
+	default:
+		return opts, i, nil
+	}
+
+	opts.enabled = true
+	opts.limit = -1
+	i++
+
+	if i < len(args) {
+		switch argToString(args[i]) {
+		case "~":
+			opts.approx = true
+			i++
+		case "=":
+			i++
+		}
+	}
+
+	if i >= len(args) {
+		return opts, i, ErrSyntax
+	}
+
+	if opts.byMinID {
+		opts.minID, err = parseStreamID(argToString(args[i]), 0)
+	} else {
+		opts.maxLen, err = argToInt64(args[i])
+		if err == nil && opts.maxLen < 0 {
+			err = ErrNotInteger
+		}
+	}
+	if err != nil {
+		return opts, i, err
+	}
+	i++
+
+	if i+1 < len(args) && strings.ToUpper(argToString(args[i])) == "LIMIT" {
+		opts.limit, err = argToInt64(args[i+1])
+		if err != nil || opts.limit < 0 {
+			return opts, i, ErrNotInteger
+		}
+
+		if !opts.approx {
+			return opts, i, ErrStreamLimitNoApprox
+		}
+
+		i += 2
+	}
+
+	if opts.limit < 0 {
+		opts.limit = 100 * streamBlockMaxEntries
+	}
+
+	return opts, i, nil
+}
+
+func (s *stream) applyTrim(opts streamTrimArgs) int64 {
+	if !opts.enabled {
+		return 0
+	}
+
+	if opts.byMinID {
+		return s.trimMinID(opts.minID, opts.approx, opts.limit)
+	}
+
+	return s.trimMaxLen(opts.maxLen, opts.approx, opts.limit)
+}
+
+// XADD key [NOMKSTREAM] [<MAXLEN | MINID> [= | ~] threshold [LIMIT count]] <* | id> field value [field value ...]
+func xaddHandler(args []*KvsValue) ([]byte, error) {
+	key := argToString(args[0])
+	noMkStream := false
+	i := 1
+
+	if strings.ToUpper(argToString(args[i])) == "NOMKSTREAM" {
+		noMkStream = true
+		i++
+	}
+
+	trimOpts, i, err := parseStreamTrimArgs(args, i)
+	if err != nil {
+		return nil, err
+	}
+
+	if i >= len(args) {
+		return nil, wrongArgsCountErr("XADD")
+	}
+
+	fieldArgs := args[i+1:]
+	if len(fieldArgs) == 0 || len(fieldArgs)%2 != 0 {
+		return nil, wrongArgsCountErr("XADD")
+	}
+
+	s, err := lookupStream(key, false)
+	if err != nil {
+		return nil, err
+	}
+
+	if s == nil {
+		if noMkStream {
+			return []byte(NullResponse), nil
+		}
+		s = newStream()
+	}
+
+	id, err := s.nextID(argToString(args[i]))
+	if err != nil {
+		return nil, err
+	}
+
+	fields := make([][]byte, len(fieldArgs))
+	for j, arg := range fieldArgs {
+		fields[j] = []byte(argToString(arg))
+	}
+
+	if _, ok := kvs.storage.Get(key); !ok {
+		storeKey(key, &KvsValue{dtype: StreamDtype, object: s})
+	}
+
+	s.add(id, fields)
+	s.applyTrim(trimOpts)
+	signalModifiedKey(key, notifyStream, "xadd")
+	signalKeyReady(key)
+
+	return streamIDResponse(id), nil
+}
+
+// parses range boundary of XRANGE: "-", "+", "(<id>" for exclusive or incomplete IDs
+func parseStreamRangeID(arg string, isStart bool) (id streamID, ok bool, err error) {
+	switch arg {
+	case "-":
+		return minStreamID, true, nil
+	case "+":
+		return maxStreamID, true, nil
+	}
+
+	missingSeq := uint64(0)
+	if !isStart {
+		missingSeq = math.MaxUint64
+	}
+
+	exclusive := strings.HasPrefix(arg, "(")
+	id, err = parseStreamID(strings.TrimPrefix(arg, "("), missingSeq)
+	if err != nil || !exclusive {
+		return id, true, err
+	}
+
+	if isStart {
+		id, ok = id.next()
+	} else {
+		id, ok = id.prev()
+	}
+
+	return id, ok, nil
+}
+
+func streamRange(args []*KvsValue, reverse bool) ([]byte, error) {
+	startArg, endArg := args[1], args[2]
+	if reverse {
+		startArg, endArg = endArg, startArg
+	}
+
+	start, startOk, err := parseStreamRangeID(argToString(startArg), true)
+	if err != nil {
+		return nil, err
+	}
+
+	end, endOk, err := parseStreamRangeID(argToString(endArg), false)
+	if err != nil {
+		return nil, err
+	}
+
+	count := int64(-1)
+	if len(args) > 3 {
+		if len(args) != 5 || strings.ToUpper(argToString(args[3])) != "COUNT" {
+			return nil, ErrSyntax
+		}
+
+		count, err = argToInt64(args[4])
+		if err != nil {
+			return nil, err
+		}
+
+		if count < 0 {
+			count = 0
+		}
+	}
+
+	s, err := lookupStream(argToString(args[0]), false)
+	if err != nil {
+		return nil, err
+	}
+
+	if s == nil || !startOk || !endOk || count == 0 {
+		return []byte(EmptyArrayResponse), nil
+	}
+
+	return streamEntriesResponse(s.rangeEntries(start, end, count, reverse)), nil
+}
+
+// XRANGE key start end [COUNT count]
+func xrangeHandler(args []*KvsValue) ([]byte, error) {
+	return streamRange(args, false)
+}
+
+// XREVRANGE key end start [COUNT count]
+func xrevrangeHandler(args []*KvsValue) ([]byte, error) {
+	return streamRange(args, true)
+}
+
+// XLEN key
+func xlenHandler(args []*KvsValue) ([]byte, error) {
+	s, err := lookupStream(argToString(args[0]), false)
+	if err != nil || s == nil {
+		return intResponse(0), err
+	}
+
+	return intResponse(s.length), nil
+}
+
+// XDEL key id [id ...]
+func xdelHandler(args []*KvsValue) ([]byte, error) {
+	ids := make([]streamID, 0, len(args)-1)
+	for _, arg := range args[1:] {
+		id, err := parseStreamID(argToString(arg), 0)
+		if err != nil {
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+
+	key := argToString(args[0])
+
+	s, err := lookupStream(key, false)
+	if err != nil || s == nil {
+		return intResponse(0), err
+	}
+
+	var deleted int64
+	for _, id := range ids {
+		if s.delete(id) {
+			deleted++
+		}
+	}
+
+	if deleted > 0 {
+		signalModifiedKey(key, notifyStream, "xdel")
+	}
+
+	return intResponse(deleted), nil
+}
+
+// XTRIM key <MAXLEN | MINID> [= | ~] threshold [LIMIT count]
+func xtrimHandler(args []*KvsValue) ([]byte, error) {
+	opts, next, err := parseStreamTrimArgs(args, 1)
+	if err != nil {
+		return nil, err
+	}
+
+	if !opts.enabled || next != len(args) {
+		return nil, ErrSyntax
+	}
+
+	key := argToString(args[0])
+
+	s, err := lookupStream(key, false)
+	if err != nil || s == nil {
+		return intResponse(0), err
+	}
+
+	trimmed := s.applyTrim(opts)
+	if trimmed > 0 {
+		signalModifiedKey(key, notifyStream, "xtrim")
+	}
+
+	return intResponse(trimmed), nil
+}
+
+type streamReadArgs struct {
+	group    string
+	consumer string
+	count    int64
+	block    bool
+	timeout  time.Duration
+	noAck    bool
+	keys     []string
+	ids      []string
+}
+
+// parses options of XREAD and XREADGROUP. args must start right after GROUP group consumer for XREADGROUP
+func parseStreamReadArgs(args []*KvsValue, isGroup bool) (opts streamReadArgs, err error) {
+	for i := 0; i < len(args); i++ {
+		opt := strings.ToUpper(argToString(args[i]))
+		hasValue := i+1 < len(args)
+
+		switch {
+		case opt == "COUNT" && hasValue:
+			opts.count, err = argToInt64(args[i+1])
+			if err != nil {
+				return opts, err
+			}
+			i++
+		case opt == "BLOCK" && hasValue:
+			ms, err := argToInt64(args[i+1])
+			if err != nil {
+				return opts, ErrNotInteger
+			}
+			if ms < 0 {
+				return opts, ErrTimeoutNegative
+			}
+			opts.block = true
+			opts.timeout = time.Duration(ms) * time.Millisecond
+			i++
+		case opt == "NOACK" && isGroup:
+			opts.noAck = true
+		case opt == "STREAMS":
+			rest := args[i+1:]
+			if len(rest) == 0 || len(rest)%2 != 0 {
+				return opts, ErrStreamUnbalancedRead
+			}
+
+			half := len(rest) / 2
+			for j := range half {
+				opts.keys = append(opts.keys, argToString(rest[j]))
+				opts.ids = append(opts.ids, argToString(rest[half+j]))
+			}
+
+			return opts, nil
+		default:
+			return opts, ErrSyntax
+		}
+	}
+
+	return opts, ErrSyntax
+}
+
+func streamReadReply(keys []string, results [][]byte) []byte {
+	elems := make([][]byte, 0, len(keys))
+	for i, key := range keys {
+		if results[i] == nil {
+			continue
+		}
+		elems = append(elems, arrayResponse(bulkStrResponse([]byte(key)), results[i]))
+	}
+
+	return arrayResponse(elems...)
+}
+
+// XREAD [COUNT count] [BLOCK milliseconds] STREAMS key [key ...] id [id ...]
+func xreadHandler(args []*KvsValue) ([]byte, error) {
+	opts, err := parseStreamReadArgs(args, false)
+	if err != nil {
+		return nil, err
+	}
+
+	// "$" has to be resolved once before blocking, so we get only entries added after the call
+	ids := make([]streamID, len(opts.keys))
+	for i, key := range opts.keys {
+		s, err := lookupStream(key, false)
+		if err != nil {
+			return nil, err
+		}
+
+		if opts.ids[i] == "$" {
+			if s != nil {
+				ids[i] = s.lastID
+			}
+			continue
+		}
+
+		ids[i], err = parseStreamID(opts.ids[i], 0)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	deadline := time.Now().Add(opts.timeout)
+
+	for {
+		results := make([][]byte, len(opts.keys))
+		found := false
+
+		for i, key := range opts.keys {
+			s, err := lookupStream(key, false)
+			if err != nil {
+				return nil, err
+			}
+
+			start, ok := ids[i].next()
+			if s == nil || !ok {
+				continue
+			}
+
+			entries := s.rangeEntries(start, maxStreamID, opts.count, false)
+			if len(entries) > 0 {
+				results[i] = streamEntriesResponse(entries)
+				found = true
+			}
+		}
+
+		if found {
+			return streamReadReply(opts.keys, results), nil
+		}
+
+		if !opts.block || !waitUntil(opts.keys, opts.timeout, deadline) {
+			return []byte(NullResponse), nil
+		}
+	}
+}
+
+// waitUntil blocks on keys for what's left until deadline. Returns false if time is out
+func waitUntil(keys []string, timeout time.Duration, deadline time.Time) bool {
+	if timeout == 0 {
+		return waitForKeys(keys, 0)
+	}
+
+	left := time.Until(deadline)
+	if left <= 0 {
+		return false
+	}
+
+	return waitForKeys(keys, left)
+}
+
+// XREADGROUP GROUP group consumer [COUNT count] [BLOCK milliseconds] [NOACK] STREAMS key [key ...] id [id ...]
+func xreadgroupHandler(args []*KvsValue) ([]byte, error) {
+	if strings.ToUpper(argToString(args[0])) != "GROUP" {
+		return nil, ErrSyntax
+	}
+
+	opts, err := parseStreamReadArgs(args[3:], true)
+	if err != nil {
+		return nil, err
+	}
+	opts.group = argToString(args[1])
+	opts.consumer = argToString(args[2])
+
+	for _, id := range opts.ids {
+		if id == "$" {
+			return nil, ErrStreamGroupDollar
+		}
+		if id != ">" {
+			if _, err := parseStreamID(id, 0); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	deadline := time.Now().Add(opts.timeout)
+
+	for {
+		results := make([][]byte, len(opts.keys))
+		found := false
+		canBlock := opts.block
+		now := nowMs()
+
+		for i, key := range opts.keys {
+			s, g, err := lookupStreamGroup(key, opts.group)
+			if err != nil {
+				return nil, err
+			}
+
+			c, created := g.consumer(opts.consumer, true)
+			c.seenTime = now
+			if created {
+				markDirty()
+			}
+
+			if opts.ids[i] != ">" {
+				// history of this consumer can't grow by waiting, so such read never blocks
+				canBlock = false
+				id, _ := parseStreamID(opts.ids[i], 0)
+				results[i] = streamConsumerHistory(s, c, id, opts.count)
+				found = true
+				continue
+			}
+
+			entries := s.rangeEntries(streamNextOrMax(g.lastID), maxStreamID, opts.count, false)
+			if g.lastID == maxStreamID || len(entries) == 0 {
+				continue
+			}
+
+			c.activeTime = now
+			markDirty()
+			for _, e := range entries {
+				s.advanceGroup(g, e.id)
+				if !opts.noAck {
+					g.deliver(c, e.id, now, true)
+				}
+			}
+
+			results[i] = streamEntriesResponse(entries)
+			found = true
+		}
+
+		if found {
+			return streamReadReply(opts.keys, results), nil
+		}
+
+		if !canBlock || !waitUntil(opts.keys, opts.timeout, deadline) {
+			return []byte(NullResponse), nil
+		}
+	}
+}
+
+func streamNextOrMax(id streamID) streamID {
+	next, ok := id.next()
+	if !ok {
+		return maxStreamID
+	}
+
+	return next
+}
+
+// advanceGroup moves last delivered ID of group forward keeping entries-read counter accurate when possible
+func (s *stream) advanceGroup(g *streamGroup, id streamID) {
+	noTombstones := s.maxDeletedID.isZero() || s.maxDeletedID.compare(g.lastID) <= 0
+
+	if g.entriesRead >= 0 && noTombstones {
+		g.entriesRead++
+	} else {
+		g.entriesRead = s.entriesReadFor(id)
+	}
+
+	g.lastID = id
+}
+
+// entries already delivered to consumer with IDs greater than id. Entries deleted from stream are reported without fields
+func streamConsumerHistory(s *stream, c *streamConsumer, id streamID, count int64) []byte {
+	var elems [][]byte
+
+	start, ok := id.next()
+	if !ok {
+		return arrayResponse()
+	}
+
+	key, _, found := c.pel.ceiling(start.key(), true)
+	for found && (count <= 0 || int64(len(elems)) < count) {
+		pendingID := streamIDFromKey(key)
+
+		if e, ok := s.lookup(pendingID); ok {
+			elems = append(elems, streamEntryResponse(e))
+		} else {
+			elems = append(elems, arrayResponse(streamIDResponse(pendingID), []byte(NullResponse)))
+		}
+
+		key, _, found = c.pel.ceiling(key, false)
+	}
+
+	return arrayResponse(elems...)
+}
+
+// XACK key group id [id ...]
+func xackHandler(args []*KvsValue) ([]byte, error) {
+	ids := make([]streamID, 0, len(args)-2)
+	for _, arg := range args[2:] {
+		id, err := parseStreamID(argToString(arg), 0)
+		if err != nil {
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+
+	_, g, err := lookupStreamGroup(argToString(args[0]), argToString(args[1]))
+	if err != nil {
+		if err == ErrStreamNoGroup {
+			return intResponse(0), nil
+		}
+		return nil, err
+	}
+
+	var acked int64
+	for _, id := range ids {
+		if g.ack(id) {
+			acked++
+		}
+	}
+	if acked > 0 {
+		markDirty()
+	}
+
+	return intResponse(acked), nil
+}
+
+// XPENDING key group [[IDLE min-idle-time] start end count [consumer]]
+func xpendingHandler(args []*KvsValue) ([]byte, error) {
+	_, g, err := lookupStreamGroup(argToString(args[0]), argToString(args[1]))
+	if err != nil {
+		return nil, err
+	}
+
+	if len(args) == 2 {
+		return streamPendingSummary(g), nil
+	}
+
+	rest := args[2:]
+	minIdle := int64(0)
+
+	if strings.ToUpper(argToString(rest[0])) == "IDLE" {
+		if len(rest) < 2 {
+			return nil, ErrSyntax
+		}
+
+		minIdle, err = argToInt64(rest[1])
+		if err != nil {
+			return nil, err
+		}
+		rest = rest[2:]
+	}
+
+	if len(rest) != 3 && len(rest) != 4 {
+		return nil, ErrSyntax
+	}
+
+	start, startOk, err := parseStreamRangeID(argToString(rest[0]), true)
+	if err != nil {
+		return nil, err
+	}
+
+	end, endOk, err := parseStreamRangeID(argToString(rest[1]), false)
+	if err != nil {
+		return nil, err
+	}
+
+	count, err := argToInt64(rest[2])
+	if err != nil {
+		return nil, err
+	}
+
+	pel := g.pel
+	if len(rest) == 4 {
+		c, ok := g.consumer(argToString(rest[3]), false)
+		if !ok {
+			return []byte(EmptyArrayResponse), nil
+		}
+		pel = c.pel
+	}
+
+	if !startOk || !endOk || count <= 0 {
+		return []byte(EmptyArrayResponse), nil
+	}
+
+	var elems [][]byte
+	now := nowMs()
+
+	key, pe, found := pel.ceiling(start.key(), true)
+	for found && int64(len(elems)) < count && pe.id.compare(end) <= 0 {
+		idle := now - pe.deliveryTime
+		if idle >= minIdle {
+			elems = append(elems, arrayResponse(
+				streamIDResponse(pe.id),
+				bulkStrResponse([]byte(pe.consumer.name)),
+				intResponse(idle),
+				intResponse(pe.deliveryCount),
+			))
+		}
+
+		key, pe, found = pel.ceiling(key, false)
+	}
+
+	return arrayResponse(elems...), nil
+}
+
+func streamPendingSummary(g *streamGroup) []byte {
+	if g.pel.len() == 0 {
+		return arrayResponse(intResponse(0), []byte(NullResponse), []byte(NullResponse), []byte(NullResponse))
+	}
+
+	_, first, _ := g.pel.first()
+	_, last, _ := g.pel.last()
+
+	var consumers [][]byte
+	g.consumers.walk(false, func(_ []byte, c *streamConsumer) bool {
+		if c.pel.len() > 0 {
+			consumers = append(consumers, bulkStrArrayResponse([]byte(c.name), []byte(strconv.Itoa(c.pel.len()))))
+		}
+		return true
+	})
+
+	return arrayResponse(
+		intResponse(int64(g.pel.len())),
+		streamIDResponse(first.id),
+		streamIDResponse(last.id),
+		arrayResponse(consumers...),
+	)
+}
+
+type streamClaimArgs struct {
+	deliveryTime int64 // -1 if not set
+	retryCount   int64 // -1 if not set
+	force        bool
+	justID       bool
+	lastID       streamID
+	hasLastID    bool
+}
+
+// XCLAIM key group consumer min-idle-time id [id ...] [IDLE ms] [TIME unix-time-milliseconds]
+// [RETRYCOUNT count] [FORCE] [JUSTID] [LASTID lastid]
+func xclaimHandler(args []*KvsValue) ([]byte, error) {
+	s, g, err := lookupStreamGroup(argToString(args[0]), argToString(args[1]))
+	if err != nil {
+		return nil, err
+	}
+
+	minIdle, err := argToInt64(args[3])
+	if err != nil {
+		return nil, err
+	}
+	minIdle = max(minIdle, 0)
+
+	now := nowMs()
+	opts := streamClaimArgs{deliveryTime: -1, retryCount: -1}
+
+	var ids []streamID
+	i := 4
+	for ; i < len(args); i++ {
+		id, err := parseStreamID(argToString(args[i]), 0)
+		if err != nil {
+			break
+		}
+		ids = append(ids, id)
+	}
+
+	for ; i < len(args); i++ {
+		opt := strings.ToUpper(argToString(args[i]))
+		hasValue := i+1 < len(args)
+
+		switch {
+		case opt == "FORCE":
+			opts.force = true
+		case opt == "JUSTID":
+			opts.justID = true
+		case opt == "IDLE" && hasValue:
+			idle, err := argToInt64(args[i+1])
+			if err != nil {
+				return nil, err
+			}
+			opts.deliveryTime = now - idle
+			i++
+		case opt == "TIME" && hasValue:
+			opts.deliveryTime, err = argToInt64(args[i+1])
+			if err != nil {
+				return nil, err
+			}
+			i++
+		case opt == "RETRYCOUNT" && hasValue:
+			opts.retryCount, err = argToInt64(args[i+1])
+			if err != nil {
+				return nil, err
+			}
+			i++
+		case opt == "LASTID" && hasValue:
+			opts.lastID, err = parseStreamID(argToString(args[i+1]), 0)
+			if err != nil {
+				return nil, err
+			}
+			opts.hasLastID = true
+			i++
+		default:
+			return nil, ErrSyntax
+		}
+	}
+
+	if opts.hasLastID && opts.lastID.compare(g.lastID) > 0 {
+		g.lastID = opts.lastID
+	}
+
+	c, _ := g.consumer(argToString(args[2]), true)
+	c.seenTime = now
+
+	var elems [][]byte
+	for _, id := range ids {
+		pe, pending := g.pel.find(id.key())
+		e, exists := s.lookup(id)
+
+		if !pending && !(opts.force && exists) {
+			continue
+		}
+
+		if !exists {
+			// entry was deleted from the stream, so there is nothing to claim anymore
+			g.ack(id)
+			continue
+		}
+
+		if pending && now-pe.deliveryTime < minIdle {
+			continue
+		}
+
+		pe = g.deliver(c, id, now, !opts.justID)
+		if opts.deliveryTime >= 0 {
+			pe.deliveryTime = opts.deliveryTime
+		}
+		if opts.retryCount >= 0 {
+			pe.deliveryCount = opts.retryCount
+		}
+		c.activeTime = now
+
+		if opts.justID {
+			elems = append(elems, streamIDResponse(id))
+		} else {
+			elems = append(elems, streamEntryResponse(e))
+		}
+	}
+
+	if len(elems) > 0 {
+		signalModifiedKey(argToString(args[0]), notifyStream, "xclaim")
+	}
+
+	return arrayResponse(elems...), nil
+}
+
+// XAUTOCLAIM key group consumer min-idle-time start [COUNT count] [JUSTID]
+func xautoclaimHandler(args []*KvsValue) ([]byte, error) {
+	s, g, err := lookupStreamGroup(argToString(args[0]), argToString(args[1]))
+	if err != nil {
+		return nil, err
+	}
+
+	minIdle, err := argToInt64(args[3])
+	if err != nil {
+		return nil, err
+	}
+	minIdle = max(minIdle, 0)
+
+	start, _, err := parseStreamRangeID(argToString(args[4]), true)
+	if err != nil {
+		return nil, err
+	}
+
+	count := int64(streamDefaultAutoclaimCount)
+	justID := false
+
+	for i := 5; i < len(args); i++ {
+		opt := strings.ToUpper(argToString(args[i]))
+		switch {
+		case opt == "COUNT" && i+1 < len(args):
+			count, err = argToInt64(args[i+1])
+			if err != nil || count < 1 || count > math.MaxInt64/10 {
+				return nil, ErrNotInteger
+			}
+			i++
+		case opt == "JUSTID":
+			justID = true
+		default:
+			return nil, ErrSyntax
+		}
+	}
+
+	now := nowMs()
+	c, _ := g.consumer(argToString(args[2]), true)
+	c.seenTime = now
+
+	var claimed, deleted [][]byte
+	attempts := count * 10
+	cursor := minStreamID
+
+	key, pe, found := g.pel.ceiling(start.key(), true)
+	for found && attempts > 0 && int64(len(claimed)) < count {
+		attempts--
+		nextKey, nextPe, nextFound := g.pel.ceiling(key, false)
+
+		if now-pe.deliveryTime >= minIdle {
+			if e, ok := s.lookup(pe.id); !ok {
+				deleted = append(deleted, streamIDResponse(pe.id))
+				g.ack(pe.id)
+			} else {
+				g.deliver(c, pe.id, now, !justID)
+				c.activeTime = now
+
+				if justID {
+					claimed = append(claimed, streamIDResponse(pe.id))
+				} else {
+					claimed = append(claimed, streamEntryResponse(e))
+				}
+			}
+		}
+
+		key, pe, found = nextKey, nextPe, nextFound
+	}
+
+	if found {
+		cursor = pe.id
+	}
+
+	if len(claimed)+len(deleted) > 0 {
+		signalModifiedKey(argToString(args[0]), notifyStream, "xautoclaim")
+	}
+
+	return arrayResponse(streamIDResponse(cursor), arrayResponse(claimed...), arrayResponse(deleted...)), nil
+}
+
+// XGROUP <CREATE | SETID | DESTROY | CREATECONSUMER | DELCONSUMER> key group ...
+func xgroupHandler(args []*KvsValue) ([]byte, error) {
+	sub := strings.ToUpper(argToString(args[0]))
+	if len(args) < 3 {
+		return nil, wrongArgsCountErr("XGROUP|" + sub)
+	}
+
+	key := argToString(args[1])
+	groupName := argToString(args[2])
+
+	switch sub {
+	case "CREATE":
+		return xgroupCreate(key, groupName, args[3:])
+	case "SETID":
+		return xgroupSetID(key, groupName, args[3:])
+	}
+
+	s, err := lookupStream(key, false)
+	if err != nil {
+		return nil, err
+	}
+
+	if s == nil {
+		return nil, ErrStreamKeyRequired
+	}
+
+	switch sub {
+	case "DESTROY":
+		if len(args) != 3 {
+			return nil, wrongArgsCountErr("XGROUP|DESTROY")
+		}
+
+		if !s.groups.remove([]byte(groupName)) {
+			return intResponse(0), nil
+		}
+
+		// clients blocked in XREADGROUP on this group should get an error now
+		signalKeyReady(key)
+		signalModifiedKey(key, notifyStream, "xgroup-destroy")
+		return intResponse(1), nil
+	case "CREATECONSUMER", "DELCONSUMER":
+		if len(args) != 4 {
+			return nil, wrongArgsCountErr("XGROUP|" + sub)
+		}
+
+		g, ok := s.group(groupName)
+		if !ok {
+			return nil, ErrStreamNoGroup
+		}
+
+		consumerName := argToString(args[3])
+		if sub == "DELCONSUMER" {
+			if c, _ := g.consumer(consumerName, false); c != nil {
+				signalModifiedKey(key, notifyStream, "xgroup-delconsumer")
+			}
+			return intResponse(g.deleteConsumer(consumerName)), nil
+		}
+
+		if _, created := g.consumer(consumerName, true); created {
+			signalModifiedKey(key, notifyStream, "xgroup-createconsumer")
+			return intResponse(1), nil
+		}
+		return intResponse(0), nil
+	}
+
+	return nil, ErrUnknownSubcommand
+}
+
+func parseGroupStartID(s *stream, arg string) (streamID, error) {
+	if arg == "$" {
+		if s == nil {
+			return minStreamID, nil
+		}
+		return s.lastID, nil
+	}
+
+	return parseStreamID(arg, 0)
+}
+
+// parses [MKSTREAM] [ENTRIESREAD entries-read]. entriesRead is -2 if option wasn't given
+func parseGroupOpts(opts []*KvsValue, allowMkStream bool) (mkStream bool, entriesRead int64, err error) {
+	entriesRead = -2
+
+	for i := 0; i < len(opts); i++ {
+		opt := strings.ToUpper(argToString(opts[i]))
+		switch {
+		case opt == "MKSTREAM" && allowMkStream:
+			mkStream = true
+		case opt == "ENTRIESREAD" && i+1 < len(opts):
+			entriesRead, err = argToInt64(opts[i+1])
+			if err != nil {
+				return false, 0, err
+			}
+			if entriesRead < 0 && entriesRead != -1 {
+				return false, 0, ErrNotInteger
+			}
+			i++
+		default:
+			return false, 0, ErrSyntax
+		}
+	}
+
+	return mkStream, entriesRead, nil
+}
+
+// XGROUP CREATE key group <id | $> [MKSTREAM] [ENTRIESREAD entries-read]
+func xgroupCreate(key, groupName string, rest []*KvsValue) ([]byte, error) {
+	if len(rest) < 1 {
+		return nil, wrongArgsCountErr("XGROUP|CREATE")
+	}
+
+	mkStream, entriesRead, err := parseGroupOpts(rest[1:], true)
+	if err != nil {
+		return nil, err
+	}
+
+	s, err := lookupStream(key, false)
+	if err != nil {
+		return nil, err
+	}
+
+	id, err := parseGroupStartID(s, argToString(rest[0]))
+	if err != nil {
+		return nil, err
+	}
+
+	if s == nil {
+		if !mkStream {
+			return nil, ErrStreamKeyRequired
+		}
+		s, _ = lookupStream(key, true)
+	}
+
+	if entriesRead == -2 {
+		entriesRead = s.entriesReadFor(id)
+	}
+
+	if _, ok := s.createGroup(groupName, id, entriesRead); !ok {
+		return nil, ErrStreamBusyGroup
+	}
+	signalModifiedKey(key, notifyStream, "xgroup-create")
+
+	return []byte(OkResponse), nil
+}
+
+// XGROUP SETID key group <id | $> [ENTRIESREAD entries-read]
+func xgroupSetID(key, groupName string, rest []*KvsValue) ([]byte, error) {
+	if len(rest) < 1 {
+		return nil, wrongArgsCountErr("XGROUP|SETID")
+	}
+
+	_, entriesRead, err := parseGroupOpts(rest[1:], false)
+	if err != nil {
+		return nil, err
+	}
+
+	s, err := lookupStream(key, false)
+	if err != nil {
+		return nil, err
+	}
+
+	if s == nil {
+		return nil, ErrStreamKeyRequired
+	}
+
+	g, ok := s.group(groupName)
+	if !ok {
+		return nil, ErrStreamNoGroup
+	}
+
+	id, err := parseGroupStartID(s, argToString(rest[0]))
+	if err != nil {
+		return nil, err
+	}
+
+	if entriesRead == -2 {
+		entriesRead = s.entriesReadFor(id)
+	}
+
+	g.lastID = id
+	g.entriesRead = entriesRead
+	signalModifiedKey(key, notifyStream, "xgroup-setid")
+
+	return []byte(OkResponse), nil
+}
+
+// XINFO <STREAM key [FULL [COUNT count]] | GROUPS key | CONSUMERS key group>
+func xinfoHandler(args []*KvsValue) ([]byte, error) {
+	sub := strings.ToUpper(argToString(args[0]))
+
+	var wantArgs int
+	switch sub {
+	case "STREAM", "GROUPS":
+		wantArgs = 2
+	case "CONSUMERS":
+		wantArgs = 3
+	default:
+		return nil, ErrUnknownSubcommand
+	}
+
+	if len(args) < wantArgs || (sub != "STREAM" && len(args) != wantArgs) {
+		return nil, wrongArgsCountErr("XINFO|" + sub)
+	}
+
+	s, err := lookupStream(argToString(args[1]), false)
+	if err != nil {
+		return nil, err
+	}
+
+	if s == nil {
+		return nil, ErrStreamNoSuchKey
+	}
+
+	switch sub {
+	case "STREAM":
+		return xinfoStream(s, args[2:])
+	case "GROUPS":
+		var groups [][]byte
+		s.groups.walk(false, func(_ []byte, g *streamGroup) bool {
+			groups = append(groups, xinfoGroup(s, g))
+			return true
+		})
+		return arrayResponse(groups...), nil
+	}
+
+	g, ok := s.group(argToString(args[2]))
+	if !ok {
+		return nil, ErrStreamNoGroup
+	}
+
+	now := nowMs()
+	var consumers [][]byte
+	g.consumers.walk(false, func(_ []byte, c *streamConsumer) bool {
+		inactive := int64(-1)
+		if c.activeTime >= 0 {
+			inactive = now - c.activeTime
+		}
+
+		consumers = append(consumers, arrayResponse(
+			bulkStrResponse([]byte("name")), bulkStrResponse([]byte(c.name)),
+			bulkStrResponse([]byte("pending")), intResponse(int64(c.pel.len())),
+			bulkStrResponse([]byte("idle")), intResponse(now-c.seenTime),
+			bulkStrResponse([]byte("inactive")), intResponse(inactive),
+		))
+		return true
+	})
+
+	return arrayResponse(consumers...), nil
+}
+
+func nullableIntResponse(n int64) []byte {
+	if n < 0 {
+		return []byte(NullResponse)
+	}
+
+	return intResponse(n)
+}
+
+func xinfoGroup(s *stream, g *streamGroup) []byte {
+	return arrayResponse(
+		bulkStrResponse([]byte("name")), bulkStrResponse([]byte(g.name)),
+		bulkStrResponse([]byte("consumers")), intResponse(int64(g.consumers.len())),
+		bulkStrResponse([]byte("pending")), intResponse(int64(g.pel.len())),
+		bulkStrResponse([]byte("last-delivered-id")), streamIDResponse(g.lastID),
+		bulkStrResponse([]byte("entries-read")), nullableIntResponse(g.entriesRead),
+		bulkStrResponse([]byte("lag")), nullableIntResponse(s.lag(g)),
+	)
+}
+
+func optionalEntryResponse(e streamEntry, ok bool) []byte {
+	if !ok {
+		return []byte(NullResponse)
+	}
+
+	return streamEntryResponse(e)
+}
+
+func xinfoStream(s *stream, opts []*KvsValue) ([]byte, error) {
+	full := false
+	count := int64(streamDefaultInfoFullCount)
+
+	if len(opts) > 0 {
+		if strings.ToUpper(argToString(opts[0])) != "FULL" {
+			return nil, ErrSyntax
+		}
+		full = true
+
+		switch len(opts) {
+		case 1:
+		case 3:
+			if strings.ToUpper(argToString(opts[1])) != "COUNT" {
+				return nil, ErrSyntax
+			}
+
+			var err error
+			count, err = argToInt64(opts[2])
+			if err != nil {
+				return nil, err
+			}
+		default:
+			return nil, ErrSyntax
+		}
+	}
+
+	firstID := minStreamID
+	first, hasFirst := s.firstEntry()
+	if hasFirst {
+		firstID = first.id
+	}
+
+	elems := [][]byte{
+		bulkStrResponse([]byte("length")), intResponse(s.length),
+		bulkStrResponse([]byte("radix-tree-keys")), intResponse(int64(s.blocks.len())),
+		bulkStrResponse([]byte("last-generated-id")), streamIDResponse(s.lastID),
+		bulkStrResponse([]byte("max-deleted-entry-id")), streamIDResponse(s.maxDeletedID),
+		bulkStrResponse([]byte("entries-added")), intResponse(s.entriesAdded),
+		bulkStrResponse([]byte("recorded-first-entry-id")), streamIDResponse(firstID),
+	}
+
+	if !full {
+		last, hasLast := s.lastEntry()
+		elems = append(elems,
+			bulkStrResponse([]byte("groups")), intResponse(int64(s.groups.len())),
+			bulkStrResponse([]byte("first-entry")), optionalEntryResponse(first, hasFirst),
+			bulkStrResponse([]byte("last-entry")), optionalEntryResponse(last, hasLast),
+		)
+
+		return arrayResponse(elems...), nil
+	}
+
+	elems = append(elems,
+		bulkStrResponse([]byte("entries")), streamEntriesResponse(s.rangeEntries(minStreamID, maxStreamID, count, false)),
+		bulkStrResponse([]byte("groups")), xinfoFullGroups(s, count),
+	)
+
+	return arrayResponse(elems...), nil
+}
+
+func pendingEntriesResponse(pel *radixTree[*streamPendingEntry], count int64, withConsumer bool) []byte {
+	var elems [][]byte
+
+	pel.walk(false, func(_ []byte, pe *streamPendingEntry) bool {
+		entry := [][]byte{streamIDResponse(pe.id)}
+		if withConsumer {
+			entry = append(entry, bulkStrResponse([]byte(pe.consumer.name)))
+		}
+		entry = append(entry, intResponse(pe.deliveryTime), intResponse(pe.deliveryCount))
+
+		elems = append(elems, arrayResponse(entry...))
+		return count <= 0 || int64(len(elems)) < count
+	})
+
+	return arrayResponse(elems...)
+}
+
+func xinfoFullGroups(s *stream, count int64) []byte {
+	var groups [][]byte
+
+	s.groups.walk(false, func(_ []byte, g *streamGroup) bool {
+		var consumers [][]byte
+		g.consumers.walk(false, func(_ []byte, c *streamConsumer) bool {
+			consumers = append(consumers, arrayResponse(
+				bulkStrResponse([]byte("name")), bulkStrResponse([]byte(c.name)),
+				bulkStrResponse([]byte("seen-time")), intResponse(c.seenTime),
+				bulkStrResponse([]byte("active-time")), intResponse(c.activeTime),
+				bulkStrResponse([]byte("pel-count")), intResponse(int64(c.pel.len())),
+				bulkStrResponse([]byte("pending")), pendingEntriesResponse(c.pel, count, false),
+			))
+			return true
+		})
+
+		groups = append(groups, arrayResponse(
+			bulkStrResponse([]byte("name")), bulkStrResponse([]byte(g.name)),
+			bulkStrResponse([]byte("last-delivered-id")), streamIDResponse(g.lastID),
+			bulkStrResponse([]byte("entries-read")), nullableIntResponse(g.entriesRead),
+			bulkStrResponse([]byte("lag")), nullableIntResponse(s.lag(g)),
+			bulkStrResponse([]byte("pel-count")), intResponse(int64(g.pel.len())),
+			bulkStrResponse([]byte("pending")), pendingEntriesResponse(g.pel, count, true),
+			bulkStrResponse([]byte("consumers")), arrayResponse(consumers...),
+		))
+		return true
+	})
+
+	return arrayResponse(groups...)
+}
@@ -0,0 +1,206 @@
+package main
+
+import (
+	"fmt"
+	"slices"
+	"testing"
+)
+
+// stream with ids 1-0, 2-0, ..., n-0 and entry i holding field "f" with value "v<i>"
+func initMockStream(n int) *stream {
+	s := newStream()
+	for i := 1; i <= n; i++ {
+		s.add(streamID{uint64(i), 0}, [][]byte{[]byte("f"), fmt.Appendf(nil, "v%d", i)})
+	}
+
+	return s
+}
+
+func entryIDs(entries []streamEntry) []uint64 {
+	res := make([]uint64, len(entries))
+	for i, e := range entries {
+		res[i] = e.id.ms
+	}
+
+	return res
+}
+
+// ================================ nextID ========================================
+func TestStreamNextIDExplicit(t *testing.T) {
+	s := initMockStream(3)
+
+	res, err := s.nextID("5-1")
+	if res != (streamID{5, 1}) || err != nil {
+		t.Errorf("nextID(5-1) = %v, expected: 5-1, err: %v", res, err)
+	}
+
+	_, err = s.nextID("3-0")
+	if err != ErrStreamIDTooSmall {
+		t.Errorf("nextID(3-0) errors with %v, expected: %v", err, ErrStreamIDTooSmall)
+	}
+}
+
+func TestStreamNextIDPartial(t *testing.T) {
+	s := initMockStream(3)
+
+	res, err := s.nextID("3-*")
+	if res != (streamID{3, 1}) || err != nil {
+		t.Errorf("nextID(3-*) = %v, expected: 3-1, err: %v", res, err)
+	}
+}
+
+func TestStreamNextIDZero(t *testing.T) {
+	s := newStream()
+
+	_, err := s.nextID("0-0")
+	if err != ErrStreamIDZero {
+		t.Errorf("nextID(0-0) errors with %v, expected: %v", err, ErrStreamIDZero)
+	}
+}
+
+// ================================ blocks ========================================
+func TestStreamEntriesSurviveBlockEncoding(t *testing.T) {
+	s := newStream()
+	s.add(streamID{1, 0}, [][]byte{[]byte("a"), []byte("1"), []byte("b"), []byte("2")})
+	s.add(streamID{1, 5}, [][]byte{[]byte("a"), []byte("3"), []byte("b"), []byte("4")})
+	s.add(streamID{7, 0}, [][]byte{[]byte("other"), []byte("5")})
+
+	entries := s.rangeEntries(minStreamID, maxStreamID, 0, false)
+	if len(entries) != 3 {
+		t.Fatalf("rangeEntries() returned %v entries, expected: %v", len(entries), 3)
+	}
+
+	if entries[1].id != (streamID{1, 5}) || string(entries[1].fields[3]) != "4" {
+		t.Errorf("second entry = %v %q, expected: 1-5 with b=4", entries[1].id, entries[1].fields)
+	}
+
+	if entries[2].id != (streamID{7, 0}) || string(entries[2].fields[0]) != "other" {
+		t.Errorf("third entry = %v %q, expected: 7-0 with other=5", entries[2].id, entries[2].fields)
+	}
+}
+
+func TestStreamSplitsIntoBlocks(t *testing.T) {
+	s := initMockStream(streamBlockMaxEntries*2 + 1)
+
+	if s.blocks.len() != 3 {
+		t.Errorf("blocks count = %v, expected: %v", s.blocks.len(), 3)
+	}
+}
+
+// ================================ range ========================================
+func TestStreamRangeAcrossBlocks(t *testing.T) {
+	s := initMockStream(250)
+
+	res := entryIDs(s.rangeEntries(streamID{98, 0}, streamID{102, 0}, 0, false))
+	expected := []uint64{98, 99, 100, 101, 102}
+
+	if !slices.Equal(res, expected) {
+		t.Errorf("rangeEntries(98, 102) = %v, expected: %v", res, expected)
+	}
+}
+
+func TestStreamRangeReverseWithCount(t *testing.T) {
+	s := initMockStream(250)
+
+	res := entryIDs(s.rangeEntries(minStreamID, streamID{201, 0}, 3, true))
+	expected := []uint64{201, 200, 199}
+
+	if !slices.Equal(res, expected) {
+		t.Errorf("rangeEntries(-, 201, COUNT 3, reverse) = %v, expected: %v", res, expected)
+	}
+}
+
+// ================================ delete ========================================
+func TestStreamDelete(t *testing.T) {
+	s := initMockStream(5)
+
+	if !s.delete(streamID{3, 0}) || s.delete(streamID{3, 0}) {
+		t.Errorf("delete(3-0) twice didn't return true, false")
+	}
+
+	res := entryIDs(s.rangeEntries(minStreamID, maxStreamID, 0, false))
+	expected := []uint64{1, 2, 4, 5}
+
+	if !slices.Equal(res, expected) || s.length != 4 || s.maxDeletedID != (streamID{3, 0}) {
+		t.Errorf("entries after delete(3-0) = %v (length %v), expected: %v", res, s.length, expected)
+	}
+}
+
+func TestStreamDeleteDropsEmptyBlock(t *testing.T) {
+	s := initMockStream(streamBlockMaxEntries + 1)
+
+	s.delete(streamID{uint64(streamBlockMaxEntries + 1), 0})
+
+	if s.blocks.len() != 1 {
+		t.Errorf("blocks count = %v, expected: %v", s.blocks.len(), 1)
+	}
+}
+
+// ================================ trim ========================================
+func TestStreamTrimMaxLenExact(t *testing.T) {
+	s := initMockStream(250)
+
+	removed := s.trimMaxLen(10, false, 0)
+	first, _ := s.firstEntry()
+
+	if removed != 240 || s.length != 10 || first.id.ms != 241 {
+		t.Errorf("trimMaxLen(10) removed %v, length %v, first %v, expected: 240, 10, 241", removed, s.length, first.id)
+	}
+}
+
+func TestStreamTrimMaxLenApprox(t *testing.T) {
+	s := initMockStream(250)
+
+	removed := s.trimMaxLen(10, true, 0)
+
+	if removed != 200 || s.length != 50 {
+		t.Errorf("trimMaxLen(~10) removed %v, length %v, expected: 200, 50", removed, s.length)
+	}
+}
+
+func TestStreamTrimMinID(t *testing.T) {
+	s := initMockStream(250)
+
+	removed := s.trimMinID(streamID{120, 0}, false, 0)
+	first, _ := s.firstEntry()
+
+	if removed != 119 || first.id.ms != 120 {
+		t.Errorf("trimMinID(120) removed %v, first %v, expected: 119, 120", removed, first.id)
+	}
+}
+
+// ================================ groups ========================================
+func TestStreamGroupDeliverAndAck(t *testing.T) {
+	s := initMockStream(3)
+	g, _ := s.createGroup("g", minStreamID, 0)
+	alice, _ := g.consumer("alice", true)
+	bob, _ := g.consumer("bob", true)
+
+	g.deliver(alice, streamID{1, 0}, 0, true)
+	g.deliver(bob, streamID{1, 0}, 0, true)
+
+	if alice.pel.len() != 0 || bob.pel.len() != 1 || g.pel.len() != 1 {
+		t.Errorf("after redelivery pel sizes alice %v, bob %v, group %v, expected: 0, 1, 1", alice.pel.len(), bob.pel.len(), g.pel.len())
+	}
+
+	pe, _ := g.pel.find(streamID{1, 0}.key())
+	if pe.deliveryCount != 2 {
+		t.Errorf("delivery count = %v, expected: %v", pe.deliveryCount, 2)
+	}
+
+	if !g.ack(streamID{1, 0}) || bob.pel.len() != 0 || g.pel.len() != 0 {
+		t.Errorf("ack(1-0) didn't clear pending entry")
+	}
+}
+
+func TestStreamGroupLag(t *testing.T) {
+	s := initMockStream(5)
+	g, _ := s.createGroup("g", minStreamID, 0)
+
+	s.advanceGroup(g, streamID{1, 0})
+	s.advanceGroup(g, streamID{2, 0})
+
+	if res := s.lag(g); res != 3 {
+		t.Errorf("lag() = %v, expected: %v", res, 3)
+	}
+}
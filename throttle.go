@@ -0,0 +1,52 @@
+package main
+
+import "time"
+
+// GCRA (generic cell rate algorithm) tracks only the theoretical arrival time (TAT) of the next request:
+// requests are emitted once per emission interval, and a request is allowed when it doesn't arrive earlier
+// than TAT minus the burst tolerance. The same semantics as in redis-cell are used, all times are in ns
+type gcraResult struct {
+	limited    bool
+	limit      int64
+	remaining  int64
+	retryAfter time.Duration // -1 when request is allowed
+	resetAfter time.Duration // time until the limit is fully restored
+	tat        int64         // new TAT to store, valid when request is allowed
+}
+
+// gcra checks request of quantity cells arriving at now against stored tat (0 if there is no state)
+// for rate of count cells per period with maxBurst cells allowed above it
+func gcra(tat, now, maxBurst, count int64, period time.Duration, quantity int64) gcraResult {
+	emission := max(int64(period)/count, 1)
+	tolerance := emission * (maxBurst + 1)
+	increment := emission * quantity
+
+	if tat == 0 {
+		tat = now
+	}
+
+	res := gcraResult{limit: maxBurst + 1, retryAfter: -1}
+
+	newTat := max(tat, now) + increment
+	diff := now - (newTat - tolerance)
+
+	var ttl int64
+	if diff < 0 {
+		// requests larger than the whole burst can never succeed, so there is nothing to wait for
+		if increment <= tolerance {
+			res.retryAfter = time.Duration(-diff)
+		}
+		res.limited = true
+		ttl = tat - now
+	} else {
+		res.tat = newTat
+		ttl = newTat - now
+	}
+
+	if next := tolerance - ttl; next > -emission {
+		res.remaining = next / emission
+	}
+	res.resetAfter = time.Duration(ttl)
+
+	return res
+}
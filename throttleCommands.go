@@ -0,0 +1,66 @@
+package main
+
+import (
+	"math"
+	"strconv"
+	"time"
+)
+
+// THROTTLE key max_burst count_per_period period [quantity]
+// Replies with [limited (0 allowed, 1 limited), limit, remaining, retry after seconds, reset after seconds].
+// State is stored at key as TAT in ns and expires when the limit is fully restored
+func throttleHandler(args []*KvsValue) ([]byte, error) {
+	if len(args) > 5 {
+		return nil, wrongArgsCountErr("THROTTLE")
+	}
+
+	key := argToString(args[0])
+
+	params := []int64{0, 0, 0, 1}
+	for i, arg := range args[1:] {
+		v, err := argToInt64(arg)
+		if err != nil {
+			return nil, err
+		}
+		params[i] = v
+	}
+
+	maxBurst, count, period, quantity := params[0], params[1], params[2], params[3]
+	if maxBurst < 0 || count < 1 || period < 1 || quantity < 0 || period > math.MaxInt64/int64(time.Second) {
+		return nil, ErrThrottleInvalidArgs
+	}
+
+	var tat int64
+	if val, ok := lookupKey(key); ok {
+		if val.dtype != BulkStrSymbol {
+			return nil, ErrWrongType
+		}
+
+		var err error
+		if tat, err = strconv.ParseInt(string(val.value), 10, 64); err != nil {
+			return nil, ErrThrottleInvalidState
+		}
+	}
+
+	now := clockNow()
+	res := gcra(tat, now.UnixNano(), maxBurst, count, time.Duration(period)*time.Second, quantity)
+
+	if !res.limited && res.resetAfter > 0 {
+		val := &KvsValue{dtype: BulkStrSymbol, value: []byte(strconv.FormatInt(res.tat, 10))}
+		setExpire(key, val, now.Add(res.resetAfter).UnixMilli()+1)
+		signalModifiedKey(key, notifyModule, "throttle")
+	}
+
+	retryAfter := int64(-1)
+	if res.retryAfter >= 0 {
+		retryAfter = int64(math.Ceil(res.retryAfter.Seconds()))
+	}
+
+	return arrayResponse(
+		boolIntResponse(res.limited),
+		intResponse(res.limit),
+		intResponse(res.remaining),
+		intResponse(retryAfter),
+		intResponse(int64(math.Ceil(res.resetAfter.Seconds()))),
+	), nil
+}
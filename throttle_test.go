@@ -0,0 +1,62 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestGCRABurst(t *testing.T) {
+	// 10 requests per second with burst of 4, so 5 requests pass at once
+	var tat int64
+	now := time.Now().UnixNano()
+
+	for i := range 5 {
+		res := gcra(tat, now, 4, 10, time.Second, 1)
+		if res.limited {
+			t.Fatalf("request %d limited, expected to be allowed", i)
+		}
+		if res.remaining != int64(4-i) {
+			t.Errorf("request %d: remaining = %d, expected: %d", i, res.remaining, 4-i)
+		}
+		tat = res.tat
+	}
+
+	res := gcra(tat, now, 4, 10, time.Second, 1)
+	if !res.limited {
+		t.Fatalf("request above burst allowed, expected to be limited")
+	}
+	if res.retryAfter != 100*time.Millisecond {
+		t.Errorf("retryAfter = %v, expected: 100ms", res.retryAfter)
+	}
+	if res.resetAfter != 500*time.Millisecond {
+		t.Errorf("resetAfter = %v, expected: 500ms", res.resetAfter)
+	}
+
+	// one emission interval later one more request passes
+	res = gcra(tat, now+int64(100*time.Millisecond), 4, 10, time.Second, 1)
+	if res.limited || res.remaining != 0 {
+		t.Errorf("request after emission interval: limited = %t, remaining = %d, expected allowed with 0 remaining",
+			res.limited, res.remaining)
+	}
+}
+
+func TestGCRAQuantity(t *testing.T) {
+	now := time.Now().UnixNano()
+
+	res := gcra(0, now, 4, 10, time.Second, 5)
+	if res.limited || res.remaining != 0 {
+		t.Errorf("request of the whole burst: limited = %t, remaining = %d, expected allowed with 0 remaining",
+			res.limited, res.remaining)
+	}
+
+	res = gcra(0, now, 4, 10, time.Second, 6)
+	if !res.limited || res.retryAfter != -1 {
+		t.Errorf("request above burst: limited = %t, retryAfter = %v, expected limited with -1", res.limited, res.retryAfter)
+	}
+
+	// zero quantity only checks the state
+	res = gcra(0, now, 4, 10, time.Second, 0)
+	if res.limited || res.remaining != 5 {
+		t.Errorf("request of 0: limited = %t, remaining = %d, expected allowed with 5 remaining", res.limited, res.remaining)
+	}
+}
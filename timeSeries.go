@@ -0,0 +1,525 @@
+package main
+
+import (
+	"cmp"
+	"math"
+	"math/bits"
+	"slices"
+	"sort"
+)
+
+// Samples of a time series are kept in Gorilla compressed chunks: timestamps are stored as delta of deltas
+// and values as XOR with the previous value, both with variable length encoding. Regular metrics mostly
+// need a couple of bits per timestamp and a dozen per value. Chunks are append-only, so out of order
+// samples make the affected chunk to be decoded and encoded again
+const (
+	tsChunkSize = 4096 // bytes of compressed data after which a new chunk is started
+
+	tsPolicyBlock = "BLOCK"
+	tsPolicyFirst = "FIRST"
+	tsPolicyLast  = "LAST"
+	tsPolicyMin   = "MIN"
+	tsPolicyMax   = "MAX"
+	tsPolicySum   = "SUM"
+)
+
+type tsSample struct {
+	ts    int64
+	value float64
+}
+
+// ================================ bit stream ========================================
+
+type bitWriter struct {
+	data []byte
+	n    uint64 // number of written bits
+}
+
+func (w *bitWriter) writeBit(bit bool) {
+	if w.n%8 == 0 {
+		w.data = append(w.data, 0)
+	}
+	if bit {
+		w.data[w.n/8] |= 0x80 >> (w.n % 8)
+	}
+	w.n++
+}
+
+// writeBits writes lower nbits of v, most significant bit first
+func (w *bitWriter) writeBits(v uint64, nbits int) {
+	for i := nbits - 1; i >= 0; i-- {
+		w.writeBit(v&(1<<i) != 0)
+	}
+}
+
+type bitReader struct {
+	data []byte
+	pos  uint64
+}
+
+func (r *bitReader) readBit() bool {
+	bit := r.data[r.pos/8]&(0x80>>(r.pos%8)) != 0
+	r.pos++
+
+	return bit
+}
+
+func (r *bitReader) readBits(nbits int) uint64 {
+	var v uint64
+	for range nbits {
+		v <<= 1
+		if r.readBit() {
+			v |= 1
+		}
+	}
+
+	return v
+}
+
+// ================================ chunks ========================================
+
+type tsChunk struct {
+	w     bitWriter
+	count int
+	first int64
+	last  int64
+
+	// state of encoder needed to append next sample
+	lastDelta int64
+	lastValue uint64
+	hasWindow bool
+	leading   int
+	trailing  int
+}
+
+// delta of deltas buckets: control bits and size of the value that follows them
+var tsDodBuckets = []struct {
+	ctrl, ctrlBits uint64
+	bits           int
+}{
+	{0b10, 2, 7},
+	{0b110, 3, 9},
+	{0b1110, 4, 12},
+	{0b1111, 4, 64},
+}
+
+func (c *tsChunk) append(s tsSample) {
+	c.count++
+	v := math.Float64bits(s.value)
+
+	if c.count == 1 {
+		c.w.writeBits(uint64(s.ts), 64)
+		c.w.writeBits(v, 64)
+		c.first, c.last, c.lastValue = s.ts, s.ts, v
+		return
+	}
+
+	delta := s.ts - c.last
+	dod := delta - c.lastDelta
+	c.last, c.lastDelta = s.ts, delta
+
+	if dod == 0 {
+		c.w.writeBit(false)
+	} else {
+		for _, b := range tsDodBuckets {
+			if b.bits == 64 || (dod >= -(1<<(b.bits-1)) && dod < 1<<(b.bits-1)) {
+				c.w.writeBits(b.ctrl, int(b.ctrlBits))
+				c.w.writeBits(uint64(dod), b.bits)
+				break
+			}
+		}
+	}
+
+	xor := v ^ c.lastValue
+	c.lastValue = v
+
+	if xor == 0 {
+		c.w.writeBit(false)
+		return
+	}
+	c.w.writeBit(true)
+
+	leading := min(bits.LeadingZeros64(xor), 31)
+	trailing := bits.TrailingZeros64(xor)
+
+	// meaningful bits fit into the window of the previous value, so the window is not repeated
+	if c.hasWindow && leading >= c.leading && trailing >= c.trailing {
+		c.w.writeBit(false)
+		c.w.writeBits(xor>>c.trailing, 64-c.leading-c.trailing)
+		return
+	}
+
+	c.hasWindow, c.leading, c.trailing = true, leading, trailing
+	sigBits := 64 - leading - trailing
+
+	c.w.writeBit(true)
+	c.w.writeBits(uint64(leading), 5)
+	// 64 meaningful bits don't fit into 6 bits and are stored as 0
+	c.w.writeBits(uint64(sigBits&63), 6)
+	c.w.writeBits(xor>>trailing, sigBits)
+}
+
+func signExtend(v uint64, nbits int) int64 {
+	if nbits == 64 {
+		return int64(v)
+	}
+
+	shift := 64 - nbits
+	return int64(v<<shift) >> shift
+}
+
+func (c *tsChunk) samples() []tsSample {
+	res := make([]tsSample, 0, c.count)
+	if c.count == 0 {
+		return res
+	}
+
+	r := bitReader{data: c.w.data}
+	ts := int64(r.readBits(64))
+	v := r.readBits(64)
+	res = append(res, tsSample{ts: ts, value: math.Float64frombits(v)})
+
+	var delta int64
+	leading, trailing := 0, 0
+
+	for len(res) < c.count {
+		if r.readBit() {
+			ctrlBits := 1
+			for ctrlBits < 4 && r.readBit() {
+				ctrlBits++
+			}
+			b := tsDodBuckets[ctrlBits-1]
+			delta += signExtend(r.readBits(b.bits), b.bits)
+		}
+		ts += delta
+
+		if r.readBit() {
+			if r.readBit() {
+				leading = int(r.readBits(5))
+				sigBits := int(r.readBits(6))
+				if sigBits == 0 {
+					sigBits = 64
+				}
+				trailing = 64 - leading - sigBits
+			}
+			v ^= r.readBits(64-leading-trailing) << trailing
+		}
+
+		res = append(res, tsSample{ts: ts, value: math.Float64frombits(v)})
+	}
+
+	return res
+}
+
+func encodeTSChunk(samples []tsSample) *tsChunk {
+	c := &tsChunk{}
+	for _, s := range samples {
+		c.append(s)
+	}
+
+	return c
+}
+
+// ================================ series ========================================
+
+type tsLabel struct {
+	name, value string
+}
+
+type timeSeries struct {
+	chunks          []*tsChunk
+	retention       int64 // ms, 0 keeps samples forever
+	duplicatePolicy string
+	labels          []tsLabel
+	rules           []*tsRule
+	srcKey          string // series this one is compacted from
+}
+
+// tsRule downsamples samples of a series into destKey. Samples of the current bucket are aggregated
+// until a sample of a later bucket arrives
+type tsRule struct {
+	destKey     string
+	aggregation string
+	bucket      int64
+	curStart    int64
+	cur         *tsAggregator
+}
+
+func newTimeSeries(retention int64, duplicatePolicy string, labels []tsLabel) *timeSeries {
+	return &timeSeries{retention: retention, duplicatePolicy: duplicatePolicy, labels: labels}
+}
+
+func (ts *timeSeries) empty() bool {
+	return len(ts.chunks) == 0
+}
+
+func (ts *timeSeries) lastTimestamp() int64 {
+	return ts.chunks[len(ts.chunks)-1].last
+}
+
+func (ts *timeSeries) lastSample() tsSample {
+	c := ts.chunks[len(ts.chunks)-1]
+	return tsSample{ts: c.last, value: math.Float64frombits(c.lastValue)}
+}
+
+// minTimestamp returns the oldest timestamp still kept by retention
+func (ts *timeSeries) minTimestamp() int64 {
+	if ts.retention == 0 || ts.empty() {
+		return math.MinInt64
+	}
+
+	return ts.lastTimestamp() - ts.retention
+}
+
+func (ts *timeSeries) label(name string) (string, bool) {
+	for _, l := range ts.labels {
+		if l.name == name {
+			return l.value, true
+		}
+	}
+
+	return "", false
+}
+
+func tsResolveDuplicate(policy string, old, new float64) (float64, error) {
+	switch policy {
+	case tsPolicyFirst:
+		return old, nil
+	case tsPolicyLast:
+		return new, nil
+	case tsPolicyMin:
+		return min(old, new), nil
+	case tsPolicyMax:
+		return max(old, new), nil
+	case tsPolicySum:
+		return old + new, nil
+	}
+
+	return 0, ErrTSDuplicateBlocked
+}
+
+// add inserts sample resolving duplicate timestamps with policy and returns whether sample was appended
+// after all existing ones
+func (ts *timeSeries) add(s tsSample, policy string) (bool, error) {
+	if ts.empty() {
+		ts.chunks = append(ts.chunks, encodeTSChunk([]tsSample{s}))
+		return true, nil
+	}
+
+	if s.ts < ts.minTimestamp() {
+		return false, ErrTSTooOld
+	}
+
+	last := ts.chunks[len(ts.chunks)-1]
+	if s.ts > last.last {
+		if len(last.w.data) >= tsChunkSize {
+			last = &tsChunk{}
+			ts.chunks = append(ts.chunks, last)
+		}
+		last.append(s)
+		ts.trim()
+
+		return true, nil
+	}
+
+	// the sample goes into the last chunk starting at or before it, the first chunk takes older ones
+	i := max(sort.Search(len(ts.chunks), func(i int) bool { return ts.chunks[i].first > s.ts })-1, 0)
+
+	samples := ts.chunks[i].samples()
+	j, found := slices.BinarySearchFunc(samples, s.ts, func(e tsSample, t int64) int {
+		return cmp.Compare(e.ts, t)
+	})
+
+	if found {
+		v, err := tsResolveDuplicate(policy, samples[j].value, s.value)
+		if err != nil {
+			return false, err
+		}
+		samples[j].value = v
+	} else {
+		samples = slices.Insert(samples, j, s)
+	}
+
+	ts.chunks[i] = encodeTSChunk(samples)
+
+	return false, nil
+}
+
+// trim drops chunks that have only samples outside of retention
+func (ts *timeSeries) trim() {
+	minTs := ts.minTimestamp()
+
+	n := 0
+	for n < len(ts.chunks)-1 && ts.chunks[n].last < minTs {
+		n++
+	}
+
+	ts.chunks = ts.chunks[n:]
+}
+
+// rangeSamples returns samples with timestamps in [from, to] in ascending order
+func (ts *timeSeries) rangeSamples(from, to int64) []tsSample {
+	from = max(from, ts.minTimestamp())
+
+	var res []tsSample
+	for _, c := range ts.chunks {
+		if c.last < from || c.first > to {
+			continue
+		}
+
+		for _, s := range c.samples() {
+			if s.ts >= from && s.ts <= to {
+				res = append(res, s)
+			}
+		}
+	}
+
+	return res
+}
+
+// ================================ aggregation ========================================
+
+var tsAggregations = []string{"avg", "sum", "min", "max", "count", "first", "last", "range"}
+
+type tsAggregator struct {
+	sum, min, max float64
+	first, last   float64
+	count         int64
+}
+
+func (a *tsAggregator) add(v float64) {
+	if a.count == 0 {
+		a.min, a.max, a.first = v, v, v
+	}
+
+	a.sum += v
+	a.min = min(a.min, v)
+	a.max = max(a.max, v)
+	a.last = v
+	a.count++
+}
+
+func (a *tsAggregator) result(aggregation string) float64 {
+	switch aggregation {
+	case "avg":
+		return a.sum / float64(a.count)
+	case "sum":
+		return a.sum
+	case "min":
+		return a.min
+	case "max":
+		return a.max
+	case "count":
+		return float64(a.count)
+	case "first":
+		return a.first
+	case "range":
+		return a.max - a.min
+	}
+
+	return a.last
+}
+
+// tsBucketStart aligns timestamp to bucket borders counted from the epoch
+func tsBucketStart(ts, bucket int64) int64 {
+	start := ts - ts%bucket
+	if ts < 0 && ts%bucket != 0 {
+		start -= bucket
+	}
+
+	return start
+}
+
+// aggregateSamples groups samples into buckets and returns one sample per non-empty bucket
+func aggregateSamples(samples []tsSample, aggregation string, bucket int64) []tsSample {
+	var res []tsSample
+	var agg tsAggregator
+	var start int64
+
+	for _, s := range samples {
+		bs := tsBucketStart(s.ts, bucket)
+		if agg.count > 0 && bs != start {
+			res = append(res, tsSample{ts: start, value: agg.result(aggregation)})
+			agg = tsAggregator{}
+		}
+		start = bs
+		agg.add(s.value)
+	}
+
+	if agg.count > 0 {
+		res = append(res, tsSample{ts: start, value: agg.result(aggregation)})
+	}
+
+	return res
+}
+
+// dump serializes samples of every chunk, chunks are encoded again when restored
+func (ts *timeSeries) dump(b []byte) []byte {
+	b = appendU64(b, uint64(ts.retention))
+	b = appendStr(b, ts.duplicatePolicy)
+	b = appendStr(b, ts.srcKey)
+
+	b = appendU32(b, len(ts.labels))
+	for _, l := range ts.labels {
+		b = appendStr(b, l.name)
+		b = appendStr(b, l.value)
+	}
+
+	b = appendU32(b, len(ts.rules))
+	for _, rule := range ts.rules {
+		b = appendStr(b, rule.destKey)
+		b = appendStr(b, rule.aggregation)
+		b = appendU64(b, uint64(rule.bucket))
+		b = appendU64(b, uint64(rule.curStart))
+
+		var cur tsAggregator
+		if rule.cur != nil {
+			cur = *rule.cur
+		}
+		for _, f := range []float64{cur.sum, cur.min, cur.max, cur.first, cur.last} {
+			b = appendF64(b, f)
+		}
+		b = appendU64(b, uint64(cur.count))
+	}
+
+	b = appendU32(b, len(ts.chunks))
+	for _, c := range ts.chunks {
+		b = appendU32(b, c.count)
+		for _, s := range c.samples() {
+			b = appendU64(b, uint64(s.ts))
+			b = appendF64(b, s.value)
+		}
+	}
+
+	return b
+}
+
+func restoreTimeSeries(r *dumpReader) *timeSeries {
+	ts := &timeSeries{retention: r.i64(), duplicatePolicy: r.str(), srcKey: r.str()}
+
+	for range r.count(8) {
+		ts.labels = append(ts.labels, tsLabel{name: r.str(), value: r.str()})
+	}
+
+	for range r.count(72) {
+		rule := &tsRule{destKey: r.str(), aggregation: r.str(), bucket: r.i64(), curStart: r.i64()}
+		cur := &tsAggregator{sum: r.f64(), min: r.f64(), max: r.f64(), first: r.f64(), last: r.f64(), count: r.i64()}
+		if cur.count > 0 {
+			rule.cur = cur
+		}
+		r.check(rule.bucket > 0)
+		ts.rules = append(ts.rules, rule)
+	}
+
+	for range r.count(4) {
+		n := r.count(16)
+		r.check(n > 0)
+		samples := make([]tsSample, n)
+		for i := range samples {
+			samples[i] = tsSample{ts: r.i64(), value: r.f64()}
+		}
+		ts.chunks = append(ts.chunks, encodeTSChunk(samples))
+	}
+
+	return ts
+}
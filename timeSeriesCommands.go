@@ -0,0 +1,550 @@
+package main
+
+import (
+	"maps"
+	"math"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// lookupTimeSeries returns time series stored at key or nil if key doesn't exist
+func lookupTimeSeries(key string) (*timeSeries, error) {
+	val, ok := lookupKey(key)
+	if !ok {
+		return nil, nil
+	}
+
+	if val.dtype != TimeSeriesDtype {
+		return nil, ErrWrongType
+	}
+
+	return val.object.(*timeSeries), nil
+}
+
+func lookupExistingTimeSeries(key string) (*timeSeries, error) {
+	series, err := lookupTimeSeries(key)
+	if err == nil && series == nil {
+		err = ErrTSKeyNotExist
+	}
+
+	return series, err
+}
+
+func tsSampleResponse(s tsSample) []byte {
+	return arrayResponse(intResponse(s.ts), simpleStrResponse(strconv.FormatFloat(s.value, 'f', -1, 64)))
+}
+
+func tsSamplesResponse(samples []tsSample) []byte {
+	res := make([][]byte, len(samples))
+	for i, s := range samples {
+		res[i] = tsSampleResponse(s)
+	}
+
+	return arrayResponse(res...)
+}
+
+func parseTSTimestamp(arg *KvsValue) (int64, error) {
+	if argToString(arg) == "*" {
+		return nowMs(), nil
+	}
+
+	ts, err := argToInt64(arg)
+	if err != nil || ts < 0 {
+		return 0, ErrTSInvalidTimestamp
+	}
+
+	return ts, nil
+}
+
+func parseTSValue(arg *KvsValue) (float64, error) {
+	v, err := argToFloat64(arg)
+	if err != nil || math.IsNaN(v) {
+		return 0, ErrTSInvalidValue
+	}
+
+	return v, nil
+}
+
+func parseTSPolicy(arg *KvsValue) (string, error) {
+	policy := strings.ToUpper(argToString(arg))
+
+	switch policy {
+	case tsPolicyBlock, tsPolicyFirst, tsPolicyLast, tsPolicyMin, tsPolicyMax, tsPolicySum:
+		return policy, nil
+	}
+
+	return "", ErrTSInvalidPolicy
+}
+
+func parseTSAggregation(aggArg, bucketArg *KvsValue) (string, int64, error) {
+	aggregation := strings.ToLower(argToString(aggArg))
+	if !slices.Contains(tsAggregations, aggregation) {
+		return "", 0, ErrTSInvalidAggregation
+	}
+
+	bucket, err := argToInt64(bucketArg)
+	if err != nil || bucket <= 0 {
+		return "", 0, ErrTSInvalidBucket
+	}
+
+	return aggregation, bucket, nil
+}
+
+type tsOptions struct {
+	retention   int64
+	policy      string
+	onDuplicate string
+	labels      []tsLabel
+}
+
+// parseTSOptions parses [RETENTION ms] [DUPLICATE_POLICY policy] [ON_DUPLICATE policy] [LABELS label value ...].
+// LABELS takes the rest of arguments
+func parseTSOptions(args []*KvsValue, allowOnDuplicate bool) (*tsOptions, error) {
+	opts := &tsOptions{policy: tsPolicyBlock}
+
+	for i := 0; i < len(args); i++ {
+		opt := strings.ToUpper(argToString(args[i]))
+
+		if opt == "LABELS" {
+			rest := args[i+1:]
+			if len(rest) == 0 || len(rest)%2 != 0 {
+				return nil, ErrTSInvalidLabels
+			}
+
+			for j := 0; j < len(rest); j += 2 {
+				opts.labels = append(opts.labels, tsLabel{name: argToString(rest[j]), value: argToString(rest[j+1])})
+			}
+
+			return opts, nil
+		}
+
+		if i+1 >= len(args) {
+			return nil, ErrSyntax
+		}
+		i++
+
+		var err error
+		switch {
+		case opt == "RETENTION":
+			if opts.retention, err = argToInt64(args[i]); err != nil || opts.retention < 0 {
+				return nil, ErrTSInvalidRetention
+			}
+		case opt == "DUPLICATE_POLICY":
+			if opts.policy, err = parseTSPolicy(args[i]); err != nil {
+				return nil, err
+			}
+		case opt == "ON_DUPLICATE" && allowOnDuplicate:
+			if opts.onDuplicate, err = parseTSPolicy(args[i]); err != nil {
+				return nil, err
+			}
+		default:
+			return nil, ErrSyntax
+		}
+	}
+
+	return opts, nil
+}
+
+// tsAddSample adds sample to series and feeds it to compaction rules of the series. Only samples appended
+// after all existing ones are compacted, updates of older samples don't change downsampled series
+func tsAddSample(series *timeSeries, s tsSample, policy string) error {
+	appended, err := series.add(s, policy)
+	if err != nil || !appended {
+		return err
+	}
+
+	for _, rule := range series.rules {
+		start := tsBucketStart(s.ts, rule.bucket)
+
+		if rule.cur != nil && start != rule.curStart {
+			// destination could be deleted since the rule was created, then the bucket is just dropped
+			if dest, err := lookupTimeSeries(rule.destKey); err == nil && dest != nil {
+				_ = tsAddSample(dest, tsSample{ts: rule.curStart, value: rule.cur.result(rule.aggregation)}, tsPolicyLast)
+				signalModifiedKey(rule.destKey, notifyModule, "ts.add:dest")
+			}
+			rule.cur = nil
+		}
+
+		if rule.cur == nil {
+			rule.cur, rule.curStart = &tsAggregator{}, start
+		}
+		rule.cur.add(s.value)
+	}
+
+	return nil
+}
+
+// TS.CREATE key [RETENTION retentionPeriod] [DUPLICATE_POLICY policy] [LABELS label value ...]
+func tsCreateHandler(args []*KvsValue) ([]byte, error) {
+	key := argToString(args[0])
+
+	opts, err := parseTSOptions(args[1:], false)
+	if err != nil {
+		return nil, err
+	}
+
+	if _, ok := kvs.storage.Get(key); ok {
+		return nil, ErrTSKeyExists
+	}
+
+	storeKey(key, &KvsValue{dtype: TimeSeriesDtype, object: newTimeSeries(opts.retention, opts.policy, opts.labels)})
+	signalModifiedKey(key, notifyModule, "ts.create")
+
+	return []byte(OkResponse), nil
+}
+
+func tsAdd(keyArg, tsArg, valueArg *KvsValue, opts *tsOptions) ([]byte, error) {
+	key := argToString(keyArg)
+
+	ts, err := parseTSTimestamp(tsArg)
+	if err != nil {
+		return nil, err
+	}
+
+	v, err := parseTSValue(valueArg)
+	if err != nil {
+		return nil, err
+	}
+
+	series, err := lookupTimeSeries(key)
+	if err != nil {
+		return nil, err
+	}
+
+	if series == nil {
+		series = newTimeSeries(opts.retention, opts.policy, opts.labels)
+		storeKey(key, &KvsValue{dtype: TimeSeriesDtype, object: series})
+	}
+
+	policy := series.duplicatePolicy
+	if opts.onDuplicate != "" {
+		policy = opts.onDuplicate
+	}
+
+	if err := tsAddSample(series, tsSample{ts: ts, value: v}, policy); err != nil {
+		return nil, err
+	}
+	signalModifiedKey(key, notifyModule, "ts.add")
+
+	return intResponse(ts), nil
+}
+
+// TS.ADD key timestamp value [RETENTION retentionPeriod] [DUPLICATE_POLICY policy] [ON_DUPLICATE policy] [LABELS label value ...]
+func tsAddHandler(args []*KvsValue) ([]byte, error) {
+	opts, err := parseTSOptions(args[3:], true)
+	if err != nil {
+		return nil, err
+	}
+
+	return tsAdd(args[0], args[1], args[2], opts)
+}
+
+// TS.MADD key timestamp value [key timestamp value ...]
+func tsMaddHandler(args []*KvsValue) ([]byte, error) {
+	if len(args)%3 != 0 {
+		return nil, wrongArgsCountErr("TS.MADD")
+	}
+
+	opts := &tsOptions{policy: tsPolicyBlock}
+
+	res := make([][]byte, 0, len(args)/3)
+	for i := 0; i < len(args); i += 3 {
+		reply, err := tsAdd(args[i], args[i+1], args[i+2], opts)
+		if err != nil {
+			reply = []byte(err.Error())
+		}
+		res = append(res, reply)
+	}
+
+	return arrayResponse(res...), nil
+}
+
+// TS.GET key
+func tsGetHandler(args []*KvsValue) ([]byte, error) {
+	series, err := lookupExistingTimeSeries(argToString(args[0]))
+	if err != nil {
+		return nil, err
+	}
+
+	if series.empty() {
+		return []byte(EmptyArrayResponse), nil
+	}
+
+	return tsSampleResponse(series.lastSample()), nil
+}
+
+type tsRangeQuery struct {
+	from, to    int64
+	count       int64
+	aggregation string
+	bucket      int64
+	withLabels  bool
+	filters     []tsFilter
+}
+
+func parseTSRangeBound(arg *KvsValue, special string, specialVal int64) (int64, error) {
+	if argToString(arg) == special {
+		return specialVal, nil
+	}
+
+	ts, err := argToInt64(arg)
+	if err != nil {
+		return 0, ErrTSInvalidTimestamp
+	}
+
+	return ts, nil
+}
+
+// parseTSRangeQuery parses fromTimestamp toTimestamp [COUNT count] [AGGREGATION aggregator bucketDuration]
+// and for multi-key commands also [WITHLABELS] FILTER filterExpr...
+func parseTSRangeQuery(args []*KvsValue, multi bool) (*tsRangeQuery, error) {
+	q := &tsRangeQuery{count: -1}
+
+	var err error
+	if q.from, err = parseTSRangeBound(args[0], "-", 0); err != nil {
+		return nil, err
+	}
+	if q.to, err = parseTSRangeBound(args[1], "+", math.MaxInt64); err != nil {
+		return nil, err
+	}
+
+	for i := 2; i < len(args); i++ {
+		switch opt := strings.ToUpper(argToString(args[i])); {
+		case opt == "COUNT" && i+1 < len(args):
+			i++
+			if q.count, err = argToInt64(args[i]); err != nil || q.count < 0 {
+				return nil, ErrNotInteger
+			}
+		case opt == "AGGREGATION" && i+2 < len(args):
+			if q.aggregation, q.bucket, err = parseTSAggregation(args[i+1], args[i+2]); err != nil {
+				return nil, err
+			}
+			i += 2
+		case opt == "WITHLABELS" && multi:
+			q.withLabels = true
+		case opt == "FILTER" && multi:
+			if q.filters, err = parseTSFilters(args[i+1:]); err != nil {
+				return nil, err
+			}
+			return q, nil
+		default:
+			return nil, ErrSyntax
+		}
+	}
+
+	if multi {
+		return nil, ErrSyntax
+	}
+
+	return q, nil
+}
+
+func (q *tsRangeQuery) run(series *timeSeries, reverse bool) []tsSample {
+	samples := series.rangeSamples(q.from, q.to)
+
+	if q.aggregation != "" {
+		samples = aggregateSamples(samples, q.aggregation, q.bucket)
+	}
+
+	if reverse {
+		slices.Reverse(samples)
+	}
+
+	if q.count >= 0 && int64(len(samples)) > q.count {
+		samples = samples[:q.count]
+	}
+
+	return samples
+}
+
+func tsRange(args []*KvsValue, reverse bool) ([]byte, error) {
+	q, err := parseTSRangeQuery(args[1:], false)
+	if err != nil {
+		return nil, err
+	}
+
+	series, err := lookupExistingTimeSeries(argToString(args[0]))
+	if err != nil {
+		return nil, err
+	}
+
+	return tsSamplesResponse(q.run(series, reverse)), nil
+}
+
+// TS.RANGE key fromTimestamp toTimestamp [COUNT count] [AGGREGATION aggregator bucketDuration]
+func tsRangeHandler(args []*KvsValue) ([]byte, error) {
+	return tsRange(args, false)
+}
+
+// TS.REVRANGE key fromTimestamp toTimestamp [COUNT count] [AGGREGATION aggregator bucketDuration]
+func tsRevrangeHandler(args []*KvsValue) ([]byte, error) {
+	return tsRange(args, true)
+}
+
+// tsFilter matches series whose label value is (or with negate is not) one of values.
+// Missing label has empty value, so "label=" matches series without the label
+type tsFilter struct {
+	label  string
+	negate bool
+	values []string
+}
+
+func parseTSFilters(args []*KvsValue) ([]tsFilter, error) {
+	filters := make([]tsFilter, 0, len(args))
+	hasMatcher := false
+
+	for _, arg := range args {
+		expr := argToString(arg)
+
+		i := strings.IndexByte(expr, '=')
+		if i <= 0 {
+			return nil, ErrTSInvalidFilter
+		}
+
+		f := tsFilter{label: expr[:i]}
+		if strings.HasSuffix(f.label, "!") {
+			f.label, f.negate = f.label[:len(f.label)-1], true
+		}
+
+		value := expr[i+1:]
+		if strings.HasPrefix(value, "(") && strings.HasSuffix(value, ")") {
+			f.values = strings.Split(value[1:len(value)-1], ",")
+		} else {
+			f.values = []string{value}
+		}
+
+		if f.label == "" {
+			return nil, ErrTSInvalidFilter
+		}
+
+		hasMatcher = hasMatcher || (!f.negate && value != "")
+		filters = append(filters, f)
+	}
+
+	if !hasMatcher {
+		return nil, ErrTSNoMatcher
+	}
+
+	return filters, nil
+}
+
+func (ts *timeSeries) matches(filters []tsFilter) bool {
+	for _, f := range filters {
+		v, _ := ts.label(f.label)
+		if slices.Contains(f.values, v) == f.negate {
+			return false
+		}
+	}
+
+	return true
+}
+
+func tsMrange(args []*KvsValue, reverse bool) ([]byte, error) {
+	q, err := parseTSRangeQuery(args, true)
+	if err != nil {
+		return nil, err
+	}
+
+	matched := make(map[string]*timeSeries)
+	for key, val := range kvs.storage.All() {
+		if val.dtype == TimeSeriesDtype && val.object.(*timeSeries).matches(q.filters) {
+			matched[key] = val.object.(*timeSeries)
+		}
+	}
+	keys := slices.Sorted(maps.Keys(matched))
+
+	res := make([][]byte, len(keys))
+	for i, key := range keys {
+		series := matched[key]
+
+		var labels [][]byte
+		if q.withLabels {
+			for _, l := range series.labels {
+				labels = append(labels, bulkStrArrayResponse([]byte(l.name), []byte(l.value)))
+			}
+		}
+
+		res[i] = arrayResponse(bulkStrResponse([]byte(key)), arrayResponse(labels...), tsSamplesResponse(q.run(series, reverse)))
+	}
+
+	return arrayResponse(res...), nil
+}
+
+// TS.MRANGE fromTimestamp toTimestamp [COUNT count] [AGGREGATION aggregator bucketDuration] [WITHLABELS] FILTER filterExpr...
+func tsMrangeHandler(args []*KvsValue) ([]byte, error) {
+	return tsMrange(args, false)
+}
+
+// TS.MREVRANGE fromTimestamp toTimestamp [COUNT count] [AGGREGATION aggregator bucketDuration] [WITHLABELS] FILTER filterExpr...
+func tsMrevrangeHandler(args []*KvsValue) ([]byte, error) {
+	return tsMrange(args, true)
+}
+
+// TS.CREATERULE sourceKey destKey AGGREGATION aggregator bucketDuration
+func tsCreateruleHandler(args []*KvsValue) ([]byte, error) {
+	srcKey, destKey := argToString(args[0]), argToString(args[1])
+
+	if strings.ToUpper(argToString(args[2])) != "AGGREGATION" {
+		return nil, ErrSyntax
+	}
+
+	aggregation, bucket, err := parseTSAggregation(args[3], args[4])
+	if err != nil {
+		return nil, err
+	}
+
+	if srcKey == destKey {
+		return nil, ErrTSSameKey
+	}
+
+	src, err := lookupExistingTimeSeries(srcKey)
+	if err != nil {
+		return nil, err
+	}
+
+	dest, err := lookupExistingTimeSeries(destKey)
+	if err != nil {
+		return nil, err
+	}
+
+	// a destination with its own rules could close a cycle of rules
+	if dest.srcKey != "" {
+		return nil, ErrTSDestHasSrc
+	}
+	if len(dest.rules) > 0 {
+		return nil, ErrTSDestHasRules
+	}
+
+	dest.srcKey = srcKey
+	src.rules = append(src.rules, &tsRule{destKey: destKey, aggregation: aggregation, bucket: bucket})
+	signalModifiedKey(srcKey, notifyModule, "ts.createrule:src")
+	signalModifiedKey(destKey, notifyModule, "ts.createrule:dest")
+
+	return []byte(OkResponse), nil
+}
+
+// TS.DELETERULE sourceKey destKey
+func tsDeleteruleHandler(args []*KvsValue) ([]byte, error) {
+	srcKey, destKey := argToString(args[0]), argToString(args[1])
+
+	src, err := lookupExistingTimeSeries(srcKey)
+	if err != nil {
+		return nil, err
+	}
+
+	i := slices.IndexFunc(src.rules, func(r *tsRule) bool { return r.destKey == destKey })
+	if i < 0 {
+		return nil, ErrTSRuleNotExist
+	}
+	src.rules = slices.Delete(src.rules, i, i+1)
+
+	signalModifiedKey(srcKey, notifyModule, "ts.deleterule:src")
+
+	if dest, err := lookupTimeSeries(destKey); err == nil && dest != nil && dest.srcKey == srcKey {
+		dest.srcKey = ""
+		signalModifiedKey(destKey, notifyModule, "ts.deleterule:dest")
+	}
+
+	return []byte(OkResponse), nil
+}
@@ -0,0 +1,151 @@
+package main
+
+import (
+	"errors"
+	"math"
+	"math/rand/v2"
+	"testing"
+)
+
+func TestTSChunkRoundTrip(t *testing.T) {
+	var samples []tsSample
+
+	ts := int64(1700000000000)
+	for i := range 1000 {
+		switch i % 4 {
+		case 0:
+			ts += 1000
+		case 1:
+			ts += 1000 + rand.Int64N(100)
+		case 2:
+			ts += rand.Int64N(1 << 40)
+		default:
+			ts++
+		}
+
+		values := []float64{float64(i), 21.5, rand.Float64() * 1e6, -0.0, math.Inf(1), math.SmallestNonzeroFloat64}
+		samples = append(samples, tsSample{ts: ts, value: values[i%len(values)]})
+	}
+
+	res := encodeTSChunk(samples).samples()
+
+	if len(res) != len(samples) {
+		t.Fatalf("decoded %d samples, expected: %d", len(res), len(samples))
+	}
+
+	for i := range samples {
+		if res[i].ts != samples[i].ts || math.Float64bits(res[i].value) != math.Float64bits(samples[i].value) {
+			t.Errorf("sample %d = %v, expected: %v", i, res[i], samples[i])
+		}
+	}
+}
+
+func TestTSChunkCompression(t *testing.T) {
+	c := &tsChunk{}
+	for i := range 1000 {
+		c.append(tsSample{ts: int64(i) * 1000, value: 42})
+	}
+
+	// regular timestamps and a constant value take 2 bits per sample
+	if len(c.w.data) > 300 {
+		t.Errorf("chunk of 1000 regular samples takes %d bytes", len(c.w.data))
+	}
+}
+
+func TestTimeSeriesOutOfOrder(t *testing.T) {
+	series := newTimeSeries(0, tsPolicyLast, nil)
+
+	for _, ts := range []int64{10, 30, 20, 5, 30} {
+		series.add(tsSample{ts: ts, value: float64(ts)}, series.duplicatePolicy)
+	}
+
+	res := series.rangeSamples(0, math.MaxInt64)
+	expected := []int64{5, 10, 20, 30}
+
+	if len(res) != len(expected) {
+		t.Fatalf("rangeSamples() returned %v, expected timestamps: %v", res, expected)
+	}
+
+	for i, s := range res {
+		if s.ts != expected[i] {
+			t.Errorf("rangeSamples()[%d].ts = %v, expected: %v", i, s.ts, expected[i])
+		}
+	}
+}
+
+func TestTimeSeriesDuplicatePolicy(t *testing.T) {
+	cases := []struct {
+		policy   string
+		expected float64
+	}{
+		{tsPolicyFirst, 1},
+		{tsPolicyLast, 2},
+		{tsPolicyMin, 1},
+		{tsPolicyMax, 2},
+		{tsPolicySum, 3},
+	}
+
+	for _, c := range cases {
+		series := newTimeSeries(0, c.policy, nil)
+		series.add(tsSample{ts: 1, value: 1}, c.policy)
+		series.add(tsSample{ts: 1, value: 2}, c.policy)
+
+		if res := series.lastSample().value; res != c.expected {
+			t.Errorf("value with %v policy = %v, expected: %v", c.policy, res, c.expected)
+		}
+	}
+
+	series := newTimeSeries(0, tsPolicyBlock, nil)
+	series.add(tsSample{ts: 1, value: 1}, tsPolicyBlock)
+	if _, err := series.add(tsSample{ts: 1, value: 2}, tsPolicyBlock); !errors.Is(err, ErrTSDuplicateBlocked) {
+		t.Errorf("add() of duplicate with BLOCK policy error = %v, expected: %v", err, ErrTSDuplicateBlocked)
+	}
+}
+
+func TestTimeSeriesRetention(t *testing.T) {
+	series := newTimeSeries(100, tsPolicyBlock, nil)
+
+	for ts := int64(0); ts <= 1000; ts += 10 {
+		series.add(tsSample{ts: ts}, tsPolicyBlock)
+	}
+
+	res := series.rangeSamples(0, math.MaxInt64)
+	if len(res) != 11 || res[0].ts != 900 {
+		t.Errorf("rangeSamples() after retention returned %d samples starting at %v, expected 11 starting at 900", len(res), res[0].ts)
+	}
+
+	if _, err := series.add(tsSample{ts: 800}, tsPolicyBlock); !errors.Is(err, ErrTSTooOld) {
+		t.Errorf("add() of sample older than retention error = %v, expected: %v", err, ErrTSTooOld)
+	}
+}
+
+func TestAggregateSamples(t *testing.T) {
+	samples := []tsSample{{1, 1}, {5, 3}, {12, 10}, {19, 2}, {35, 7}}
+
+	cases := []struct {
+		aggregation string
+		expected    []float64
+	}{
+		{"avg", []float64{2, 6, 7}},
+		{"sum", []float64{4, 12, 7}},
+		{"min", []float64{1, 2, 7}},
+		{"max", []float64{3, 10, 7}},
+		{"count", []float64{2, 2, 1}},
+		{"last", []float64{3, 2, 7}},
+	}
+
+	for _, c := range cases {
+		res := aggregateSamples(samples, c.aggregation, 10)
+
+		if len(res) != len(c.expected) {
+			t.Errorf("aggregateSamples(%v) = %v, expected values: %v", c.aggregation, res, c.expected)
+			continue
+		}
+
+		for i, s := range res {
+			if s.value != c.expected[i] || s.ts != []int64{0, 10, 30}[i] {
+				t.Errorf("aggregateSamples(%v)[%d] = %v, expected value: %v", c.aggregation, i, s, c.expected[i])
+			}
+		}
+	}
+}
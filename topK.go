@@ -0,0 +1,193 @@
+package main
+
+import (
+	"cmp"
+	"math"
+	"math/rand/v2"
+	"slices"
+	"strings"
+)
+
+// Top-K uses HeavyKeeper: a Count-Min like table where every bucket remembers a fingerprint of the item
+// it counts. Colliding items decay the counter with probability decay^count, so heavy hitters keep their
+// buckets while rare items fade out. A small min-heap keeps the k items with the largest counts
+const (
+	topKDefaultWidth = 8
+	topKDefaultDepth = 7
+	topKDefaultDecay = 0.9
+
+	topKHashSeed = 0x2545f4914f6cdd1d
+)
+
+type topKBucket struct {
+	fp    uint64
+	count uint64
+}
+
+type topKItem struct {
+	item  string
+	count uint64
+}
+
+type topK struct {
+	k       int
+	width   uint64
+	depth   uint64
+	decay   float64
+	buckets []topKBucket
+	heap    []topKItem // min-heap by count
+}
+
+func newTopK(k int, width, depth uint64, decay float64) *topK {
+	return &topK{
+		k:       k,
+		width:   width,
+		depth:   depth,
+		decay:   decay,
+		buckets: make([]topKBucket, width*depth),
+		heap:    make([]topKItem, 0, k),
+	}
+}
+
+// incr counts item in HeavyKeeper table and returns its estimated count
+func (t *topK) incr(item []byte) uint64 {
+	fp := murmurHash64A(item, topKHashSeed)
+	var res uint64
+
+	for row := range t.depth {
+		b := &t.buckets[row*t.width+murmurHash64A(item, row)%t.width]
+
+		switch {
+		case b.count == 0:
+			b.fp, b.count = fp, 1
+		case b.fp == fp:
+			b.count++
+		case rand.Float64() < math.Pow(t.decay, float64(b.count)):
+			b.count--
+			if b.count == 0 {
+				b.fp, b.count = fp, 1
+			}
+		}
+
+		if b.fp == fp {
+			res = max(res, b.count)
+		}
+	}
+
+	return res
+}
+
+func (t *topK) heapIndex(item string) int {
+	return slices.IndexFunc(t.heap, func(it topKItem) bool { return it.item == item })
+}
+
+func (t *topK) heapUp(i int) {
+	for i > 0 {
+		parent := (i - 1) / 2
+		if t.heap[parent].count <= t.heap[i].count {
+			return
+		}
+		t.heap[parent], t.heap[i] = t.heap[i], t.heap[parent]
+		i = parent
+	}
+}
+
+func (t *topK) heapDown(i int) {
+	for {
+		smallest := i
+		for _, child := range []int{2*i + 1, 2*i + 2} {
+			if child < len(t.heap) && t.heap[child].count < t.heap[smallest].count {
+				smallest = child
+			}
+		}
+
+		if smallest == i {
+			return
+		}
+		t.heap[smallest], t.heap[i] = t.heap[i], t.heap[smallest]
+		i = smallest
+	}
+}
+
+// add counts item and returns an item expelled from the top list because of it, if any
+func (t *topK) add(item []byte) (string, bool) {
+	count := t.incr(item)
+	key := string(item)
+
+	if i := t.heapIndex(key); i >= 0 {
+		// counts only grow for items in the list, so the item can only move down the heap
+		t.heap[i].count = max(t.heap[i].count, count)
+		t.heapDown(i)
+		return "", false
+	}
+
+	if len(t.heap) < t.k {
+		t.heap = append(t.heap, topKItem{item: key, count: count})
+		t.heapUp(len(t.heap) - 1)
+		return "", false
+	}
+
+	if count <= t.heap[0].count {
+		return "", false
+	}
+
+	expelled := t.heap[0].item
+	t.heap[0] = topKItem{item: key, count: count}
+	t.heapDown(0)
+
+	return expelled, true
+}
+
+func (t *topK) query(item string) bool {
+	return t.heapIndex(item) >= 0
+}
+
+// list returns items of the top list from the most to the least frequent
+func (t *topK) list() []topKItem {
+	res := slices.Clone(t.heap)
+	slices.SortFunc(res, func(a, b topKItem) int {
+		return cmp.Or(cmp.Compare(b.count, a.count), strings.Compare(a.item, b.item))
+	})
+
+	return res
+}
+
+func (t *topK) dump(b []byte) []byte {
+	b = appendU32(b, t.k)
+	b = appendU64(b, t.width)
+	b = appendU64(b, t.depth)
+	b = appendF64(b, t.decay)
+	for _, bucket := range t.buckets {
+		b = appendU64(b, bucket.fp)
+		b = appendU64(b, bucket.count)
+	}
+
+	b = appendU32(b, len(t.heap))
+	for _, it := range t.heap {
+		b = appendStr(b, it.item)
+		b = appendU64(b, it.count)
+	}
+
+	return b
+}
+
+func restoreTopK(r *dumpReader) *topK {
+	k, width, depth, decay := int(r.u32()), r.u64(), r.u64(), r.f64()
+	r.check(k > 0 && width > 0 && depth > 0 && width*depth/depth == width && width*depth <= uint64(len(r.data))/16)
+	if r.failed {
+		return nil
+	}
+
+	t := newTopK(k, width, depth, decay)
+	for i := range t.buckets {
+		t.buckets[i] = topKBucket{fp: r.u64(), count: r.u64()}
+	}
+
+	n := r.count(12)
+	r.check(n <= k)
+	for range n {
+		t.heap = append(t.heap, topKItem{item: r.str(), count: r.u64()})
+	}
+
+	return t
+}
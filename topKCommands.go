@@ -0,0 +1,119 @@
+package main
+
+import (
+	"strings"
+)
+
+// lookupTopK returns Top-K list stored at key. Lists are created only by TOPK.RESERVE
+func lookupTopK(key string) (*topK, error) {
+	val, ok := lookupKey(key)
+	if !ok {
+		return nil, ErrTopKKeyNotExist
+	}
+
+	if val.dtype != TopKDtype {
+		return nil, ErrWrongType
+	}
+
+	return val.object.(*topK), nil
+}
+
+// TOPK.RESERVE key topk [width depth decay]
+func topkReserveHandler(args []*KvsValue) ([]byte, error) {
+	if len(args) != 2 && len(args) != 5 {
+		return nil, wrongArgsCountErr("TOPK.RESERVE")
+	}
+
+	key := argToString(args[0])
+
+	k, err := argToInt64(args[1])
+	if err != nil || k < 1 {
+		return nil, ErrTopKInvalidK
+	}
+
+	width, depth, decay := int64(topKDefaultWidth), int64(topKDefaultDepth), topKDefaultDecay
+	if len(args) == 5 {
+		if width, err = argToInt64(args[2]); err != nil || width < 1 {
+			return nil, ErrTopKInvalidWidth
+		}
+		if depth, err = argToInt64(args[3]); err != nil || depth < 1 {
+			return nil, ErrTopKInvalidDepth
+		}
+		if decay, err = argToFloat64(args[4]); err != nil || decay <= 0 || decay > 1 {
+			return nil, ErrTopKInvalidDecay
+		}
+	}
+
+	if _, ok := kvs.storage.Get(key); ok {
+		return nil, ErrTopKKeyExists
+	}
+
+	storeKey(key, &KvsValue{dtype: TopKDtype, object: newTopK(int(k), uint64(width), uint64(depth), decay)})
+	signalModifiedKey(key, notifyModule, "topk.reserve")
+
+	return []byte(OkResponse), nil
+}
+
+// TOPK.ADD key item [item ...]
+func topkAddHandler(args []*KvsValue) ([]byte, error) {
+	key := argToString(args[0])
+
+	t, err := lookupTopK(key)
+	if err != nil {
+		return nil, err
+	}
+
+	res := make([][]byte, len(args)-1)
+	for i, arg := range args[1:] {
+		if expelled, ok := t.add([]byte(argToString(arg))); ok {
+			res[i] = []byte(expelled)
+		}
+	}
+	signalModifiedKey(key, notifyModule, "topk.add")
+
+	return bulkStrArrayResponse(res...), nil
+}
+
+// TOPK.QUERY key item [item ...]
+func topkQueryHandler(args []*KvsValue) ([]byte, error) {
+	t, err := lookupTopK(argToString(args[0]))
+	if err != nil {
+		return nil, err
+	}
+
+	res := make([][]byte, len(args)-1)
+	for i, arg := range args[1:] {
+		res[i] = boolIntResponse(t.query(argToString(arg)))
+	}
+
+	return arrayResponse(res...), nil
+}
+
+// TOPK.LIST key [WITHCOUNT]
+func topkListHandler(args []*KvsValue) ([]byte, error) {
+	withCount := false
+	switch {
+	case len(args) > 2:
+		return nil, wrongArgsCountErr("TOPK.LIST")
+	case len(args) == 2:
+		if strings.ToUpper(argToString(args[1])) != "WITHCOUNT" {
+			return nil, ErrSyntax
+		}
+		withCount = true
+	}
+
+	t, err := lookupTopK(argToString(args[0]))
+	if err != nil {
+		return nil, err
+	}
+
+	var res [][]byte
+	for _, it := range t.list() {
+		res = append(res, bulkStrResponse([]byte(it.item)))
+		if withCount {
+			res = append(res, intResponse(int64(it.count)))
+		}
+	}
+
+	return arrayResponse(res...), nil
+}
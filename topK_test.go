@@ -0,0 +1,63 @@
+package main
+
+import (
+	"strconv"
+	"testing"
+)
+
+func TestTopKHeavyHitters(t *testing.T) {
+	tk := newTopK(3, 50, 5, 0.9)
+
+	for round := range 100 {
+		for _, item := range []string{"a", "b", "c"} {
+			tk.add([]byte(item))
+		}
+		// noise items are seen only once
+		tk.add([]byte("noise" + strconv.Itoa(round)))
+	}
+
+	list := tk.list()
+	if len(list) != 3 {
+		t.Fatalf("list() returned %d items, expected: 3", len(list))
+	}
+
+	for _, item := range []string{"a", "b", "c"} {
+		if !tk.query(item) {
+			t.Errorf("query(%q) = false, expected heavy hitter in the list", item)
+		}
+	}
+}
+
+func TestTopKExpelled(t *testing.T) {
+	tk := newTopK(1, 8, 7, 0.9)
+
+	tk.add([]byte("a"))
+	if _, ok := tk.add([]byte("a")); ok {
+		t.Errorf("add() of item already in the list expelled something")
+	}
+
+	tk.add([]byte("b"))
+	tk.add([]byte("b"))
+	expelled, ok := tk.add([]byte("b"))
+	if !ok || expelled != "a" {
+		t.Errorf("add() = %q, %v, expected: \"a\", true", expelled, ok)
+	}
+}
+
+func TestTopKListOrder(t *testing.T) {
+	tk := newTopK(3, 64, 5, 0.9)
+
+	for item, count := range map[string]int{"x": 1, "y": 5, "z": 3} {
+		for range count {
+			tk.add([]byte(item))
+		}
+	}
+
+	list := tk.list()
+	expected := []string{"y", "z", "x"}
+	for i, it := range list {
+		if it.item != expected[i] {
+			t.Errorf("list()[%d] = %q, expected: %q", i, it.item, expected[i])
+		}
+	}
+}
@@ -0,0 +1,174 @@
+package main
+
+import (
+	"slices"
+	"strings"
+)
+
+// Client side caching. In default mode keys read by a tracking client are remembered, and the first
+// change of such key sends invalidation message to the client and forgets the key, so the client has
+// to read it again to hear about the next change. In BCAST mode clients hear about every change of keys
+// starting with their prefixes instead. RESP3 clients get invalidate push frames, RESP2 clients redirect
+// invalidations to a connection subscribed to __redis__:invalidate
+const trackingChannel = "__redis__:invalidate"
+
+// trackingState of a client is guarded by kvs.mu
+type trackingState struct {
+	on       bool
+	bcast    bool
+	optin    bool
+	optout   bool
+	noloop   bool
+	redirect int64
+	prefixes []string
+	// set with CLIENT CACHING yes|no for the next command only
+	caching    bool
+	cachingSet bool
+}
+
+// tracked keys and prefixes are guarded by kvs.mu. Keys map to client ids, so clients that have gone
+// or turned tracking off are just skipped
+var tracking = struct {
+	keys     map[string]map[int64]struct{}
+	prefixes map[string]map[*client]struct{}
+}{
+	keys:     make(map[string]map[int64]struct{}),
+	prefixes: make(map[string]map[*client]struct{}),
+}
+
+// enableTracking turns tracking on or adds prefixes if it's on already. Must be called with kvs.mu held
+func enableTracking(c *client, opts trackingState) error {
+	t := &c.tracking
+
+	if t.on && t.bcast != opts.bcast {
+		return ErrTrackingSwitchMode
+	}
+
+	// prefixes of a client must not overlap, otherwise a change would be sent twice
+	prefixes := append(t.prefixes[:len(t.prefixes):len(t.prefixes)], opts.prefixes...)
+	for i, p := range prefixes {
+		for j := range i {
+			q := prefixes[j]
+			if p != q && (strings.HasPrefix(p, q) || strings.HasPrefix(q, p)) {
+				return trackingPrefixOverlapErr(p, q)
+			}
+		}
+	}
+
+	if opts.bcast && len(opts.prefixes) == 0 && len(t.prefixes) == 0 {
+		opts.prefixes = []string{""}
+	}
+	for _, p := range opts.prefixes {
+		if tracking.prefixes[p] == nil {
+			tracking.prefixes[p] = make(map[*client]struct{})
+		}
+		tracking.prefixes[p][c] = struct{}{}
+		if !slices.Contains(t.prefixes, p) {
+			t.prefixes = append(t.prefixes, p)
+		}
+	}
+
+	t.on, t.bcast = true, opts.bcast
+	t.optin, t.optout, t.noloop, t.redirect = opts.optin, opts.optout, opts.noloop, opts.redirect
+	t.cachingSet = false
+
+	return nil
+}
+
+// disableTracking turns tracking off, keys read before are forgotten lazily. Must be called with kvs.mu held
+func disableTracking(c *client) {
+	for _, p := range c.tracking.prefixes {
+		delete(tracking.prefixes[p], c)
+		if len(tracking.prefixes[p]) == 0 {
+			delete(tracking.prefixes, p)
+		}
+	}
+
+	c.tracking = trackingState{}
+}
+
+// trackKey remembers that the running command's client has read key. Must be called with kvs.mu held
+func trackKey(key string) {
+	c := kvs.client
+	if c == nil || kvs.cmd == nil || kvs.cmd.write {
+		return
+	}
+
+	t := &c.tracking
+	if !t.on || t.bcast || t.optin && !(t.cachingSet && t.caching) || t.optout && t.cachingSet && !t.caching {
+		return
+	}
+
+	if tracking.keys[key] == nil {
+		tracking.keys[key] = make(map[int64]struct{})
+	}
+	tracking.keys[key][c.id] = struct{}{}
+}
+
+// trackingInvalidateKey notifies clients caching key about its change. Must be called with kvs.mu held
+func trackingInvalidateKey(key string) {
+	for id := range tracking.keys[key] {
+		c := clientByID(id)
+		if c == nil || !c.tracking.on || c.tracking.bcast || c.tracking.noloop && c == kvs.client {
+			continue
+		}
+		sendInvalidation(c, []byte(key))
+	}
+	delete(tracking.keys, key)
+
+	for p, clients := range tracking.prefixes {
+		if !strings.HasPrefix(key, p) {
+			continue
+		}
+		for c := range clients {
+			if !(c.tracking.noloop && c == kvs.client) {
+				sendInvalidation(c, []byte(key))
+			}
+		}
+	}
+}
+
+// trackingInvalidateAll tells all tracking clients to drop their caches after FLUSHALL. Must be called
+// with kvs.mu held
+func trackingInvalidateAll() {
+	for _, c := range allClients() {
+		if c.tracking.on {
+			sendInvalidation(c, nil)
+		}
+	}
+	clear(tracking.keys)
+}
+
+// sendInvalidation sends invalidation of key, or of everything if key is nil, to the client or to
+// the client it redirects to
+func sendInvalidation(c *client, key []byte) {
+	target := c
+	if c.tracking.redirect != 0 {
+		if target = clientByID(c.tracking.redirect); target == nil {
+			pubsub.mu.Lock()
+			defer pubsub.mu.Unlock()
+
+			if c.resp == 3 {
+				c.push(pushResponse(3, bulkStrResponse([]byte("tracking-redir-broken")), intResponse(c.tracking.redirect)))
+			}
+			return
+		}
+	}
+
+	keys := []byte(NullResponse)
+	if key != nil {
+		keys = arrayResponse(bulkStrResponse(key))
+	}
+
+	pubsub.mu.Lock()
+	defer pubsub.mu.Unlock()
+
+	if target.resp == 3 {
+		target.push(pushResponse(3, bulkStrResponse([]byte("invalidate")), keys))
+		return
+	}
+
+	if _, ok := target.subs[subChannel][trackingChannel]; ok {
+		target.push(pushResponse(2, bulkStrResponse([]byte("message")), bulkStrResponse([]byte(trackingChannel)), keys))
+	}
+}
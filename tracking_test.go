@@ -0,0 +1,144 @@
+package main
+
+import (
+	"strconv"
+	"testing"
+	"time"
+)
+
+func trackingClient(t *testing.T, resp int, tracking ...string) (*client, func(expected string)) {
+	t.Helper()
+
+	c, r := pipeClient(t)
+	c.resp = resp
+	t.Cleanup(func() { unregisterClient(c) })
+
+	if len(tracking) > 0 {
+		if res, err := c.clientCommand(bulkArgs(append([]string{"TRACKING"}, tracking...)...)); err != nil || string(res) != OkResponse {
+			t.Fatalf("CLIENT TRACKING %v: %q, %v", tracking, res, err)
+		}
+	}
+
+	return c, func(expected string) {
+		t.Helper()
+		readReply(t, r, expected)
+	}
+}
+
+func invalidateMessage(keys ...string) string {
+	if keys == nil {
+		return string(pushResponse(3, bulkStrResponse([]byte("invalidate")), []byte(NullResponse)))
+	}
+
+	res := make([][]byte, len(keys))
+	for i, key := range keys {
+		res[i] = []byte(key)
+	}
+
+	return string(pushResponse(3, bulkStrResponse([]byte("invalidate")), bulkStrArrayResponse(res...)))
+}
+
+func TestTrackingDefaultMode(t *testing.T) {
+	initStorage()
+	var tx txState
+
+	c, expect := trackingClient(t, 3, "ON")
+	c.dispatch("GET", bulkArgs("k"))
+	c.dispatch("HGET", bulkArgs("h", "f"))
+
+	dispatchTest(t, &tx, "SET", "k", "v")
+	expect(invalidateMessage("k"))
+
+	// the key isn't tracked until it's read again
+	dispatchTest(t, &tx, "SET", "k", "w")
+	dispatchTest(t, &tx, "HSET", "h", "f", "v")
+	expect(invalidateMessage("h"))
+
+	c.dispatch("GET", bulkArgs("k"))
+	c.dispatch("SET", bulkArgs("k", "x"))
+	expect(invalidateMessage("k"))
+
+	dispatchTest(t, &tx, "FLUSHALL")
+	expect(invalidateMessage())
+}
+
+func TestTrackingBcast(t *testing.T) {
+	initStorage()
+	var tx txState
+
+	c, expect := trackingClient(t, 3, "ON", "BCAST", "PREFIX", "user:", "PREFIX", "order:", "NOLOOP")
+	if _, err := c.clientCommand(bulkArgs("TRACKING", "ON", "BCAST", "PREFIX", "us")); err == nil {
+		t.Fatal("overlapping prefixes are accepted")
+	}
+
+	c.dispatch("SET", bulkArgs("user:1", "a"))
+	dispatchTest(t, &tx, "SET", "other", "b")
+	dispatchTest(t, &tx, "SET", "user:2", "c")
+	dispatchTest(t, &tx, "DELETE", "order:1")
+	dispatchTest(t, &tx, "SET", "order:1", "d")
+	expect(invalidateMessage("user:2"))
+	expect(invalidateMessage("order:1"))
+}
+
+func TestTrackingRedirectOptin(t *testing.T) {
+	initStorage()
+	var tx txState
+
+	target, expect := trackingClient(t, 2)
+	pubsub.subscribe(target, subChannel, []string{trackingChannel})
+	defer pubsub.unsubscribeAll(target)
+	expect("*3\r\n$9\r\nsubscribe\r\n$20\r\n__redis__:invalidate\r\n:1\r\n")
+
+	c, _ := trackingClient(t, 2, "ON", "REDIRECT", strconv.FormatInt(target.id, 10), "OPTIN")
+	c.dispatch("GET", bulkArgs("a"))
+	if _, err := c.clientCommand(bulkArgs("CACHING", "YES")); err != nil {
+		t.Fatal(err)
+	}
+	c.dispatch("GET", bulkArgs("b"))
+	c.dispatch("GET", bulkArgs("c"))
+
+	for _, key := range []string{"a", "b", "c"} {
+		dispatchTest(t, &tx, "SET", key, "v")
+	}
+	expect(string(pushResponse(2, bulkStrResponse([]byte("message")), bulkStrResponse([]byte(trackingChannel)),
+		bulkStrArrayResponse([]byte("b")))))
+
+	if res, _ := c.clientCommand(bulkArgs("GETREDIR")); string(res) != string(intResponse(target.id)) {
+		t.Errorf("GETREDIR: got %q", res)
+	}
+}
+
+// Key read by a blocked command after it wakes up is tracked for its client
+func TestTrackingAfterBlockedRead(t *testing.T) {
+	initStorage()
+	var tx txState
+
+	c, expect := trackingClient(t, 3, "ON")
+	dispatchTest(t, &tx, "XADD", "s", "1-1", "f", "v")
+
+	done := make(chan []byte)
+	go func() {
+		res, _ := c.dispatch("XREAD", bulkArgs("BLOCK", "0", "STREAMS", "s", "$"))
+		done <- res
+	}()
+	for blocked := false; !blocked; time.Sleep(time.Millisecond) {
+		kvs.mu.Lock()
+		blocked = len(kvs.keyWaiters["s"]) > 0
+		kvs.mu.Unlock()
+	}
+
+	dispatchTest(t, &tx, "XADD", "s", "2-1", "f", "v")
+	expect(invalidateMessage("s"))
+	if res := <-done; string(res) != "*1\r\n*2\r\n$1\r\ns\r\n*1\r\n*2\r\n$3\r\n2-1\r\n*2\r\n$1\r\nf\r\n$1\r\nv\r\n" {
+		t.Fatalf("XREAD: %q", res)
+	}
+
+	kvs.mu.Lock()
+	_, tracked := tracking.keys["s"][c.id]
+	kvs.mu.Unlock()
+	if !tracked {
+		t.Fatal("key read after waking up isn't tracked")
+	}
+	dispatchTest(t, &tx, "XADD", "s", "3-1", "f", "v")
+	expect(invalidateMessage("s"))
+}
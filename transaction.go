@@ -0,0 +1,212 @@
+package main
+
+import "time"
+
+// Every connection keeps its transaction state. Between MULTI and EXEC commands are only checked
+// for existence and arity and queued. EXEC runs the whole queue holding kvs.mu, so other clients
+// see either none or all of its writes. A command rejected while queuing makes EXEC fail with
+// EXECABORT, while runtime errors of queued commands are just returned among the replies.
+// WATCH remembers versions of keys, and EXEC replies with null without running anything if
+// any of them was modified since
+type queuedCommand struct {
+	cmd  *command
+	args []*KvsValue
+}
+
+type txState struct {
+	client  *client // nil for transactions run by tests
+	active  bool
+	aborted bool
+	queue   []queuedCommand
+	watched map[string]uint64 // key -> version at WATCH
+}
+
+const QueuedResponse = "+QUEUED" + CRLF
+
+// reset ends transaction, watched keys are kept
+func (tx *txState) reset() {
+	tx.active, tx.aborted, tx.queue = false, false, nil
+}
+
+// WATCH key [key ...]
+func (tx *txState) watch(args []*KvsValue) ([]byte, error) {
+	if len(args) == 0 {
+		return nil, wrongArgsCountErr(WatchCmd)
+	}
+	if tx.active {
+		return nil, ErrWatchInMulti
+	}
+
+	if err := lockKvs(); err != nil {
+		return nil, err
+	}
+	defer kvs.mu.Unlock()
+
+	expireDueKeys(nowMs())
+
+	if tx.watched == nil {
+		tx.watched = make(map[string]uint64)
+	}
+
+	for _, arg := range args {
+		key := argToString(arg)
+		if _, ok := tx.watched[key]; ok {
+			continue
+		}
+
+		tx.watched[key] = keyVersion(key)
+		kvs.watchers[key]++
+	}
+
+	return []byte(OkResponse), nil
+}
+
+// unwatchKeys must be called with kvs.mu held
+func (tx *txState) unwatchKeys() {
+	for key := range tx.watched {
+		kvs.watchers[key]--
+		if kvs.watchers[key] > 0 {
+			continue
+		}
+
+		delete(kvs.watchers, key)
+		if _, ok := kvs.storage.Get(key); !ok {
+			delete(kvs.versions, key)
+		}
+	}
+
+	tx.watched = nil
+}
+
+// UNWATCH
+func (tx *txState) unwatch(args []*KvsValue) ([]byte, error) {
+	if len(args) != 0 {
+		return nil, wrongArgsCountErr(UnwatchCmd)
+	}
+
+	tx.close()
+
+	return []byte(OkResponse), nil
+}
+
+// close forgets watched keys of connection
+func (tx *txState) close() {
+	if tx.watched == nil {
+		return
+	}
+
+	kvs.mu.Lock()
+	tx.unwatchKeys()
+	kvs.mu.Unlock()
+}
+
+// MULTI
+func (tx *txState) multi(args []*KvsValue) ([]byte, error) {
+	if len(args) != 0 {
+		return nil, wrongArgsCountErr(MultiCmd)
+	}
+	if tx.active {
+		return nil, ErrNestedMulti
+	}
+
+	tx.active = true
+
+	return []byte(OkResponse), nil
+}
+
+// DISCARD
+func (tx *txState) discard(args []*KvsValue) ([]byte, error) {
+	if len(args) != 0 {
+		return nil, wrongArgsCountErr(DiscardCmd)
+	}
+	if !tx.active {
+		return nil, ErrDiscardWithoutMulti
+	}
+
+	tx.reset()
+	tx.close()
+
+	return []byte(OkResponse), nil
+}
+
+// enqueue queues command or marks transaction aborted if the command is rejected
+func (tx *txState) enqueue(name string, args []*KvsValue) ([]byte, error) {
+	cmd, ok := lookupCommand(name)
+	if !ok {
+		tx.aborted = true
+		return nil, ErrCmdNotSupported
+	}
+
+	if err := checkArity(cmd, args); err != nil {
+		tx.aborted = true
+		return nil, err
+	}
+
+	tx.queue = append(tx.queue, queuedCommand{cmd: cmd, args: args})
+
+	return []byte(QueuedResponse), nil
+}
+
+// EXEC
+func (tx *txState) exec(args []*KvsValue) (res []byte, err error) {
+	if len(args) != 0 {
+		tx.aborted = true
+		return nil, wrongArgsCountErr(ExecCmd)
+	}
+	if !tx.active {
+		return nil, ErrExecWithoutMulti
+	}
+
+	queue, aborted := tx.queue, tx.aborted
+	tx.reset()
+
+	if err := lockKvs(); err != nil {
+		return nil, err
+	}
+	defer kvs.mu.Unlock()
+	defer tx.unwatchKeys()
+
+	if aborted {
+		return nil, ErrExecAbort
+	}
+
+	expireDueKeys(nowMs())
+	for key, version := range tx.watched {
+		if keyVersion(key) != version {
+			return []byte(NullResponse), nil
+		}
+	}
+
+	kvs.inExec, kvs.client = true, tx.client
+	commandTime.Store(time.Now().UnixMilli())
+	defer func() {
+		if aofErr := flushAOF(); aofErr != nil {
+			res, err = nil, aofErr
+		}
+		commandTime.Store(0)
+		kvs.inExec, kvs.cmd, kvs.client = false, nil, nil
+		if tx.client != nil {
+			tx.client.tracking.cachingSet = false
+		}
+	}()
+
+	// queued commands are one batch of the storage engine
+	replies := make([][]byte, len(queue))
+	engineErr := batch(func() {
+		for i, q := range queue {
+			expireDueKeys(nowMs())
+
+			kvs.cmd = q.cmd
+			res, err := call(q.cmd, q.args)
+			if err != nil {
+				res = []byte(err.Error())
+			}
+			replies[i] = res
+		}
+	})
+	if engineErr != nil {
+		return nil, storageEngineErr(engineErr.Error())
+	}
+
+	return arrayResponse(replies...), nil
+}
@@ -0,0 +1,144 @@
+package main
+
+import (
+	"bytes"
+	"strconv"
+	"sync"
+	"testing"
+)
+
+func bulkArgs(strs ...string) []*KvsValue {
+	args := make([]*KvsValue, len(strs))
+	for i, s := range strs {
+		args[i] = &KvsValue{dtype: BulkStrSymbol, value: []byte(s)}
+	}
+
+	return args
+}
+
+func dispatchTest(t *testing.T, tx *txState, cmd string, args ...string) string {
+	t.Helper()
+
+	res, err := dispatchCommand(tx, cmd, bulkArgs(args...))
+	if err != nil {
+		return err.Error()
+	}
+
+	return string(res)
+}
+
+func TestTransactionQueue(t *testing.T) {
+	initStorage()
+	var tx txState
+
+	for _, step := range []struct{ cmd, reply string }{
+		{"MULTI", OkResponse},
+		{"SET", QueuedResponse},
+		{"GET", QueuedResponse},
+		{"EXEC", "*2\r\n+OK\r\n$1\r\n1\r\n"},
+		{"EXEC", ErrExecWithoutMulti.Error()},
+	} {
+		var args []string
+		switch step.cmd {
+		case "SET":
+			args = []string{"k", "1"}
+		case "GET":
+			args = []string{"k"}
+		}
+
+		if reply := dispatchTest(t, &tx, step.cmd, args...); reply != step.reply {
+			t.Fatalf("%s replied %q, expected %q", step.cmd, reply, step.reply)
+		}
+	}
+
+	dispatchTest(t, &tx, "MULTI")
+	dispatchTest(t, &tx, "SET", "k", "2")
+	if reply := dispatchTest(t, &tx, "GET"); reply != wrongArgsCountErr("GET").Error() {
+		t.Fatalf("GET without key replied %q", reply)
+	}
+	if reply := dispatchTest(t, &tx, "EXEC"); reply != ErrExecAbort.Error() {
+		t.Fatalf("EXEC of aborted transaction replied %q", reply)
+	}
+	if k, _ := kvs.storage.Get("k"); string(k.value) != "1" {
+		t.Errorf("aborted transaction changed k to %s", k.value)
+	}
+}
+
+func TestTransactionAtomic(t *testing.T) {
+	initStorage()
+
+	var wg sync.WaitGroup
+	wg.Add(2)
+
+	go func() {
+		defer wg.Done()
+		var tx txState
+		for i := range 500 {
+			n := strconv.Itoa(i)
+			dispatchTest(t, &tx, "MULTI")
+			dispatchTest(t, &tx, "SET", "a", n)
+			dispatchTest(t, &tx, "SET", "b", n)
+			dispatchTest(t, &tx, "EXEC")
+		}
+	}()
+
+	go func() {
+		defer wg.Done()
+		var tx txState
+		for range 500 {
+			dispatchTest(t, &tx, "MULTI")
+			dispatchTest(t, &tx, "GET", "a")
+			dispatchTest(t, &tx, "GET", "b")
+			reply := []byte(dispatchTest(t, &tx, "EXEC"))
+
+			// both replies are equal, either null or the same bulk string
+			body := reply[bytes.IndexByte(reply, '\n')+1:]
+			if half := len(body) / 2; !bytes.Equal(body[:half], body[half:]) {
+				t.Errorf("transaction saw partial writes: %q", reply)
+				return
+			}
+		}
+	}()
+
+	wg.Wait()
+}
+
+func TestTransactionWatch(t *testing.T) {
+	initStorage()
+	var a, b txState
+
+	dispatchTest(t, &a, "SET", "k", "1")
+	dispatchTest(t, &a, "WATCH", "k", "missing")
+	dispatchTest(t, &b, "SET", "k", "2")
+	dispatchTest(t, &a, "MULTI")
+	dispatchTest(t, &a, "SET", "k", "3")
+	if reply := dispatchTest(t, &a, "EXEC"); reply != NullResponse {
+		t.Fatalf("EXEC after watched key changed replied %q", reply)
+	}
+	if k, _ := kvs.storage.Get("k"); string(k.value) != "2" {
+		t.Errorf("failed EXEC changed k to %s", k.value)
+	}
+
+	// a key created and deleted again is modified too
+	dispatchTest(t, &a, "WATCH", "missing")
+	dispatchTest(t, &b, "SET", "missing", "1")
+	dispatchTest(t, &b, "DELETE", "missing")
+	dispatchTest(t, &a, "MULTI")
+	if reply := dispatchTest(t, &a, "EXEC"); reply != NullResponse {
+		t.Fatalf("EXEC after watched key was created and deleted replied %q", reply)
+	}
+
+	dispatchTest(t, &a, "WATCH", "k")
+	dispatchTest(t, &a, "MULTI")
+	dispatchTest(t, &a, "SET", "k", "3")
+	if reply := dispatchTest(t, &a, "EXEC"); reply != "*1\r\n+OK\r\n" {
+		t.Fatalf("EXEC with unchanged watched key replied %q", reply)
+	}
+
+	if len(kvs.watchers) != 0 {
+		t.Errorf("keys are still watched after EXEC: %v", kvs.watchers)
+	}
+	if _, ok := kvs.versions["missing"]; ok {
+		t.Errorf("version of deleted unwatched key is kept")
+	}
+}
@@ -0,0 +1,406 @@
+package main
+
+import (
+	"errors"
+	"math"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// FILTER expressions of VSIM are evaluated against JSON attributes of elements, e.g.
+// .year >= 1980 and (.genre == "drama" || .rating > 8.5) and "en" in .langs
+// Supported are .field selectors, numbers, strings, true/false/null, [array] literals, arithmetic
+// (+ - * / % **), comparison, in, and/&&, or/||, not/!. An element with a missing field never matches
+var errVFUndefined = errors.New("undefined value")
+
+type vfExpr interface {
+	eval(attrs *jsonNode) (*jsonNode, error)
+}
+
+type vfLiteral struct{ val *jsonNode }
+type vfSelector struct{ field string }
+type vfArray struct{ elems []vfExpr }
+type vfUnary struct {
+	op string
+	x  vfExpr
+}
+type vfBinary struct {
+	op   string
+	l, r vfExpr
+}
+
+func vfBool(b bool) *jsonNode {
+	return &jsonNode{kind: jsonBool, b: b}
+}
+
+func vfNumber(f float64) *jsonNode {
+	return &jsonNode{kind: jsonFloat, f: f}
+}
+
+func vfTruthy(v *jsonNode) bool {
+	switch v.kind {
+	case jsonBool:
+		return v.b
+	case jsonInt, jsonFloat:
+		return v.number() != 0
+	case jsonString:
+		return v.str != ""
+	case jsonArray:
+		return len(v.arr) > 0
+	case jsonObject:
+		return true
+	}
+
+	return false
+}
+
+func (e vfLiteral) eval(*jsonNode) (*jsonNode, error) {
+	return e.val, nil
+}
+
+func (e vfSelector) eval(attrs *jsonNode) (*jsonNode, error) {
+	if attrs == nil || attrs.kind != jsonObject {
+		return nil, errVFUndefined
+	}
+
+	v, ok := attrs.obj.get(e.field)
+	if !ok {
+		return nil, errVFUndefined
+	}
+
+	return v, nil
+}
+
+func (e vfArray) eval(attrs *jsonNode) (*jsonNode, error) {
+	res := &jsonNode{kind: jsonArray, arr: make([]*jsonNode, len(e.elems))}
+	for i, el := range e.elems {
+		v, err := el.eval(attrs)
+		if err != nil {
+			return nil, err
+		}
+		res.arr[i] = v
+	}
+
+	return res, nil
+}
+
+func (e vfUnary) eval(attrs *jsonNode) (*jsonNode, error) {
+	v, err := e.x.eval(attrs)
+	if err != nil {
+		return nil, err
+	}
+
+	if e.op == "-" {
+		if !v.isNumber() {
+			return nil, errVFUndefined
+		}
+		return vfNumber(-v.number()), nil
+	}
+
+	return vfBool(!vfTruthy(v)), nil
+}
+
+func (e vfBinary) eval(attrs *jsonNode) (*jsonNode, error) {
+	l, err := e.l.eval(attrs)
+	if err != nil {
+		return nil, err
+	}
+
+	// logical operators short-circuit
+	switch e.op {
+	case "and":
+		if !vfTruthy(l) {
+			return vfBool(false), nil
+		}
+	case "or":
+		if vfTruthy(l) {
+			return vfBool(true), nil
+		}
+	}
+
+	r, err := e.r.eval(attrs)
+	if err != nil {
+		return nil, err
+	}
+
+	switch e.op {
+	case "and", "or":
+		return vfBool(vfTruthy(r)), nil
+	case "==":
+		return vfBool(jsonEqual(l, r)), nil
+	case "!=":
+		return vfBool(!jsonEqual(l, r)), nil
+	case "in":
+		switch {
+		case r.kind == jsonArray:
+			return vfBool(slices.ContainsFunc(r.arr, func(el *jsonNode) bool { return jsonEqual(el, l) })), nil
+		case r.kind == jsonString && l.kind == jsonString:
+			return vfBool(strings.Contains(r.str, l.str)), nil
+		}
+		return nil, errVFUndefined
+	case "<", "<=", ">", ">=":
+		var c int
+		switch {
+		case l.isNumber() && r.isNumber():
+			c = cmpFloat(l.number(), r.number())
+		case l.kind == jsonString && r.kind == jsonString:
+			c = strings.Compare(l.str, r.str)
+		default:
+			return nil, errVFUndefined
+		}
+
+		switch e.op {
+		case "<":
+			return vfBool(c < 0), nil
+		case "<=":
+			return vfBool(c <= 0), nil
+		case ">":
+			return vfBool(c > 0), nil
+		}
+		return vfBool(c >= 0), nil
+	}
+
+	if !l.isNumber() || !r.isNumber() {
+		return nil, errVFUndefined
+	}
+
+	a, b := l.number(), r.number()
+	switch e.op {
+	case "+":
+		return vfNumber(a + b), nil
+	case "-":
+		return vfNumber(a - b), nil
+	case "*":
+		return vfNumber(a * b), nil
+	case "/":
+		return vfNumber(a / b), nil
+	case "%":
+		return vfNumber(math.Mod(a, b)), nil
+	}
+
+	return vfNumber(math.Pow(a, b)), nil
+}
+
+// vfMatch reports whether attributes satisfy filter, errors of evaluation mean no match
+func vfMatch(expr vfExpr, attrs *jsonNode) bool {
+	v, err := expr.eval(attrs)
+	return err == nil && vfTruthy(v)
+}
+
+// ================================ parsing ========================================
+
+type vfParser struct {
+	data string
+	pos  int
+}
+
+// binary operators by precedence level, from the loosest one
+var vfBinaryOps = [][]string{
+	{"or", "||"},
+	{"and", "&&"},
+	{"==", "!=", "<=", ">=", "<", ">"},
+	{"in"},
+	{"+", "-"},
+	{"*", "/", "%"},
+	{"**"},
+}
+
+func parseVectorFilter(expr string) (vfExpr, error) {
+	p := &vfParser{data: expr}
+
+	res, err := p.parseBinary(0)
+	if err != nil {
+		return nil, err
+	}
+
+	p.skipSpaces()
+	if p.pos != len(p.data) {
+		return nil, ErrVSetInvalidFilter
+	}
+
+	return res, nil
+}
+
+func (p *vfParser) skipSpaces() {
+	for p.pos < len(p.data) && strings.IndexByte(" \t\r\n", p.data[p.pos]) >= 0 {
+		p.pos++
+	}
+}
+
+func isVFWordChar(c byte) bool {
+	return c == '_' || (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z') || (c >= '0' && c <= '9')
+}
+
+// consumeOp consumes operator if it's next in the input. Word operators must not be followed by word chars
+func (p *vfParser) consumeOp(op string) bool {
+	p.skipSpaces()
+
+	if !strings.HasPrefix(p.data[p.pos:], op) {
+		return false
+	}
+
+	end := p.pos + len(op)
+	if isVFWordChar(op[0]) && end < len(p.data) && isVFWordChar(p.data[end]) {
+		return false
+	}
+
+	// "*" must not take the first char of "**"
+	if op == "*" && strings.HasPrefix(p.data[end:], "*") {
+		return false
+	}
+
+	p.pos = end
+
+	return true
+}
+
+func (p *vfParser) parseBinary(level int) (vfExpr, error) {
+	if level == len(vfBinaryOps) {
+		return p.parseUnary()
+	}
+
+	left, err := p.parseBinary(level + 1)
+	if err != nil {
+		return nil, err
+	}
+
+	for {
+		op := ""
+		for _, candidate := range vfBinaryOps[level] {
+			if p.consumeOp(candidate) {
+				op = candidate
+				break
+			}
+		}
+
+		if op == "" {
+			return left, nil
+		}
+
+		right, err := p.parseBinary(level + 1)
+		if err != nil {
+			return nil, err
+		}
+
+		switch op {
+		case "||":
+			op = "or"
+		case "&&":
+			op = "and"
+		}
+
+		left = vfBinary{op: op, l: left, r: right}
+	}
+}
+
+func (p *vfParser) parseUnary() (vfExpr, error) {
+	for _, op := range []string{"not", "!", "-"} {
+		if p.consumeOp(op) {
+			x, err := p.parseUnary()
+			if err != nil {
+				return nil, err
+			}
+			if op == "-" {
+				return vfUnary{op: "-", x: x}, nil
+			}
+			return vfUnary{op: "!", x: x}, nil
+		}
+	}
+
+	return p.parsePrimary()
+}
+
+func (p *vfParser) parsePrimary() (vfExpr, error) {
+	p.skipSpaces()
+	if p.pos >= len(p.data) {
+		return nil, ErrVSetInvalidFilter
+	}
+
+	c := p.data[p.pos]
+	switch {
+	case c == '(':
+		p.pos++
+		expr, err := p.parseBinary(0)
+		if err != nil {
+			return nil, err
+		}
+		if !p.consumeOp(")") {
+			return nil, ErrVSetInvalidFilter
+		}
+		return expr, nil
+	case c == '[':
+		p.pos++
+		var arr vfArray
+		if p.consumeOp("]") {
+			return arr, nil
+		}
+		for {
+			el, err := p.parseBinary(0)
+			if err != nil {
+				return nil, err
+			}
+			arr.elems = append(arr.elems, el)
+
+			if p.consumeOp("]") {
+				return arr, nil
+			}
+			if !p.consumeOp(",") {
+				return nil, ErrVSetInvalidFilter
+			}
+		}
+	case c == '.':
+		p.pos++
+		start := p.pos
+		for p.pos < len(p.data) && isVFWordChar(p.data[p.pos]) {
+			p.pos++
+		}
+		if start == p.pos {
+			return nil, ErrVSetInvalidFilter
+		}
+		return vfSelector{field: p.data[start:p.pos]}, nil
+	case c == '"' || c == '\'':
+		return p.parseString(c)
+	case c >= '0' && c <= '9':
+		start := p.pos
+		for p.pos < len(p.data) && (isVFWordChar(p.data[p.pos]) || p.data[p.pos] == '.' ||
+			((p.data[p.pos] == '+' || p.data[p.pos] == '-') && (p.data[p.pos-1] == 'e' || p.data[p.pos-1] == 'E'))) {
+			p.pos++
+		}
+		f, err := strconv.ParseFloat(p.data[start:p.pos], 64)
+		if err != nil {
+			return nil, ErrVSetInvalidFilter
+		}
+		return vfLiteral{vfNumber(f)}, nil
+	}
+
+	for word, val := range map[string]*jsonNode{"true": vfBool(true), "false": vfBool(false), "null": {kind: jsonNull}} {
+		if p.consumeOp(word) {
+			return vfLiteral{val}, nil
+		}
+	}
+
+	return nil, ErrVSetInvalidFilter
+}
+
+func (p *vfParser) parseString(quote byte) (vfExpr, error) {
+	p.pos++
+
+	var sb strings.Builder
+	for p.pos < len(p.data) {
+		c := p.data[p.pos]
+		p.pos++
+
+		switch {
+		case c == quote:
+			return vfLiteral{&jsonNode{kind: jsonString, str: sb.String()}}, nil
+		case c == '\\' && p.pos < len(p.data):
+			sb.WriteByte(p.data[p.pos])
+			p.pos++
+		default:
+			sb.WriteByte(c)
+		}
+	}
+
+	return nil, ErrVSetInvalidFilter
+}
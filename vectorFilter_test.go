@@ -0,0 +1,56 @@
+package main
+
+import "testing"
+
+func TestVectorFilter(t *testing.T) {
+	attrs, err := parseJSON(`{"year":1984,"genre":"drama","rating":8.1,"langs":["en","fr"],"seen":false}`)
+	if err != nil {
+		t.Fatalf("parseJSON() returned error: %v", err)
+	}
+
+	for expr, expected := range map[string]bool{
+		`.year == 1984`:                                true,
+		`.year >= 1980 and .year < 1990`:               true,
+		`.genre == "drama" && .rating > 8.5`:           false,
+		`.genre == 'comedy' || .rating > 8`:            true,
+		`"en" in .langs`:                               true,
+		`"de" in .langs`:                               false,
+		`.genre in ["drama", "thriller"]`:              true,
+		`not .seen`:                                    true,
+		`!(.year > 2000)`:                              true,
+		`.year % 4 == 0`:                               true,
+		`.rating * 10 >= 81 and 2 ** 3 == 8`:           true,
+		`-.year < 0`:                                   true,
+		`.missing == 1`:                                false,
+		`.missing == 1 or .year == 1984`:               false,
+		`.year == 1984 or .missing == 1`:               true,
+		`.genre > 5`:                                   false,
+		`.year - 1 + 1 == 1984 and .seen == false`:     true,
+		`(.year == 1984 or .year == 1985) and .rating`: true,
+	} {
+		filter, err := parseVectorFilter(expr)
+		if err != nil {
+			t.Errorf("parseVectorFilter(%s) returned error: %v", expr, err)
+			continue
+		}
+
+		if res := vfMatch(filter, attrs); res != expected {
+			t.Errorf("vfMatch(%s) = %t, expected: %t", expr, res, expected)
+		}
+	}
+}
+
+func TestVectorFilterSyntaxErrors(t *testing.T) {
+	for _, expr := range []string{"", ".year ==", "(.a == 1", ".", "[1, 2", `"unterminated`, ".a == 1 )", "1 2", ".a === 1"} {
+		if _, err := parseVectorFilter(expr); err != ErrVSetInvalidFilter {
+			t.Errorf("parseVectorFilter(%q) returned %v, expected: %v", expr, err, ErrVSetInvalidFilter)
+		}
+	}
+}
+
+func TestVectorFilterNoAttributes(t *testing.T) {
+	filter, _ := parseVectorFilter(".year > 0")
+	if vfMatch(filter, nil) {
+		t.Errorf("vfMatch() without attributes = true, expected: false")
+	}
+}
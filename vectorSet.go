@@ -0,0 +1,555 @@
+package main
+
+import (
+	"container/heap"
+	"encoding/binary"
+	"math"
+	"math/rand/v2"
+	"slices"
+)
+
+// Vector set is an HNSW graph: every element lives on layers 0..level, where level is random with
+// exponentially decreasing probability. Upper layers are sparse and let search quickly get close to the
+// query, layer 0 contains all elements. Vectors can be stored as float32 or quantized to int8 with
+// a per-vector scale, which takes 4 times less memory at the cost of a bit of precision
+const (
+	vsetQuantNone = "noquant"
+	vsetQuantQ8   = "q8"
+
+	vsetMetricCosine = "cosine"
+	vsetMetricL2     = "l2"
+
+	vsetDefaultM        = 16
+	vsetMaxM            = 512
+	vsetDefaultEFBuild  = 200
+	vsetDefaultEFSearch = 100
+	vsetMaxLevel        = 16
+)
+
+type vsetNode struct {
+	element string
+	vec     []float32 // nil when quantized
+	q8      []int8
+	scale   float32 // q8[i]*scale restores i-th component
+	attrs   string
+	// attributes parsed once for FILTER expressions, nil if there are none or they are not an object
+	attrsJSON *jsonNode
+	links     [][]*vsetNode // neighbours on every layer the node belongs to
+	deleted   bool
+}
+
+type vectorSet struct {
+	dim     int
+	quant   string
+	metric  string
+	m       int
+	efBuild int
+
+	nodes    map[string]*vsetNode
+	entry    *vsetNode
+	maxLevel int
+}
+
+func newVectorSet(dim int, quant, metric string, m, efBuild int) *vectorSet {
+	return &vectorSet{
+		dim:     dim,
+		quant:   quant,
+		metric:  metric,
+		m:       m,
+		efBuild: efBuild,
+		nodes:   make(map[string]*vsetNode),
+	}
+}
+
+func (vs *vectorSet) card() int {
+	return len(vs.nodes)
+}
+
+// maxLinks returns how many neighbours a node can have on level, layer 0 is twice as dense
+func (vs *vectorSet) maxLinks(level int) int {
+	if level == 0 {
+		return vs.m * 2
+	}
+
+	return vs.m
+}
+
+// prepare returns vector in the form used for distances: vectors compared by cosine are normalized
+func (vs *vectorSet) prepare(vec []float32) []float32 {
+	res := slices.Clone(vec)
+	if vs.metric != vsetMetricCosine {
+		return res
+	}
+
+	var norm float64
+	for _, v := range res {
+		norm += float64(v) * float64(v)
+	}
+
+	if norm == 0 {
+		return res
+	}
+
+	norm = math.Sqrt(norm)
+	for i := range res {
+		res[i] = float32(float64(res[i]) / norm)
+	}
+
+	return res
+}
+
+func (vs *vectorSet) newNode(element string, vec []float32) *vsetNode {
+	n := &vsetNode{element: element}
+
+	if vs.quant != vsetQuantQ8 {
+		n.vec = vec
+		return n
+	}
+
+	var maxAbs float32
+	for _, v := range vec {
+		maxAbs = max(maxAbs, float32(math.Abs(float64(v))))
+	}
+
+	n.q8 = make([]int8, len(vec))
+	if maxAbs > 0 {
+		n.scale = maxAbs / 127
+		for i, v := range vec {
+			n.q8[i] = int8(math.Round(float64(v / n.scale)))
+		}
+	}
+
+	return n
+}
+
+// vector returns components of node vector, dequantized if needed
+func (n *vsetNode) vector() []float32 {
+	if n.vec != nil {
+		return n.vec
+	}
+
+	res := make([]float32, len(n.q8))
+	for i, q := range n.q8 {
+		res[i] = float32(q) * n.scale
+	}
+
+	return res
+}
+
+// distance between prepared query vector and node. For cosine it's 1 - cos in [0, 2], for L2 squared
+// euclidean distance, which keeps the order and is cheaper
+func (vs *vectorSet) distance(query []float32, n *vsetNode) float32 {
+	var res float32
+
+	switch {
+	case vs.metric == vsetMetricCosine && n.vec != nil:
+		for i, v := range n.vec {
+			res += query[i] * v
+		}
+		return 1 - res
+	case vs.metric == vsetMetricCosine:
+		for i, q := range n.q8 {
+			res += query[i] * float32(q)
+		}
+		return 1 - res*n.scale
+	case n.vec != nil:
+		for i, v := range n.vec {
+			d := query[i] - v
+			res += d * d
+		}
+	default:
+		for i, q := range n.q8 {
+			d := query[i] - float32(q)*n.scale
+			res += d * d
+		}
+	}
+
+	return res
+}
+
+// score converts distance to the value reported by VSIM: similarity in [0, 1] for cosine
+// and euclidean distance for L2
+func (vs *vectorSet) score(dist float32) float64 {
+	if vs.metric == vsetMetricCosine {
+		return 1 - float64(dist)/2
+	}
+
+	return math.Sqrt(float64(max(dist, 0)))
+}
+
+// ================================ candidate queues ========================================
+
+type vsetCandidate struct {
+	node *vsetNode
+	dist float32
+}
+
+// vsetQueue is a min-heap of candidates by distance, or max-heap when farthest is set
+type vsetQueue struct {
+	items    []vsetCandidate
+	farthest bool
+}
+
+func (q *vsetQueue) Len() int { return len(q.items) }
+
+func (q *vsetQueue) Less(i, j int) bool {
+	if q.farthest {
+		return q.items[i].dist > q.items[j].dist
+	}
+
+	return q.items[i].dist < q.items[j].dist
+}
+
+func (q *vsetQueue) Swap(i, j int) { q.items[i], q.items[j] = q.items[j], q.items[i] }
+
+func (q *vsetQueue) Push(x any) { q.items = append(q.items, x.(vsetCandidate)) }
+
+func (q *vsetQueue) Pop() any {
+	last := q.items[len(q.items)-1]
+	q.items = q.items[:len(q.items)-1]
+
+	return last
+}
+
+func sortCandidates(cands []vsetCandidate) {
+	slices.SortFunc(cands, func(a, b vsetCandidate) int {
+		switch {
+		case a.dist < b.dist:
+			return -1
+		case a.dist > b.dist:
+			return 1
+		}
+		return 0
+	})
+}
+
+// ================================ HNSW ========================================
+
+// searchLayer returns up to ef nodes of level closest to query, starting from entry points, nearest first
+func (vs *vectorSet) searchLayer(query []float32, entry []vsetCandidate, ef, level int) []vsetCandidate {
+	visited := make(map[*vsetNode]bool, ef*4)
+	cands := &vsetQueue{}
+	res := &vsetQueue{farthest: true}
+
+	for _, c := range entry {
+		visited[c.node] = true
+		heap.Push(cands, c)
+		heap.Push(res, c)
+	}
+
+	for res.Len() > ef {
+		heap.Pop(res)
+	}
+
+	for cands.Len() > 0 {
+		c := heap.Pop(cands).(vsetCandidate)
+		if res.Len() >= ef && c.dist > res.items[0].dist {
+			break
+		}
+
+		for _, n := range c.node.links[level] {
+			if visited[n] || n.deleted {
+				continue
+			}
+			visited[n] = true
+
+			d := vs.distance(query, n)
+			if res.Len() < ef || d < res.items[0].dist {
+				heap.Push(cands, vsetCandidate{node: n, dist: d})
+				heap.Push(res, vsetCandidate{node: n, dist: d})
+				if res.Len() > ef {
+					heap.Pop(res)
+				}
+			}
+		}
+	}
+
+	sortCandidates(res.items)
+
+	return res.items
+}
+
+// selectNeighbors picks up to n candidates (sorted nearest first) with the heuristic from HNSW paper:
+// a candidate is skipped when it's closer to an already selected neighbour than to the base node,
+// so links spread in different directions. Skipped candidates fill the remaining room
+func (vs *vectorSet) selectNeighbors(cands []vsetCandidate, n int) []*vsetNode {
+	res := make([]*vsetNode, 0, n)
+	var skipped []*vsetNode
+
+	for _, c := range cands {
+		if len(res) >= n {
+			break
+		}
+
+		query := vs.prepare(c.node.vector())
+		good := true
+		for _, r := range res {
+			if vs.distance(query, r) < c.dist {
+				good = false
+				break
+			}
+		}
+
+		if good {
+			res = append(res, c.node)
+		} else {
+			skipped = append(skipped, c.node)
+		}
+	}
+
+	for _, s := range skipped {
+		if len(res) >= n {
+			break
+		}
+		res = append(res, s)
+	}
+
+	return res
+}
+
+// relink chooses best neighbours of node on level among candidates
+func (vs *vectorSet) relink(node *vsetNode, level int, candidates []*vsetNode) {
+	query := vs.prepare(node.vector())
+
+	cands := make([]vsetCandidate, 0, len(candidates))
+	for _, c := range candidates {
+		if c != node && !c.deleted && !slices.ContainsFunc(cands, func(x vsetCandidate) bool { return x.node == c }) {
+			cands = append(cands, vsetCandidate{node: c, dist: vs.distance(query, c)})
+		}
+	}
+	sortCandidates(cands)
+
+	node.links[level] = vs.selectNeighbors(cands, vs.maxLinks(level))
+}
+
+func (vs *vectorSet) randomLevel() int {
+	ml := 1 / math.Log(float64(vs.m))
+	level := int(-math.Log(1-rand.Float64()) * ml)
+
+	return min(level, vsetMaxLevel)
+}
+
+// insert adds node to the graph. vec is the original vector, so the graph is built with full precision
+// even for quantized sets
+func (vs *vectorSet) insert(n *vsetNode, vec []float32) {
+	level := vs.randomLevel()
+	n.links = make([][]*vsetNode, level+1)
+	vs.nodes[n.element] = n
+
+	if vs.entry == nil {
+		vs.entry, vs.maxLevel = n, level
+		return
+	}
+
+	query := vs.prepare(vec)
+	entry := []vsetCandidate{{node: vs.entry, dist: vs.distance(query, vs.entry)}}
+
+	for l := vs.maxLevel; l > level; l-- {
+		entry = vs.searchLayer(query, entry, 1, l)
+	}
+
+	for l := min(level, vs.maxLevel); l >= 0; l-- {
+		cands := vs.searchLayer(query, entry, vs.efBuild, l)
+		n.links[l] = vs.selectNeighbors(cands, vs.maxLinks(l))
+
+		for _, nb := range n.links[l] {
+			nb.links[l] = append(nb.links[l], n)
+			if len(nb.links[l]) > vs.maxLinks(l) {
+				vs.relink(nb, l, nb.links[l])
+			}
+		}
+
+		entry = cands
+	}
+
+	if level > vs.maxLevel {
+		vs.entry, vs.maxLevel = n, level
+	}
+}
+
+// remove deletes element and reconnects its neighbours with each other, so the graph stays navigable
+func (vs *vectorSet) remove(element string) bool {
+	n, ok := vs.nodes[element]
+	if !ok {
+		return false
+	}
+
+	delete(vs.nodes, element)
+	// nodes that link to n without n linking back skip it during search
+	n.deleted = true
+
+	for l, nbs := range n.links {
+		for _, nb := range nbs {
+			if nb.deleted {
+				continue
+			}
+			vs.relink(nb, l, append(slices.Clone(nb.links[l]), nbs...))
+		}
+	}
+
+	if vs.entry == n {
+		vs.entry, vs.maxLevel = nil, 0
+		for _, node := range vs.nodes {
+			if vs.entry == nil || len(node.links)-1 > vs.maxLevel {
+				vs.entry, vs.maxLevel = node, len(node.links)-1
+			}
+		}
+	}
+
+	return true
+}
+
+// search returns up to count nearest elements matching filter. With filter the graph is explored wider,
+// up to filterEf candidates, since many near elements can be filtered out
+func (vs *vectorSet) search(vec []float32, count, ef int, filter func(n *vsetNode) bool, filterEf int) []vsetCandidate {
+	if vs.entry == nil || count <= 0 {
+		return nil
+	}
+
+	query := vs.prepare(vec)
+	entry := []vsetCandidate{{node: vs.entry, dist: vs.distance(query, vs.entry)}}
+
+	for l := vs.maxLevel; l > 0; l-- {
+		entry = vs.searchLayer(query, entry, 1, l)
+	}
+
+	ef = max(ef, count)
+	if filter != nil {
+		ef = max(ef, filterEf)
+	}
+
+	res := vs.searchLayer(query, entry, ef, 0)
+	if filter != nil {
+		res = slices.DeleteFunc(res, func(c vsetCandidate) bool { return !filter(c.node) })
+	}
+
+	return res[:min(count, len(res))]
+}
+
+// linearSearch compares query with every element, it's exact and used to check quality of the graph
+func (vs *vectorSet) linearSearch(vec []float32, count int, filter func(n *vsetNode) bool) []vsetCandidate {
+	query := vs.prepare(vec)
+
+	res := make([]vsetCandidate, 0, len(vs.nodes))
+	for _, n := range vs.nodes {
+		if filter == nil || filter(n) {
+			res = append(res, vsetCandidate{node: n, dist: vs.distance(query, n)})
+		}
+	}
+	sortCandidates(res)
+
+	return res[:min(count, len(res))]
+}
+
+// dump serializes elements with their vectors as they are stored, so quantized vectors don't lose
+// more precision, and links of every layer as element names
+func (vs *vectorSet) dump(b []byte) []byte {
+	b = appendU32(b, vs.dim)
+	b = appendStr(b, vs.quant)
+	b = appendStr(b, vs.metric)
+	b = appendU32(b, vs.m)
+	b = appendU32(b, vs.efBuild)
+	b = appendU32(b, vs.maxLevel)
+
+	entry := ""
+	if vs.entry != nil {
+		entry = vs.entry.element
+	}
+	b = appendStr(b, entry)
+
+	b = appendU32(b, len(vs.nodes))
+	for _, n := range vs.nodes {
+		b = appendStr(b, n.element)
+		b = appendStr(b, n.attrs)
+		if n.vec != nil {
+			for _, v := range n.vec {
+				b = binary.LittleEndian.AppendUint32(b, math.Float32bits(v))
+			}
+		} else {
+			b = binary.LittleEndian.AppendUint32(b, math.Float32bits(n.scale))
+			for _, q := range n.q8 {
+				b = append(b, byte(q))
+			}
+		}
+
+		b = appendU32(b, len(n.links))
+		for _, nbs := range n.links {
+			live := slices.DeleteFunc(slices.Clone(nbs), func(nb *vsetNode) bool { return nb.deleted })
+			b = appendU32(b, len(live))
+			for _, nb := range live {
+				b = appendStr(b, nb.element)
+			}
+		}
+	}
+
+	return b
+}
+
+func restoreVectorSet(r *dumpReader) *vectorSet {
+	vs := newVectorSet(int(r.u32()), r.str(), r.str(), int(r.u32()), int(r.u32()))
+	vs.maxLevel = int(r.u32())
+	entry := r.str()
+	r.check(vs.dim > 0 && (vs.quant == vsetQuantNone || vs.quant == vsetQuantQ8) &&
+		(vs.metric == vsetMetricCosine || vs.metric == vsetMetricL2) && vs.maxLevel <= vsetMaxLevel)
+
+	links := make(map[*vsetNode][][]string)
+	for range r.count(12) {
+		n := &vsetNode{element: r.str(), attrs: r.str()}
+		r.check(vs.dim <= len(r.data))
+		if r.failed {
+			return vs
+		}
+
+		if vs.quant == vsetQuantNone {
+			n.vec = make([]float32, vs.dim)
+			for i := range n.vec {
+				n.vec[i] = math.Float32frombits(r.u32())
+			}
+		} else {
+			n.scale = math.Float32frombits(r.u32())
+			n.q8 = make([]int8, vs.dim)
+			for i, q := range r.next(uint64(vs.dim)) {
+				n.q8[i] = int8(q)
+			}
+		}
+		if n.attrs != "" {
+			n.attrsJSON, _ = parseJSON(n.attrs)
+		}
+
+		levels := r.count(4)
+		r.check(levels > 0 && levels <= vsetMaxLevel+1)
+		n.links = make([][]*vsetNode, levels)
+		names := make([][]string, levels)
+		for l := range names {
+			names[l] = make([]string, r.count(4))
+			for i := range names[l] {
+				names[l][i] = r.str()
+			}
+		}
+
+		_, dup := vs.nodes[n.element]
+		r.check(!dup)
+		vs.nodes[n.element] = n
+		links[n] = names
+	}
+
+	for n, names := range links {
+		for l, level := range names {
+			for _, name := range level {
+				nb, ok := vs.nodes[name]
+				r.check(ok && len(nb.links) > l)
+				n.links[l] = append(n.links[l], nb)
+			}
+		}
+	}
+
+	if entry != "" {
+		var ok bool
+		vs.entry, ok = vs.nodes[entry]
+		r.check(ok && len(vs.entry.links)-1 == vs.maxLevel)
+	} else {
+		r.check(len(vs.nodes) == 0)
+	}
+
+	return vs
+}
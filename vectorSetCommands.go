@@ -0,0 +1,357 @@
+package main
+
+import (
+	"cmp"
+	"encoding/binary"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// lookupVectorSet returns vector set stored at key or nil if key doesn't exist
+func lookupVectorSet(key string) (*vectorSet, error) {
+	val, ok := lookupKey(key)
+	if !ok {
+		return nil, nil
+	}
+
+	if val.dtype != VectorSetDtype {
+		return nil, ErrWrongType
+	}
+
+	return val.object.(*vectorSet), nil
+}
+
+// parseVector parses FP32 <blob> or VALUES <num> <value> ... and returns vector with number of consumed args
+func parseVector(args []*KvsValue) ([]float32, int, error) {
+	if len(args) < 2 {
+		return nil, 0, ErrSyntax
+	}
+
+	switch strings.ToUpper(argToString(args[0])) {
+	case "FP32":
+		blob := []byte(argToString(args[1]))
+		if len(blob) == 0 || len(blob)%4 != 0 {
+			return nil, 0, ErrVSetInvalidVector
+		}
+
+		vec := make([]float32, len(blob)/4)
+		for i := range vec {
+			vec[i] = math.Float32frombits(binary.LittleEndian.Uint32(blob[i*4:]))
+		}
+
+		return vec, 2, nil
+	case "VALUES":
+		n, err := argToInt64(args[1])
+		if err != nil || n < 1 || n > int64(len(args)-2) {
+			return nil, 0, ErrVSetInvalidVector
+		}
+
+		vec := make([]float32, n)
+		for i := range vec {
+			v, err := argToFloat64(args[2+i])
+			if err != nil {
+				return nil, 0, ErrVSetInvalidVector
+			}
+			vec[i] = float32(v)
+		}
+
+		return vec, int(n) + 2, nil
+	}
+
+	return nil, 0, ErrSyntax
+}
+
+// VADD key (FP32 vector | VALUES num vector...) element [NOQUANT | Q8] [METRIC COSINE | L2] [EF build-ef]
+// [SETATTR attributes] [M numlinks] [CAS]
+func vaddHandler(args []*KvsValue) ([]byte, error) {
+	key := argToString(args[0])
+
+	vec, consumed, err := parseVector(args[1:])
+	if err != nil {
+		return nil, err
+	}
+
+	rest := args[1+consumed:]
+	if len(rest) == 0 {
+		return nil, ErrSyntax
+	}
+	element := argToString(rest[0])
+
+	quant, metric := "", ""
+	m, efBuild := int64(vsetDefaultM), int64(vsetDefaultEFBuild)
+	var attrs *string
+
+	for i := 1; i < len(rest); i++ {
+		opt := strings.ToUpper(argToString(rest[i]))
+
+		switch opt {
+		case "NOQUANT":
+			quant = vsetQuantNone
+			continue
+		case "Q8":
+			quant = vsetQuantQ8
+			continue
+		case "CAS":
+			// the graph is updated under the storage lock anyway, so there is nothing to do in background
+			continue
+		}
+
+		if i+1 >= len(rest) {
+			return nil, ErrSyntax
+		}
+		i++
+
+		switch opt {
+		case "METRIC":
+			metric = strings.ToLower(argToString(rest[i]))
+			if metric != vsetMetricCosine && metric != vsetMetricL2 {
+				return nil, ErrVSetInvalidMetric
+			}
+		case "EF":
+			if efBuild, err = argToInt64(rest[i]); err != nil || efBuild < 1 || efBuild > 1000000 {
+				return nil, ErrVSetInvalidEF
+			}
+		case "M":
+			if m, err = argToInt64(rest[i]); err != nil || m < 4 || m > vsetMaxM {
+				return nil, ErrVSetInvalidM
+			}
+		case "SETATTR":
+			s := argToString(rest[i])
+			attrs = &s
+		default:
+			return nil, ErrSyntax
+		}
+	}
+
+	var attrsJSON *jsonNode
+	if attrs != nil && *attrs != "" {
+		if attrsJSON, err = parseJSON(*attrs); err != nil {
+			return nil, ErrVSetInvalidAttrs
+		}
+	}
+
+	vs, err := lookupVectorSet(key)
+	if err != nil {
+		return nil, err
+	}
+
+	if vs == nil {
+		vs = newVectorSet(len(vec), cmp.Or(quant, vsetQuantNone), cmp.Or(metric, vsetMetricCosine), int(m), int(efBuild))
+		storeKey(key, &KvsValue{dtype: VectorSetDtype, object: vs})
+	}
+
+	if len(vec) != vs.dim {
+		return nil, vsetDimMismatchErr(len(vec), vs.dim)
+	}
+	if quant != "" && quant != vs.quant {
+		return nil, ErrVSetQuantMismatch
+	}
+	if metric != "" && metric != vs.metric {
+		return nil, ErrVSetMetricMismatch
+	}
+
+	// updated element is reinserted, since its place in the graph depends on the vector
+	old, exists := vs.nodes[element]
+	if exists {
+		vs.remove(element)
+	}
+
+	n := vs.newNode(element, vs.prepare(vec))
+	switch {
+	case attrs != nil:
+		n.attrs, n.attrsJSON = *attrs, attrsJSON
+	case exists:
+		n.attrs, n.attrsJSON = old.attrs, old.attrsJSON
+	}
+	vs.insert(n, vec)
+	signalModifiedKey(key, notifyModule, "vadd")
+
+	return boolIntResponse(!exists), nil
+}
+
+// VSIM key (ELE element | FP32 vector | VALUES num vector...) [WITHSCORES] [WITHATTRIBS] [COUNT num]
+// [EF search-ef] [FILTER expression] [FILTER-EF max-filtering-effort] [TRUTH]
+func vsimHandler(args []*KvsValue) ([]byte, error) {
+	vs, err := lookupVectorSet(argToString(args[0]))
+	if err != nil {
+		return nil, err
+	}
+
+	var vec []float32
+	var consumed int
+	var ele *string
+
+	if strings.ToUpper(argToString(args[1])) == "ELE" {
+		s := argToString(args[2])
+		ele, consumed = &s, 2
+	} else if vec, consumed, err = parseVector(args[1:]); err != nil {
+		return nil, err
+	}
+
+	withScores, withAttrs, truth := false, false, false
+	count, ef, filterEf := int64(10), int64(vsetDefaultEFSearch), int64(-1)
+	var filter vfExpr
+
+	rest := args[1+consumed:]
+	for i := 0; i < len(rest); i++ {
+		opt := strings.ToUpper(argToString(rest[i]))
+
+		switch opt {
+		case "WITHSCORES":
+			withScores = true
+			continue
+		case "WITHATTRIBS":
+			withAttrs = true
+			continue
+		case "TRUTH":
+			truth = true
+			continue
+		case "NOTHREAD":
+			continue
+		}
+
+		if i+1 >= len(rest) {
+			return nil, ErrSyntax
+		}
+		i++
+
+		switch opt {
+		case "COUNT":
+			if count, err = argToInt64(rest[i]); err != nil || count < 1 {
+				return nil, ErrVSetInvalidCount
+			}
+		case "EF":
+			if ef, err = argToInt64(rest[i]); err != nil || ef < 1 || ef > 1000000 {
+				return nil, ErrVSetInvalidEF
+			}
+		case "FILTER-EF":
+			if filterEf, err = argToInt64(rest[i]); err != nil || filterEf < 0 {
+				return nil, ErrVSetInvalidEF
+			}
+		case "FILTER":
+			if filter, err = parseVectorFilter(argToString(rest[i])); err != nil {
+				return nil, err
+			}
+		default:
+			return nil, ErrSyntax
+		}
+	}
+
+	if vs == nil {
+		return []byte(EmptyArrayResponse), nil
+	}
+
+	if ele != nil {
+		n, ok := vs.nodes[*ele]
+		if !ok {
+			return nil, ErrVSetElementNotFound
+		}
+		vec = n.vector()
+	}
+
+	if len(vec) != vs.dim {
+		return nil, vsetDimMismatchErr(len(vec), vs.dim)
+	}
+
+	var match func(n *vsetNode) bool
+	if filter != nil {
+		match = func(n *vsetNode) bool { return vfMatch(filter, n.attrsJSON) }
+		if filterEf < 0 {
+			filterEf = count * 100
+		}
+	}
+
+	var found []vsetCandidate
+	if truth {
+		found = vs.linearSearch(vec, int(count), match)
+	} else {
+		found = vs.search(vec, int(count), int(ef), match, int(filterEf))
+	}
+
+	res := make([][]byte, 0, len(found))
+	for _, c := range found {
+		res = append(res, bulkStrResponse([]byte(c.node.element)))
+
+		if withScores {
+			res = append(res, bulkStrResponse([]byte(strconv.FormatFloat(vs.score(c.dist), 'f', -1, 32))))
+		}
+
+		if withAttrs {
+			if c.node.attrs == "" {
+				res = append(res, []byte(NullResponse))
+			} else {
+				res = append(res, bulkStrResponse([]byte(c.node.attrs)))
+			}
+		}
+	}
+
+	return arrayResponse(res...), nil
+}
+
+// VREM key element
+func vremHandler(args []*KvsValue) ([]byte, error) {
+	key := argToString(args[0])
+
+	vs, err := lookupVectorSet(key)
+	if err != nil || vs == nil {
+		return intResponse(0), err
+	}
+
+	removed := vs.remove(argToString(args[1]))
+	if removed {
+		signalModifiedKey(key, notifyModule, "vrem")
+	}
+	if vs.card() == 0 {
+		removeKey(key)
+		signalModifiedKey(key, notifyGeneric, "del")
+	}
+
+	return boolIntResponse(removed), nil
+}
+
+// VCARD key
+func vcardHandler(args []*KvsValue) ([]byte, error) {
+	vs, err := lookupVectorSet(argToString(args[0]))
+	if err != nil || vs == nil {
+		return intResponse(0), err
+	}
+
+	return intResponse(int64(vs.card())), nil
+}
+
+// VDIM key
+func vdimHandler(args []*KvsValue) ([]byte, error) {
+	vs, err := lookupVectorSet(argToString(args[0]))
+	if err != nil {
+		return nil, err
+	}
+	if vs == nil {
+		return nil, ErrStreamNoSuchKey
+	}
+
+	return intResponse(int64(vs.dim)), nil
+}
+
+// VINFO key
+func vinfoHandler(args []*KvsValue) ([]byte, error) {
+	vs, err := lookupVectorSet(argToString(args[0]))
+	if err != nil || vs == nil {
+		return []byte(NullResponse), err
+	}
+
+	quant := "f32"
+	if vs.quant == vsetQuantQ8 {
+		quant = "int8"
+	}
+
+	return arrayResponse(
+		bulkStrResponse([]byte("quant-type")), bulkStrResponse([]byte(quant)),
+		bulkStrResponse([]byte("metric")), bulkStrResponse([]byte(vs.metric)),
+		bulkStrResponse([]byte("vector-dim")), intResponse(int64(vs.dim)),
+		bulkStrResponse([]byte("size")), intResponse(int64(vs.card())),
+		bulkStrResponse([]byte("max-level")), intResponse(int64(vs.maxLevel)),
+		bulkStrResponse([]byte("hnsw-m")), intResponse(int64(vs.m)),
+		bulkStrResponse([]byte("ef-construction")), intResponse(int64(vs.efBuild)),
+	), nil
+}
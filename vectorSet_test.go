@@ -0,0 +1,151 @@
+package main
+
+import (
+	"math/rand/v2"
+	"strconv"
+	"testing"
+)
+
+func randomVectorSet(metric, quant string, n, dim int) *vectorSet {
+	r := rand.New(rand.NewPCG(1, 2))
+	vs := newVectorSet(dim, quant, metric, vsetDefaultM, vsetDefaultEFBuild)
+
+	for i := range n {
+		vec := make([]float32, dim)
+		for j := range vec {
+			vec[j] = r.Float32()*2 - 1
+		}
+		vs.insert(vs.newNode("e"+strconv.Itoa(i), vs.prepare(vec)), vec)
+	}
+
+	return vs
+}
+
+// recall returns share of exact nearest neighbours found by the graph search
+func recall(vs *vectorSet, queries, count int) float64 {
+	r := rand.New(rand.NewPCG(3, 4))
+	found, total := 0, 0
+
+	for range queries {
+		vec := make([]float32, vs.dim)
+		for j := range vec {
+			vec[j] = r.Float32()*2 - 1
+		}
+
+		exact := make(map[string]bool)
+		for _, c := range vs.linearSearch(vec, count, nil) {
+			exact[c.node.element] = true
+		}
+		for _, c := range vs.search(vec, count, vsetDefaultEFSearch, nil, 0) {
+			if exact[c.node.element] {
+				found++
+			}
+		}
+		total += count
+	}
+
+	return float64(found) / float64(total)
+}
+
+func TestVectorSetRecall(t *testing.T) {
+	for _, tc := range []struct{ metric, quant string }{
+		{vsetMetricCosine, vsetQuantNone},
+		{vsetMetricL2, vsetQuantNone},
+		{vsetMetricCosine, vsetQuantQ8},
+	} {
+		vs := randomVectorSet(tc.metric, tc.quant, 1000, 16)
+
+		if r := recall(vs, 50, 10); r < 0.9 {
+			t.Errorf("%s/%s: recall = %f, expected at least 0.9", tc.metric, tc.quant, r)
+		}
+	}
+}
+
+func TestVectorSetRemove(t *testing.T) {
+	vs := randomVectorSet(vsetMetricL2, vsetQuantNone, 1000, 8)
+
+	for i := range 500 {
+		if !vs.remove("e" + strconv.Itoa(i*2)) {
+			t.Fatalf("remove(e%d) = false, expected true", i*2)
+		}
+	}
+
+	if vs.remove("e0") {
+		t.Errorf("remove() of removed element = true, expected false")
+	}
+	if vs.card() != 500 {
+		t.Fatalf("card() = %d, expected: 500", vs.card())
+	}
+
+	// every remaining element must be reachable and be the nearest to its own vector
+	for _, n := range vs.nodes {
+		res := vs.search(n.vector(), 1, vsetDefaultEFSearch, nil, 0)
+		if len(res) != 1 || res[0].node != n {
+			t.Fatalf("search() for vector of %s didn't find it", n.element)
+		}
+	}
+
+	if r := recall(vs, 50, 10); r < 0.9 {
+		t.Errorf("recall after removal = %f, expected at least 0.9", r)
+	}
+}
+
+func TestVectorSetScore(t *testing.T) {
+	vs := newVectorSet(2, vsetQuantNone, vsetMetricCosine, vsetDefaultM, vsetDefaultEFBuild)
+	for el, vec := range map[string][]float32{"same": {2, 0}, "orthogonal": {0, 1}, "opposite": {-1, 0}} {
+		vs.insert(vs.newNode(el, vs.prepare(vec)), vec)
+	}
+
+	res := vs.search([]float32{1, 0}, 3, vsetDefaultEFSearch, nil, 0)
+	expected := []struct {
+		element string
+		score   float64
+	}{{"same", 1}, {"orthogonal", 0.5}, {"opposite", 0}}
+
+	for i, e := range expected {
+		if res[i].node.element != e.element || vs.score(res[i].dist) != e.score {
+			t.Errorf("result %d = %s with score %f, expected: %s with score %f",
+				i, res[i].node.element, vs.score(res[i].dist), e.element, e.score)
+		}
+	}
+}
+
+func TestVectorSetQuantization(t *testing.T) {
+	vs := newVectorSet(4, vsetQuantQ8, vsetMetricL2, vsetDefaultM, vsetDefaultEFBuild)
+	vec := []float32{1, -0.5, 0.25, 0}
+	n := vs.newNode("a", vs.prepare(vec))
+
+	if n.vec != nil || len(n.q8) != 4 {
+		t.Fatalf("newNode() didn't quantize vector")
+	}
+
+	for i, v := range n.vector() {
+		if d := v - vec[i]; d > 0.01 || d < -0.01 {
+			t.Errorf("component %d = %f after quantization, expected: %f", i, v, vec[i])
+		}
+	}
+}
+
+func TestVectorSetFilteredSearch(t *testing.T) {
+	vs := randomVectorSet(vsetMetricCosine, vsetQuantNone, 500, 8)
+	for i := range 500 {
+		n := vs.nodes["e"+strconv.Itoa(i)]
+		n.attrsJSON, _ = parseJSON(`{"even":` + strconv.FormatBool(i%2 == 0) + `}`)
+	}
+
+	filter, err := parseVectorFilter(".even")
+	if err != nil {
+		t.Fatalf("parseVectorFilter() returned error: %v", err)
+	}
+	match := func(n *vsetNode) bool { return vfMatch(filter, n.attrsJSON) }
+
+	res := vs.search([]float32{1, 0, 0, 0, 0, 0, 0, 0}, 20, vsetDefaultEFSearch, match, 2000)
+	if len(res) != 20 {
+		t.Fatalf("search() returned %d elements, expected: 20", len(res))
+	}
+	for _, c := range res {
+		if !match(c.node) {
+			t.Errorf("search() returned %s not matching filter", c.node.element)
+		}
+	}
+}
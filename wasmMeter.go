@@ -0,0 +1,440 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"math"
+	"slices"
+)
+
+// Fuel of WASM functions is metered by rewriting the module before it's compiled. A mutable i64 global
+// exported as kvs_fuel is added, and every function body and every loop body starts with subtracting
+// number of instructions it contains from it and trapping once it's negative. Branches of if are
+// charged together, so fuel is an upper bound of instructions executed
+const wasmFuelExport = "kvs_fuel"
+
+var wasmHeader = []byte{0x00, 0x61, 0x73, 0x6d, 0x01, 0x00, 0x00, 0x00}
+
+// WASM section ids
+const (
+	wasmSectionCustom = 0
+	wasmSectionImport = 2
+	wasmSectionGlobal = 6
+	wasmSectionExport = 7
+	wasmSectionCode   = 10
+)
+
+// order of non-custom sections in a module, by id
+var wasmSectionOrder = map[byte]int{1: 1, 2: 2, 3: 3, 4: 4, 5: 5, 13: 6, 6: 7, 7: 8, 8: 9, 9: 10, 12: 11, 10: 12, 11: 13}
+
+var (
+	errWasmMalformed   = errors.New("malformed module")
+	errWasmUnsupported = errors.New("unsupported instruction")
+	errWasmGlobal      = errors.New("unknown global")
+)
+
+type wasmSection struct {
+	id   byte
+	body []byte
+}
+
+// wasmReader reads module encoding, the first error sticks and makes all reads return zeros
+type wasmReader struct {
+	b   []byte
+	pos int
+	err error
+}
+
+func (r *wasmReader) fail(err error) {
+	if r.err == nil {
+		r.err = err
+	}
+	r.pos = len(r.b)
+}
+
+func (r *wasmReader) byte() byte {
+	if r.pos >= len(r.b) {
+		r.fail(errWasmMalformed)
+		return 0
+	}
+	r.pos++
+
+	return r.b[r.pos-1]
+}
+
+func (r *wasmReader) bytes(n int) []byte {
+	if n < 0 || n > len(r.b)-r.pos {
+		r.fail(errWasmMalformed)
+		return nil
+	}
+	r.pos += n
+
+	return r.b[r.pos-n : r.pos]
+}
+
+func (r *wasmReader) u32() uint32 {
+	var res uint32
+	for shift := 0; shift < 35; shift += 7 {
+		b := r.byte()
+		res |= uint32(b&0x7f) << shift
+		if b&0x80 == 0 {
+			return res
+		}
+	}
+	r.fail(errWasmMalformed)
+
+	return 0
+}
+
+// skipLEB skips signed or unsigned LEB128 number of up to 64 bits
+func (r *wasmReader) skipLEB() {
+	for range 10 {
+		if r.byte()&0x80 == 0 {
+			return
+		}
+	}
+	r.fail(errWasmMalformed)
+}
+
+func (r *wasmReader) name() string {
+	return string(r.bytes(int(r.u32())))
+}
+
+func (r *wasmReader) skipLimits() {
+	flags := r.byte()
+	r.u32()
+	if flags&1 != 0 {
+		r.u32()
+	}
+}
+
+func (r *wasmReader) skipMemarg() {
+	if r.u32()&0x40 != 0 {
+		r.u32() // memory index
+	}
+	r.u32()
+}
+
+func appendULEB(b []byte, v uint64) []byte {
+	for v >= 0x80 {
+		b = append(b, byte(v)|0x80)
+		v >>= 7
+	}
+
+	return append(b, byte(v))
+}
+
+func appendSLEB(b []byte, v int64) []byte {
+	for {
+		c := byte(v & 0x7f)
+		v >>= 7
+		if v == 0 && c&0x40 == 0 || v == -1 && c&0x40 != 0 {
+			return append(b, c)
+		}
+		b = append(b, c|0x80)
+	}
+}
+
+func appendWasmName(b []byte, name string) []byte {
+	return append(appendULEB(b, uint64(len(name))), name...)
+}
+
+func parseWasmSections(code []byte) ([]wasmSection, error) {
+	if !bytes.HasPrefix(code, wasmHeader) {
+		return nil, errWasmMalformed
+	}
+
+	r := &wasmReader{b: code, pos: len(wasmHeader)}
+	var sections []wasmSection
+	for r.pos < len(r.b) {
+		id := r.byte()
+		body := r.bytes(int(r.u32()))
+		if r.err != nil {
+			return nil, r.err
+		}
+		if _, ok := wasmSectionOrder[id]; !ok && id != wasmSectionCustom {
+			return nil, errWasmMalformed
+		}
+		sections = append(sections, wasmSection{id, body})
+	}
+
+	return sections, nil
+}
+
+// wasmCustomSection returns contents of the first custom section called name
+func wasmCustomSection(sections []wasmSection, name string) ([]byte, bool) {
+	for _, s := range sections {
+		if s.id != wasmSectionCustom {
+			continue
+		}
+
+		r := &wasmReader{b: s.body}
+		if r.name() == name && r.err == nil {
+			return r.b[r.pos:], true
+		}
+	}
+
+	return nil, false
+}
+
+// meterWasm returns module with fuel metering added
+func meterWasm(code []byte) ([]byte, error) {
+	sections, err := parseWasmSections(code)
+	if err != nil {
+		return nil, err
+	}
+
+	// the fuel global goes after imported and defined globals, so indices of existing ones don't change
+	fuel := 0
+	for _, s := range sections {
+		switch s.id {
+		case wasmSectionImport:
+			n, err := wasmImportedGlobals(s.body)
+			if err != nil {
+				return nil, err
+			}
+			fuel += n
+		case wasmSectionGlobal:
+			r := &wasmReader{b: s.body}
+			fuel += int(r.u32())
+		}
+	}
+
+	global := appendSLEB([]byte{0x7e, 0x01, 0x42}, math.MaxInt64) // mut i64 = i64.const max
+	global = append(global, 0x0b)
+	export := appendULEB(appendWasmName(nil, wasmFuelExport), 0x03) // global export
+	export = appendULEB(export, uint64(fuel))
+
+	sections = wasmAppendToVec(sections, wasmSectionGlobal, global)
+	sections = wasmAppendToVec(sections, wasmSectionExport, export)
+
+	for i, s := range sections {
+		if s.id != wasmSectionCode {
+			continue
+		}
+
+		body, err := meterCode(s.body, uint64(fuel))
+		if err != nil {
+			return nil, err
+		}
+		sections[i].body = body
+	}
+
+	res := bytes.Clone(wasmHeader)
+	for _, s := range sections {
+		res = append(res, s.id)
+		res = appendULEB(res, uint64(len(s.body)))
+		res = append(res, s.body...)
+	}
+
+	return res, nil
+}
+
+func wasmImportedGlobals(body []byte) (int, error) {
+	r := &wasmReader{b: body}
+	n := 0
+	for count := r.u32(); count > 0 && r.err == nil; count-- {
+		r.name()
+		r.name()
+		switch r.byte() {
+		case 0x00: // function
+			r.u32()
+		case 0x01: // table
+			r.byte()
+			r.skipLimits()
+		case 0x02: // memory
+			r.skipLimits()
+		case 0x03: // global
+			r.byte()
+			r.byte()
+			n++
+		case 0x04: // tag
+			r.byte()
+			r.u32()
+		default:
+			r.fail(errWasmMalformed)
+		}
+	}
+
+	return n, r.err
+}
+
+// wasmAppendToVec appends entry to the vector that is the body of section id, the section is created if missing
+func wasmAppendToVec(sections []wasmSection, id byte, entry []byte) []wasmSection {
+	for i, s := range sections {
+		if s.id == id {
+			r := &wasmReader{b: s.body}
+			n := r.u32()
+			body := appendULEB(nil, uint64(n)+1)
+			body = append(body, s.body[r.pos:]...)
+			sections[i].body = append(body, entry...)
+			return sections
+		}
+	}
+
+	at := len(sections)
+	for i, s := range sections {
+		if s.id != wasmSectionCustom && wasmSectionOrder[s.id] > wasmSectionOrder[id] {
+			at = i
+			break
+		}
+	}
+
+	return slices.Insert(sections, at, wasmSection{id, append([]byte{0x01}, entry...)})
+}
+
+func meterCode(body []byte, fuel uint64) ([]byte, error) {
+	r := &wasmReader{b: body}
+	n := r.u32()
+	res := appendULEB(nil, uint64(n))
+
+	for range n {
+		f := r.bytes(int(r.u32()))
+		if r.err != nil {
+			return nil, r.err
+		}
+
+		metered, err := meterFunction(f, fuel)
+		if err != nil {
+			return nil, err
+		}
+		res = appendULEB(res, uint64(len(metered)))
+		res = append(res, metered...)
+	}
+
+	return res, r.err
+}
+
+// meterFunction adds charging of fuel to the function body and to bodies of its loops. Globals at the index
+// of the fuel global and above don't exist in the original module, so the function can't refill its fuel
+func meterFunction(body []byte, fuel uint64) ([]byte, error) {
+	r := &wasmReader{b: body}
+	for count := r.u32(); count > 0 && r.err == nil; count-- {
+		r.u32()
+		r.byte()
+	}
+	if r.err != nil {
+		return nil, r.err
+	}
+
+	// counts[0] is the function body, others are loops in order. blocks maps open blocks to counts
+	type chargePoint struct{ pos, count int }
+	points := []chargePoint{{r.pos, 0}}
+	counts := []int{0}
+	blocks := []int{0}
+
+	for len(blocks) > 0 {
+		counts[blocks[len(blocks)-1]]++
+
+		op := r.byte()
+		switch op {
+		case 0x02, 0x04: // block, if
+			r.skipLEB()
+			blocks = append(blocks, blocks[len(blocks)-1])
+		case 0x03: // loop
+			r.skipLEB()
+			points = append(points, chargePoint{r.pos, len(counts)})
+			blocks = append(blocks, len(counts))
+			counts = append(counts, 0)
+		case 0x0b: // end
+			blocks = blocks[:len(blocks)-1]
+		case 0x23, 0x24: // global.get, global.set
+			if idx := r.u32(); r.err == nil && uint64(idx) >= fuel {
+				return nil, errWasmGlobal
+			}
+		default:
+			skipWasmImmediates(r, op)
+		}
+
+		if r.err != nil {
+			return nil, r.err
+		}
+	}
+	if r.pos != len(r.b) {
+		return nil, errWasmMalformed
+	}
+
+	res := make([]byte, 0, len(body)+len(points)*24)
+	prev := 0
+	for _, p := range points {
+		res = append(res, body[prev:p.pos]...)
+		res = appendFuelCharge(res, fuel, counts[p.count])
+		prev = p.pos
+	}
+
+	return append(res, body[prev:]...), nil
+}
+
+// appendFuelCharge appends: fuel -= n; if fuel < 0 { unreachable }
+func appendFuelCharge(b []byte, fuel uint64, n int) []byte {
+	b = appendULEB(append(b, 0x23), fuel) // global.get
+	b = appendSLEB(append(b, 0x42), int64(n))
+	b = append(b, 0x7d)                   // i64.sub
+	b = appendULEB(append(b, 0x24), fuel) // global.set
+	b = appendULEB(append(b, 0x23), fuel)
+
+	return append(b, 0x42, 0x00, 0x53, 0x04, 0x40, 0x00, 0x0b) // i64.const 0, i64.lt_s, if, unreachable, end
+}
+
+// skipWasmImmediates skips immediate arguments of instruction op other than block, loop, if and end
+func skipWasmImmediates(r *wasmReader, op byte) {
+	switch {
+	case op <= 0x01, op == 0x05, op == 0x0f, op == 0x1a, op == 0x1b, op >= 0x45 && op <= 0xc4, op == 0xd1:
+	case op == 0x0c, op == 0x0d, op == 0x10, op == 0x12, op >= 0x20 && op <= 0x26, op == 0x3f, op == 0x40, op == 0xd2:
+		r.u32()
+	case op == 0x0e: // br_table
+		for count := r.u32(); count > 0 && r.err == nil; count-- {
+			r.u32()
+		}
+		r.u32()
+	case op == 0x11, op == 0x13: // call_indirect, return_call_indirect
+		r.u32()
+		r.u32()
+	case op == 0x1c: // select with types
+		r.bytes(int(r.u32()))
+	case op >= 0x28 && op <= 0x3e: // loads and stores
+		r.skipMemarg()
+	case op == 0x41, op == 0x42:
+		r.skipLEB()
+	case op == 0x43:
+		r.bytes(4)
+	case op == 0x44:
+		r.bytes(8)
+	case op == 0xd0: // ref.null
+		r.byte()
+	case op == 0xfc:
+		skipWasmMiscImmediates(r, r.u32())
+	case op == 0xfd:
+		skipWasmSIMDImmediates(r, r.u32())
+	default:
+		r.fail(errWasmUnsupported)
+	}
+}
+
+// skipWasmMiscImmediates handles saturating truncation, bulk memory and table instructions
+func skipWasmMiscImmediates(r *wasmReader, op uint32) {
+	switch {
+	case op <= 7:
+	case op == 8, op == 10, op == 12, op == 14:
+		r.u32()
+		r.u32()
+	case op <= 17:
+		r.u32()
+	default:
+		r.fail(errWasmUnsupported)
+	}
+}
+
+func skipWasmSIMDImmediates(r *wasmReader, op uint32) {
+	switch {
+	case op <= 11, op == 92, op == 93: // loads and stores
+		r.skipMemarg()
+	case op == 12, op == 13: // v128.const, i8x16.shuffle
+		r.bytes(16)
+	case op >= 21 && op <= 34: // lane access
+		r.byte()
+	case op >= 84 && op <= 91: // lane loads and stores
+		r.skipMemarg()
+		r.byte()
+	}
+}